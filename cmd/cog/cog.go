@@ -1,6 +1,8 @@
 package main
 
 import (
+	"os"
+
 	"github.com/replicate/cog/pkg/cli"
 	"github.com/replicate/cog/pkg/util/console"
 )
@@ -12,6 +14,6 @@ func main() {
 	}
 
 	if err = cmd.Execute(); err != nil {
-		console.Fatalf("%s", err)
+		os.Exit(cli.ReportError(err))
 	}
 }
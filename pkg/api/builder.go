@@ -0,0 +1,471 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/replicate/cog/pkg/client"
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/global"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// maxFinishedJobs is how many finished jobs are kept, with their logs, so clients can get their results
+const maxFinishedJobs = 200
+
+// Statuses of a build job
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCanceled  = "canceled"
+)
+
+// BuildRunner builds the image for a job, writing what the build prints to logs, and returns its metadata
+type BuildRunner func(ctx context.Context, options client.BuildOptions, logs io.Writer) (*client.ImageMetadata, error)
+
+// Job is a build submitted to `cog builder serve`
+type Job struct {
+	ID         string                `json:"id"`
+	User       string                `json:"user"`
+	Status     string                `json:"status"`
+	Options    client.BuildOptions   `json:"options"`
+	Image      *client.ImageMetadata `json:"image,omitempty"`
+	Error      string                `json:"error,omitempty"`
+	CreatedAt  time.Time             `json:"created_at"`
+	StartedAt  *time.Time            `json:"started_at,omitempty"`
+	FinishedAt *time.Time            `json:"finished_at,omitempty"`
+	// Position is how many jobs are ahead of this one in the queue, if it's queued
+	Position int `json:"position,omitempty"`
+
+	logs   *jobLogs
+	cancel context.CancelFunc
+}
+
+func (j *Job) finished() bool {
+	return j.Status == JobSucceeded || j.Status == JobFailed || j.Status == JobCanceled
+}
+
+// BuildQueue runs build jobs in the order they're submitted, with at most concurrency running at once, and at most
+// perUser for each user, so one user submitting lots of builds doesn't hold up everyone else
+type BuildQueue struct {
+	concurrency int
+	perUser     int
+	run         BuildRunner
+
+	mu            sync.Mutex
+	jobs          []*Job
+	nextID        int
+	running       int
+	runningByUser map[string]int
+}
+
+// NewBuildQueue returns a queue that builds images with run
+func NewBuildQueue(concurrency int, perUser int, run BuildRunner) *BuildQueue {
+	return &BuildQueue{
+		concurrency:   max(concurrency, 1),
+		perUser:       max(perUser, 1),
+		run:           run,
+		runningByUser: map[string]int{},
+	}
+}
+
+// Submit queues a build for user, and starts it if the limits allow
+func (q *BuildQueue) Submit(user string, options client.BuildOptions) Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.nextID++
+	job := &Job{
+		ID:        strconv.Itoa(q.nextID),
+		User:      user,
+		Status:    JobQueued,
+		Options:   options,
+		CreatedAt: time.Now(),
+		logs:      newJobLogs(),
+	}
+	q.jobs = append(q.jobs, job)
+	q.schedule()
+	return q.snapshot(job)
+}
+
+// Get returns the job with id
+func (q *BuildQueue) Get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job := q.find(id)
+	if job == nil {
+		return Job{}, false
+	}
+	return q.snapshot(job), true
+}
+
+// List returns the jobs, or only the ones user submitted if user isn't empty, newest first
+func (q *BuildQueue) List(user string) []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := []Job{}
+	for i := len(q.jobs) - 1; i >= 0; i-- {
+		if user == "" || q.jobs[i].User == user {
+			jobs = append(jobs, q.snapshot(q.jobs[i]))
+		}
+	}
+	return jobs
+}
+
+// Cancel cancels a job that's queued or running. It returns false if there's no job with id.
+func (q *BuildQueue) Cancel(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job := q.find(id)
+	if job == nil {
+		return Job{}, false
+	}
+	switch job.Status {
+	case JobQueued:
+		q.finish(job, JobCanceled, nil, "Canceled before it started")
+	case JobRunning:
+		// The build is stopped, and finishes as canceled when its runner returns
+		job.cancel()
+	}
+	return q.snapshot(job), true
+}
+
+// logs returns the logs of the job with id
+func (q *BuildQueue) logs(id string) *jobLogs {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if job := q.find(id); job != nil {
+		return job.logs
+	}
+	return nil
+}
+
+func (q *BuildQueue) find(id string) *Job {
+	for _, job := range q.jobs {
+		if job.ID == id {
+			return job
+		}
+	}
+	return nil
+}
+
+// snapshot returns a copy of job that can be read without the lock
+func (q *BuildQueue) snapshot(job *Job) Job {
+	snapshot := *job
+	if job.Status == JobQueued {
+		for _, other := range q.jobs {
+			if other == job {
+				break
+			}
+			if other.Status == JobQueued {
+				snapshot.Position++
+			}
+		}
+	}
+	return snapshot
+}
+
+// schedule starts the queued jobs that the limits allow, in the order they were submitted
+func (q *BuildQueue) schedule() {
+	for _, job := range q.jobs {
+		if q.running >= q.concurrency {
+			return
+		}
+		if job.Status != JobQueued || q.runningByUser[job.User] >= q.perUser {
+			continue
+		}
+		q.start(job)
+	}
+}
+
+func (q *BuildQueue) start(job *Job) {
+	ctx, cancel := context.WithCancel(context.Background())
+	now := time.Now()
+	job.Status = JobRunning
+	job.StartedAt = &now
+	job.cancel = cancel
+	q.running++
+	q.runningByUser[job.User]++
+	console.Infof("Starting build %s for %s", job.ID, job.User)
+
+	go func() {
+		defer cancel()
+		image, err := q.run(ctx, job.Options, job.logs)
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		q.running--
+		q.runningByUser[job.User]--
+		switch {
+		case ctx.Err() != nil:
+			q.finish(job, JobCanceled, nil, "Canceled while it was running")
+		case err != nil:
+			q.finish(job, JobFailed, nil, err.Error())
+		default:
+			q.finish(job, JobSucceeded, image, "")
+		}
+		q.schedule()
+	}()
+}
+
+func (q *BuildQueue) finish(job *Job, status string, image *client.ImageMetadata, message string) {
+	now := time.Now()
+	job.Status = status
+	job.Image = image
+	job.Error = message
+	job.FinishedAt = &now
+	job.logs.close()
+	console.Infof("Build %s for %s %s", job.ID, job.User, status)
+	q.prune()
+}
+
+// prune forgets the oldest finished jobs, so the logs of old builds don't use up memory
+func (q *BuildQueue) prune() {
+	finished := []*Job{}
+	for _, job := range q.jobs {
+		if job.finished() {
+			finished = append(finished, job)
+		}
+	}
+	if len(finished) <= maxFinishedJobs {
+		return
+	}
+	sort.SliceStable(finished, func(i, j int) bool { return finished[i].FinishedAt.Before(*finished[j].FinishedAt) })
+	forget := map[*Job]bool{}
+	for _, job := range finished[:len(finished)-maxFinishedJobs] {
+		forget[job] = true
+	}
+	jobs := []*Job{}
+	for _, job := range q.jobs {
+		if !forget[job] {
+			jobs = append(jobs, job)
+		}
+	}
+	q.jobs = jobs
+}
+
+// jobLogs is what a build has printed so far, which clients can follow as it's written
+type jobLogs struct {
+	mu      sync.Mutex
+	buf     []byte
+	closed  bool
+	changed chan struct{}
+}
+
+func newJobLogs() *jobLogs {
+	return &jobLogs{changed: make(chan struct{})}
+}
+
+func (l *jobLogs) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return 0, errors.New("The build has finished")
+	}
+	l.buf = append(l.buf, p...)
+	close(l.changed)
+	l.changed = make(chan struct{})
+	return len(p), nil
+}
+
+func (l *jobLogs) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.closed {
+		l.closed = true
+		close(l.changed)
+	}
+}
+
+// since returns what's been written after offset, whether the logs are finished, and a channel that's closed when
+// more is written
+func (l *jobLogs) since(offset int) ([]byte, bool, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf[min(offset, len(l.buf)):], l.closed, l.changed
+}
+
+// checkJob checks that a build only reads files on the host from the server's project root, so users can't build
+// other files on the host into images they can read. Users who passed a user's token, rather than the server's, also
+// can't use the host's secrets or SSH agent. It returns the project's config, and its directory.
+func (s *Server) checkJob(user requestUser, options *client.BuildOptions) (*config.Config, string, error) {
+	projectDir, err := inRoot(s.projectRoot, options.ProjectDir, "project_dir")
+	if err != nil {
+		return nil, "", err
+	}
+	options.ProjectDir = projectDir
+	// Included files are read when the config is loaded, so they're checked first
+	if err := s.checkIncludes(filepath.Join(projectDir, global.ConfigFilename), global.ConfigFilename, map[string]bool{}); err != nil {
+		return nil, "", err
+	}
+	for _, file := range []struct{ name, path string }{{"dockerfile", options.Dockerfile}, {"schema_file", options.SchemaFile}} {
+		if file.path == "" {
+			continue
+		}
+		if !filepath.IsAbs(file.path) {
+			file.path = filepath.Join(projectDir, file.path)
+		}
+		if _, err := inRoot(s.projectRoot, file.path, file.name); err != nil {
+			return nil, "", err
+		}
+	}
+	cfg, configDir, err := config.GetConfig(projectDir)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := inRoot(s.projectRoot, configDir, "The directory with "+global.ConfigFilename); err != nil {
+		return nil, "", err
+	}
+	if requirements := cfg.Build.PythonRequirements; requirements != "" {
+		if !filepath.IsAbs(requirements) {
+			requirements = filepath.Join(configDir, requirements)
+		}
+		if _, err := inRoot(s.projectRoot, requirements, "build.python_requirements"); err != nil {
+			return nil, "", err
+		}
+	}
+	if user.trusted {
+		return cfg, configDir, nil
+	}
+	if len(options.Secrets) > 0 {
+		return nil, "", errors.New("secrets can only be passed with the server's token, because they're read from the host")
+	}
+	if cfg.Build.SSH {
+		return nil, "", errors.New("build.ssh can only be used with the server's token, because it uses the host's SSH agent")
+	}
+	for _, name := range cfg.Build.BuildContextNames() {
+		context := cfg.Build.Contexts[name]
+		contextPath, err := context.AbsPath(configDir)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := inRoot(s.projectRoot, contextPath, "build.contexts."+name); err != nil {
+			return nil, "", err
+		}
+	}
+	return cfg, configDir, nil
+}
+
+// checkIncludes checks that path, and the files it includes, are in the project root, and that includes are relative
+// paths, before any of them are read by loading the config. name is what path is, for errors, and seen is the files
+// already checked.
+func (s *Server) checkIncludes(path string, name string, seen map[string]bool) error {
+	resolved, err := inRoot(s.projectRoot, path, name)
+	if err != nil {
+		return err
+	}
+	if seen[resolved] {
+		return nil
+	}
+	seen[resolved] = true
+	contents, err := os.ReadFile(resolved)
+	if err != nil {
+		return err
+	}
+	includes, err := config.IncludePaths(path, contents)
+	if err != nil {
+		return err
+	}
+	for _, include := range includes {
+		if filepath.IsAbs(include) {
+			return fmt.Errorf("include %s in %s must be a path relative to it", include, filepath.Base(path))
+		}
+		if err := s.checkIncludes(filepath.Join(filepath.Dir(path), include), "include", seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// imageNamespace returns what the names of the images user builds have to start with, so users can't replace each
+// other's images, or others the host uses. The server's token can build any image.
+func (s *Server) imageNamespace(user requestUser) string {
+	if user.trusted {
+		return ""
+	}
+	return s.imagePrefix + user.name + "/"
+}
+
+// inRoot returns p with symlinks resolved, or an error if it isn't in root
+func inRoot(root string, p string, name string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return "", fmt.Errorf("%s %s doesn't exist", name, p)
+	}
+	if resolved, err = filepath.Abs(resolved); err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s %s isn't in %s, which builds have to be in", name, p, root)
+	}
+	return resolved, nil
+}
+
+// CommandRunner returns a BuildRunner that builds images by running `cog build` with executable, so builds have
+// their own configuration and logs, and can run at the same time. They share the host's Docker daemon, so layers and
+// base images from earlier builds are reused.
+func CommandRunner(executable string) BuildRunner {
+	return func(ctx context.Context, options client.BuildOptions, logs io.Writer) (*client.ImageMetadata, error) {
+		cmd := exec.CommandContext(ctx, executable, buildArgs(options)...) //#nosec G204
+		cmd.Dir = options.ProjectDir
+		cmd.Env = append(os.Environ(), "COG_NO_UPDATE_CHECK=1")
+		cmd.Stdout = logs
+		cmd.Stderr = logs
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("cog build failed: %w", err)
+		}
+		return (&client.Client{}).Inspect(options.Image)
+	}
+}
+
+// buildArgs returns the arguments to cog build for options. Image has to be set, so the image can be inspected
+// afterwards.
+func buildArgs(options client.BuildOptions) []string {
+	args := []string{"build", "--tag", options.Image}
+	for _, secret := range options.Secrets {
+		args = append(args, "--secret", secret)
+	}
+	if options.NoCache {
+		args = append(args, "--no-cache")
+	}
+	if options.SeparateWeights {
+		args = append(args, "--separate-weights")
+	}
+	if options.UseCudaBaseImage != "" {
+		args = append(args, "--use-cuda-base-image", options.UseCudaBaseImage)
+	}
+	if options.UseCogBaseImage != nil {
+		args = append(args, "--use-cog-base-image="+strconv.FormatBool(*options.UseCogBaseImage))
+	}
+	if options.SchemaFile != "" {
+		args = append(args, "--openapi-schema", options.SchemaFile)
+	}
+	if options.Dockerfile != "" {
+		args = append(args, "--dockerfile", options.Dockerfile)
+	}
+	progressOutput := options.ProgressOutput
+	if progressOutput == "" {
+		progressOutput = "plain"
+	}
+	args = append(args, "--progress", progressOutput)
+	keys := make([]string, 0, len(options.Annotations))
+	for key := range options.Annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, "--annotation", key+"="+options.Annotations[key])
+	}
+	return args
+}
@@ -0,0 +1,243 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/client"
+)
+
+// fakeRunner is a BuildRunner whose builds run until they're released
+type fakeRunner struct {
+	mu      sync.Mutex
+	started []string
+	release map[string]chan error
+}
+
+func newFakeRunner() *fakeRunner {
+	return &fakeRunner{release: map[string]chan error{}}
+}
+
+func (f *fakeRunner) run(ctx context.Context, options client.BuildOptions, logs io.Writer) (*client.ImageMetadata, error) {
+	f.mu.Lock()
+	f.started = append(f.started, options.Image)
+	release := f.channel(options.Image)
+	f.mu.Unlock()
+	_, _ = fmt.Fprintf(logs, "building %s\n", options.Image)
+	select {
+	case err := <-release:
+		if err != nil {
+			return nil, err
+		}
+		return &client.ImageMetadata{Image: options.Image}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (f *fakeRunner) channel(image string) chan error {
+	if _, ok := f.release[image]; !ok {
+		f.release[image] = make(chan error, 1)
+	}
+	return f.release[image]
+}
+
+func (f *fakeRunner) finish(image string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.channel(image) <- err
+}
+
+func (f *fakeRunner) startedImages() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.started...)
+}
+
+func waitForStatus(t *testing.T, q *BuildQueue, id string, status string) Job {
+	t.Helper()
+	var job Job
+	require.Eventually(t, func() bool {
+		job, _ = q.Get(id)
+		return job.Status == status
+	}, 5*time.Second, time.Millisecond)
+	return job
+}
+
+func TestBuildQueueLimits(t *testing.T) {
+	f := newFakeRunner()
+	q := NewBuildQueue(2, 1, f.run)
+
+	a1 := q.Submit("alice", client.BuildOptions{Image: "a1"})
+	a2 := q.Submit("alice", client.BuildOptions{Image: "a2"})
+	b1 := q.Submit("bob", client.BuildOptions{Image: "b1"})
+	c1 := q.Submit("carol", client.BuildOptions{Image: "c1"})
+
+	// alice can only run one build at once, so bob's starts ahead of her second, and carol's waits for a slot
+	waitForStatus(t, q, a1.ID, JobRunning)
+	waitForStatus(t, q, b1.ID, JobRunning)
+	job, _ := q.Get(a2.ID)
+	require.Equal(t, JobQueued, job.Status)
+	job, _ = q.Get(c1.ID)
+	require.Equal(t, JobQueued, job.Status)
+	require.Equal(t, 1, job.Position)
+
+	f.finish("a1", nil)
+	job = waitForStatus(t, q, a1.ID, JobSucceeded)
+	require.Equal(t, "a1", job.Image.Image)
+	waitForStatus(t, q, a2.ID, JobRunning)
+
+	f.finish("b1", fmt.Errorf("cog build failed: exit status 1"))
+	job = waitForStatus(t, q, b1.ID, JobFailed)
+	require.Equal(t, "cog build failed: exit status 1", job.Error)
+	waitForStatus(t, q, c1.ID, JobRunning)
+
+	f.finish("a2", nil)
+	f.finish("c1", nil)
+	waitForStatus(t, q, c1.ID, JobSucceeded)
+	started := f.startedImages()
+	require.ElementsMatch(t, []string{"a1", "b1"}, started[:2])
+	require.Equal(t, []string{"a2", "c1"}, started[2:])
+
+	jobs := q.List("alice")
+	require.Len(t, jobs, 2)
+	require.Equal(t, a2.ID, jobs[0].ID)
+}
+
+func TestBuildQueueCancel(t *testing.T) {
+	f := newFakeRunner()
+	q := NewBuildQueue(1, 1, f.run)
+	running := q.Submit("alice", client.BuildOptions{Image: "running"})
+	queued := q.Submit("alice", client.BuildOptions{Image: "queued"})
+	waitForStatus(t, q, running.ID, JobRunning)
+
+	job, ok := q.Cancel(queued.ID)
+	require.True(t, ok)
+	require.Equal(t, JobCanceled, job.Status)
+
+	_, _ = q.Cancel(running.ID)
+	waitForStatus(t, q, running.ID, JobCanceled)
+	require.Equal(t, []string{"running"}, f.startedImages())
+
+	_, ok = q.Cancel("missing")
+	require.False(t, ok)
+}
+
+func TestBuilderServer(t *testing.T) {
+	f := newFakeRunner()
+	q := NewBuildQueue(1, 1, f.run)
+	root := t.TempDir()
+	projectDir := filepath.Join(root, "my-model")
+	require.NoError(t, os.MkdirAll(projectDir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(projectDir, "cog.yaml"), []byte("build:\n  python_version: \"3.12\"\n"), 0o644))
+	server := httptest.NewServer(NewBuilderServer(io.Discard, "admin-token", map[string]string{"alice-token": "alice", "bob-token": "bob"}, q, root, "").Handler())
+	defer server.Close()
+	submit := fmt.Sprintf(`{"project_dir":%q,"image":"alice/my-model"}`, projectDir)
+
+	// Projects that read files outside the root
+	outside := filepath.Join(t.TempDir(), "shared.yaml")
+	require.NoError(t, os.WriteFile(outside, []byte("build:\n  gpu: true\n"), 0o644))
+	escapingProjects := []string{}
+	for name, cogYAML := range map[string]string{
+		"absolute-include":      fmt.Sprintf("include: %s\n", outside),
+		"escaping-include":      fmt.Sprintf("include: %s\n", filepath.Join("..", "..", filepath.Base(filepath.Dir(outside)), "shared.yaml")),
+		"escaping-requirements": "build:\n  python_version: \"3.12\"\n  python_requirements: ../../requirements.txt\n",
+	} {
+		dir := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(dir, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "cog.yaml"), []byte(cogYAML), 0o644))
+		escapingProjects = append(escapingProjects, dir)
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(root), "requirements.txt"), []byte("torch\n"), 0o644))
+
+	request := func(method string, path string, token string, body string) *http.Response {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := request(http.MethodPost, "/jobs", "wrong", submit)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp.Body.Close()
+
+	// The builder only queues builds, so users can't get around the queue
+	resp = request(http.MethodPost, "/builds", "alice-token", submit)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp.Body.Close()
+
+	for _, body := range []string{
+		fmt.Sprintf(`{"project_dir":%q}`, t.TempDir()),
+		fmt.Sprintf(`{"project_dir":%q,"dockerfile":"/etc/passwd"}`, projectDir),
+		fmt.Sprintf(`{"project_dir":%q,"secrets":["id=key,src=/etc/shadow"]}`, projectDir),
+		// Images are named in the user's namespace
+		fmt.Sprintf(`{"project_dir":%q,"image":"my-model"}`, projectDir),
+		fmt.Sprintf(`{"project_dir":%q,"image":"bob/my-model"}`, projectDir),
+		fmt.Sprintf(`{"project_dir":%q}`, escapingProjects[0]),
+		fmt.Sprintf(`{"project_dir":%q}`, escapingProjects[1]),
+		fmt.Sprintf(`{"project_dir":%q}`, escapingProjects[2]),
+	} {
+		resp = request(http.MethodPost, "/jobs", "alice-token", body)
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode, body)
+		resp.Body.Close()
+	}
+
+	resp = request(http.MethodPost, "/jobs", "alice-token", submit)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	var job Job
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&job))
+	resp.Body.Close()
+	require.Equal(t, "alice", job.User)
+
+	// bob can't see or cancel alice's builds
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		resp = request(method, "/jobs/"+job.ID, "bob-token", "")
+		require.Equal(t, http.StatusNotFound, resp.StatusCode, method)
+		resp.Body.Close()
+	}
+	resp = request(http.MethodGet, "/jobs/"+job.ID+"/logs", "bob-token", "")
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp.Body.Close()
+	var jobs []Job
+	resp = request(http.MethodGet, "/jobs?user=alice", "bob-token", "")
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&jobs))
+	resp.Body.Close()
+	require.Empty(t, jobs)
+	resp = request(http.MethodGet, "/jobs", "admin-token", "")
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&jobs))
+	resp.Body.Close()
+	require.Len(t, jobs, 1)
+
+	logs := request(http.MethodGet, "/jobs/"+job.ID+"/logs", "alice-token", "")
+	require.Equal(t, http.StatusOK, logs.StatusCode)
+	waitForStatus(t, q, job.ID, JobRunning)
+	f.finish("alice/my-model", nil)
+	body, err := io.ReadAll(logs.Body)
+	require.NoError(t, err)
+	logs.Body.Close()
+	require.Equal(t, "building alice/my-model\n", string(body))
+
+	resp = request(http.MethodGet, "/jobs/"+job.ID, "admin-token", "")
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&job))
+	resp.Body.Close()
+	require.Equal(t, JobSucceeded, job.Status)
+
+	resp = request(http.MethodGet, "/jobs/missing", "admin-token", "")
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp.Body.Close()
+}
@@ -0,0 +1,386 @@
+// Package api serves build, predict and inspect over a REST API, for GUIs and platforms that drive cog on a build
+// host.
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/replicate/cog/pkg/client"
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+//go:embed openapi.json
+var openAPISchema []byte
+
+// Server handles requests to the API
+type Server struct {
+	logs  io.Writer
+	token string
+	// users maps the tokens of the users of `cog builder serve` to their names
+	users map[string]string
+	// queue runs build jobs, if the server is a build service started with `cog builder serve`
+	queue *BuildQueue
+	// projectRoot is the directory that the projects built by `cog builder serve` have to be in
+	projectRoot string
+	// imagePrefix is what the names of images users of `cog builder serve` build start with, before their name
+	imagePrefix string
+	// mu makes builds and predictions run one at a time. They use a lot of resources, and their logs are
+	// captured from the console, which is shared by the whole process.
+	mu sync.Mutex
+}
+
+type predictionRequest struct {
+	Image   string            `json:"image"`
+	Inputs  map[string]string `json:"inputs"`
+	GPUs    string            `json:"gpus,omitempty"`
+	Env     []string          `json:"env,omitempty"`
+	Timeout int               `json:"timeout,omitempty"`
+}
+
+type predictionResponse struct {
+	*client.PredictResponse
+	Logs string `json:"logs"`
+}
+
+type buildResponse struct {
+	Image *client.ImageMetadata `json:"image"`
+	Logs  string                `json:"logs"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+	Logs  string `json:"logs,omitempty"`
+}
+
+// NewServer returns a Server that writes logs to logs. Requests have to pass token as a bearer token, so if it's
+// empty, no requests are allowed.
+func NewServer(logs io.Writer, token string) *Server {
+	return &Server{logs: logs, token: token}
+}
+
+// NewBuilderServer returns a Server that only queues build jobs with queue, for `cog builder serve`, of projects in
+// projectRoot. users maps tokens to the names of the users they're for. Requests can pass one of them, or token,
+// which is trusted to say who the user is in an X-Cog-User header. Users' images are named imagePrefix, then their
+// name and a slash, like registry.example.com/models/alice/my-model.
+func NewBuilderServer(logs io.Writer, token string, users map[string]string, queue *BuildQueue, projectRoot string, imagePrefix string) *Server {
+	s := NewServer(logs, token)
+	s.users = users
+	s.queue = queue
+	s.projectRoot = projectRoot
+	s.imagePrefix = imagePrefix
+	return s
+}
+
+// Handler returns the HTTP handler for the API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /openapi.json", s.handleOpenAPISchema)
+	if s.queue != nil {
+		// Builds only go through the queue, so users can't get around its limits, or run models on the host
+		mux.HandleFunc("POST /jobs", s.handleSubmitJob)
+		mux.HandleFunc("GET /jobs", s.handleListJobs)
+		mux.HandleFunc("GET /jobs/{id}", s.handleGetJob)
+		mux.HandleFunc("DELETE /jobs/{id}", s.handleCancelJob)
+		mux.HandleFunc("GET /jobs/{id}/logs", s.handleJobLogs)
+		return s.authenticate(mux)
+	}
+	mux.HandleFunc("GET /images", s.handleListImages)
+	mux.HandleFunc("GET /images/{image...}", s.handleInspectImage)
+	mux.HandleFunc("POST /builds", s.handleBuild)
+	mux.HandleFunc("POST /predictions", s.handlePrediction)
+	return s.authenticate(mux)
+}
+
+// requestUser is who made a request, for the build queue's per-user limits
+type requestUser struct {
+	name string
+	// trusted is whether the request passed the server's token, rather than a user's, so it can see and cancel anyone's jobs
+	trusted bool
+}
+
+type requestUserKey struct{}
+
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Browsers send Origin with cross-site requests, and can send them with any token-less body, so a web page
+		// could otherwise build and run models on this host
+		if r.Header.Get("Origin") != "" {
+			writeJSON(w, http.StatusForbidden, errorResponse{Error: "Requests from web browsers aren't allowed"})
+			return
+		}
+		if r.Method == http.MethodPost {
+			if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+				writeJSON(w, http.StatusUnsupportedMediaType, errorResponse{Error: "Content-Type must be application/json"})
+				return
+			}
+		}
+		user, ok := s.user(r)
+		if !ok {
+			writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "Invalid or missing token"})
+			return
+		}
+		console.Debugf("%s %s", r.Method, r.URL.Path)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestUserKey{}, user)))
+	})
+}
+
+// user returns who made a request, and false if it didn't pass a valid token
+func (s *Server) user(r *http.Request) (requestUser, bool) {
+	token, hasToken := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	for userToken, name := range s.users {
+		if hasToken && subtle.ConstantTimeCompare([]byte(token), []byte(userToken)) == 1 {
+			return requestUser{name: name}, true
+		}
+	}
+	if s.token == "" || !hasToken || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		return requestUser{}, false
+	}
+	name := r.Header.Get("X-Cog-User")
+	if name == "" {
+		name = "default"
+	}
+	return requestUser{name: name, trusted: true}, true
+}
+
+func (s *Server) handleOpenAPISchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(openAPISchema)
+}
+
+func (s *Server) handleListImages(w http.ResponseWriter, r *http.Request) {
+	images, err := (&client.Client{}).ListImages()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, images)
+}
+
+func (s *Server) handleInspectImage(w http.ResponseWriter, r *http.Request) {
+	metadata, err := (&client.Client{}).Inspect(r.PathValue("image"))
+	if errors.Is(err, docker.ErrNoSuchImage) {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, metadata)
+}
+
+func (s *Server) handleBuild(w http.ResponseWriter, r *http.Request) {
+	var options client.BuildOptions
+	if err := json.NewDecoder(r.Body).Decode(&options); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("Invalid request: %s", err)})
+		return
+	}
+	if options.ProjectDir == "" {
+		// The server's working directory isn't something callers should depend on
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "project_dir is required"})
+		return
+	}
+
+	var metadata *client.ImageMetadata
+	logs, err := s.withLogs(func(c *client.Client) (err error) {
+		metadata, err = c.Build(options)
+		return err
+	})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error(), Logs: logs})
+		return
+	}
+	writeJSON(w, http.StatusOK, buildResponse{Image: metadata, Logs: logs})
+}
+
+func (s *Server) handlePrediction(w http.ResponseWriter, r *http.Request) {
+	var req predictionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("Invalid request: %s", err)})
+		return
+	}
+	if req.Image == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "image is required"})
+		return
+	}
+	for name, value := range req.Inputs {
+		// @ reads files in the CLI, which would let callers read any file on the host
+		if strings.HasPrefix(value, "@") {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("Input %s can't be a file on the host. Pass files as URLs or data URLs.", name)})
+			return
+		}
+	}
+
+	var response *client.PredictResponse
+	logs, err := s.withLogs(func(c *client.Client) (err error) {
+		response, err = c.Predict(client.PredictRequest{
+			Image:   req.Image,
+			Inputs:  req.Inputs,
+			GPUs:    req.GPUs,
+			Env:     req.Env,
+			Timeout: time.Duration(req.Timeout) * time.Second,
+		})
+		return err
+	})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error(), Logs: logs})
+		return
+	}
+	writeJSON(w, http.StatusOK, predictionResponse{PredictResponse: response, Logs: logs})
+}
+
+func (s *Server) handleSubmitJob(w http.ResponseWriter, r *http.Request) {
+	var options client.BuildOptions
+	if err := json.NewDecoder(r.Body).Decode(&options); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("Invalid request: %s", err)})
+		return
+	}
+	if options.ProjectDir == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "project_dir is required"})
+		return
+	}
+	user := userFromContext(r.Context())
+	cfg, projectDir, err := s.checkJob(user, &options)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	namespace := s.imageNamespace(user)
+	if options.Image == "" {
+		// The image is named now, rather than by the build, so the job can say what it's building
+		options.Image = cfg.Image
+		if options.Image == "" || !strings.HasPrefix(options.Image, namespace) {
+			options.Image = namespace + config.DockerImageName(projectDir)
+		}
+	}
+	if !strings.HasPrefix(options.Image, namespace) {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("image must start with %s, so builds don't replace other images", namespace)})
+		return
+	}
+	writeJSON(w, http.StatusAccepted, s.queue.Submit(user.name, options))
+}
+
+func (s *Server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	user := r.URL.Query().Get("user")
+	if u := userFromContext(r.Context()); !u.trusted {
+		user = u.name
+	}
+	writeJSON(w, http.StatusOK, s.queue.List(user))
+}
+
+func (s *Server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	if job, ok := s.userJob(w, r); ok {
+		writeJSON(w, http.StatusOK, job)
+	}
+}
+
+func (s *Server) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.userJob(w, r)
+	if !ok {
+		return
+	}
+	job, _ = s.queue.Cancel(job.ID)
+	writeJSON(w, http.StatusOK, job)
+}
+
+// userJob returns the job in the request's path, or writes a 404 if there isn't one or someone else submitted it,
+// unless the request is trusted
+func (s *Server) userJob(w http.ResponseWriter, r *http.Request) (Job, bool) {
+	job, ok := s.queue.Get(r.PathValue("id"))
+	if user := userFromContext(r.Context()); ok && !user.trusted && user.name != job.User {
+		ok = false
+	}
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: fmt.Sprintf("Job %s not found", r.PathValue("id"))})
+	}
+	return job, ok
+}
+
+// handleJobLogs streams a job's logs as they're written, until the job finishes or the client goes away
+func (s *Server) handleJobLogs(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.userJob(w, r)
+	if !ok {
+		return
+	}
+	logs := s.queue.logs(job.ID)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	offset := 0
+	for {
+		data, finished, changed := logs.since(offset)
+		if len(data) > 0 {
+			if _, err := w.Write(data); err != nil {
+				return
+			}
+			offset += len(data)
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if finished {
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func userFromContext(ctx context.Context) requestUser {
+	user, _ := ctx.Value(requestUserKey{}).(requestUser)
+	return user
+}
+
+// withLogs runs f with a client that writes logs to the server's logs, and returns what it wrote
+func (s *Server) withLogs(f func(c *client.Client) error) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	logs := &lockedBuffer{}
+	c := client.New(client.Options{Logs: io.MultiWriter(s.logs, logs)})
+	err := f(c)
+	return logs.String(), err
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		console.Debugf("Failed to write response: %s", err)
+	}
+}
+
+// lockedBuffer is a bytes.Buffer that can be written to from the console and subprocesses at the same time
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenAPISchema(t *testing.T) {
+	schema, err := openapi3.NewLoader().LoadFromData(openAPISchema)
+	require.NoError(t, err)
+	require.NoError(t, schema.Validate(openapi3.NewLoader().Context))
+	for _, path := range []string{"/images", "/images/{image}", "/builds", "/predictions", "/jobs", "/jobs/{id}", "/jobs/{id}/logs"} {
+		require.NotNil(t, schema.Paths.Value(path), path)
+	}
+}
+
+func TestToken(t *testing.T) {
+	handler := NewServer(io.Discard, "secret").Handler()
+
+	req := httptest.NewRequest(http.MethodGet, "/openapi.json", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/openapi.json", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/openapi.json", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, openAPISchema, rec.Body.Bytes())
+}
+
+func TestInvalidRequests(t *testing.T) {
+	handler := NewServer(io.Discard, "secret").Handler()
+
+	for _, tt := range []struct {
+		path  string
+		body  string
+		error string
+	}{
+		{"/builds", `{`, "Invalid request"},
+		{"/builds", `{}`, "project_dir is required"},
+		{"/predictions", `{"inputs":{}}`, "image is required"},
+		{"/predictions", `{"image":"my-model","inputs":{"image":"@/etc/passwd"}}`, "can't be a file on the host"},
+	} {
+		req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusBadRequest, rec.Code, tt.body)
+
+		var response errorResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		require.Contains(t, response.Error, tt.error)
+	}
+}
+
+func TestRejectsRequestsFromBrowsers(t *testing.T) {
+	handler := NewServer(io.Discard, "secret").Handler()
+
+	// A web page can send a simple cross-site POST with a text/plain body, but not set Authorization
+	req := httptest.NewRequest(http.MethodPost, "/predictions", strings.NewReader(`{"image":"evil"}`))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/images", nil)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	// Without a token, nothing is allowed
+	req = httptest.NewRequest(http.MethodGet, "/openapi.json", nil)
+	rec = httptest.NewRecorder()
+	NewServer(io.Discard, "").Handler().ServeHTTP(rec, req)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+}
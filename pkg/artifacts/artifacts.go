@@ -0,0 +1,148 @@
+// Package artifacts attaches files like schema snapshots, SBOMs, scan reports and provenance to model images in
+// their registry, as OCI referrers of the image's digest. Registries that don't support the OCI referrers API are
+// sent the fallback tag scheme from the OCI distribution spec instead, which is sha256-<digest> in the image's
+// repository.
+package artifacts
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// Artifact types, which are the media types of the files
+const (
+	TypeSchema    = "application/vnd.cog.openapi-schema+json"
+	TypeSPDX      = "application/spdx+json"
+	TypeCycloneDX = "application/vnd.cyclonedx+json"
+	TypeSARIF     = "application/sarif+json"
+	TypeInToto    = "application/vnd.in-toto+json"
+	TypeLicenses  = "application/vnd.cog.license-report+json"
+)
+
+// Annotations on artifact manifests
+const (
+	AnnotationCreated  = "org.opencontainers.image.created"
+	AnnotationFilename = "org.opencontainers.image.title"
+)
+
+// Types are short names for artifact types, for flags
+var Types = map[string]string{
+	"schema":     TypeSchema,
+	"sbom":       TypeSPDX,
+	"cyclonedx":  TypeCycloneDX,
+	"scan":       TypeSARIF,
+	"provenance": TypeInToto,
+	"licenses":   TypeLicenses,
+}
+
+// Artifact is a file attached to an image
+type Artifact struct {
+	Digest       string            `json:"digest"`
+	ArtifactType string            `json:"artifact_type"`
+	Size         int64             `json:"size"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// ResolveType returns the artifact type for t, which is a short name in Types or a media type
+func ResolveType(t string) string {
+	if mediaType, ok := Types[t]; ok {
+		return mediaType
+	}
+	return t
+}
+
+// Attach pushes data as an artifact of artifactType that refers to imageName, and returns its digest.
+// annotations are added to its manifest, with the time it was created.
+func Attach(imageName string, artifactType string, data []byte, annotations map[string]string) (name.Digest, error) {
+	options := []remote.Option{remote.WithAuthFromKeychain(authn.DefaultKeychain)}
+	ref, err := name.ParseReference(imageName)
+	if err != nil {
+		return name.Digest{}, fmt.Errorf("Failed to parse image name %s: %w", imageName, err)
+	}
+	subject, err := remote.Head(ref, options...)
+	if err != nil {
+		return name.Digest{}, fmt.Errorf("Failed to fetch %s: %w", imageName, err)
+	}
+
+	artifact, err := newArtifact(*subject, artifactType, data, annotations)
+	if err != nil {
+		return name.Digest{}, err
+	}
+	digest, err := artifact.Digest()
+	if err != nil {
+		return name.Digest{}, err
+	}
+	target := ref.Context().Digest(digest.String())
+	if err := remote.Write(target, artifact, options...); err != nil {
+		return name.Digest{}, fmt.Errorf("Failed to push artifact to %s: %w", ref.Context(), err)
+	}
+	return target, nil
+}
+
+// List returns the artifacts attached to imageName, sorted by type
+func List(imageName string) ([]Artifact, error) {
+	options := []remote.Option{remote.WithAuthFromKeychain(authn.DefaultKeychain)}
+	ref, err := name.ParseReference(imageName)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse image name %s: %w", imageName, err)
+	}
+	desc, err := remote.Head(ref, options...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch %s: %w", imageName, err)
+	}
+	index, err := remote.Referrers(ref.Context().Digest(desc.Digest.String()), options...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list artifacts attached to %s: %w", imageName, err)
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	artifacts := []Artifact{}
+	for _, referrer := range manifest.Manifests {
+		artifacts = append(artifacts, Artifact{
+			Digest:       referrer.Digest.String(),
+			ArtifactType: referrer.ArtifactType,
+			Size:         referrer.Size,
+			Annotations:  referrer.Annotations,
+		})
+	}
+	sort.SliceStable(artifacts, func(i, j int) bool {
+		return artifacts[i].ArtifactType < artifacts[j].ArtifactType
+	})
+	return artifacts, nil
+}
+
+// newArtifact makes an OCI artifact manifest for data, with subject as its subject. The artifact type is the
+// config media type, which is what registries and the fallback tag scheme report it as.
+func newArtifact(subject v1.Descriptor, artifactType string, data []byte, annotations map[string]string) (v1.Image, error) {
+	img := mutate.MediaType(empty.Image, types.OCIManifestSchema1)
+	img = mutate.ConfigMediaType(img, types.MediaType(artifactType))
+	img, err := mutate.Append(img, mutate.Addendum{
+		Layer:     static.NewLayer(data, types.MediaType(artifactType)),
+		MediaType: types.MediaType(artifactType),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	manifestAnnotations := map[string]string{AnnotationCreated: time.Now().UTC().Format(time.RFC3339)}
+	for key, value := range annotations {
+		manifestAnnotations[key] = value
+	}
+	img = mutate.Annotations(img, manifestAnnotations).(v1.Image)
+	// The subject descriptor only has the fields the spec allows
+	subject = v1.Descriptor{MediaType: subject.MediaType, Digest: subject.Digest, Size: subject.Size}
+	return mutate.Subject(img, subject).(v1.Image), nil
+}
@@ -0,0 +1,58 @@
+package artifacts
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttachAndList(t *testing.T) {
+	for _, referrersSupport := range []bool{true, false} {
+		server := httptest.NewServer(registry.New(registry.WithReferrersSupport(referrersSupport)))
+		defer server.Close()
+		u, err := url.Parse(server.URL)
+		require.NoError(t, err)
+
+		imageName := u.Host + "/ml/my-model:v1"
+		img, err := random.Image(1024, 1)
+		require.NoError(t, err)
+		ref, err := name.ParseReference(imageName)
+		require.NoError(t, err)
+		require.NoError(t, remote.Write(ref, img))
+
+		artifacts, err := List(imageName)
+		require.NoError(t, err)
+		require.Empty(t, artifacts)
+
+		schema, err := Attach(imageName, ResolveType("schema"), []byte(`{"openapi":"3.0.2"}`), nil)
+		require.NoError(t, err)
+		sbom, err := Attach(imageName, ResolveType("sbom"), []byte(`{"spdxVersion":"SPDX-2.3"}`), map[string]string{AnnotationFilename: "sbom.spdx.json"})
+		require.NoError(t, err)
+
+		artifacts, err = List(imageName)
+		require.NoError(t, err)
+		require.Len(t, artifacts, 2)
+		require.Equal(t, TypeSPDX, artifacts[0].ArtifactType)
+		require.Equal(t, sbom.DigestStr(), artifacts[0].Digest)
+		require.Equal(t, TypeSchema, artifacts[1].ArtifactType)
+		require.Equal(t, schema.DigestStr(), artifacts[1].Digest)
+
+		if !referrersSupport {
+			digest, err := img.Digest()
+			require.NoError(t, err)
+			_, err = remote.Head(ref.Context().Tag("sha256-" + digest.Hex))
+			require.NoError(t, err, "fallback tag should be pushed")
+		}
+	}
+}
+
+func TestResolveType(t *testing.T) {
+	require.Equal(t, TypeSARIF, ResolveType("scan"))
+	require.Equal(t, "application/vnd.example+json", ResolveType("application/vnd.example+json"))
+}
@@ -0,0 +1,236 @@
+// Package audit records the requests to a model's HTTP server and its responses, for debugging and collecting
+// datasets while testing a model with `cog serve`.
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/replicate/cog/pkg/proxy"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// DefaultMaxSize is the default for Options.MaxSize
+const DefaultMaxSize = 1024 * 1024
+
+// Redacted replaces the values that are redacted
+const Redacted = "[REDACTED]"
+
+// shortStringLength is how much of long strings, like files in data URLs, is kept when a body is too big
+const shortStringLength = 256
+
+// Record is a request and its response
+type Record struct {
+	Time       time.Time       `json:"time"`
+	Client     string          `json:"client"`
+	Method     string          `json:"method"`
+	Path       string          `json:"path"`
+	Status     int             `json:"status"`
+	DurationMs int64           `json:"duration_ms"`
+	Request    json.RawMessage `json:"request,omitempty"`
+	Response   json.RawMessage `json:"response,omitempty"`
+	// RequestSize and ResponseSize are set to the size of the body if it's left out because it's bigger than
+	// Options.MaxSize, or isn't JSON
+	RequestSize  int `json:"request_size,omitempty"`
+	ResponseSize int `json:"response_size,omitempty"`
+}
+
+// Sink is where records are written to
+type Sink interface {
+	Write(record Record) error
+	Close() error
+}
+
+// Options are how requests are recorded
+type Options struct {
+	// RedactFields are the keys whose values are redacted, wherever they are in the bodies, like "prompt" or "email"
+	RedactFields []string
+	// RedactPatterns are redacted from strings in the bodies, like email addresses
+	RedactPatterns []*regexp.Regexp
+	// Redact is called with each body after it's parsed, for redaction that needs code. It can change it in place.
+	Redact func(body any)
+	// MaxSize is the largest each body can be in the log, in bytes. Long strings in bigger bodies are shortened, and
+	// bodies that are still too big are left out.
+	MaxSize int
+}
+
+// Logger records the requests sent to a handler
+type Logger struct {
+	sinks   []Sink
+	options Options
+	fields  map[string]bool
+}
+
+// NewLogger returns a Logger that writes records to sinks
+func NewLogger(options Options, sinks ...Sink) *Logger {
+	if options.MaxSize <= 0 {
+		options.MaxSize = DefaultMaxSize
+	}
+	fields := map[string]bool{}
+	for _, field := range options.RedactFields {
+		fields[field] = true
+	}
+	return &Logger{sinks: sinks, options: options, fields: fields}
+}
+
+// Close closes the sinks
+func (l *Logger) Close() error {
+	var errs []error
+	for _, sink := range l.sinks {
+		errs = append(errs, sink.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Middleware records the requests to next, apart from GETs like health checks
+func (l *Logger) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		started := time.Now()
+		var request []byte
+		if r.Body != nil {
+			var err error
+			request, err = io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(request))
+		}
+		recorder := proxy.NewResponseRecorder(w)
+		recorder.Body = &bytes.Buffer{}
+		next.ServeHTTP(recorder, r)
+
+		record := Record{
+			Time:       started.UTC(),
+			Client:     proxy.ClientAddress(r),
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Status:     recorder.Status,
+			DurationMs: time.Since(started).Milliseconds(),
+		}
+		record.Request, record.RequestSize = l.body(request)
+		record.Response, record.ResponseSize = l.body(recorder.Body.Bytes())
+		for _, sink := range l.sinks {
+			if err := sink.Write(record); err != nil {
+				console.Warnf("Failed to write to audit log: %s", err)
+			}
+		}
+	})
+}
+
+// body returns the body to record, redacted and shortened, or its size if it's left out
+func (l *Logger) body(data []byte) (json.RawMessage, int) {
+	if len(data) == 0 {
+		return nil, 0
+	}
+	var body any
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, len(data)
+	}
+	body = l.redact(body)
+	if l.options.Redact != nil {
+		l.options.Redact(body)
+	}
+	out, err := json.Marshal(body)
+	if err != nil {
+		return nil, len(data)
+	}
+	if len(out) > l.options.MaxSize {
+		out, err = json.Marshal(shorten(body))
+		if err != nil || len(out) > l.options.MaxSize {
+			return nil, len(data)
+		}
+	}
+	return out, 0
+}
+
+func (l *Logger) redact(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, elem := range v {
+			if l.fields[key] {
+				v[key] = Redacted
+			} else {
+				v[key] = l.redact(elem)
+			}
+		}
+		return v
+	case []any:
+		for i, elem := range v {
+			v[i] = l.redact(elem)
+		}
+		return v
+	case string:
+		for _, pattern := range l.options.RedactPatterns {
+			v = pattern.ReplaceAllString(v, Redacted)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func shorten(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, elem := range v {
+			v[key] = shorten(elem)
+		}
+		return v
+	case []any:
+		for i, elem := range v {
+			v[i] = shorten(elem)
+		}
+		return v
+	case string:
+		if len(v) > shortStringLength {
+			return fmt.Sprintf("%s... (%d bytes)", v[:shortStringLength], len(v))
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// FileSink writes records to a file as JSON lines
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink returns a FileSink that appends to the file at path
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open audit log: %w", err)
+	}
+	return &FileSink{file: file}, nil
+}
+
+func (s *FileSink) Write(record Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
+
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
@@ -0,0 +1,131 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type memorySink struct {
+	records []Record
+}
+
+func (s *memorySink) Write(record Record) error {
+	s.records = append(s.records, record)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	return nil
+}
+
+func echo(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(body)
+}
+
+func TestMiddlewareRedacts(t *testing.T) {
+	sink := &memorySink{}
+	logger := NewLogger(Options{
+		RedactFields:   []string{"api_key"},
+		RedactPatterns: []*regexp.Regexp{regexp.MustCompile(`[\w.]+@[\w.]+`)},
+		Redact: func(body any) {
+			if m, ok := body.(map[string]any); ok {
+				delete(m, "id")
+			}
+		},
+	}, sink)
+	server := httptest.NewServer(logger.Middleware(http.HandlerFunc(echo)))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/predictions", "application/json", strings.NewReader(`{"id":"abc","input":{"prompt":"email me at ada@example.com","api_key":"secret"}}`))
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	// The model gets the request as it was sent
+	require.Contains(t, string(body), "secret")
+
+	resp, err = http.Get(server.URL + "/health-check")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.Len(t, sink.records, 1)
+	record := sink.records[0]
+	require.Equal(t, "POST", record.Method)
+	require.Equal(t, "/predictions", record.Path)
+	require.Equal(t, http.StatusCreated, record.Status)
+	require.Equal(t, "127.0.0.1", record.Client)
+	require.JSONEq(t, `{"input":{"prompt":"email me at [REDACTED]","api_key":"[REDACTED]"}}`, string(record.Request))
+	require.JSONEq(t, string(record.Request), string(record.Response))
+}
+
+func TestBodySizeLimit(t *testing.T) {
+	logger := NewLogger(Options{MaxSize: 400})
+
+	long := strings.Repeat("a", 1000)
+	body, size := logger.body([]byte(`{"output":"` + long + `"}`))
+	require.Zero(t, size)
+	require.JSONEq(t, `{"output":"`+long[:shortStringLength]+`... (1000 bytes)"}`, string(body))
+
+	many := `{"output":["` + strings.Repeat(`a","`, 200) + `a"]}`
+	body, size = logger.body([]byte(many))
+	require.Nil(t, body)
+	require.Equal(t, len(many), size)
+
+	body, size = logger.body([]byte("not json"))
+	require.Nil(t, body)
+	require.Equal(t, 8, size)
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := NewFileSink(path)
+	require.NoError(t, err)
+	require.NoError(t, sink.Write(Record{Method: "POST", Path: "/predictions", Status: 200}))
+	require.NoError(t, sink.Write(Record{Method: "PUT", Path: "/predictions/abc", Status: 409}))
+	require.NoError(t, sink.Close())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	var records []Record
+	for scanner.Scan() {
+		var record Record
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	require.Len(t, records, 2)
+	require.Equal(t, 409, records[1].Status)
+}
+
+func TestOTLPSink(t *testing.T) {
+	requests := make(chan map[string]any, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/logs", r.URL.Path)
+		var body map[string]any
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		requests <- body
+	}))
+	defer collector.Close()
+
+	sink := NewOTLPSink(collector.URL + "/")
+	require.NoError(t, sink.Write(Record{Method: "POST", Path: "/predictions", Status: 500}))
+	require.NoError(t, sink.Close())
+
+	body := <-requests
+	logRecord := body["resourceLogs"].([]any)[0].(map[string]any)["scopeLogs"].([]any)[0].(map[string]any)["logRecords"].([]any)[0].(map[string]any)
+	require.Equal(t, "ERROR", logRecord["severityText"])
+	require.Contains(t, logRecord["body"].(map[string]any)["stringValue"], `"path":"/predictions"`)
+}
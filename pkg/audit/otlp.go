@@ -0,0 +1,132 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// otlpQueueLength is how many records wait to be exported before new ones are dropped
+const otlpQueueLength = 1000
+
+// OTLPSink exports records as logs to an OpenTelemetry collector, with OTLP over HTTP in JSON. Records are exported in
+// the background, so they don't slow down responses.
+type OTLPSink struct {
+	url     string
+	client  *http.Client
+	records chan Record
+	done    chan struct{}
+}
+
+// NewOTLPSink returns an OTLPSink that exports to the collector at endpoint, like http://localhost:4318
+func NewOTLPSink(endpoint string) *OTLPSink {
+	s := &OTLPSink{
+		url:     strings.TrimSuffix(endpoint, "/") + "/v1/logs",
+		client:  &http.Client{Timeout: 10 * time.Second},
+		records: make(chan Record, otlpQueueLength),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *OTLPSink) Write(record Record) error {
+	select {
+	case s.records <- record:
+		return nil
+	default:
+		return fmt.Errorf("%d records are waiting to be exported to %s, so this one was dropped", otlpQueueLength, s.url)
+	}
+}
+
+// Close exports the records that are waiting
+func (s *OTLPSink) Close() error {
+	close(s.records)
+	<-s.done
+	return nil
+}
+
+func (s *OTLPSink) run() {
+	defer close(s.done)
+	for record := range s.records {
+		if err := s.export(record); err != nil {
+			console.Warnf("Failed to export audit log to %s: %s", s.url, err)
+		}
+	}
+}
+
+func (s *OTLPSink) export(record Record) error {
+	body, err := json.Marshal(otlpLogs(record))
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+func stringAttribute(key string, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{StringValue: &value}}
+}
+
+func intAttribute(key string, value int64) otlpAttribute {
+	// OTLP JSON encodes 64-bit integers as strings
+	s := strconv.FormatInt(value, 10)
+	return otlpAttribute{Key: key, Value: otlpValue{IntValue: &s}}
+}
+
+// otlpLogs returns the ExportLogsServiceRequest for a record, with the record as the body of a log record, and its
+// request line and status as attributes
+func otlpLogs(record Record) map[string]any {
+	line, _ := json.Marshal(record)
+	body := string(line)
+	attributes := []otlpAttribute{
+		stringAttribute("http.request.method", record.Method),
+		stringAttribute("url.path", record.Path),
+		stringAttribute("client.address", record.Client),
+		intAttribute("http.response.status_code", int64(record.Status)),
+		intAttribute("duration_ms", record.DurationMs),
+	}
+	severity := "INFO"
+	if record.Status >= 400 {
+		severity = "ERROR"
+	}
+	return map[string]any{
+		"resourceLogs": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": []otlpAttribute{stringAttribute("service.name", "cog")},
+			},
+			"scopeLogs": []any{map[string]any{
+				"scope": map[string]any{"name": "cog.audit"},
+				"logRecords": []any{map[string]any{
+					"timeUnixNano": strconv.FormatInt(record.Time.UnixNano(), 10),
+					"severityText": severity,
+					"body":         otlpValue{StringValue: &body},
+					"attributes":   attributes,
+				}},
+			}},
+		}},
+	}
+}
@@ -0,0 +1,148 @@
+package benchmark
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadScenario(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peak.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+stages:
+  - duration: 30s
+    users: 4
+  - duration: 1m
+    users: 4
+  - duration: 30s
+    users: 0
+think_time:
+  min: 500ms
+  max: 2s
+inputs:
+  - name: short
+    weight: 3
+    input:
+      prompt: a cat
+  - input:
+      prompt: a detailed painting of a cat
+      steps: 50
+`), 0o644))
+
+	scenario, err := LoadScenario(path)
+	require.NoError(t, err)
+	require.Equal(t, "peak", scenario.Name)
+	require.Equal(t, 2*time.Minute, scenario.Duration())
+	require.Equal(t, ThinkTime{Min: Duration(500 * time.Millisecond), Max: Duration(2 * time.Second)}, scenario.ThinkTime)
+	require.Equal(t, "short", scenario.InputName(0))
+	require.Equal(t, "input 2", scenario.InputName(1))
+	require.Equal(t, 50, scenario.Inputs[1].Input["steps"])
+
+	require.Equal(t, 0, scenario.UsersAt(0))
+	require.Equal(t, 2, scenario.UsersAt(15*time.Second))
+	require.Equal(t, 4, scenario.UsersAt(time.Minute))
+	require.Equal(t, 2, scenario.UsersAt(105*time.Second))
+	require.Equal(t, 0, scenario.UsersAt(3*time.Minute))
+}
+
+func TestLoadScenarioThinkTime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scenario.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("stages: [{duration: 10s, users: 1}]\nthink_time: 1s\n"), 0o644))
+	scenario, err := LoadScenario(path)
+	require.NoError(t, err)
+	require.Equal(t, ThinkTime{Min: Duration(time.Second), Max: Duration(time.Second)}, scenario.ThinkTime)
+}
+
+func TestLoadScenarioInvalid(t *testing.T) {
+	for _, contents := range []string{
+		"stages: []\n",
+		"stages: [{users: 1}]\n",
+		"stages: [{duration: soon, users: 1}]\n",
+		"stages: [{duration: 10s, users: 1}]\nthink_time: {min: 2s, max: 1s}\n",
+		"stages: [{duration: 10s, users: 1}]\nramp: linear\n",
+	} {
+		path := filepath.Join(t.TempDir(), "scenario.yaml")
+		require.NoError(t, os.WriteFile(path, []byte(contents), 0o644))
+		_, err := LoadScenario(path)
+		require.Error(t, err, contents)
+	}
+}
+
+func TestRun(t *testing.T) {
+	scenario := &Scenario{
+		Name:   "test",
+		Stages: []Stage{{Duration: Duration(300 * time.Millisecond), Users: 2}, {Duration: Duration(300 * time.Millisecond), Users: 2}},
+		Inputs: []InputMix{{Name: "a", Weight: 1}, {Name: "b", Weight: 3}},
+	}
+	var inFlight, peak atomic.Int32
+	result := Run(context.Background(), scenario, func(ctx context.Context, input int) error {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		if input == 0 {
+			return errors.New("failed")
+		}
+		return nil
+	})
+
+	require.Equal(t, "test", result.Scenario)
+	require.Equal(t, 2, result.PeakUsers)
+	require.LessOrEqual(t, peak.Load(), int32(2))
+	require.Greater(t, result.Requests, 10)
+	require.Equal(t, result.Requests, result.Inputs[0].Requests+result.Inputs[1].Requests)
+	require.Equal(t, result.Inputs[0].Requests, result.Errors)
+	require.Greater(t, result.Inputs[1].Requests, result.Inputs[0].Requests)
+	require.Greater(t, result.Throughput, 0.0)
+	require.GreaterOrEqual(t, result.Latency.P50, 10.0)
+	require.LessOrEqual(t, result.Latency.P50, result.Latency.P99)
+}
+
+func TestPercentile(t *testing.T) {
+	latencies := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	require.Equal(t, 5.0, percentile(latencies, 50))
+	require.Equal(t, 9.0, percentile(latencies, 90))
+	require.Equal(t, 10.0, percentile(latencies, 99))
+	require.Equal(t, 1.0, percentile([]float64{1}, 99))
+}
+
+func TestCompare(t *testing.T) {
+	previous := &Result{Stats: Stats{Requests: 100, Throughput: 10, Latency: Latency{Mean: 100, P50: 100, P90: 150, P99: 200}}}
+	current := &Result{Stats: Stats{Requests: 100, Errors: 1, Throughput: 9.5, Latency: Latency{Mean: 100, P50: 100, P90: 150, P99: 300}}}
+
+	changes := Compare(previous, current, 10)
+	regressed := map[string]bool{}
+	for _, change := range changes {
+		regressed[change.Metric] = change.Regressed
+	}
+	require.Equal(t, map[string]bool{
+		"throughput":   false,
+		"error rate":   true,
+		"latency mean": false,
+		"latency p50":  false,
+		"latency p90":  false,
+		"latency p99":  true,
+	}, regressed)
+	require.Equal(t, -5.0, changes[0].Percent)
+	require.Equal(t, 50.0, changes[5].Percent)
+}
+
+func TestSaveAndLoadResult(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "result.json")
+	result := &Result{Scenario: "peak", Started: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), Duration: 60, PeakUsers: 4, Stats: Stats{Requests: 10, Throughput: 1.5}}
+	require.NoError(t, result.Save(path))
+	loaded, err := LoadResult(path)
+	require.NoError(t, err)
+	require.Equal(t, result, loaded)
+}
@@ -0,0 +1,183 @@
+package benchmark
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// Result is what happened when a scenario was run, saved as JSON to compare with later runs
+type Result struct {
+	Scenario string    `json:"scenario"`
+	Started  time.Time `json:"started"`
+	// Duration is how long it ran, in seconds
+	Duration  float64 `json:"duration"`
+	PeakUsers int     `json:"peak_users"`
+	Stats
+	Inputs []InputResult `json:"inputs,omitempty"`
+}
+
+// InputResult is the requests that sent one of the scenario's input mixes
+type InputResult struct {
+	Name string `json:"name"`
+	Stats
+}
+
+// Stats are the statistics of a set of requests. Latencies are in milliseconds.
+type Stats struct {
+	Requests int `json:"requests"`
+	Errors   int `json:"errors"`
+	// Throughput is the number of successful requests per second
+	Throughput float64 `json:"throughput"`
+	Latency    Latency `json:"latency"`
+}
+
+// Latency is the distribution of the latencies of successful requests, in milliseconds
+type Latency struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// ErrorRate returns the fraction of requests that failed
+func (s Stats) ErrorRate() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.Errors) / float64(s.Requests)
+}
+
+func newResult(scenario *Scenario, started time.Time, duration time.Duration, peakUsers int, samples []sample) *Result {
+	result := &Result{
+		Scenario:  scenario.Name,
+		Started:   started.UTC(),
+		Duration:  duration.Seconds(),
+		PeakUsers: peakUsers,
+		Stats:     newStats(samples, duration),
+	}
+	for i := range scenario.Inputs {
+		inputSamples := []sample{}
+		for _, s := range samples {
+			if s.input == i {
+				inputSamples = append(inputSamples, s)
+			}
+		}
+		result.Inputs = append(result.Inputs, InputResult{Name: scenario.InputName(i), Stats: newStats(inputSamples, duration)})
+	}
+	return result
+}
+
+func newStats(samples []sample, duration time.Duration) Stats {
+	stats := Stats{Requests: len(samples)}
+	latencies := []float64{}
+	for _, s := range samples {
+		if s.err != nil {
+			stats.Errors++
+			continue
+		}
+		latencies = append(latencies, float64(s.latency)/float64(time.Millisecond))
+	}
+	if duration > 0 {
+		stats.Throughput = float64(len(latencies)) / duration.Seconds()
+	}
+	if len(latencies) == 0 {
+		return stats
+	}
+
+	sort.Float64s(latencies)
+	sum := 0.0
+	for _, l := range latencies {
+		sum += l
+	}
+	stats.Latency = Latency{
+		Mean: sum / float64(len(latencies)),
+		P50:  percentile(latencies, 50),
+		P90:  percentile(latencies, 90),
+		P99:  percentile(latencies, 99),
+		Max:  latencies[len(latencies)-1],
+	}
+	return stats
+}
+
+// percentile returns the nearest-rank percentile p of sorted
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	return sorted[max(0, min(rank, len(sorted)-1))]
+}
+
+// Save writes the result to path as JSON
+func (r *Result) Save(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("Failed to save benchmark results: %w", err)
+	}
+	return nil
+}
+
+// LoadResult reads a result saved with Save
+func LoadResult(path string) (*Result, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read benchmark results: %w", err)
+	}
+	result := &Result{}
+	if err := json.Unmarshal(data, result); err != nil {
+		return nil, fmt.Errorf("Failed to parse benchmark results %s: %w", path, err)
+	}
+	return result, nil
+}
+
+// Change is how a metric changed from a previous run to this one
+type Change struct {
+	Metric   string  `json:"metric"`
+	Previous float64 `json:"previous"`
+	Current  float64 `json:"current"`
+	// Percent is the change from Previous to Current, as a percentage of Previous. It's 100 if Previous is 0.
+	Percent float64 `json:"percent"`
+	// Regressed is whether it got worse by more than the threshold it was compared with
+	Regressed bool `json:"regressed"`
+}
+
+// Compare returns how the metrics of current changed from previous. A metric regressed if it got worse by more than
+// threshold percent, like throughput going down or latency going up.
+func Compare(previous *Result, current *Result, threshold float64) []Change {
+	metrics := []struct {
+		name           string
+		previous       float64
+		current        float64
+		higherIsBetter bool
+	}{
+		{"throughput", previous.Throughput, current.Throughput, true},
+		{"error rate", previous.ErrorRate() * 100, current.ErrorRate() * 100, false},
+		{"latency mean", previous.Latency.Mean, current.Latency.Mean, false},
+		{"latency p50", previous.Latency.P50, current.Latency.P50, false},
+		{"latency p90", previous.Latency.P90, current.Latency.P90, false},
+		{"latency p99", previous.Latency.P99, current.Latency.P99, false},
+	}
+
+	changes := []Change{}
+	for _, m := range metrics {
+		change := Change{Metric: m.name, Previous: m.previous, Current: m.current}
+		switch {
+		case m.previous != 0:
+			change.Percent = (m.current - m.previous) / m.previous * 100
+		case m.current != 0:
+			change.Percent = math.Copysign(100, m.current)
+		}
+		worse := change.Percent
+		if m.higherIsBetter {
+			worse = -worse
+		}
+		change.Regressed = worse > threshold
+		changes = append(changes, change)
+	}
+	return changes
+}
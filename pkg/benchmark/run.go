@@ -0,0 +1,112 @@
+package benchmark
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// tick is how often the number of users is adjusted to the scenario's ramp
+const tick = 100 * time.Millisecond
+
+// Target sends a request with the scenario's input mix input to the model, and returns an error if it failed
+type Target func(ctx context.Context, input int) error
+
+type sample struct {
+	input   int
+	latency time.Duration
+	err     error
+}
+
+// Run runs the scenario against target, and returns the results. Each user sends a request, waits for its response,
+// waits for the think time, and repeats. Requests that are in flight when the scenario ends are waited for.
+func Run(ctx context.Context, scenario *Scenario, target Target) *Result {
+	var (
+		mu        sync.Mutex
+		samples   []sample
+		wg        sync.WaitGroup
+		users     []context.CancelFunc
+		peakUsers int
+	)
+
+	// Users stop once their current request finishes, so in-flight requests aren't cancelled
+	startUser := func(n int) context.CancelFunc {
+		userCtx, cancel := context.WithCancel(ctx)
+		rng := rand.New(rand.NewSource(scenario.Seed + int64(n))) //nolint:gosec
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for userCtx.Err() == nil {
+				input := scenario.pickInput(rng)
+				start := time.Now()
+				err := target(ctx, input)
+				mu.Lock()
+				samples = append(samples, sample{input: input, latency: time.Since(start), err: err})
+				mu.Unlock()
+
+				select {
+				case <-userCtx.Done():
+				case <-time.After(scenario.thinkTime(rng)):
+				}
+			}
+		}()
+		return cancel
+	}
+
+	started := time.Now()
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		elapsed := time.Since(started)
+		if elapsed >= scenario.Duration() || ctx.Err() != nil {
+			break
+		}
+		want := scenario.UsersAt(elapsed)
+		for len(users) < want {
+			users = append(users, startUser(len(users)))
+		}
+		for len(users) > want {
+			users[len(users)-1]()
+			users = users[:len(users)-1]
+		}
+		peakUsers = max(peakUsers, len(users))
+
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+	}
+	for _, cancel := range users {
+		cancel()
+	}
+	wg.Wait()
+
+	return newResult(scenario, started, time.Since(started), peakUsers, samples)
+}
+
+func (s *Scenario) pickInput(rng *rand.Rand) int {
+	total := 0
+	for _, input := range s.Inputs {
+		total += input.weight()
+	}
+	if total == 0 {
+		return 0
+	}
+	n := rng.Intn(total)
+	for i, input := range s.Inputs {
+		n -= input.weight()
+		if n < 0 {
+			return i
+		}
+	}
+	return 0
+}
+
+func (s *Scenario) thinkTime(rng *rand.Rand) time.Duration {
+	shortest, longest := time.Duration(s.ThinkTime.Min), time.Duration(s.ThinkTime.Max)
+	if longest <= shortest {
+		return shortest
+	}
+	return shortest + time.Duration(rng.Int63n(int64(longest-shortest)))
+}
@@ -0,0 +1,184 @@
+// Package benchmark replays traffic against a model, from a scenario file that describes how many users there are
+// over time, what they send and how long they wait between requests, and compares the results with previous runs.
+package benchmark
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Scenario is a pattern of traffic to send to a model. For example:
+//
+//	name: peak
+//	stages:
+//	  - duration: 30s
+//	    users: 4
+//	  - duration: 2m
+//	    users: 4
+//	  - duration: 30s
+//	    users: 0
+//	think_time:
+//	  min: 500ms
+//	  max: 2s
+//	inputs:
+//	  - name: short
+//	    weight: 3
+//	    input:
+//	      prompt: a cat
+//	  - name: long
+//	    input:
+//	      prompt: a detailed painting of a cat
+//	      steps: 50
+type Scenario struct {
+	Name string `yaml:"name"`
+	// Stages ramp the number of users linearly from the previous stage's, or 0, to Users over Duration
+	Stages    []Stage   `yaml:"stages"`
+	ThinkTime ThinkTime `yaml:"think_time"`
+	// Inputs are what users send. Each request picks one at random, in proportion to their weights.
+	Inputs []InputMix `yaml:"inputs"`
+	// Seed seeds the random choices of inputs and think times, so runs of a scenario send the same requests
+	Seed int64 `yaml:"seed"`
+}
+
+// Stage is a period of a scenario, over which the number of users ramps to Users
+type Stage struct {
+	Duration Duration `yaml:"duration"`
+	Users    int      `yaml:"users"`
+}
+
+// ThinkTime is how long each user waits after a response before sending its next request, picked at random between
+// Min and Max. It's written as a duration, like 1s, or with min and max.
+type ThinkTime struct {
+	Min Duration `yaml:"min"`
+	Max Duration `yaml:"max"`
+}
+
+// InputMix is a set of inputs users send
+type InputMix struct {
+	Name string `yaml:"name"`
+	// Weight is how often it's sent, compared to the other inputs. It's 1 if it isn't set.
+	Weight int `yaml:"weight"`
+	// Input is the inputs, in the same form as `cog predict -i`. Values prefixed with @ are files.
+	Input map[string]interface{} `yaml:"input"`
+}
+
+// Duration is a time.Duration written like 30s or 2m
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("Invalid duration %q, like 30s or 2m: %w", s, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (t *ThinkTime) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var d Duration
+	if err := unmarshal(&d); err == nil {
+		t.Min, t.Max = d, d
+		return nil
+	}
+	aux := struct {
+		Min Duration `yaml:"min"`
+		Max Duration `yaml:"max"`
+	}{}
+	if err := unmarshal(&aux); err != nil {
+		return err
+	}
+	t.Min, t.Max = aux.Min, aux.Max
+	return nil
+}
+
+// LoadScenario reads the scenario file at path
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read scenario file: %w", err)
+	}
+	scenario := &Scenario{}
+	if err := yaml.UnmarshalStrict(data, scenario); err != nil {
+		return nil, fmt.Errorf("Failed to parse scenario file %s: %w", path, err)
+	}
+	if scenario.Name == "" {
+		scenario.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if err := scenario.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid scenario file %s: %w", path, err)
+	}
+	return scenario, nil
+}
+
+// Validate returns an error if the scenario can't be run
+func (s *Scenario) Validate() error {
+	if len(s.Stages) == 0 {
+		return fmt.Errorf("It needs at least one stage")
+	}
+	for i, stage := range s.Stages {
+		if stage.Duration <= 0 {
+			return fmt.Errorf("Stage %d needs a duration", i+1)
+		}
+		if stage.Users < 0 {
+			return fmt.Errorf("Stage %d can't have a negative number of users", i+1)
+		}
+	}
+	if s.ThinkTime.Min < 0 || s.ThinkTime.Max < s.ThinkTime.Min {
+		return fmt.Errorf("The think time's max must be at least its min")
+	}
+	for i, input := range s.Inputs {
+		if input.Weight < 0 {
+			return fmt.Errorf("Input %d can't have a negative weight", i+1)
+		}
+	}
+	return nil
+}
+
+// Duration returns how long the scenario runs for
+func (s *Scenario) Duration() time.Duration {
+	var total time.Duration
+	for _, stage := range s.Stages {
+		total += time.Duration(stage.Duration)
+	}
+	return total
+}
+
+// UsersAt returns how many users there are at elapsed into the scenario
+func (s *Scenario) UsersAt(elapsed time.Duration) int {
+	previous := 0
+	for _, stage := range s.Stages {
+		duration := time.Duration(stage.Duration)
+		if elapsed < duration {
+			progress := float64(elapsed) / float64(duration)
+			return previous + int(math.Round(float64(stage.Users-previous)*progress))
+		}
+		elapsed -= duration
+		previous = stage.Users
+	}
+	return 0
+}
+
+// InputName returns the name of the input mix i, or its number if it doesn't have one
+func (s *Scenario) InputName(i int) string {
+	if i < len(s.Inputs) && s.Inputs[i].Name != "" {
+		return s.Inputs[i].Name
+	}
+	return fmt.Sprintf("input %d", i+1)
+}
+
+func (m InputMix) weight() int {
+	if m.Weight == 0 {
+		return 1
+	}
+	return m.Weight
+}
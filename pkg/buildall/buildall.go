@@ -0,0 +1,277 @@
+package buildall
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/replicate/cog/pkg/global"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// Model is a model in a manifest
+type Model struct {
+	// Name identifies the model in the summary and names its log. It defaults to the base name of Path.
+	Name string `yaml:"name" json:"name"`
+	// Path is the directory with the model's cog.yaml, relative to the manifest
+	Path string `yaml:"path" json:"path"`
+	// Image is the name to build the image as. It defaults to cog build's default.
+	Image string `yaml:"image" json:"image,omitempty"`
+	// Args are other arguments to pass to cog build, like --separate-weights
+	Args []string `yaml:"args" json:"args,omitempty"`
+	// CPUs is how many CPUs building the model is expected to keep busy, so builds are only run together if there
+	// are enough CPUs for them. It defaults to the CPUs divided by how many builds are run at once.
+	CPUs int `yaml:"cpus" json:"cpus,omitempty"`
+}
+
+// Manifest is a list of models to build, from a file like models.yaml
+type Manifest struct {
+	Models []Model `yaml:"models"`
+}
+
+var modelNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Load reads a manifest, with the models' paths made absolute
+func Load(path string) (*Manifest, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s: %w", path, err)
+	}
+	manifest := &Manifest{}
+	if err := yaml.UnmarshalStrict(contents, manifest); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %w", path, err)
+	}
+	if len(manifest.Models) == 0 {
+		return nil, fmt.Errorf("%s doesn't list any models", path)
+	}
+
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for i := range manifest.Models {
+		model := &manifest.Models[i]
+		if model.Path == "" {
+			return nil, fmt.Errorf("Model %d in %s doesn't have a path", i+1, path)
+		}
+		if !filepath.IsAbs(model.Path) {
+			model.Path = filepath.Join(dir, model.Path)
+		}
+		if model.Name == "" {
+			model.Name = filepath.Base(model.Path)
+		}
+		if !modelNameRegex.MatchString(model.Name) {
+			return nil, fmt.Errorf("Model name %q in %s can only have letters, numbers, '.', '_' and '-'", model.Name, path)
+		}
+		if names[model.Name] {
+			return nil, fmt.Errorf("There's more than one model named %q in %s. Set name on them so they're different", model.Name, path)
+		}
+		names[model.Name] = true
+		if model.CPUs < 0 {
+			return nil, fmt.Errorf("cpus for %s in %s must be at least 1", model.Name, path)
+		}
+		if _, err := os.Stat(filepath.Join(model.Path, global.ConfigFilename)); err != nil {
+			return nil, fmt.Errorf("There's no %s in %s, for %s in %s", global.ConfigFilename, model.Path, model.Name, path)
+		}
+	}
+	return manifest, nil
+}
+
+// Statuses of a model's build
+const (
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusSkipped   = "skipped"
+)
+
+// Result is the outcome of building a model
+type Result struct {
+	Model    Model         `json:"model"`
+	Status   string        `json:"status"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+	// Log is the file the build's output was written to
+	Log string `json:"log,omitempty"`
+}
+
+// Builder builds model, writing what it prints to log
+type Builder func(model Model, log io.Writer) error
+
+// Scheduler builds models in parallel, within limits on how many builds run at once, the CPUs they use, and the
+// disk space they leave free
+type Scheduler struct {
+	Build Builder
+	// Parallel is how many builds run at once
+	Parallel int
+	// CPUs is how many CPUs the builds that are running can use between them. It defaults to all of them.
+	CPUs int
+	// MinFreeDisk is how much disk space has to be free to start another build. It's only checked if FreeDisk is set.
+	MinFreeDisk int64
+	// FreeDisk returns the free space where Docker stores images
+	FreeDisk func() (int64, error)
+	// LogDir is where each build's output is written, to a file named after the model
+	LogDir string
+}
+
+// Run builds models, starting them in order when the limits allow, and returns the result of each one. A build
+// always starts if nothing else is running, even if it needs more CPUs than the limit, but it's skipped if there
+// isn't enough disk space for it.
+func (s *Scheduler) Run(models []Model) []Result {
+	parallel := max(s.Parallel, 1)
+	cpus := s.CPUs
+	if cpus <= 0 {
+		cpus = runtime.NumCPU()
+	}
+	cost := func(model Model) int {
+		if model.CPUs > 0 {
+			return model.CPUs
+		}
+		return max(cpus/parallel, 1)
+	}
+
+	results := make([]Result, len(models))
+	done := make(chan int)
+	running, cpusUsed, next := 0, 0, 0
+	for next < len(models) || running > 0 {
+		for next < len(models) && running < parallel {
+			model := models[next]
+			if running > 0 && cpusUsed+cost(model) > cpus {
+				break
+			}
+			if err := s.checkDisk(); err != nil {
+				if running > 0 {
+					// Wait for a build to finish, in case it frees some space by removing its intermediate images
+					break
+				}
+				console.Warnf("Skipping %s: %s", model.Name, err)
+				results[next] = Result{Model: model, Status: StatusSkipped, Error: err.Error()}
+				next++
+				continue
+			}
+			running++
+			cpusUsed += cost(model)
+			console.Infof("Building %s (%d of %d)...", model.Name, next+1, len(models))
+			go func(i int) {
+				results[i] = s.build(models[i])
+				done <- i
+			}(next)
+			next++
+		}
+		if running == 0 {
+			continue
+		}
+		i := <-done
+		running--
+		cpusUsed -= cost(models[i])
+		result := results[i]
+		if result.Status == StatusSucceeded {
+			console.Infof("Built %s in %s", result.Model.Name, result.Duration.Round(time.Second))
+		} else {
+			console.Warnf("Failed to build %s after %s: %s. See %s", result.Model.Name, result.Duration.Round(time.Second), result.Error, result.Log)
+		}
+	}
+	return results
+}
+
+func (s *Scheduler) checkDisk() error {
+	if s.FreeDisk == nil || s.MinFreeDisk <= 0 {
+		return nil
+	}
+	free, err := s.FreeDisk()
+	if err != nil {
+		console.Debugf("Failed to get the free disk space, so not checking it: %s", err)
+		return nil
+	}
+	if free < s.MinFreeDisk {
+		return fmt.Errorf("only %s of disk space is free, and at least %s has to be free to start a build", console.FormatBytes(free), console.FormatBytes(s.MinFreeDisk))
+	}
+	return nil
+}
+
+func (s *Scheduler) build(model Model) Result {
+	result := Result{Model: model, Log: filepath.Join(s.LogDir, model.Name+".log")}
+	start := time.Now()
+	err := os.MkdirAll(s.LogDir, 0o755)
+	var log *os.File
+	if err == nil {
+		log, err = os.Create(result.Log)
+	}
+	if err == nil {
+		err = s.Build(model, log)
+		if closeErr := log.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	result.Duration = time.Since(start)
+	if err != nil {
+		result.Status = StatusFailed
+		result.Error = err.Error()
+	} else {
+		result.Status = StatusSucceeded
+	}
+	return result
+}
+
+// Failed returns how many of results didn't succeed
+func Failed(results []Result) int {
+	failed := 0
+	for _, result := range results {
+		if result.Status != StatusSucceeded {
+			failed++
+		}
+	}
+	return failed
+}
+
+// WriteSummary writes a table of results
+func WriteSummary(w io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODEL\tSTATUS\tDURATION\tLOG")
+	var total time.Duration
+	for _, result := range results {
+		total += result.Duration
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", result.Model.Name, result.Status, result.Duration.Round(time.Second), result.Log)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	failed := Failed(results)
+	_, err := fmt.Fprintf(w, "\n%d built, %d failed or skipped, %s of build time in total\n", len(results)-failed, failed, total.Round(time.Second))
+	return err
+}
+
+// WriteReport writes results to path as JSON, for CI
+func WriteReport(path string, results []Result) error {
+	data, err := json.MarshalIndent(map[string]any{"results": results}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("Failed to write report to %s: %w", path, err)
+	}
+	return nil
+}
+
+// Args returns the arguments to cog build for model
+func Args(model Model) []string {
+	args := []string{"build"}
+	if model.Image != "" {
+		args = append(args, "--tag", model.Image)
+	}
+	return append(args, model.Args...)
+}
+
+// String describes the model's build as a command, for logs
+func (m Model) String() string {
+	return "cog " + strings.Join(Args(m), " ")
+}
@@ -0,0 +1,130 @@
+package buildall
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeModel(t *testing.T, dir string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cog.yaml"), []byte("predict: predict.py:Predictor\n"), 0o644))
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	writeModel(t, filepath.Join(dir, "models", "resnet"))
+	writeModel(t, filepath.Join(dir, "models", "llama"))
+	path := filepath.Join(dir, "models.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+models:
+  - path: models/resnet
+    image: r8.im/my-org/resnet
+  - path: models/llama
+    name: llama-8b
+    args: ["--separate-weights"]
+    cpus: 8
+`), 0o644))
+
+	manifest, err := Load(path)
+	require.NoError(t, err)
+	require.Equal(t, []Model{
+		{Name: "resnet", Path: filepath.Join(dir, "models", "resnet"), Image: "r8.im/my-org/resnet"},
+		{Name: "llama-8b", Path: filepath.Join(dir, "models", "llama"), Args: []string{"--separate-weights"}, CPUs: 8},
+	}, manifest.Models)
+	require.Equal(t, []string{"build", "--tag", "r8.im/my-org/resnet"}, Args(manifest.Models[0]))
+
+	require.NoError(t, os.WriteFile(path, []byte("models:\n  - path: models/resnet\n  - path: models/resnet\n"), 0o644))
+	_, err = Load(path)
+	require.ErrorContains(t, err, `more than one model named "resnet"`)
+
+	require.NoError(t, os.WriteFile(path, []byte("models:\n  - path: models/missing\n"), 0o644))
+	_, err = Load(path)
+	require.ErrorContains(t, err, "There's no cog.yaml")
+}
+
+// recorder is a Builder that records how many builds run at once
+type recorder struct {
+	mu      sync.Mutex
+	running int
+	peak    int
+	built   []string
+}
+
+func (r *recorder) build(model Model, log io.Writer) error {
+	r.mu.Lock()
+	r.running++
+	r.peak = max(r.peak, r.running)
+	r.mu.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	r.mu.Lock()
+	r.running--
+	r.built = append(r.built, model.Name)
+	r.mu.Unlock()
+	if model.Name == "broken" {
+		return errors.New("exit status 1")
+	}
+	return nil
+}
+
+func models(names ...string) []Model {
+	result := []Model{}
+	for _, name := range names {
+		result = append(result, Model{Name: name})
+	}
+	return result
+}
+
+func TestSchedulerLimitsParallelBuilds(t *testing.T) {
+	r := &recorder{}
+	s := &Scheduler{Build: r.build, Parallel: 2, CPUs: 8, LogDir: t.TempDir()}
+	results := s.Run(models("a", "b", "broken", "d", "e"))
+	require.Equal(t, 2, r.peak)
+	require.Len(t, r.built, 5)
+	require.Equal(t, 1, Failed(results))
+	require.Equal(t, StatusFailed, results[2].Status)
+	require.Equal(t, "exit status 1", results[2].Error)
+	require.FileExists(t, results[0].Log)
+}
+
+func TestSchedulerLimitsCPUs(t *testing.T) {
+	r := &recorder{}
+	s := &Scheduler{Build: r.build, Parallel: 4, CPUs: 8, LogDir: t.TempDir()}
+	big := models("a", "b", "c")
+	for i := range big {
+		big[i].CPUs = 6
+	}
+	results := s.Run(big)
+	require.Equal(t, 1, r.peak)
+	require.Equal(t, 0, Failed(results))
+}
+
+func TestSchedulerSkipsBuildsWithoutDiskSpace(t *testing.T) {
+	r := &recorder{}
+	free := int64(30 << 30)
+	s := &Scheduler{
+		Build:       r.build,
+		Parallel:    2,
+		MinFreeDisk: 20 << 30,
+		FreeDisk: func() (int64, error) {
+			free -= 10 << 30
+			return free + 10<<30, nil
+		},
+		LogDir: t.TempDir(),
+	}
+	results := s.Run(models("a", "b", "c"))
+	statuses := []string{}
+	for _, result := range results {
+		statuses = append(statuses, result.Status)
+	}
+	require.Equal(t, []string{StatusSucceeded, StatusSucceeded, StatusSkipped}, statuses, fmt.Sprint(results))
+	require.Contains(t, results[2].Error, "has to be free to start a build")
+}
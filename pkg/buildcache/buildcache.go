@@ -0,0 +1,336 @@
+// Package buildcache predicts which stages of a build will be Docker cache hits, by comparing
+// the sections of cog.yaml and the source files with those of the last successful build.
+package buildcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/dockercontext"
+	"github.com/replicate/cog/pkg/dockerignore"
+	"github.com/replicate/cog/pkg/global"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// Stages, in the order they appear in the generated Dockerfile. A change to one stage invalidates every stage after it.
+const (
+	StageBase           = "base"
+	StageSystemPackages = "system_packages"
+	StagePythonPackages = "python_packages"
+	StageRun            = "run"
+	StageSource         = "source"
+)
+
+// maxReasons is the number of changes listed for a stage before they are summarized
+const maxReasons = 10
+
+const manifestFile = "build_cache.json"
+
+// digestsFile caches the digests of source files, so only files whose size or modification time changed are hashed
+const digestsFile = "file_digests.json"
+
+// racyInterval is how recently a file can have been modified for its digest to not be cached, because it could be
+// modified again without its modification time changing, within the resolution of the filesystem's timestamps
+const racyInterval = 2 * time.Second
+
+// manifestPath returns where the stages of the last build of the project in dir are recorded. Each named environment
+// has its own, so switching between them doesn't predict cache misses.
+func manifestPath(dir string) string {
+	return filepath.Join(dir, config.CacheDir(dir), manifestFile)
+}
+
+// Stage is the digest of the inputs to one stage of the build
+type Stage struct {
+	Name   string            `json:"name"`
+	Digest string            `json:"digest"`
+	Inputs map[string]string `json:"inputs"`
+}
+
+// Manifest records the stages of the last successful build
+type Manifest struct {
+	Stages []Stage `json:"stages"`
+}
+
+// Result is the predicted cache status of a stage
+type Result struct {
+	Stage   string
+	Hit     bool
+	Reasons []string
+}
+
+// Stages hashes each section of cog.yaml that produces a stage of the build, and the source files in dir.
+// cfg must have been validated with ValidateAndComplete.
+func Stages(cfg *config.Config, dir string) ([]Stage, error) {
+	files, err := dockerignore.WalkContext(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read build context: %w", err)
+	}
+	return StagesForContext(cfg, dir, files)
+}
+
+// StagesForContext is Stages for the files in dir that have already been listed with dockerignore.WalkContext
+func StagesForContext(cfg *config.Config, dir string, files []dockerignore.ContextFile) ([]Stage, error) {
+	base := map[string]string{
+		"cog_version":    global.Version,
+		"language":       cfg.PredictorLanguage(),
+		"gpu":            fmt.Sprintf("%t", cfg.Build.GPU),
+		"python_version": cfg.Build.PythonVersion,
+		"cuda":           cfg.Build.CUDA,
+		"cudnn":          cfg.Build.CuDNN,
+	}
+
+	systemPackages := map[string]string{}
+	for _, pkg := range cfg.Build.SystemPackages {
+		systemPackages[pkg] = pkg
+	}
+	if cfg.Build.AptSnapshot != "" {
+		systemPackages["apt snapshot"] = cfg.Build.AptSnapshot
+	}
+	for _, repo := range cfg.Build.AptRepositories {
+		systemPackages["apt repository "+repo.Name] = strings.Join([]string{repo.Source, repo.KeyURL, repo.KeyFingerprint}, " ")
+	}
+
+	pythonPackages := map[string]string{}
+	for _, pkg := range cfg.Build.PythonRequirementsContent() {
+		name, err := config.PackageName(pkg)
+		if err != nil || name == "" {
+			name = pkg
+		}
+		pythonPackages[name] = pkg
+	}
+	for _, pkg := range cfg.Build.LocalPythonPackages() {
+		pythonPackages["local package "+pkg.Path] = fmt.Sprintf("editable: %t", pkg.Editable)
+	}
+	for _, extension := range cfg.Build.CUDAExtensions() {
+		pythonPackages["cuda extension "+extension] = extension
+	}
+
+	run := map[string]string{}
+	for i, step := range cfg.Build.Run {
+		command := strings.TrimSpace(step.Command)
+		if step.Network == config.NetworkNone {
+			command += " (network: none)"
+		}
+		run[fmt.Sprintf("step %d", i+1)] = command
+	}
+
+	source, err := sourceInputs(dir, files)
+	if err != nil {
+		return nil, err
+	}
+
+	return []Stage{
+		newStage(StageBase, base),
+		newStage(StageSystemPackages, systemPackages),
+		newStage(StagePythonPackages, pythonPackages),
+		newStage(StageRun, run),
+		newStage(StageSource, source),
+	}, nil
+}
+
+// Load reads the stages of the last successful build in dir, returning nil if there hasn't been one
+func Load(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(manifestPath(dir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		// A corrupt cache manifest only means we can't predict cache hits
+		return nil, nil
+	}
+	return m, nil
+}
+
+// Save records the stages of a successful build in dir
+func Save(dir string, stages []Stage) error {
+	path := manifestPath(dir)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(Manifest{Stages: stages})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// Compare predicts which stages will be cache hits, and explains why the others were invalidated
+func Compare(previous *Manifest, current []Stage) []Result {
+	results := []Result{}
+	invalidatedBy := ""
+	for _, stage := range current {
+		result := Result{Stage: stage.Name}
+		var prev *Stage
+		if previous != nil {
+			prev = previous.stage(stage.Name)
+		}
+		switch {
+		case previous == nil:
+			result.Reasons = []string{"no previous build"}
+		case invalidatedBy != "":
+			result.Reasons = []string{fmt.Sprintf("invalidated by changes to %s", invalidatedBy)}
+		case prev == nil:
+			result.Reasons = []string{"not in previous build"}
+		case prev.Digest != stage.Digest:
+			result.Reasons = diffInputs(stage.Name, prev.Inputs, stage.Inputs)
+		default:
+			result.Hit = true
+		}
+		if !result.Hit && invalidatedBy == "" {
+			invalidatedBy = stage.Name
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func (m *Manifest) stage(name string) *Stage {
+	for i := range m.Stages {
+		if m.Stages[i].Name == name {
+			return &m.Stages[i]
+		}
+	}
+	return nil
+}
+
+func newStage(name string, inputs map[string]string) Stage {
+	h := sha256.New()
+	for _, key := range sortedKeys(inputs) {
+		fmt.Fprintf(h, "%s\x00%s\x00", key, inputs[key])
+	}
+	return Stage{Name: name, Digest: hex.EncodeToString(h.Sum(nil)), Inputs: inputs}
+}
+
+// cachedDigest is the digest of a source file when it had a size and modification time
+type cachedDigest struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mod_time"`
+	Digest  string `json:"digest"`
+}
+
+// sourceInputs fingerprints each file sent to the build context by its contents, like Docker does for COPY, so
+// files that are checked out again or touched without changing aren't reported as modified. Files whose size and
+// modification time haven't changed since they were last hashed, like weights, aren't read again.
+func sourceInputs(dir string, files []dockerignore.ContextFile) (map[string]string, error) {
+	cachePath := filepath.Join(dir, config.CacheDir(dir), digestsFile)
+	cached := map[string]cachedDigest{}
+	if data, err := os.ReadFile(cachePath); err == nil {
+		// A corrupt cache only means every file is hashed
+		_ = json.Unmarshal(data, &cached)
+	}
+
+	inputs := map[string]string{}
+	digests := map[string]cachedDigest{}
+	changed := false
+	for _, f := range dockerignore.Included(files) {
+		if f.Path == dockercontext.CogBuildArtifactsFolder || strings.HasPrefix(f.Path, dockercontext.CogBuildArtifactsFolder+"/") {
+			continue
+		}
+		path := filepath.Join(dir, f.Path)
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		entry, ok := cached[f.Path]
+		if !ok || entry.Size != info.Size() || entry.ModTime != info.ModTime().UnixNano() {
+			digest, err := fileDigest(path)
+			if err != nil {
+				return nil, err
+			}
+			entry = cachedDigest{Size: info.Size(), ModTime: info.ModTime().UnixNano(), Digest: digest}
+			changed = true
+		}
+		inputs[f.Path] = entry.Digest
+		if time.Since(info.ModTime()) > racyInterval {
+			digests[f.Path] = entry
+		}
+	}
+
+	if changed || len(digests) != len(cached) {
+		if err := saveDigests(cachePath, digests); err != nil {
+			console.Debugf("Failed to cache the digests of source files in %s: %s", cachePath, err)
+		}
+	}
+	return inputs, nil
+}
+
+func saveDigests(path string, digests map[string]cachedDigest) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(digests)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func diffInputs(stage string, previous map[string]string, current map[string]string) []string {
+	reasons := []string{}
+	for _, key := range sortedKeys(current) {
+		old, ok := previous[key]
+		switch {
+		case !ok:
+			reasons = append(reasons, "added "+describeInput(stage, key, current[key]))
+		case old != current[key] && stage == StageSource:
+			reasons = append(reasons, "modified "+key)
+		case old != current[key]:
+			reasons = append(reasons, fmt.Sprintf("%s changed from %q to %q", key, old, current[key]))
+		}
+	}
+	for _, key := range sortedKeys(previous) {
+		if _, ok := current[key]; !ok {
+			reasons = append(reasons, "removed "+describeInput(stage, key, previous[key]))
+		}
+	}
+	if len(reasons) > maxReasons {
+		more := len(reasons) - maxReasons
+		reasons = append(reasons[:maxReasons], fmt.Sprintf("and %d more", more))
+	}
+	return reasons
+}
+
+func describeInput(stage string, key string, value string) string {
+	switch stage {
+	case StagePythonPackages, StageRun:
+		if value != key {
+			return fmt.Sprintf("%s (%s)", key, value)
+		}
+	}
+	return key
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,142 @@
+package buildcache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/dockerignore"
+)
+
+func testConfig(t *testing.T, yaml string) *config.Config {
+	t.Helper()
+	cfg, err := config.FromYAML([]byte(yaml))
+	require.NoError(t, err)
+	require.NoError(t, cfg.ValidateAndComplete(""))
+	return cfg
+}
+
+func TestCompare(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "predict.py"), []byte("print(1)"), 0o644))
+
+	cfg := testConfig(t, `
+build:
+  python_version: "3.12"
+  system_packages: [ffmpeg]
+  python_packages: ["torch==2.1.0"]
+  run: ["echo hello"]
+`)
+	stages, err := Stages(cfg, dir)
+	require.NoError(t, err)
+
+	previous, err := Load(dir)
+	require.NoError(t, err)
+	require.Nil(t, previous)
+	for _, r := range Compare(previous, stages) {
+		require.False(t, r.Hit)
+		require.Equal(t, []string{"no previous build"}, r.Reasons)
+	}
+
+	require.NoError(t, Save(dir, stages))
+	previous, err = Load(dir)
+	require.NoError(t, err)
+
+	// Saving the manifest into .cog doesn't change the source stage
+	stages, err = Stages(cfg, dir)
+	require.NoError(t, err)
+	for _, r := range Compare(previous, stages) {
+		require.True(t, r.Hit, r.Stage)
+	}
+
+	cfg = testConfig(t, `
+build:
+  python_version: "3.12"
+  system_packages: [ffmpeg]
+  python_packages: ["torch==2.2.0", "numpy"]
+  run: ["echo hello"]
+`)
+	stages, err = Stages(cfg, dir)
+	require.NoError(t, err)
+	results := Compare(previous, stages)
+	require.Equal(t, []Result{
+		{Stage: StageBase, Hit: true},
+		{Stage: StageSystemPackages, Hit: true},
+		{Stage: StagePythonPackages, Reasons: []string{"added numpy", `torch changed from "torch==2.1.0" to "torch==2.2.0"`}},
+		{Stage: StageRun, Reasons: []string{"invalidated by changes to python_packages"}},
+		{Stage: StageSource, Reasons: []string{"invalidated by changes to python_packages"}},
+	}, results)
+}
+
+func TestSourceInputsHashContents(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "predict.py")
+	require.NoError(t, os.WriteFile(path, []byte("print(1)"), 0o644))
+	source := func() map[string]string {
+		files, err := dockerignore.WalkContext(dir)
+		require.NoError(t, err)
+		inputs, err := sourceInputs(dir, files)
+		require.NoError(t, err)
+		return inputs
+	}
+	before := source()
+
+	// A checkout that rewrites the file with the same contents doesn't change it
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(path, later, later))
+	require.Equal(t, before, source())
+
+	// A change that keeps its size and modification time does
+	require.NoError(t, os.WriteFile(path, []byte("print(2)"), 0o644))
+	require.NoError(t, os.Chtimes(path, later, later))
+	require.NotEqual(t, before["predict.py"], source()["predict.py"])
+}
+
+func TestSourceInputsReuseDigests(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "weights.bin")
+	require.NoError(t, os.WriteFile(path, []byte("weights"), 0o644))
+	earlier := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(path, earlier, earlier))
+	source := func() map[string]string {
+		files, err := dockerignore.WalkContext(dir)
+		require.NoError(t, err)
+		inputs, err := sourceInputs(dir, files)
+		require.NoError(t, err)
+		return inputs
+	}
+	before := source()
+	require.FileExists(t, filepath.Join(dir, ".cog", digestsFile))
+
+	// A file with the same size and modification time isn't hashed again
+	require.NoError(t, os.WriteFile(path, []byte("WEIGHTS"), 0o644))
+	require.NoError(t, os.Chtimes(path, earlier, earlier))
+	require.Equal(t, before, source())
+
+	// but one whose size changed is
+	require.NoError(t, os.WriteFile(path, []byte("new weights"), 0o644))
+	require.NoError(t, os.Chtimes(path, earlier, earlier))
+	require.NotEqual(t, before["weights.bin"], source()["weights.bin"])
+}
+
+func TestDiffInputsSource(t *testing.T) {
+	reasons := diffInputs(StageSource,
+		map[string]string{"predict.py": "8:1", "old.py": "1:1"},
+		map[string]string{"predict.py": "9:2", "new.py": "1:1"},
+	)
+	require.Equal(t, []string{"added new.py", "modified predict.py", "removed old.py"}, reasons)
+}
+
+func TestDiffInputsTruncated(t *testing.T) {
+	current := map[string]string{}
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"} {
+		current[name] = name
+	}
+	reasons := diffInputs(StageSystemPackages, map[string]string{}, current)
+	require.Len(t, reasons, maxReasons+1)
+	require.Equal(t, "and 2 more", reasons[maxReasons])
+}
@@ -0,0 +1,142 @@
+package ci
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/global"
+)
+
+// GitHubWorkflowPath is where GitHubWorkflow is written in the project
+const GitHubWorkflowPath = ".github/workflows/cog.yaml"
+
+// imageVariable is the repository variable the workflow pushes to when cog.yaml doesn't set image
+const imageVariable = "COG_IMAGE"
+
+// GitHubWorkflow returns a GitHub Actions workflow that builds the model, checks it starts, scans the image for
+// vulnerabilities, and pushes it when main changes. Builds use the last pushed image as a cache, so unchanged
+// layers aren't rebuilt on fresh runners.
+func GitHubWorkflow(cfg *config.Config) (string, error) {
+	imageName := cfg.Image
+	registry := global.ReplicateRegistryHost
+	if imageName != "" {
+		ref, err := name.ParseReference(imageName)
+		if err != nil {
+			return "", fmt.Errorf("Failed to parse image %s in %s: %w", imageName, global.ConfigFilename, err)
+		}
+		registry = ref.Context().RegistryStr()
+	}
+
+	lines := []string{
+		"name: Cog",
+		"",
+		"on:",
+		"  push:",
+		"    branches:",
+		"      - main",
+		"  pull_request:",
+		"  workflow_dispatch:",
+		"",
+		"jobs:",
+		"  build:",
+		"    name: Build, test and push",
+		"    # If your model is large, the default runner may not have enough disk space. You can set up a bigger runner on GitHub.",
+		"    runs-on: ubuntu-latest",
+		"    env:",
+	}
+	if imageName != "" {
+		lines = append(lines, "      IMAGE: "+imageName)
+	} else {
+		lines = append(lines,
+			"      # Set the "+imageVariable+" repository variable to where the model is pushed, like r8.im/alice/bunny-detector",
+			"      IMAGE: ${{ vars."+imageVariable+" }}",
+		)
+	}
+	lines = append(lines,
+		"    steps:",
+		"      - name: Free disk space",
+		"        uses: jlumbroso/free-disk-space@v1.3.1",
+		"        with:",
+		"          tool-cache: false",
+		"          docker-images: false",
+		"",
+		"      - name: Checkout",
+		"        uses: actions/checkout@v4",
+		"",
+		"      - name: Setup Cog",
+		"        uses: replicate/setup-cog@v2",
+	)
+	if registry == global.ReplicateRegistryHost {
+		lines = append(lines,
+			"        with:",
+			"          # Add a CLI auth token from https://replicate.com/account/api-token to your repository secrets to push to Replicate",
+			"          token: ${{ secrets.REPLICATE_CLI_AUTH_TOKEN }}",
+		)
+	} else {
+		lines = append(lines,
+			"",
+			"      - name: Log in to "+registry,
+			"        uses: docker/login-action@v3",
+			"        with:",
+			"          registry: "+registry,
+			"          username: ${{ secrets.REGISTRY_USERNAME }}",
+			"          password: ${{ secrets.REGISTRY_PASSWORD }}",
+		)
+	}
+
+	buildArgs := []string{`-t "$IMAGE"`, `--cache-from "type=registry,ref=$IMAGE"`}
+	if cfg.Build.GPU {
+		// CUDA images are large, so it's worth splitting the weights out to cache them separately
+		buildArgs = append(buildArgs, "--separate-weights")
+	}
+	lines = append(lines,
+		"",
+		"      - name: Build",
+		"        run: cog build "+strings.Join(buildArgs, " "),
+		"",
+	)
+	lines = append(lines, testSteps(cfg)...)
+	lines = append(lines,
+		"      - name: Scan",
+		"        uses: aquasecurity/trivy-action@0.28.0",
+		"        with:",
+		"          image-ref: ${{ env.IMAGE }}",
+		"          severity: CRITICAL,HIGH",
+		"          ignore-unfixed: true",
+		"          # Report vulnerabilities without failing the build. Set this to \"1\" to fail it.",
+		`          exit-code: "0"`,
+		"",
+		"      - name: Push",
+		"        if: github.event_name != 'pull_request'",
+		"        run: cog push \"$IMAGE\" "+strings.Join(buildArgs[1:], " "),
+	)
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// testSteps checks the model starts by running it and waiting for its health check to be ready
+func testSteps(cfg *config.Config) []string {
+	if cfg.Build.GPU {
+		return []string{
+			"      # GitHub's hosted runners don't have GPUs, so the model isn't started here. To test it, run this job",
+			"      # on a GPU runner and add a step that runs `docker run --gpus all` and checks /health-check.",
+			"",
+		}
+	}
+	return []string{
+		"      - name: Test",
+		"        run: |",
+		`          docker run -d --name model -p 5000:5000 "$IMAGE"`,
+		"          for i in $(seq 1 60); do",
+		`            status=$(curl -fsS http://localhost:5000/health-check | jq -r .status || true)`,
+		`            if [ "$status" = READY ]; then exit 0; fi`,
+		`            if [ "$status" = SETUP_FAILED ]; then break; fi`,
+		"            sleep 5",
+		"          done",
+		"          docker logs model",
+		"          exit 1",
+		"",
+	}
+}
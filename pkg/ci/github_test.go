@@ -0,0 +1,89 @@
+package ci
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+
+	"github.com/replicate/cog/pkg/config"
+)
+
+type workflow struct {
+	Jobs map[string]struct {
+		Env   map[string]string `yaml:"env"`
+		Steps []struct {
+			Name string            `yaml:"name"`
+			Uses string            `yaml:"uses"`
+			Run  string            `yaml:"run"`
+			With map[string]string `yaml:"with"`
+		} `yaml:"steps"`
+	} `yaml:"jobs"`
+}
+
+func parseWorkflow(t *testing.T, contents string) (map[string]string, map[string]string, map[string]map[string]string) {
+	t.Helper()
+	var w workflow
+	require.NoError(t, yaml.Unmarshal([]byte(contents), &w))
+	job := w.Jobs["build"]
+	runs := map[string]string{}
+	with := map[string]map[string]string{}
+	for _, step := range job.Steps {
+		runs[step.Name] = step.Run
+		with[step.Name] = step.With
+	}
+	return job.Env, runs, with
+}
+
+func TestGitHubWorkflow(t *testing.T) {
+	cfg, err := config.FromYAML([]byte(`
+build:
+  python_version: "3.12"
+image: r8.im/alice/bunny-detector
+predict: predict.py:Predictor
+`))
+	require.NoError(t, err)
+
+	contents, err := GitHubWorkflow(cfg)
+	require.NoError(t, err)
+	env, runs, with := parseWorkflow(t, contents)
+	require.Equal(t, "r8.im/alice/bunny-detector", env["IMAGE"])
+	require.Equal(t, `cog build -t "$IMAGE" --cache-from "type=registry,ref=$IMAGE"`, runs["Build"])
+	require.Contains(t, runs["Test"], "/health-check")
+	require.Equal(t, `cog push "$IMAGE" --cache-from "type=registry,ref=$IMAGE"`, runs["Push"])
+	require.Equal(t, "${{ secrets.REPLICATE_CLI_AUTH_TOKEN }}", with["Setup Cog"]["token"])
+	require.Equal(t, "${{ env.IMAGE }}", with["Scan"]["image-ref"])
+}
+
+func TestGitHubWorkflowWithGPUAndOtherRegistry(t *testing.T) {
+	cfg, err := config.FromYAML([]byte(`
+build:
+  gpu: true
+  python_version: "3.12"
+image: ghcr.io/alice/bunny-detector
+predict: predict.py:Predictor
+`))
+	require.NoError(t, err)
+
+	contents, err := GitHubWorkflow(cfg)
+	require.NoError(t, err)
+	_, runs, with := parseWorkflow(t, contents)
+	require.Equal(t, `cog build -t "$IMAGE" --cache-from "type=registry,ref=$IMAGE" --separate-weights`, runs["Build"])
+	require.NotContains(t, runs, "Test")
+	require.Equal(t, "ghcr.io", with["Log in to ghcr.io"]["registry"])
+	require.Nil(t, with["Setup Cog"])
+}
+
+func TestGitHubWorkflowWithoutImage(t *testing.T) {
+	cfg, err := config.FromYAML([]byte(`
+build:
+  python_version: "3.12"
+predict: predict.py:Predictor
+`))
+	require.NoError(t, err)
+
+	contents, err := GitHubWorkflow(cfg)
+	require.NoError(t, err)
+	env, _, _ := parseWorkflow(t, contents)
+	require.Equal(t, "${{ vars.COG_IMAGE }}", env["IMAGE"])
+}
@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/global"
+	"github.com/replicate/cog/pkg/image"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+var (
+	adoptTag     string
+	adoptPredict string
+)
+
+func newAdoptCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "adopt IMAGE",
+		Short: "Make a Cog model from an image that wasn't built by Cog",
+		Long: `Make a Cog model from an image that wasn't built by Cog.
+
+Cog's HTTP server is installed in IMAGE, with the predictor in the current
+directory, like predict.py, copied to /src. The image's schema is generated from
+the predictor, or read from --openapi-schema, and Cog's labels are added, so it
+can be run with 'cog predict' and pushed with 'cog push' like any other model.
+
+IMAGE needs Python and pip. If there's a ` + global.ConfigFilename + `, the predictor is read from
+it, otherwise it's predict.py:Predictor, or --predict.`,
+		Example: `cog adopt my-legacy-model:1.2 --predict predict.py:Predictor -t my-model`,
+		Args:    cobra.ExactArgs(1),
+		RunE:    adopt,
+	}
+	cmd.Flags().StringVarP(&adoptTag, "tag", "t", "", "A name for the adopted image in the form 'repository:tag'")
+	cmd.Flags().StringVar(&adoptPredict, "predict", "", "The predictor, like predict.py:Predictor, if it isn't set in "+global.ConfigFilename)
+	addSchemaFlag(cmd)
+	addBuildProgressOutputFlag(cmd)
+
+	return cmd
+}
+
+func adopt(cmd *cobra.Command, args []string) error {
+	baseImage := args[0]
+	cfg, projectDir, err := adoptConfig()
+	if err != nil {
+		return err
+	}
+
+	imageName := cfg.Image
+	if adoptTag != "" {
+		imageName = adoptTag
+	}
+	if imageName == "" {
+		imageName = config.DockerImageName(projectDir)
+	}
+
+	if err := image.Adopt(cfg, projectDir, baseImage, imageName, buildSchemaFile, buildProgressOutput); err != nil {
+		return err
+	}
+	console.Infof("\nImage '%s' built from %s as a Cog model", imageName, baseImage)
+	return nil
+}
+
+// adoptConfig returns the config of the model being adopted, from cog.yaml if there is one, or with just a
+// predictor otherwise
+func adoptConfig() (*config.Config, string, error) {
+	projectDir, err := config.GetProjectDir(projectDirFlag)
+	if _, statErr := os.Stat(filepath.Join(projectDir, global.ConfigFilename)); err == nil && statErr == nil {
+		cfg, projectDir, err := config.GetConfig(projectDir)
+		if err != nil {
+			return nil, "", err
+		}
+		if adoptPredict != "" {
+			cfg.Predict = adoptPredict
+		}
+		if cfg.Predict == "" {
+			return nil, "", fmt.Errorf("Set the predictor to adopt the image with, with --predict or 'predict' in %s", global.ConfigFilename)
+		}
+		return cfg, projectDir, nil
+	}
+
+	projectDir, err = filepath.Abs(projectDirFlag)
+	if err != nil {
+		return nil, "", err
+	}
+	cfg := &config.Config{Build: &config.Build{}, Predict: "predict.py:Predictor"}
+	if adoptPredict != "" {
+		cfg.Predict = adoptPredict
+	}
+	if err := cfg.ValidateAndComplete(projectDir); err != nil {
+		return nil, "", err
+	}
+	return cfg, projectDir, nil
+}
@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/cog/pkg/api"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+var (
+	apiHost  = "127.0.0.1"
+	apiPort  = 8394
+	apiToken string
+)
+
+func newAPICommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "api",
+		Short: "Run a REST API for building, inspecting and running models on this host",
+		Long: `Run a REST API for building, inspecting and running models on this host.
+
+The API is described by the OpenAPI schema at /openapi.json. Requests have to pass the token
+set with --token or COG_API_TOKEN in an 'Authorization: Bearer' header. If it isn't set, one is
+generated and printed. Requests from web browsers aren't allowed.`,
+		RunE: cmdAPI,
+		Args: cobra.NoArgs,
+	}
+
+	cmd.Flags().StringVar(&apiHost, "host", apiHost, "Host on which to listen")
+	cmd.Flags().IntVarP(&apiPort, "port", "p", apiPort, "Port on which to listen")
+	cmd.Flags().StringVar(&apiToken, "token", "", "Token that requests have to pass. Defaults to COG_API_TOKEN")
+
+	return cmd
+}
+
+func cmdAPI(cmd *cobra.Command, args []string) error {
+	token := apiToken
+	if token == "" {
+		token = os.Getenv("COG_API_TOKEN")
+	}
+	if token == "" {
+		var err error
+		if token, err = generateAPIToken(); err != nil {
+			return err
+		}
+	}
+
+	addr := net.JoinHostPort(apiHost, fmt.Sprint(apiPort))
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           api.NewServer(os.Stderr, token).Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	console.Infof("Serving the Cog API on http://%s", addr)
+	return server.ListenAndServe()
+}
+
+// generateAPIToken returns a random token for a server that wasn't given one, and prints it so clients can use it
+func generateAPIToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("Failed to generate a token: %w", err)
+	}
+	token := hex.EncodeToString(b)
+	console.Infof("No token was set with --token or COG_API_TOKEN, so requests have to pass this one: %s", token)
+	return token, nil
+}
@@ -0,0 +1,158 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/cog/pkg/artifacts"
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/docker/command"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+var (
+	artifactsJSON bool
+	artifactType  string
+	pushAttach    []string
+)
+
+func newArtifactsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "artifacts",
+		Short: "List and attach artifacts like SBOMs and scan reports to pushed models",
+		Long: `List and attach artifacts like SBOMs and scan reports to pushed models.
+
+Artifacts are attached to the image's digest in its registry with the OCI referrers API,
+or the fallback tag scheme for registries that don't support it.`,
+	}
+
+	list := &cobra.Command{
+		Use:     "list IMAGE",
+		Short:   "List the artifacts attached to a pushed model",
+		Example: `cog artifacts list r8.im/your-username/hotdog-detector`,
+		Args:    cobra.ExactArgs(1),
+		RunE:    listArtifacts,
+	}
+	list.Flags().BoolVar(&artifactsJSON, "json", false, "Print the artifacts as JSON")
+
+	attach := &cobra.Command{
+		Use:     "attach IMAGE FILE --type TYPE",
+		Short:   "Attach a file to a pushed model",
+		Example: `cog artifacts attach r8.im/your-username/hotdog-detector sbom.spdx.json --type sbom`,
+		Args:    cobra.ExactArgs(2),
+		RunE:    attachArtifact,
+	}
+	attach.Flags().StringVar(&artifactType, "type", "", "The type of artifact: "+strings.Join(artifactTypeNames(), ", ")+", or a media type")
+	_ = attach.MarkFlagRequired("type")
+
+	cmd.AddCommand(list, attach)
+	return cmd
+}
+
+func addAttachFlag(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&pushAttach, "attach", []string{}, "Attach an artifact to the pushed image, in the form 'type=path', e.g. 'sbom=sbom.spdx.json'. Pass 'schema' to attach the model's OpenAPI schema")
+}
+
+func listArtifacts(cmd *cobra.Command, args []string) error {
+	list, err := artifacts.List(args[0])
+	if err != nil {
+		return err
+	}
+	if artifactsJSON {
+		data, err := json.MarshalIndent(list, "", "  ")
+		if err != nil {
+			return err
+		}
+		console.Output(string(data))
+		return nil
+	}
+	if len(list) == 0 {
+		console.Infof("No artifacts are attached to %s", args[0])
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DIGEST\tTYPE\tSIZE\tCREATED\tFILE")
+	for _, artifact := range list {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", artifact.Digest, artifact.ArtifactType, artifact.Size, artifact.Annotations[artifacts.AnnotationCreated], artifact.Annotations[artifacts.AnnotationFilename])
+	}
+	return w.Flush()
+}
+
+func attachArtifact(cmd *cobra.Command, args []string) error {
+	digest, err := attachFile(args[0], artifactType, args[1])
+	if err != nil {
+		return err
+	}
+	console.Infof("Attached %s to %s as %s", args[1], args[0], digest)
+	return nil
+}
+
+// attachArtifacts attaches the artifacts passed with --attach to imageName, after it's pushed
+func attachArtifacts(imageName string) error {
+	for _, attach := range pushAttach {
+		t, path, _ := strings.Cut(attach, "=")
+		var digest string
+		var err error
+		if path == "" {
+			if artifacts.ResolveType(t) != artifacts.TypeSchema {
+				return fmt.Errorf("Invalid --attach %q, it must be in the form 'type=path'", attach)
+			}
+			digest, err = attachSchema(imageName)
+		} else {
+			digest, err = attachFile(imageName, t, path)
+		}
+		if err != nil {
+			return err
+		}
+		console.Infof("Attached %s to %s as %s", attach, imageName, digest)
+	}
+	return nil
+}
+
+func attachFile(imageName string, t string, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read %s: %w", path, err)
+	}
+	digest, err := artifacts.Attach(imageName, artifacts.ResolveType(t), data, map[string]string{artifacts.AnnotationFilename: filepath.Base(path)})
+	if err != nil {
+		return "", err
+	}
+	return digest.String(), nil
+}
+
+// attachSchema attaches a snapshot of the OpenAPI schema in imageName's labels
+func attachSchema(imageName string) (string, error) {
+	inspect, err := docker.ImageInspect(imageName)
+	if err != nil {
+		return "", fmt.Errorf("Failed to inspect %s: %w", imageName, err)
+	}
+	schema := ""
+	if inspect.Config != nil {
+		schema = inspect.Config.Labels[command.CogOpenAPISchemaLabelKey]
+	}
+	if schema == "" {
+		return "", fmt.Errorf("%s doesn't have an OpenAPI schema to attach", imageName)
+	}
+	digest, err := artifacts.Attach(imageName, artifacts.TypeSchema, []byte(schema), map[string]string{artifacts.AnnotationFilename: "openapi.json"})
+	if err != nil {
+		return "", err
+	}
+	return digest.String(), nil
+}
+
+func artifactTypeNames() []string {
+	names := []string{}
+	for name := range artifacts.Types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
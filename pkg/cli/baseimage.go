@@ -20,6 +20,7 @@ var (
 	baseImageCUDAVersion   string
 	baseImagePythonVersion string
 	baseImageTorchVersion  string
+	baseImageResolveJSON   bool
 )
 
 func NewBaseImageRootCommand() (*cobra.Command, error) {
@@ -123,7 +124,7 @@ func newBaseImageBuildCommand() *cobra.Command {
 			}
 			baseImageName := dockerfile.BaseImageName(baseImageCUDAVersion, baseImagePythonVersion, baseImageTorchVersion)
 
-			err = docker.Build(cwd, dockerfileContents, baseImageName, []string{}, buildNoCache, buildProgressOutput, config.BuildSourceEpochTimestamp, dockercontext.StandardBuildDirectory, nil)
+			err = docker.Build(cwd, dockerfileContents, baseImageName, []string{}, nil, buildNoCache, buildProgressOutput, config.BuildSourceEpochTimestamp, dockercontext.StandardBuildDirectory, nil)
 			if err != nil {
 				return err
 			}
@@ -137,6 +138,63 @@ func newBaseImageBuildCommand() *cobra.Command {
 	return cmd
 }
 
+func newBaseImageResolveCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "resolve",
+		Short: "Show which base images a build of the model in the current directory would use, and why",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, projectDir, err := config.GetConfig(projectDirFlag)
+			if err != nil {
+				return err
+			}
+			generator, err := dockerfile.NewGenerator(cfg, projectDir, false, docker.NewDockerCommand(), false)
+			if err != nil {
+				return fmt.Errorf("Error creating Dockerfile generator: %w", err)
+			}
+			defer func() {
+				if err := generator.Cleanup(); err != nil {
+					console.Warnf("Error cleaning up Dockerfile generator: %s", err)
+				}
+			}()
+			generator.SetUseCudaBaseImage(buildUseCudaBaseImage)
+			if useCogBaseImage := DetermineUseCogBaseImage(cmd); useCogBaseImage != nil {
+				generator.SetUseCogBaseImage(*useCogBaseImage)
+			}
+
+			resolution, err := dockerfile.ResolveBaseImages(generator)
+			if err != nil {
+				return err
+			}
+			if baseImageResolveJSON {
+				output, err := json.MarshalIndent(resolution, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(output))
+				return nil
+			}
+			fmt.Println(resolution.String())
+			return nil
+		},
+		Args: cobra.MaximumNArgs(0),
+	}
+	addUseCudaBaseImageFlag(cmd)
+	addUseCogBaseImageFlag(cmd)
+	cmd.Flags().BoolVar(&baseImageResolveJSON, "json", false, "Print the result as JSON")
+
+	return cmd
+}
+
+// newBaseImageCommand is `cog base-image`, for the base image commands that are useful to people building models
+func newBaseImageCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "base-image",
+		Short: "Inspect the base images your model is built on",
+	}
+	cmd.AddCommand(newBaseImageResolveCommand())
+	return cmd
+}
+
 func addBaseImageFlags(cmd *cobra.Command) {
 	cmd.Flags().StringVar(&baseImageCUDAVersion, "cuda", "", "CUDA version")
 	cmd.Flags().StringVar(&baseImagePythonVersion, "python", "", "Python version")
@@ -0,0 +1,178 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/cog/pkg/benchmark"
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/predict"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+var (
+	benchmarkScenario      string
+	benchmarkSave          string
+	benchmarkCompare       string
+	benchmarkMaxRegression float64
+	benchmarkJSON          bool
+)
+
+func newBenchmarkCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "benchmark [image] --scenario FILE",
+		Short: "Replay a traffic scenario against a model and measure its latency and throughput",
+		Long: `Replay a traffic scenario against a model and measure its latency and throughput.
+
+The scenario file describes how the number of users ramps up and down, the inputs
+they send and how long they wait between requests. The results can be saved with
+--save, and compared with a previous run's with --compare. It exits with an error
+if throughput, error rate or latency got worse by more than --max-regression
+percent.
+
+If 'image' is passed, it benchmarks that Docker image. Otherwise, it builds the
+model in the current directory and benchmarks that.`,
+		Example: `  cog benchmark --scenario peak.yaml --save peak.json
+  cog benchmark --scenario peak.yaml --compare peak.json`,
+		RunE: cmdBenchmark,
+		Args: cobra.MaximumNArgs(1),
+	}
+
+	addUseCudaBaseImageFlag(cmd)
+	addUseCogBaseImageFlag(cmd)
+	addBuildProgressOutputFlag(cmd)
+	addDockerfileFlag(cmd)
+	addGpusFlag(cmd)
+	addSetupTimeoutFlag(cmd)
+	addFastFlag(cmd)
+	addLocalImage(cmd)
+	addSecurityFlags(cmd)
+	addMaxInputSizeFlag(cmd)
+
+	cmd.Flags().StringArrayVarP(&envFlags, "env", "e", []string{}, "Environment variables, in the form name=value")
+	cmd.Flags().StringVar(&benchmarkScenario, "scenario", "", "A YAML file describing the traffic to send to the model")
+	cmd.Flags().StringVar(&benchmarkSave, "save", "", "Save the results to this file as JSON, to compare with later runs")
+	cmd.Flags().StringVar(&benchmarkCompare, "compare", "", "Compare the results with a previous run's, saved with --save")
+	cmd.Flags().Float64Var(&benchmarkMaxRegression, "max-regression", 10, "How much worse, in percent, a metric can get compared with the previous run before it's a regression")
+	cmd.Flags().BoolVar(&benchmarkJSON, "json", false, "Print the results as JSON")
+	_ = cmd.MarkFlagRequired("scenario")
+
+	return cmd
+}
+
+func cmdBenchmark(cmd *cobra.Command, args []string) error {
+	scenario, err := benchmark.LoadScenario(benchmarkScenario)
+	if err != nil {
+		return err
+	}
+	var previous *benchmark.Result
+	if benchmarkCompare != "" {
+		if previous, err = benchmark.LoadResult(benchmarkCompare); err != nil {
+			return err
+		}
+	}
+
+	// Files in the scenario's inputs are relative to it. A scenario without inputs sends the model's defaults.
+	inputs := []predict.Inputs{{}}
+	if len(scenario.Inputs) > 0 {
+		inputs = nil
+		for i, mix := range scenario.Inputs {
+			mixInputs, err := parseInputFlags(relativeInputFlags(config.InputFlags(mix.Input), filepath.Dir(benchmarkScenario)))
+			if err != nil {
+				return fmt.Errorf("Failed to parse the inputs of %s: %w", scenario.InputName(i), err)
+			}
+			inputs = append(inputs, mixInputs)
+		}
+	}
+
+	var result *benchmark.Result
+	if err := withPredictor(cmd, args, func(predictor predict.Predictor, _ *config.Output) error {
+		console.Infof("Running scenario %s for %s...", scenario.Name, scenario.Duration())
+		result = benchmark.Run(cmd.Context(), scenario, func(ctx context.Context, input int) error {
+			prediction, err := predictor.Predict(inputs[input])
+			if err != nil {
+				return err
+			}
+			if prediction.Status == "failed" {
+				return fmt.Errorf("Prediction failed: %s", prediction.Error)
+			}
+			return nil
+		})
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if benchmarkSave != "" {
+		if err := result.Save(benchmarkSave); err != nil {
+			return err
+		}
+	}
+
+	var changes []benchmark.Change
+	if previous != nil {
+		changes = benchmark.Compare(previous, result, benchmarkMaxRegression)
+	}
+	if benchmarkJSON {
+		data, err := json.MarshalIndent(struct {
+			*benchmark.Result
+			Changes []benchmark.Change `json:"changes,omitempty"`
+		}{result, changes}, "", "  ")
+		if err != nil {
+			return err
+		}
+		console.Output(string(data))
+	} else {
+		printBenchmarkResult(result, previous, changes)
+	}
+
+	regressed := 0
+	for _, change := range changes {
+		if change.Regressed {
+			regressed++
+		}
+	}
+	if regressed > 0 {
+		return fmt.Errorf("%d metrics got more than %g%% worse than the previous run", regressed, benchmarkMaxRegression)
+	}
+	return nil
+}
+
+func printBenchmarkResult(result *benchmark.Result, previous *benchmark.Result, changes []benchmark.Change) {
+	console.Infof("Ran %s for %s with up to %d users", result.Scenario, time.Duration(result.Duration*float64(time.Second)).Round(time.Millisecond), result.PeakUsers)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "INPUT\tREQUESTS\tERRORS\tTHROUGHPUT\tMEAN\tP50\tP90\tP99\tMAX")
+	row := func(name string, stats benchmark.Stats) {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.2f/s\t%.0fms\t%.0fms\t%.0fms\t%.0fms\t%.0fms\n", name, stats.Requests, stats.Errors, stats.Throughput,
+			stats.Latency.Mean, stats.Latency.P50, stats.Latency.P90, stats.Latency.P99, stats.Latency.Max)
+	}
+	for _, input := range result.Inputs {
+		row(input.Name, input.Stats)
+	}
+	row("total", result.Stats)
+	w.Flush()
+
+	if previous == nil {
+		return
+	}
+	fmt.Println()
+	console.Infof("Compared with the run of %s at %s:", previous.Scenario, previous.Started.Local().Format(time.DateTime))
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "METRIC\tPREVIOUS\tCURRENT\tCHANGE\t")
+	for _, change := range changes {
+		regressed := ""
+		if change.Regressed {
+			regressed = "regressed"
+		}
+		fmt.Fprintf(w, "%s\t%.2f\t%.2f\t%+.1f%%\t%s\n", change.Metric, change.Previous, change.Current, change.Percent, regressed)
+	}
+	w.Flush()
+}
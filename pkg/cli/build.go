@@ -8,14 +8,19 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 
+	"github.com/replicate/cog/pkg/buildcache"
 	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/dockerfile"
+	"github.com/replicate/cog/pkg/hooks"
 	"github.com/replicate/cog/pkg/image"
+	"github.com/replicate/cog/pkg/triton"
 	"github.com/replicate/cog/pkg/util/console"
 )
 
 var buildTag string
 var buildSeparateWeights bool
 var buildSecrets []string
+var buildSSH []string
 var buildNoCache bool
 var buildProgressOutput string
 var buildSchemaFile string
@@ -26,6 +31,15 @@ var buildStrip bool
 var buildPrecompile bool
 var buildFast bool
 var buildLocalImage bool
+var buildCleanupDryRun bool
+var buildTriton bool
+var buildExplainCache bool
+var buildVerifyBase string
+var buildTarget string
+var buildStateFile string
+var buildEncryptWeights string
+var buildAlsoCPU bool
+var buildAnnotations map[string]string
 
 const useCogBaseImageFlagKey = "use-cog-base-image"
 
@@ -40,6 +54,10 @@ func newBuildCommand() *cobra.Command {
 	addBuildProgressOutputFlag(cmd)
 	addSecretsFlag(cmd)
 	addNoCacheFlag(cmd)
+	addCacheFromFlag(cmd)
+	addNoResolveFlag(cmd)
+	addNoDiskCheckFlag(cmd)
+	addIntrospectWithFlag(cmd)
 	addSeparateWeightsFlag(cmd)
 	addSchemaFlag(cmd)
 	addUseCudaBaseImageFlag(cmd)
@@ -50,7 +68,20 @@ func newBuildCommand() *cobra.Command {
 	addPrecompileFlag(cmd)
 	addFastFlag(cmd)
 	addLocalImage(cmd)
+	addVerifyBaseFlag(cmd)
+	addStateFileFlag(cmd)
+	addEncryptWeightsFlag(cmd)
+	cmd.Flags().BoolVar(&buildCleanupDryRun, "cleanup-dry-run", false, "Build without applying the cleanup rules in cog.yaml and report how many bytes each rule would save")
+	cmd.Flags().BoolVar(&config.BuildResume, "resume", false, "Resume the last build if it failed, skipping the images and stages it completed, as long as nothing has changed since")
+	cmd.Flags().BoolVar(&buildExplainCache, "explain-cache", false, "Explain why each build stage that won't be a cache hit was invalidated since the last build")
+	cmd.Flags().BoolVar(&buildAlsoCPU, "also-cpu", false, "Also build a CPU-only image named '<image>-cpu', with CPU torch wheels and no CUDA, for testing a GPU model on machines without GPUs")
+	cmd.Flags().BoolVar(&buildTriton, "triton", false, "Also package the model as a Triton Inference Server model repository in an image named '<image>-triton' that runs Triton, for models on Python 3.10 or 3.12")
 	cmd.Flags().StringVarP(&buildTag, "tag", "t", "", "A name for the built image in the form 'repository:tag'")
+	// Annotations are added by `cog builder serve` for the builds it runs. They're hidden because they're meant for
+	// the platforms that submit builds to it, rather than people.
+	cmd.Flags().StringToStringVar(&buildAnnotations, "annotation", nil, "Add an annotation to the image, as key=value")
+	_ = cmd.Flags().MarkHidden("annotation")
+	cmd.Flags().StringVar(&buildTarget, "target", "", "Only build this stage of the generated Dockerfile, such as 'deps' or 'weights'. The image is named '<image>-<target>' unless --tag is set")
 	return cmd
 }
 
@@ -76,12 +107,182 @@ func buildCommand(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	if err := image.Build(cfg, projectDir, imageName, buildSecrets, buildNoCache, buildSeparateWeights, buildUseCudaBaseImage, buildProgressOutput, buildSchemaFile, buildDockerfileFile, DetermineUseCogBaseImage(cmd), buildStrip, buildPrecompile, buildFast, nil, buildLocalImage); err != nil {
+	if buildEncryptWeights != "" && (buildFast || buildTarget != "") {
+		return fmt.Errorf("--encrypt-weights can't be used with fast builds or --target")
+	}
+
+	if buildAlsoCPU {
+		if !cfg.Build.GPU {
+			return fmt.Errorf("--also-cpu needs 'build.gpu: true' in cog.yaml, because the image is already CPU-only")
+		}
+		if buildTarget != "" || buildDockerfileFile != "" {
+			return fmt.Errorf("--also-cpu can't be used with --target or --dockerfile")
+		}
+	}
+
+	if err := verifyBaseImage(cmd, cfg, projectDir, buildFast); err != nil {
+		return err
+	}
+
+	if err := hooks.RunPreBuild(cfg, projectDir, imageName); err != nil {
 		return err
 	}
 
+	if buildTarget != "" {
+		return buildTargetCommand(cmd, cfg, projectDir, imageName)
+	}
+
+	cleanupRules, err := dockerfile.CleanupRules(cfg.Build.Cleanup)
+	if err != nil {
+		return err
+	}
+	if buildCleanupDryRun {
+		cfg.Build.Cleanup = nil
+	}
+
+	// The build cache is predicted from the stages of the generated Dockerfile, so builds from another Dockerfile
+	// aren't predicted or recorded
+	var stages []buildcache.Stage
+	if buildDockerfileFile == "" {
+		stages, err = buildcache.Stages(cfg, projectDir)
+		if err != nil {
+			return err
+		}
+		if !buildNoCache {
+			if err := reportBuildCache(projectDir, stages, buildExplainCache); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := image.Build(cfg, projectDir, imageName, buildSecrets, buildSSH, buildNoCache, buildSeparateWeights, buildUseCudaBaseImage, buildProgressOutput, buildSchemaFile, buildDockerfileFile, DetermineUseCogBaseImage(cmd), buildStrip, buildPrecompile, buildFast, buildAnnotations, buildLocalImage, stages); err != nil {
+		return err
+	}
+
+	if err := encryptWeights(projectDir, imageName); err != nil {
+		return err
+	}
+
+	if stages != nil {
+		if err := buildcache.Save(projectDir, stages); err != nil {
+			console.Warnf("Failed to save build cache manifest: %s", err)
+		}
+	}
+
+	if buildCleanupDryRun {
+		if err := reportCleanupSavings(imageName, cleanupRules); err != nil {
+			return err
+		}
+	}
+
 	console.Infof("\nImage built as %s", imageName)
+	recordVersion(projectDir, imageName, false)
+
+	if buildStateFile != "" {
+		if err := image.WriteState(buildStateFile, imageName, buildSeparateWeights); err != nil {
+			return err
+		}
+	}
+
+	if buildAlsoCPU {
+		if err := buildCPUImage(cmd, cfg, projectDir, imageName); err != nil {
+			return err
+		}
+	}
+
+	if buildTriton {
+		tritonImageName, err := triton.Build(cfg, projectDir, imageName, buildProgressOutput)
+		if err != nil {
+			return err
+		}
+		console.Infof("Triton image built as %s", tritonImageName)
+	}
+
+	return nil
+}
+
+// buildCPUImage builds the CPU-only sibling of a GPU image, for cog build --also-cpu. When weights are built
+// separately, it reuses the weights layers cached by the GPU image's build.
+func buildCPUImage(cmd *cobra.Command, cfg *config.Config, projectDir string, imageName string) error {
+	cpuImageName := config.CPUImageName(imageName)
+	console.Infof("\nBuilding CPU-only image %s...", cpuImageName)
+	if err := image.Build(cfg.CPUVariant(), projectDir, cpuImageName, buildSecrets, buildSSH, buildNoCache, buildSeparateWeights, buildUseCudaBaseImage, buildProgressOutput, buildSchemaFile, "", DetermineUseCogBaseImage(cmd), buildStrip, buildPrecompile, buildFast, nil, buildLocalImage, nil); err != nil {
+		return fmt.Errorf("Failed to build CPU-only image: %w", err)
+	}
+	if err := encryptWeights(projectDir, cpuImageName); err != nil {
+		return err
+	}
+	console.Infof("CPU-only image built as %s", cpuImageName)
+	return nil
+}
+
+// buildTargetCommand builds just one stage of the model's Dockerfile, for cog build --target
+func buildTargetCommand(cmd *cobra.Command, cfg *config.Config, projectDir string, imageName string) error {
+	if buildDockerfileFile != "" {
+		return fmt.Errorf("--target can't be used with --dockerfile. Pass --target to docker build instead.")
+	}
+	if buildFast {
+		return fmt.Errorf("--target can't be used with fast builds")
+	}
+	if buildTag == "" {
+		imageName += "-" + buildTarget
+	}
+	if err := image.BuildTarget(cfg, projectDir, imageName, buildTarget, buildSecrets, buildSSH, buildNoCache, buildUseCudaBaseImage, buildProgressOutput, DetermineUseCogBaseImage(cmd), buildStrip, buildPrecompile); err != nil {
+		return err
+	}
+	console.Infof("\nTarget %s built as %s", buildTarget, imageName)
+	return nil
+}
+
+// reportBuildCache predicts which build stages will be cache hits, based on the last successful build
+func reportBuildCache(projectDir string, stages []buildcache.Stage, explain bool) error {
+	previous, err := buildcache.Load(projectDir)
+	if err != nil {
+		return fmt.Errorf("Failed to read build cache manifest: %w", err)
+	}
+	results := buildcache.Compare(previous, stages)
 
+	summary := []string{}
+	for _, r := range results {
+		status := "cached"
+		if !r.Hit {
+			status = "rebuild"
+		}
+		summary = append(summary, fmt.Sprintf("%s (%s)", r.Stage, status))
+	}
+	console.Infof("Build stages: %s", strings.Join(summary, ", "))
+
+	if !explain {
+		return nil
+	}
+	for _, r := range results {
+		if r.Hit {
+			continue
+		}
+		console.Infof("%s will be rebuilt:", r.Stage)
+		for _, reason := range r.Reasons {
+			console.Infof("  - %s", reason)
+		}
+	}
+	return nil
+}
+
+func reportCleanupSavings(imageName string, rules []dockerfile.CleanupRule) error {
+	if len(rules) == 0 {
+		console.Info("No cleanup rules configured in cog.yaml")
+		return nil
+	}
+	console.Info("Measuring cleanup rules...")
+	savings, err := image.MeasureCleanup(imageName, rules)
+	if err != nil {
+		return fmt.Errorf("Failed to measure cleanup rules: %w", err)
+	}
+	var total int64
+	for _, s := range savings {
+		console.Infof("  %-10s %s", s.Rule, console.FormatBytes(s.Bytes))
+		total += s.Bytes
+	}
+	console.Infof("Cleanup would save %s in total", console.FormatBytes(total))
 	return nil
 }
 
@@ -95,16 +296,49 @@ func addBuildProgressOutputFlag(cmd *cobra.Command) {
 
 func addSecretsFlag(cmd *cobra.Command) {
 	cmd.Flags().StringArrayVar(&buildSecrets, "secret", []string{}, "Secrets to pass to the build environment in the form 'id=foo,src=/path/to/file'")
+	cmd.Flags().StringArrayVar(&buildSSH, "ssh", []string{}, "SSH agent socket or keys to forward to the build when build.ssh is enabled in cog.yaml, in the form 'default' or 'default=/path/to/key'")
 }
 
 func addNoCacheFlag(cmd *cobra.Command) {
 	cmd.Flags().BoolVar(&buildNoCache, "no-cache", false, "Do not use cache when building the image")
 }
 
+func addCacheFromFlag(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&config.BuildXCacheFrom, "cache-from", []string{}, "External cache sources for the build, in the same format as `docker buildx build --cache-from`, e.g. 'type=registry,ref=r8.im/your-username/hotdog-detector'")
+}
+
+func addIntrospectWithFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&config.BuildIntrospectWith, "introspect-with", "", "How to run the built image to get its schema: 'auto', 'cpu' to run it without GPUs, or a Docker host with GPUs like 'ssh://user@gpu-host'. Defaults to $"+image.IntrospectWithEnvVarName+", or 'auto'")
+}
+
+func addNoResolveFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&config.BuildSkipResolve, "no-resolve", false, "Don't check the Python requirements can be installed together with uv before building")
+}
+
+func addNoDiskCheckFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&config.BuildSkipDiskCheck, "no-disk-check", false, "Don't check there's enough disk space for the build before starting it")
+}
+
 func addSeparateWeightsFlag(cmd *cobra.Command) {
 	cmd.Flags().BoolVar(&buildSeparateWeights, "separate-weights", false, "Separate model weights from code in image layers")
 }
 
+func addStateFileFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&buildStateFile, "state-file", "", "Write the image's ID, digest, labels, schema hash and base images as JSON to this path, for tools like Terraform to pin what was built")
+}
+
+func addEncryptWeightsFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&buildEncryptWeights, "encrypt-weights", "", "Encrypt the model's weights in the image with the key in this file, or a KMS key like 'awskms://alias/models' or 'gcpkms://projects/.../cryptoKeys/...'")
+}
+
+// encryptWeights encrypts the weights in imageName if --encrypt-weights is set
+func encryptWeights(projectDir string, imageName string) error {
+	if buildEncryptWeights == "" {
+		return nil
+	}
+	return image.EncryptWeights(imageName, projectDir, buildEncryptWeights)
+}
+
 func addSchemaFlag(cmd *cobra.Command) {
 	cmd.Flags().StringVar(&buildSchemaFile, "openapi-schema", "", "Load OpenAPI schema from a file")
 }
@@ -169,6 +403,36 @@ func checkMutuallyExclusiveFlags(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func addVerifyBaseFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&buildVerifyBase, "verify-base", "", "Verify the signature of the base image before building, with 'cosign' (the default) or 'content-trust'")
+	cmd.Flags().Lookup("verify-base").NoOptDefVal = image.VerifyWithCosign
+}
+
+// verifyBaseImage verifies the signature of the base image a build would use, if --verify-base is set or
+// the COG_VERIFY_BASE policy requires it
+func verifyBaseImage(cmd *cobra.Command, cfg *config.Config, projectDir string, fast bool) error {
+	methods, err := image.BaseImageVerifications(buildVerifyBase)
+	if err != nil {
+		return err
+	}
+	if len(methods) == 0 {
+		return nil
+	}
+	if buildDockerfileFile != "" {
+		return fmt.Errorf("Base images can't be verified when building with --dockerfile")
+	}
+	if fast {
+		return fmt.Errorf("Base images can't be verified for fast builds")
+	}
+	pinned, err := image.VerifyBaseImage(cfg, projectDir, buildUseCudaBaseImage, DetermineUseCogBaseImage(cmd), methods)
+	if err != nil {
+		return err
+	}
+	// Build from the image that was verified, even if its tag has been moved since
+	cfg.Build.PinnedBaseImage = pinned
+	return nil
+}
+
 func DetermineUseCogBaseImage(cmd *cobra.Command) *bool {
 	if !cmd.Flags().Changed(useCogBaseImageFlagKey) {
 		return nil
@@ -0,0 +1,187 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/spf13/cobra"
+	"github.com/vbauerster/mpb/v8"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/replicate/cog/pkg/buildall"
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/dockercontext"
+	cogerrors "github.com/replicate/cog/pkg/errors"
+	"github.com/replicate/cog/pkg/global"
+	"github.com/replicate/cog/pkg/image"
+	"github.com/replicate/cog/pkg/util/console"
+	"github.com/replicate/cog/pkg/util/files"
+)
+
+var (
+	buildAllFile        string
+	buildAllParallel    int
+	buildAllCPUs        int
+	buildAllMinFreeDisk string
+	buildAllReport      string
+)
+
+func newBuildAllCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "build-all",
+		Short: "Build several models at once, from a list of them in a file",
+		Long: `Build several models at once, from a list of them in a file like models.yaml:
+
+    models:
+      - path: models/resnet
+        image: r8.im/my-org/resnet
+      - path: models/llama
+        image: r8.im/my-org/llama
+        args: ["--separate-weights"]
+        cpus: 8
+
+Each model is built with 'cog build' in its directory, with its output written
+to a log in .cog/build-all next to the file. The base images the models need are
+pulled first, once each, so models that share one don't pull it at the same
+time.
+
+Builds start in order, as long as fewer than --parallel are running, there are
+enough CPUs for them, and there's at least --min-free-disk free where Docker
+stores images. A summary of the builds is printed at the end.`,
+		Example: `  cog build-all -f models.yaml --parallel 4`,
+		RunE:    buildAllCommand,
+		Args:    cobra.NoArgs,
+	}
+	cmd.Flags().StringVarP(&buildAllFile, "file", "f", "models.yaml", "The file that lists the models to build")
+	cmd.Flags().IntVar(&buildAllParallel, "parallel", 2, "How many models to build at once")
+	cmd.Flags().IntVar(&buildAllCPUs, "cpus", runtime.NumCPU(), "How many CPUs the builds can use between them. A model's cpus in the file is how many its build uses, which defaults to this divided by --parallel")
+	cmd.Flags().StringVar(&buildAllMinFreeDisk, "min-free-disk", "20GB", "Don't start a build unless there's this much disk space free where Docker stores images, or 0 to not check")
+	cmd.Flags().StringVar(&buildAllReport, "report", "", "Write the result of each build to this file as JSON")
+	return cmd
+}
+
+func buildAllCommand(cmd *cobra.Command, args []string) error {
+	if buildAllParallel < 1 {
+		return fmt.Errorf("--parallel must be at least 1")
+	}
+	minFreeDisk, err := units.RAMInBytes(buildAllMinFreeDisk)
+	if err != nil || minFreeDisk < 0 {
+		return fmt.Errorf("--min-free-disk must be a size such as 20GB, got %q", buildAllMinFreeDisk)
+	}
+	manifest, err := buildall.Load(buildAllFile)
+	if err != nil {
+		return cogerrors.WithCategory(cogerrors.CategoryConfig, err)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("Failed to find the cog executable: %w", err)
+	}
+
+	pullBaseImages(manifest.Models)
+
+	scheduler := &buildall.Scheduler{
+		Build: func(model buildall.Model, log io.Writer) error {
+			return buildModel(executable, model, log)
+		},
+		Parallel:    buildAllParallel,
+		CPUs:        buildAllCPUs,
+		MinFreeDisk: minFreeDisk,
+		LogDir:      filepath.Join(filepath.Dir(buildAllFile), dockercontext.CogBuildArtifactsFolder, "build-all"),
+	}
+	if root, err := docker.RootDir(); err != nil {
+		console.Debugf("Failed to get Docker's root directory, so not checking its free space: %s", err)
+	} else if root != "" {
+		scheduler.FreeDisk = func() (int64, error) { return files.FreeSpace(root) }
+	}
+
+	start := time.Now()
+	results := scheduler.Run(manifest.Models)
+	console.Info("")
+	if err := buildall.WriteSummary(os.Stdout, results); err != nil {
+		return err
+	}
+	if buildAllReport != "" {
+		if err := buildall.WriteReport(buildAllReport, results); err != nil {
+			return err
+		}
+	}
+	if failed := buildall.Failed(results); failed > 0 {
+		return cogerrors.WithCategory(cogerrors.CategoryBuild, fmt.Errorf("%d of %d models weren't built", failed, len(results)))
+	}
+	console.Infof("Built %d models in %s", len(results), time.Since(start).Round(time.Second))
+	return nil
+}
+
+// pullBaseImages pulls the base images the models are built on, each one once, so builds of models that share a base
+// image don't all pull it. Images that can't be pulled are left for the builds to pull, so they fail with a useful
+// error.
+func pullBaseImages(models []buildall.Model) {
+	baseImages := []string{}
+	seen := map[string]bool{}
+	for _, model := range models {
+		cfg, dir, err := config.GetConfig(model.Path)
+		if err != nil {
+			console.Debugf("Failed to load %s for %s, so not pulling its base image: %s", global.ConfigFilename, model.Name, err)
+			continue
+		}
+		resolution, err := image.ResolveBaseImages(cfg, dir, "auto", nil)
+		if err != nil {
+			console.Debugf("Failed to work out the base image for %s: %s", model.Name, err)
+			continue
+		}
+		if seen[resolution.BaseImage] {
+			continue
+		}
+		seen[resolution.BaseImage] = true
+		if exists, err := docker.ImageExists(resolution.BaseImage); err == nil && exists {
+			continue
+		}
+		baseImages = append(baseImages, resolution.BaseImage)
+	}
+	if len(baseImages) == 0 {
+		return
+	}
+
+	console.Infof("Pulling %d base images...", len(baseImages))
+	var group errgroup.Group
+	group.SetLimit(buildAllParallel)
+	p := mpb.New(
+		mpb.WithRefreshRate(180 * time.Millisecond),
+	)
+	for _, baseImage := range baseImages {
+		group.Go(func() error {
+			if err := docker.PullWithProgress(baseImage, docker.BuildPlatform(), p); err != nil {
+				console.Debugf("%s", err)
+				_, _ = fmt.Fprintf(p, "Failed to pull %s, so builds that need it will pull it\n", baseImage)
+			}
+			return nil
+		})
+	}
+	_ = group.Wait()
+	p.Wait()
+}
+
+// buildModel builds model by running cog build in its directory, so each build has its own configuration
+func buildModel(executable string, model buildall.Model, log io.Writer) error {
+	args := buildall.Args(model)
+	if global.Debug {
+		args = append(args, "--debug")
+	}
+	_, _ = fmt.Fprintf(log, "$ %s (in %s)\n", model, model.Path)
+	cmd := exec.Command(executable, args...) //#nosec G204
+	cmd.Dir = model.Path
+	cmd.Env = append(os.Environ(), "COG_NO_UPDATE_CHECK=1")
+	cmd.Stdout = log
+	cmd.Stderr = log
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("cog build failed: %w", err)
+	}
+	return nil
+}
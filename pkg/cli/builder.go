@@ -0,0 +1,155 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/cog/pkg/api"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+var (
+	builderHost        = "127.0.0.1"
+	builderPort        = 8394
+	builderToken       string
+	builderUsersFile   string
+	builderConcurrency int
+	builderPerUser     int
+	builderProjectRoot string
+	builderImagePrefix string
+)
+
+func newBuilderCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "builder",
+		Short: "Run a build service that a team can share",
+	}
+
+	serve := &cobra.Command{
+		Use:   "serve",
+		Short: "Queue and run builds submitted over HTTP, on this host",
+		Long: `Queue and run builds submitted over HTTP, on this host.
+
+This serves /jobs endpoints for submitting builds to a queue, following their
+logs, and cancelling them. Users can only see and cancel their own builds. Builds
+share this host's Docker daemon, so base images and layers from earlier builds
+are reused.
+
+At most --concurrency builds run at once, and at most --per-user of them for
+each user, so one user submitting lots of builds doesn't hold up everyone else.
+
+Users are identified by tokens in the file passed with --users, which has a
+name and a token on each line:
+
+    alice 4f9c0d...
+    bob 81aa2e...
+
+Requests that pass --token instead can say who they're for in an X-Cog-User
+header, can see and cancel anyone's builds, and can pass secrets. If neither
+--token nor COG_API_TOKEN is set, and there's no --users, a token is generated
+and printed.
+
+Only projects in --project-root can be built, and builds can't use files outside
+it through includes or build.python_requirements. Users' builds also can't use
+files outside it through build.contexts, or use the host's SSH agent.
+
+Users' images are named --image-prefix, then their name and a slash, like
+registry.example.com/models/alice/my-model, so they can't replace each other's
+images, or others on this host. If a build doesn't say what to name its image,
+and the image in cog.yaml isn't named like that, it's named after the project.`,
+		Example: `  cog builder serve --host 0.0.0.0 --users users.txt --concurrency 4`,
+		RunE:    cmdBuilderServe,
+		Args:    cobra.NoArgs,
+	}
+	serve.Flags().StringVar(&builderHost, "host", builderHost, "Host on which to listen")
+	serve.Flags().IntVarP(&builderPort, "port", "p", builderPort, "Port on which to listen")
+	serve.Flags().StringVar(&builderToken, "token", "", "Token that requests have to pass, if they don't pass a user's. Defaults to COG_API_TOKEN")
+	serve.Flags().StringVar(&builderUsersFile, "users", "", "File with the name and token of a user on each line")
+	serve.Flags().IntVar(&builderConcurrency, "concurrency", 2, "How many builds to run at once")
+	serve.Flags().IntVar(&builderPerUser, "per-user", 1, "How many builds to run at once for each user")
+	serve.Flags().StringVar(&builderProjectRoot, "project-root", ".", "The directory that the projects to build have to be in")
+	serve.Flags().StringVar(&builderImagePrefix, "image-prefix", "", "What the names of users' images start with, before their name, like registry.example.com/models/")
+
+	cmd.AddCommand(serve)
+	return cmd
+}
+
+func cmdBuilderServe(cmd *cobra.Command, args []string) error {
+	if builderConcurrency < 1 || builderPerUser < 1 {
+		return fmt.Errorf("--concurrency and --per-user must be at least 1")
+	}
+	token := builderToken
+	if token == "" {
+		token = os.Getenv("COG_API_TOKEN")
+	}
+	var err error
+	users := map[string]string{}
+	if builderUsersFile != "" {
+		if users, err = readBuilderUsers(builderUsersFile); err != nil {
+			return err
+		}
+	}
+	if token == "" && len(users) == 0 {
+		if token, err = generateAPIToken(); err != nil {
+			return err
+		}
+	}
+	projectRoot, err := filepath.EvalSymlinks(builderProjectRoot)
+	if err == nil {
+		projectRoot, err = filepath.Abs(projectRoot)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to find --project-root %s: %w", builderProjectRoot, err)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("Failed to find the cog executable: %w", err)
+	}
+
+	queue := api.NewBuildQueue(builderConcurrency, builderPerUser, api.CommandRunner(executable))
+	addr := net.JoinHostPort(builderHost, fmt.Sprint(builderPort))
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           api.NewBuilderServer(os.Stderr, token, users, queue, projectRoot, builderImagePrefix).Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	console.Infof("Serving the Cog build service on http://%s, running %d builds at once of projects in %s", addr, builderConcurrency, projectRoot)
+	return server.ListenAndServe()
+}
+
+// readBuilderUsers reads a file with a user's name and token on each line, and returns a map of tokens to names
+func readBuilderUsers(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read users from %s: %w", path, err)
+	}
+	defer f.Close()
+	users := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Line %d of %s should be a name and a token, separated by a space", line, path)
+		}
+		if _, ok := users[fields[1]]; ok {
+			return nil, fmt.Errorf("The token on line %d of %s is used by more than one user", line, path)
+		}
+		users[fields[1]] = fields[0]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to read users from %s: %w", path, err)
+	}
+	return users, nil
+}
@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/envcheck"
+	"github.com/replicate/cog/pkg/image"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+var (
+	checkEnvFile string
+	checkJSON    bool
+)
+
+func newCheckCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "check IMAGE --env FILE",
+		Short: "Check a model image is compatible with the environment it'll be deployed to",
+		Long: `Check a model image is compatible with the environment it'll be deployed to.
+
+The environment file declares what the machines the model will run on have:
+
+  arch: amd64
+  gpu: NVIDIA A100-SXM4-80GB
+  gpu_count: 8
+  driver: 535.104.05
+
+The image's architecture, CUDA version and GPU count, from build.gpu_count in cog.yaml,
+are compared against it. It exits with an error if the model won't run there.`,
+		Example: `  cog check r8.im/someone/some-model --env k8s-node.yaml`,
+		RunE:    check,
+		Args:    cobra.ExactArgs(1),
+	}
+	cmd.Flags().StringVar(&checkEnvFile, "env", "", "A YAML file declaring the target environment's arch, gpu, gpu_count and driver")
+	cmd.Flags().BoolVar(&checkJSON, "json", false, "Print the problems as JSON")
+	_ = cmd.MarkFlagRequired("env")
+
+	return cmd
+}
+
+func check(cmd *cobra.Command, args []string) error {
+	imageName := args[0]
+	env, err := envcheck.LoadEnvironment(checkEnvFile)
+	if err != nil {
+		return err
+	}
+
+	exists, err := docker.ImageExists(imageName)
+	if err != nil {
+		return fmt.Errorf("Failed to determine if %s exists: %w", imageName, err)
+	}
+	if !exists {
+		console.Infof("Pulling image: %s", imageName)
+		if err := docker.Pull(imageName); err != nil {
+			return fmt.Errorf("Failed to pull %s: %w", imageName, err)
+		}
+	}
+	inspect, err := docker.ImageInspect(imageName)
+	if err != nil {
+		return fmt.Errorf("Failed to inspect %s: %w", imageName, err)
+	}
+	conf, err := image.GetConfig(imageName)
+	if err != nil {
+		return err
+	}
+
+	problems := envcheck.Check(envcheck.NewImage(inspect.Architecture, conf), *env)
+	if checkJSON {
+		output, err := json.MarshalIndent(problems, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(output))
+	} else if len(problems) == 0 {
+		console.Infof("%s is compatible with %s", imageName, checkEnvFile)
+	} else {
+		for _, p := range problems {
+			if p.Severity == envcheck.SeverityError {
+				console.Error(p.Message)
+			} else {
+				console.Warn(p.Message)
+			}
+		}
+	}
+	if envcheck.HasErrors(problems) {
+		return fmt.Errorf("%s isn't compatible with %s", imageName, checkEnvFile)
+	}
+	return nil
+}
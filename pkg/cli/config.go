@@ -0,0 +1,180 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/global"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+var configMigrateDryRun bool
+
+func newConfigCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Manage " + global.ConfigFilename,
+	}
+
+	migrate := &cobra.Command{
+		Use:   "migrate",
+		Short: "Update " + global.ConfigFilename + " to the current version of its format",
+		Long: `Update ` + global.ConfigFilename + ` to the current version of its format.
+
+Older versions of ` + global.ConfigFilename + ` are migrated in memory when they're loaded, with a
+warning for each deprecated field. This rewrites the file in place, so the
+warnings go away, and sets 'version' so later versions of Cog know what format
+it's in. Deprecated python_packages are moved to requirements.txt. Check the
+result with --dry-run first.`,
+		Example: `  cog config migrate --dry-run`,
+		Args:    cobra.NoArgs,
+		RunE:    cmdConfigMigrate,
+	}
+	migrate.Flags().BoolVar(&configMigrateDryRun, "dry-run", false, "Print the migrated "+global.ConfigFilename+" instead of writing it")
+
+	get := &cobra.Command{
+		Use:   "get KEY",
+		Short: "Print a value from " + global.ConfigFilename,
+		Long: `Print a value from ` + global.ConfigFilename + `, as Cog sees it.
+
+KEY is a path of fields separated by dots, with list items like build.run[0].
+Fields that aren't set print their default value. Strings and numbers are
+printed as they are, and lists and maps as YAML.`,
+		Example: `  cog config get build.gpu
+  cog config get build.run[0].command`,
+		Args: cobra.ExactArgs(1),
+		RunE: cmdConfigGet,
+	}
+
+	set := &cobra.Command{
+		Use:   "set KEY VALUE",
+		Short: "Set a value in " + global.ConfigFilename,
+		Long: `Set a value in ` + global.ConfigFilename + `.
+
+VALUE is parsed as the type of the field, so 3.10 stays a string for
+build.python_version. Lists and maps are YAML, like '[ffmpeg, git]'. Nothing is
+written unless the result is a valid ` + global.ConfigFilename + `.
+
+Only ` + global.ConfigFilename + ` itself is changed, not the files it includes. The rest of it,
+and its comments, are kept.`,
+		Example: `  cog config set build.python_version 3.11
+  cog config set build.system_packages '[ffmpeg, git]'`,
+		Args: cobra.ExactArgs(2),
+		RunE: cmdConfigSet,
+	}
+
+	render := &cobra.Command{
+		Use:   "render",
+		Short: "Print " + global.ConfigFilename + " as Cog sees it",
+		Long: `Print ` + global.ConfigFilename + ` as Cog sees it, with the files it includes merged into it
+and migrated to the current version of its format.`,
+		Args: cobra.NoArgs,
+		RunE: cmdConfigRender,
+	}
+
+	cmd.AddCommand(get, migrate, render, set)
+	return cmd
+}
+
+func cmdConfigGet(cmd *cobra.Command, args []string) error {
+	cfg, _, err := config.GetConfig(projectDirFlag)
+	if err != nil {
+		return err
+	}
+	value, err := cfg.Lookup(args[0])
+	if err != nil {
+		return err
+	}
+	switch value.(type) {
+	case string, bool, int, float64:
+		console.Output(fmt.Sprint(value))
+		return nil
+	}
+	out, err := yaml.Marshal(value)
+	if err != nil {
+		return err
+	}
+	console.Output(strings.TrimSuffix(string(out), "\n"))
+	return nil
+}
+
+func cmdConfigSet(cmd *cobra.Command, args []string) error {
+	projectDir, err := config.GetProjectDir(projectDirFlag)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(projectDir, global.ConfigFilename)
+	contents, err := config.SetKey(projectDir, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, contents, 0o644); err != nil {
+		return fmt.Errorf("Failed to write %s: %w", global.ConfigFilename, err)
+	}
+	console.Infof("Set %s to %s", args[0], args[1])
+	return nil
+}
+
+func cmdConfigRender(cmd *cobra.Command, args []string) error {
+	projectDir, err := config.GetProjectDir(projectDirFlag)
+	if err != nil {
+		return err
+	}
+	contents, err := config.ResolvedYAML(projectDir)
+	if err != nil {
+		return err
+	}
+	console.Output(strings.TrimSuffix(string(contents), "\n"))
+	return nil
+}
+
+func cmdConfigMigrate(cmd *cobra.Command, args []string) error {
+	projectDir, err := config.GetProjectDir(projectDirFlag)
+	if err != nil {
+		return err
+	}
+	result, err := config.MigrateFile(projectDir)
+	if err != nil {
+		return err
+	}
+	if result.From == config.CurrentVersion {
+		console.Infof("%s is already version %d", global.ConfigFilename, config.CurrentVersion)
+		return nil
+	}
+
+	console.Infof("Migrating %s from version %d to %d", global.ConfigFilename, result.From, result.To)
+	for _, change := range result.Changes {
+		console.Infof("  - %s", change)
+	}
+	files := make([]string, 0, len(result.Files))
+	for name := range result.Files {
+		files = append(files, name)
+	}
+	sort.Strings(files)
+
+	if configMigrateDryRun {
+		for _, name := range files {
+			console.Infof("Would write %s:", name)
+			console.Output(string(result.Files[name]))
+		}
+		console.Infof("Would write %s:", global.ConfigFilename)
+		console.Output(string(result.Config))
+		return nil
+	}
+
+	if err := config.WriteMigration(projectDir, result); err != nil {
+		return fmt.Errorf("Failed to write the migrated %s: %w", global.ConfigFilename, err)
+	}
+	for _, name := range files {
+		console.Infof("Wrote %s", name)
+	}
+	console.Infof("Wrote %s", global.ConfigFilename)
+	return nil
+}
@@ -21,7 +21,27 @@ func newDebugCommand() *cobra.Command {
 		Short:  "Generate a Dockerfile from " + global.ConfigFilename,
 		RunE:   cmdDockerfile,
 	}
+	addDebugDockerfileFlags(cmd)
 
+	cmd.AddCommand(newDebugDockerfileCommand())
+	cmd.AddCommand(newDebugContextCommand())
+	cmd.AddCommand(newDebugDockerignoreCommand())
+
+	return cmd
+}
+
+func newDebugDockerfileCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dockerfile",
+		Short: "Generate a Dockerfile from " + global.ConfigFilename,
+		Args:  cobra.NoArgs,
+		RunE:  cmdDockerfile,
+	}
+	addDebugDockerfileFlags(cmd)
+	return cmd
+}
+
+func addDebugDockerfileFlags(cmd *cobra.Command) {
 	addSeparateWeightsFlag(cmd)
 	addUseCudaBaseImageFlag(cmd)
 	addDockerfileFlag(cmd)
@@ -30,8 +50,6 @@ func newDebugCommand() *cobra.Command {
 	addFastFlag(cmd)
 	addLocalImage(cmd)
 	cmd.Flags().StringVarP(&imageName, "image-name", "", "", "The image name to use for the generated Dockerfile")
-
-	return cmd
 }
 
 func cmdDockerfile(cmd *cobra.Command, args []string) error {
@@ -0,0 +1,111 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/dockerignore"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+var debugContextSortBySize bool
+
+func newDebugContextCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "context",
+		Short: "List the files that will be sent to the Docker build context",
+		Long: `List the files that will be sent to the Docker build context, along with their sizes.
+
+Files excluded by .dockerignore are not listed. Use 'cog debug dockerignore' to see why a file was excluded.`,
+		Args: cobra.NoArgs,
+		RunE: cmdDebugContext,
+	}
+	cmd.Flags().BoolVar(&debugContextSortBySize, "sort-by-size", false, "Sort files by size, largest first")
+	return cmd
+}
+
+func newDebugDockerignoreCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dockerignore",
+		Short: "Show the effective .dockerignore rules and which rule excluded each path",
+		Args:  cobra.NoArgs,
+		RunE:  cmdDebugDockerignore,
+	}
+	return cmd
+}
+
+func cmdDebugContext(cmd *cobra.Command, args []string) error {
+	_, projectDir, err := config.GetConfig(projectDirFlag)
+	if err != nil {
+		return err
+	}
+	files, err := dockerignore.WalkContext(projectDir)
+	if err != nil {
+		return fmt.Errorf("Failed to read build context: %w", err)
+	}
+	included := dockerignore.Included(files)
+	if debugContextSortBySize {
+		sort.SliceStable(included, func(i, j int) bool { return included[i].Size > included[j].Size })
+	}
+
+	var total int64
+	for _, f := range included {
+		total += f.Size
+		console.Output(fmt.Sprintf("%10s  %s", console.FormatBytes(f.Size), f.Path))
+	}
+	console.Output(fmt.Sprintf("%10s  total (%d files)", console.FormatBytes(total), len(included)))
+	return nil
+}
+
+func cmdDebugDockerignore(cmd *cobra.Command, args []string) error {
+	_, projectDir, err := config.GetConfig(projectDirFlag)
+	if err != nil {
+		return err
+	}
+	rules, err := dockerignore.Rules(projectDir)
+	if err != nil {
+		return fmt.Errorf("Failed to read .dockerignore: %w", err)
+	}
+	if len(rules) == 0 {
+		console.Output("No .dockerignore rules, all files are sent to the build context")
+		return nil
+	}
+
+	console.Output("Rules:")
+	for _, rule := range rules {
+		console.Output(fmt.Sprintf("  %4d  %s", rule.LineNo, formatRule(rule)))
+	}
+
+	files, err := dockerignore.WalkContext(projectDir)
+	if err != nil {
+		return fmt.Errorf("Failed to read build context: %w", err)
+	}
+	console.Output("")
+	console.Output("Excluded:")
+	excluded := 0
+	for _, f := range files {
+		if f.Rule == nil {
+			continue
+		}
+		excluded++
+		path := f.Path
+		if f.Dir {
+			path += "/"
+		}
+		console.Output(fmt.Sprintf("  %s (line %d: %s)", path, f.Rule.LineNo, formatRule(*f.Rule)))
+	}
+	if excluded == 0 {
+		console.Output("  (nothing)")
+	}
+	return nil
+}
+
+func formatRule(rule dockerignore.Rule) string {
+	if rule.Negate {
+		return "!" + rule.Pattern
+	}
+	return rule.Pattern
+}
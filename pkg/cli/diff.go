@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/cog/pkg/image"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+var diffJSON bool
+
+func newDiffCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "diff IMAGE_A IMAGE_B",
+		Short: "Show what's different between two models",
+		Long: `Show what's different between two models, like two versions of one.
+
+The images are compared by their Python packages, their cog.yaml, their OpenAPI
+schema, their other labels, which layers they share, and the files in /src.
+Images are read from the local Docker daemon if they're there, or fetched from
+their registry if they aren't.`,
+		Example: `cog diff r8.im/your-username/hotdog-detector:v41 r8.im/your-username/hotdog-detector:v42`,
+		RunE:    diffImages,
+		Args:    cobra.ExactArgs(2),
+	}
+	cmd.Flags().BoolVar(&diffJSON, "json", false, "Print the differences as JSON")
+
+	return cmd
+}
+
+func diffImages(cmd *cobra.Command, args []string) error {
+	diff, err := image.Diff(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	if diffJSON {
+		data, err := json.MarshalIndent(diff, "", "  ")
+		if err != nil {
+			return err
+		}
+		console.Output(string(data))
+		return nil
+	}
+	return image.WriteDiff(os.Stdout, diff)
+}
@@ -0,0 +1,142 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/global"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+func newEnvCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "env",
+		Short: "List and select named environments of the project",
+		Long: `List and select named environments of the project.
+
+Each named environment has its own image, like cog-hotdog-detector-cuda12, and its
+own build cache and weights manifest in .cog/envs/<name>, so you can switch between
+variants of a model, like experiment branches, without them clobbering each other's
+caches.
+
+Select an environment for one command with --environment or $` + config.EnvironmentEnvVar + `,
+or for every command in the project with 'cog env use'.`,
+		Example: `  cog env use cuda12
+  cog --environment cuda11 build`,
+		RunE: listEnvs,
+		Args: cobra.NoArgs,
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List the project's environments",
+		Args:  cobra.NoArgs,
+		RunE:  listEnvs,
+	}
+	use := &cobra.Command{
+		Use:   "use NAME",
+		Short: "Select the environment to use in the project. 'default' selects the default one",
+		Args:  cobra.ExactArgs(1),
+		RunE:  useEnv,
+	}
+	rm := &cobra.Command{
+		Use:   "rm NAME",
+		Short: "Remove an environment's caches",
+		Args:  cobra.ExactArgs(1),
+		RunE:  rmEnv,
+	}
+
+	cmd.AddCommand(list, use, rm)
+	return cmd
+}
+
+// validateEnvironment returns an error if the environment selected with --environment or COG_ENV isn't valid
+func validateEnvironment() error {
+	if global.Environment != "" {
+		return config.ValidateEnvironmentName(global.Environment)
+	}
+	if name := os.Getenv(config.EnvironmentEnvVar); name != "" {
+		if err := config.ValidateEnvironmentName(name); err != nil {
+			return fmt.Errorf("Invalid $%s: %w", config.EnvironmentEnvVar, err)
+		}
+	}
+	return nil
+}
+
+func listEnvs(cmd *cobra.Command, args []string) error {
+	projectDir, err := config.GetProjectDir(projectDirFlag)
+	if err != nil {
+		return err
+	}
+	names, err := config.Environments(projectDir)
+	if err != nil {
+		return fmt.Errorf("Failed to list environments: %w", err)
+	}
+	current := config.Environment(projectDir)
+	if current != "" && !slices.Contains(names, current) {
+		// Selected with --environment or COG_ENV, but nothing has been cached in it yet
+		names = append(names, current)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\tENVIRONMENT\tIMAGE\tCACHE")
+	for _, name := range append([]string{config.DefaultEnvironment}, names...) {
+		selected := ""
+		if name == current || (current == "" && name == config.DefaultEnvironment) {
+			selected = "*"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", selected, name, config.DockerImageNameForEnvironment(projectDir, name), config.EnvironmentDir(name))
+	}
+	return w.Flush()
+}
+
+func useEnv(cmd *cobra.Command, args []string) error {
+	projectDir, err := config.GetProjectDir(projectDirFlag)
+	if err != nil {
+		return err
+	}
+	name := args[0]
+	if err := config.UseEnvironment(projectDir, name); err != nil {
+		return err
+	}
+	console.Infof("Using the %s environment, with the image %s", name, config.DockerImageNameForEnvironment(projectDir, name))
+	if global.Environment != "" || os.Getenv(config.EnvironmentEnvVar) != "" {
+		console.Warnf("--environment and $%s take precedence over the environment selected with 'cog env use'", config.EnvironmentEnvVar)
+	}
+	return nil
+}
+
+func rmEnv(cmd *cobra.Command, args []string) error {
+	projectDir, err := config.GetProjectDir(projectDirFlag)
+	if err != nil {
+		return err
+	}
+	name := args[0]
+	if err := config.ValidateEnvironmentName(name); err != nil {
+		return err
+	}
+	if name == config.DefaultEnvironment {
+		return fmt.Errorf("The default environment can't be removed")
+	}
+	dir := filepath.Join(projectDir, config.EnvironmentDir(name))
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return fmt.Errorf("There's no %s environment in this project", name)
+	}
+	if config.UsedEnvironment(projectDir) == name {
+		if err := config.UseEnvironment(projectDir, config.DefaultEnvironment); err != nil {
+			return err
+		}
+		console.Infof("Using the default environment")
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("Failed to remove the caches of %s: %w", name, err)
+	}
+	console.Infof("Removed the caches of %s. Its image, %s, is still there. Remove it with 'docker rmi'.", name, config.DockerImageNameForEnvironment(projectDir, name))
+	return nil
+}
@@ -0,0 +1,65 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/replicate/cog/pkg/docker"
+	cogerrors "github.com/replicate/cog/pkg/errors"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+const (
+	errorFormatText = "text"
+	errorFormatJSON = "json"
+)
+
+var errorFormatFlag string
+
+// errorReport is how errors are written with --error-format json
+type errorReport struct {
+	Error struct {
+		Category cogerrors.Category `json:"category"`
+		ExitCode int                `json:"exit_code"`
+		Message  string             `json:"message"`
+	} `json:"error"`
+}
+
+// ReportError writes err to stderr, in the format set with --error-format, and returns the code to exit with for its
+// category
+func ReportError(err error) int {
+	category := errorCategory(err)
+	if errorFormatFlag == errorFormatJSON {
+		report := errorReport{}
+		report.Error.Category = category
+		report.Error.ExitCode = category.ExitCode()
+		report.Error.Message = err.Error()
+		data, marshalErr := json.Marshal(report)
+		if marshalErr == nil {
+			fmt.Fprintln(os.Stderr, string(data))
+			return category.ExitCode()
+		}
+	}
+	console.Error(err.Error())
+	return category.ExitCode()
+}
+
+func errorCategory(err error) cogerrors.Category {
+	if docker.IsUnavailable(err) {
+		return cogerrors.CategoryDockerUnavailable
+	}
+	category := cogerrors.CategoryOf(err)
+	// Builds that fail because Docker isn't running don't always say so, so check
+	if category == cogerrors.CategoryBuild && docker.Available() != nil {
+		return cogerrors.CategoryDockerUnavailable
+	}
+	return category
+}
+
+func validateErrorFormat() error {
+	if errorFormatFlag != errorFormatText && errorFormatFlag != errorFormatJSON {
+		return fmt.Errorf("--error-format must be %s or %s, not %q", errorFormatText, errorFormatJSON, errorFormatFlag)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/dockerfile"
+	"github.com/replicate/cog/pkg/global"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+var explainJSON bool
+
+func newExplainCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "explain",
+		Short: "Show each instruction of the Dockerfile generated from " + global.ConfigFilename + ", and which fields caused it",
+		Args:  cobra.NoArgs,
+		RunE:  explain,
+	}
+	addUseCudaBaseImageFlag(cmd)
+	addUseCogBaseImageFlag(cmd)
+	addFastFlag(cmd)
+	addLocalImage(cmd)
+	cmd.Flags().BoolVar(&explainJSON, "json", false, "Print the explanation as JSON")
+
+	return cmd
+}
+
+func explain(cmd *cobra.Command, args []string) error {
+	cfg, projectDir, err := config.GetConfig(projectDirFlag)
+	if err != nil {
+		return err
+	}
+	if cfg.Build.Fast {
+		buildFast = cfg.Build.Fast
+	}
+
+	generator, err := dockerfile.NewGenerator(cfg, projectDir, buildFast, docker.NewDockerCommand(), buildLocalImage)
+	if err != nil {
+		return fmt.Errorf("Error creating Dockerfile generator: %w", err)
+	}
+	defer func() {
+		if err := generator.Cleanup(); err != nil {
+			console.Warnf("Error cleaning up Dockerfile generator: %s", err)
+		}
+	}()
+	generator.SetUseCudaBaseImage(buildUseCudaBaseImage)
+	if useCogBaseImage := DetermineUseCogBaseImage(cmd); useCogBaseImage != nil {
+		generator.SetUseCogBaseImage(*useCogBaseImage)
+	}
+
+	explanation, err := generator.Explain()
+	if err != nil {
+		return err
+	}
+	if explainJSON {
+		output, err := json.MarshalIndent(explanation, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(output))
+		return nil
+	}
+	fmt.Println(explanation.String())
+	return nil
+}
@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/export"
+	"github.com/replicate/cog/pkg/image"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+var (
+	exportOutput string
+	exportImage  string
+	exportOpset  int
+	exportFP16   bool
+)
+
+func newExportCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export <onnx|tensorrt>",
+		Short: "Export the model to an inference-optimized format",
+		Long: `Export the model to an inference-optimized format.
+
+The export runs inside the model environment. The predictor is set up as
+usual, then the model returned by 'export_model()' (or the 'model' attribute)
+is traced with the inputs returned by 'export_inputs()'.
+
+If --image is set, the model's image is built with that name and the export
+runs in it. The exported model is then added to the image, with its path in
+the COG_EXPORTED_MODEL environment variable.`,
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: export.Formats,
+		RunE:      cmdExport,
+		PreRunE:   checkMutuallyExclusiveFlags,
+	}
+	addBuildProgressOutputFlag(cmd)
+	addDockerfileFlag(cmd)
+	addUseCudaBaseImageFlag(cmd)
+	addUseCogBaseImageFlag(cmd)
+	addGpusFlag(cmd)
+
+	cmd.Flags().StringVarP(&exportOutput, "output", "o", "", "Path to write the exported model to, relative to the project directory (default model.onnx or model.plan)")
+	cmd.Flags().StringVar(&exportImage, "image", "", "Build an optimized image with this name containing the exported model")
+	cmd.Flags().IntVar(&exportOpset, "opset", 17, "ONNX opset version to export with")
+	cmd.Flags().BoolVar(&exportFP16, "fp16", false, "Build the TensorRT engine with FP16 precision")
+
+	return cmd
+}
+
+func cmdExport(cmd *cobra.Command, args []string) error {
+	format := args[0]
+	if err := export.ValidateFormat(format); err != nil {
+		return err
+	}
+
+	cfg, projectDir, err := config.GetConfig(projectDirFlag)
+	if err != nil {
+		return err
+	}
+	if cfg.Predict == "" {
+		return fmt.Errorf("'predict' must be set in cog.yaml to export a model")
+	}
+
+	if err := verifyBaseImage(cmd, cfg, projectDir, false); err != nil {
+		return err
+	}
+	// With --image, the model is exported in the image it's added to, so that image is only built once
+	var modelImage string
+	if exportImage != "" {
+		if err := image.Build(cfg, projectDir, exportImage, buildSecrets, buildSSH, buildNoCache, false, buildUseCudaBaseImage, buildProgressOutput, "", buildDockerfileFile, DetermineUseCogBaseImage(cmd), false, false, false, nil, false, nil); err != nil {
+			return err
+		}
+		modelImage = exportImage
+	} else {
+		modelImage, err = image.BuildBase(cfg, projectDir, buildUseCudaBaseImage, DetermineUseCogBaseImage(cmd), buildProgressOutput)
+		if err != nil {
+			return err
+		}
+	}
+
+	gpus, err := selectGPUs(cfg.Build)
+	if err != nil {
+		return err
+	}
+	output := exportOutput
+	if output == "" {
+		output = export.DefaultOutput(format)
+	}
+
+	if err := export.Run(projectDir, modelImage, export.Options{
+		Format: format,
+		Output: output,
+		Opset:  exportOpset,
+		FP16:   exportFP16,
+		GPUs:   gpus,
+	}); err != nil {
+		return fmt.Errorf("Failed to export model: %w", err)
+	}
+	console.Infof("\nExported model written to %s", output)
+
+	if exportImage == "" {
+		return nil
+	}
+
+	if err := export.BuildOptimizedImage(projectDir, exportImage, exportImage, output, buildProgressOutput); err != nil {
+		return fmt.Errorf("Failed to build optimized image: %w", err)
+	}
+	console.Infof("\nOptimized image built as %s", exportImage)
+
+	return nil
+}
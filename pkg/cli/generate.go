@@ -0,0 +1,145 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/cog/pkg/ci"
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/devcontainer"
+	"github.com/replicate/cog/pkg/image"
+	"github.com/replicate/cog/pkg/util/console"
+	"github.com/replicate/cog/pkg/util/files"
+)
+
+var (
+	generateImage string
+	generateForce bool
+)
+
+func newGenerateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "generate",
+		Short: "Generate configuration for developing the model with other tools",
+	}
+	cmd.AddCommand(newGenerateCICommand())
+	cmd.AddCommand(newGenerateDevcontainerCommand())
+	return cmd
+}
+
+func newGenerateCICommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ci",
+		Short: "Generate a CI workflow that builds, tests, scans and pushes the model",
+	}
+	cmd.AddCommand(newGenerateCIGitHubCommand())
+	return cmd
+}
+
+func newGenerateCIGitHubCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "github",
+		Short: "Generate a GitHub Actions workflow in " + ci.GitHubWorkflowPath,
+		Args:  cobra.NoArgs,
+		RunE:  generateCIGitHub,
+	}
+	cmd.Flags().BoolVar(&generateForce, "force", false, "Overwrite an existing workflow")
+	return cmd
+}
+
+func generateCIGitHub(cmd *cobra.Command, args []string) error {
+	cfg, projectDir, err := config.GetConfig(projectDirFlag)
+	if err != nil {
+		return err
+	}
+
+	outputPath := filepath.Join(projectDir, filepath.FromSlash(ci.GitHubWorkflowPath))
+	if err := checkGeneratedFile(outputPath, ci.GitHubWorkflowPath); err != nil {
+		return err
+	}
+	workflow, err := ci.GitHubWorkflow(cfg)
+	if err != nil {
+		return err
+	}
+	if err := writeGeneratedFile(outputPath, []byte(workflow)); err != nil {
+		return err
+	}
+	console.Infof("Wrote %s", outputPath)
+	if cfg.Image == "" {
+		console.Info("Set the COG_IMAGE repository variable to where the model should be pushed, or set image in cog.yaml and run this again with --force.")
+	}
+	return nil
+}
+
+func newGenerateDevcontainerCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "devcontainer",
+		Short:   "Generate a .devcontainer configuration to develop inside the model's environment in VS Code",
+		Example: `cog generate devcontainer --image r8.im/your-username/hotdog-detector`,
+		Args:    cobra.NoArgs,
+		RunE:    generateDevcontainer,
+	}
+	addBuildProgressOutputFlag(cmd)
+	addUseCudaBaseImageFlag(cmd)
+	addUseCogBaseImageFlag(cmd)
+	cmd.Flags().StringVar(&generateImage, "image", "", "Image to develop in, e.g. one built with `cog build`. Defaults to building the model's environment without its code, like `cog run`.")
+	cmd.Flags().BoolVar(&generateForce, "force", false, "Overwrite an existing devcontainer.json")
+	return cmd
+}
+
+func generateDevcontainer(cmd *cobra.Command, args []string) error {
+	cfg, projectDir, err := config.GetConfig(projectDirFlag)
+	if err != nil {
+		return err
+	}
+
+	outputPath := filepath.Join(projectDir, devcontainer.Dir, "devcontainer.json")
+	if err := checkGeneratedFile(outputPath, filepath.Join(devcontainer.Dir, "devcontainer.json")); err != nil {
+		return err
+	}
+
+	imageName := generateImage
+	if imageName == "" {
+		if imageName, err = image.BuildBase(cfg, projectDir, buildUseCudaBaseImage, DetermineUseCogBaseImage(cmd), buildProgressOutput); err != nil {
+			return err
+		}
+	}
+
+	contents, err := devcontainer.Generate(cfg, projectDir, imageName)
+	if err != nil {
+		return err
+	}
+	if err := writeGeneratedFile(outputPath, contents); err != nil {
+		return err
+	}
+	console.Infof("Wrote %s using %s", outputPath, imageName)
+	if generateImage == "" {
+		console.Info("After changing the environment in cog.yaml, run `cog generate devcontainer --force` to rebuild the image, then rebuild the container.")
+	}
+	return nil
+}
+
+// checkGeneratedFile makes sure a file isn't overwritten unless --force is passed
+func checkGeneratedFile(path string, name string) error {
+	exists, err := files.Exists(path)
+	if err != nil {
+		return err
+	}
+	if exists && !generateForce {
+		return fmt.Errorf("Found an existing %s. Pass --force to overwrite it.", name)
+	}
+	return nil
+}
+
+func writeGeneratedFile(path string, contents []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("Error creating directory %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, contents, 0o644); err != nil {
+		return fmt.Errorf("Error writing %s: %w", path, err)
+	}
+	return nil
+}
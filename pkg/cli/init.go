@@ -1,14 +1,18 @@
 package cli
 
 import (
+	"bytes"
 	// blank import for embeds
 	_ "embed"
 	"fmt"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
 
+	"github.com/replicate/cog/pkg/requirements"
 	"github.com/replicate/cog/pkg/util/console"
 	"github.com/replicate/cog/pkg/util/files"
 )
@@ -28,6 +32,11 @@ var actionsWorkflowContent []byte
 //go:embed init-templates/requirements.txt
 var requirementsTxtContent []byte
 
+// findRequirements is --from-requirements without a path, which finds requirements.txt or pyproject.toml
+const findRequirements = "auto"
+
+var initFromRequirements string
+
 func newInitCommand() *cobra.Command {
 	var cmd = &cobra.Command{
 		Use:        "init",
@@ -38,6 +47,8 @@ func newInitCommand() *cobra.Command {
 		},
 		Args: cobra.MaximumNArgs(0),
 	}
+	cmd.Flags().StringVar(&initFromRequirements, "from-requirements", "", "Use the project's requirements file, or the dependencies in pyproject.toml, for build.python_requirements rather than creating an example. On its own, it finds requirements.txt or pyproject.toml")
+	cmd.Flags().Lookup("from-requirements").NoOptDefVal = findRequirements
 
 	return cmd
 }
@@ -57,6 +68,18 @@ func initCommand(args []string) error {
 		".github/workflows/push.yaml": actionsWorkflowContent,
 		"requirements.txt":            requirementsTxtContent,
 	}
+	if initFromRequirements != "" {
+		cogYaml, requirementsTxt, err := importRequirements(cwd, initFromRequirements)
+		if err != nil {
+			return err
+		}
+		fileContentMap["cog.yaml"] = cogYaml
+		if requirementsTxt != nil {
+			fileContentMap["requirements.txt"] = requirementsTxt
+		} else {
+			delete(fileContentMap, "requirements.txt")
+		}
+	}
 
 	for filename, content := range fileContentMap {
 		filePath := path.Join(cwd, filename)
@@ -86,3 +109,54 @@ func initCommand(args []string) error {
 
 	return nil
 }
+
+// importRequirements returns cog.yaml with build.python_requirements set to the requirements file from, in dir. If
+// from is pyproject.toml, its dependencies are also returned as requirements.txt, and build.python_version is set
+// from its requires-python.
+func importRequirements(dir string, from string) (cogYaml []byte, requirementsTxt []byte, err error) {
+	if from == findRequirements {
+		from = ""
+		for _, name := range []string{requirements.REQUIREMENTS_FILE, requirements.PYPROJECT_FILE} {
+			if exists, _ := files.Exists(filepath.Join(dir, name)); exists {
+				from = name
+				break
+			}
+		}
+		if from == "" {
+			return nil, nil, fmt.Errorf("Couldn't find %s or %s to use. Pass the path of the requirements file with --from-requirements=PATH.", requirements.REQUIREMENTS_FILE, requirements.PYPROJECT_FILE)
+		}
+	}
+	if !filepath.IsAbs(from) {
+		from = filepath.Join(dir, from)
+	}
+	rel, err := filepath.Rel(dir, from)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return nil, nil, fmt.Errorf("%s must be in the project, because it's sent to the build context", from)
+	}
+
+	cogYaml = cogYamlContent
+	pythonRequirements := filepath.ToSlash(rel)
+	if filepath.Base(from) == requirements.PYPROJECT_FILE {
+		dependencies, pythonVersion, err := requirements.PyprojectDependencies(from)
+		if err != nil {
+			return nil, nil, err
+		}
+		requirementsTxt = []byte(fmt.Sprintf("# The dependencies in %s\n%s\n", requirements.PYPROJECT_FILE, strings.Join(dependencies, "\n")))
+		pythonRequirements = requirements.REQUIREMENTS_FILE
+		if pythonVersion != "" {
+			cogYaml = bytes.Replace(cogYaml, []byte(`python_version: "3.11"`), []byte(`python_version: "`+pythonVersion+`"`), 1)
+		}
+		console.Infof("Using the %d dependencies in %s", len(dependencies), requirements.PYPROJECT_FILE)
+	} else {
+		contents, err := os.ReadFile(from)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to read %s: %w", from, err)
+		}
+		if bytes.Contains(contents, []byte("--hash")) {
+			console.Infof("%s pins requirements to hashes, so they'll be installed as they are, with --require-hashes", pythonRequirements)
+		}
+		console.Infof("Using %s", pythonRequirements)
+	}
+	cogYaml = bytes.Replace(cogYaml, []byte("python_requirements: requirements.txt"), []byte("python_requirements: "+pythonRequirements), 1)
+	return cogYaml, requirementsTxt, nil
+}
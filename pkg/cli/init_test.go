@@ -20,3 +20,50 @@ func TestInit(t *testing.T) {
 	require.FileExists(t, path.Join(dir, "cog.yaml"))
 	require.FileExists(t, path.Join(dir, "predict.py"))
 }
+
+func TestInitFromRequirements(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.Chdir(dir))
+	require.NoError(t, os.MkdirAll(path.Join(dir, "deps"), 0o755))
+	require.NoError(t, os.WriteFile(path.Join(dir, "deps", "prod.txt"), []byte("torch==2.5.1\n"), 0o644))
+
+	initFromRequirements = "deps/prod.txt"
+	t.Cleanup(func() { initFromRequirements = "" })
+	require.NoError(t, initCommand([]string{}))
+
+	cogYaml, err := os.ReadFile(path.Join(dir, "cog.yaml"))
+	require.NoError(t, err)
+	require.Contains(t, string(cogYaml), "python_requirements: deps/prod.txt")
+	require.NoFileExists(t, path.Join(dir, "requirements.txt"))
+}
+
+func TestInitFromPyproject(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.Chdir(dir))
+	require.NoError(t, os.WriteFile(path.Join(dir, "pyproject.toml"), []byte(`[project]
+name = "my-model"
+requires-python = ">=3.12"
+dependencies = ["torch==2.5.1", "pillow"]
+`), 0o644))
+
+	initFromRequirements = findRequirements
+	t.Cleanup(func() { initFromRequirements = "" })
+	require.NoError(t, initCommand([]string{}))
+
+	cogYaml, err := os.ReadFile(path.Join(dir, "cog.yaml"))
+	require.NoError(t, err)
+	require.Contains(t, string(cogYaml), "python_requirements: requirements.txt")
+	require.Contains(t, string(cogYaml), `python_version: "3.12"`)
+	requirementsTxt, err := os.ReadFile(path.Join(dir, "requirements.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "# The dependencies in pyproject.toml\ntorch==2.5.1\npillow\n", string(requirementsTxt))
+}
+
+func TestInitFromRequirementsNotFound(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.Chdir(dir))
+
+	initFromRequirements = findRequirements
+	t.Cleanup(func() { initFromRequirements = "" })
+	require.ErrorContains(t, initCommand([]string{}), "Couldn't find requirements.txt or pyproject.toml")
+}
@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/global"
+	"github.com/replicate/cog/pkg/lint"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+var (
+	lintJSON       bool
+	lintListRules  bool
+	lintDockerfile string
+)
+
+func newLintCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "lint",
+		Short: "Check " + global.ConfigFilename + " and the model's files for common mistakes",
+		Long: `Check ` + global.ConfigFilename + ` and the model's files for common mistakes, and suggest how to fix them.
+
+It finds versions of torch that aren't built for the CUDA that's set, large files
+that are copied into the image with the model's code, .git being sent to the
+build context, and versions of Python there isn't a cog base image for. 'cog build'
+shows the same suggestions as warnings.
+
+With --dockerfile, it also checks a Dockerfile you build the model with, for things
+that stop Cog running the image, like an ENTRYPOINT in shell form, and common
+mistakes, like base images that aren't pinned to a version.
+
+It exits with an error if there are any suggestions, so it can be run in CI.`,
+		Args: cobra.NoArgs,
+		RunE: cmdLint,
+	}
+	cmd.Flags().BoolVar(&lintJSON, "json", false, "Print the suggestions as JSON")
+	cmd.Flags().BoolVar(&lintListRules, "list-rules", false, "List the rules instead of checking them")
+	cmd.Flags().StringVar(&lintDockerfile, "dockerfile", "", "Also check the Dockerfile at this path, which is passed to 'cog build --dockerfile'")
+
+	return cmd
+}
+
+func cmdLint(cmd *cobra.Command, args []string) error {
+	if lintListRules {
+		for _, rule := range lint.Rules {
+			console.Output(fmt.Sprintf("%s: %s", rule.Name, rule.Description))
+		}
+		for _, rule := range lint.DockerfileRules {
+			console.Output(fmt.Sprintf("%s: %s (with --dockerfile)", rule.Name, rule.Description))
+		}
+		return nil
+	}
+
+	cfg, projectDir, err := config.GetConfig(projectDirFlag)
+	if err != nil {
+		return err
+	}
+	suggestions, err := lint.Lint(cfg, projectDir)
+	if err != nil {
+		return err
+	}
+	if lintDockerfile != "" {
+		contents, err := os.ReadFile(lintDockerfile)
+		if err != nil {
+			return fmt.Errorf("Failed to read Dockerfile at %s: %w", lintDockerfile, err)
+		}
+		suggestions = append(suggestions, lint.LintDockerfile(cfg, string(contents))...)
+	}
+
+	if lintJSON {
+		output, err := json.MarshalIndent(suggestions, "", "  ")
+		if err != nil {
+			return err
+		}
+		console.Output(string(output))
+	} else if len(suggestions) == 0 {
+		console.Info("No problems found")
+	} else {
+		for _, suggestion := range suggestions {
+			console.Warnf("%s [%s]", suggestion, suggestion.Rule)
+		}
+	}
+	if len(suggestions) > 0 {
+		return fmt.Errorf("Found %d problems", len(suggestions))
+	}
+	return nil
+}
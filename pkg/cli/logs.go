@@ -0,0 +1,144 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/docker/command"
+	"github.com/replicate/cog/pkg/predict"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// setupPollInterval is how often the server is asked whether setup has completed while following its logs by phase
+const setupPollInterval = time.Second
+
+var (
+	logsFollow     bool
+	logsTimestamps bool
+	logsTail       string
+	logsPhase      string
+)
+
+func newLogsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "logs [container|image]",
+		Short: "Show the logs of a model's container",
+		Long: `Show the logs of a model's container.
+
+If a container is passed, it shows its logs. If an image is passed, it shows the
+logs of the newest container 'cog serve', 'cog predict' or 'cog train' is running
+it in. Otherwise, it shows the logs of the newest of any of them.
+
+--phase shows only the logs of setup(), or only the logs after it, while the server
+runs predictions.`,
+		Example: `  cog logs --follow
+  cog logs my-model --phase setup`,
+		RunE: cmdLogs,
+		Args: cobra.MaximumNArgs(1),
+	}
+
+	cmd.Flags().BoolVarP(&logsFollow, "follow", "f", false, "Keep showing logs until the container stops")
+	cmd.Flags().BoolVarP(&logsTimestamps, "timestamps", "t", false, "Show when each line was logged")
+	cmd.Flags().StringVar(&logsTail, "tail", "all", "How many lines to show from the end of the logs")
+	cmd.Flags().StringVar(&logsPhase, "phase", "", "Only show logs from this phase: setup or predict")
+
+	return cmd
+}
+
+func cmdLogs(cmd *cobra.Command, args []string) error {
+	var phase predict.Phase
+	if logsPhase != "" {
+		var err error
+		if phase, err = predict.ParsePhase(logsPhase); err != nil {
+			return err
+		}
+	}
+
+	arg := ""
+	if len(args) > 0 {
+		arg = args[0]
+	}
+	containerID, err := findLogsContainer(arg)
+	if err != nil {
+		return err
+	}
+
+	options := docker.LogsOptions{Follow: logsFollow, Timestamps: logsTimestamps, Tail: logsTail}
+	if phase == "" {
+		return docker.ContainerLogs(containerID, options, os.Stdout)
+	}
+
+	setupCompletedAt, err := setupCompletedAtFunc(containerID)
+	if err != nil {
+		return err
+	}
+	w := &predict.PhaseWriter{Out: os.Stdout, Phase: phase, Timestamps: logsTimestamps, SetupCompletedAt: setupCompletedAt}
+	options.Timestamps = true
+	if err := docker.ContainerLogs(containerID, options, w); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// findLogsContainer returns the container arg names, or the newest container Cog is running arg's image in, or the
+// newest container Cog is running if arg is empty
+func findLogsContainer(arg string) (string, error) {
+	if arg != "" {
+		if container, err := docker.ContainerInspect(arg); err == nil {
+			return container.ID, nil
+		}
+	}
+
+	filters := []string{"label=" + command.CogContainerLabelKey}
+	if arg != "" {
+		filters = append(filters, "ancestor="+arg)
+	}
+	containers, err := docker.ContainerList(false, filters...)
+	if err != nil {
+		return "", fmt.Errorf("Failed to list containers: %w", err)
+	}
+	if len(containers) == 0 {
+		if arg != "" {
+			return "", fmt.Errorf("%s isn't a container, and Cog isn't running any containers of it", arg)
+		}
+		return "", fmt.Errorf("Cog isn't running any containers. Pass the container or image to show the logs of.")
+	}
+	container := containers[0]
+	if len(containers) > 1 {
+		console.Infof("Showing the logs of %s (%s), the newest of %d containers", container.Names, container.Image, len(containers))
+	} else {
+		console.Infof("Showing the logs of %s (%s)", container.Names, container.Image)
+	}
+	return container.ID, nil
+}
+
+// setupCompletedAtFunc returns a function that asks the model's server in the container when setup completed. It
+// asks at most once every setupPollInterval, so following a long setup doesn't flood the server.
+func setupCompletedAtFunc(containerID string) (func() *time.Time, error) {
+	port, err := docker.GetPort(containerID, 5000)
+	if err != nil {
+		return nil, fmt.Errorf("Can't show the logs of a phase, because the model's server in %s isn't running: %w", containerID, err)
+	}
+	serverURL := fmt.Sprintf("http://localhost:%d", port)
+
+	var lastAsked time.Time
+	return func() *time.Time {
+		if time.Since(lastAsked) < setupPollInterval {
+			return nil
+		}
+		lastAsked = time.Now()
+		healthcheck, err := predict.Healthcheck(serverURL)
+		if err != nil {
+			console.Debugf("Failed to ask the server when setup completed: %s", err)
+			return nil
+		}
+		if healthcheck.Setup == nil {
+			return nil
+		}
+		return healthcheck.Setup.CompletedAt
+	}, nil
+}
@@ -0,0 +1,167 @@
+package cli
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/docker"
+	cogerrors "github.com/replicate/cog/pkg/errors"
+	"github.com/replicate/cog/pkg/image"
+	"github.com/replicate/cog/pkg/predict"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// gpuMemorySampleInterval is how often GPU memory is sampled while the prediction runs. nvidia-smi doesn't record
+// peaks, so a spike shorter than this can be missed.
+const gpuMemorySampleInterval = 250 * time.Millisecond
+
+var measureNoLabels bool
+
+func newMeasureCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "measure IMAGE",
+		Short: "Measure how much memory a model needs, and record it on its image",
+		Long: `Measure how much memory a model needs, and record it on its image.
+
+It runs setup() and one prediction with the -i inputs, then reads the peak memory
+of the container from its cgroup, and samples the memory used on its GPUs while the
+prediction runs. The suggested memory, with some headroom, is set on the image as
+the run.cog.memory and run.cog.gpu_memory labels, in bytes, and 'cog run' warns if
+the machine has less than that.
+
+How long setup() took, and each of its phases, are recorded too, as the
+run.cog.setup_time and run.cog.setup_phases labels, so cold-start time can be
+compared across versions.`,
+		Example: `  cog measure my-model -i prompt="a cat"`,
+		RunE:    cmdMeasure,
+		Args:    cobra.ExactArgs(1),
+	}
+
+	addGpusFlag(cmd)
+	addSetupTimeoutFlag(cmd)
+	addSecurityFlags(cmd)
+	addMaxInputSizeFlag(cmd)
+
+	cmd.Flags().StringArrayVarP(&inputFlags, "input", "i", []string{}, "Inputs, in the form name=value. if value is prefixed with @, then it is read from a file on disk or downloaded from a URL. E.g. -i path=@image.jpg or -i path=@https://example.com/image.jpg")
+	cmd.Flags().StringArrayVarP(&envFlags, "env", "e", []string{}, "Environment variables, in the form name=value")
+	cmd.Flags().BoolVar(&measureNoLabels, "no-labels", false, "Only print the memory the model needs, without setting labels on the image")
+
+	return cmd
+}
+
+func cmdMeasure(cmd *cobra.Command, args []string) error {
+	imageName := args[0]
+	inputs, err := parseInputFlags(inputFlags)
+	if err != nil {
+		return err
+	}
+
+	var peakMemory, peakGPUMemory int64
+	var setup image.SetupTiming
+	if err := withPredictor(cmd, args, func(predictor predict.Predictor, _ *config.Output) error {
+		if result := predictor.Setup(); result != nil {
+			setup = image.NewSetupTiming(result.Duration(), result.Phases)
+		}
+		containerID := predictor.ContainerID()
+		_, gpuErr := docker.ContainerGPUMemory(containerID)
+		if gpuErr != nil {
+			console.Debugf("Not measuring GPU memory: %s", gpuErr)
+		}
+
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		if gpuErr == nil {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ticker := time.NewTicker(gpuMemorySampleInterval)
+				defer ticker.Stop()
+				for {
+					if used, err := docker.ContainerGPUMemory(containerID); err == nil {
+						peakGPUMemory = max(peakGPUMemory, used)
+					}
+					select {
+					case <-done:
+						return
+					case <-ticker.C:
+					}
+				}
+			}()
+		}
+
+		console.Info("Running prediction...")
+		prediction, err := predictor.Predict(inputs)
+		close(done)
+		wg.Wait()
+		if err != nil {
+			return fmt.Errorf("Failed to predict: %w", err)
+		}
+		if prediction.Status == "failed" {
+			return cogerrors.WithCategory(cogerrors.CategoryPrediction, fmt.Errorf("Prediction failed: %s", prediction.Error))
+		}
+
+		peakMemory, err = docker.ContainerPeakMemory(containerID)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	requirements := image.MemoryRequirements{
+		Memory:    image.SuggestMemory(peakMemory),
+		GPUMemory: image.SuggestMemory(peakGPUMemory),
+	}
+	if setup.Seconds > 0 {
+		console.Infof("setup() took %s", setup)
+	}
+	console.Infof("Peak memory was %s, so give the model %s", units.BytesSize(float64(peakMemory)), units.BytesSize(float64(requirements.Memory)))
+	if peakGPUMemory > 0 {
+		console.Infof("Peak GPU memory was %s, so give the model GPUs with %s", units.BytesSize(float64(peakGPUMemory)), units.BytesSize(float64(requirements.GPUMemory)))
+	}
+
+	if measureNoLabels {
+		return nil
+	}
+	labels := requirements.Labels()
+	setupLabels, err := setup.Labels()
+	if err != nil {
+		return err
+	}
+	for key, value := range setupLabels {
+		labels[key] = value
+	}
+	if err := docker.BuildAddLabelsToImage(imageName, labels); err != nil {
+		return fmt.Errorf("Failed to set memory labels on %s: %w", imageName, err)
+	}
+	console.Infof("Recorded the memory the model needs, and how long setup() took, on %s", imageName)
+	return nil
+}
+
+// warnIfNotEnoughMemory warns if the memory the model's image was measured to need by `cog measure` is more than
+// this machine has
+func warnIfNotEnoughMemory(imageName string) {
+	if exists, err := docker.ImageExists(imageName); err != nil || !exists {
+		return
+	}
+	requirements, err := image.GetMemoryRequirements(imageName)
+	if err != nil {
+		console.Debugf("Failed to get memory requirements of %s: %s", imageName, err)
+		return
+	}
+	if requirements.Memory > 0 {
+		if memory, ok := docker.HostMemory(); ok && memory < requirements.Memory {
+			console.Warnf("The model needs about %s of memory, measured with 'cog measure', but Docker has %s. It might run out of memory.",
+				units.BytesSize(float64(requirements.Memory)), units.BytesSize(float64(memory)))
+		}
+	}
+	if requirements.GPUMemory > 0 {
+		if memory, ok := docker.HostGPUMemory(); ok && memory < requirements.GPUMemory {
+			console.Warnf("The model needs GPUs with about %s of memory, measured with 'cog measure', but this machine has a GPU with %s. It might run out of GPU memory.",
+				units.BytesSize(float64(requirements.GPUMemory)), units.BytesSize(float64(memory)))
+		}
+	}
+}
@@ -12,18 +12,25 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/docker/go-units"
 	"github.com/getkin/kin-openapi/openapi3"
 	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
+	"github.com/vbauerster/mpb/v8"
+	"github.com/vbauerster/mpb/v8/decor"
 	"github.com/vincent-petithory/dataurl"
 	"golang.org/x/sys/unix"
 
 	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/diagnostics"
 	"github.com/replicate/cog/pkg/docker"
+	cogerrors "github.com/replicate/cog/pkg/errors"
 	"github.com/replicate/cog/pkg/image"
 	"github.com/replicate/cog/pkg/predict"
+	"github.com/replicate/cog/pkg/transform"
 	"github.com/replicate/cog/pkg/util/console"
 	"github.com/replicate/cog/pkg/util/mime"
+	"github.com/replicate/cog/pkg/util/npy"
 )
 
 var (
@@ -31,6 +38,12 @@ var (
 	inputFlags   []string
 	outPath      string
 	setupTimeout uint32
+	sessionFlag  bool
+	maxInputSize string
+	convertFlag  string
+	maxSizeFlag  int
+
+	streamUploadFlag bool
 )
 
 func newPredictCommand() *cobra.Command {
@@ -57,18 +70,46 @@ the prediction on that.`,
 	addSetupTimeoutFlag(cmd)
 	addFastFlag(cmd)
 	addLocalImage(cmd)
+	addSecurityFlags(cmd)
+	addMaxInputSizeFlag(cmd)
 
-	cmd.Flags().StringArrayVarP(&inputFlags, "input", "i", []string{}, "Inputs, in the form name=value. if value is prefixed with @, then it is read from a file on disk. E.g. -i path=@image.jpg")
+	cmd.Flags().StringArrayVarP(&inputFlags, "input", "i", []string{}, "Inputs, in the form name=value. if value is prefixed with @, then it is read from a file on disk or downloaded from a URL. E.g. -i path=@image.jpg or -i path=@https://example.com/image.jpg")
 	cmd.Flags().StringVarP(&outPath, "output", "o", "", "Output path")
 	cmd.Flags().StringArrayVarP(&envFlags, "env", "e", []string{}, "Environment variables, in the form name=value")
+	cmd.Flags().StringVar(&convertFlag, "convert", "", "Convert file outputs to a format, like webp or mp4. The converted copy is written next to the output.")
+	cmd.Flags().IntVar(&maxSizeFlag, "max-size", 0, "Scale down image and video outputs to this width or height in pixels, in a copy written next to the output")
+	cmd.Flags().BoolVar(&streamUploadFlag, "stream-upload", false, "Upload file inputs to the model in chunks before the prediction, showing their progress and resuming chunks that fail, rather than sending them in the prediction's request")
+	cmd.Flags().BoolVar(&sessionFlag, "session", false, "Open a session on a stateful model with the -i inputs, then run a step for each line of name=value inputs on stdin")
 
 	return cmd
 }
 
 func cmdPredict(cmd *cobra.Command, args []string) error {
+	// Check the flags before building and starting the model
+	if err := (transform.Options{Format: convertFlag, MaxSize: maxSizeFlag}).Validate(); err != nil {
+		return err
+	}
+	return withPredictor(cmd, args, func(predictor predict.Predictor, outputConfig *config.Output) error {
+		transformOptions, err := outputTransformOptions(cmd, outputConfig)
+		if err != nil {
+			return err
+		}
+		if sessionFlag {
+			return predictSession(predictor, inputFlags, os.Stdin)
+		}
+		return predictIndividualInputs(predictor, inputFlags, outPath, false, transformOptions)
+	})
+}
+
+// withPredictor builds the model in the current directory, or uses the image in args, starts it, and calls run with
+// it and the output section of its cog.yaml. The container is stopped when run returns.
+func withPredictor(cmd *cobra.Command, args []string, run func(predictor predict.Predictor, outputConfig *config.Output) error) error {
 	imageName := ""
 	volumes := []docker.Volume{}
 	gpus := gpusFlag
+	security := docker.SecurityOptions{}
+	var runConfig *config.Runtime
+	var outputConfig *config.Output
 
 	if len(args) == 0 {
 		// Build image
@@ -77,6 +118,9 @@ func cmdPredict(cmd *cobra.Command, args []string) error {
 		if err != nil {
 			return err
 		}
+		security = image.ConfigSecurityOptions(cfg, projectDir)
+		runConfig = cfg.Run
+		outputConfig = cfg.Output
 
 		if cfg.Build.Fast {
 			buildFast = cfg.Build.Fast
@@ -85,6 +129,9 @@ func cmdPredict(cmd *cobra.Command, args []string) error {
 		if buildFast {
 			imageName = config.DockerImageName(projectDir)
 		} else {
+			if err := verifyBaseImage(cmd, cfg, projectDir, false); err != nil {
+				return err
+			}
 			if imageName, err = image.BuildBase(cfg, projectDir, buildUseCudaBaseImage, DetermineUseCogBaseImage(cmd), buildProgressOutput); err != nil {
 				return err
 			}
@@ -95,8 +142,8 @@ func cmdPredict(cmd *cobra.Command, args []string) error {
 				Destination: "/src",
 			})
 
-			if gpus == "" && cfg.Build.GPU {
-				gpus = "all"
+			if gpus, err = selectGPUs(cfg.Build); err != nil {
+				return err
 			}
 		}
 
@@ -123,12 +170,25 @@ func cmdPredict(cmd *cobra.Command, args []string) error {
 		if err != nil {
 			return err
 		}
-		if gpus == "" && conf.Build.GPU {
-			gpus = "all"
+		if gpus, err = selectGPUs(conf.Build); err != nil {
+			return err
 		}
 		if conf.Build.Fast {
 			buildFast = conf.Build.Fast
 		}
+		if security, err = image.SecurityOptions(imageName); err != nil {
+			return err
+		}
+		runConfig = conf.Run
+		outputConfig = conf.Output
+	}
+	security, err := securityOptions(cmd, security)
+	if err != nil {
+		return err
+	}
+	containerRuntime, err := sandboxRuntime(cmd, runConfig, gpus)
+	if err != nil {
+		return err
 	}
 
 	console.Info("")
@@ -136,10 +196,12 @@ func cmdPredict(cmd *cobra.Command, args []string) error {
 	dockerCommand := docker.NewDockerCommand()
 
 	predictor, err := predict.NewPredictor(docker.RunOptions{
-		GPUs:    gpus,
-		Image:   imageName,
-		Volumes: volumes,
-		Env:     envFlags,
+		GPUs:     gpus,
+		Image:    imageName,
+		Volumes:  volumes,
+		Env:      envFlags,
+		Security: security,
+		Runtime:  containerRuntime,
 	}, false, buildFast, dockerCommand)
 	if err != nil {
 		return err
@@ -166,18 +228,22 @@ func cmdPredict(cmd *cobra.Command, args []string) error {
 
 			_ = predictor.Stop()
 			predictor, err = predict.NewPredictor(docker.RunOptions{
-				Image:   imageName,
-				Volumes: volumes,
-				Env:     envFlags,
+				Image:    imageName,
+				Volumes:  volumes,
+				Env:      envFlags,
+				Security: security,
+				Runtime:  containerRuntime,
 			}, false, buildFast, dockerCommand)
 			if err != nil {
 				return err
 			}
 
 			if err := predictor.Start(os.Stderr, timeout); err != nil {
+				stopCrashedPredictor(predictor)
 				return err
 			}
 		} else {
+			stopCrashedPredictor(predictor)
 			return err
 		}
 	}
@@ -190,14 +256,103 @@ func cmdPredict(cmd *cobra.Command, args []string) error {
 		}
 	}()
 
-	return predictIndividualInputs(*predictor, inputFlags, outPath, false)
+	if err := run(*predictor, outputConfig); err != nil {
+		diagnoseCrash(predictor)
+		return err
+	}
+	return nil
+}
+
+// stopCrashedPredictor stops a predictor that failed to start, after collecting diagnostics if its container died
+func stopCrashedPredictor(predictor *predict.Predictor) {
+	diagnoseCrash(predictor)
+	if predictor.ContainerID() != "" {
+		_ = predictor.Stop()
+	}
+}
+
+// diagnoseCrash collects diagnostics into .cog/diagnostics if the predictor's container died, and says where they are
+func diagnoseCrash(predictor *predict.Predictor) {
+	if predictor.ContainerID() == "" {
+		return
+	}
+	crash, exited, err := diagnostics.Inspect(predictor.ContainerID())
+	if err != nil {
+		console.Debugf("Failed to check whether the container crashed: %s", err)
+		return
+	}
+	if !exited {
+		return
+	}
+	dir, err := config.GetProjectDir(projectDirFlag)
+	if err != nil {
+		dir = "."
+	}
+	path, err := diagnostics.Collect(crash, dir)
+	if err != nil {
+		console.Warnf("The model's container stopped because %s. Failed to collect diagnostics: %s", crash.Reason, err)
+		return
+	}
+	console.Warnf("The model's container stopped because %s. Diagnostics, with its logs, the kernel's OOM messages and the state of the GPUs, are in %s", crash.Reason, path)
+}
+
+// outputTransformOptions returns how to transform file outputs, from output in cog.yaml and the --convert and
+// --max-size flags, which override it
+func outputTransformOptions(cmd *cobra.Command, output *config.Output) (transform.Options, error) {
+	options := transform.Options{}
+	if output != nil {
+		options.Format = output.Convert
+		options.MaxSize = output.MaxSize
+	}
+	if cmd.Flags().Changed("convert") {
+		options.Format = convertFlag
+	}
+	if cmd.Flags().Changed("max-size") {
+		options.MaxSize = maxSizeFlag
+	}
+	options.Format = strings.ToLower(strings.TrimPrefix(options.Format, "."))
+	return options, options.Validate()
+}
+
+// uploadInputs uploads the file inputs to the model in chunks, with a progress bar for each, and replaces them with
+// the uploads' URLs
+func uploadInputs(predictor *predict.Predictor, inputs predict.Inputs) error {
+	p := mpb.New(
+		mpb.WithRefreshRate(180 * time.Millisecond),
+	)
+	err := inputs.UploadFiles(func(path string) (string, error) {
+		bar := p.New(0,
+			mpb.BarStyle().Rbound("|"),
+			mpb.PrependDecorators(
+				decor.Name(filepath.Base(path)+" "),
+				decor.Counters(decor.SizeB1024(0), "% .2f / % .2f"),
+			),
+			mpb.AppendDecorators(
+				decor.EwmaETA(decor.ET_STYLE_GO, 30),
+				decor.Name(" ] "),
+				decor.EwmaSpeed(decor.SizeB1024(0), "% .2f", 30),
+			),
+		)
+		defer bar.Abort(false)
+		started := time.Now()
+		return predictor.Upload(path, func(sent int64, size int64) {
+			bar.SetTotal(size, false)
+			bar.EwmaSetCurrent(sent, time.Since(started))
+			started = time.Now()
+			if sent == size {
+				bar.SetTotal(size, true)
+			}
+		})
+	})
+	p.Wait()
+	return err
 }
 
 func isURI(ref *openapi3.Schema) bool {
 	return ref != nil && ref.Type.Is("string") && ref.Format == "uri"
 }
 
-func predictIndividualInputs(predictor predict.Predictor, inputFlags []string, outputPath string, isTrain bool) error {
+func predictIndividualInputs(predictor predict.Predictor, inputFlags []string, outputPath string, isTrain bool, transformOptions transform.Options) error {
 	console.Info("Running prediction...")
 	schema, err := predictor.GetSchema()
 	if err != nil {
@@ -227,9 +382,27 @@ func predictIndividualInputs(predictor predict.Predictor, inputFlags []string, o
 	responseSchema := schema.Paths.Value(url).Post.Responses.Value("200").Value.Content["application/json"].Schema.Value
 	outputSchema := responseSchema.Properties["output"].Value
 
-	prediction, err := predictor.Predict(inputs)
+	if err := predict.CheckInputLimits(inputs, schema, isTrain); err != nil {
+		return err
+	}
+
+	if streamUploadFlag {
+		if err := uploadInputs(&predictor, inputs); err != nil {
+			return err
+		}
+	}
+
+	var prediction *predict.Response
+	if table := predict.TableInput(inputs, schema, isTrain); table != "" {
+		prediction, err = predictor.PredictTable(inputs, table)
+	} else {
+		prediction, err = predictor.Predict(inputs)
+	}
 	if err != nil {
-		return fmt.Errorf("Failed to predict: %w", err)
+		return cogerrors.WithCategory(cogerrors.CategoryPrediction, fmt.Errorf("Failed to predict: %w", err))
+	}
+	if prediction.Status == "failed" {
+		return cogerrors.WithCategory(cogerrors.CategoryPrediction, fmt.Errorf("Prediction failed: %s", prediction.Error))
 	}
 
 	if prediction.Output == nil {
@@ -250,7 +423,7 @@ func predictIndividualInputs(predictor predict.Predictor, inputFlags []string, o
 			return fmt.Errorf("Failed to convert prediction output to string")
 		}
 
-		if err := writeDataURLOutput(outputStr, outputPath, addExtension); err != nil {
+		if err := writeDataURLOutput(outputStr, outputPath, addExtension, transformOptions); err != nil {
 			return fmt.Errorf("Failed to write output: %w", err)
 		}
 
@@ -270,7 +443,7 @@ func predictIndividualInputs(predictor predict.Predictor, inputFlags []string, o
 				return fmt.Errorf("Failed to convert prediction output to string")
 			}
 
-			if err := writeDataURLOutput(outputStr, outputPath, addExtension); err != nil {
+			if err := writeDataURLOutput(outputStr, outputPath, addExtension, transformOptions); err != nil {
 				return fmt.Errorf("Failed to write output %d: %w", i, err)
 			}
 		}
@@ -293,6 +466,19 @@ func predictIndividualInputs(predictor predict.Predictor, inputFlags []string, o
 
 		return nil
 	default:
+		// Arrays of numbers are written as .npy files if they're asked for, or the schema says the output is a cog.Array
+		_, isArray := outputSchema.Extensions["x-cog-array"]
+		if values, shape, ok := npy.FromJSON(*prediction.Output); ok && (strings.HasSuffix(outputPath, ".npy") || (outputPath == "" && isArray)) {
+			if outputPath == "" {
+				outputPath = "output.npy"
+			}
+			if err := writeOutput(outputPath, npy.Encode(values, shape)); err != nil {
+				return fmt.Errorf("Failed to write output: %w", err)
+			}
+			console.Infof("The output is a %s", npy.Header{DType: "<f8", Shape: shape})
+			return nil
+		}
+
 		// Treat everything else as JSON -- ints, floats, bools will all convert correctly.
 		rawJSON, err := json.Marshal(prediction.Output)
 		if err != nil {
@@ -359,7 +545,7 @@ func writeOutput(outputPath string, output []byte) error {
 	return nil
 }
 
-func writeDataURLOutput(outputString string, outputPath string, addExtension bool) error {
+func writeDataURLOutput(outputString string, outputPath string, addExtension bool, transformOptions transform.Options) error {
 	dataurlObj, err := dataurl.DecodeString(outputString)
 	if err != nil {
 		return fmt.Errorf("Failed to decode dataurl: %w", err)
@@ -376,7 +562,26 @@ func writeDataURLOutput(outputString string, outputPath string, addExtension boo
 	if err := writeOutput(outputPath, output); err != nil {
 		return err
 	}
+	if dataurlObj.ContentType() == "application/x-npy" {
+		if header, err := npy.ReadHeader(output); err == nil {
+			console.Infof("The output is a %s", header)
+		}
+	}
 
+	if transformOptions.IsZero() {
+		return nil
+	}
+	outputPath, err = homedir.Expand(outputPath)
+	if err != nil {
+		return err
+	}
+	transformedPath, err := transform.Apply(outputPath, transformOptions)
+	if err != nil {
+		return fmt.Errorf("Failed to transform output: %w", err)
+	}
+	if transformedPath != "" {
+		console.Infof("Written transformed output to %s", transformedPath)
+	}
 	return nil
 }
 
@@ -402,7 +607,29 @@ func parseInputFlags(inputs []string) (predict.Inputs, error) {
 		keyVals[name] = append(keyVals[name], value)
 	}
 
-	return predict.NewInputs(keyVals), nil
+	return fetchInputs(predict.NewInputs(keyVals))
+}
+
+// fetchInputs downloads the files in inputs that are URLs, like @https://example.com/image.jpg or
+// @s3://bucket/image.jpg, into the input cache
+func fetchInputs(inputs predict.Inputs) (predict.Inputs, error) {
+	maxSize := int64(predict.DefaultMaxInputSize)
+	if maxInputSize != "" {
+		size, err := units.RAMInBytes(maxInputSize)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid --max-input-size %q: %w", maxInputSize, err)
+		}
+		maxSize = size
+	}
+	fetcher, err := predict.NewFetcher(maxSize)
+	if err != nil {
+		return nil, err
+	}
+	return fetcher.Fetch(inputs)
+}
+
+func addMaxInputSizeFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&maxInputSize, "max-input-size", "1GB", "The largest input file to download from a URL, like 500MB")
 }
 
 func addSetupTimeoutFlag(cmd *cobra.Command) {
@@ -0,0 +1,130 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"github.com/replicate/cog/pkg/predict"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// predictSession opens a session on a stateful model with the -i inputs, runs a step for each line of inputs on
+// stdin, and closes the session at the end of stdin
+func predictSession(predictor predict.Predictor, inputFlags []string, stdin io.Reader) error {
+	if outPath != "" {
+		return fmt.Errorf("--output can't be used with --session, because the output of each step is printed")
+	}
+	schema, err := predictor.GetSchema()
+	if err != nil {
+		return err
+	}
+	if !predict.IsStateful(schema) {
+		return fmt.Errorf("The model isn't stateful, so it can't be used with --session. Define step() on the predictor to keep state between predictions.")
+	}
+
+	inputs, err := parseInputFlags(inputFlags)
+	if err != nil {
+		return err
+	}
+	sessionID, err := predictor.CreateSession(inputs)
+	if err != nil {
+		return err
+	}
+	console.Infof("Opened session %s. Enter the inputs of each step on a line, like text=\"hello world\" n=2, and press Ctrl-D to close it.", sessionID)
+	defer func() {
+		if err := predictor.CloseSession(sessionID); err != nil {
+			console.Warnf("Failed to close session %s: %s", sessionID, err)
+		}
+	}()
+
+	scanner := bufio.NewScanner(stdin)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		fields, err := splitStepInputs(scanner.Text())
+		if err != nil {
+			console.Error(err.Error())
+			continue
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		stepInputs, err := parseInputFlags(fields)
+		if err != nil {
+			console.Error(err.Error())
+			continue
+		}
+		// A step that fails doesn't close the session, so the inputs can be corrected and tried again
+		resp, err := predictor.Step(sessionID, stepInputs)
+		if err != nil {
+			console.Error(err.Error())
+			continue
+		}
+		if resp.Status == "failed" {
+			console.Errorf("Step failed: %s", resp.Error)
+			continue
+		}
+		if err := printStepOutput(resp.Output); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func printStepOutput(output *any) error {
+	if output == nil {
+		console.Warn("No output generated")
+		return nil
+	}
+	if s, ok := (*output).(string); ok {
+		console.Output(s)
+		return nil
+	}
+	rawJSON, err := json.Marshal(output)
+	if err != nil {
+		return fmt.Errorf("Failed to encode step output as JSON: %w", err)
+	}
+	var indentedJSON bytes.Buffer
+	if err := json.Indent(&indentedJSON, rawJSON, "", "  "); err != nil {
+		return err
+	}
+	console.Output(indentedJSON.String())
+	return nil
+}
+
+// splitStepInputs splits a line of name=value inputs on whitespace, apart from inside double quotes, so values can
+// have spaces in them like they can with -i
+func splitStepInputs(line string) ([]string, error) {
+	fields := []string{}
+	var field strings.Builder
+	inField := false
+	inQuotes := false
+	for _, r := range line {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			inField = true
+			field.WriteRune(r)
+		case unicode.IsSpace(r) && !inQuotes:
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			inField = true
+			field.WriteRune(r)
+		}
+	}
+	if inQuotes {
+		return nil, fmt.Errorf("Failed to parse inputs '%s': a quote isn't closed", line)
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
@@ -0,0 +1,42 @@
+package cli
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/image"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+func newPrefetchCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "prefetch [IMAGE]",
+		Short:   "Pull the images that building the model in the current directory needs, ahead of building it",
+		Example: `cog prefetch r8.im/your-username/hotdog-detector --separate-weights`,
+		RunE:    prefetch,
+		Args:    cobra.MaximumNArgs(1),
+	}
+	addSeparateWeightsFlag(cmd)
+	addUseCudaBaseImageFlag(cmd)
+	addUseCogBaseImageFlag(cmd)
+
+	return cmd
+}
+
+func prefetch(cmd *cobra.Command, args []string) error {
+	cfg, projectDir, err := config.GetConfig(projectDirFlag)
+	if err != nil {
+		return err
+	}
+
+	imageName := cfg.Image
+	if len(args) > 0 {
+		imageName = args[0]
+	}
+
+	if err := image.Prefetch(cfg, projectDir, imageName, buildSeparateWeights, buildUseCudaBaseImage, DetermineUseCogBaseImage(cmd)); err != nil {
+		return err
+	}
+	console.Info("Images pulled")
+	return nil
+}
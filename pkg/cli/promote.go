@@ -0,0 +1,89 @@
+package cli
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/hooks"
+	"github.com/replicate/cog/pkg/image"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+var (
+	promoteFrom   string
+	promoteTo     string
+	promoteVerify string
+)
+
+func newPromoteCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "promote IMAGE --to REGISTRY",
+		Short: "Copy a pushed model to another registry by digest, with its signatures and SBOMs",
+		Long: `Copy a pushed model to another registry by digest, with its signatures and SBOMs.
+
+The image is copied in the registries without rebuilding or pulling it, so what's promoted
+is exactly what was tested. Signatures, attestations and SBOMs attached to it with cosign
+or the OCI referrers API are copied too. The repository path and tag are kept.
+
+Before the image is copied, its signature is verified if --verify is set, and the
+pre-promote hook in .cog/hooks is run with its labels.`,
+		Example: `  cog promote ml/hotdog-detector:v3 --from staging.example.com --to prod.example.com
+  cog promote staging.example.com/ml/hotdog-detector@sha256:3f2a... --to prod.example.com --verify`,
+		RunE: promote,
+		Args: cobra.ExactArgs(1),
+	}
+	cmd.Flags().StringVar(&promoteFrom, "from", "", "The registry to promote from. If it isn't set, IMAGE must include its registry")
+	cmd.Flags().StringVar(&promoteTo, "to", "", "The registry to promote to, optionally with a path to put the repository under, like 'prod.example.com/models'")
+	cmd.Flags().StringVar(&promoteVerify, "verify", "", "Verify the signature of the image before promoting it, with 'cosign' (the default) or 'content-trust'")
+	cmd.Flags().Lookup("verify").NoOptDefVal = image.VerifyWithCosign
+	_ = cmd.MarkFlagRequired("to")
+
+	return cmd
+}
+
+func promote(cmd *cobra.Command, args []string) error {
+	source := args[0]
+	if promoteFrom != "" {
+		source = promoteFrom + "/" + source
+	}
+	target, err := image.PromotionTarget(source, promoteTo)
+	if err != nil {
+		return err
+	}
+
+	promotion, err := image.NewPromotion(source, target)
+	if err != nil {
+		return err
+	}
+	if promoteVerify != "" {
+		if err := promotion.Verify([]string{promoteVerify}); err != nil {
+			return err
+		}
+	}
+
+	// Promoting doesn't need a project, but hooks are only run in one
+	projectDir, err := config.GetProjectDir(projectDirFlag)
+	if err != nil {
+		if projectDir, err = os.Getwd(); err != nil {
+			return err
+		}
+	}
+	if err := hooks.RunPrePromote(projectDir, promotion.Source.String(), target, promotion.Labels, promotion.Size); err != nil {
+		return err
+	}
+
+	artifacts, err := promotion.Copy()
+	if err != nil {
+		return err
+	}
+	for _, artifact := range artifacts {
+		console.Infof("Copied %s", artifact)
+	}
+	console.Infof("Promoted '%s' to '%s'", promotion.Source, target)
+	if len(artifacts) == 0 {
+		console.Info("No signatures or SBOMs were attached to the image")
+	}
+	return nil
+}
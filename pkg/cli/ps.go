@@ -0,0 +1,213 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/docker/command"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+var (
+	psAll   bool
+	psQuiet bool
+)
+
+func newPsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ps",
+		Short: "List the containers Cog is running models in",
+		Long: `List the containers Cog is running models in.
+
+It lists the containers started by 'cog predict', 'cog train', 'cog serve' and
+'cog run', with the model they're running, the ports they're serving on and the
+GPUs they're using. Stop them with 'cog stop', and remove stopped ones with
+'cog rm'.`,
+		Example: `  cog ps --all`,
+		RunE:    cmdPs,
+		Args:    cobra.NoArgs,
+	}
+
+	cmd.Flags().BoolVarP(&psAll, "all", "a", false, "List stopped containers too")
+	cmd.Flags().BoolVarP(&psQuiet, "quiet", "q", false, "Only print container IDs")
+
+	return cmd
+}
+
+func cmdPs(cmd *cobra.Command, args []string) error {
+	containers, err := cogContainers(psAll)
+	if err != nil {
+		return err
+	}
+
+	if psQuiet {
+		for _, c := range containers {
+			console.Output(shortContainerID(c.ID))
+		}
+		return nil
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CONTAINER ID\tNAME\tKIND\tMODEL\tIMAGE\tPORTS\tGPUS\tCREATED\tSTATUS")
+	for _, c := range containers {
+		kind, image := "", ""
+		if c.Config != nil {
+			kind = c.Config.Labels[command.CogContainerLabelKey]
+			image = c.Config.Image
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			shortContainerID(c.ID), strings.TrimPrefix(c.Name, "/"), kind, containerModel(c.Mounts), image,
+			containerPorts(c.NetworkSettings), containerGPUs(c.HostConfig), containerAge(c.Created, now), containerStatus(c.State))
+	}
+	return w.Flush()
+}
+
+// cogContainers returns the containers Cog started, newest first. If all is set, it returns stopped ones too.
+func cogContainers(all bool) ([]types.ContainerJSON, error) {
+	summaries, err := docker.ContainerList(all, "label="+command.CogContainerLabelKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list containers: %w", err)
+	}
+	ids := make([]string, len(summaries))
+	for i, summary := range summaries {
+		ids[i] = summary.ID
+	}
+	containers, err := docker.ContainersInspect(ids...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to inspect containers: %w", err)
+	}
+	return containers, nil
+}
+
+func shortContainerID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// containerModel returns the name of the model's directory mounted in the container, or "-" if it's running an image
+// without its source
+func containerModel(mounts []types.MountPoint) string {
+	for _, mount := range mounts {
+		if mount.Destination == "/src" {
+			return filepath.Base(mount.Source)
+		}
+	}
+	return "-"
+}
+
+// containerPorts returns the container's published ports, like 8393->5000/tcp
+func containerPorts(settings *types.NetworkSettings) string {
+	if settings == nil || len(settings.Ports) == 0 {
+		return "-"
+	}
+	// Ports are bound on IPv4 and IPv6, usually to the same host port
+	hostPorts := map[string][]string{}
+	containerPorts := make([]string, 0, len(settings.Ports))
+	for port, bindings := range settings.Ports {
+		containerPorts = append(containerPorts, string(port))
+		for _, binding := range bindings {
+			if binding.HostPort != "" && !slices.Contains(hostPorts[string(port)], binding.HostPort) {
+				hostPorts[string(port)] = append(hostPorts[string(port)], binding.HostPort)
+			}
+		}
+	}
+	sort.Strings(containerPorts)
+
+	ports := []string{}
+	for _, port := range containerPorts {
+		for _, hostPort := range hostPorts[port] {
+			ports = append(ports, hostPort+"->"+port)
+		}
+	}
+	if len(ports) == 0 {
+		return "-"
+	}
+	return strings.Join(ports, ", ")
+}
+
+// containerGPUs returns the GPUs the container was given with --gpus, like all, 2 or device=0,1
+func containerGPUs(hostConfig *container.HostConfig) string {
+	if hostConfig == nil {
+		return "-"
+	}
+	for _, request := range hostConfig.DeviceRequests {
+		isGPU := request.Driver == "nvidia"
+		for _, capabilities := range request.Capabilities {
+			for _, capability := range capabilities {
+				isGPU = isGPU || capability == "gpu"
+			}
+		}
+		if !isGPU {
+			continue
+		}
+		switch {
+		case len(request.DeviceIDs) > 0:
+			return "device=" + strings.Join(request.DeviceIDs, ",")
+		case request.Count < 0:
+			return "all"
+		default:
+			return fmt.Sprint(request.Count)
+		}
+	}
+	return "-"
+}
+
+// containerAge returns how long ago the container was created, like "5 minutes ago"
+func containerAge(created string, now time.Time) string {
+	createdAt, err := time.Parse(time.RFC3339Nano, created)
+	if err != nil {
+		return "-"
+	}
+	return units.HumanDuration(now.Sub(createdAt)) + " ago"
+}
+
+func containerStatus(state *types.ContainerState) string {
+	if state == nil {
+		return "-"
+	}
+	if state.Status == "exited" {
+		return fmt.Sprintf("exited (%d)", state.ExitCode)
+	}
+	return state.Status
+}
+
+// containersToActOn returns the containers `cog stop` or `cog rm` were passed, or if all is set, the containers Cog
+// started, including stopped ones if includeStopped is set
+func containersToActOn(args []string, all bool, includeStopped bool) ([]string, error) {
+	if all && len(args) > 0 {
+		return nil, fmt.Errorf("Pass either containers or --all, not both")
+	}
+	if !all {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("Pass the containers, or --all for all the containers Cog started")
+		}
+		return args, nil
+	}
+	containers, err := cogContainers(includeStopped)
+	if err != nil {
+		return nil, err
+	}
+	if len(containers) == 0 {
+		console.Info("There aren't any containers Cog started")
+	}
+	ids := make([]string, len(containers))
+	for i, c := range containers {
+		ids[i] = shortContainerID(c.ID)
+	}
+	return ids, nil
+}
@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContainerGPUs(t *testing.T) {
+	gpus := func(requests ...container.DeviceRequest) string {
+		return containerGPUs(&container.HostConfig{Resources: container.Resources{DeviceRequests: requests}})
+	}
+	require.Equal(t, "-", gpus())
+	require.Equal(t, "all", gpus(container.DeviceRequest{Count: -1, Capabilities: [][]string{{"gpu"}}}))
+	require.Equal(t, "2", gpus(container.DeviceRequest{Count: 2, Capabilities: [][]string{{"gpu"}}}))
+	require.Equal(t, "device=0,1", gpus(container.DeviceRequest{Driver: "nvidia", DeviceIDs: []string{"0", "1"}}))
+	require.Equal(t, "-", containerGPUs(nil))
+}
+
+func TestContainerModel(t *testing.T) {
+	require.Equal(t, "resnet", containerModel([]types.MountPoint{
+		{Source: "/home/me/.cache", Destination: "/root/.cache"},
+		{Source: "/home/me/resnet", Destination: "/src"},
+	}))
+	require.Equal(t, "-", containerModel(nil))
+}
+
+func TestContainerAgeAndStatus(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 5, 0, 0, time.UTC)
+	require.Equal(t, "5 minutes ago", containerAge("2024-05-01T09:59:59.123456789Z", now))
+	require.Equal(t, "-", containerAge("", now))
+
+	require.Equal(t, "running", containerStatus(&types.ContainerState{Status: "running"}))
+	require.Equal(t, "exited (137)", containerStatus(&types.ContainerState{Status: "exited", ExitCode: 137}))
+}
+
+func TestContainersToActOn(t *testing.T) {
+	ids, err := containersToActOn([]string{"abc", "def"}, false, false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"abc", "def"}, ids)
+
+	_, err = containersToActOn(nil, false, false)
+	require.Error(t, err)
+	_, err = containersToActOn([]string{"abc"}, true, false)
+	require.Error(t, err)
+}
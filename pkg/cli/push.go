@@ -1,19 +1,35 @@
 package cli
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"time"
 
+	"github.com/docker/go-units"
+	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/spf13/cobra"
 
 	"github.com/replicate/go/uuid"
 
 	"github.com/replicate/cog/pkg/config"
 	"github.com/replicate/cog/pkg/docker"
+	cogerrors "github.com/replicate/cog/pkg/errors"
 	"github.com/replicate/cog/pkg/global"
+	"github.com/replicate/cog/pkg/hooks"
 	"github.com/replicate/cog/pkg/image"
+	"github.com/replicate/cog/pkg/p2p"
 	"github.com/replicate/cog/pkg/util/console"
+	"github.com/replicate/cog/pkg/versions"
+)
+
+var (
+	pushIncludeBase       bool
+	pushOptimizeLayout    bool
+	pushUploadConcurrency int
+	pushBandwidthLimit    string
+	pushMountFrom         []string
+	pushSeedP2P           string
 )
 
 func newPushCommand() *cobra.Command {
@@ -27,6 +43,10 @@ func newPushCommand() *cobra.Command {
 	}
 	addSecretsFlag(cmd)
 	addNoCacheFlag(cmd)
+	addCacheFromFlag(cmd)
+	addNoResolveFlag(cmd)
+	addNoDiskCheckFlag(cmd)
+	addIntrospectWithFlag(cmd)
 	addSeparateWeightsFlag(cmd)
 	addSchemaFlag(cmd)
 	addUseCudaBaseImageFlag(cmd)
@@ -37,6 +57,17 @@ func newPushCommand() *cobra.Command {
 	addPrecompileFlag(cmd)
 	addFastFlag(cmd)
 	addLocalImage(cmd)
+	addVerifyBaseFlag(cmd)
+	addStateFileFlag(cmd)
+	addAttachFlag(cmd)
+	addEncryptWeightsFlag(cmd)
+	cmd.Flags().BoolVar(&pushIncludeBase, "include-base", false, "Also push the cog base image the model is built on to the same registry, for clusters that can't pull from r8.im")
+	cmd.Flags().IntVar(&pushUploadConcurrency, "upload-concurrency", 0, "The number of layers to upload at once")
+	cmd.Flags().StringVar(&pushBandwidthLimit, "bandwidth-limit", "", "The most to upload per second, like '10MB' or '500KB'")
+	cmd.Flags().StringArrayVar(&pushMountFrom, "mount-from", []string{}, "Another repository in the same registry to mount layers from, instead of uploading them again")
+	cmd.Flags().StringVar(&pushSeedP2P, "seed-p2p", "", "Seed the pushed image into a P2P registry accelerator, 'dragonfly' or 'spegel', so nodes pull it from peers")
+	cmd.Flags().Lookup("seed-p2p").NoOptDefVal = p2p.Dragonfly
+	cmd.Flags().BoolVar(&pushOptimizeLayout, "optimize-layout", false, "Restructure the image into separate environment, weights and code layers before pushing, so new versions only push what changed")
 
 	return cmd
 }
@@ -64,12 +95,44 @@ func push(cmd *cobra.Command, args []string) error {
 		if err := docker.ManifestInspect(imageName); err != nil && strings.Contains(err.Error(), `"code":"NAME_UNKNOWN"`) {
 			return fmt.Errorf("Unable to find Replicate existing model for %s. Go to replicate.com and create a new model before pushing.", imageName)
 		}
+		if pushIncludeBase {
+			return fmt.Errorf("--include-base is for pushing to your own registry. Replicate already has the cog base images, so push without it.")
+		}
 	} else {
 		if buildLocalImage {
 			return fmt.Errorf("Unable to push a local image model to a non replicate host, please disable the local image flag before pushing to this host.")
 		}
 	}
 
+	if pushOptimizeLayout && buildFast {
+		return fmt.Errorf("--optimize-layout can't be used with fast builds, which already push the model in separate layers.")
+	}
+	if buildEncryptWeights != "" && buildFast {
+		return fmt.Errorf("--encrypt-weights can't be used with fast builds")
+	}
+
+	pushOptions, err := parsePushOptions(projectDir)
+	if err != nil {
+		return err
+	}
+	if pushOptions.InProcess() && buildFast {
+		return fmt.Errorf("--upload-concurrency, --bandwidth-limit and --mount-from can't be used with fast pushes")
+	}
+
+	if pushSeedP2P != "" {
+		if err := p2p.ValidateAccelerator(pushSeedP2P); err != nil {
+			return err
+		}
+	}
+
+	if err := verifyBaseImage(cmd, cfg, projectDir, buildFast); err != nil {
+		return err
+	}
+
+	if err := hooks.RunPreBuild(cfg, projectDir, imageName); err != nil {
+		return err
+	}
+
 	annotations := map[string]string{}
 	buildID, err := uuid.NewV7()
 	if err != nil {
@@ -78,28 +141,58 @@ func push(cmd *cobra.Command, args []string) error {
 	} else {
 		annotations["run.cog.push_id"] = buildID.String()
 	}
+	if pushSeedP2P != "" {
+		annotations[p2p.SeedLabelKey] = pushSeedP2P
+	}
 
 	startBuildTime := time.Now()
 
-	if err := image.Build(cfg, projectDir, imageName, buildSecrets, buildNoCache, buildSeparateWeights, buildUseCudaBaseImage, buildProgressOutput, buildSchemaFile, buildDockerfileFile, DetermineUseCogBaseImage(cmd), buildStrip, buildPrecompile, buildFast, annotations, buildLocalImage); err != nil {
+	if err := image.Build(cfg, projectDir, imageName, buildSecrets, buildSSH, buildNoCache, buildSeparateWeights, buildUseCudaBaseImage, buildProgressOutput, buildSchemaFile, buildDockerfileFile, DetermineUseCogBaseImage(cmd), buildStrip, buildPrecompile, buildFast, annotations, buildLocalImage, nil); err != nil {
+		return err
+	}
+
+	if pushOptimizeLayout {
+		if err := image.OptimizeLayout(imageName, projectDir); err != nil {
+			return err
+		}
+	}
+
+	if err := encryptWeights(projectDir, imageName); err != nil {
+		return err
+	}
+
+	// Fast pushes upload the project directory, which was already scanned when it was synced into the build context
+	if !buildFast {
+		if err := image.CheckImageSecrets(cfg, imageName); err != nil {
+			return err
+		}
+	}
+
+	if err := hooks.RunPrePush(cfg, projectDir, imageName); err != nil {
 		return err
 	}
 
 	buildDuration := time.Since(startBuildTime)
 
+	command := docker.NewDockerCommand()
+	if pushIncludeBase {
+		if err := image.PushBaseImage(imageName, command); err != nil {
+			return err
+		}
+	}
+
 	console.Infof("\nPushing image '%s'...", imageName)
 	if buildFast {
 		console.Info("Fast push enabled.")
 	}
 
-	command := docker.NewDockerCommand()
 	err = docker.Push(imageName, buildFast, projectDir, command, docker.BuildInfo{
 		BuildTime: buildDuration,
 		BuildID:   buildID.String(),
-	})
+	}, pushOptions)
 	if err != nil {
 		if strings.Contains(err.Error(), "404") {
-			return fmt.Errorf("Unable to find existing Replicate model for %s. "+
+			return cogerrors.WithCategory(cogerrors.CategoryPushAuth, fmt.Errorf("Unable to find existing Replicate model for %s. "+
 				"Go to replicate.com and create a new model before pushing."+
 				"\n\n"+
 				"If the model already exists, you may be getting this error "+
@@ -107,12 +200,32 @@ func push(cmd *cobra.Command, args []string) error {
 				"This can happen if you did `sudo cog login` instead of `cog login` "+
 				"or `sudo cog push` instead of `cog push`, "+
 				"which causes Docker to use the wrong Docker credentials.",
-				imageName)
+				imageName))
+		}
+		if isAuthError(err) {
+			return cogerrors.WithCategory(cogerrors.CategoryPushAuth, fmt.Errorf("Failed to push image, because the registry didn't accept your credentials. Run 'cog login' and try again: %w", err))
 		}
 		return fmt.Errorf("Failed to push image: %w", err)
 	}
 
 	console.Infof("Image '%s' pushed", imageName)
+	recordVersion(projectDir, imageName, true)
+
+	if err := attachArtifacts(imageName); err != nil {
+		return err
+	}
+
+	if pushSeedP2P != "" {
+		if err := seedP2P(pushSeedP2P, imageName); err != nil {
+			return err
+		}
+	}
+
+	if buildStateFile != "" {
+		if err := image.WriteState(buildStateFile, imageName, buildSeparateWeights); err != nil {
+			return err
+		}
+	}
 	if strings.HasPrefix(imageName, replicatePrefix) {
 		replicatePage := fmt.Sprintf("https://%s", strings.Replace(imageName, global.ReplicateRegistryHost, global.ReplicateWebsiteHost, 1))
 		console.Infof("\nRun your model on Replicate:\n    %s", replicatePage)
@@ -120,3 +233,68 @@ func push(cmd *cobra.Command, args []string) error {
 
 	return nil
 }
+
+// seedP2P seeds the image that was pushed as imageName into a P2P accelerator, by its digest so it's exactly what
+// was pushed
+func seedP2P(accelerator string, imageName string) error {
+	digest, err := image.RemoteDigest(imageName)
+	if err != nil {
+		return err
+	}
+	seed, err := p2p.SeedImage(context.Background(), accelerator, digest)
+	if err != nil {
+		return err
+	}
+	if seed.JobID != "" {
+		console.Infof("Started %s preheat job %s for %s", accelerator, seed.JobID, digest)
+		return nil
+	}
+	console.Infof("To seed %s into %s, run:", digest, accelerator)
+	for _, command := range seed.Commands {
+		console.Infof("    %s", command)
+	}
+	return nil
+}
+
+func parsePushOptions(projectDir string) (docker.PushOptions, error) {
+	options := docker.PushOptions{UploadConcurrency: pushUploadConcurrency}
+	if pushUploadConcurrency < 0 {
+		return options, fmt.Errorf("--upload-concurrency must be at least 1")
+	}
+	if pushBandwidthLimit != "" {
+		limit, err := units.FromHumanSize(strings.TrimSuffix(pushBandwidthLimit, "/s"))
+		if err != nil || limit <= 0 {
+			return options, fmt.Errorf("Invalid --bandwidth-limit %q, it should be a size per second like '10MB'", pushBandwidthLimit)
+		}
+		options.BandwidthLimit = limit
+	}
+	for _, mountFrom := range pushMountFrom {
+		repository, err := name.NewRepository(mountFrom)
+		if err != nil {
+			return options, fmt.Errorf("Invalid --mount-from %q: %w", mountFrom, err)
+		}
+		options.MountFrom = append(options.MountFrom, repository)
+	}
+	if options.InProcess() {
+		// Layers of versions pushed to other repositories can be mounted too
+		if log, err := versions.Load(projectDir); err == nil {
+			for _, pushed := range log.Repositories() {
+				if repository, err := name.NewRepository(pushed); err == nil {
+					options.MountFrom = append(options.MountFrom, repository)
+				}
+			}
+		}
+	}
+	return options, nil
+}
+
+// isAuthError returns true if a push failed because the registry didn't accept the credentials, or there weren't any
+func isAuthError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"unauthorized", "authentication required", "denied", "401", "403"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
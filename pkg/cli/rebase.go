@@ -0,0 +1,47 @@
+package cli
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/cog/pkg/image"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+var (
+	rebaseOnto string
+	rebaseTag  string
+)
+
+func newRebaseCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rebase IMAGE --onto NEW_BASE",
+		Short: "Move a pushed model onto a newer cog base image without rebuilding it",
+		Long: `Move a pushed model onto a newer cog base image without rebuilding it.
+
+The model's layers are put on top of the new base image in the registry, in place of
+the base image it was built on, so security fixes in the base image don't require
+installing the model's dependencies again. The new base image must be compatible with
+the old one, e.g. the same cog base image tag with patched system packages.`,
+		Example: `cog rebase r8.im/your-username/hotdog-detector --onto r8.im/cog-base:cuda12.1-python3.11-torch2.3.1`,
+		RunE:    rebase,
+		Args:    cobra.ExactArgs(1),
+	}
+	cmd.Flags().StringVar(&rebaseOnto, "onto", "", "The base image to move the model onto")
+	cmd.Flags().StringVarP(&rebaseTag, "tag", "t", "", "Push the rebased model to this image, instead of replacing IMAGE")
+	_ = cmd.MarkFlagRequired("onto")
+
+	return cmd
+}
+
+func rebase(cmd *cobra.Command, args []string) error {
+	imageName := args[0]
+	target := imageName
+	if rebaseTag != "" {
+		target = rebaseTag
+	}
+	if err := image.Rebase(imageName, rebaseOnto, target); err != nil {
+		return err
+	}
+	console.Infof("Image '%s' rebased onto '%s'", target, rebaseOnto)
+	return nil
+}
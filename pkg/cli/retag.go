@@ -0,0 +1,33 @@
+package cli
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/cog/pkg/image"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+func newRetagCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "retag SOURCE TAG",
+		Short: "Point a tag at a pushed model in the registry, without pulling it",
+		Long: `Point a tag at a pushed model in the registry, without pulling it.
+
+SOURCE is usually a digest, like r8.im/your-username/hotdog-detector@sha256:..., so the tag
+points at exactly that image. TAG can be a full image name, or just a tag in the same
+repository as SOURCE.`,
+		Example: `cog retag r8.im/your-username/hotdog-detector@sha256:3f2a... prod`,
+		RunE:    retag,
+		Args:    cobra.ExactArgs(2),
+	}
+	return cmd
+}
+
+func retag(cmd *cobra.Command, args []string) error {
+	target, err := image.Retag(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	console.Infof("'%s' now points to '%s'", target, args[0])
+	return nil
+}
@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+var (
+	rmAll   bool
+	rmForce bool
+)
+
+func newRmCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rm [CONTAINER...]",
+		Short: "Remove stopped containers Cog started",
+		Long: `Remove stopped containers Cog started.
+
+Running containers aren't removed unless --force is passed. Stop them gracefully
+with 'cog stop' first.
+
+--all removes all the stopped containers Cog started, listed by 'cog ps --all',
+like the containers of predictions that crashed.`,
+		Example: `  cog rm --all`,
+		RunE:    cmdRm,
+	}
+
+	cmd.Flags().BoolVarP(&rmAll, "all", "a", false, "Remove all the stopped containers Cog started")
+	cmd.Flags().BoolVarP(&rmForce, "force", "f", false, "Remove running containers too, killing them")
+
+	return cmd
+}
+
+func cmdRm(cmd *cobra.Command, args []string) error {
+	containerIDs, err := containersToActOn(args, rmAll, true)
+	if err != nil {
+		return err
+	}
+
+	failed, running := 0, 0
+	for _, containerID := range containerIDs {
+		if !rmForce && containerRunning(containerID) {
+			if !rmAll {
+				console.Warnf("%s is running. Stop it with 'cog stop', or pass --force.", containerID)
+				failed++
+			}
+			running++
+			continue
+		}
+		if err := docker.RemoveContainer(containerID); err != nil {
+			console.Warnf("Failed to remove %s: %s", containerID, err)
+			failed++
+			continue
+		}
+		console.Output(containerID)
+	}
+	if rmAll && running > 0 {
+		console.Infof("Didn't remove %d running containers. Stop them with 'cog stop --all', or pass --force.", running)
+	}
+	if failed > 0 {
+		return fmt.Errorf("Failed to remove %d of %d containers", failed, len(containerIDs))
+	}
+	return nil
+}
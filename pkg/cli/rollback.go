@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/image"
+	"github.com/replicate/cog/pkg/util/console"
+	"github.com/replicate/cog/pkg/versions"
+)
+
+var rollbackTo int
+
+func newRollbackCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rollback TAG",
+		Short: "Point a tag back at a version of the model that was pushed before",
+		Long: `Point a tag back at a version of the model that was pushed before.
+
+The versions pushed from this project are read from .cog/versions.json. TAG is pointed at
+the version pushed --to versions before the one it points to now. This only changes the tag
+in the registry, so nothing is pulled or pushed again.`,
+		Example: `cog rollback r8.im/your-username/hotdog-detector:latest --to 1`,
+		RunE:    rollback,
+		Args:    cobra.ExactArgs(1),
+	}
+	cmd.Flags().IntVar(&rollbackTo, "to", 1, "How many versions to go back")
+	return cmd
+}
+
+func rollback(cmd *cobra.Command, args []string) error {
+	if rollbackTo < 1 {
+		return fmt.Errorf("--to must be at least 1")
+	}
+	tag, err := name.NewTag(args[0])
+	if err != nil {
+		return fmt.Errorf("Failed to parse tag %s: %w", args[0], err)
+	}
+	_, projectDir, err := config.GetConfig(projectDirFlag)
+	if err != nil {
+		return err
+	}
+	log, err := versions.Load(projectDir)
+	if err != nil {
+		return err
+	}
+
+	current, err := image.RemoteDigest(tag.String())
+	if err != nil {
+		return err
+	}
+	version, err := log.Rollback(tag.Context().String(), current, rollbackTo)
+	if err != nil {
+		return err
+	}
+
+	console.Infof("Rolling back '%s' from %s to version %s, built from commit %s", tag, current, version.ShortID(), orUnknown(shortCommit(version.GitCommit)))
+	if _, err := image.Retag(version.Digest, tag.TagStr()); err != nil {
+		return err
+	}
+	console.Infof("'%s' now points to '%s'", tag, version.Digest)
+	return nil
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"github.com/replicate/cog/pkg/config"
 	"github.com/replicate/cog/pkg/global"
 	"github.com/replicate/cog/pkg/update"
 	"github.com/replicate/cog/pkg/util/console"
@@ -24,7 +25,7 @@ https://github.com/replicate/cog`,
       $ cog run echo hello world`,
 		Version: fmt.Sprintf("%s (built %s)", global.Version, global.BuildTime),
 		// This stops errors being printed because we print them in cmd/cog/cog.go
-		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 			if global.Debug {
 				console.SetLevel(console.DebugLevel)
 			}
@@ -32,21 +33,53 @@ https://github.com/replicate/cog`,
 			if err := update.DisplayAndCheckForRelease(); err != nil {
 				console.Debugf("%s", err)
 			}
+			if err := validateErrorFormat(); err != nil {
+				return err
+			}
+			return validateEnvironment()
 		},
 		SilenceErrors: true,
 	}
 	setPersistentFlags(&rootCmd)
+	rootCmd.PersistentFlags().StringVar(&global.Environment, "environment", "", "The named project environment to use, with its own image and caches. Defaults to $"+config.EnvironmentEnvVar+", or the one selected with 'cog env use'")
 
 	rootCmd.AddCommand(
+		newAdoptCommand(),
+		newAPICommand(),
+		newArtifactsCommand(),
+		newBaseImageCommand(),
+		newBenchmarkCommand(),
 		newBuildCommand(),
+		newBuildAllCommand(),
+		newBuilderCommand(),
+		newCheckCommand(),
+		newConfigCommand(),
 		newDebugCommand(),
+		newDiffCommand(),
+		newEnvCommand(),
+		newExplainCommand(),
+		newExportCommand(),
+		newGenerateCommand(),
 		newInitCommand(),
+		newLintCommand(),
 		newLoginCommand(),
+		newLogsCommand(),
+		newMeasureCommand(),
 		newPredictCommand(),
+		newPrefetchCommand(),
+		newPromoteCommand(),
+		newPsCommand(),
 		newPushCommand(),
+		newRebaseCommand(),
+		newRetagCommand(),
+		newRmCommand(),
+		newRollbackCommand(),
 		newRunCommand(),
 		newServeCommand(),
+		newStopCommand(),
+		newTestCommand(),
 		newTrainCommand(),
+		newVersionsCommand(),
 	)
 
 	return &rootCmd, nil
@@ -56,5 +89,6 @@ func setPersistentFlags(cmd *cobra.Command) {
 	cmd.PersistentFlags().BoolVar(&global.Debug, "debug", false, "Show debugging output")
 	cmd.PersistentFlags().BoolVar(&global.ProfilingEnabled, "profile", false, "Enable profiling")
 	cmd.PersistentFlags().Bool("version", false, "Show version of Cog")
+	cmd.PersistentFlags().StringVar(&errorFormatFlag, "error-format", errorFormatText, "How to print errors: text, or json with the error's category and exit code, for scripts")
 	_ = cmd.PersistentFlags().MarkHidden("profile")
 }
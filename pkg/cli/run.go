@@ -9,6 +9,7 @@ import (
 
 	"github.com/replicate/cog/pkg/config"
 	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/docker/command"
 	"github.com/replicate/cog/pkg/image"
 	"github.com/replicate/cog/pkg/util"
 	"github.com/replicate/cog/pkg/util/console"
@@ -20,7 +21,23 @@ var (
 )
 
 func addGpusFlag(cmd *cobra.Command) {
-	cmd.Flags().StringVar(&gpusFlag, "gpus", "", "GPU devices to add to the container, in the same format as `docker run --gpus`.")
+	cmd.Flags().StringVar(&gpusFlag, "gpus", "", "GPU devices to add to the container, like 2 or device=0,1, in the same format as `docker run --gpus`. Defaults to build.gpu_count GPUs, or all of them.")
+}
+
+// selectGPUs returns the GPUs to give the container: --gpus, or build.gpu_count or all of the host's GPUs if the
+// model uses GPUs. It fails if the host doesn't have the GPUs the model needs.
+func selectGPUs(build *config.Build) (string, error) {
+	gpus := gpusFlag
+	if gpus == "" && build.GPU {
+		gpus = "all"
+		if build.GPUCount > 0 {
+			gpus = strconv.Itoa(build.GPUCount)
+		}
+	}
+	if err := docker.ValidateGPUs(gpus, build.GPUCount); err != nil {
+		return "", err
+	}
+	return gpus, nil
 }
 
 func newRunCommand() *cobra.Command {
@@ -38,6 +55,7 @@ func newRunCommand() *cobra.Command {
 	addGpusFlag(cmd)
 	addFastFlag(cmd)
 	addLocalImage(cmd)
+	addSecurityFlags(cmd)
 
 	flags := cmd.Flags()
 	// Flags after first argument are considered args and passed to command
@@ -56,27 +74,48 @@ func run(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	if err := verifyBaseImage(cmd, cfg, projectDir, false); err != nil {
+		return err
+	}
+
+	// The memory the model needs is recorded on its image by `cog measure`, if it's been built
+	modelImageName := cfg.Image
+	if modelImageName == "" {
+		modelImageName = config.DockerImageName(projectDir)
+	}
+	warnIfNotEnoughMemory(modelImageName)
+
 	imageName, err := image.BuildBase(cfg, projectDir, buildUseCudaBaseImage, DetermineUseCogBaseImage(cmd), buildProgressOutput)
 	if err != nil {
 		return err
 	}
 
-	gpus := ""
-	if gpusFlag != "" {
-		gpus = gpusFlag
-	} else if cfg.Build.GPU {
-		gpus = "all"
+	gpus, err := selectGPUs(cfg.Build)
+	if err != nil {
+		return err
+	}
+
+	containerRuntime, err := sandboxRuntime(cmd, cfg.Run, gpus)
+	if err != nil {
+		return err
+	}
+	security, err := securityOptions(cmd, image.ConfigSecurityOptions(cfg, projectDir))
+	if err != nil {
+		return err
 	}
 
 	dockerCommand := docker.NewDockerCommand()
 
 	runOptions := docker.RunOptions{
-		Args:    args,
-		Env:     envFlags,
-		GPUs:    gpus,
-		Image:   imageName,
-		Volumes: []docker.Volume{{Source: projectDir, Destination: "/src"}},
-		Workdir: "/src",
+		Runtime:  containerRuntime,
+		Args:     args,
+		Env:      envFlags,
+		GPUs:     gpus,
+		Image:    imageName,
+		Volumes:  []docker.Volume{{Source: projectDir, Destination: "/src"}},
+		Workdir:  "/src",
+		Security: security,
+		Labels:   map[string]string{command.CogContainerLabelKey: "run"},
 	}
 	runOptions, err = docker.FillInWeightsManifestVolumes(dockerCommand, runOptions)
 	if err != nil {
@@ -0,0 +1,89 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/docker"
+)
+
+var (
+	securityReadOnly        bool
+	securityWritablePaths   []string
+	securitySeccomp         string
+	securityAppArmor        string
+	securityNoNewPrivileges bool
+	securityRuntime         string
+	securityNetwork         string
+	securityAllowHosts      []string
+)
+
+func addSecurityFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&securityReadOnly, "read-only", false, "Mount the container's root filesystem read-only, apart from /tmp and --writable-path")
+	cmd.Flags().StringArrayVar(&securityWritablePaths, "writable-path", []string{}, "A directory that's writable in a read-only container")
+	cmd.Flags().StringVar(&securitySeccomp, "seccomp", "", "The path of a seccomp profile to run the container with, or 'unconfined'")
+	cmd.Flags().StringVar(&securityAppArmor, "apparmor", "", "The AppArmor profile to run the container with, or 'unconfined'")
+	cmd.Flags().BoolVar(&securityNoNewPrivileges, "no-new-privileges", false, "Stop processes in the container gaining privileges")
+	cmd.Flags().StringVar(&securityRuntime, "runtime", "", "Run the container in a sandbox, 'runsc' for gVisor or 'kata' for Kata Containers")
+	cmd.Flags().StringVar(&securityNetwork, "network", "", "'none' to stop the container connecting to anything but --allow-host, or 'default' to let it connect anywhere")
+	cmd.Flags().StringArrayVar(&securityAllowHosts, "allow-host", []string{}, "A host the container can connect to through HTTP and HTTPS, like huggingface.co")
+}
+
+// sandboxRuntime returns the container runtime to pass to `docker run --runtime`, from --runtime or the run stanza
+// in cog.yaml. It fails if the sandbox isn't installed or can't give the container gpus.
+func sandboxRuntime(cmd *cobra.Command, run *config.Runtime, gpus string) (string, error) {
+	name := securityRuntime
+	if !cmd.Flags().Changed("runtime") && run != nil {
+		name = run.Runtime
+	}
+	return docker.ResolveRuntime(name, gpus)
+}
+
+// securityOptions applies the hardening flags on top of options, which are from cog.yaml or the image's labels
+func securityOptions(cmd *cobra.Command, options docker.SecurityOptions) (docker.SecurityOptions, error) {
+	flags := cmd.Flags()
+	if flags.Changed("read-only") {
+		options.ReadOnly = securityReadOnly
+	}
+	if flags.Changed("writable-path") {
+		options.WritablePaths = append(options.WritablePaths, securityWritablePaths...)
+		// Writable paths only make sense in a read-only container
+		options.ReadOnly = true
+	}
+	if flags.Changed("seccomp") {
+		options.Seccomp = securitySeccomp
+	}
+	if flags.Changed("apparmor") {
+		options.AppArmor = securityAppArmor
+	}
+	if flags.Changed("no-new-privileges") {
+		options.NoNewPrivileges = securityNoNewPrivileges
+	}
+	if flags.Changed("network") {
+		switch securityNetwork {
+		case config.NetworkNone:
+			options.Egress = &docker.EgressPolicy{}
+		case config.NetworkDefault:
+			options.Egress = nil
+		default:
+			return options, fmt.Errorf("--network must be %q or %q, got %q", config.NetworkNone, config.NetworkDefault, securityNetwork)
+		}
+	}
+	if flags.Changed("allow-host") {
+		if securityNetwork == config.NetworkDefault {
+			return options, fmt.Errorf("--allow-host can't be used with --network %s", config.NetworkDefault)
+		}
+		if options.Egress == nil {
+			options.Egress = &docker.EgressPolicy{}
+		}
+		for _, host := range securityAllowHosts {
+			if err := config.ValidateNetworkHost(host); err != nil {
+				return options, fmt.Errorf("Invalid --allow-host: %w", err)
+			}
+		}
+		options.Egress.Allow = append(append([]string{}, options.Egress.Allow...), securityAllowHosts...)
+	}
+	return options, nil
+}
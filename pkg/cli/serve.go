@@ -1,22 +1,52 @@
 package cli
 
 import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 
+	"github.com/replicate/cog/pkg/audit"
 	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/dataset"
 	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/docker/command"
 	"github.com/replicate/cog/pkg/image"
+	"github.com/replicate/cog/pkg/proxy"
 	"github.com/replicate/cog/pkg/util"
 	"github.com/replicate/cog/pkg/util/console"
 )
 
 var (
-	port = 8393
+	port              = 8393
+	serveDrainTimeout int
+	serveCache        bool
+	serveCacheTTL     = 24 * 60 * 60
+	serveLimits       proxy.Limits
+
+	serveAuditLog            string
+	serveAuditOTLPEndpoint   string
+	serveAuditRedact         []string
+	serveAuditRedactPatterns []string
+	serveAuditMaxSize        int
+
+	serveCapture string
 )
 
+// serveCacheDir is where `cog serve --cache` caches predictions, relative to the project directory
+const serveCacheDir = ".cog/cache/predictions"
+
 func newServeCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "serve",
@@ -34,18 +64,61 @@ Generate and run an HTTP server based on the declared model inputs and outputs.`
 	addUseCogBaseImageFlag(cmd)
 	addGpusFlag(cmd)
 	addFastFlag(cmd)
+	addSecurityFlags(cmd)
 
 	cmd.Flags().IntVarP(&port, "port", "p", port, "Port on which to listen")
+	cmd.Flags().IntVar(&serveDrainTimeout, "drain-timeout", 0, "When the server is stopped, stop accepting predictions and wait this many seconds for running ones to finish")
+	cmd.Flags().BoolVar(&serveCache, "cache", false, "Cache the outputs of predictions in "+serveCacheDir+", and return them for predictions with the same input instead of running the model again. Only use it for models whose output only depends on their input.")
+	cmd.Flags().IntVar(&serveCacheTTL, "cache-ttl", serveCacheTTL, "How many seconds cached predictions are used for, with --cache. 0 means forever.")
+	cmd.Flags().IntVar(&serveLimits.MaxInFlight, "max-in-flight", 0, "The most predictions to run at once. Others wait in the queue.")
+	cmd.Flags().IntVar(&serveLimits.QueueLength, "queue-length", 0, "The most predictions to wait for --max-in-flight. Others get a 429 error.")
+	cmd.Flags().Float64Var(&serveLimits.RateLimit, "rate-limit", 0, "The most predictions each client can make a second. Others get a 429 error.")
+	cmd.Flags().IntVar(&serveLimits.Burst, "rate-limit-burst", 1, "The most predictions each client can make at once, with --rate-limit")
+	cmd.Flags().StringVar(&serveAuditLog, "audit-log", "", "Record each request to the model and its response to this file, as JSON lines")
+	cmd.Flags().StringVar(&serveAuditOTLPEndpoint, "audit-otlp-endpoint", "", "Export each request to the model and its response as logs to this OpenTelemetry collector, like http://localhost:4318")
+	cmd.Flags().StringArrayVar(&serveAuditRedact, "audit-redact", nil, "Redact the values of this field in recorded requests and responses, like 'prompt'. Can be repeated.")
+	cmd.Flags().StringArrayVar(&serveAuditRedactPatterns, "audit-redact-pattern", nil, "Redact text that matches this regular expression in recorded requests and responses. Can be repeated.")
+	cmd.Flags().StringVar(&serveCapture, "capture", "", "Record the inputs and outputs of successful predictions to this dataset, as JSON lines, to test the model with cog test --dataset")
+	cmd.Flags().IntVar(&serveAuditMaxSize, "audit-max-size", audit.DefaultMaxSize, "The largest a recorded request or response can be, in bytes. Long strings in bigger ones are shortened.")
 
 	return cmd
 }
 
 func cmdServe(cmd *cobra.Command, arg []string) error {
+	if serveCacheTTL < 0 {
+		return fmt.Errorf("--cache-ttl must be 0 or more seconds")
+	}
+	if serveLimits.MaxInFlight < 0 || serveLimits.QueueLength < 0 || serveLimits.RateLimit < 0 || serveLimits.Burst < 0 {
+		return fmt.Errorf("--max-in-flight, --queue-length, --rate-limit and --rate-limit-burst can't be negative")
+	}
+	if serveLimits.QueueLength > 0 && serveLimits.MaxInFlight == 0 {
+		return fmt.Errorf("--queue-length needs --max-in-flight")
+	}
+
+	auditLogger, err := newAuditLogger()
+	if err != nil {
+		return err
+	}
+	if auditLogger != nil {
+		defer auditLogger.Close()
+	}
+	var capture *dataset.Capture
+	if serveCapture != "" {
+		capture, err = dataset.NewCapture(serveCapture)
+		if err != nil {
+			return err
+		}
+		defer capture.Close()
+	}
+
 	cfg, projectDir, err := config.GetConfig(projectDirFlag)
 	if err != nil {
 		return err
 	}
 
+	if err := verifyBaseImage(cmd, cfg, projectDir, false); err != nil {
+		return err
+	}
 	imageName, err := image.BuildBase(cfg, projectDir, buildUseCudaBaseImage, DetermineUseCogBaseImage(cmd), buildProgressOutput)
 	if err != nil {
 		return err
@@ -55,11 +128,9 @@ func cmdServe(cmd *cobra.Command, arg []string) error {
 		console.Info("Fast serve enabled.")
 	}
 
-	gpus := ""
-	if gpusFlag != "" {
-		gpus = gpusFlag
-	} else if cfg.Build.GPU {
-		gpus = "all"
+	gpus, err := selectGPUs(cfg.Build)
+	if err != nil {
+		return err
 	}
 
 	args := []string{
@@ -68,15 +139,34 @@ func cmdServe(cmd *cobra.Command, arg []string) error {
 		"-m", "cog.server.http",
 		"--await-explicit-shutdown", "true",
 	}
+	if serveDrainTimeout > 0 {
+		args = append(args, "--drain-timeout", strconv.Itoa(serveDrainTimeout))
+	}
+	if serveCache {
+		// The project directory is mounted at /src, so the cache is kept between runs
+		args = append(args, "--cache-dir", path.Join("/src", serveCacheDir), "--cache-ttl", strconv.Itoa(serveCacheTTL))
+	}
+
+	containerRuntime, err := sandboxRuntime(cmd, cfg.Run, gpus)
+	if err != nil {
+		return err
+	}
+	security, err := securityOptions(cmd, image.ConfigSecurityOptions(cfg, projectDir))
+	if err != nil {
+		return err
+	}
 
 	dockerCommand := docker.NewDockerCommand()
 	runOptions := docker.RunOptions{
-		Args:    args,
-		Env:     envFlags,
-		GPUs:    gpus,
-		Image:   imageName,
-		Volumes: []docker.Volume{{Source: projectDir, Destination: "/src"}},
-		Workdir: "/src",
+		Runtime:  containerRuntime,
+		Args:     args,
+		Env:      envFlags,
+		GPUs:     gpus,
+		Image:    imageName,
+		Volumes:  []docker.Volume{{Source: projectDir, Destination: "/src"}},
+		Workdir:  "/src",
+		Security: security,
+		Labels:   map[string]string{command.CogContainerLabelKey: "serve"},
 	}
 	runOptions, err = docker.FillInWeightsManifestVolumes(dockerCommand, runOptions)
 	if err != nil {
@@ -87,14 +177,75 @@ func cmdServe(cmd *cobra.Command, arg []string) error {
 		runOptions.Platform = "linux/amd64"
 	}
 
-	runOptions.Ports = append(runOptions.Ports, docker.Port{HostPort: port, ContainerPort: 5000})
+	// With limits, an audit log or a capture, the model's server listens on another port, behind a proxy on the port
+	// that enforces the limits and records requests
+	serverPort := port
+	if serveLimits.Enabled() || auditLogger != nil || capture != nil {
+		serverPort, err = freePort()
+		if err != nil {
+			return err
+		}
+		target := &url.URL{Scheme: "http", Host: fmt.Sprintf("127.0.0.1:%d", serverPort)}
+		modelProxy := proxy.New(target, serveLimits)
+		// Async predictions send their webhooks through the proxy, so they count towards --max-in-flight until they
+		// finish. A model with an egress policy can't reach the host.
+		if serveLimits.MaxInFlight > 0 && runOptions.Security.Egress == nil {
+			modelProxy.SetCallback(&url.URL{Scheme: "http", Host: fmt.Sprintf("host.docker.internal:%d", port)})
+			runOptions.ExtraHosts = append(runOptions.ExtraHosts, "host.docker.internal:host-gateway")
+		}
+		var handler http.Handler = modelProxy
+		if capture != nil {
+			handler = capture.Middleware(handler)
+		}
+		if auditLogger != nil {
+			handler = auditLogger.Middleware(handler)
+		}
+		stopProxy, err := startProxy(port, handler)
+		if err != nil {
+			return err
+		}
+		defer stopProxy()
+	}
+	modelPort := docker.Port{HostPort: serverPort, ContainerPort: 5000}
+	if serverPort != port {
+		// Only the proxy connects to the model's server, so requests can't get around it
+		modelPort.HostIP = "127.0.0.1"
+	}
+	runOptions.Ports = append(runOptions.Ports, modelPort)
 
 	console.Info("")
 	console.Infof("Running '%[1]s' in Docker with the current directory mounted as a volume...", strings.Join(args, " "))
 	console.Info("")
 	console.Infof("Serving at http://127.0.0.1:%[1]v", port)
+	if serveCache {
+		console.Infof("Caching predictions in %s. Delete it to run them again.", serveCacheDir)
+	}
+	if serveLimits.Enabled() {
+		console.Infof("Limiting predictions to %s.", describeLimits(serveLimits))
+	}
+	if serveAuditLog != "" {
+		console.Infof("Recording requests to %s.", serveAuditLog)
+	}
+	if serveAuditOTLPEndpoint != "" {
+		console.Infof("Exporting requests to %s.", serveAuditOTLPEndpoint)
+	}
+	if serveCapture != "" {
+		console.Infof("Capturing predictions to %s. Test the model with them with 'cog test --dataset %s'.", serveCapture, serveCapture)
+	}
 	console.Info("")
 
+	if serveDrainTimeout > 0 {
+		// The server drains when it's interrupted, so wait for it to exit instead of exiting with it still running
+		interrupted := make(chan os.Signal, 1)
+		signal.Notify(interrupted, os.Interrupt)
+		defer signal.Stop(interrupted)
+		go func() {
+			<-interrupted
+			console.Infof("Waiting up to %d seconds for running predictions to finish. Interrupt again to stop now.", serveDrainTimeout)
+			signal.Stop(interrupted)
+		}()
+	}
+
 	err = docker.Run(runOptions)
 	// Only retry if we're using a GPU but but the user didn't explicitly select a GPU with --gpus
 	// If the user specified the wrong GPU, they are explicitly selecting a GPU and they'll want to hear about it
@@ -107,3 +258,71 @@ func cmdServe(cmd *cobra.Command, arg []string) error {
 
 	return err
 }
+
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, fmt.Errorf("Failed to find a free port: %w", err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// startProxy serves the proxy in front of the model's server on port
+func startProxy(port int, handler http.Handler) (stop func(), err error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to listen on port %d: %w", port, err)
+	}
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			console.Warnf("Proxy stopped: %s", err)
+		}
+	}()
+	return func() { _ = server.Close() }, nil
+}
+
+func describeLimits(limits proxy.Limits) string {
+	parts := []string{}
+	if limits.MaxInFlight > 0 {
+		parts = append(parts, fmt.Sprintf("%d at once with %d queued", limits.MaxInFlight, limits.QueueLength))
+	}
+	if limits.RateLimit > 0 {
+		parts = append(parts, fmt.Sprintf("%g a second per client in bursts of up to %d", limits.RateLimit, max(limits.Burst, 1)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// newAuditLogger returns the logger for the --audit flags, or nil if requests aren't recorded
+func newAuditLogger() (*audit.Logger, error) {
+	if serveAuditLog == "" && serveAuditOTLPEndpoint == "" {
+		return nil, nil
+	}
+	options := audit.Options{RedactFields: serveAuditRedact, MaxSize: serveAuditMaxSize}
+	for _, pattern := range serveAuditRedactPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid --audit-redact-pattern %q: %w", pattern, err)
+		}
+		options.RedactPatterns = append(options.RedactPatterns, re)
+	}
+	if serveAuditOTLPEndpoint != "" {
+		u, err := url.Parse(serveAuditOTLPEndpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("Invalid --audit-otlp-endpoint %q: it must be an http:// or https:// URL", serveAuditOTLPEndpoint)
+		}
+	}
+	sinks := []audit.Sink{}
+	if serveAuditLog != "" {
+		sink, err := audit.NewFileSink(serveAuditLog)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if serveAuditOTLPEndpoint != "" {
+		sinks = append(sinks, audit.NewOTLPSink(serveAuditOTLPEndpoint))
+	}
+	return audit.NewLogger(options, sinks...), nil
+}
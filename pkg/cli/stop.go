@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/predict"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// stopGracePeriod is how long the server has to exit after draining before it's stopped anyway
+const stopGracePeriod = 10 * time.Second
+
+var (
+	stopTimeout int
+	stopAll     bool
+)
+
+func newStopCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "stop [CONTAINER...]",
+		Short: "Gracefully stop prediction servers, letting running predictions finish",
+		Long: `Gracefully stop prediction servers, letting running predictions finish.
+
+The server stops accepting predictions straight away, and shuts down once the
+running ones finish. If they're still running after --timeout, or the server
+can't be reached, the container is stopped with docker stop.
+
+--all stops all the containers Cog is running, listed by 'cog ps'.`,
+		Example: `  cog stop 3f2a9c1b7d4e --timeout 300
+  cog stop --all`,
+		RunE: stopCommand,
+	}
+	cmd.Flags().IntVar(&stopTimeout, "timeout", 60, "How many seconds to wait for running predictions to finish")
+	cmd.Flags().BoolVarP(&stopAll, "all", "a", false, "Stop all the containers Cog is running")
+
+	return cmd
+}
+
+func stopCommand(cmd *cobra.Command, args []string) error {
+	containerIDs, err := containersToActOn(args, stopAll, false)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, containerID := range containerIDs {
+		if err := stopContainer(containerID, time.Duration(stopTimeout)*time.Second); err != nil {
+			console.Warnf("Failed to stop %s: %s", containerID, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("Failed to stop %d of %d containers", failed, len(containerIDs))
+	}
+	return nil
+}
+
+// stopContainer asks the prediction server in the container to shut down once its running predictions finish, and
+// waits for it to. If it can't, or it takes longer than timeout, the container is stopped with docker stop.
+func stopContainer(containerID string, timeout time.Duration) error {
+
+	port, err := docker.GetPort(containerID, 5000)
+	if err != nil {
+		console.Warnf("Failed to find the prediction server's port, stopping %s without draining: %s", containerID, err)
+		return docker.Stop(containerID)
+	}
+	serverURL := fmt.Sprintf("http://localhost:%d", port)
+	if err := predict.Shutdown(serverURL, timeout); err != nil {
+		console.Warnf("Failed to shut down the prediction server, stopping %s without draining: %s", containerID, err)
+		return docker.Stop(containerID)
+	}
+
+	console.Infof("Stopping %s once running predictions finish...", containerID)
+	inFlight := -1
+	deadline := time.Now().Add(timeout + stopGracePeriod)
+	for time.Now().Before(deadline) {
+		if !containerRunning(containerID) {
+			console.Infof("Stopped %s", containerID)
+			return nil
+		}
+		if healthcheck, err := predict.Healthcheck(serverURL); err == nil && healthcheck.Status == predict.StatusDraining && healthcheck.PredictionsInFlight != inFlight {
+			inFlight = healthcheck.PredictionsInFlight
+			console.Infof("Waiting for %d running predictions to finish...", inFlight)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	console.Warnf("%s didn't shut down within %s, stopping it", containerID, timeout+stopGracePeriod)
+	return docker.Stop(containerID)
+}
+
+// containerRunning returns whether a container is running. A container that's been removed isn't running.
+func containerRunning(containerID string) bool {
+	container, err := docker.ContainerInspect(containerID)
+	return err == nil && container.State != nil && container.State.Running
+}
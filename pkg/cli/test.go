@@ -0,0 +1,435 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/dataset"
+	"github.com/replicate/cog/pkg/determinism"
+	"github.com/replicate/cog/pkg/predict"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+var (
+	testDeterminism      bool
+	testAgainst          string
+	testRuns             int
+	testSeed             int
+	testTolerance        float64
+	testMaxImageDistance int
+	testJSON             bool
+	testDataset          string
+)
+
+// testCase is a set of inputs to test the model with, from tests in cog.yaml or the -i flags
+type testCase struct {
+	name    string
+	inputs  predict.Inputs
+	options determinism.Options
+	// expected is the output recorded for the inputs in a dataset, if there is one
+	expected    any
+	hasExpected bool
+}
+
+// testResult is the outcome of a test case, for the report
+type testResult struct {
+	Name        string                   `json:"name"`
+	Status      string                   `json:"status"`
+	Runs        []determinism.Run        `json:"runs,omitempty"`
+	Differences []determinism.Difference `json:"differences,omitempty"`
+}
+
+func newTestCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "test [image] [--determinism | --against IMAGE | --dataset FILE]",
+		Short: "Test a model's predictions",
+		Long: `Test a model's predictions.
+
+The model is run on the inputs of each test under tests in cog.yaml, or on the
+-i inputs. If the model has a seed input, it's set to --seed, and PYTHONHASHSEED
+is set in the container. Numbers that differ by less than --tolerance, and images
+whose perceptual hashes are close, are counted as equivalent. The comparators
+under each test's compare in cog.yaml override this for parts of the output.
+
+With --determinism, each test is run several times, and the outputs are compared
+to the first run's. It exits with an error if any run's output diverged.
+
+With --against, each test is run on the model and on a previous image of it, and
+the outputs are compared. It exits with an error if any output regressed.
+
+With --dataset, the tests are the examples in a dataset, like one recorded with
+cog serve --capture. On its own, each example's output is compared to the one
+in the dataset. It exits with an error if any output regressed. It can also be
+used with --determinism or --against, to run those tests on the examples.
+
+If 'image' is passed, it tests that Docker image. Otherwise, it builds the model
+in the current directory and tests that.`,
+		Example: `  cog test --determinism -n 5 -i prompt="a cat"
+  cog test --against r8.im/someone/some-model@sha256:...
+  cog test --dataset dataset.jsonl`,
+		RunE: cmdTest,
+		Args: cobra.MaximumNArgs(1),
+	}
+
+	addUseCudaBaseImageFlag(cmd)
+	addUseCogBaseImageFlag(cmd)
+	addBuildProgressOutputFlag(cmd)
+	addDockerfileFlag(cmd)
+	addGpusFlag(cmd)
+	addSetupTimeoutFlag(cmd)
+	addFastFlag(cmd)
+	addLocalImage(cmd)
+	addSecurityFlags(cmd)
+	addMaxInputSizeFlag(cmd)
+
+	cmd.Flags().StringArrayVarP(&inputFlags, "input", "i", []string{}, "Inputs, in the form name=value. if value is prefixed with @, then it is read from a file on disk or downloaded from a URL. E.g. -i path=@image.jpg or -i path=@https://example.com/image.jpg")
+	cmd.Flags().StringArrayVarP(&envFlags, "env", "e", []string{}, "Environment variables, in the form name=value")
+	cmd.Flags().BoolVar(&testDeterminism, "determinism", false, "Check the model returns the same output every time it's run with the same inputs")
+	cmd.Flags().StringVar(&testAgainst, "against", "", "A previous image of the model to compare outputs with, to check for regressions")
+	cmd.Flags().IntVarP(&testRuns, "runs", "n", 5, "Number of times to run each test, with --determinism")
+	cmd.Flags().IntVar(&testSeed, "seed", 42, "Value of the model's seed input, if it has one and it isn't set in the inputs")
+	cmd.Flags().Float64Var(&testTolerance, "tolerance", 1e-6, "Largest difference between numbers in outputs that's counted as equivalent")
+	cmd.Flags().IntVar(&testMaxImageDistance, "max-image-distance", determinism.DefaultMaxImageDistance, "Largest number of bits, out of 64, the perceptual hashes of images in outputs can differ by to be counted as equivalent")
+	cmd.Flags().BoolVar(&testJSON, "json", false, "Print the report as JSON")
+	cmd.Flags().StringVar(&testDataset, "dataset", "", "A dataset of inputs and outputs, in JSON lines, to test the model with")
+
+	return cmd
+}
+
+func cmdTest(cmd *cobra.Command, args []string) error {
+	if testDeterminism && testAgainst != "" {
+		return fmt.Errorf("Choose a test to run: --determinism or --against IMAGE")
+	}
+	if !testDeterminism && testAgainst == "" && testDataset == "" {
+		return fmt.Errorf("Choose a test to run: --determinism, --against IMAGE or --dataset FILE")
+	}
+	if testDeterminism && testRuns < 2 {
+		return fmt.Errorf("--runs must be at least 2, to have outputs to compare")
+	}
+
+	cases, err := loadTestCases(args)
+	if err != nil {
+		return err
+	}
+	envFlags = append(envFlags, "PYTHONHASHSEED="+strconv.Itoa(testSeed))
+
+	switch {
+	case testDeterminism:
+		return testForDeterminism(cmd, args, cases)
+	case testAgainst != "":
+		return testForRegressions(cmd, args, cases)
+	default:
+		return testAgainstDataset(cmd, args, cases)
+	}
+}
+
+// loadTestCases returns a test case for the -i flags, or the tests in cog.yaml. If there are neither, the model is
+// tested with its default inputs.
+func loadTestCases(args []string) ([]testCase, error) {
+	options := determinism.Options{Tolerance: testTolerance, MaxImageDistance: testMaxImageDistance}
+	if testDataset != "" {
+		return datasetTestCases(testDataset, options)
+	}
+	if len(inputFlags) == 0 {
+		conf, rootDir, err := config.GetConfig(projectDirFlag)
+		// An image can be tested without a cog.yaml
+		if err != nil && len(args) == 0 {
+			return nil, err
+		}
+		if err == nil && len(conf.Tests) > 0 {
+			return configTestCases(conf.Tests, rootDir, options)
+		}
+	}
+
+	inputs, err := parseInputFlags(inputFlags)
+	if err != nil {
+		return nil, err
+	}
+	return []testCase{{name: "inputs", inputs: inputs, options: options}}, nil
+}
+
+func configTestCases(tests []config.TestCase, rootDir string, options determinism.Options) ([]testCase, error) {
+	cases := []testCase{}
+	for i, test := range tests {
+		name := test.Name
+		if name == "" {
+			name = fmt.Sprintf("test %d", i+1)
+		}
+
+		// Files are relative to cog.yaml
+		inputs, err := parseInputFlags(relativeInputFlags(test.InputFlags(), rootDir))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse the inputs of %s: %w", name, err)
+		}
+
+		caseOptions := options
+		caseOptions.Comparators = nil
+		for _, c := range test.Compare {
+			caseOptions.Comparators = append(caseOptions.Comparators, determinism.Comparator{
+				Path:             c.Path,
+				Type:             c.Type,
+				Tolerance:        c.Tolerance,
+				MaxImageDistance: c.MaxDistance,
+			})
+		}
+		if err := caseOptions.Validate(); err != nil {
+			return nil, fmt.Errorf("Invalid comparator in %s: %w", name, err)
+		}
+		cases = append(cases, testCase{name: name, inputs: inputs, options: caseOptions})
+	}
+	return cases, nil
+}
+
+// datasetTestCases returns a test case for each example in the dataset at path
+func datasetTestCases(path string, options determinism.Options) ([]testCase, error) {
+	examples, err := dataset.Read(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(examples) == 0 {
+		return nil, fmt.Errorf("The dataset %s doesn't have any examples", path)
+	}
+	dir := filepath.Dir(path)
+	cases := []testCase{}
+	for _, example := range examples {
+		// Files are relative to the dataset
+		inputs, err := parseInputFlags(relativeInputFlags(config.InputFlags(example.Input), dir))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse the inputs of %s: %w", example.Name, err)
+		}
+		c := testCase{name: example.Name, inputs: inputs, options: options}
+		if example.Output != nil {
+			c.expected, err = dataset.ResolveFiles(example.Output, dir)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to read the output of %s: %w", example.Name, err)
+			}
+			c.hasExpected = true
+		}
+		cases = append(cases, c)
+	}
+	return cases, nil
+}
+
+// relativeInputFlags returns input flags with the paths of files that are relative made relative to dir
+func relativeInputFlags(flags []string, dir string) []string {
+	resolved := make([]string, len(flags))
+	for i, flag := range flags {
+		resolved[i] = flag
+		key, value, _ := strings.Cut(flag, "=")
+		if path, ok := strings.CutPrefix(value, "@"); ok && !predict.IsURL(path) && !filepath.IsAbs(path) {
+			resolved[i] = key + "=@" + filepath.Join(dir, path)
+		}
+	}
+	return resolved
+}
+
+func testForDeterminism(cmd *cobra.Command, args []string, cases []testCase) error {
+	return withPredictor(cmd, args, func(predictor predict.Predictor, _ *config.Output) error {
+		schema, err := predictor.GetSchema()
+		if err != nil {
+			return err
+		}
+
+		results := []testResult{}
+		diverged := 0
+		for _, c := range cases {
+			inputs := seedInputs(schema, c.inputs)
+			outputs := make([]any, 0, testRuns)
+			for i := 1; i <= testRuns; i++ {
+				console.Infof("Running %s, %d of %d...", c.name, i, testRuns)
+				output, err := runTestPrediction(predictor, inputs)
+				if err != nil {
+					return err
+				}
+				outputs = append(outputs, output)
+			}
+			report := determinism.Compare(outputs, c.options)
+			results = append(results, testResult{Name: c.name, Status: report.Status(), Runs: report.Runs})
+			diverged += report.Diverged()
+		}
+		if err := printTestResults(results); err != nil {
+			return err
+		}
+
+		total := len(cases) * testRuns
+		switch worstStatus(results) {
+		case determinism.StatusDivergent:
+			return fmt.Errorf("The model isn't deterministic: %d of %d runs had different outputs to the first", diverged, total)
+		case determinism.StatusEquivalent:
+			console.Infof("The model is deterministic within the tolerances: all %d runs had equivalent outputs", total)
+		default:
+			console.Infof("The model is deterministic: all %d runs had identical outputs", total)
+		}
+		return nil
+	})
+}
+
+func testForRegressions(cmd *cobra.Command, args []string, cases []testCase) error {
+	runCases := func(predictor predict.Predictor, _ *config.Output) ([]any, error) {
+		schema, err := predictor.GetSchema()
+		if err != nil {
+			return nil, err
+		}
+		outputs := []any{}
+		for _, c := range cases {
+			console.Infof("Running %s...", c.name)
+			output, err := runTestPrediction(predictor, seedInputs(schema, c.inputs))
+			if err != nil {
+				return nil, err
+			}
+			outputs = append(outputs, output)
+		}
+		return outputs, nil
+	}
+
+	var outputs, previousOutputs []any
+	if err := withPredictor(cmd, args, func(predictor predict.Predictor, outputConfig *config.Output) (err error) {
+		outputs, err = runCases(predictor, outputConfig)
+		return err
+	}); err != nil {
+		return err
+	}
+	console.Infof("Running the tests on %s...", testAgainst)
+	if err := withPredictor(cmd, []string{testAgainst}, func(predictor predict.Predictor, outputConfig *config.Output) (err error) {
+		previousOutputs, err = runCases(predictor, outputConfig)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	results := []testResult{}
+	regressed := 0
+	for i, c := range cases {
+		status, differences := determinism.Diff(previousOutputs[i], outputs[i], c.options)
+		results = append(results, testResult{Name: c.name, Status: status, Differences: differences})
+		if status == determinism.StatusDivergent {
+			regressed++
+		}
+	}
+	if err := printTestResults(results); err != nil {
+		return err
+	}
+	if regressed > 0 {
+		return fmt.Errorf("%d of %d tests had different outputs to %s", regressed, len(cases), testAgainst)
+	}
+	console.Infof("All %d tests had the same outputs as %s", len(cases), testAgainst)
+	return nil
+}
+
+func testAgainstDataset(cmd *cobra.Command, args []string, cases []testCase) error {
+	return withPredictor(cmd, args, func(predictor predict.Predictor, _ *config.Output) error {
+		results := []testResult{}
+		regressed := 0
+		tested := 0
+		for _, c := range cases {
+			if !c.hasExpected {
+				console.Warnf("Skipping %s, because it doesn't have an output in the dataset", c.name)
+				continue
+			}
+			console.Infof("Running %s...", c.name)
+			// The dataset's inputs are used as they are, without --seed, so they're the same as when it was recorded
+			output, err := runTestPrediction(predictor, c.inputs)
+			if err != nil {
+				return err
+			}
+			status, differences := determinism.Diff(c.expected, output, c.options)
+			results = append(results, testResult{Name: c.name, Status: status, Differences: differences})
+			if status == determinism.StatusDivergent {
+				regressed++
+			}
+			tested++
+		}
+		if tested == 0 {
+			return fmt.Errorf("None of the examples in %s have outputs to compare with", testDataset)
+		}
+		if err := printTestResults(results); err != nil {
+			return err
+		}
+		if regressed > 0 {
+			return fmt.Errorf("%d of %d examples had different outputs to %s", regressed, tested, testDataset)
+		}
+		console.Infof("All %d examples had the same outputs as %s", tested, testDataset)
+		return nil
+	})
+}
+
+// runTestPrediction runs a prediction and returns its output. If the prediction fails, its error is the output, so
+// it's compared like any other.
+func runTestPrediction(predictor predict.Predictor, inputs predict.Inputs) (any, error) {
+	prediction, err := predictor.Predict(inputs)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to predict: %w", err)
+	}
+	if prediction.Status == "failed" {
+		console.Warnf("Prediction failed: %s", prediction.Error)
+		return map[string]any{"error": prediction.Error}, nil
+	}
+	if prediction.Output == nil {
+		return nil, nil
+	}
+	return *prediction.Output, nil
+}
+
+// seedInputs returns inputs with the model's seed input set to --seed, if it has one and it isn't set already
+func seedInputs(schema *openapi3.T, inputs predict.Inputs) predict.Inputs {
+	if _, ok := inputs["seed"]; ok {
+		return inputs
+	}
+	input := schema.Components.Schemas["Input"]
+	if input == nil || input.Value.Properties["seed"] == nil {
+		console.Warn("The model doesn't have a seed input, so it has to seed itself for its output to be deterministic")
+		return inputs
+	}
+	seeded := predict.Inputs{}
+	for k, v := range inputs {
+		seeded[k] = v
+	}
+	seed := strconv.Itoa(testSeed)
+	seeded["seed"] = predict.Input{String: &seed}
+	return seeded
+}
+
+func worstStatus(results []testResult) string {
+	statuses := []string{}
+	for _, result := range results {
+		statuses = append(statuses, result.Status)
+	}
+	return determinism.Worst(statuses...)
+}
+
+func printTestResults(results []testResult) error {
+	if testJSON {
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		console.Output(string(data))
+		return nil
+	}
+	for _, result := range results {
+		console.Infof("%s: %s", result.Name, result.Status)
+		for i, run := range result.Runs {
+			status := run.Status
+			if i == 0 {
+				status = "first run"
+			}
+			console.Infof("  Run %d: %s (%s)", i+1, status, run.Hash)
+			printDifferences(run.Differences, "    ")
+		}
+		printDifferences(result.Differences, "  ")
+	}
+	return nil
+}
+
+func printDifferences(differences []determinism.Difference, indent string) {
+	for _, d := range differences {
+		console.Infof("%s%s %s: %s", indent, d.Status, d.Path, d.Message)
+	}
+}
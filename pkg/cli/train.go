@@ -13,6 +13,7 @@ import (
 	"github.com/replicate/cog/pkg/docker"
 	"github.com/replicate/cog/pkg/image"
 	"github.com/replicate/cog/pkg/predict"
+	"github.com/replicate/cog/pkg/transform"
 	"github.com/replicate/cog/pkg/util/console"
 )
 
@@ -43,8 +44,10 @@ Otherwise, it will build the model in the current directory and train it.`,
 	addGpusFlag(cmd)
 	addUseCogBaseImageFlag(cmd)
 	addFastFlag(cmd)
+	addMaxInputSizeFlag(cmd)
+	addSecurityFlags(cmd)
 
-	cmd.Flags().StringArrayVarP(&trainInputFlags, "input", "i", []string{}, "Inputs, in the form name=value. if value is prefixed with @, then it is read from a file on disk. E.g. -i path=@image.jpg")
+	cmd.Flags().StringArrayVarP(&trainInputFlags, "input", "i", []string{}, "Inputs, in the form name=value. if value is prefixed with @, then it is read from a file on disk or downloaded from a URL. E.g. -i path=@image.jpg or -i path=@https://example.com/image.jpg")
 	cmd.Flags().StringArrayVarP(&trainEnvFlags, "env", "e", []string{}, "Environment variables, in the form name=value")
 	cmd.Flags().StringVarP(&trainOutPath, "output", "o", "weights", "Output path")
 
@@ -60,6 +63,8 @@ func cmdTrain(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	security := image.ConfigSecurityOptions(cfg, projectDir)
+	runConfig := cfg.Run
 
 	if len(args) == 0 {
 		// Build image
@@ -68,6 +73,9 @@ func cmdTrain(cmd *cobra.Command, args []string) error {
 			buildFast = cfg.Build.Fast
 		}
 
+		if err := verifyBaseImage(cmd, cfg, projectDir, false); err != nil {
+			return err
+		}
 		if imageName, err = image.BuildBase(cfg, projectDir, buildUseCudaBaseImage, DetermineUseCogBaseImage(cmd), buildProgressOutput); err != nil {
 			return err
 		}
@@ -78,8 +86,8 @@ func cmdTrain(cmd *cobra.Command, args []string) error {
 			Destination: "/src",
 		})
 
-		if gpus == "" && cfg.Build.GPU {
-			gpus = "all"
+		if gpus, err = selectGPUs(cfg.Build); err != nil {
+			return err
 		}
 	} else {
 		// Use existing image
@@ -99,12 +107,23 @@ func cmdTrain(cmd *cobra.Command, args []string) error {
 		if err != nil {
 			return err
 		}
-		if gpus == "" && conf.Build.GPU {
-			gpus = "all"
+		if gpus, err = selectGPUs(conf.Build); err != nil {
+			return err
 		}
 		if conf.Build.Fast {
 			buildFast = conf.Build.Fast
 		}
+		if security, err = image.SecurityOptions(imageName); err != nil {
+			return err
+		}
+		runConfig = conf.Run
+	}
+	containerRuntime, err := sandboxRuntime(cmd, runConfig, gpus)
+	if err != nil {
+		return err
+	}
+	if security, err = securityOptions(cmd, security); err != nil {
+		return err
 	}
 
 	console.Info("")
@@ -112,11 +131,13 @@ func cmdTrain(cmd *cobra.Command, args []string) error {
 	dockerCommand := docker.NewDockerCommand()
 
 	predictor, err := predict.NewPredictor(docker.RunOptions{
-		GPUs:    gpus,
-		Image:   imageName,
-		Volumes: volumes,
-		Env:     trainEnvFlags,
-		Args:    []string{"python", "-m", "cog.server.http", "--x-mode", "train"},
+		GPUs:     gpus,
+		Image:    imageName,
+		Volumes:  volumes,
+		Env:      trainEnvFlags,
+		Args:     []string{"python", "-m", "cog.server.http", "--x-mode", "train"},
+		Security: security,
+		Runtime:  containerRuntime,
 	}, true, buildFast, dockerCommand)
 	if err != nil {
 		return err
@@ -135,6 +156,7 @@ func cmdTrain(cmd *cobra.Command, args []string) error {
 	}()
 
 	if err := predictor.Start(os.Stderr, time.Duration(setupTimeout)*time.Second); err != nil {
+		stopCrashedPredictor(predictor)
 		return err
 	}
 
@@ -146,5 +168,9 @@ func cmdTrain(cmd *cobra.Command, args []string) error {
 		}
 	}()
 
-	return predictIndividualInputs(*predictor, trainInputFlags, trainOutPath, true)
+	if err := predictIndividualInputs(*predictor, trainInputFlags, trainOutPath, true, transform.Options{}); err != nil {
+		diagnoseCrash(predictor)
+		return err
+	}
+	return nil
 }
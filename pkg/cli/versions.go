@@ -0,0 +1,154 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/util/console"
+	"github.com/replicate/cog/pkg/versions"
+)
+
+var versionsJSON bool
+
+func newVersionsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "versions",
+		Short: "List and compare the versions of the model that were built or pushed from this project",
+		Long: `List and compare the versions of the model that were built or pushed from this project.
+
+Each build and push is recorded in .cog/versions.json, with the git commit it was built from
+and hashes of its config and schema.`,
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List the versions of the model, newest first",
+		Args:  cobra.NoArgs,
+		RunE:  listVersions,
+	}
+	list.Flags().BoolVar(&versionsJSON, "json", false, "Print the versions as JSON")
+
+	diff := &cobra.Command{
+		Use:   "diff [VERSION] [VERSION]",
+		Short: "Show what changed between two versions of the model",
+		Long: `Show what changed between two versions of the model.
+
+Versions are image IDs, or the start of them. With one version, it's compared with the
+version built before it. With none, the latest version is compared with the one before it.`,
+		Example: `  cog versions diff
+  cog versions diff 3f2a9c1b
+  cog versions diff 3f2a9c1b 8d01e6aa`,
+		Args: cobra.MaximumNArgs(2),
+		RunE: diffVersions,
+	}
+
+	cmd.AddCommand(list, diff)
+	return cmd
+}
+
+func listVersions(cmd *cobra.Command, args []string) error {
+	_, projectDir, err := config.GetConfig(projectDirFlag)
+	if err != nil {
+		return err
+	}
+	log, err := versions.Load(projectDir)
+	if err != nil {
+		return err
+	}
+
+	if versionsJSON {
+		data, err := json.MarshalIndent(log.Versions, "", "  ")
+		if err != nil {
+			return err
+		}
+		console.Output(string(data))
+		return nil
+	}
+	if len(log.Versions) == 0 {
+		console.Info("No versions have been built from this project yet")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tBUILT\tCOMMIT\tIMAGE\tPUSHED")
+	for i := len(log.Versions) - 1; i >= 0; i-- {
+		version := log.Versions[i]
+		commit := shortCommit(version.GitCommit)
+		if version.GitDirty {
+			commit += " (dirty)"
+		}
+		pushed := ""
+		if version.PushedAt != nil {
+			pushed = version.PushedAt.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", version.ShortID(), version.BuiltAt.Local().Format(time.DateTime), commit, version.Image, pushed)
+	}
+	return w.Flush()
+}
+
+func diffVersions(cmd *cobra.Command, args []string) error {
+	_, projectDir, err := config.GetConfig(projectDirFlag)
+	if err != nil {
+		return err
+	}
+	log, err := versions.Load(projectDir)
+	if err != nil {
+		return err
+	}
+
+	var a, b *versions.Version
+	switch len(args) {
+	case 2:
+		if a, err = log.Find(args[0]); err != nil {
+			return err
+		}
+		if b, err = log.Find(args[1]); err != nil {
+			return err
+		}
+	default:
+		if len(args) == 1 {
+			if b, err = log.Find(args[0]); err != nil {
+				return err
+			}
+		} else if b = log.Latest(); b == nil {
+			return fmt.Errorf("No versions have been built from this project yet")
+		}
+		if b.Parent == "" {
+			return fmt.Errorf("Version %s is the first version, so there's nothing to compare it with", b.ShortID())
+		}
+		if a, err = log.Find(b.Parent); err != nil {
+			return err
+		}
+	}
+
+	console.Infof("Comparing %s with %s", a.ShortID(), b.ShortID())
+	changes := versions.Diff(a, b)
+	if len(changes) == 0 {
+		console.Output("No changes")
+		return nil
+	}
+	for _, change := range changes {
+		console.Output(change)
+	}
+	return nil
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 8 {
+		return commit[:8]
+	}
+	return commit
+}
+
+// recordVersion adds imageName to the project's version log. It's only a record, so failures don't fail the build.
+func recordVersion(projectDir string, imageName string, pushed bool) {
+	if _, err := versions.Record(projectDir, imageName, pushed); err != nil {
+		console.Warnf("Failed to record version in .cog/versions.json: %s", err)
+	}
+}
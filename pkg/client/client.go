@@ -0,0 +1,271 @@
+// Package client builds, inspects and runs Cog models from Go programs, without shelling out to the cog binary.
+//
+// It does the same things as cog build, cog predict and cog inspect, but returns results instead of printing
+// them. Docker still needs to be installed, as it does for the CLI.
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/getkin/kin-openapi/openapi3"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/docker/command"
+	"github.com/replicate/cog/pkg/hooks"
+	"github.com/replicate/cog/pkg/image"
+	"github.com/replicate/cog/pkg/predict"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// DefaultPredictTimeout is how long Predict waits for a model's setup to finish if PredictRequest.Timeout isn't set
+const DefaultPredictTimeout = 5 * time.Minute
+
+// Options configure a Client
+type Options struct {
+	// Logs is where build output and messages are written. If it's nil, they're discarded.
+	Logs io.Writer
+}
+
+// Client builds, inspects and runs models
+type Client struct {
+	logs io.Writer
+}
+
+// consoleMu is held while a Client's messages are written to its logs. Cog's packages log through one console for
+// the whole process, so Clients take turns at it.
+var consoleMu sync.Mutex
+
+// New returns a Client that writes messages to options.Logs
+func New(options Options) *Client {
+	logs := options.Logs
+	if logs == nil {
+		logs = io.Discard
+	}
+	return &Client{logs: logs}
+}
+
+// logging writes messages to the Client's logs until the function it returns is called, and then writes them where
+// they were written before. Only one Client's call can log at a time, so calls from other Clients wait for it.
+func (c *Client) logging() func() {
+	consoleMu.Lock()
+	previous := console.SetWriter(c.logs)
+	return func() {
+		console.SetWriter(previous)
+		consoleMu.Unlock()
+	}
+}
+
+// BuildOptions are the options for Build, which match the flags for cog build
+type BuildOptions struct {
+	// ProjectDir is the directory with cog.yaml in it. If it's empty, it's found from the working directory.
+	ProjectDir string `json:"project_dir,omitempty"`
+	// Image is the name of the image to build. If it's empty, image in cog.yaml is used, or a name is made from
+	// ProjectDir.
+	Image           string   `json:"image,omitempty"`
+	Secrets         []string `json:"secrets,omitempty"`
+	NoCache         bool     `json:"no_cache,omitempty"`
+	SeparateWeights bool     `json:"separate_weights,omitempty"`
+	// UseCudaBaseImage is "auto", "true" or "false". If it's empty, it's "auto".
+	UseCudaBaseImage string `json:"use_cuda_base_image,omitempty"`
+	// UseCogBaseImage is whether to build on a cog base image. If it's nil, one is used if there's one for the
+	// model's Python, CUDA and Torch versions.
+	UseCogBaseImage *bool  `json:"use_cog_base_image,omitempty"`
+	SchemaFile      string `json:"schema_file,omitempty"`
+	Dockerfile      string `json:"dockerfile,omitempty"`
+	// ProgressOutput is "auto", "tty" or "plain". If it's empty, it's "plain".
+	ProgressOutput string            `json:"progress_output,omitempty"`
+	Annotations    map[string]string `json:"annotations,omitempty"`
+}
+
+// ImageMetadata is what Cog records about a model in its image
+type ImageMetadata struct {
+	Image      string            `json:"image"`
+	ID         string            `json:"id"`
+	Size       int64             `json:"size"`
+	CogVersion string            `json:"cog_version"`
+	Config     *config.Config    `json:"config"`
+	Schema     *openapi3.T       `json:"schema,omitempty"`
+	Labels     map[string]string `json:"labels"`
+}
+
+// PredictRequest is a prediction to run with Predict
+type PredictRequest struct {
+	// Image is the model to run. It's pulled if it doesn't exist locally.
+	Image string
+	// Inputs are the inputs to the model. Values prefixed with @ are read from files, like cog predict -i.
+	Inputs map[string]string
+	// BaseDir is the directory files in Inputs are relative to. If it's empty, it's the working directory.
+	BaseDir string
+	// GPUs is passed to docker run --gpus. If it's empty, all GPUs are used by models that need a GPU.
+	GPUs string
+	// Env are environment variables for the model, in the form name=value
+	Env []string
+	// Timeout is how long to wait for the model's setup to finish. If it's zero, it's DefaultPredictTimeout.
+	Timeout time.Duration
+}
+
+// PredictResponse is the result of a prediction
+type PredictResponse struct {
+	Status string `json:"status"`
+	Output any    `json:"output"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Build builds the model in options.ProjectDir and returns the metadata of the image it built
+func (c *Client) Build(options BuildOptions) (*ImageMetadata, error) {
+	defer c.logging()()
+
+	cfg, projectDir, err := config.GetConfig(options.ProjectDir)
+	if err != nil {
+		return nil, err
+	}
+
+	imageName := options.Image
+	if imageName == "" {
+		imageName = cfg.Image
+	}
+	if imageName == "" {
+		imageName = config.DockerImageName(projectDir)
+	}
+	useCudaBaseImage := options.UseCudaBaseImage
+	if useCudaBaseImage == "" {
+		useCudaBaseImage = "auto"
+	}
+	progressOutput := options.ProgressOutput
+	if progressOutput == "" {
+		progressOutput = "plain"
+	}
+
+	if err := hooks.RunPreBuild(cfg, projectDir, imageName); err != nil {
+		return nil, err
+	}
+	if err := image.Build(cfg, projectDir, imageName, options.Secrets, nil, options.NoCache, options.SeparateWeights, useCudaBaseImage, progressOutput, options.SchemaFile, options.Dockerfile, options.UseCogBaseImage, false, false, cfg.Build.Fast, options.Annotations, false, nil); err != nil {
+		return nil, err
+	}
+	return inspectImage(imageName)
+}
+
+// Inspect returns the metadata of a model's image, which has to exist locally
+func (c *Client) Inspect(imageName string) (*ImageMetadata, error) {
+	defer c.logging()()
+	return inspectImage(imageName)
+}
+
+func inspectImage(imageName string) (*ImageMetadata, error) {
+	inspect, err := docker.ImageInspect(imageName)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to inspect %s: %w", imageName, err)
+	}
+	return metadataFromInspect(imageName, inspect)
+}
+
+// ListImages lists the models in the local Docker daemon
+func (c *Client) ListImages() ([]docker.ImageSummary, error) {
+	defer c.logging()()
+
+	images, err := docker.ImageList("label=" + command.CogVersionLabelKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list images: %w", err)
+	}
+	return images, nil
+}
+
+// Predict starts the model, runs a prediction, and stops the model
+func (c *Client) Predict(req PredictRequest) (*PredictResponse, error) {
+	defer c.logging()()
+
+	exists, err := docker.ImageExists(req.Image)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to determine if %s exists: %w", req.Image, err)
+	}
+	if !exists {
+		console.Infof("Pulling image: %s", req.Image)
+		if err := docker.Pull(req.Image); err != nil {
+			return nil, fmt.Errorf("Failed to pull %s: %w", req.Image, err)
+		}
+	}
+	metadata, err := inspectImage(req.Image)
+	if err != nil {
+		return nil, err
+	}
+
+	gpus := req.GPUs
+	if gpus == "" && metadata.Config.Build.GPU {
+		gpus = "all"
+	}
+	timeout := req.Timeout
+	if timeout == 0 {
+		timeout = DefaultPredictTimeout
+	}
+	baseDir := req.BaseDir
+	if baseDir == "" {
+		if baseDir, err = os.Getwd(); err != nil {
+			return nil, err
+		}
+	}
+
+	predictor, err := predict.NewPredictor(docker.RunOptions{
+		GPUs:  gpus,
+		Image: req.Image,
+		Env:   req.Env,
+	}, false, metadata.Config.Build.Fast, docker.NewDockerCommand())
+	if err != nil {
+		return nil, err
+	}
+	if err := predictor.Start(console.Writer(), timeout); err != nil {
+		if predictor.ContainerID() != "" {
+			_ = predictor.Stop()
+		}
+		return nil, err
+	}
+	defer func() {
+		if err := predictor.Stop(); err != nil {
+			console.Warnf("Failed to stop container: %s", err)
+		}
+	}()
+
+	response, err := predictor.Predict(predict.NewInputsWithBaseDir(req.Inputs, baseDir))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to predict: %w", err)
+	}
+	result := &PredictResponse{Status: string(response.Status), Error: response.Error}
+	if response.Output != nil {
+		result.Output = *response.Output
+	}
+	return result, nil
+}
+
+func metadataFromInspect(imageName string, inspect *types.ImageInspect) (*ImageMetadata, error) {
+	metadata := &ImageMetadata{Image: imageName, ID: inspect.ID, Size: inspect.Size}
+	if inspect.Config == nil {
+		return nil, fmt.Errorf("Image %s does not appear to be a Cog model", imageName)
+	}
+	metadata.Labels = inspect.Config.Labels
+
+	configString := metadata.Labels[command.CogConfigLabelKey]
+	if configString == "" {
+		return nil, fmt.Errorf("Image %s does not appear to be a Cog model", imageName)
+	}
+	metadata.Config = new(config.Config)
+	if err := json.Unmarshal([]byte(configString), metadata.Config); err != nil {
+		return nil, fmt.Errorf("Failed to parse config from %s: %w", imageName, err)
+	}
+
+	metadata.CogVersion = metadata.Labels[command.CogVersionLabelKey]
+
+	if schemaString := metadata.Labels[command.CogOpenAPISchemaLabelKey]; schemaString != "" {
+		schema, err := openapi3.NewLoader().LoadFromData([]byte(schemaString))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse schema from %s: %w", imageName, err)
+		}
+		metadata.Schema = schema
+	}
+	return metadata, nil
+}
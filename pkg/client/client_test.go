@@ -0,0 +1,70 @@
+package client
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/docker/command"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+func TestMetadataFromInspect(t *testing.T) {
+	inspect := &types.ImageInspect{
+		ID:   "sha256:abc",
+		Size: 1024,
+		Config: &container.Config{
+			Labels: map[string]string{
+				command.CogConfigLabelKey:        `{"build":{"gpu":true,"python_version":"3.11"},"predict":"predict.py:Predictor"}`,
+				command.CogVersionLabelKey:       "0.9.0",
+				command.CogOpenAPISchemaLabelKey: `{"openapi":"3.0.2","info":{"title":"Cog","version":"0.1.0"},"paths":{}}`,
+			},
+		},
+	}
+	metadata, err := metadataFromInspect("my-model", inspect)
+	require.NoError(t, err)
+	require.Equal(t, "my-model", metadata.Image)
+	require.Equal(t, "sha256:abc", metadata.ID)
+	require.Equal(t, int64(1024), metadata.Size)
+	require.Equal(t, "0.9.0", metadata.CogVersion)
+	require.True(t, metadata.Config.Build.GPU)
+	require.Equal(t, "predict.py:Predictor", metadata.Config.Predict)
+	require.Equal(t, "Cog", metadata.Schema.Info.Title)
+}
+
+func TestMetadataFromInspectNotCogModel(t *testing.T) {
+	inspect := &types.ImageInspect{
+		ID:     "sha256:abc",
+		Config: &container.Config{Labels: map[string]string{}},
+	}
+	_, err := metadataFromInspect("ubuntu", inspect)
+	require.ErrorContains(t, err, "does not appear to be a Cog model")
+}
+
+func TestClientLogs(t *testing.T) {
+	var other bytes.Buffer
+	previous := console.SetWriter(&other)
+	defer console.SetWriter(previous)
+
+	var a, b bytes.Buffer
+	clientA := New(Options{Logs: &a})
+	clientB := New(Options{Logs: &b})
+
+	done := clientA.logging()
+	console.Info("building a")
+	done()
+	done = clientB.logging()
+	console.Info("building b")
+	done()
+	console.Info("something else")
+
+	require.Contains(t, a.String(), "building a")
+	require.NotContains(t, a.String(), "building b")
+	require.Contains(t, b.String(), "building b")
+	require.NotContains(t, b.String(), "building a")
+	require.Contains(t, other.String(), "something else")
+	require.NotContains(t, other.String(), "building")
+}
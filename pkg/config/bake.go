@@ -0,0 +1,52 @@
+package config
+
+import (
+	"fmt"
+	"path"
+)
+
+// bakeWorkdir is where relative paths in build.bake.paths are resolved, the directory the model runs in
+const bakeWorkdir = "/src"
+
+// Bake configures running the model's setup once the image is built, so files it generates, like compiled
+// kernels or TensorRT engines, are added to the image instead of being generated every time the model starts
+type Bake struct {
+	// Paths are the files and directories setup writes that are added to the image, relative to /src or absolute
+	Paths []string `json:"paths,omitempty" yaml:"paths"`
+}
+
+// BakePaths returns the absolute paths in the container that are baked into the image, or nil if
+// build.bake isn't set
+func (b *Build) BakePaths() []string {
+	if b.Bake == nil {
+		return nil
+	}
+	paths := []string{}
+	for _, p := range b.Bake.Paths {
+		if !path.IsAbs(p) {
+			p = path.Join(bakeWorkdir, p)
+		}
+		paths = append(paths, path.Clean(p))
+	}
+	return paths
+}
+
+func (b *Build) validateBake() error {
+	if b.Bake == nil {
+		return nil
+	}
+	if len(b.Bake.Paths) == 0 {
+		return fmt.Errorf("'build.bake.paths' in cog.yaml must list the files or directories that setup generates")
+	}
+	seen := map[string]bool{}
+	for i, p := range b.BakePaths() {
+		if p == "/" || p == bakeWorkdir {
+			return fmt.Errorf("'build.bake.paths' in cog.yaml can't include %q, list the files or directories that setup generates instead", b.Bake.Paths[i])
+		}
+		if seen[p] {
+			return fmt.Errorf("%q is listed more than once in 'build.bake.paths' in cog.yaml", b.Bake.Paths[i])
+		}
+		seen[p] = true
+	}
+	return nil
+}
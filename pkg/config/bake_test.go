@@ -0,0 +1,37 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBakePaths(t *testing.T) {
+	build := &Build{Bake: &Bake{Paths: []string{".cache/torchinductor", "/root/.cache/huggingface/", "engines/../model.plan"}}}
+	require.Equal(t, []string{"/src/.cache/torchinductor", "/root/.cache/huggingface", "/src/model.plan"}, build.BakePaths())
+	require.Nil(t, (&Build{}).BakePaths())
+}
+
+func TestValidateBake(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		build *Build
+		err   string
+	}{
+		{"valid", &Build{Bake: &Bake{Paths: []string{"engines", "/root/.triton"}}}, ""},
+		{"not set", &Build{}, ""},
+		{"no paths", &Build{Bake: &Bake{}}, "must list"},
+		{"root", &Build{Bake: &Bake{Paths: []string{"/"}}}, "can't include \"/\""},
+		{"source directory", &Build{Bake: &Bake{Paths: []string{"."}}}, "can't include \".\""},
+		{"duplicate", &Build{Bake: &Bake{Paths: []string{"engines", "/src/engines/"}}}, "more than once"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.build.validateBake()
+			if tt.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/replicate/cog/pkg/util/version"
+)
+
+// maxSuggestedCombinations is the number of compatible combinations suggested when a build's combination isn't compatible
+const maxSuggestedCombinations = 3
+
+// compatibleCombination is a version of a package that's known to work with a version of Python and CUDA
+type compatibleCombination struct {
+	Package string
+	Version string
+	Python  string
+	// CUDA is empty for CPU packages
+	CUDA string
+}
+
+func (c compatibleCombination) String() string {
+	s := fmt.Sprintf("%s==%s, Python %s", c.Package, c.Version, c.Python)
+	if c.CUDA != "" {
+		s += ", CUDA " + c.CUDA
+	}
+	return s
+}
+
+func torchCombinations() []compatibleCombination {
+	combinations := []compatibleCombination{}
+	for _, compat := range TorchCompatibilityMatrix {
+		cuda := ""
+		if compat.CUDA != nil {
+			cuda = *compat.CUDA
+		}
+		for _, python := range compat.Pythons {
+			combinations = append(combinations, compatibleCombination{Package: "torch", Version: compat.TorchVersion(), Python: python, CUDA: cuda})
+		}
+	}
+	return combinations
+}
+
+func tfCombinations() []compatibleCombination {
+	combinations := []compatibleCombination{}
+	for _, compat := range TFCompatibilityMatrix {
+		for _, python := range compat.Pythons {
+			combinations = append(combinations, compatibleCombination{Package: "tensorflow", Version: compat.TF, Python: python, CUDA: compat.CUDA})
+		}
+	}
+	return combinations
+}
+
+// validateCompatibility checks the versions of torch and tensorflow in cog.yaml against the bundled compatibility
+// matrices, so builds with a Python or CUDA version the package doesn't support fail early instead of deep inside
+// pip or at runtime. Versions that aren't in the matrices are left to the warnings in validateAndCompleteCUDA.
+func (c *Config) validateCompatibility() error {
+	if torchVersion, ok := c.TorchVersion(); ok {
+		if err := c.checkCompatibility("torch", version.StripModifier(torchVersion), torchCombinations()); err != nil {
+			return err
+		}
+	}
+	if tfVersion, ok := c.TensorFlowVersion(); ok {
+		if err := c.checkCompatibility("tensorflow", tfVersion, tfCombinations()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Config) checkCompatibility(pkg string, pkgVersion string, combinations []compatibleCombination) error {
+	python := c.Build.PythonVersion
+	cuda := ""
+	if c.Build.GPU {
+		cuda = c.Build.CUDA
+	}
+
+	known := false
+	for _, combination := range combinations {
+		if !versionsEqual(combination.Version, pkgVersion) {
+			continue
+		}
+		known = true
+		if python != "" && !pythonVersionsEqual(combination.Python, python) {
+			continue
+		}
+		if cuda != "" && (combination.CUDA == "" || !cudaMajorsEqual(combination.CUDA, cuda)) {
+			continue
+		}
+		return nil
+	}
+	if !known {
+		return nil
+	}
+
+	declared := []string{}
+	if python != "" {
+		declared = append(declared, "Python "+python)
+	}
+	if cuda != "" {
+		declared = append(declared, "CUDA "+cuda)
+	}
+	suggestions := []string{}
+	for _, combination := range nearestCombinations(combinations, pkgVersion, python, c.Build.GPU, cuda) {
+		suggestions = append(suggestions, "  "+combination.String())
+	}
+	return fmt.Errorf("%s==%s isn't compatible with %s in cog.yaml.\n\nThe nearest compatible combinations are:\n%s",
+		pkg, pkgVersion, strings.Join(declared, " and "), strings.Join(suggestions, "\n"))
+}
+
+// nearestCombinations returns the compatible combinations that change the least of what's in cog.yaml,
+// preferring to keep the package version, then the Python version, then the CUDA version.
+func nearestCombinations(combinations []compatibleCombination, pkgVersion string, python string, gpu bool, cuda string) []compatibleCombination {
+	versions := []string{}
+	candidates := []compatibleCombination{}
+	seen := map[compatibleCombination]bool{}
+	for _, combination := range combinations {
+		if gpu && combination.CUDA == "" {
+			continue
+		}
+		if !gpu {
+			// CPU builds don't use CUDA, so only the Python version matters
+			combination.CUDA = ""
+		}
+		if seen[combination] {
+			continue
+		}
+		seen[combination] = true
+		candidates = append(candidates, combination)
+		if !sliceContains(versions, combination.Version) {
+			versions = append(versions, combination.Version)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return versionLess(versions[i], versions[j]) })
+	rank := map[string]int{}
+	for i, v := range versions {
+		rank[v] = i
+	}
+
+	distance := func(combination compatibleCombination) []int {
+		mismatches := 0
+		if !versionsEqual(combination.Version, pkgVersion) {
+			mismatches += 4
+		}
+		if python != "" && !pythonVersionsEqual(combination.Python, python) {
+			mismatches += 2
+		}
+		if cuda != "" && !cudaMajorsEqual(combination.CUDA, cuda) {
+			mismatches++
+		}
+		return []int{mismatches, abs(rank[combination.Version] - rankOf(versions, pkgVersion)), abs(minorDistance(combination.Python, python))}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := distance(candidates[i]), distance(candidates[j])
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		// Prefer newer versions when they're equally near
+		if candidates[i].Version != candidates[j].Version {
+			return versionLess(candidates[j].Version, candidates[i].Version)
+		}
+		if candidates[i].Python != candidates[j].Python {
+			return versionLess(candidates[j].Python, candidates[i].Python)
+		}
+		return versionLess(candidates[j].CUDA, candidates[i].CUDA)
+	})
+	if len(candidates) > maxSuggestedCombinations {
+		candidates = candidates[:maxSuggestedCombinations]
+	}
+	return candidates
+}
+
+// rankOf returns the index of v in the sorted versions, or where it would be inserted
+func rankOf(versions []string, v string) int {
+	for i, other := range versions {
+		if !versionLess(other, v) {
+			return i
+		}
+	}
+	return len(versions)
+}
+
+func versionsEqual(a string, b string) bool {
+	va, errA := version.NewVersion(a)
+	vb, errB := version.NewVersion(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return va.Equal(vb)
+}
+
+func versionLess(a string, b string) bool {
+	va, errA := version.NewVersion(a)
+	vb, errB := version.NewVersion(b)
+	if errA != nil || errB != nil {
+		return a < b
+	}
+	return vb.Greater(va)
+}
+
+func pythonVersionsEqual(a string, b string) bool {
+	aMajor, aMinor, errA := splitPythonVersion(a)
+	bMajor, bMinor, errB := splitPythonVersion(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return aMajor == bMajor && aMinor == bMinor
+}
+
+func minorDistance(a string, b string) int {
+	_, aMinor, errA := splitPythonVersion(a)
+	_, bMinor, errB := splitPythonVersion(b)
+	if errA != nil || errB != nil {
+		return 0
+	}
+	return aMinor - bMinor
+}
+
+// cudaMajorsEqual reports whether two CUDA versions have the same major version. Torch and Tensorflow
+// packages bundle or load the CUDA libraries they need, so they work with other minor versions.
+func cudaMajorsEqual(a string, b string) bool {
+	va, errA := version.NewVersion(a)
+	vb, errB := version.NewVersion(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return va.Major == vb.Major
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
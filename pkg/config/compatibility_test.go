@@ -18,3 +18,50 @@ func TestCudasFromTorchWithCUVersionModifier(t *testing.T) {
 	require.Equal(t, cudas[0], "11.8")
 	require.Nil(t, err)
 }
+
+func TestValidateCompatibility(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		build    *Build
+		expected string
+	}{
+		{
+			name:  "compatible",
+			build: &Build{GPU: true, PythonVersion: "3.11", CUDA: "11.8", PythonPackages: []string{"torch==2.0.1"}},
+		},
+		{
+			name:  "compatible with a different minor CUDA version",
+			build: &Build{GPU: true, PythonVersion: "3.11", CUDA: "12.2", PythonPackages: []string{"torch==2.5.1"}},
+		},
+		{
+			name:  "unknown version",
+			build: &Build{PythonVersion: "3.12", PythonPackages: []string{"torch==0.1.0"}},
+		},
+		{
+			name:     "incompatible python",
+			build:    &Build{PythonVersion: "3.8", PythonPackages: []string{"torch==2.5.1"}},
+			expected: "torch==2.5.1 isn't compatible with Python 3.8 in cog.yaml.\n\nThe nearest compatible combinations are:\n  torch==2.5.1, Python 3.9\n  torch==2.5.1, Python 3.10\n  torch==2.5.1, Python 3.11",
+		},
+		{
+			name:     "incompatible cuda",
+			build:    &Build{GPU: true, PythonVersion: "3.11", CUDA: "12.1", PythonPackages: []string{"torch==2.0.1"}},
+			expected: "torch==2.0.1 isn't compatible with Python 3.11 and CUDA 12.1 in cog.yaml.\n\nThe nearest compatible combinations are:\n  torch==2.0.1, Python 3.11, CUDA 11.8",
+		},
+		{
+			name:     "incompatible tensorflow",
+			build:    &Build{GPU: true, PythonVersion: "3.8", CUDA: "11.8", PythonPackages: []string{"tensorflow==2.16.1"}},
+			expected: "tensorflow==2.16.1 isn't compatible with Python 3.8 and CUDA 11.8 in cog.yaml.",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{Build: tt.build}
+			config.Build.pythonRequirementsContent = config.Build.PythonPackages
+			err := config.validateCompatibility()
+			if tt.expected == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tt.expected)
+			}
+		})
+	}
+}
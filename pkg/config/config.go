@@ -1,25 +1,33 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"path"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 
+	units "github.com/docker/go-units"
+	"github.com/google/go-containerregistry/pkg/name"
 	"gopkg.in/yaml.v2"
 
 	"github.com/replicate/cog/pkg/requirements"
 	"github.com/replicate/cog/pkg/util/console"
-	"github.com/replicate/cog/pkg/util/slices"
 	"github.com/replicate/cog/pkg/util/version"
 )
 
 var (
 	BuildSourceEpochTimestamp int64 = -1
 	BuildXCachePath           string
+	BuildXCacheFrom           []string
+	BuildSkipResolve          bool
+	BuildIntrospectWith       string
+	BuildResume               bool
+	BuildSkipDiskCheck        bool
 	PipPackageNameRegex       = regexp.MustCompile(`^([^>=<~ \n[#]+)`)
 )
 
@@ -35,6 +43,12 @@ const (
 	MinimumMajorCudaVersion                 int = 11
 )
 
+// Network modes for commands in build.run and for the model's container
+const (
+	NetworkDefault = "default"
+	NetworkNone    = "none"
+)
+
 type RunItem struct {
 	Command string `json:"command,omitempty" yaml:"command"`
 	Mounts  []struct {
@@ -42,21 +56,50 @@ type RunItem struct {
 		ID     string `json:"id,omitempty" yaml:"id"`
 		Target string `json:"target,omitempty" yaml:"target"`
 	} `json:"mounts,omitempty" yaml:"mounts"`
+	// Network is NetworkNone to run the command without network access
+	Network string `json:"network,omitempty" yaml:"network"`
 }
 
 type Build struct {
-	GPU                bool      `json:"gpu,omitempty" yaml:"gpu"`
-	PythonVersion      string    `json:"python_version,omitempty" yaml:"python_version"`
-	PythonRequirements string    `json:"python_requirements,omitempty" yaml:"python_requirements"`
-	PythonPackages     []string  `json:"python_packages,omitempty" yaml:"python_packages"` // Deprecated, but included for backwards compatibility
-	Run                []RunItem `json:"run,omitempty" yaml:"run"`
-	SystemPackages     []string  `json:"system_packages,omitempty" yaml:"system_packages"`
-	PreInstall         []string  `json:"pre_install,omitempty" yaml:"pre_install"` // Deprecated, but included for backwards compatibility
-	CUDA               string    `json:"cuda,omitempty" yaml:"cuda"`
-	CuDNN              string    `json:"cudnn,omitempty" yaml:"cudnn"`
-	Fast               bool      `json:"fast,omitempty" yaml:"fast"`
+	GPU                bool                    `json:"gpu,omitempty" yaml:"gpu"`
+	GPUCount           int                     `json:"gpu_count,omitempty" yaml:"gpu_count"`
+	PythonVersion      string                  `json:"python_version,omitempty" yaml:"python_version"`
+	PythonRequirements string                  `json:"python_requirements,omitempty" yaml:"python_requirements"`
+	PythonPackages     []string                `json:"python_packages,omitempty" yaml:"python_packages"` // Deprecated, but included for backwards compatibility
+	Run                []RunItem               `json:"run,omitempty" yaml:"run"`
+	SystemPackages     []string                `json:"system_packages,omitempty" yaml:"system_packages"`
+	PreInstall         []string                `json:"pre_install,omitempty" yaml:"pre_install"` // Deprecated, but included for backwards compatibility
+	CUDA               string                  `json:"cuda,omitempty" yaml:"cuda"`
+	CuDNN              string                  `json:"cudnn,omitempty" yaml:"cudnn"`
+	Fast               bool                    `json:"fast,omitempty" yaml:"fast"`
+	Cleanup            []string                `json:"cleanup,omitempty" yaml:"cleanup"`
+	NodeVersion        string                  `json:"node_version,omitempty" yaml:"node_version"`
+	ServerCommand      string                  `json:"server_command,omitempty" yaml:"server_command"`
+	OpenAPISchema      string                  `json:"openapi_schema,omitempty" yaml:"openapi_schema"`
+	RVersion           string                  `json:"r_version,omitempty" yaml:"r_version"`
+	RPackages          []string                `json:"r_packages,omitempty" yaml:"r_packages"`
+	MaxContextSize     string                  `json:"max_context_size,omitempty" yaml:"max_context_size"`
+	TmpDir             string                  `json:"tmp_dir,omitempty" yaml:"tmp_dir"`
+	Contexts           map[string]BuildContext `json:"contexts,omitempty" yaml:"contexts"`
+	PipIndexURL        string                  `json:"pip_index_url,omitempty" yaml:"pip_index_url"`
+	PipExtraIndexURLs  []string                `json:"pip_extra_index_urls,omitempty" yaml:"pip_extra_index_urls"`
+	PipTrustedHosts    []string                `json:"pip_trusted_hosts,omitempty" yaml:"pip_trusted_hosts"`
+	SSH                bool                    `json:"ssh,omitempty" yaml:"ssh"`
+	AptRepositories    []AptRepository         `json:"apt_repositories,omitempty" yaml:"apt_repositories"`
+	AptSnapshot        string                  `json:"apt_snapshot,omitempty" yaml:"apt_snapshot"`
+	Precompile         *Precompile             `json:"precompile,omitempty" yaml:"precompile"`
+	Bake               *Bake                   `json:"bake,omitempty" yaml:"bake"`
+	CogBaseImage       string                  `json:"cog_base_image,omitempty" yaml:"cog_base_image"`
+	Licenses           *Licenses               `json:"licenses,omitempty" yaml:"licenses"`
+	AllowSecrets       []string                `json:"allow_secrets,omitempty" yaml:"allow_secrets"`
+	LocalPackages      []string                `json:"local_packages,omitempty" yaml:"local_packages"`
+
+	// PinnedBaseImage is the base image, pinned by digest, whose signature was verified before the build with
+	// --verify-base. It isn't read from cog.yaml.
+	PinnedBaseImage string `json:"-" yaml:"-"`
 
 	pythonRequirementsContent []string
+	localPackages             []LocalPackage
 }
 
 type Concurrency struct {
@@ -69,11 +112,21 @@ type Example struct {
 }
 
 type Config struct {
-	Build       *Build       `json:"build" yaml:"build"`
-	Image       string       `json:"image,omitempty" yaml:"image"`
-	Predict     string       `json:"predict,omitempty" yaml:"predict"`
-	Train       string       `json:"train,omitempty" yaml:"train"`
-	Concurrency *Concurrency `json:"concurrency,omitempty" yaml:"concurrency"`
+	// Version is the version of cog.yaml's format. Older versions are migrated to CurrentVersion when they're loaded.
+	Version        int             `json:"version,omitempty" yaml:"version"`
+	Build          *Build          `json:"build" yaml:"build"`
+	Image          string          `json:"image,omitempty" yaml:"image"`
+	Predict        string          `json:"predict,omitempty" yaml:"predict"`
+	Train          string          `json:"train,omitempty" yaml:"train"`
+	Pipeline       []PipelineStep  `json:"pipeline,omitempty" yaml:"pipeline"`
+	Concurrency    *Concurrency    `json:"concurrency,omitempty" yaml:"concurrency"`
+	ServingBackend *ServingBackend `json:"serving_backend,omitempty" yaml:"serving_backend"`
+	Sidecars       []Sidecar       `json:"sidecars,omitempty" yaml:"sidecars"`
+	Setup          *Setup          `json:"setup,omitempty" yaml:"setup"`
+	Run            *Runtime        `json:"run,omitempty" yaml:"run"`
+	Output         *Output         `json:"output,omitempty" yaml:"output"`
+	Tests          []TestCase      `json:"tests,omitempty" yaml:"tests"`
+	Retry          *Retry          `json:"retry,omitempty" yaml:"retry"`
 }
 
 func DefaultConfig() *Config {
@@ -109,6 +162,7 @@ func (r *RunItem) UnmarshalYAML(unmarshal func(interface{}) error) error {
 				ID     string `yaml:"id"`
 				Target string `yaml:"target"`
 			} `yaml:"mounts,omitempty"`
+			Network string `yaml:"network,omitempty"`
 		}{}
 
 		if err := yaml.Unmarshal(data, &aux); err != nil {
@@ -140,6 +194,7 @@ func (r *RunItem) UnmarshalJSON(data []byte) error {
 				ID     string `json:"id"`
 				Target string `json:"target"`
 			} `json:"mounts,omitempty"`
+			Network string `json:"network,omitempty"`
 		}{}
 
 		jsonData, err := json.Marshal(v)
@@ -160,6 +215,9 @@ func (r *RunItem) UnmarshalJSON(data []byte) error {
 }
 
 func FromYAML(contents []byte) (*Config, error) {
+	// cog.yaml files written on Windows often have CRLF line endings and a byte order mark
+	contents = bytes.TrimPrefix(contents, []byte("\xef\xbb\xbf"))
+	contents = bytes.ReplaceAll(contents, []byte("\r\n"), []byte("\n"))
 	config := DefaultConfig()
 	if err := yaml.Unmarshal(contents, config); err != nil {
 		return nil, fmt.Errorf("Failed to parse config yaml: %w", err)
@@ -196,6 +254,12 @@ func (c *Config) TensorFlowVersion() (string, bool) {
 	return c.pythonPackageVersion("tensorflow")
 }
 
+// TorchCUDAs returns the version of torch in the requirements, and the CUDA versions it's built for, or "" if torch
+// isn't pinned
+func (c *Config) TorchCUDAs() (torchVersion string, torchCUDAs []string, err error) {
+	return c.cudasFromTorch()
+}
+
 func (c *Config) cudasFromTorch() (torchVersion string, torchCUDAs []string, err error) {
 	if version, ok := c.TorchVersion(); ok {
 		cudas, err := cudasFromTorch(version)
@@ -282,14 +346,72 @@ func (c *Config) ValidateAndComplete(projectDir string) error {
 		errs = append(errs, err)
 	}
 
+	if err := c.validateServingBackend(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.validatePipeline(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.validateSidecars(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.validateSetup(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := c.RetryPolicy(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.validateRuntime(projectDir); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.Build.ServerCommand != "" && c.Predict != "" {
+		errs = append(errs, fmt.Errorf("'predict' and 'build.server_command' cannot both be set in cog.yaml"))
+	}
+
+	if _, err := c.Build.MaxContextSizeBytes(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := c.Build.TmpDirPath(projectDir); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Build.validateContexts(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Build.validateSystemPackages(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := c.Build.AptSnapshotTimestamp(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.Build.GPUCount > 0 && !c.Build.GPU {
+		errs = append(errs, fmt.Errorf("'build.gpu_count' in cog.yaml needs 'build.gpu: true'"))
+	}
+
+	if c.Build.CogBaseImage != "" {
+		if _, err := name.ParseReference(c.Build.CogBaseImage); err != nil {
+			errs = append(errs, fmt.Errorf("Invalid 'build.cog_base_image' in cog.yaml: %w", err))
+		}
+	}
+
 	if c.Predict != "" {
-		if len(strings.Split(c.Predict, ".py:")) != 2 {
-			errs = append(errs, fmt.Errorf("'predict' in cog.yaml must be in the form 'predict.py:Predictor"))
+		if err := validatePredictRef(c.Predict); err != nil {
+			errs = append(errs, err)
 		}
 	}
 
 	if len(c.Build.PythonPackages) > 0 {
-		console.Warn("`python_packages` in cog.yaml is deprecated and will be removed in future versions, use `python_requirements` instead.")
+		console.Warn("`python_packages` in cog.yaml is deprecated and will be removed in future versions, use `python_requirements` instead. Run 'cog config migrate' to move them to requirements.txt.")
 		if c.Build.PythonRequirements != "" {
 			errs = append(errs, fmt.Errorf("Only one of python_packages or python_requirements can be set in your cog.yaml, not both"))
 		}
@@ -301,7 +423,7 @@ func (c *Config) ValidateAndComplete(projectDir string) error {
 
 	// Load python_requirements into memory to simplify reading it multiple times
 	if c.Build.PythonRequirements != "" {
-		c.Build.pythonRequirementsContent, err = requirements.ReadRequirements(path.Join(projectDir, c.Build.PythonRequirements))
+		c.Build.pythonRequirementsContent, err = requirements.ReadRequirements(filepath.Join(projectDir, c.Build.PythonRequirements))
 		if err != nil {
 			errs = append(errs, fmt.Errorf("Failed to open python_requirements file: %w", err))
 		}
@@ -312,7 +434,35 @@ func (c *Config) ValidateAndComplete(projectDir string) error {
 		c.Build.pythonRequirementsContent = c.Build.PythonPackages
 	}
 
-	if c.Build.GPU {
+	if err := c.Build.completeLocalPackages(projectDir); err != nil {
+		errs = append(errs, err)
+	} else if c.Build.PythonRequirements != "" {
+		if err := c.Build.validateHashPinned(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := c.Build.validatePrecompile(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Build.validateBake(); err != nil {
+		errs = append(errs, err)
+	} else if c.Build.Bake != nil && c.PredictorLanguage() != LanguagePython {
+		errs = append(errs, fmt.Errorf("'build.bake' in cog.yaml is only supported for Python predictors"))
+	}
+
+	if err := c.Build.validateLicenses(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Build.validateAllowSecrets(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.validateCompatibility(); err != nil {
+		errs = append(errs, err)
+	} else if c.Build.GPU {
 		if err := c.validateAndCompleteCUDA(); err != nil {
 			errs = append(errs, err)
 		}
@@ -340,13 +490,25 @@ func (c *Config) PythonRequirementsForArch(goos string, goarch string, includePa
 		includePackageNames = append(includePackageNames, packageName)
 	}
 
+	// Hashes are for particular files, so requirements pinned to them are installed as they are
+	if c.Build.RequirementsHashPinned() {
+		return strings.Join(c.Build.pythonRequirementsContent, "\n"), nil
+	}
+
 	// Include all the requirements and remove our include packages if they exist
+	hasTorchPackage := false
+	torchIndexResolved := false
 	for _, pkg := range c.Build.pythonRequirementsContent {
 		archPkg, findLinksList, extraIndexURLs, err := c.pythonPackageForArch(pkg, goos, goarch)
 		if err != nil {
 			return "", err
 		}
 		packages = append(packages, archPkg)
+		if IsTorchPackage(requirementName(archPkg)) {
+			hasTorchPackage = true
+			torchIndexResolved = torchIndexResolved || len(findLinksList) > 0 || len(extraIndexURLs) > 0
+		}
+		torchIndexResolved = torchIndexResolved || hasTorchIndex(pkg)
 		if len(findLinksList) > 0 {
 			for _, fl := range findLinksList {
 				findLinksSet[fl] = true
@@ -377,6 +539,13 @@ func (c *Config) PythonRequirementsForArch(goos string, goarch string, includePa
 	// If we still have some include packages add them in
 	packages = append(packages, includePackages...)
 
+	// Install torch packages that aren't pinned to a version Cog knows about from the index for the CUDA version
+	if hasTorchPackage && !torchIndexResolved {
+		if indexURL := c.TorchIndexURL(goos, goarch); indexURL != "" {
+			extraIndexURLSet[indexURL] = true
+		}
+	}
+
 	// Create final requirements.txt output
 	// Put index URLs first
 	lines := []string{}
@@ -536,13 +705,8 @@ Compatible cuDNN version is: %s`, c.Build.CuDNN, tfVersion, tfCuDNN)
 			}
 			c.Build.CUDA = latestCUDAFrom(torchCUDAs)
 			console.Debugf("Setting CUDA to version %s from Torch version", c.Build.CUDA)
-		case len(slices.FilterString(torchCUDAs, func(torchCUDA string) bool { return version.EqualMinor(torchCUDA, c.Build.CUDA) })) == 0:
-			// TODO: can we suggest a CUDA version known to be compatible?
-			console.Warnf("Cog doesn't know if CUDA %s is compatible with PyTorch %s. This might cause CUDA problems.", c.Build.CUDA, torchVersion)
-			if len(torchCUDAs) > 0 {
-				console.Warnf("Try %s instead?", torchCUDAs[len(torchCUDAs)-1])
-			}
 		}
+		// If torch isn't built for the CUDA that's set, lint suggests one it's built for
 
 		if c.Build.CuDNN == "" {
 			c.Build.CuDNN, err = latestCuDNNForCUDA(c.Build.CUDA)
@@ -576,3 +740,44 @@ func sliceContains(slice []string, s string) bool {
 	}
 	return false
 }
+
+// MaxContextSizeBytes parses build.max_context_size, e.g. "500MB" or "2GB". It returns 0 if no limit is set.
+func (b *Build) MaxContextSizeBytes() (int64, error) {
+	if b.MaxContextSize == "" {
+		return 0, nil
+	}
+	size, err := units.RAMInBytes(b.MaxContextSize)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("'build.max_context_size' in cog.yaml must be a size such as 500MB or 2GB, got %q", b.MaxContextSize)
+	}
+	return size, nil
+}
+
+// TmpDirPath returns the absolute path build.tmp_dir sets for the project in projectDir, with environment variables
+// like $SCRATCH expanded, or "" if it isn't set
+func (b *Build) TmpDirPath(projectDir string) (string, error) {
+	if b.TmpDir == "" {
+		return "", nil
+	}
+	tmpDir := os.ExpandEnv(b.TmpDir)
+	if tmpDir == "~" || strings.HasPrefix(tmpDir, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("Failed to expand ~ in 'build.tmp_dir' in cog.yaml: %w", err)
+		}
+		tmpDir = filepath.Join(home, strings.TrimPrefix(tmpDir, "~"))
+	}
+	if tmpDir == "" {
+		return "", fmt.Errorf("'build.tmp_dir' in cog.yaml is empty after expanding environment variables, got %q", b.TmpDir)
+	}
+	if !filepath.IsAbs(tmpDir) {
+		tmpDir = filepath.Join(projectDir, tmpDir)
+	}
+	return filepath.Abs(tmpDir)
+}
+
+// PythonRequirementsContent returns the Python packages to install, from python_requirements or python_packages.
+// It is only populated after ValidateAndComplete.
+func (b *Build) PythonRequirementsContent() []string {
+	return b.pythonRequirementsContent
+}
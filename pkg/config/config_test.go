@@ -165,6 +165,20 @@ func TestPythonPackagesAndRequirementsCantBeUsedTogether(t *testing.T) {
 	require.Contains(t, err.Error(), "Only one of python_packages or python_requirements can be set in your cog.yaml, not both")
 }
 
+func TestGPUCountNeedsGPU(t *testing.T) {
+	config := &Config{
+		Build: &Build{
+			PythonVersion: "3.12",
+			GPUCount:      2,
+		},
+	}
+	err := config.ValidateAndComplete("")
+	require.ErrorContains(t, err, "'build.gpu_count' in cog.yaml needs 'build.gpu: true'")
+
+	config.Build.GPU = true
+	require.NoError(t, config.ValidateAndComplete(""))
+}
+
 func TestPythonRequirementsResolvesPythonPackagesAndCudaVersions(t *testing.T) {
 	tmpDir := t.TempDir()
 	err := os.WriteFile(path.Join(tmpDir, "requirements.txt"), []byte(`torch==1.7.1
@@ -225,6 +239,43 @@ foo==1.0.0`
 	require.Equal(t, expected, requirements)
 }
 
+func TestPythonRequirementsAddsTorchIndexForUnpinnedTorch(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		build    *Build
+		expected string
+	}{
+		{
+			name:     "gpu",
+			build:    &Build{GPU: true, CUDA: "12.2", PythonVersion: "3.11", PythonPackages: []string{"torch>=2.4", "torchaudio", "foo==1.0.0"}},
+			expected: "--extra-index-url https://download.pytorch.org/whl/cu121\ntorch>=2.4\ntorchaudio\nfoo==1.0.0",
+		},
+		{
+			name:     "cpu",
+			build:    &Build{PythonVersion: "3.11", PythonPackages: []string{"torch"}},
+			expected: "--extra-index-url https://download.pytorch.org/whl/cpu\ntorch",
+		},
+		{
+			name:     "index already in requirements",
+			build:    &Build{PythonVersion: "3.11", PythonPackages: []string{"--extra-index-url https://download.pytorch.org/whl/cu118", "torch"}},
+			expected: "--extra-index-url https://download.pytorch.org/whl/cu118\ntorch",
+		},
+		{
+			name:     "no torch",
+			build:    &Build{PythonVersion: "3.11", PythonPackages: []string{"foo==1.0.0"}},
+			expected: "foo==1.0.0",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{Build: tt.build}
+			require.NoError(t, config.ValidateAndComplete(""))
+			requirements, err := config.PythonRequirementsForArch("linux", "amd64", []string{})
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, requirements)
+		})
+	}
+}
+
 func TestPythonRequirementsWorksWithLinesCogCannotParse(t *testing.T) {
 	tmpDir := t.TempDir()
 	err := os.WriteFile(path.Join(tmpDir, "requirements.txt"), []byte(`foo==1.0.0
@@ -263,7 +314,7 @@ func TestValidateAndCompleteCUDAForAllTF(t *testing.T) {
 		config := &Config{
 			Build: &Build{
 				GPU:           true,
-				PythonVersion: "3.8",
+				PythonVersion: compat.Pythons[0],
 				PythonPackages: []string{
 					"tensorflow==" + compat.TF,
 				},
@@ -282,7 +333,7 @@ func TestValidateAndCompleteCUDAForAllTorch(t *testing.T) {
 		config := &Config{
 			Build: &Build{
 				GPU:           compat.CUDA != nil,
-				PythonVersion: "3.8",
+				PythonVersion: compat.Pythons[0],
 				PythonPackages: []string{
 					"torch==" + compat.TorchVersion(),
 				},
@@ -635,6 +686,27 @@ func TestBuildRunItemDictJSON(t *testing.T) {
 	require.Equal(t, "/mnt/data", buildWrapper.Build.Run[0].Mounts[0].Target)
 }
 
+func TestBuildRunItemNetwork(t *testing.T) {
+	config, err := FromYAML([]byte(`
+build:
+  run:
+    - command: make test
+      network: none
+    - curl -LO https://example.com/data.tar.gz
+`))
+	require.NoError(t, err)
+	require.Equal(t, NetworkNone, config.Build.Run[0].Network)
+	require.Equal(t, "", config.Build.Run[1].Network)
+
+	_, err = FromYAML([]byte(`
+build:
+  run:
+    - command: make test
+      network: host
+`))
+	require.Error(t, err)
+}
+
 func TestTorchWithExistingExtraIndexURL(t *testing.T) {
 	config := &Config{
 		Build: &Build{
@@ -708,3 +780,115 @@ build:
 	_, err := FromYAML([]byte(yamlString))
 	require.NoError(t, err)
 }
+
+func TestPredictorLanguage(t *testing.T) {
+	for _, tc := range []struct {
+		predict  string
+		language string
+	}{
+		{"", LanguagePython},
+		{"predict.py:Predictor", LanguagePython},
+		{"src/predict.ts:Predictor", LanguageNode},
+		{"predict.mjs:Predictor", LanguageNode},
+	} {
+		cfg := &Config{Predict: tc.predict}
+		require.Equal(t, tc.language, cfg.PredictorLanguage(), tc.predict)
+	}
+}
+
+func TestValidatePredictRef(t *testing.T) {
+	require.NoError(t, validatePredictRef("predict.py:Predictor"))
+	require.NoError(t, validatePredictRef("predict.ts:Predictor"))
+	require.Error(t, validatePredictRef("predict.py"))
+	require.Error(t, validatePredictRef("predict.rb:Predictor"))
+}
+
+func TestMaxContextSizeBytes(t *testing.T) {
+	size, err := (&Build{}).MaxContextSizeBytes()
+	require.NoError(t, err)
+	require.Equal(t, int64(0), size)
+
+	size, err = (&Build{MaxContextSize: "2GB"}).MaxContextSizeBytes()
+	require.NoError(t, err)
+	require.Equal(t, int64(2*1024*1024*1024), size)
+
+	size, err = (&Build{MaxContextSize: "500mb"}).MaxContextSizeBytes()
+	require.NoError(t, err)
+	require.Equal(t, int64(500*1024*1024), size)
+
+	_, err = (&Build{MaxContextSize: "lots"}).MaxContextSizeBytes()
+	require.ErrorContains(t, err, "max_context_size")
+}
+
+func TestTmpDirPath(t *testing.T) {
+	tmpDir, err := (&Build{}).TmpDirPath("/project")
+	require.NoError(t, err)
+	require.Equal(t, "", tmpDir)
+
+	tmpDir, err = (&Build{TmpDir: "../scratch"}).TmpDirPath("/home/user/project")
+	require.NoError(t, err)
+	require.Equal(t, "/home/user/scratch", tmpDir)
+
+	t.Setenv("SCRATCH", "/scratch")
+	tmpDir, err = (&Build{TmpDir: "$SCRATCH/cog"}).TmpDirPath("/project")
+	require.NoError(t, err)
+	require.Equal(t, "/scratch/cog", tmpDir)
+
+	_, err = (&Build{TmpDir: "$UNSET_SCRATCH"}).TmpDirPath("/project")
+	require.ErrorContains(t, err, "build.tmp_dir")
+}
+
+func TestFromYAMLWithWindowsLineEndings(t *testing.T) {
+	config, err := FromYAML([]byte("\xef\xbb\xbfbuild:\r\n  python_version: \"3.12\"\r\n  run:\r\n    - |\r\n      echo a\r\n      echo b\r\npredict: \"predict.py:Predictor\"\r\n"))
+	require.NoError(t, err)
+	require.Equal(t, "3.12", config.Build.PythonVersion)
+	require.Equal(t, "echo a\necho b\n", config.Build.Run[0].Command)
+	require.Equal(t, "predict.py:Predictor", config.Predict)
+}
+
+func TestOutputYAML(t *testing.T) {
+	config, err := FromYAML([]byte(`
+build:
+  python_version: "3.12"
+output:
+  convert: webp
+  max_size: 1024
+`))
+	require.NoError(t, err)
+	require.Equal(t, &Output{Convert: "webp", MaxSize: 1024}, config.Output)
+	require.NoError(t, ValidateConfig(config, ""))
+
+	config.Output.Convert = "bmp"
+	require.Error(t, ValidateConfig(config, ""))
+}
+
+func TestTestsYAML(t *testing.T) {
+	config, err := FromYAML([]byte(`
+build:
+  python_version: "3.12"
+tests:
+  - name: cat
+    input:
+      prompt: a cat
+      steps: 20
+      images: ["@a.png", "@b.png"]
+    compare:
+      - path: output.seconds
+        type: ignore
+      - path: output.scores
+        type: numeric
+        tolerance: 0.01
+`))
+	require.NoError(t, err)
+	require.NoError(t, ValidateConfig(config, ""))
+	require.Len(t, config.Tests, 1)
+	require.Equal(t, "cat", config.Tests[0].Name)
+	require.Equal(t, []string{"images=@a.png", "images=@b.png", "prompt=a cat", "steps=20"}, config.Tests[0].InputFlags())
+	require.Equal(t, []Comparator{
+		{Path: "output.seconds", Type: "ignore"},
+		{Path: "output.scores", Type: "numeric", Tolerance: 0.01},
+	}, config.Tests[0].Compare)
+
+	config.Tests[0].Compare[0].Type = "fuzzy"
+	require.Error(t, ValidateConfig(config, ""))
+}
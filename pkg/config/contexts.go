@@ -0,0 +1,110 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// reservedBuildContexts are build contexts and stages used internally by Cog's generators
+var reservedBuildContexts = map[string]bool{
+	"apt":             true,
+	"cuda-extensions": true,
+	"deps":            true,
+	"model":           true,
+	"monobase":        true,
+	"requirements":    true,
+	"src":             true,
+	"weights":         true,
+	"wheels":          true,
+}
+
+var buildContextNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+
+// BuildContext is a directory outside the project directory that is made available to the build,
+// e.g. an asset directory shared between models in a monorepo.
+// In cog.yaml it can be given either as a path, or as a map with the options below.
+type BuildContext struct {
+	Path string `json:"path" yaml:"path"`
+	// Target is where the directory is copied to in the image. Defaults to /src/<name>.
+	Target string `json:"target,omitempty" yaml:"target"`
+}
+
+type buildContextAux BuildContext
+
+func (b *BuildContext) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var p string
+	if err := unmarshal(&p); err == nil {
+		*b = BuildContext{Path: p}
+		return nil
+	}
+	var aux buildContextAux
+	if err := unmarshal(&aux); err != nil {
+		return err
+	}
+	*b = BuildContext(aux)
+	return nil
+}
+
+func (b *BuildContext) UnmarshalJSON(data []byte) error {
+	var p string
+	if err := json.Unmarshal(data, &p); err == nil {
+		*b = BuildContext{Path: p}
+		return nil
+	}
+	var aux buildContextAux
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	*b = BuildContext(aux)
+	return nil
+}
+
+// TargetPath returns where the named build context is copied to in the image.
+func (b *BuildContext) TargetPath(name string) string {
+	if b.Target != "" {
+		return b.Target
+	}
+	return path.Join("/src", name)
+}
+
+// AbsPath resolves the build context's path relative to the project directory.
+func (b *BuildContext) AbsPath(projectDir string) (string, error) {
+	p := b.Path
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(projectDir, p)
+	}
+	return filepath.Abs(p)
+}
+
+// BuildContextNames returns the names of build.contexts in a stable order.
+func (b *Build) BuildContextNames() []string {
+	names := []string{}
+	for name := range b.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (b *Build) validateContexts() error {
+	for _, name := range b.BuildContextNames() {
+		context := b.Contexts[name]
+		if !buildContextNamePattern.MatchString(name) {
+			return fmt.Errorf("Invalid build context name %q in cog.yaml: names must be lowercase letters, numbers, '.', '_' or '-'", name)
+		}
+		if reservedBuildContexts[name] {
+			return fmt.Errorf("The build context name %q in cog.yaml is reserved by Cog", name)
+		}
+		if context.Path == "" {
+			return fmt.Errorf("The build context %q in cog.yaml must have a path", name)
+		}
+		if context.Target != "" && !path.IsAbs(context.Target) {
+			return fmt.Errorf("The target of build context %q in cog.yaml must be an absolute path", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildContexts(t *testing.T) {
+	cfg, err := FromYAML([]byte(`
+build:
+  python_version: "3.12"
+  contexts:
+    assets: ../shared-assets
+    fonts:
+      path: /opt/fonts
+      target: /usr/share/fonts/custom
+`))
+	require.NoError(t, err)
+	require.NoError(t, cfg.Build.validateContexts())
+	require.Equal(t, []string{"assets", "fonts"}, cfg.Build.BuildContextNames())
+
+	assets := cfg.Build.Contexts["assets"]
+	require.Equal(t, BuildContext{Path: "../shared-assets"}, assets)
+	require.Equal(t, "/src/assets", assets.TargetPath("assets"))
+	path, err := assets.AbsPath("/repo/models/sdxl")
+	require.NoError(t, err)
+	require.Equal(t, "/repo/models/shared-assets", path)
+
+	fonts := cfg.Build.Contexts["fonts"]
+	require.Equal(t, "/usr/share/fonts/custom", fonts.TargetPath("fonts"))
+}
+
+func TestBuildContextsInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		contexts map[string]BuildContext
+		err      string
+	}{
+		{map[string]BuildContext{"Assets": {Path: "../assets"}}, "Invalid build context name"},
+		{map[string]BuildContext{"src": {Path: "../src"}}, "reserved"},
+		{map[string]BuildContext{"deps": {Path: "../deps"}}, "reserved"},
+		{map[string]BuildContext{"cuda-extensions": {Path: "../ext"}}, "reserved"},
+		{map[string]BuildContext{"assets": {}}, "must have a path"},
+		{map[string]BuildContext{"assets": {Path: "../assets", Target: "assets"}}, "absolute path"},
+	} {
+		build := &Build{Contexts: tc.contexts}
+		require.ErrorContains(t, build.validateContexts(), tc.err)
+	}
+}
@@ -0,0 +1,14 @@
+package config
+
+// CPUVariant returns a copy of the config for a CPU-only build of a GPU model. The base image doesn't have CUDA,
+// and torch and torchvision are installed from their CPU wheels.
+func (c *Config) CPUVariant() *Config {
+	build := *c.Build
+	build.GPU = false
+	build.GPUCount = 0
+	build.CUDA = ""
+	build.CuDNN = ""
+	cpu := *c
+	cpu.Build = &build
+	return &cpu
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCPUVariant(t *testing.T) {
+	tmpDir := t.TempDir()
+	require.NoError(t, os.WriteFile(path.Join(tmpDir, "requirements.txt"), []byte("torch==2.3.1\n"), 0o644))
+	config := &Config{
+		Build: &Build{
+			GPU:                true,
+			GPUCount:           2,
+			PythonVersion:      "3.11",
+			PythonRequirements: "requirements.txt",
+		},
+	}
+	require.NoError(t, config.ValidateAndComplete(tmpDir))
+	require.NotEmpty(t, config.Build.CUDA)
+
+	cpu := config.CPUVariant()
+	require.False(t, cpu.Build.GPU)
+	require.Zero(t, cpu.Build.GPUCount)
+	require.Empty(t, cpu.Build.CUDA)
+	require.Empty(t, cpu.Build.CuDNN)
+
+	// The original config is unchanged
+	require.True(t, config.Build.GPU)
+	require.NotEmpty(t, config.Build.CUDA)
+
+	requirements, err := cpu.PythonRequirementsForArch("linux", "amd64", []string{})
+	require.NoError(t, err)
+	require.Contains(t, requirements, "torch==2.3.1")
+	require.Contains(t, requirements, "https://download.pytorch.org/whl/cpu")
+}
+
+func TestCPUImageName(t *testing.T) {
+	require.Equal(t, "r8.im/user/model:v1-cpu", CPUImageName("r8.im/user/model:v1"))
+	require.Equal(t, "cog-model-cpu", CPUImageName("cog-model"))
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/replicate/cog/pkg/dockercontext"
+	"github.com/replicate/cog/pkg/global"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// EnvironmentEnvVar selects a named environment, like --environment
+const EnvironmentEnvVar = "COG_ENV"
+
+// DefaultEnvironment is the name of the environment used when none is selected, which keeps its caches directly in .cog
+const DefaultEnvironment = "default"
+
+// environmentFile records the environment selected with `cog env use`
+var environmentFile = filepath.Join(dockercontext.CogBuildArtifactsFolder, "env")
+
+// environmentsFolder has a folder for the caches of each named environment
+var environmentsFolder = filepath.Join(dockercontext.CogBuildArtifactsFolder, "envs")
+
+// Environment names end up in image names, so they're limited to what's valid in one
+var environmentNamePattern = regexp.MustCompile(`^[a-z0-9]+([._-][a-z0-9]+)*$`)
+
+// ValidateEnvironmentName returns an error if name can't be the name of an environment
+func ValidateEnvironmentName(name string) error {
+	if len(name) > 64 || !environmentNamePattern.MatchString(name) {
+		return fmt.Errorf("Invalid environment name %q. It must be lowercase letters, digits, and '.', '_' or '-' between them, like cuda12", name)
+	}
+	return nil
+}
+
+// Environment returns the named environment selected for the project in projectDir, or "" for the default one. It's
+// --environment, or COG_ENV, or the one selected with `cog env use`.
+func Environment(projectDir string) string {
+	name := global.Environment
+	if name == "" {
+		name = os.Getenv(EnvironmentEnvVar)
+	}
+	if name == "" {
+		name = UsedEnvironment(projectDir)
+	}
+	if name == DefaultEnvironment {
+		return ""
+	}
+	return name
+}
+
+// UsedEnvironment returns the environment selected for the project in projectDir with `cog env use`, or "" if it
+// hasn't been
+func UsedEnvironment(projectDir string) string {
+	data, err := os.ReadFile(filepath.Join(projectDir, environmentFile))
+	if err != nil {
+		return ""
+	}
+	name := strings.TrimSpace(string(data))
+	if err := ValidateEnvironmentName(name); err != nil {
+		console.Warnf("Ignoring the environment in %s: %s", environmentFile, err)
+		return ""
+	}
+	return name
+}
+
+// UseEnvironment selects the named environment for the project in projectDir, so it's used when --environment and
+// COG_ENV aren't set. DefaultEnvironment selects the default one.
+func UseEnvironment(projectDir string, name string) error {
+	if err := ValidateEnvironmentName(name); err != nil {
+		return err
+	}
+	path := filepath.Join(projectDir, environmentFile)
+	if name == DefaultEnvironment {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Join(projectDir, environmentsFolder, name), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(name+"\n"), 0o644)
+}
+
+// Environments returns the named environments that have been used in the project in projectDir, sorted by name
+func Environments(projectDir string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(projectDir, environmentsFolder))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() && ValidateEnvironmentName(entry.Name()) == nil {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// EnvironmentDir returns where the caches of the named environment are kept in the project, relative to it. The
+// default environment keeps them directly in .cog.
+func EnvironmentDir(name string) string {
+	if name == "" || name == DefaultEnvironment {
+		return dockercontext.CogBuildArtifactsFolder
+	}
+	return filepath.Join(environmentsFolder, name)
+}
+
+// CacheDir returns where the caches of the environment selected for the project in projectDir are kept, relative to
+// it, so switching environments doesn't invalidate them
+func CacheDir(projectDir string) string {
+	return EnvironmentDir(Environment(projectDir))
+}
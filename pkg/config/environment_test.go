@@ -0,0 +1,55 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/global"
+)
+
+func TestValidateEnvironmentName(t *testing.T) {
+	require.NoError(t, ValidateEnvironmentName("cuda12"))
+	require.NoError(t, ValidateEnvironmentName("torch-2.1_exp"))
+	require.Error(t, ValidateEnvironmentName("CUDA12"))
+	require.Error(t, ValidateEnvironmentName("-cuda"))
+	require.Error(t, ValidateEnvironmentName("cuda/12"))
+	require.Error(t, ValidateEnvironmentName(""))
+}
+
+func TestEnvironment(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "hotdog-detector")
+	require.NoError(t, os.Mkdir(dir, 0o755))
+	t.Setenv(EnvironmentEnvVar, "")
+
+	require.Equal(t, "", Environment(dir))
+	require.Equal(t, ".cog", CacheDir(dir))
+	require.Equal(t, "cog-hotdog-detector", DockerImageName(dir))
+
+	require.NoError(t, UseEnvironment(dir, "cuda12"))
+	require.Equal(t, "cuda12", Environment(dir))
+	require.Equal(t, filepath.Join(".cog", "envs", "cuda12"), CacheDir(dir))
+	require.Equal(t, "cog-hotdog-detector-cuda12", DockerImageName(dir))
+	require.DirExists(t, filepath.Join(dir, ".cog", "envs", "cuda12"))
+
+	// COG_ENV and --environment take precedence over `cog env use`
+	t.Setenv(EnvironmentEnvVar, "cuda11")
+	require.Equal(t, "cuda11", Environment(dir))
+	global.Environment = "cpu"
+	t.Cleanup(func() { global.Environment = "" })
+	require.Equal(t, "cpu", Environment(dir))
+	global.Environment = DefaultEnvironment
+	require.Equal(t, "", Environment(dir))
+
+	environments, err := Environments(dir)
+	require.NoError(t, err)
+	require.Equal(t, []string{"cuda12"}, environments)
+
+	global.Environment = ""
+	t.Setenv(EnvironmentEnvVar, "")
+	require.NoError(t, UseEnvironment(dir, DefaultEnvironment))
+	require.Equal(t, "", Environment(dir))
+	require.Equal(t, "", UsedEnvironment(dir))
+}
@@ -1,13 +1,22 @@
 package config
 
 import (
+	"fmt"
 	"path"
 	"regexp"
 	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
 )
 
-// DockerImageName returns the default Docker image name for images
+// DockerImageName returns the default Docker image name for images, in the environment selected for the project
 func DockerImageName(projectDir string) string {
+	return DockerImageNameForEnvironment(projectDir, Environment(projectDir))
+}
+
+// DockerImageNameForEnvironment returns the default Docker image name for images in the named environment. Each has
+// its own, like cog-hotdog-detector-cuda12.
+func DockerImageNameForEnvironment(projectDir string, env string) string {
 	prefix := "cog-"
 	projectName := strings.ToLower(path.Base(projectDir))
 
@@ -28,6 +37,10 @@ func DockerImageName(projectDir string) string {
 		projectName = prefix + projectName
 	}
 
+	if env != "" && env != DefaultEnvironment {
+		projectName += "-" + env
+	}
+
 	return projectName
 }
 
@@ -35,3 +48,27 @@ func DockerImageName(projectDir string) string {
 func BaseDockerImageName(projectDir string) string {
 	return DockerImageName(projectDir) + "-base"
 }
+
+// MirroredBaseImageName returns where `cog push --include-base` pushes baseImage to, next to imageName in the
+// same registry. For example, r8.im/cog-base:cuda12.1-python3.11 is pushed to registry.example.com/ml/cog-base:cuda12.1-python3.11
+// for the model registry.example.com/ml/hotdog-detector.
+func MirroredBaseImageName(imageName string, baseImage string) (string, error) {
+	imageRef, err := name.ParseReference(imageName)
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse image name %s: %w", imageName, err)
+	}
+	baseRef, err := name.ParseReference(baseImage)
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse base image name %s: %w", baseImage, err)
+	}
+
+	// Tags can't contain colons, so base images pinned by digest are tagged with the digest, like sha256-abc123...
+	tag := strings.ReplaceAll(baseRef.Identifier(), ":", "-")
+	repository := path.Join(path.Dir(imageRef.Context().RepositoryStr()), path.Base(baseRef.Context().RepositoryStr()))
+	return imageRef.Context().RegistryStr() + "/" + repository + ":" + tag, nil
+}
+
+// CPUImageName returns the name of the CPU-only image built alongside a GPU image with `cog build --also-cpu`
+func CPUImageName(imageName string) string {
+	return imageName + "-cpu"
+}
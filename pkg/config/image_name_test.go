@@ -1,6 +1,7 @@
 package config
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -13,3 +14,23 @@ func TestDockerImageName(t *testing.T) {
 	require.Equal(t, "cog-my-great-model", DockerImageName("/home/joe/my great model"))
 	require.Equal(t, 30, len(DockerImageName("/home/joe/verylongverylongverylongverylongverylongverylongverylong")))
 }
+
+func TestMirroredBaseImageName(t *testing.T) {
+	for _, tt := range []struct {
+		imageName string
+		baseImage string
+		expected  string
+	}{
+		{"registry.example.com/ml/hotdog-detector", "r8.im/cog-base:cuda12.1-python3.11-torch2.3.1", "registry.example.com/ml/cog-base:cuda12.1-python3.11-torch2.3.1"},
+		{"registry.example.com:5000/team/models/hotdog:v2", "r8.im/cog-base:python3.12", "registry.example.com:5000/team/models/cog-base:python3.12"},
+		{"registry.example.com/hotdog", "r8.im/cog-base:python3.12", "registry.example.com/cog-base:python3.12"},
+		{"registry.example.com/ml/hotdog", "r8.im/cog-base@sha256:" + strings.Repeat("a", 64), "registry.example.com/ml/cog-base:sha256-" + strings.Repeat("a", 64)},
+	} {
+		actual, err := MirroredBaseImageName(tt.imageName, tt.baseImage)
+		require.NoError(t, err)
+		require.Equal(t, tt.expected, actual)
+	}
+
+	_, err := MirroredBaseImageName("Not An Image", "r8.im/cog-base:python3.12")
+	require.Error(t, err)
+}
@@ -0,0 +1,294 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/replicate/cog/pkg/global"
+)
+
+// includeKey is the key in cog.yaml listing partial YAML files to merge into it, like a list of python_packages
+// shared between models
+const includeKey = "include"
+
+var packageNameSeparatorPattern = regexp.MustCompile(`[-_.]+`)
+
+// notIncludableKeys can only be set in cog.yaml itself, because the model's server reads them from it
+var notIncludableKeys = []string{"predict", "train", "pipeline", "concurrency", "sidecars", "setup"}
+
+// resolveIncludes returns the cog.yaml at path, with contents, with the files it includes merged into it. If it doesn't
+// include anything, contents are returned as they are.
+//
+// Included files can include others, and paths are relative to the file that includes them. They're merged in order,
+// and then the including file is merged on top:
+//
+//   - maps are merged key by key
+//   - lists are concatenated, without repeating items already in the list. A package in python_packages replaces an
+//     included one with the same name, so the including file can pin another version.
+//   - anything else in the including file replaces what's included
+//   - a key set to null in the including file removes it
+//
+// Values are merged as they're written, so python_version: 3.10 stays 3.10, and comments are kept.
+func resolveIncludes(path string, contents []byte) ([]byte, error) {
+	doc, err := parseYAMLDoc(path, contents)
+	if err != nil {
+		return nil, err
+	}
+	i := nodeIndex(doc.Content[0], includeKey)
+	if i < 0 {
+		return contents, nil
+	}
+	// Keep comments above include, like the file's header, which would otherwise go with it
+	if comment := doc.Content[0].Content[i].HeadComment; comment != "" {
+		doc.HeadComment = strings.TrimSpace(doc.HeadComment + "\n" + comment)
+	}
+	merged, err := loadIncludes(path, doc.Content[0], nil)
+	if err != nil {
+		return nil, err
+	}
+	doc.Content[0] = merged
+	return marshalYAMLDoc(doc)
+}
+
+// IncludePaths returns the paths the cog.yaml at path, with contents, includes directly, as they're written
+func IncludePaths(path string, contents []byte) ([]string, error) {
+	doc, err := parseYAMLDoc(path, contents)
+	if err != nil {
+		return nil, err
+	}
+	value, _ := nodeGet(doc.Content[0], includeKey)
+	includes, err := includePaths(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s in %s: %w", includeKey, path, err)
+	}
+	return includes, nil
+}
+
+// loadIncludes merges what m, the map read from path, includes into it. stack is the files including it, to detect
+// cycles.
+func loadIncludes(path string, m *yaml.Node, stack []string) (*yaml.Node, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	for i, p := range stack {
+		if p == absPath {
+			cycle := append(append([]string{}, stack[i:]...), absPath)
+			for j := range cycle {
+				cycle[j] = filepath.Base(cycle[j])
+			}
+			return nil, fmt.Errorf("%s includes itself: %s", filepath.Base(absPath), strings.Join(cycle, " -> "))
+		}
+	}
+	stack = append(stack, absPath)
+
+	value, _ := nodeGet(m, includeKey)
+	includes, err := includePaths(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s in %s: %w", includeKey, path, err)
+	}
+
+	merged := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, include := range includes {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(path), include)
+		}
+		contents, err := os.ReadFile(include)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to include %s in %s: %w", include, path, err)
+		}
+		doc, err := parseYAMLDoc(include, contents)
+		if err != nil {
+			return nil, err
+		}
+		included := doc.Content[0]
+		for _, key := range notIncludableKeys {
+			if _, ok := nodeGet(included, key); ok {
+				return nil, fmt.Errorf("%s can't be set in %s, which is included in %s. Set it in %s instead.", key, include, path, global.ConfigFilename)
+			}
+		}
+		if included, err = loadIncludes(include, included, stack); err != nil {
+			return nil, err
+		}
+		merged = mergeNodes("", merged, included)
+	}
+	own := *m
+	own.Content = append([]*yaml.Node{}, m.Content...)
+	nodeDelete(&own, includeKey)
+	return mergeNodes("", merged, &own), nil
+}
+
+// includePaths returns the paths in the value of include, which is a path or a list of them
+func includePaths(value *yaml.Node) ([]string, error) {
+	switch {
+	case value == nil || isNullNode(value):
+		return nil, nil
+	case value.Kind == yaml.ScalarNode:
+		return []string{value.Value}, nil
+	case value.Kind == yaml.SequenceNode:
+		paths := make([]string, len(value.Content))
+		for i, item := range value.Content {
+			if item.Kind != yaml.ScalarNode || isNullNode(item) {
+				return nil, fmt.Errorf("it must be a path or a list of paths")
+			}
+			paths[i] = item.Value
+		}
+		return paths, nil
+	}
+	return nil, fmt.Errorf("it must be a path or a list of paths")
+}
+
+// mergeNodes merges override on top of base, which is nil if it isn't set. key is the key they're the value of.
+func mergeNodes(key string, base *yaml.Node, override *yaml.Node) *yaml.Node {
+	switch override.Kind {
+	case yaml.MappingNode:
+		merged := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		if base != nil && base.Kind == yaml.MappingNode {
+			*merged = *base
+			merged.Content = append([]*yaml.Node{}, base.Content...)
+		}
+		for i := 0; i+1 < len(override.Content); i += 2 {
+			k, v := override.Content[i], override.Content[i+1]
+			if isNullNode(v) {
+				nodeDelete(merged, k.Value)
+				continue
+			}
+			j := nodeIndex(merged, k.Value)
+			if j < 0 {
+				merged.Content = append(merged.Content, k, mergeNodes(k.Value, nil, v))
+				continue
+			}
+			// The including file's key, with its comments
+			merged.Content[j] = k
+			merged.Content[j+1] = mergeNodes(k.Value, merged.Content[j+1], v)
+		}
+		return merged
+	case yaml.SequenceNode:
+		if base == nil || base.Kind != yaml.SequenceNode {
+			return override
+		}
+		merged := *override
+		merged.Content = append([]*yaml.Node{}, base.Content...)
+		for _, item := range override.Content {
+			if i := sameItemIndex(key, merged.Content, item); i >= 0 {
+				merged.Content[i] = item
+			} else {
+				merged.Content = append(merged.Content, item)
+			}
+		}
+		return &merged
+	}
+	return override
+}
+
+// sameItemIndex returns the index of the item in items that item replaces, or -1. That's an equal item, or in
+// python_packages, a package with the same name.
+func sameItemIndex(key string, items []*yaml.Node, item *yaml.Node) int {
+	name := ""
+	if key == "python_packages" && item.Kind == yaml.ScalarNode {
+		name = packageKey(item.Value)
+	}
+	for i, existing := range items {
+		if nodesEqual(existing, item) {
+			return i
+		}
+		if name != "" && existing.Kind == yaml.ScalarNode && packageKey(existing.Value) == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// packageKey returns the name of a requirement normalized as in PEP 503, like torch for Torch==2.1.0, or "" if it
+// doesn't have one, like a URL
+func packageKey(requirement string) string {
+	requirement = strings.TrimSpace(requirement)
+	name := requirementName(requirement)
+	if rest := requirement[len(name):]; name == "" || (rest != "" && !strings.ContainsAny(rest[:1], " [=<>!~;@")) {
+		return ""
+	}
+	return strings.ToLower(packageNameSeparatorPattern.ReplaceAllString(name, "-"))
+}
+
+// nodesEqual returns whether a and b are the same value, however they're written
+func nodesEqual(a *yaml.Node, b *yaml.Node) bool {
+	if a.Kind != b.Kind || a.ShortTag() != b.ShortTag() || a.Value != b.Value || len(a.Content) != len(b.Content) {
+		return false
+	}
+	for i := range a.Content {
+		if !nodesEqual(a.Content[i], b.Content[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseYAMLDoc parses contents, read from path, as a YAML document whose content is a map. Unlike decoding it into Go
+// values, the nodes keep how each value is written and the comments, so they can be edited and written back.
+func parseYAMLDoc(path string, contents []byte) (*yaml.Node, error) {
+	// Like FromYAML, allow files written on Windows
+	contents = bytes.TrimPrefix(contents, []byte("\xef\xbb\xbf"))
+	contents = bytes.ReplaceAll(contents, []byte("\r\n"), []byte("\n"))
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(contents, doc); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %w", path, err)
+	}
+	if doc.Kind != yaml.DocumentNode {
+		doc = &yaml.Node{Kind: yaml.DocumentNode}
+	}
+	if len(doc.Content) == 0 || isNullNode(doc.Content[0]) {
+		doc.Content = []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("Failed to parse %s: it must be a map of keys to values", path)
+	}
+	return doc, nil
+}
+
+// marshalYAMLDoc writes doc, parsed with parseYAMLDoc, as YAML
+func marshalYAMLDoc(doc *yaml.Node) ([]byte, error) {
+	var b bytes.Buffer
+	encoder := yaml.NewEncoder(&b)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// nodeIndex returns the index of key in the map m, with its value at the next index, or -1
+func nodeIndex(m *yaml.Node, key string) int {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func nodeGet(m *yaml.Node, key string) (*yaml.Node, bool) {
+	i := nodeIndex(m, key)
+	if i < 0 {
+		return nil, false
+	}
+	return m.Content[i+1], true
+}
+
+func nodeDelete(m *yaml.Node, key string) {
+	if i := nodeIndex(m, key); i >= 0 {
+		m.Content = append(m.Content[:i:i], m.Content[i+2:]...)
+	}
+}
+
+func isNullNode(n *yaml.Node) bool {
+	return n.Kind == yaml.ScalarNode && n.ShortTag() == "!!null"
+}
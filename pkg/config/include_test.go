@@ -0,0 +1,129 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeYAMLFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(contents), 0o644))
+	}
+}
+
+func TestGetConfigWithIncludes(t *testing.T) {
+	dir := t.TempDir()
+	writeYAMLFiles(t, dir, map[string]string{
+		"shared/ml-platform.yaml": `
+include: cuda.yaml
+build:
+  python_version: "3.10"
+  python_packages:
+    - torch==2.1.0
+    - numpy==1.26.0
+  system_packages:
+    - ffmpeg
+`,
+		"shared/cuda.yaml": `
+build:
+  gpu: true
+  cuda: "12.1"
+`,
+		"cog.yaml": `
+include:
+  - shared/ml-platform.yaml
+build:
+  python_version: "3.11"
+  python_packages:
+    - numpy==1.26.0
+    - pillow==10.0.0
+  system_packages: null
+predict: "predict.py:Predictor"
+`,
+	})
+
+	cfg, _, err := GetConfig(dir)
+	require.NoError(t, err)
+	require.Equal(t, "predict.py:Predictor", cfg.Predict)
+	require.Equal(t, "3.11", cfg.Build.PythonVersion)
+	require.True(t, cfg.Build.GPU)
+	require.Equal(t, "12.1", cfg.Build.CUDA)
+	require.Equal(t, []string{"torch==2.1.0", "numpy==1.26.0", "pillow==10.0.0"}, cfg.Build.PythonPackages)
+	require.Empty(t, cfg.Build.SystemPackages)
+}
+
+func TestIncludeCycle(t *testing.T) {
+	dir := t.TempDir()
+	writeYAMLFiles(t, dir, map[string]string{
+		"cog.yaml": "include: a.yaml\npredict: predict.py:Predictor\n",
+		"a.yaml":   "include: b.yaml\n",
+		"b.yaml":   "include: a.yaml\n",
+	})
+	_, _, err := GetConfig(dir)
+	require.ErrorContains(t, err, "a.yaml includes itself: a.yaml -> b.yaml -> a.yaml")
+}
+
+func TestIncludeErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeYAMLFiles(t, dir, map[string]string{"cog.yaml": "include: missing.yaml\n"})
+	_, _, err := GetConfig(dir)
+	require.ErrorContains(t, err, "Failed to include")
+
+	writeYAMLFiles(t, dir, map[string]string{
+		"cog.yaml":    "include: shared.yaml\n",
+		"shared.yaml": "predict: predict.py:Predictor\n",
+	})
+	_, _, err = GetConfig(dir)
+	require.ErrorContains(t, err, "predict can't be set in")
+
+	writeYAMLFiles(t, dir, map[string]string{"cog.yaml": "include:\n  - nested: true\n"})
+	_, _, err = GetConfig(dir)
+	require.ErrorContains(t, err, "it must be a path or a list of paths")
+}
+
+func TestResolveIncludesWithoutIncludes(t *testing.T) {
+	contents := []byte("# A comment that would be lost if re-encoded\nbuild:\n  gpu: true\n")
+	resolved, err := resolveIncludes("cog.yaml", contents)
+	require.NoError(t, err)
+	require.Equal(t, contents, resolved)
+}
+
+func TestIncludeKeepsValuesAsWritten(t *testing.T) {
+	dir := t.TempDir()
+	writeYAMLFiles(t, dir, map[string]string{
+		"shared.yaml": "build:\n  python_packages:\n    - torch==2.0.0\n    - numpy==1.26.0\n",
+		"cog.yaml": `# The model's build
+include: shared.yaml
+build:
+  python_version: 3.10 # not 3.1
+  python_packages:
+    - Torch==2.1.0
+    - git+https://github.com/replicate/a.git
+    - git+https://github.com/replicate/b.git
+predict: predict.py:Predictor
+`,
+	})
+
+	cfg, _, err := GetConfig(dir)
+	require.NoError(t, err)
+	require.Equal(t, "3.10", cfg.Build.PythonVersion)
+	require.Equal(t, []string{
+		"Torch==2.1.0",
+		"numpy==1.26.0",
+		"git+https://github.com/replicate/a.git",
+		"git+https://github.com/replicate/b.git",
+	}, cfg.Build.PythonPackages)
+
+	contents, err := os.ReadFile(filepath.Join(dir, "cog.yaml"))
+	require.NoError(t, err)
+	resolved, err := resolveIncludes(filepath.Join(dir, "cog.yaml"), contents)
+	require.NoError(t, err)
+	require.Contains(t, string(resolved), "# The model's build")
+	require.Contains(t, string(resolved), "python_version: 3.10 # not 3.1")
+}
@@ -0,0 +1,269 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/replicate/cog/pkg/global"
+)
+
+// keyElement is an element of a key in cog.yaml, like build.run[0]: either a field or map key, or a list index
+type keyElement struct {
+	name    string
+	index   int
+	isIndex bool
+}
+
+var keyPartPattern = regexp.MustCompile(`^([^\[\]]+)((?:\[\d+\])*)$`)
+
+// parseKey parses a key in cog.yaml, which is a path of fields separated by dots, with list items like build.run[0]
+func parseKey(key string) ([]keyElement, error) {
+	elements := []keyElement{}
+	for _, part := range strings.Split(key, ".") {
+		match := keyPartPattern.FindStringSubmatch(part)
+		if match == nil {
+			return nil, fmt.Errorf("Invalid key %q. Keys are fields separated by dots, like build.python_version, with list items like build.run[0].", key)
+		}
+		elements = append(elements, keyElement{name: match[1]})
+		for _, index := range strings.Split(strings.Trim(match[2], "[]"), "][") {
+			if index == "" {
+				continue
+			}
+			i, _ := strconv.Atoi(index)
+			elements = append(elements, keyElement{index: i, isIndex: true})
+		}
+	}
+	return elements, nil
+}
+
+// ResolvedYAML returns the cog.yaml in projectDir as Cog sees it, with the files it includes merged into it, migrated
+// to CurrentVersion
+func ResolvedYAML(projectDir string) ([]byte, error) {
+	path := filepath.Join(projectDir, global.ConfigFilename)
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if contents, err = resolveIncludes(path, contents); err != nil {
+		return nil, err
+	}
+	return migrateOnLoad(path, contents)
+}
+
+// Lookup returns the value of key in the config, like build.python_version or build.run[0].command. Fields that aren't
+// set have their default value.
+func (c *Config) Lookup(key string) (interface{}, error) {
+	elements, err := parseKey(key)
+	if err != nil {
+		return nil, err
+	}
+	value, err := lookupKey(reflect.ValueOf(c), elements, key, false)
+	if err != nil {
+		return nil, err
+	}
+	return value.Interface(), nil
+}
+
+// SetKey returns the cog.yaml in projectDir with key set to value, which is parsed as the type of the field, so
+// `3.10` stays a string for build.python_version. Lists and maps are YAML, like [ffmpeg, git]. It returns an error if
+// the result isn't a valid cog.yaml, and doesn't write anything.
+//
+// Only cog.yaml itself is changed, not the files it includes. The rest of it, and its comments, are kept as they are.
+func SetKey(projectDir string, key string, value string) ([]byte, error) {
+	elements, err := parseKey(key)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(elements))
+	for i, element := range elements {
+		if element.isIndex {
+			return nil, fmt.Errorf("Can't set an item of a list. Set the whole list instead, like %s '[a, b]'.", strings.SplitN(key, "[", 2)[0])
+		}
+		names[i] = element.name
+	}
+	field, err := lookupKey(reflect.ValueOf(&Config{}), elements, key, true)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := parseValue(field.Type(), value)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid value for %s: %w", key, err)
+	}
+
+	path := filepath.Join(projectDir, global.ConfigFilename)
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parseYAMLDoc(path, contents)
+	if err != nil {
+		return nil, err
+	}
+	setKey(doc.Content[0], names, parsed)
+	if contents, err = marshalYAMLDoc(doc); err != nil {
+		return nil, err
+	}
+
+	resolved, err := resolveIncludes(path, contents)
+	if err != nil {
+		return nil, err
+	}
+	if resolved, err = migrateOnLoad(path, resolved); err != nil {
+		return nil, err
+	}
+	if _, err := FromYAML(resolved); err != nil {
+		return nil, fmt.Errorf("Can't set %s to %s: %w", key, value, err)
+	}
+	return contents, nil
+}
+
+// lookupKey returns the value at elements in v, which is a Config. key is the whole key, for errors. If forSet is set,
+// it only needs the field's type, so map keys don't need to exist.
+func lookupKey(v reflect.Value, elements []keyElement, key string, forSet bool) (reflect.Value, error) {
+	for i, element := range elements {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v = reflect.Zero(v.Type().Elem())
+			} else {
+				v = v.Elem()
+			}
+		}
+		sofar := elementsString(elements[:i+1])
+
+		if element.isIndex {
+			if v.Kind() != reflect.Slice {
+				return reflect.Value{}, fmt.Errorf("%s isn't a list", elementsString(elements[:i]))
+			}
+			if element.index >= v.Len() {
+				return reflect.Value{}, fmt.Errorf("%s isn't set: %s has %d items", sofar, elementsString(elements[:i]), v.Len())
+			}
+			v = v.Index(element.index)
+			continue
+		}
+
+		switch v.Kind() {
+		case reflect.Struct:
+			field, ok := structFieldByYAMLName(v, element.name)
+			if !ok {
+				return reflect.Value{}, fmt.Errorf("Unknown key %s in %s", sofar, global.ConfigFilename)
+			}
+			v = field
+		case reflect.Map:
+			value := v.MapIndex(reflect.ValueOf(element.name))
+			if !value.IsValid() {
+				if !forSet {
+					return reflect.Value{}, fmt.Errorf("%s isn't set", sofar)
+				}
+				value = reflect.Zero(v.Type().Elem())
+			}
+			v = value
+		default:
+			return reflect.Value{}, fmt.Errorf("Unknown key %s in %s: %s isn't a map", key, global.ConfigFilename, elementsString(elements[:i]))
+		}
+	}
+	return v, nil
+}
+
+func structFieldByYAMLName(v reflect.Value, name string) (reflect.Value, bool) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if strings.Split(field.Tag.Get("yaml"), ",")[0] == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func elementsString(elements []keyElement) string {
+	var b strings.Builder
+	for i, element := range elements {
+		switch {
+		case element.isIndex:
+			fmt.Fprintf(&b, "[%d]", element.index)
+		case i > 0:
+			b.WriteString("." + element.name)
+		default:
+			b.WriteString(element.name)
+		}
+	}
+	return b.String()
+}
+
+// parseValue parses value as a field of type t, as a YAML node
+func parseValue(t reflect.Type, value string) (*yaml.Node, error) {
+	node := &yaml.Node{}
+	switch t.Kind() {
+	case reflect.String:
+		node.SetString(value)
+		return node, nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("it must be true or false")
+		}
+		return node, node.Encode(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("it must be a whole number")
+		}
+		return node, node.Encode(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("it must be a number")
+		}
+		return node, node.Encode(f)
+	}
+
+	// Lists and maps are YAML, which must fit the field
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal([]byte(value), doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, fmt.Errorf("it must be set")
+	}
+	node = doc.Content[0]
+	if err := node.Decode(reflect.New(t).Interface()); err != nil {
+		return nil, err
+	}
+	// Written like the rest of cog.yaml, rather than [a, b]
+	setBlockStyle(node)
+	return node, nil
+}
+
+func setBlockStyle(node *yaml.Node) {
+	node.Style &^= yaml.FlowStyle
+	for _, child := range node.Content {
+		setBlockStyle(child)
+	}
+}
+
+// setKey sets the value at the path of names in the map m, adding maps on the way if they don't exist. Comments on
+// the value it replaces are kept.
+func setKey(m *yaml.Node, names []string, value *yaml.Node) {
+	if len(names) > 1 {
+		child, ok := nodeGet(m, names[0])
+		if !ok || child.Kind != yaml.MappingNode {
+			child = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			nodeSet(m, names[0], child)
+		}
+		setKey(child, names[1:], value)
+		return
+	}
+	if existing, ok := nodeGet(m, names[0]); ok {
+		value.HeadComment, value.LineComment, value.FootComment = existing.HeadComment, existing.LineComment, existing.FootComment
+	}
+	nodeSet(m, names[0], value)
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKey(t *testing.T) {
+	elements, err := parseKey("build.run[1].mounts[0].target")
+	require.NoError(t, err)
+	require.Equal(t, []keyElement{
+		{name: "build"},
+		{name: "run"},
+		{index: 1, isIndex: true},
+		{name: "mounts"},
+		{index: 0, isIndex: true},
+		{name: "target"},
+	}, elements)
+	require.Equal(t, "build.run[1].mounts[0].target", elementsString(elements))
+
+	for _, key := range []string{"", "build.", "build..gpu", "build.run[x]", "build.run[0"} {
+		_, err := parseKey(key)
+		require.Error(t, err, key)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	cfg, err := FromYAML([]byte(`build:
+  gpu: true
+  run:
+    - echo hello
+  contexts:
+    weights:
+      path: ../weights
+predict: predict.py:Predictor
+`))
+	require.NoError(t, err)
+
+	for key, expected := range map[string]interface{}{
+		"build.gpu":                   true,
+		"build.python_version":        "3.12",
+		"build.run[0].command":        "echo hello",
+		"build.contexts.weights.path": "../weights",
+		"build.system_packages":       []string(nil),
+		"predict":                     "predict.py:Predictor",
+		"concurrency.max":             0,
+	} {
+		value, err := cfg.Lookup(key)
+		require.NoError(t, err, key)
+		require.Equal(t, expected, value, key)
+	}
+
+	_, err = cfg.Lookup("build.nope")
+	require.ErrorContains(t, err, "Unknown key build.nope")
+	_, err = cfg.Lookup("build.run[3]")
+	require.ErrorContains(t, err, "build.run[3] isn't set")
+	_, err = cfg.Lookup("build.contexts.other")
+	require.ErrorContains(t, err, "build.contexts.other isn't set")
+	_, err = cfg.Lookup("build.gpu.count")
+	require.ErrorContains(t, err, "build.gpu isn't a map")
+}
+
+func TestSetKey(t *testing.T) {
+	dir := t.TempDir()
+	writeYAMLFiles(t, dir, map[string]string{"cog.yaml": "build:\n  gpu: true\npredict: predict.py:Predictor\n"})
+
+	contents, err := SetKey(dir, "build.python_version", "3.10")
+	require.NoError(t, err)
+	require.Equal(t, "build:\n  gpu: true\n  python_version: \"3.10\"\npredict: predict.py:Predictor\n", string(contents))
+
+	contents, err = SetKey(dir, "build.gpu", "false")
+	require.NoError(t, err)
+	require.Equal(t, "build:\n  gpu: false\npredict: predict.py:Predictor\n", string(contents))
+
+	contents, err = SetKey(dir, "concurrency.max", "4")
+	require.NoError(t, err)
+	require.Equal(t, "build:\n  gpu: true\npredict: predict.py:Predictor\nconcurrency:\n  max: 4\n", string(contents))
+
+	contents, err = SetKey(dir, "build.system_packages", "[ffmpeg, git]")
+	require.NoError(t, err)
+	require.Equal(t, "build:\n  gpu: true\n  system_packages:\n    - ffmpeg\n    - git\npredict: predict.py:Predictor\n", string(contents))
+}
+
+func TestSetKeyKeepsValuesAndComments(t *testing.T) {
+	dir := t.TempDir()
+	writeYAMLFiles(t, dir, map[string]string{"cog.yaml": `# Built for the demo
+build:
+  python_version: 3.10 # not 3.1
+  gpu: true
+predict: predict.py:Predictor # the default
+`})
+
+	contents, err := SetKey(dir, "build.gpu", "false")
+	require.NoError(t, err)
+	require.Equal(t, `# Built for the demo
+build:
+  python_version: 3.10 # not 3.1
+  gpu: false
+predict: predict.py:Predictor # the default
+`, string(contents))
+
+	contents, err = SetKey(dir, "build.python_version", "3.12")
+	require.NoError(t, err)
+	require.Contains(t, string(contents), `python_version: "3.12" # not 3.1`)
+}
+
+func TestSetKeyInvalid(t *testing.T) {
+	dir := t.TempDir()
+	writeYAMLFiles(t, dir, map[string]string{"cog.yaml": "build:\n  gpu: true\n"})
+
+	_, err := SetKey(dir, "build.gpu", "yes please")
+	require.ErrorContains(t, err, "Invalid value for build.gpu: it must be true or false")
+	_, err = SetKey(dir, "build.nope", "1")
+	require.ErrorContains(t, err, "Unknown key build.nope")
+	_, err = SetKey(dir, "build.run[0]", "echo hello")
+	require.ErrorContains(t, err, "Set the whole list instead, like build.run")
+	_, err = SetKey(dir, "build.system_packages", "{not: a list}")
+	require.ErrorContains(t, err, "Invalid value for build.system_packages")
+	// Schema validation catches what the types don't
+	_, err = SetKey(dir, "build.gpu_count", "0")
+	require.ErrorContains(t, err, "Can't set build.gpu_count to 0")
+}
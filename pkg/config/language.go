@@ -0,0 +1,77 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	LanguagePython = "python"
+	LanguageNode   = "node"
+	LanguageR      = "r"
+	// LanguageCustom is used when the model brings its own HTTP server with build.server_command
+	LanguageCustom = "custom"
+
+	DefaultNodeVersion = "20"
+	DefaultRVersion    = "4.4"
+)
+
+var predictorExtensions = map[string]string{
+	".py":  LanguagePython,
+	".ts":  LanguageNode,
+	".mts": LanguageNode,
+	".js":  LanguageNode,
+	".mjs": LanguageNode,
+	".R":   LanguageR,
+	".r":   LanguageR,
+}
+
+// PredictorLanguage returns the language runtime of the model, based on the file extension of `predict`,
+// or LanguageCustom if the model brings its own server.
+func (c *Config) PredictorLanguage() string {
+	if c.Build != nil && c.Build.ServerCommand != "" {
+		return LanguageCustom
+	}
+	if c.Predict == "" {
+		return LanguagePython
+	}
+	file, _, _ := strings.Cut(c.Predict, ":")
+	if language, ok := predictorExtensions[filepath.Ext(file)]; ok {
+		return language
+	}
+	return LanguagePython
+}
+
+// NodeVersionOrDefault returns the major version of Node.js to install for Node predictors.
+func (b *Build) NodeVersionOrDefault() string {
+	if b.NodeVersion != "" {
+		return b.NodeVersion
+	}
+	return DefaultNodeVersion
+}
+
+// RVersionOrDefault returns the version of R to install for R predictors.
+func (b *Build) RVersionOrDefault() string {
+	if b.RVersion != "" {
+		return b.RVersion
+	}
+	return DefaultRVersion
+}
+
+// RequiresSchemaFile returns true if the model's schema can't be generated by Cog, and has to be provided with build.openapi_schema
+func (c *Config) RequiresSchemaFile() bool {
+	language := c.PredictorLanguage()
+	return language == LanguageCustom || language == LanguageR
+}
+
+func validatePredictRef(ref string) error {
+	file, class, ok := strings.Cut(ref, ":")
+	if !ok || class == "" || strings.Contains(class, ":") {
+		return fmt.Errorf("'predict' in cog.yaml must be in the form 'predict.py:Predictor', 'predict.ts:Predictor' or 'predict.R:predict'")
+	}
+	if _, ok := predictorExtensions[filepath.Ext(file)]; !ok {
+		return fmt.Errorf("'predict' in cog.yaml must point to a Python (.py), TypeScript/JavaScript (.ts, .js) or R (.R) file")
+	}
+	return nil
+}
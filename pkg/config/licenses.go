@@ -0,0 +1,31 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Licenses is a policy for the licenses of the Python and system packages installed in the image. The build fails
+// if a package has a license that's denied, or, if Allow is set, one that isn't allowed.
+type Licenses struct {
+	// Allow are the licenses packages can have, like MIT or Apache-2.0. Everything is allowed if it's empty.
+	Allow []string `json:"allow,omitempty" yaml:"allow"`
+	// Deny are licenses packages can't have, like AGPL. A license family like GPL also denies GPL-2.0 and GPL-3.0.
+	Deny []string `json:"deny,omitempty" yaml:"deny"`
+	// Ignore are packages that aren't checked, like ones whose licenses have been reviewed
+	Ignore []string `json:"ignore,omitempty" yaml:"ignore"`
+}
+
+func (b *Build) validateLicenses() error {
+	if b.Licenses == nil {
+		return nil
+	}
+	for _, allowed := range b.Licenses.Allow {
+		for _, denied := range b.Licenses.Deny {
+			if strings.EqualFold(allowed, denied) {
+				return fmt.Errorf("%q is both allowed and denied in 'build.licenses' in cog.yaml", allowed)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateLicenses(t *testing.T) {
+	require.NoError(t, (&Build{}).validateLicenses())
+	require.NoError(t, (&Build{Licenses: &Licenses{Allow: []string{"MIT"}, Deny: []string{"AGPL"}}}).validateLicenses())
+	err := (&Build{Licenses: &Licenses{Allow: []string{"MIT", "GPL-3.0"}, Deny: []string{"gpl-3.0"}}}).validateLicenses()
+	require.ErrorContains(t, err, "both allowed and denied")
+}
@@ -3,11 +3,12 @@ package config
 import (
 	"fmt"
 	"os"
-	"path"
 	"path/filepath"
 
+	"github.com/replicate/cog/pkg/dockercontext"
 	"github.com/replicate/cog/pkg/errors"
 	"github.com/replicate/cog/pkg/global"
+	"github.com/replicate/cog/pkg/retry"
 	"github.com/replicate/cog/pkg/util/files"
 )
 
@@ -32,19 +33,27 @@ func GetConfig(customDir string) (*Config, string, error) {
 	// Find the root project directory
 	rootDir, err := GetProjectDir(customDir)
 	if err != nil {
-		return nil, "", err
+		return nil, "", errors.WithCategory(errors.CategoryConfig, err)
 	}
-	configPath := path.Join(rootDir, global.ConfigFilename)
+	configPath := filepath.Join(rootDir, global.ConfigFilename)
 
 	// Then try to load the config file from there
 	config, err := loadConfigFromFile(configPath)
 	if err != nil {
-		return nil, "", err
+		return nil, "", errors.WithCategory(errors.CategoryConfig, err)
 	}
 
 	err = config.ValidateAndComplete(rootDir)
+	if err == nil {
+		// Pulls, pushes and introspection are retried as cog.yaml says, wherever they happen
+		policy, _ := config.RetryPolicy()
+		retry.SetDefault(policy)
+		// and the files generated for builds go where it says, unless COG_TMPDIR is set
+		tmpDir, _ := config.Build.TmpDirPath(rootDir)
+		dockercontext.SetTmpRoot(tmpDir)
+	}
 
-	return config, rootDir, err
+	return config, rootDir, errors.WithCategory(errors.CategoryConfig, err)
 }
 
 // Given a file path, attempt to load a config from that file
@@ -62,6 +71,12 @@ func loadConfigFromFile(file string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	if contents, err = resolveIncludes(file, contents); err != nil {
+		return nil, err
+	}
+	if contents, err = migrateOnLoad(file, contents); err != nil {
+		return nil, err
+	}
 
 	config, err := FromYAML(contents)
 	if err != nil {
@@ -74,7 +89,7 @@ func loadConfigFromFile(file string) (*Config, error) {
 
 // Given a directory, find the cog config file in that directory
 func findConfigPathInDirectory(dir string) (configPath string, err error) {
-	filePath := path.Join(dir, global.ConfigFilename)
+	filePath := filepath.Join(dir, global.ConfigFilename)
 	exists, err := files.Exists(filePath)
 	if err != nil {
 		return "", fmt.Errorf("Failed to scan directory %s for %s: %s", dir, filePath, err)
@@ -95,7 +110,8 @@ func findProjectRootDir(startDir string) (string, error) {
 			return "", err
 		case err == nil:
 			return dir, nil
-		case dir == "." || dir == "/":
+		case dir == "." || filepath.Dir(dir) == dir:
+			// The root of the filesystem, which is / or a drive like C:\ on Windows
 			return "", errors.ConfigNotFound(fmt.Sprintf("%s not found in %s (or in any parent directories)", global.ConfigFilename, startDir))
 		}
 
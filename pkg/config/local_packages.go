@@ -0,0 +1,108 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// LocalPackage is a Python package in the project directory, like a library that's developed alongside the model.
+// Local packages are installed after the other Python packages, so changing them doesn't install those again.
+type LocalPackage struct {
+	// Path is the package's directory, relative to the project directory, with forward slashes
+	Path string
+	// Editable is whether it's installed with pip install -e
+	Editable bool
+}
+
+// localPackageFiles are the files that make a directory a Python package pip can install
+var localPackageFiles = []string{"pyproject.toml", "setup.py"}
+
+// LocalPythonPackages returns the local packages in build.local_packages and the Python requirements.
+// It is only populated after ValidateAndComplete.
+func (b *Build) LocalPythonPackages() []LocalPackage {
+	return b.localPackages
+}
+
+// parseLocalPackage returns the local package a requirement like "-e ./lib/mymodel" or "./lib/mymodel" installs, and
+// false if it installs something else, like a package from an index or a git repository
+func parseLocalPackage(requirement string) (LocalPackage, bool) {
+	requirement = strings.TrimSpace(requirement)
+	editable := false
+	for _, prefix := range []string{"-e ", "--editable ", "--editable="} {
+		if strings.HasPrefix(requirement, prefix) {
+			requirement = strings.TrimSpace(strings.TrimPrefix(requirement, prefix))
+			editable = true
+			break
+		}
+	}
+	requirement = strings.TrimPrefix(requirement, "file:")
+	if requirement == "" || strings.ContainsAny(requirement, " @;") || strings.Contains(requirement, "://") {
+		return LocalPackage{}, false
+	}
+	if requirement != "." && !strings.HasPrefix(requirement, "./") && !strings.HasPrefix(requirement, "../") && !strings.Contains(requirement, "/") {
+		return LocalPackage{}, false
+	}
+	return LocalPackage{Path: requirement, Editable: editable}, true
+}
+
+// completeLocalPackages moves local packages out of the Python requirements and adds the ones in
+// build.local_packages, checking they're Python packages in the project directory
+func (b *Build) completeLocalPackages(projectDir string) error {
+	requirements := []string{}
+	packages := []LocalPackage{}
+	for _, requirement := range b.pythonRequirementsContent {
+		if pkg, ok := parseLocalPackage(requirement); ok {
+			packages = append(packages, pkg)
+		} else {
+			requirements = append(requirements, requirement)
+		}
+	}
+	b.pythonRequirementsContent = requirements
+
+	for _, p := range b.LocalPackages {
+		pkg, ok := parseLocalPackage(p)
+		if !ok {
+			// A directory in the project directory, like "mymodel"
+			pkg = LocalPackage{Path: strings.TrimSpace(p)}
+		}
+		packages = append(packages, pkg)
+	}
+
+	seen := map[string]bool{}
+	b.localPackages = nil
+	for _, pkg := range packages {
+		if filepath.IsAbs(pkg.Path) {
+			return fmt.Errorf("Local Python package %s must be a path relative to the project directory", pkg.Path)
+		}
+		pkg.Path = path.Clean(filepath.ToSlash(pkg.Path))
+		if pkg.Path == ".." || strings.HasPrefix(pkg.Path, "../") {
+			return fmt.Errorf("Local Python package %s must be in the project directory, so it's sent to Docker when building", pkg.Path)
+		}
+		if seen[pkg.Path] {
+			return fmt.Errorf("Local Python package %s is listed more than once", pkg.Path)
+		}
+		seen[pkg.Path] = true
+		if err := checkLocalPackage(filepath.Join(projectDir, filepath.FromSlash(pkg.Path))); err != nil {
+			return fmt.Errorf("Local Python package %s %w", pkg.Path, err)
+		}
+		b.localPackages = append(b.localPackages, pkg)
+	}
+	return nil
+}
+
+// checkLocalPackage checks dir is a directory pip can install
+func checkLocalPackage(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return fmt.Errorf("isn't a directory in the project directory")
+	}
+	for _, file := range localPackageFiles {
+		if _, err := os.Stat(filepath.Join(dir, file)); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("doesn't have a %s, so pip can't install it", strings.Join(localPackageFiles, " or "))
+}
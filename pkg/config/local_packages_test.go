@@ -0,0 +1,58 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLocalPackage(t *testing.T) {
+	for _, tc := range []struct {
+		requirement string
+		expected    LocalPackage
+		ok          bool
+	}{
+		{"-e ./lib/mymodel", LocalPackage{Path: "./lib/mymodel", Editable: true}, true},
+		{"--editable=lib/mymodel", LocalPackage{Path: "lib/mymodel", Editable: true}, true},
+		{"./lib/mymodel", LocalPackage{Path: "./lib/mymodel"}, true},
+		{"file:./lib/mymodel", LocalPackage{Path: "./lib/mymodel"}, true},
+		{"-e .", LocalPackage{Path: ".", Editable: true}, true},
+		{"torch==2.1.0", LocalPackage{}, false},
+		{"-e git+https://github.com/replicate/cog.git#egg=cog", LocalPackage{}, false},
+		{"cog @ https://example.com/cog.whl", LocalPackage{}, false},
+		{"--extra-index-url https://download.pytorch.org/whl/cu121", LocalPackage{}, false},
+	} {
+		pkg, ok := parseLocalPackage(tc.requirement)
+		require.Equal(t, tc.ok, ok, tc.requirement)
+		require.Equal(t, tc.expected, pkg, tc.requirement)
+	}
+}
+
+func TestCompleteLocalPackages(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "lib", "mymodel"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "lib", "mymodel", "setup.py"), []byte(""), 0o644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "notapackage"), 0o755))
+
+	build := &Build{
+		LocalPackages:             []string{"notapackage"},
+		pythonRequirementsContent: []string{"numpy==1.26.4", "-e ./lib/mymodel"},
+	}
+	err := build.completeLocalPackages(dir)
+	require.ErrorContains(t, err, "Local Python package notapackage doesn't have a pyproject.toml or setup.py")
+
+	build = &Build{
+		LocalPackages:             []string{"../elsewhere"},
+		pythonRequirementsContent: []string{"numpy==1.26.4"},
+	}
+	require.ErrorContains(t, build.completeLocalPackages(dir), "must be in the project directory")
+
+	build = &Build{
+		pythonRequirementsContent: []string{"numpy==1.26.4", "-e ./lib/mymodel"},
+	}
+	require.NoError(t, build.completeLocalPackages(dir))
+	require.Equal(t, []string{"numpy==1.26.4"}, build.PythonRequirementsContent())
+	require.Equal(t, []LocalPackage{{Path: "lib/mymodel", Editable: true}}, build.LocalPythonPackages())
+}
@@ -0,0 +1,189 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/replicate/cog/pkg/global"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// CurrentVersion is the version of cog.yaml's format that this version of Cog writes. cog.yaml without a version is
+// version 1.
+const CurrentVersion = 2
+
+const versionKey = "version"
+
+// migration migrates cog.yaml from one version of its format to the next
+type migration struct {
+	// from is the version it migrates from, to from+1
+	from int
+	// migrate edits doc, the map in cog.yaml, and describes each change in result. If rewrite is set, cog.yaml is
+	// being rewritten by `cog config migrate` rather than migrated in memory when it's loaded, so it can also add files
+	// to the project.
+	migrate func(doc *yaml.Node, projectDir string, rewrite bool, result *MigrationResult) error
+}
+
+var migrations = []migration{
+	{from: 1, migrate: migrateV1ToV2},
+}
+
+// MigrationResult is what migrating cog.yaml changed
+type MigrationResult struct {
+	From int
+	To   int
+	// Changes describes each change
+	Changes []string
+	// Files are files to add to the project, by path relative to it
+	Files map[string][]byte
+	// Config is the migrated cog.yaml
+	Config []byte
+}
+
+// MigrateFile migrates the cog.yaml in projectDir to CurrentVersion, for `cog config migrate`. It doesn't write
+// anything, so the result can be shown first. Write it with WriteMigration.
+func MigrateFile(projectDir string) (*MigrationResult, error) {
+	path := filepath.Join(projectDir, global.ConfigFilename)
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parseYAMLDoc(path, contents)
+	if err != nil {
+		return nil, err
+	}
+	result := &MigrationResult{Files: map[string][]byte{}}
+	if err := migrateYAML(doc.Content[0], projectDir, true, result); err != nil {
+		return nil, err
+	}
+	if result.Config, err = marshalYAMLDoc(doc); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// WriteMigration writes migrated cog.yaml, and the files the migration added, to projectDir
+func WriteMigration(projectDir string, result *MigrationResult) error {
+	for name, contents := range result.Files {
+		if err := os.WriteFile(filepath.Join(projectDir, name), contents, 0o644); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(filepath.Join(projectDir, global.ConfigFilename), result.Config, 0o644)
+}
+
+// migrateOnLoad migrates cog.yaml, with contents, to CurrentVersion in memory, warning about each change. If nothing
+// needed changing, contents are returned as they are.
+func migrateOnLoad(path string, contents []byte) ([]byte, error) {
+	doc, err := parseYAMLDoc(path, contents)
+	if err != nil {
+		return nil, err
+	}
+	result := &MigrationResult{}
+	if err := migrateYAML(doc.Content[0], filepath.Dir(path), false, result); err != nil {
+		return nil, err
+	}
+	if len(result.Changes) == 0 {
+		return contents, nil
+	}
+	for _, change := range result.Changes {
+		console.Warnf("%s. Run 'cog config migrate' to update %s.", change, global.ConfigFilename)
+	}
+	return marshalYAMLDoc(doc)
+}
+
+// migrateYAML migrates doc, the map in cog.yaml, to CurrentVersion
+func migrateYAML(doc *yaml.Node, projectDir string, rewrite bool, result *MigrationResult) error {
+	version, err := configVersion(doc)
+	if err != nil {
+		return err
+	}
+	result.From, result.To = version, CurrentVersion
+	for _, m := range migrations {
+		if m.from < version {
+			continue
+		}
+		if err := m.migrate(doc, projectDir, rewrite, result); err != nil {
+			return err
+		}
+	}
+	nodeSet(doc, versionKey, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(CurrentVersion)})
+	return nil
+}
+
+// configVersion returns the version of cog.yaml's format of doc
+func configVersion(doc *yaml.Node) (int, error) {
+	value, ok := nodeGet(doc, versionKey)
+	if !ok || isNullNode(value) {
+		return 1, nil
+	}
+	var version int
+	if value.Kind != yaml.ScalarNode || value.ShortTag() != "!!int" || value.Decode(&version) != nil || version < 1 {
+		return 0, fmt.Errorf("'version' in %s must be a whole number, like %d", global.ConfigFilename, CurrentVersion)
+	}
+	if version > CurrentVersion {
+		return 0, fmt.Errorf("%s is version %d, but this version of Cog only understands up to version %d. Upgrade Cog to use it.", global.ConfigFilename, version, CurrentVersion)
+	}
+	return version, nil
+}
+
+// migrateV1ToV2 moves the deprecated build.pre_install to the end of build.run, where they're run anyway, and
+// build.python_packages to a requirements file
+func migrateV1ToV2(doc *yaml.Node, projectDir string, rewrite bool, result *MigrationResult) error {
+	build, ok := nodeGet(doc, "build")
+	if !ok || build.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	if preInstall, ok := nodeGet(build, "pre_install"); ok {
+		run, ok := nodeGet(build, "run")
+		if !ok || run.Kind != yaml.SequenceNode {
+			run = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		}
+		if preInstall.Kind == yaml.SequenceNode {
+			run.Content = append(run.Content, preInstall.Content...)
+		}
+		nodeDelete(build, "pre_install")
+		nodeSet(build, "run", run)
+		result.Changes = append(result.Changes, "'build.pre_install' is deprecated, so its commands were moved to the end of 'build.run'")
+	}
+
+	// In memory, python_packages still work as they are
+	if packages, ok := nodeGet(build, "python_packages"); ok && rewrite {
+		requirementsFile := "requirements.txt"
+		if _, err := os.Stat(filepath.Join(projectDir, requirementsFile)); err == nil {
+			return fmt.Errorf("Can't move 'build.python_packages' to %s, because it already exists. Move them by hand, and set 'build.python_requirements'.", requirementsFile)
+		}
+		var requirements bytes.Buffer
+		for _, item := range packages.Content {
+			requirements.WriteString(strings.TrimSpace(item.Value) + "\n")
+		}
+		result.Files[requirementsFile] = requirements.Bytes()
+		nodeDelete(build, "python_packages")
+		nodeSet(build, "python_requirements", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: requirementsFile})
+		result.Changes = append(result.Changes, fmt.Sprintf("'build.python_packages' is deprecated, so they were moved to %s, set as 'build.python_requirements'", requirementsFile))
+	}
+
+	return nil
+}
+
+// nodeSet sets key in the map m to value, keeping its position if it's already set, or otherwise adding it at the end.
+// version goes at the start, where it's easy to see.
+func nodeSet(m *yaml.Node, key string, value *yaml.Node) {
+	if i := nodeIndex(m, key); i >= 0 {
+		m.Content[i+1] = value
+		return
+	}
+	keyNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}
+	if key == versionKey {
+		m.Content = append([]*yaml.Node{keyNode, value}, m.Content...)
+		return
+	}
+	m.Content = append(m.Content, keyNode, value)
+}
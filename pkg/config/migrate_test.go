@@ -0,0 +1,120 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const v1Config = `build:
+  python_version: "3.11"
+  python_packages:
+    - torch==2.1.0
+    - pillow==10.0.0
+  run:
+    - echo first
+  pre_install:
+    - echo second
+predict: predict.py:Predictor
+`
+
+func TestMigrateOnLoad(t *testing.T) {
+	dir := t.TempDir()
+	writeYAMLFiles(t, dir, map[string]string{"cog.yaml": v1Config})
+
+	cfg, _, err := GetConfig(dir)
+	require.NoError(t, err)
+	require.Equal(t, CurrentVersion, cfg.Version)
+	require.Empty(t, cfg.Build.PreInstall)
+	require.Equal(t, []RunItem{{Command: "echo first"}, {Command: "echo second"}}, cfg.Build.Run)
+	// python_packages still work as they are in memory
+	require.Equal(t, []string{"torch==2.1.0", "pillow==10.0.0"}, cfg.Build.PythonPackages)
+}
+
+func TestMigrateOnLoadCurrentVersion(t *testing.T) {
+	contents := []byte("version: 2\n# A comment\nbuild:\n  gpu: true\n")
+	migrated, err := migrateOnLoad("cog.yaml", contents)
+	require.NoError(t, err)
+	require.Equal(t, contents, migrated)
+}
+
+func TestMigrateOnLoadKeepsValuesAsWritten(t *testing.T) {
+	// Nothing to migrate, so it's used as it is
+	contents := []byte("# A comment\nbuild:\n  python_version: 3.10\npredict: predict.py:Predictor\n")
+	migrated, err := migrateOnLoad("cog.yaml", contents)
+	require.NoError(t, err)
+	require.Equal(t, contents, migrated)
+
+	dir := t.TempDir()
+	writeYAMLFiles(t, dir, map[string]string{"cog.yaml": `build:
+  python_version: 3.10 # not 3.1
+  pre_install:
+    - echo first
+predict: predict.py:Predictor
+`})
+	migrated, err = ResolvedYAML(dir)
+	require.NoError(t, err)
+	require.Equal(t, `version: 2
+build:
+  python_version: 3.10 # not 3.1
+  run:
+    - echo first
+predict: predict.py:Predictor
+`, string(migrated))
+	cfg, _, err := GetConfig(dir)
+	require.NoError(t, err)
+	require.Equal(t, "3.10", cfg.Build.PythonVersion)
+}
+
+func TestMigrateFile(t *testing.T) {
+	dir := t.TempDir()
+	writeYAMLFiles(t, dir, map[string]string{"cog.yaml": v1Config})
+
+	result, err := MigrateFile(dir)
+	require.NoError(t, err)
+	require.Equal(t, 1, result.From)
+	require.Equal(t, CurrentVersion, result.To)
+	require.Len(t, result.Changes, 2)
+	require.Equal(t, "torch==2.1.0\npillow==10.0.0\n", string(result.Files["requirements.txt"]))
+	require.Equal(t, `version: 2
+build:
+  python_version: "3.11"
+  run:
+    - echo first
+    - echo second
+  python_requirements: requirements.txt
+predict: predict.py:Predictor
+`, string(result.Config))
+
+	require.NoError(t, WriteMigration(dir, result))
+	cfg, _, err := GetConfig(dir)
+	require.NoError(t, err)
+	require.Equal(t, "requirements.txt", cfg.Build.PythonRequirements)
+	require.Empty(t, cfg.Build.PythonPackages)
+
+	result, err = MigrateFile(dir)
+	require.NoError(t, err)
+	require.Equal(t, CurrentVersion, result.From)
+	require.Empty(t, result.Changes)
+}
+
+func TestMigrateFileWithExistingRequirements(t *testing.T) {
+	dir := t.TempDir()
+	writeYAMLFiles(t, dir, map[string]string{"cog.yaml": v1Config})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "requirements.txt"), []byte("numpy\n"), 0o644))
+	_, err := MigrateFile(dir)
+	require.ErrorContains(t, err, "requirements.txt, because it already exists")
+}
+
+func TestConfigVersion(t *testing.T) {
+	dir := t.TempDir()
+	writeYAMLFiles(t, dir, map[string]string{"cog.yaml": "version: 99\npredict: predict.py:Predictor\n"})
+	_, _, err := GetConfig(dir)
+	require.ErrorContains(t, err, "only understands up to version 2")
+
+	writeYAMLFiles(t, dir, map[string]string{"cog.yaml": "version: two\n"})
+	_, _, err = GetConfig(dir)
+	require.ErrorContains(t, err, "must be a whole number")
+}
@@ -0,0 +1,10 @@
+package config
+
+// Output is how `cog predict` transforms file outputs when it writes them, like converting them to another format
+// or shrinking them, so huge generated images and videos are quick to look at. The model's output isn't changed.
+type Output struct {
+	// Convert is the format to convert file outputs to, like webp or mp4
+	Convert string `json:"convert,omitempty" yaml:"convert"`
+	// MaxSize is the largest width or height of image and video outputs, in pixels
+	MaxSize int `json:"max_size,omitempty" yaml:"max_size"`
+}
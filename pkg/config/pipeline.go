@@ -0,0 +1,65 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// PipelineStep is one predictor in a pipeline. Its output is passed to the next step's input.
+type PipelineStep struct {
+	// Predict is the step's predictor, like predict.py:Predictor
+	Predict string `json:"predict" yaml:"predict"`
+	// Input is the name of the input the previous step's output is passed to. It defaults to the step's first input.
+	Input string `json:"input,omitempty" yaml:"input"`
+}
+
+// PipelinePredictorRef is the predictor Cog runs a pipeline with. It's generated into the image, and runs the
+// steps in `pipeline` in cog.yaml one after the other.
+const PipelinePredictorRef = "/opt/cog/pipeline/predictor.py:Predictor"
+
+func (c *Config) validatePipeline() error {
+	if len(c.Pipeline) == 0 {
+		return nil
+	}
+	if c.Predict != "" {
+		return fmt.Errorf("'predict' and 'pipeline' cannot both be set in cog.yaml: the pipeline is the predictor")
+	}
+	if c.ServingBackend != nil {
+		return fmt.Errorf("'serving_backend' and 'pipeline' cannot both be set in cog.yaml")
+	}
+	if c.Build != nil && c.Build.ServerCommand != "" {
+		return fmt.Errorf("'build.server_command' and 'pipeline' cannot both be set in cog.yaml")
+	}
+	if len(c.Pipeline) < 2 {
+		return fmt.Errorf("'pipeline' in cog.yaml needs at least two steps, use 'predict' for a single predictor")
+	}
+	for i, step := range c.Pipeline {
+		file, class, ok := strings.Cut(step.Predict, ":")
+		if !ok || class == "" || strings.Contains(class, ":") {
+			return fmt.Errorf("Step %d of 'pipeline' in cog.yaml must set 'predict' in the form 'predict.py:Predictor'", i+1)
+		}
+		if filepath.Ext(file) != ".py" {
+			return fmt.Errorf("Step %d of 'pipeline' in cog.yaml must be a Python predictor, got %s", i+1, step.Predict)
+		}
+		if i == 0 && step.Input != "" {
+			return fmt.Errorf("The first step of 'pipeline' in cog.yaml can't set 'input': its inputs are the pipeline's inputs")
+		}
+	}
+	return nil
+}
+
+// PredictorRefs returns the predictors the model runs, which are the pipeline's steps if it has one
+func (c *Config) PredictorRefs() []string {
+	if len(c.Pipeline) == 0 {
+		if c.Predict == "" {
+			return nil
+		}
+		return []string{c.Predict}
+	}
+	refs := make([]string, len(c.Pipeline))
+	for i, step := range c.Pipeline {
+		refs[i] = step.Predict
+	}
+	return refs
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipeline(t *testing.T) {
+	cfg, err := FromYAML([]byte(`
+pipeline:
+  - predict: preprocess.py:Preprocessor
+  - predict: predict.py:Predictor
+    input: image
+`))
+	require.NoError(t, err)
+	require.NoError(t, cfg.validatePipeline())
+	require.Equal(t, []PipelineStep{
+		{Predict: "preprocess.py:Preprocessor"},
+		{Predict: "predict.py:Predictor", Input: "image"},
+	}, cfg.Pipeline)
+	require.Equal(t, []string{"preprocess.py:Preprocessor", "predict.py:Predictor"}, cfg.PredictorRefs())
+}
+
+func TestPipelineInvalid(t *testing.T) {
+	for _, tt := range []struct {
+		yaml string
+		err  string
+	}{
+		{`
+predict: predict.py:Predictor
+pipeline:
+  - predict: a.py:A
+  - predict: b.py:B
+`, "cannot both be set"},
+		{`
+pipeline:
+  - predict: a.py:A
+`, "at least two steps"},
+		{`
+pipeline:
+  - predict: a.py:A
+  - predict: b.ts:B
+`, "must be a Python predictor"},
+		{`
+pipeline:
+  - predict: a.py
+  - predict: b.py:B
+`, "in the form"},
+		{`
+pipeline:
+  - predict: a.py:A
+    input: image
+  - predict: b.py:B
+`, "first step"},
+	} {
+		cfg, err := FromYAML([]byte(tt.yaml))
+		require.NoError(t, err)
+		require.ErrorContains(t, cfg.validatePipeline(), tt.err)
+	}
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var cudaArchListPattern = regexp.MustCompile(`^\d+\.\d(\+PTX)?([; ]\d+\.\d(\+PTX)?)*$`)
+
+// Precompile configures work that's done at build time so it doesn't have to be done when the model starts
+type Precompile struct {
+	// Python compiles Python files to bytecode, like `cog build --x-precompile`
+	Python bool `json:"python,omitempty" yaml:"python"`
+	// CUDAExtensions are Python packages with CUDA extensions, like flash-attn, that are compiled into wheels
+	// in a separate builder stage so they're only compiled again when they or their dependencies change
+	CUDAExtensions []string `json:"cuda_extensions,omitempty" yaml:"cuda_extensions"`
+	// Wheels builds the Python requirements into wheels in a separate builder stage, and keeps them in a BuildKit
+	// cache, so packages that compile C or CUDA extensions aren't compiled again on every build
+	Wheels bool `json:"wheels,omitempty" yaml:"wheels"`
+	// CUDAArchList is the TORCH_CUDA_ARCH_LIST the extensions are compiled for, e.g. "8.0;8.6;9.0"
+	CUDAArchList string `json:"cuda_arch_list,omitempty" yaml:"cuda_arch_list"`
+	// MaxJobs limits how many compiler processes run in parallel, because compiling CUDA kernels uses a lot of memory
+	MaxJobs int `json:"max_jobs,omitempty" yaml:"max_jobs"`
+}
+
+// PrecompilePython reports whether cog.yaml asks for Python files to be compiled to bytecode
+func (b *Build) PrecompilePython() bool {
+	return b.Precompile != nil && b.Precompile.Python
+}
+
+// PrecompileWheels reports whether cog.yaml asks for the Python requirements to be built into wheels in their own stage
+func (b *Build) PrecompileWheels() bool {
+	return b.Precompile != nil && b.Precompile.Wheels
+}
+
+// CUDAExtensions returns the packages in build.precompile.cuda_extensions
+func (b *Build) CUDAExtensions() []string {
+	if b.Precompile == nil {
+		return nil
+	}
+	return b.Precompile.CUDAExtensions
+}
+
+func (b *Build) validatePrecompile() error {
+	if b.Precompile == nil {
+		return nil
+	}
+	if len(b.Precompile.CUDAExtensions) > 0 && !b.GPU {
+		return fmt.Errorf("'build.precompile.cuda_extensions' in cog.yaml requires 'build.gpu' to be true")
+	}
+	for _, extension := range b.Precompile.CUDAExtensions {
+		name := requirementName(extension)
+		if name == "" {
+			return fmt.Errorf("Invalid package %q in 'build.precompile.cuda_extensions' in cog.yaml", extension)
+		}
+		for _, pkg := range b.pythonRequirementsContent {
+			if requirementName(pkg) == name {
+				return fmt.Errorf("%s is in both Python requirements and 'build.precompile.cuda_extensions' in cog.yaml. Remove it from the Python requirements", name)
+			}
+		}
+	}
+	if b.Precompile.Wheels && b.RequirementsHashPinned() {
+		return fmt.Errorf("'build.precompile.wheels' in cog.yaml can't be used with requirements pinned to hashes, because the wheels it builds don't have the same hashes")
+	}
+	if b.Precompile.CUDAArchList != "" && !cudaArchListPattern.MatchString(b.Precompile.CUDAArchList) {
+		return fmt.Errorf("'build.precompile.cuda_arch_list' in cog.yaml must be a list of compute capabilities such as \"8.0;8.6;9.0+PTX\", got %q", b.Precompile.CUDAArchList)
+	}
+	if b.Precompile.MaxJobs < 0 {
+		return fmt.Errorf("'build.precompile.max_jobs' in cog.yaml must be a positive number")
+	}
+	return nil
+}
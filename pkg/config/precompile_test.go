@@ -0,0 +1,33 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidatePrecompile(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		build *Build
+		err   string
+	}{
+		{"valid", &Build{GPU: true, Precompile: &Precompile{CUDAExtensions: []string{"flash-attn==2.6.3"}, CUDAArchList: "8.0;8.6 9.0+PTX", MaxJobs: 4}}, ""},
+		{"python only", &Build{Precompile: &Precompile{Python: true}}, ""},
+		{"requires gpu", &Build{Precompile: &Precompile{CUDAExtensions: []string{"flash-attn"}}}, "requires 'build.gpu'"},
+		{"invalid package", &Build{GPU: true, Precompile: &Precompile{CUDAExtensions: []string{"--no-binary"}}}, "Invalid package"},
+		{"also a requirement", &Build{GPU: true, Precompile: &Precompile{CUDAExtensions: []string{"flash-attn==2.6.3"}}, pythonRequirementsContent: []string{"flash-attn"}}, "in both Python requirements"},
+		{"wheels", &Build{Precompile: &Precompile{Wheels: true}, pythonRequirementsContent: []string{"pycocotools==2.0.8"}}, ""},
+		{"wheels with hashes", &Build{Precompile: &Precompile{Wheels: true}, pythonRequirementsContent: []string{"pycocotools==2.0.8 --hash=sha256:aaaa"}}, "pinned to hashes"},
+		{"invalid arch list", &Build{GPU: true, Precompile: &Precompile{CUDAArchList: "sm_80"}}, "compute capabilities"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.build.validatePrecompile()
+			if tt.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tt.err)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"regexp"
+	"strings"
 )
 
 // SplitPinnedPythonRequirement returns the name, version, findLinks, and extraIndexURLs from a requirements.txt line
@@ -53,3 +54,29 @@ func PackageName(pipRequirement string) (string, error) {
 	name, _, _, _, err := SplitPinnedPythonRequirement(pipRequirement)
 	return name, err
 }
+
+// RequirementsHashPinned returns whether the requirements are pinned to hashes with --hash, like the output of
+// `pip-compile --generate-hashes`, so they're installed with --require-hashes
+func (b *Build) RequirementsHashPinned() bool {
+	for _, requirement := range b.pythonRequirementsContent {
+		if strings.Contains(requirement, "--hash") {
+			return true
+		}
+	}
+	return false
+}
+
+// validateHashPinned checks every requirement has a hash if any of them do, because pip won't install any of them
+// otherwise
+func (b *Build) validateHashPinned() error {
+	if !b.RequirementsHashPinned() {
+		return nil
+	}
+	for _, requirement := range b.pythonRequirementsContent {
+		if strings.HasPrefix(requirement, "-") || strings.Contains(requirement, "--hash") {
+			continue
+		}
+		return fmt.Errorf("%s pins requirements to hashes, but not %s. When any requirement has a --hash, they all need one.", b.PythonRequirements, requirement)
+	}
+	return nil
+}
@@ -40,3 +40,20 @@ func TestSplitPinnedPythonRequirement(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateHashPinned(t *testing.T) {
+	build := &Build{PythonRequirements: "requirements.txt", pythonRequirementsContent: []string{
+		"--extra-index-url https://download.pytorch.org/whl/cu121",
+		"numpy==1.26.4 --hash=sha256:aaaa",
+		"torch==2.1.0 --hash=sha256:bbbb",
+	}}
+	require.True(t, build.RequirementsHashPinned())
+	require.NoError(t, build.validateHashPinned())
+
+	build.pythonRequirementsContent = append(build.pythonRequirementsContent, "pillow==10.0.0")
+	require.ErrorContains(t, build.validateHashPinned(), "requirements.txt pins requirements to hashes, but not pillow==10.0.0")
+
+	build.pythonRequirementsContent = []string{"pillow==10.0.0"}
+	require.False(t, build.RequirementsHashPinned())
+	require.NoError(t, build.validateHashPinned())
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/replicate/cog/pkg/retry"
+)
+
+// Retry is how operations that talk to Docker or a registry, like fetching the base image, pulling and pushing
+// images, and running the built image to get its schema, are retried when they fail with a transient error
+type Retry struct {
+	// Attempts is how many times an operation is tried, including the first time. 1 turns off retries.
+	Attempts int `json:"attempts,omitempty" yaml:"attempts"`
+	// Delay is how long to wait before the first retry, like 2s. It doubles after each retry.
+	Delay string `json:"delay,omitempty" yaml:"delay"`
+	// MaxDelay is the longest to wait between retries, like 30s
+	MaxDelay string `json:"max_delay,omitempty" yaml:"max_delay"`
+}
+
+// RetryPolicy returns the policy set with 'retry' in cog.yaml, with the defaults for what isn't set
+func (c *Config) RetryPolicy() (retry.Policy, error) {
+	policy := retry.DefaultPolicy
+	if c.Retry == nil {
+		return policy, nil
+	}
+	if c.Retry.Attempts < 0 {
+		return policy, fmt.Errorf("'retry.attempts' in cog.yaml must be at least 1")
+	}
+	if c.Retry.Attempts > 0 {
+		policy.Attempts = c.Retry.Attempts
+	}
+	if c.Retry.Delay != "" {
+		delay, err := time.ParseDuration(c.Retry.Delay)
+		if err != nil || delay < 0 {
+			return policy, fmt.Errorf("Invalid 'retry.delay' in cog.yaml %q, it should be a duration like '2s'", c.Retry.Delay)
+		}
+		policy.Delay = delay
+	}
+	if c.Retry.MaxDelay != "" {
+		maxDelay, err := time.ParseDuration(c.Retry.MaxDelay)
+		if err != nil || maxDelay < 0 {
+			return policy, fmt.Errorf("Invalid 'retry.max_delay' in cog.yaml %q, it should be a duration like '30s'", c.Retry.MaxDelay)
+		}
+		policy.MaxDelay = maxDelay
+	}
+	policy.MaxDelay = max(policy.MaxDelay, policy.Delay)
+	return policy, nil
+}
@@ -0,0 +1,32 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/retry"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	cfg, err := FromYAML([]byte(`
+predict: predict.py:Predictor
+retry:
+  attempts: 5
+  delay: 500ms
+`))
+	require.NoError(t, err)
+	policy, err := cfg.RetryPolicy()
+	require.NoError(t, err)
+	require.Equal(t, retry.Policy{Attempts: 5, Delay: 500 * time.Millisecond, MaxDelay: retry.DefaultPolicy.MaxDelay}, policy)
+
+	policy, err = (&Config{}).RetryPolicy()
+	require.NoError(t, err)
+	require.Equal(t, retry.DefaultPolicy, policy)
+
+	_, err = (&Config{Retry: &Retry{Delay: "soon"}}).RetryPolicy()
+	require.ErrorContains(t, err, "retry.delay")
+	_, err = (&Config{Retry: &Retry{Attempts: -1}}).RetryPolicy()
+	require.ErrorContains(t, err, "retry.attempts")
+}
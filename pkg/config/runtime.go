@@ -0,0 +1,174 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// SeccompUnconfined and AppArmorUnconfined run the container without a seccomp or AppArmor profile
+const (
+	SeccompUnconfined  = "unconfined"
+	AppArmorUnconfined = "unconfined"
+)
+
+// Container runtimes. gVisor and Kata Containers are sandboxes, for running models that aren't trusted.
+const (
+	RuntimeRunc   = "runc"
+	SandboxGVisor = "runsc"
+	SandboxKata   = "kata"
+)
+
+// Runtime is how the model's container is run by `cog run`, `cog predict`, `cog serve` and `cog train`. It's
+// recorded in the image, so schedulers can run the model the same way.
+type Runtime struct {
+	// ReadOnly mounts the container's root filesystem read-only, apart from WritablePaths
+	ReadOnly bool `json:"read_only,omitempty" yaml:"read_only"`
+	// WritablePaths are directories that are writable in a read-only container, as tmpfs mounts. /tmp is always
+	// writable, because Cog writes inputs and outputs there.
+	WritablePaths []string `json:"writable_paths,omitempty" yaml:"writable_paths"`
+	// Seccomp is the path of a seccomp profile, relative to the project, or "unconfined"
+	Seccomp string `json:"seccomp,omitempty" yaml:"seccomp"`
+	// AppArmor is the name of an AppArmor profile that's loaded on the host, or "unconfined"
+	AppArmor string `json:"apparmor,omitempty" yaml:"apparmor"`
+	// NoNewPrivileges stops processes in the container gaining privileges, like with setuid binaries
+	NoNewPrivileges bool `json:"no_new_privileges,omitempty" yaml:"no_new_privileges"`
+	// Runtime is the container runtime, like a sandbox. It's Docker's default runtime if it's empty.
+	Runtime string `json:"runtime,omitempty" yaml:"runtime"`
+	// Network restricts the hosts the container can connect to
+	Network *ContainerNetwork `json:"network,omitempty" yaml:"network"`
+}
+
+// ValidateSandbox returns an error if name isn't runc or a sandbox Cog knows about
+func ValidateSandbox(name string) error {
+	switch name {
+	case "", RuntimeRunc, SandboxGVisor, SandboxKata:
+		return nil
+	}
+	return fmt.Errorf("Unknown runtime %q. Use %q for gVisor or %q for Kata Containers.", name, SandboxGVisor, SandboxKata)
+}
+
+// SeccompProfilePath returns the absolute path of the seccomp profile, or "" if it's not a file
+func (r *Runtime) SeccompProfilePath(projectDir string) string {
+	if r == nil || r.Seccomp == "" || r.Seccomp == SeccompUnconfined {
+		return ""
+	}
+	if filepath.IsAbs(r.Seccomp) {
+		return r.Seccomp
+	}
+	return filepath.Join(projectDir, r.Seccomp)
+}
+
+func (c *Config) validateRuntime(projectDir string) error {
+	if c.Run == nil {
+		return nil
+	}
+	if err := ValidateSandbox(c.Run.Runtime); err != nil {
+		return fmt.Errorf("Invalid 'run.runtime' in cog.yaml: %w", err)
+	}
+	if err := c.Run.Network.validate(); err != nil {
+		return err
+	}
+	for _, p := range c.Run.WritablePaths {
+		if !path.IsAbs(p) || path.Clean(p) == "/" {
+			return fmt.Errorf("'run.writable_paths' in cog.yaml must be absolute paths in the container other than /, got %q", p)
+		}
+	}
+	if len(c.Run.WritablePaths) > 0 && !c.Run.ReadOnly {
+		return fmt.Errorf("'run.writable_paths' in cog.yaml is only used with 'run.read_only'")
+	}
+	if profilePath := c.Run.SeccompProfilePath(projectDir); profilePath != "" {
+		data, err := os.ReadFile(profilePath)
+		if err != nil {
+			return fmt.Errorf("Failed to read seccomp profile in 'run.seccomp' in cog.yaml: %w", err)
+		}
+		if !json.Valid(data) {
+			return fmt.Errorf("The seccomp profile %s in 'run.seccomp' in cog.yaml isn't valid JSON", c.Run.Seccomp)
+		}
+	}
+	return nil
+}
+
+// ContainerNetwork restricts the hosts the model's container can connect to. In cog.yaml it's either a mode, "none"
+// or "default", a list of the hosts it can connect to, or a map with them in allow.
+type ContainerNetwork struct {
+	Mode  string   `json:"mode,omitempty" yaml:"mode"`
+	Allow []string `json:"allow,omitempty" yaml:"allow"`
+}
+
+type containerNetworkAux ContainerNetwork
+
+func (n *ContainerNetwork) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var mode string
+	if err := unmarshal(&mode); err == nil {
+		*n = ContainerNetwork{Mode: mode}
+		return nil
+	}
+	var allow []string
+	if err := unmarshal(&allow); err == nil {
+		*n = ContainerNetwork{Allow: allow}
+		return nil
+	}
+	var aux containerNetworkAux
+	if err := unmarshal(&aux); err != nil {
+		return err
+	}
+	*n = ContainerNetwork(aux)
+	return nil
+}
+
+func (n *ContainerNetwork) UnmarshalJSON(data []byte) error {
+	var mode string
+	if err := json.Unmarshal(data, &mode); err == nil {
+		*n = ContainerNetwork{Mode: mode}
+		return nil
+	}
+	var allow []string
+	if err := json.Unmarshal(data, &allow); err == nil {
+		*n = ContainerNetwork{Allow: allow}
+		return nil
+	}
+	var aux containerNetworkAux
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	*n = ContainerNetwork(aux)
+	return nil
+}
+
+// Restricted returns whether the container can only connect to the allowed hosts
+func (n *ContainerNetwork) Restricted() bool {
+	return n != nil && (n.Mode == NetworkNone || len(n.Allow) > 0)
+}
+
+func (n *ContainerNetwork) validate() error {
+	if n == nil {
+		return nil
+	}
+	switch n.Mode {
+	case "", NetworkDefault, NetworkNone:
+	default:
+		return fmt.Errorf("'run.network' in cog.yaml must be %q, %q, or a list of hosts to allow, got %q", NetworkNone, NetworkDefault, n.Mode)
+	}
+	if len(n.Allow) > 0 && n.Mode != "" {
+		return fmt.Errorf("'run.network.mode' and 'run.network.allow' can't both be set in cog.yaml")
+	}
+	for _, host := range n.Allow {
+		if err := ValidateNetworkHost(host); err != nil {
+			return fmt.Errorf("Invalid host in 'run.network.allow' in cog.yaml: %w", err)
+		}
+	}
+	return nil
+}
+
+// ValidateNetworkHost returns an error if host isn't a host name, or a wildcard like *.example.com
+func ValidateNetworkHost(host string) error {
+	name := strings.TrimPrefix(host, "*.")
+	if name == "" || strings.ContainsAny(name, "/:*@ ") {
+		return fmt.Errorf("%q must be a host name like huggingface.co, or a wildcard like *.huggingface.co, without a scheme or port", host)
+	}
+	return nil
+}
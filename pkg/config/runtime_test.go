@@ -0,0 +1,83 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateRuntime(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "seccomp.json"), []byte(`{"defaultAction": "SCMP_ACT_ERRNO"}`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{`), 0o644))
+
+	for _, tt := range []struct {
+		name    string
+		runtime *Runtime
+		err     string
+	}{
+		{"not set", nil, ""},
+		{"valid", &Runtime{ReadOnly: true, WritablePaths: []string{"/root/.cache"}, Seccomp: "seccomp.json", NoNewPrivileges: true}, ""},
+		{"unconfined", &Runtime{Seccomp: SeccompUnconfined, AppArmor: AppArmorUnconfined}, ""},
+		{"relative writable path", &Runtime{ReadOnly: true, WritablePaths: []string{"cache"}}, "must be absolute"},
+		{"writable root", &Runtime{ReadOnly: true, WritablePaths: []string{"/"}}, "must be absolute"},
+		{"writable without read-only", &Runtime{WritablePaths: []string{"/root/.cache"}}, "only used with"},
+		{"missing seccomp profile", &Runtime{Seccomp: "missing.json"}, "Failed to read seccomp profile"},
+		{"invalid seccomp profile", &Runtime{Seccomp: "broken.json"}, "isn't valid JSON"},
+		{"sandbox", &Runtime{Runtime: SandboxGVisor}, ""},
+		{"unknown runtime", &Runtime{Runtime: "crun"}, "Unknown runtime"},
+		{"no network", &Runtime{Network: &ContainerNetwork{Mode: NetworkNone}}, ""},
+		{"allowed hosts", &Runtime{Network: &ContainerNetwork{Allow: []string{"huggingface.co", "*.s3.amazonaws.com"}}}, ""},
+		{"unknown network mode", &Runtime{Network: &ContainerNetwork{Mode: "host"}}, "'run.network' in cog.yaml must be"},
+		{"network mode and hosts", &Runtime{Network: &ContainerNetwork{Mode: NetworkNone, Allow: []string{"huggingface.co"}}}, "can't both be set"},
+		{"host with scheme", &Runtime{Network: &ContainerNetwork{Allow: []string{"https://huggingface.co"}}}, "without a scheme or port"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&Config{Run: tt.runtime}).validateRuntime(dir)
+			if tt.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestContainerNetworkYAML(t *testing.T) {
+	config, err := FromYAML([]byte(`
+build:
+  python_version: "3.12"
+run:
+  network: none
+`))
+	require.NoError(t, err)
+	require.Equal(t, &ContainerNetwork{Mode: NetworkNone}, config.Run.Network)
+	require.True(t, config.Run.Network.Restricted())
+
+	config, err = FromYAML([]byte(`
+build:
+  python_version: "3.12"
+run:
+  network:
+    allow:
+      - huggingface.co
+`))
+	require.NoError(t, err)
+	require.Equal(t, &ContainerNetwork{Allow: []string{"huggingface.co"}}, config.Run.Network)
+	require.True(t, config.Run.Network.Restricted())
+
+	config, err = FromYAML([]byte(`
+build:
+  python_version: "3.12"
+run:
+  network:
+    - huggingface.co
+`))
+	require.NoError(t, err)
+	require.Equal(t, &ContainerNetwork{Allow: []string{"huggingface.co"}}, config.Run.Network)
+
+	require.False(t, (&ContainerNetwork{Mode: NetworkDefault}).Restricted())
+	require.False(t, (*ContainerNetwork)(nil).Restricted())
+}
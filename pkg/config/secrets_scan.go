@@ -0,0 +1,32 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// SecretAllowed returns whether the file at p, relative to the project directory, is allowed to contain
+// credentials by build.allow_secrets. A pattern allows the files it matches, and everything in the directories it
+// matches.
+func (b *Build) SecretAllowed(p string) bool {
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	for _, pattern := range b.AllowSecrets {
+		pattern = strings.Trim(path.Clean("/"+pattern), "/")
+		for candidate := p; candidate != "." && candidate != "/"; candidate = path.Dir(candidate) {
+			if matched, _ := path.Match(pattern, candidate); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (b *Build) validateAllowSecrets() error {
+	for _, pattern := range b.AllowSecrets {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Invalid pattern %q in 'build.allow_secrets' in cog.yaml: %w", pattern, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretAllowed(t *testing.T) {
+	build := &Build{AllowSecrets: []string{"tests/fixtures", "*.pem", "./keys/dev-*.key"}}
+	require.True(t, build.SecretAllowed("tests/fixtures/aws.json"))
+	require.True(t, build.SecretAllowed("tests/fixtures"))
+	require.True(t, build.SecretAllowed("server.pem"))
+	require.True(t, build.SecretAllowed("keys/dev-1.key"))
+	require.False(t, build.SecretAllowed("keys/prod.key"))
+	require.False(t, build.SecretAllowed("tests/test_predict.py"))
+	require.False(t, build.SecretAllowed("certs/server.pem"))
+	require.False(t, (&Build{}).SecretAllowed("predict.py"))
+}
+
+func TestValidateAllowSecrets(t *testing.T) {
+	require.NoError(t, (&Build{AllowSecrets: []string{"tests/*"}}).validateAllowSecrets())
+	require.ErrorContains(t, (&Build{AllowSecrets: []string{"tests/["}}).validateAllowSecrets(), "Invalid pattern")
+}
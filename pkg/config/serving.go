@@ -0,0 +1,81 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	ServingBackendVLLM = "vllm"
+
+	DefaultVLLMVersion  = "0.6.3"
+	DefaultServingModel = "/src/weights"
+)
+
+// ServingBackend replaces the user's predictor with a serving engine that is installed and configured by Cog.
+// In cog.yaml it can be given either as a backend name, or as a map with the options below.
+type ServingBackend struct {
+	Name    string   `json:"name" yaml:"name"`
+	Model   string   `json:"model,omitempty" yaml:"model"`
+	Version string   `json:"version,omitempty" yaml:"version"`
+	Args    []string `json:"args,omitempty" yaml:"args"`
+}
+
+type servingBackendAux ServingBackend
+
+func (s *ServingBackend) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err == nil {
+		*s = ServingBackend{Name: name}
+		return nil
+	}
+	var aux servingBackendAux
+	if err := unmarshal(&aux); err != nil {
+		return err
+	}
+	*s = ServingBackend(aux)
+	return nil
+}
+
+func (s *ServingBackend) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*s = ServingBackend{Name: name}
+		return nil
+	}
+	var aux servingBackendAux
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	*s = ServingBackend(aux)
+	return nil
+}
+
+// PackageVersion returns the version of the serving engine to install.
+func (s *ServingBackend) PackageVersion() string {
+	if s.Version != "" {
+		return s.Version
+	}
+	return DefaultVLLMVersion
+}
+
+// ModelPath returns the model the serving engine loads, either a path inside the image or a Hugging Face model ID.
+func (s *ServingBackend) ModelPath() string {
+	if s.Model != "" {
+		return s.Model
+	}
+	return DefaultServingModel
+}
+
+func (c *Config) validateServingBackend() error {
+	if c.ServingBackend == nil {
+		return nil
+	}
+	if c.ServingBackend.Name != ServingBackendVLLM {
+		return fmt.Errorf("Unsupported serving_backend %q in cog.yaml, the only supported backend is %q", c.ServingBackend.Name, ServingBackendVLLM)
+	}
+	if c.Predict != "" {
+		return fmt.Errorf("'predict' and 'serving_backend' cannot both be set in cog.yaml: the serving backend provides the predictor")
+	}
+	return nil
+}
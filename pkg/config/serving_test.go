@@ -0,0 +1,59 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServingBackendString(t *testing.T) {
+	cfg, err := FromYAML([]byte(`
+build:
+  gpu: true
+serving_backend: vllm
+`))
+	require.NoError(t, err)
+	require.Equal(t, &ServingBackend{Name: "vllm"}, cfg.ServingBackend)
+	require.Equal(t, DefaultServingModel, cfg.ServingBackend.ModelPath())
+	require.Equal(t, DefaultVLLMVersion, cfg.ServingBackend.PackageVersion())
+}
+
+func TestServingBackendMap(t *testing.T) {
+	cfg, err := FromYAML([]byte(`
+build:
+  gpu: true
+serving_backend:
+  name: vllm
+  model: meta-llama/Llama-3.1-8B-Instruct
+  version: "0.6.4"
+  args: ["--max-model-len", "8192"]
+`))
+	require.NoError(t, err)
+	require.Equal(t, &ServingBackend{
+		Name:    "vllm",
+		Model:   "meta-llama/Llama-3.1-8B-Instruct",
+		Version: "0.6.4",
+		Args:    []string{"--max-model-len", "8192"},
+	}, cfg.ServingBackend)
+}
+
+func TestServingBackendUnsupported(t *testing.T) {
+	_, err := FromYAML([]byte(`
+build:
+  gpu: true
+serving_backend: tgi
+`))
+	require.Error(t, err)
+}
+
+func TestServingBackendWithPredict(t *testing.T) {
+	cfg, err := FromYAML([]byte(`
+build:
+  gpu: true
+serving_backend: vllm
+predict: predict.py:Predictor
+`))
+	require.NoError(t, err)
+	err = cfg.validateServingBackend()
+	require.ErrorContains(t, err, "cannot both be set")
+}
@@ -0,0 +1,37 @@
+package config
+
+import "fmt"
+
+const (
+	SetupStrategyThread  = "thread"
+	SetupStrategyProcess = "process"
+)
+
+// Setup is how setup() loads a large model. cog.startup.parallel_load() loads files with Threads workers, which are
+// threads or processes depending on Strategy, and cog.startup.device_map() returns DeviceMap.
+type Setup struct {
+	// Threads is the number of workers files are loaded with. It defaults to the number of CPUs.
+	Threads int `json:"threads,omitempty" yaml:"threads"`
+	// Strategy is whether the workers are threads (the default) or processes
+	Strategy string `json:"strategy,omitempty" yaml:"strategy"`
+	// DeviceMap is how models are spread over the GPUs, like auto, passed to libraries like transformers
+	DeviceMap string `json:"device_map,omitempty" yaml:"device_map"`
+}
+
+func (c *Config) validateSetup() error {
+	if c.Setup == nil {
+		return nil
+	}
+	if c.PredictorLanguage() != LanguagePython {
+		return fmt.Errorf("'setup' in cog.yaml is only supported for Python predictors")
+	}
+	if c.Setup.Threads < 0 {
+		return fmt.Errorf("'setup.threads' in cog.yaml must be at least 1")
+	}
+	switch c.Setup.Strategy {
+	case "", SetupStrategyThread, SetupStrategyProcess:
+	default:
+		return fmt.Errorf("Invalid 'setup.strategy' in cog.yaml: it must be %s or %s", SetupStrategyThread, SetupStrategyProcess)
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetup(t *testing.T) {
+	cfg, err := FromYAML([]byte(`
+predict: predict.py:Predictor
+setup:
+  threads: 8
+  strategy: process
+  device_map: auto
+`))
+	require.NoError(t, err)
+	require.NoError(t, cfg.validateSetup())
+	require.Equal(t, &Setup{Threads: 8, Strategy: SetupStrategyProcess, DeviceMap: "auto"}, cfg.Setup)
+
+	cfg.Setup.Strategy = "fiber"
+	require.ErrorContains(t, cfg.validateSetup(), "setup.strategy")
+
+	cfg = &Config{Build: &Build{}, Predict: "predict.ts:Predictor", Setup: &Setup{Threads: 4}}
+	require.ErrorContains(t, cfg.validateSetup(), "only supported for Python predictors")
+}
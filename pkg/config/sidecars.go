@@ -0,0 +1,61 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+)
+
+const (
+	SidecarRestartAlways    = "always"
+	SidecarRestartOnFailure = "on-failure"
+	SidecarRestartNever     = "never"
+)
+
+var sidecarNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Sidecar is a long-running process, like a tokenizer server, that Cog's HTTP server runs alongside the predictor.
+// Its output is written to the model's output with each line prefixed by its name.
+type Sidecar struct {
+	Name string `json:"name" yaml:"name"`
+	// Command is run with /bin/sh -c in the project directory
+	Command string `json:"command" yaml:"command"`
+	// HealthCheck is a URL that returns a 2xx status when the sidecar is healthy. The model isn't ready until it is.
+	HealthCheck string `json:"health_check,omitempty" yaml:"health_check"`
+	// Restart is when the sidecar is restarted after it exits: always (the default), on-failure or never
+	Restart string `json:"restart,omitempty" yaml:"restart"`
+}
+
+func (c *Config) validateSidecars() error {
+	if len(c.Sidecars) == 0 {
+		return nil
+	}
+	if c.PredictorLanguage() != LanguagePython {
+		return fmt.Errorf("'sidecars' in cog.yaml are only supported for Python predictors, because they're run by Cog's Python server")
+	}
+	names := map[string]bool{}
+	for _, sidecar := range c.Sidecars {
+		if !sidecarNameRegex.MatchString(sidecar.Name) {
+			return fmt.Errorf("Invalid sidecar name %q in cog.yaml: it must be lowercase letters, digits, '-' and '_'", sidecar.Name)
+		}
+		if names[sidecar.Name] {
+			return fmt.Errorf("Sidecar %q is in cog.yaml more than once", sidecar.Name)
+		}
+		names[sidecar.Name] = true
+		if sidecar.Command == "" {
+			return fmt.Errorf("Sidecar %q in cog.yaml must set 'command'", sidecar.Name)
+		}
+		if sidecar.HealthCheck != "" {
+			u, err := url.Parse(sidecar.HealthCheck)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("Invalid 'health_check' for sidecar %q in cog.yaml: it must be an http:// or https:// URL", sidecar.Name)
+			}
+		}
+		switch sidecar.Restart {
+		case "", SidecarRestartAlways, SidecarRestartOnFailure, SidecarRestartNever:
+		default:
+			return fmt.Errorf("Invalid 'restart' for sidecar %q in cog.yaml: it must be %s, %s or %s", sidecar.Name, SidecarRestartAlways, SidecarRestartOnFailure, SidecarRestartNever)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSidecars(t *testing.T) {
+	cfg, err := FromYAML([]byte(`
+predict: predict.py:Predictor
+sidecars:
+  - name: tokenizer
+    command: python tokenizer_server.py --port 7000
+    health_check: http://localhost:7000/health
+  - name: cache
+    command: redis-server
+    restart: on-failure
+`))
+	require.NoError(t, err)
+	require.NoError(t, cfg.validateSidecars())
+	require.Equal(t, []Sidecar{
+		{Name: "tokenizer", Command: "python tokenizer_server.py --port 7000", HealthCheck: "http://localhost:7000/health"},
+		{Name: "cache", Command: "redis-server", Restart: SidecarRestartOnFailure},
+	}, cfg.Sidecars)
+}
+
+func TestSidecarsInvalid(t *testing.T) {
+	for _, tt := range []struct {
+		sidecars []Sidecar
+		err      string
+	}{
+		{[]Sidecar{{Name: "Tokenizer", Command: "serve"}}, "Invalid sidecar name"},
+		{[]Sidecar{{Name: "a", Command: "serve"}, {Name: "a", Command: "serve"}}, "more than once"},
+		{[]Sidecar{{Name: "a"}}, "must set 'command'"},
+		{[]Sidecar{{Name: "a", Command: "serve", HealthCheck: "localhost:7000/health"}}, "health_check"},
+		{[]Sidecar{{Name: "a", Command: "serve", Restart: "sometimes"}}, "restart"},
+	} {
+		cfg := &Config{Build: &Build{}, Predict: "predict.py:Predictor", Sidecars: tt.sidecars}
+		require.ErrorContains(t, cfg.validateSidecars(), tt.err)
+	}
+
+	cfg := &Config{Build: &Build{}, Predict: "predict.ts:Predictor", Sidecars: []Sidecar{{Name: "a", Command: "serve"}}}
+	require.ErrorContains(t, cfg.validateSidecars(), "only supported for Python predictors")
+}
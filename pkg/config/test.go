@@ -0,0 +1,51 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+)
+
+// TestCase is a set of inputs `cog test` runs the model on, to compare its outputs against another version of it
+type TestCase struct {
+	Name  string                 `json:"name,omitempty" yaml:"name"`
+	Input map[string]interface{} `json:"input" yaml:"input"`
+	// Compare overrides how parts of the output are compared
+	Compare []Comparator `json:"compare,omitempty" yaml:"compare"`
+}
+
+// Comparator is how to compare the part of an output at Path, like output.scores or output[*].image
+type Comparator struct {
+	Path string `json:"path" yaml:"path"`
+	// Type is exact, numeric, image or ignore
+	Type        string  `json:"type" yaml:"type"`
+	Tolerance   float64 `json:"tolerance,omitempty" yaml:"tolerance"`
+	MaxDistance int     `json:"max_distance,omitempty" yaml:"max_distance"`
+}
+
+// InputFlags returns the test case's inputs as name=value strings, like the -i flags of `cog predict`
+func (t TestCase) InputFlags() []string {
+	return InputFlags(t.Input)
+}
+
+// InputFlags returns inputs declared in YAML as name=value strings, like the -i flags of `cog predict`. Lists are
+// repeated, like -i name=a -i name=b.
+func InputFlags(input map[string]interface{}) []string {
+	names := make([]string, 0, len(input))
+	for name := range input {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	flags := []string{}
+	for _, name := range names {
+		switch value := input[name].(type) {
+		case []interface{}:
+			for _, v := range value {
+				flags = append(flags, fmt.Sprintf("%s=%v", name, v))
+			}
+		default:
+			flags = append(flags, fmt.Sprintf("%s=%v", name, value))
+		}
+	}
+	return flags
+}
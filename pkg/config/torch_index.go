@@ -0,0 +1,72 @@
+package config
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/replicate/cog/pkg/util"
+	"github.com/replicate/cog/pkg/util/version"
+)
+
+// torchPackages are the packages published to the PyTorch wheel index for each CUDA version
+var torchPackages = []string{"torch", "torchvision", "torchaudio"}
+
+var requirementNamePattern = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9._-]*)`)
+
+// IsTorchPackage reports whether name is published to the PyTorch wheel index
+func IsTorchPackage(name string) bool {
+	return sliceContains(torchPackages, name)
+}
+
+// TorchIndexURL returns the PyTorch wheel index with the torch, torchvision and torchaudio builds for the
+// CUDA version in cog.yaml, or the CPU builds if the model doesn't use a GPU. It returns an empty string
+// if the default packages on PyPI should be used.
+func (c *Config) TorchIndexURL(goos string, goarch string) string {
+	if !c.Build.GPU {
+		if util.IsAppleSiliconMac(goos, goarch) {
+			// The default aarch64 packages are CPU only
+			return ""
+		}
+		for _, compat := range TorchCompatibilityMatrix {
+			if compat.CUDA == nil && compat.ExtraIndexURL != "" {
+				return compat.ExtraIndexURL
+			}
+		}
+		return ""
+	}
+
+	torchVersion, pinned := c.TorchVersion()
+	// Use the index for the latest CUDA version that's at most the CUDA version in the image
+	var latest *TorchCompatibility
+	for _, compat := range TorchCompatibilityMatrix {
+		if compat.CUDA == nil || compat.ExtraIndexURL == "" {
+			continue
+		}
+		if pinned && !version.Matches(compat.TorchVersion(), version.StripModifier(torchVersion)) {
+			continue
+		}
+		if greater, err := versionGreater(*compat.CUDA, c.Build.CUDA); err != nil || greater {
+			continue
+		}
+		if latest == nil {
+			latest = &compat
+		} else if greater, err := versionGreater(*compat.CUDA, *latest.CUDA); err == nil && greater {
+			latest = &compat
+		}
+	}
+	if latest == nil {
+		return ""
+	}
+	return latest.ExtraIndexURL
+}
+
+// hasTorchIndex reports whether a requirements line already points pip at the PyTorch wheel index
+func hasTorchIndex(line string) bool {
+	return strings.HasPrefix(line, "-") && strings.Contains(line, "download.pytorch.org")
+}
+
+// requirementName returns the package name of a requirements line in any format, such as "torch>=2.0",
+// or an empty string for options and comments
+func requirementName(line string) string {
+	return requirementNamePattern.FindString(strings.TrimSpace(line))
+}
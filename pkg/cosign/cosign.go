@@ -0,0 +1,90 @@
+package cosign
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// VerifyOptions are the trusted signers for Verify. Images are verified with Key if it's set, and otherwise
+// with keyless signing, where the signing certificate has to match Identity and OIDCIssuer.
+type VerifyOptions struct {
+	// Key is a path or URL to a public key, or a KMS reference
+	Key string
+	// Identity is the identity in the signing certificate, like an email address. It has to match exactly, so an
+	// identity that only contains it, like one from an attacker's domain, isn't trusted.
+	Identity string
+	// OIDCIssuer is the OIDC issuer in the signing certificate, which has to match exactly too
+	OIDCIssuer string
+}
+
+// Installed returns true if cosign is available
+func Installed() bool {
+	_, err := exec.LookPath("cosign")
+	return err == nil
+}
+
+// Verify checks that image is signed by one of the signers in options with `cosign verify`, and returns the digest
+// of the manifest that was verified, so the image can be pinned to it
+func Verify(image string, options VerifyOptions) (string, error) {
+	args, err := verifyArgs(image, options)
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command("cosign", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	console.Debug("$ " + strings.Join(cmd.Args, " "))
+	out, err := cmd.Output()
+	if err != nil {
+		if details := strings.TrimSpace(stderr.String()); details != "" {
+			return "", fmt.Errorf("%s", details)
+		}
+		return "", err
+	}
+	return verifiedDigest(out)
+}
+
+func verifyArgs(image string, options VerifyOptions) ([]string, error) {
+	args := []string{"verify", "--output", "json"}
+	switch {
+	case options.Key != "":
+		args = append(args, "--key", options.Key)
+	case options.Identity != "" && options.OIDCIssuer != "":
+		args = append(args, "--certificate-identity", options.Identity, "--certificate-oidc-issuer", options.OIDCIssuer)
+	default:
+		return nil, errors.New("A public key, or a certificate identity and OIDC issuer, are needed to verify signatures with cosign")
+	}
+	return append(args, image), nil
+}
+
+// verifiedDigest returns the manifest digest that the signatures in the output of `cosign verify --output json` are for
+func verifiedDigest(out []byte) (string, error) {
+	var signatures []struct {
+		Critical struct {
+			Image struct {
+				DockerManifestDigest string `json:"docker-manifest-digest"`
+			} `json:"image"`
+		} `json:"critical"`
+	}
+	if err := json.Unmarshal(out, &signatures); err != nil {
+		return "", fmt.Errorf("Failed to parse the output of cosign verify: %w", err)
+	}
+	digest := ""
+	for _, signature := range signatures {
+		d := signature.Critical.Image.DockerManifestDigest
+		if d == "" || (digest != "" && d != digest) {
+			return "", fmt.Errorf("cosign verify didn't say which digest was verified")
+		}
+		digest = d
+	}
+	if digest == "" {
+		return "", fmt.Errorf("cosign verify didn't return any signatures")
+	}
+	return digest, nil
+}
@@ -0,0 +1,35 @@
+package cosign
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifiedDigest(t *testing.T) {
+	out := []byte(`[{"critical":{"identity":{"docker-reference":"r8.im/cog-base"},"image":{"docker-manifest-digest":"sha256:abc123"},"type":"cosign container image signature"},"optional":null},
+{"critical":{"image":{"docker-manifest-digest":"sha256:abc123"}}}]`)
+	digest, err := verifiedDigest(out)
+	require.NoError(t, err)
+	require.Equal(t, "sha256:abc123", digest)
+
+	_, err = verifiedDigest([]byte(`[{"critical":{"image":{"docker-manifest-digest":"sha256:abc123"}}},{"critical":{"image":{"docker-manifest-digest":"sha256:def456"}}}]`))
+	require.ErrorContains(t, err, "didn't say which digest")
+
+	_, err = verifiedDigest([]byte(`[]`))
+	require.ErrorContains(t, err, "didn't return any signatures")
+}
+
+func TestVerifyArgs(t *testing.T) {
+	args, err := verifyArgs("r8.im/cog-base", VerifyOptions{Identity: "builds@replicate.com", OIDCIssuer: "https://accounts.google.com"})
+	require.NoError(t, err)
+	// The identity and issuer have to match exactly, rather than as unanchored regular expressions
+	require.Equal(t, []string{"verify", "--output", "json", "--certificate-identity", "builds@replicate.com", "--certificate-oidc-issuer", "https://accounts.google.com", "r8.im/cog-base"}, args)
+
+	args, err = verifyArgs("r8.im/cog-base", VerifyOptions{Key: "cosign.pub"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"verify", "--output", "json", "--key", "cosign.pub", "r8.im/cog-base"}, args)
+
+	_, err = verifyArgs("r8.im/cog-base", VerifyOptions{Identity: "builds@replicate.com"})
+	require.Error(t, err)
+}
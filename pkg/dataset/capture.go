@@ -0,0 +1,71 @@
+package dataset
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/replicate/cog/pkg/proxy"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// Capture records the inputs and outputs of the successful predictions sent to a handler to a dataset
+type Capture struct {
+	writer *Writer
+}
+
+// NewCapture returns a Capture that appends to the dataset at path
+func NewCapture(path string) (*Capture, error) {
+	writer, err := NewWriter(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Capture{writer: writer}, nil
+}
+
+func (c *Capture) Close() error {
+	return c.writer.Close()
+}
+
+// Middleware records the predictions sent to next. Only synchronous predictions that succeed are recorded, because
+// async ones send their outputs to webhooks.
+func (c *Capture) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method != http.MethodPost && r.Method != http.MethodPut) || !strings.HasPrefix(r.URL.Path, "/predictions") || strings.HasSuffix(r.URL.Path, "/cancel") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		request, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(request))
+		recorder := proxy.NewResponseRecorder(w)
+		recorder.Body = &bytes.Buffer{}
+		next.ServeHTTP(recorder, r)
+
+		if recorder.Status != http.StatusOK {
+			return
+		}
+		var req struct {
+			Input map[string]any `json:"input"`
+		}
+		var resp struct {
+			Status string `json:"status"`
+			Output any    `json:"output"`
+		}
+		if json.Unmarshal(request, &req) != nil || json.Unmarshal(recorder.Body.Bytes(), &resp) != nil || resp.Status != "succeeded" {
+			return
+		}
+		if req.Input == nil {
+			req.Input = map[string]any{}
+		}
+		if err := c.writer.Write(Example{Input: req.Input, Output: resp.Output}); err != nil {
+			console.Warnf("Failed to capture prediction: %s", err)
+		}
+	})
+}
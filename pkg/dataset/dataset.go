@@ -0,0 +1,207 @@
+// Package dataset reads and writes datasets of a model's inputs and outputs, in JSON lines, like the ones `cog serve
+// --capture` records to turn manual testing into tests for `cog test --dataset`.
+//
+// Each line is an example, with the inputs and outputs of a prediction:
+//
+//	{"name": "...", "input": {"prompt": "a cat", "image": "@dataset_files/3f2a....png"}, "output": "@dataset_files/9b1c....png"}
+//
+// Files are in a directory next to the dataset, and are referred to with @ and their path relative to the dataset,
+// like the inputs of tests in cog.yaml.
+package dataset
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/vincent-petithory/dataurl"
+
+	"github.com/replicate/cog/pkg/util/mime"
+)
+
+// Example is the inputs of a prediction, and its output
+type Example struct {
+	Name   string         `json:"name,omitempty"`
+	Input  map[string]any `json:"input"`
+	Output any            `json:"output,omitempty"`
+}
+
+// FilesDir returns the directory the files of the dataset at path are in, like dataset_files for dataset.jsonl
+func FilesDir(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + "_files"
+}
+
+// Read returns the examples in the dataset at path
+func Read(path string) ([]Example, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open dataset: %w", err)
+	}
+	defer f.Close()
+
+	examples := []Example{}
+	scanner := bufio.NewScanner(f)
+	// Lines can have large inputs and outputs that weren't saved as files
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var example Example
+		if err := json.Unmarshal(scanner.Bytes(), &example); err != nil {
+			return nil, fmt.Errorf("Invalid example on line %d of %s: %w", line, path, err)
+		}
+		if example.Name == "" {
+			example.Name = fmt.Sprintf("line %d", line)
+		}
+		examples = append(examples, example)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to read dataset: %w", err)
+	}
+	return examples, nil
+}
+
+// ResolveFiles returns value with references to the dataset's files, like "@dataset_files/abc.png", replaced with
+// their contents as data URLs, so outputs in the dataset can be compared with a model's outputs. datasetDir is the
+// directory the dataset is in.
+func ResolveFiles(value any, datasetDir string) (any, error) {
+	switch v := value.(type) {
+	case map[string]any:
+		resolved := make(map[string]any, len(v))
+		for key, elem := range v {
+			r, err := ResolveFiles(elem, datasetDir)
+			if err != nil {
+				return nil, err
+			}
+			resolved[key] = r
+		}
+		return resolved, nil
+	case []any:
+		resolved := make([]any, len(v))
+		for i, elem := range v {
+			r, err := ResolveFiles(elem, datasetDir)
+			if err != nil {
+				return nil, err
+			}
+			resolved[i] = r
+		}
+		return resolved, nil
+	case string:
+		path, ok := strings.CutPrefix(v, "@")
+		if !ok || strings.Contains(path, "://") {
+			return v, nil
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(datasetDir, path)
+		}
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			// It's a string that starts with @, not a file
+			return v, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return dataurl.New(data, mime.TypeByExtension(filepath.Ext(path))).String(), nil
+	default:
+		return v, nil
+	}
+}
+
+// Writer appends examples to a dataset, and saves the files in them in the dataset's files directory
+type Writer struct {
+	mu       sync.Mutex
+	file     *os.File
+	filesDir string
+	// filesRef is how files are referred to in the dataset, relative to it
+	filesRef string
+}
+
+// NewWriter returns a Writer that appends to the dataset at path
+func NewWriter(path string) (*Writer, error) {
+	filesDir := FilesDir(path)
+	if err := os.MkdirAll(filesDir, 0o755); err != nil {
+		return nil, fmt.Errorf("Failed to create dataset files directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open dataset: %w", err)
+	}
+	return &Writer{file: file, filesDir: filesDir, filesRef: filepath.Base(filesDir)}, nil
+}
+
+// Write saves the files in the example, which are data URLs, and appends it to the dataset
+func (w *Writer) Write(example Example) error {
+	input, err := w.saveFiles(example.Input)
+	if err != nil {
+		return err
+	}
+	example.Input, _ = input.(map[string]any)
+	if example.Output, err = w.saveFiles(example.Output); err != nil {
+		return err
+	}
+	line, err := json.Marshal(example)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err = w.file.Write(append(line, '\n'))
+	return err
+}
+
+func (w *Writer) Close() error {
+	return w.file.Close()
+}
+
+// saveFiles returns value with the data URLs in it saved as files and replaced with references to them. Files are
+// named by their hash, so a file that's in many examples is only saved once.
+func (w *Writer) saveFiles(value any) (any, error) {
+	switch v := value.(type) {
+	case map[string]any:
+		saved := make(map[string]any, len(v))
+		for key, elem := range v {
+			s, err := w.saveFiles(elem)
+			if err != nil {
+				return nil, err
+			}
+			saved[key] = s
+		}
+		return saved, nil
+	case []any:
+		saved := make([]any, len(v))
+		for i, elem := range v {
+			s, err := w.saveFiles(elem)
+			if err != nil {
+				return nil, err
+			}
+			saved[i] = s
+		}
+		return saved, nil
+	case string:
+		if !strings.HasPrefix(v, "data:") {
+			return v, nil
+		}
+		u, err := dataurl.DecodeString(v)
+		if err != nil {
+			return v, nil
+		}
+		sum := sha256.Sum256(u.Data)
+		name := hex.EncodeToString(sum[:])[:16] + mime.ExtensionByType(u.ContentType())
+		if err := os.WriteFile(filepath.Join(w.filesDir, name), u.Data, 0o644); err != nil {
+			return nil, fmt.Errorf("Failed to save file in dataset: %w", err)
+		}
+		return "@" + w.filesRef + "/" + name, nil
+	default:
+		return v, nil
+	}
+}
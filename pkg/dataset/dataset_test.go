@@ -0,0 +1,82 @@
+package dataset
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vincent-petithory/dataurl"
+)
+
+func TestWriteAndRead(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dataset.jsonl")
+	image := dataurl.New([]byte("png data"), "image/png").String()
+
+	w, err := NewWriter(path)
+	require.NoError(t, err)
+	require.NoError(t, w.Write(Example{Input: map[string]any{"image": image, "prompt": "a cat"}, Output: []any{image, "done"}}))
+	require.NoError(t, w.Write(Example{Name: "no output", Input: map[string]any{"prompt": "@someone"}}))
+	require.NoError(t, w.Close())
+
+	files, err := os.ReadDir(FilesDir(path))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.Equal(t, ".png", filepath.Ext(files[0].Name()))
+
+	examples, err := Read(path)
+	require.NoError(t, err)
+	require.Len(t, examples, 2)
+	require.Equal(t, "line 1", examples[0].Name)
+	ref := "@dataset_files/" + files[0].Name()
+	require.Equal(t, map[string]any{"image": ref, "prompt": "a cat"}, examples[0].Input)
+	require.Equal(t, "no output", examples[1].Name)
+	require.Nil(t, examples[1].Output)
+
+	output, err := ResolveFiles(examples[0].Output, dir)
+	require.NoError(t, err)
+	require.Equal(t, []any{image, "done"}, output)
+
+	// Strings that start with @ but aren't files are left alone
+	input, err := ResolveFiles(examples[1].Input, dir)
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{"prompt": "@someone"}, input)
+}
+
+func TestCapture(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "captured.jsonl")
+	capture, err := NewCapture(path)
+	require.NoError(t, err)
+
+	model := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Prefer") == "respond-async" {
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = w.Write([]byte(`{"status":"starting"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"status":"succeeded","output":"hello"}`))
+	})
+	server := httptest.NewServer(capture.Middleware(model))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/predictions", "application/json", strings.NewReader(`{"input":{"text":"hello"}}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/predictions", strings.NewReader(`{"input":{"text":"async"}}`))
+	require.NoError(t, err)
+	req.Header.Set("Prefer", "respond-async")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.NoError(t, capture.Close())
+
+	examples, err := Read(path)
+	require.NoError(t, err)
+	require.Len(t, examples, 1)
+	require.Equal(t, map[string]any{"text": "hello"}, examples[0].Input)
+	require.Equal(t, "hello", examples[0].Output)
+}
@@ -0,0 +1,339 @@
+// Package determinism compares the outputs of running a model on the same inputs several times, to catch predictors
+// that don't return the same output every time before they're released, and compares the outputs of two versions of
+// a model, to catch regressions.
+package determinism
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/vincent-petithory/dataurl"
+)
+
+// Statuses of a run's output compared to the first run's. Equivalent outputs differ, but only by less than the
+// tolerances, like floating point noise or an image that looks the same.
+const (
+	StatusIdentical  = "identical"
+	StatusEquivalent = "equivalent"
+	StatusDivergent  = "divergent"
+)
+
+// DefaultMaxImageDistance is how many of the 64 bits of two images' perceptual hashes can differ for them to look
+// the same
+const DefaultMaxImageDistance = 4
+
+// Options are the tolerances outputs are compared with
+type Options struct {
+	// Tolerance is the largest absolute difference between numbers that are counted as equivalent
+	Tolerance float64
+	// MaxImageDistance is the largest distance between the perceptual hashes of images that are counted as equivalent
+	MaxImageDistance int
+	// Comparators override how parts of outputs are compared. If more than one matches a path, the last one is used.
+	Comparators []Comparator
+}
+
+// Types of Comparator
+const (
+	// ComparatorExact counts any difference as divergent
+	ComparatorExact = "exact"
+	// ComparatorNumeric compares numbers with the comparator's Tolerance
+	ComparatorNumeric = "numeric"
+	// ComparatorImage compares images with the comparator's MaxImageDistance
+	ComparatorImage = "image"
+	// ComparatorIgnore skips comparing, for things like timings
+	ComparatorIgnore = "ignore"
+)
+
+// Comparator is how to compare the part of an output at Path, and everything inside it
+type Comparator struct {
+	// Path is like output.scores. [*] matches any item of a list, and .* any key of an object, like output[*].image.
+	Path             string
+	Type             string
+	Tolerance        float64
+	MaxImageDistance int
+}
+
+// Validate returns an error if the comparators aren't valid
+func (o Options) Validate() error {
+	for _, c := range o.Comparators {
+		switch c.Type {
+		case ComparatorExact, ComparatorNumeric, ComparatorImage, ComparatorIgnore:
+		default:
+			return fmt.Errorf("Unknown comparator %q for %s. The comparators are exact, numeric, image and ignore.", c.Type, c.Path)
+		}
+		if c.Path != "output" && !strings.HasPrefix(c.Path, "output.") && !strings.HasPrefix(c.Path, "output[") {
+			return fmt.Errorf("The path %q of a comparator must start with output", c.Path)
+		}
+	}
+	return nil
+}
+
+// at returns the options for comparing the part of an output at path
+func (o Options) at(path string) (Options, bool) {
+	ignore := false
+	for _, c := range o.Comparators {
+		if !pathPattern(c.Path).MatchString(path) {
+			continue
+		}
+		ignore = false
+		switch c.Type {
+		case ComparatorExact:
+			o.Tolerance = 0
+			o.MaxImageDistance = -1
+		case ComparatorNumeric:
+			o.Tolerance = c.Tolerance
+		case ComparatorImage:
+			o.MaxImageDistance = c.MaxImageDistance
+		case ComparatorIgnore:
+			ignore = true
+		}
+	}
+	return o, ignore
+}
+
+func pathPattern(path string) *regexp.Regexp {
+	pattern := regexp.QuoteMeta(path)
+	pattern = strings.ReplaceAll(pattern, `\[\*\]`, `\[\d+\]`)
+	pattern = strings.ReplaceAll(pattern, `\.\*`, `\.[^.\[]+`)
+	return regexp.MustCompile("^" + pattern + "$")
+}
+
+// Difference is a difference between a run's output and the first run's
+type Difference struct {
+	// Path is where in the output it is, like output[0].scores
+	Path    string `json:"path"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// Run is the output of a run compared to the first run's
+type Run struct {
+	// Hash is the SHA-256 of the output, as JSON
+	Hash        string       `json:"hash"`
+	Status      string       `json:"status"`
+	Differences []Difference `json:"differences,omitempty"`
+}
+
+// Report compares the outputs of runs of a model with the same inputs
+type Report struct {
+	Runs []Run `json:"runs"`
+}
+
+// Status returns the status of the run whose output diverged most from the first run's
+func (r Report) Status() string {
+	statuses := []string{}
+	for _, run := range r.Runs {
+		statuses = append(statuses, run.Status)
+	}
+	return Worst(statuses...)
+}
+
+// Worst returns the status that's furthest from identical
+func Worst(statuses ...string) string {
+	worst := StatusIdentical
+	for _, status := range statuses {
+		if rank(status) > rank(worst) {
+			worst = status
+		}
+	}
+	return worst
+}
+
+// Diverged returns how many runs had outputs that diverged from the first run's
+func (r Report) Diverged() int {
+	n := 0
+	for _, run := range r.Runs {
+		if run.Status == StatusDivergent {
+			n++
+		}
+	}
+	return n
+}
+
+// Compare compares each output to the first one
+func Compare(outputs []any, options Options) Report {
+	report := Report{}
+	for i, output := range outputs {
+		run := Run{Hash: Hash(output), Status: StatusIdentical}
+		if i > 0 && run.Hash != report.Runs[0].Hash {
+			run.Status, run.Differences = Diff(outputs[0], output, options)
+		}
+		report.Runs = append(report.Runs, run)
+	}
+	return report
+}
+
+// Diff compares output b to output a, and returns the differences and the status of the worst one
+func Diff(a any, b any, options Options) (string, []Difference) {
+	status := StatusIdentical
+	differences := []Difference{}
+	compare(a, b, "output", options, func(d Difference) {
+		differences = append(differences, d)
+		if rank(d.Status) > rank(status) {
+			status = d.Status
+		}
+	})
+	return status, differences
+}
+
+func rank(status string) int {
+	switch status {
+	case StatusEquivalent:
+		return 1
+	case StatusDivergent:
+		return 2
+	}
+	return 0
+}
+
+// Hash returns the SHA-256 of output, as JSON
+func Hash(output any) string {
+	// encoding/json sorts the keys of maps, so equal outputs have the same JSON
+	data, _ := json.Marshal(output)
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func fileHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func compare(a any, b any, path string, options Options, add func(Difference)) {
+	options, ignore := options.at(path)
+	if ignore {
+		return
+	}
+	divergent := func(format string, args ...any) {
+		add(Difference{Path: path, Status: StatusDivergent, Message: fmt.Sprintf(format, args...)})
+	}
+	equivalent := func(format string, args ...any) {
+		add(Difference{Path: path, Status: StatusEquivalent, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch a := a.(type) {
+	case float64:
+		b, ok := b.(float64)
+		if !ok {
+			divergent("a number in the first run, but %s", describe(b))
+			return
+		}
+		diff := math.Abs(a - b)
+		switch {
+		case diff == 0:
+		case diff <= options.Tolerance:
+			equivalent("%v and %v differ by %g, within the tolerance", a, b, diff)
+		default:
+			divergent("%v and %v differ by %g", a, b, diff)
+		}
+	case string:
+		b, ok := b.(string)
+		if !ok {
+			divergent("a string in the first run, but %s", describe(b))
+			return
+		}
+		if a != b {
+			compareStrings(a, b, options, equivalent, divergent)
+		}
+	case []any:
+		b, ok := b.([]any)
+		if !ok {
+			divergent("a list in the first run, but %s", describe(b))
+			return
+		}
+		if len(a) != len(b) {
+			divergent("%d items in the first run, but %d", len(a), len(b))
+			return
+		}
+		for i := range a {
+			compare(a[i], b[i], fmt.Sprintf("%s[%d]", path, i), options, add)
+		}
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok {
+			divergent("an object in the first run, but %s", describe(b))
+			return
+		}
+		keys := map[string]bool{}
+		for k := range a {
+			keys[k] = true
+		}
+		for k := range b {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			av, aok := a[k]
+			bv, bok := b[k]
+			switch {
+			case !aok:
+				add(Difference{Path: path + "." + k, Status: StatusDivergent, Message: "missing in the first run"})
+			case !bok:
+				add(Difference{Path: path + "." + k, Status: StatusDivergent, Message: "missing"})
+			default:
+				compare(av, bv, path+"."+k, options, add)
+			}
+		}
+	default:
+		if Hash(a) != Hash(b) {
+			divergent("%s in the first run, but %s", describe(a), describe(b))
+		}
+	}
+}
+
+func compareStrings(a string, b string, options Options, equivalent func(string, ...any), divergent func(string, ...any)) {
+	aURL, aErr := dataurl.DecodeString(a)
+	bURL, bErr := dataurl.DecodeString(b)
+	if aErr != nil || bErr != nil || !strings.HasPrefix(a, "data:") || !strings.HasPrefix(b, "data:") {
+		divergent("%s and %s differ", truncate(a), truncate(b))
+		return
+	}
+	aHash, aIsImage := imageHash(aURL.Data)
+	bHash, bIsImage := imageHash(bURL.Data)
+	if !aIsImage || !bIsImage {
+		divergent("files differ: %s and %s", fileHash(aURL.Data), fileHash(bURL.Data))
+		return
+	}
+	distance := hammingDistance(aHash, bHash)
+	if distance <= options.MaxImageDistance {
+		equivalent("images differ, but look the same, with perceptual hashes %d/64 bits apart", distance)
+	} else {
+		divergent("images look different, with perceptual hashes %d/64 bits apart", distance)
+	}
+}
+
+func describe(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case float64:
+		return "a number"
+	case string:
+		return "a string"
+	case bool:
+		return fmt.Sprintf("%v", v)
+	case []any:
+		return "a list"
+	case map[string]any:
+		return "an object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func truncate(s string) string {
+	const limit = 40
+	if len(s) > limit {
+		return fmt.Sprintf("%q...", s[:limit])
+	}
+	return fmt.Sprintf("%q", s)
+}
@@ -0,0 +1,152 @@
+package determinism
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vincent-petithory/dataurl"
+)
+
+var options = Options{Tolerance: 1e-6, MaxImageDistance: DefaultMaxImageDistance}
+
+func TestCompareIdentical(t *testing.T) {
+	outputs := []any{
+		map[string]any{"text": "hello", "scores": []any{0.1, 0.2}},
+		map[string]any{"scores": []any{0.1, 0.2}, "text": "hello"},
+	}
+	report := Compare(outputs, options)
+	require.Len(t, report.Runs, 2)
+	require.Equal(t, report.Runs[0].Hash, report.Runs[1].Hash)
+	require.Equal(t, StatusIdentical, report.Status())
+	require.Equal(t, 0, report.Diverged())
+}
+
+func TestCompareNumbers(t *testing.T) {
+	report := Compare([]any{
+		[]any{1.0, 2.0},
+		[]any{1.0, 2.0000001},
+		[]any{1.5, 2.0},
+	}, options)
+	require.Equal(t, StatusIdentical, report.Runs[0].Status)
+
+	require.Equal(t, StatusEquivalent, report.Runs[1].Status)
+	require.Len(t, report.Runs[1].Differences, 1)
+	require.Equal(t, "output[1]", report.Runs[1].Differences[0].Path)
+
+	require.Equal(t, StatusDivergent, report.Runs[2].Status)
+	require.Equal(t, "output[0]", report.Runs[2].Differences[0].Path)
+	require.Equal(t, "1 and 1.5 differ by 0.5", report.Runs[2].Differences[0].Message)
+
+	require.Equal(t, StatusDivergent, report.Status())
+	require.Equal(t, 1, report.Diverged())
+}
+
+func TestCompareStructure(t *testing.T) {
+	report := Compare([]any{
+		map[string]any{"a": "x", "b": []any{1.0}},
+		map[string]any{"a": "y", "c": true, "b": []any{1.0, 2.0}},
+	}, options)
+	run := report.Runs[1]
+	require.Equal(t, StatusDivergent, run.Status)
+	paths := []string{}
+	for _, d := range run.Differences {
+		paths = append(paths, d.Path)
+	}
+	require.Equal(t, []string{"output.a", "output.b", "output.c"}, paths)
+}
+
+func TestCompareFiles(t *testing.T) {
+	a := dataurl.New([]byte("hello"), "text/plain").String()
+	b := dataurl.New([]byte("world"), "text/plain").String()
+	report := Compare([]any{a, b}, options)
+	require.Equal(t, StatusDivergent, report.Status())
+	require.Contains(t, report.Runs[1].Differences[0].Message, "files differ")
+}
+
+func TestCompareImages(t *testing.T) {
+	gradient := testImage(t, 0)
+	noisy := testImage(t, 1)
+	inverted := testImage(t, -1)
+
+	report := Compare([]any{gradient, noisy, inverted}, options)
+	require.Equal(t, StatusEquivalent, report.Runs[1].Status)
+	require.Contains(t, report.Runs[1].Differences[0].Message, "look the same")
+	require.Equal(t, StatusDivergent, report.Runs[2].Status)
+	require.Contains(t, report.Runs[2].Differences[0].Message, "look different")
+}
+
+func TestPerceptualHash(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 90, 80))
+	for x := 0; x < 90; x++ {
+		for y := 0; y < 80; y++ {
+			// Darker to the right, so every pixel is brighter than the one to its right
+			img.SetGray(x, y, color.Gray{Y: uint8(255 - x*2)})
+		}
+	}
+	require.Equal(t, ^uint64(0), perceptualHash(img))
+	require.Equal(t, 0, hammingDistance(perceptualHash(img), perceptualHash(img)))
+}
+
+// testImage returns a data URL of a horizontal gradient. If noise is 1, some pixels are off by one, and if it's -1,
+// the gradient goes the other way.
+func testImage(t *testing.T, noise int) string {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, 64, 64))
+	for x := 0; x < 64; x++ {
+		for y := 0; y < 64; y++ {
+			v := x * 4
+			if noise < 0 {
+				v = 255 - v
+			} else if noise > 0 && (x+y)%7 == 0 {
+				v++
+			}
+			img.SetGray(x, y, color.Gray{Y: uint8(v)})
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return dataurl.New(buf.Bytes(), "image/png").String()
+}
+
+func TestComparators(t *testing.T) {
+	a := map[string]any{
+		"scores":  []any{0.5, 0.25},
+		"seconds": 1.2,
+		"items":   []any{map[string]any{"label": "cat", "score": 0.9}},
+	}
+	b := map[string]any{
+		"scores":  []any{0.51, 0.25},
+		"seconds": 3.4,
+		"items":   []any{map[string]any{"label": "cat", "score": 0.9000001}},
+	}
+
+	status, differences := Diff(a, b, options)
+	require.Equal(t, StatusDivergent, status)
+	require.Len(t, differences, 3)
+
+	status, differences = Diff(a, b, Options{
+		Tolerance:        1e-6,
+		MaxImageDistance: DefaultMaxImageDistance,
+		Comparators: []Comparator{
+			{Path: "output.scores", Type: ComparatorNumeric, Tolerance: 0.1},
+			{Path: "output.seconds", Type: ComparatorIgnore},
+			{Path: "output.items[*].score", Type: ComparatorExact},
+		},
+	})
+	require.Equal(t, StatusDivergent, status)
+	require.Len(t, differences, 2)
+	require.Equal(t, "output.items[0].score", differences[0].Path)
+	require.Equal(t, StatusDivergent, differences[0].Status)
+	require.Equal(t, "output.scores[0]", differences[1].Path)
+	require.Equal(t, StatusEquivalent, differences[1].Status)
+}
+
+func TestValidateComparators(t *testing.T) {
+	require.NoError(t, Options{Comparators: []Comparator{{Path: "output.*", Type: ComparatorIgnore}}}.Validate())
+	require.Error(t, Options{Comparators: []Comparator{{Path: "output", Type: "fuzzy"}}}.Validate())
+	require.Error(t, Options{Comparators: []Comparator{{Path: "scores", Type: ComparatorExact}}}.Validate())
+}
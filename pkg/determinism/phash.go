@@ -0,0 +1,66 @@
+package determinism
+
+import (
+	"bytes"
+	"image"
+	"math/bits"
+
+	// Image formats the perceptual hash can decode
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// imageHash returns the perceptual hash of an image file, and false if it isn't an image Go can decode
+func imageHash(data []byte) (uint64, bool) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, false
+	}
+	return perceptualHash(img), true
+}
+
+// perceptualHash is a difference hash of img: it's shrunk to 9x8 greyscale pixels, and each bit is whether a pixel
+// is brighter than the one to its right. Images that look the same have hashes that are a few bits apart, even if
+// their pixels differ slightly, like from nondeterministic GPU kernels or JPEG encoding.
+func perceptualHash(img image.Image) uint64 {
+	const width, height = 9, 8
+	bounds := img.Bounds()
+	var grey [height][width]float64
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*bounds.Dy()/height)
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := max(x0+1, bounds.Min.X+(x+1)*bounds.Dx()/width)
+			// Sample up to 16x16 pixels of each cell, so large images are quick to hash
+			stepX := max(1, (x1-x0)/16)
+			stepY := max(1, (y1-y0)/16)
+			var sum float64
+			n := 0
+			for sy := y0; sy < y1; sy += stepY {
+				for sx := x0; sx < x1; sx += stepX {
+					r, g, b, _ := img.At(sx, sy).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+					n++
+				}
+			}
+			grey[y][x] = sum / float64(n)
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < height; y++ {
+		for x := 0; x < width-1; x++ {
+			hash <<= 1
+			if grey[y][x] > grey[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+func hammingDistance(a uint64, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
@@ -0,0 +1,112 @@
+package devcontainer
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/replicate/cog/pkg/config"
+)
+
+// Dir is the directory in the project that editors look for devcontainer.json in
+const Dir = ".devcontainer"
+
+// PythonInterpreterPath is the Python that models run with. Images built by Cog link it to the Python version in
+// cog.yaml.
+const PythonInterpreterPath = "/usr/bin/python3"
+
+// workspaceFolder is where the project is mounted, like with `cog run`
+const workspaceFolder = "/src"
+
+// DevContainer is the subset of the Development Container specification (https://containers.dev) that Cog generates
+type DevContainer struct {
+	Name             string            `json:"name"`
+	Image            string            `json:"image"`
+	WorkspaceMount   string            `json:"workspaceMount"`
+	WorkspaceFolder  string            `json:"workspaceFolder"`
+	Mounts           []string          `json:"mounts,omitempty"`
+	RunArgs          []string          `json:"runArgs,omitempty"`
+	HostRequirements *HostRequirements `json:"hostRequirements,omitempty"`
+	ForwardPorts     []int             `json:"forwardPorts,omitempty"`
+	Customizations   Customizations    `json:"customizations"`
+}
+
+type HostRequirements struct {
+	GPU bool `json:"gpu"`
+}
+
+type Customizations struct {
+	VSCode VSCode `json:"vscode"`
+}
+
+type VSCode struct {
+	Settings   map[string]any `json:"settings,omitempty"`
+	Extensions []string       `json:"extensions,omitempty"`
+}
+
+// Generate returns the devcontainer.json for developing the model in projectDir inside image. The project is
+// mounted at /src, build contexts where they're copied to in the image, and GPUs are passed through if the model
+// uses them.
+func Generate(cfg *config.Config, projectDir string, image string) ([]byte, error) {
+	devContainer := DevContainer{
+		Name:            filepath.Base(projectDir),
+		Image:           image,
+		WorkspaceMount:  bindMount("${localWorkspaceFolder}", workspaceFolder),
+		WorkspaceFolder: workspaceFolder,
+		// The same shared memory as `cog run`, https://github.com/pytorch/pytorch/issues/2244
+		RunArgs:      []string{"--shm-size=6G"},
+		ForwardPorts: []int{5000},
+	}
+
+	for _, name := range cfg.Build.BuildContextNames() {
+		context := cfg.Build.Contexts[name]
+		source, err := context.AbsPath(projectDir)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to resolve build context %s: %w", name, err)
+		}
+		devContainer.Mounts = append(devContainer.Mounts, bindMount(workspacePath(projectDir, source), context.TargetPath(name)))
+	}
+
+	if cfg.Build.GPU {
+		devContainer.RunArgs = append(devContainer.RunArgs, "--gpus=all")
+		devContainer.HostRequirements = &HostRequirements{GPU: true}
+	}
+
+	switch cfg.PredictorLanguage() {
+	case config.LanguagePython:
+		devContainer.Customizations.VSCode = VSCode{
+			Settings: map[string]any{
+				"python.defaultInterpreterPath": PythonInterpreterPath,
+			},
+			Extensions: []string{"ms-python.python"},
+		}
+	case config.LanguageNode:
+		devContainer.Customizations.VSCode = VSCode{Extensions: []string{"dbaeumer.vscode-eslint"}}
+	case config.LanguageR:
+		devContainer.Customizations.VSCode = VSCode{Extensions: []string{"REditorSupport.r"}}
+	}
+
+	data, err := json.MarshalIndent(devContainer, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func bindMount(source string, target string) string {
+	return "source=" + source + ",target=" + target + ",type=bind"
+}
+
+// workspacePath returns source relative to ${localWorkspaceFolder} if it's in the project, so the configuration
+// works wherever the project is checked out
+func workspacePath(projectDir string, source string) string {
+	rel, err := filepath.Rel(projectDir, source)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return source
+	}
+	if rel == "." {
+		return "${localWorkspaceFolder}"
+	}
+	return "${localWorkspaceFolder}/" + filepath.ToSlash(rel)
+}
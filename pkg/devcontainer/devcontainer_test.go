@@ -0,0 +1,63 @@
+package devcontainer
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/config"
+)
+
+func TestGenerate(t *testing.T) {
+	projectDir := filepath.Join(t.TempDir(), "hotdog-detector")
+	cfg, err := config.FromYAML([]byte(`
+build:
+  gpu: true
+  python_version: "3.12"
+  contexts:
+    shared:
+      path: ../shared
+    data:
+      path: data
+      target: /data
+predict: predict.py:Predictor
+`))
+	require.NoError(t, err)
+
+	data, err := Generate(cfg, projectDir, "cog-hotdog-detector-base")
+	require.NoError(t, err)
+	var devContainer DevContainer
+	require.NoError(t, json.Unmarshal(data, &devContainer))
+
+	require.Equal(t, "hotdog-detector", devContainer.Name)
+	require.Equal(t, "cog-hotdog-detector-base", devContainer.Image)
+	require.Equal(t, "source=${localWorkspaceFolder},target=/src,type=bind", devContainer.WorkspaceMount)
+	require.Equal(t, "/src", devContainer.WorkspaceFolder)
+	require.Equal(t, []string{
+		"source=${localWorkspaceFolder}/data,target=/data,type=bind",
+		"source=" + filepath.Join(filepath.Dir(projectDir), "shared") + ",target=/src/shared,type=bind",
+	}, devContainer.Mounts)
+	require.Equal(t, []string{"--shm-size=6G", "--gpus=all"}, devContainer.RunArgs)
+	require.True(t, devContainer.HostRequirements.GPU)
+	require.Equal(t, PythonInterpreterPath, devContainer.Customizations.VSCode.Settings["python.defaultInterpreterPath"])
+}
+
+func TestGenerateWithoutGPU(t *testing.T) {
+	cfg, err := config.FromYAML([]byte(`
+build:
+  python_version: "3.12"
+predict: predict.ts:Predictor
+`))
+	require.NoError(t, err)
+
+	data, err := Generate(cfg, t.TempDir(), "cog-model-base")
+	require.NoError(t, err)
+	var devContainer DevContainer
+	require.NoError(t, json.Unmarshal(data, &devContainer))
+
+	require.Nil(t, devContainer.HostRequirements)
+	require.Equal(t, []string{"--shm-size=6G"}, devContainer.RunArgs)
+	require.Empty(t, devContainer.Customizations.VSCode.Settings)
+}
@@ -0,0 +1,197 @@
+// Package diagnostics collects what's needed to work out why a model's container died, like running out of memory
+// or a segfault, into a bundle in .cog/diagnostics.
+package diagnostics
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/dockercontext"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// LogLines is how many of the last lines of the container's logs are collected
+const LogLines = 200
+
+// oomPattern matches the kernel's log lines about the OOM killer
+var oomPattern = regexp.MustCompile(`(?i)out of memory|oom-kill|oom_reaper|killed process|memory cgroup`)
+
+// Crash is why a container exited
+type Crash struct {
+	ContainerID string `json:"container_id"`
+	Image       string `json:"image"`
+	Reason      string `json:"reason"`
+	ExitCode    int    `json:"exit_code"`
+	OOMKilled   bool   `json:"oom_killed"`
+	Error       string `json:"error,omitempty"`
+	StartedAt   string `json:"started_at"`
+	FinishedAt  string `json:"finished_at"`
+}
+
+// Inspect returns why a container exited, and false if it's still running
+func Inspect(containerID string) (*Crash, bool, error) {
+	container, err := docker.ContainerInspect(containerID)
+	if err != nil {
+		return nil, false, fmt.Errorf("Failed to inspect container %s: %w", containerID, err)
+	}
+	state := container.State
+	if state == nil || (state.Status != "exited" && state.Status != "dead") {
+		return nil, false, nil
+	}
+	crash := &Crash{
+		ContainerID: containerID,
+		Reason:      ExitReason(state.ExitCode, state.OOMKilled),
+		ExitCode:    state.ExitCode,
+		OOMKilled:   state.OOMKilled,
+		Error:       state.Error,
+		StartedAt:   state.StartedAt,
+		FinishedAt:  state.FinishedAt,
+	}
+	if container.Config != nil {
+		crash.Image = container.Config.Image
+	}
+	return crash, true, nil
+}
+
+// ExitReason describes why a container exited with exitCode
+func ExitReason(exitCode int, oomKilled bool) string {
+	if oomKilled {
+		return "it ran out of memory and was killed"
+	}
+	// Docker exits with 128 plus the signal that killed the process
+	switch exitCode {
+	case 0:
+		return "it exited"
+	case 134:
+		return "it aborted (SIGABRT)"
+	case 135:
+		return "it had a bus error (SIGBUS)"
+	case 136:
+		return "it had a floating point exception (SIGFPE)"
+	case 137:
+		return "it was killed (SIGKILL), maybe because it ran out of memory"
+	case 139:
+		return "it had a segmentation fault (SIGSEGV)"
+	case 143:
+		return "it was terminated (SIGTERM)"
+	}
+	if exitCode > 128 && exitCode < 128+65 {
+		return fmt.Sprintf("it was killed by signal %d", exitCode-128)
+	}
+	return fmt.Sprintf("it exited with status %d", exitCode)
+}
+
+// Collect writes a bundle of diagnostics for the crash to .cog/diagnostics/<timestamp>.tar.gz in dir, and returns
+// its path. It has why the container exited, the last LogLines lines of its logs, the kernel's OOM messages and the
+// state of the GPUs. Anything that can't be collected, like dmesg without permission, says why in the bundle.
+func Collect(crash *Crash, dir string) (string, error) {
+	files := map[string][]byte{}
+
+	crashJSON, err := json.MarshalIndent(crash, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	files["crash.json"] = crashJSON
+
+	if container, err := docker.ContainerInspect(crash.ContainerID); err == nil {
+		if inspectJSON, err := json.MarshalIndent(container, "", "  "); err == nil {
+			files["inspect.json"] = inspectJSON
+		}
+	}
+	files["logs.txt"] = collect(func() ([]byte, error) {
+		return docker.ContainerLogsTail(crash.ContainerID, LogLines)
+	})
+	files["dmesg-oom.txt"] = collect(func() ([]byte, error) {
+		out, err := exec.Command("dmesg").Output()
+		if err != nil {
+			return nil, err
+		}
+		return FilterOOMLines(out), nil
+	})
+	files["gpu.txt"] = collect(func() ([]byte, error) {
+		if _, err := exec.LookPath("nvidia-smi"); err != nil {
+			return []byte("nvidia-smi isn't installed\n"), nil
+		}
+		return exec.Command("nvidia-smi").CombinedOutput()
+	})
+
+	bundleDir := filepath.Join(dir, dockercontext.CogBuildArtifactsFolder, "diagnostics")
+	if err := os.MkdirAll(bundleDir, 0o755); err != nil {
+		return "", fmt.Errorf("Failed to create diagnostics directory: %w", err)
+	}
+	path := filepath.Join(bundleDir, time.Now().UTC().Format("20060102T150405Z")+".tar.gz")
+	if err := writeBundle(path, files); err != nil {
+		return "", fmt.Errorf("Failed to write diagnostics: %w", err)
+	}
+	return path, nil
+}
+
+// collect returns what get returns, or why it failed
+func collect(get func() ([]byte, error)) []byte {
+	out, err := get()
+	if err != nil {
+		console.Debugf("Failed to collect diagnostics: %s", err)
+		return []byte(fmt.Sprintf("Failed to collect: %s\n%s", err, out))
+	}
+	return out
+}
+
+// FilterOOMLines returns the lines of the kernel log about the OOM killer
+func FilterOOMLines(dmesg []byte) []byte {
+	var buf bytes.Buffer
+	for _, line := range strings.Split(string(dmesg), "\n") {
+		if oomPattern.MatchString(line) {
+			buf.WriteString(line)
+			buf.WriteString("\n")
+		}
+	}
+	if buf.Len() == 0 {
+		return []byte("No OOM killer messages\n")
+	}
+	return buf.Bytes()
+}
+
+func writeBundle(path string, files map[string][]byte) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	now := time.Now()
+	for _, name := range names {
+		header := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(files[name])), ModTime: now}
+		if err = tw.WriteHeader(header); err != nil {
+			break
+		}
+		if _, err = tw.Write(files[name]); err != nil {
+			break
+		}
+	}
+	for _, closer := range []interface{ Close() error }{tw, gz, out} {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		_ = os.Remove(path)
+	}
+	return err
+}
@@ -0,0 +1,63 @@
+package diagnostics
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExitReason(t *testing.T) {
+	require.Equal(t, "it ran out of memory and was killed", ExitReason(137, true))
+	require.Equal(t, "it had a segmentation fault (SIGSEGV)", ExitReason(139, false))
+	require.Equal(t, "it was killed (SIGKILL), maybe because it ran out of memory", ExitReason(137, false))
+	require.Equal(t, "it was killed by signal 4", ExitReason(132, false))
+	require.Equal(t, "it exited with status 1", ExitReason(1, false))
+}
+
+func TestFilterOOMLines(t *testing.T) {
+	dmesg := []byte(`[  10.1] usb 1-1: new device
+[ 500.2] python invoked oom-killer: gfp_mask=0xcc0(GFP_KERNEL), order=0, oom_score_adj=0
+[ 500.3] Memory cgroup out of memory: Killed process 1234 (python) total-vm:123kB
+[ 501.0] eth0: link up
+`)
+	require.Equal(t, `[ 500.2] python invoked oom-killer: gfp_mask=0xcc0(GFP_KERNEL), order=0, oom_score_adj=0
+[ 500.3] Memory cgroup out of memory: Killed process 1234 (python) total-vm:123kB
+`, string(FilterOOMLines(dmesg)))
+	require.Equal(t, "No OOM killer messages\n", string(FilterOOMLines([]byte("[ 1.0] booted\n"))))
+}
+
+func TestWriteBundle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	require.NoError(t, writeBundle(path, map[string][]byte{
+		"logs.txt":   []byte("Traceback\n"),
+		"crash.json": []byte(`{"reason": "it had a segmentation fault (SIGSEGV)"}`),
+	}))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	tr := tar.NewReader(gz)
+
+	contents := map[string]string{}
+	names := []string{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		names = append(names, header.Name)
+		contents[header.Name] = string(data)
+	}
+	require.Equal(t, []string{"crash.json", "logs.txt"}, names)
+	require.Equal(t, "Traceback\n", contents["logs.txt"])
+}
@@ -5,28 +5,38 @@ import (
 	"os"
 	"os/exec"
 	"runtime"
+	"sort"
 	"strings"
 
 	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/dockercontext"
 
 	"github.com/replicate/cog/pkg/util"
 	"github.com/replicate/cog/pkg/util/console"
 )
 
-func Build(dir, dockerfileContents, imageName string, secrets []string, noCache bool, progressOutput string, epoch int64, contextDir string, buildContexts map[string]string) error {
-	var args []string
+func Build(dir, dockerfileContents, imageName string, secrets []string, ssh []string, noCache bool, progressOutput string, epoch int64, contextDir string, buildContexts map[string]string) error {
+	return BuildTarget(dir, dockerfileContents, imageName, "", secrets, ssh, noCache, progressOutput, epoch, contextDir, buildContexts)
+}
 
-	args = append(args, "buildx", "build")
+// BuildTarget builds the stage of a multi-stage Dockerfile named target, like `docker build --target`. The whole
+// Dockerfile is built if target is empty.
+func BuildTarget(dir, dockerfileContents, imageName string, target string, secrets []string, ssh []string, noCache bool, progressOutput string, epoch int64, contextDir string, buildContexts map[string]string) error {
+	args := buildCommand()
 
 	if util.IsAppleSiliconMac(runtime.GOOS, runtime.GOARCH) {
 		// Fixes "WARNING: The requested image's platform (linux/amd64) does not match the detected host platform (linux/arm64/v8) and no specific platform was requested"
-		args = append(args, "--platform", "linux/amd64", "--load")
+		args = append(args, platformArgs()...)
 	}
 
 	for _, secret := range secrets {
 		args = append(args, "--secret", secret)
 	}
 
+	for _, s := range ssh {
+		args = append(args, "--ssh", s)
+	}
+
 	if noCache {
 		args = append(args, "--no-cache")
 	}
@@ -35,11 +45,14 @@ func Build(dir, dockerfileContents, imageName string, secrets []string, noCache
 	// format. It's generally safe to override to --output type=docker,rewrite-timestamp=true as the use of `--load` is
 	// equivalent to `--output type=docker`
 	if epoch >= 0 {
-		args = append(args,
-			"--build-arg", fmt.Sprintf("SOURCE_DATE_EPOCH=%d", epoch),
-			"--output", "type=docker,rewrite-timestamp=true")
-		console.Infof("Forcing timestamp rewriting to epoch %d", epoch)
-
+		args = append(args, "--build-arg", fmt.Sprintf("SOURCE_DATE_EPOCH=%d", epoch))
+		if IsNerdctl() {
+			// nerdctl loads the image into containerd itself, so it can't be given a docker output
+			console.Infof("Setting SOURCE_DATE_EPOCH to %d", epoch)
+		} else {
+			args = append(args, "--output", "type=docker,rewrite-timestamp=true")
+			console.Infof("Forcing timestamp rewriting to epoch %d", epoch)
+		}
 	}
 
 	if config.BuildXCachePath != "" {
@@ -51,11 +64,18 @@ func Build(dir, dockerfileContents, imageName string, secrets []string, noCache
 	} else {
 		args = append(args, "--cache-to", "type=inline")
 	}
+	for _, cacheFrom := range config.BuildXCacheFrom {
+		args = append(args, "--cache-from", cacheFrom)
+	}
 
 	for name, dir := range buildContexts {
 		args = append(args, "--build-context", name+"="+dir)
 	}
 
+	if target != "" {
+		args = append(args, "--target", target)
+	}
+
 	args = append(args,
 		"--file", "-",
 		"--tag", imageName,
@@ -63,10 +83,10 @@ func Build(dir, dockerfileContents, imageName string, secrets []string, noCache
 		contextDir,
 	)
 
-	cmd := exec.Command("docker", args...)
+	cmd := exec.Command(DockerCommandFromEnvironment(), args...)
 	cmd.Dir = dir
-	cmd.Stdout = os.Stderr // redirect stdout to stderr - build output is all messaging
-	cmd.Stderr = os.Stderr
+	cmd.Stdout = console.Writer() // redirect stdout to the console - build output is all messaging
+	cmd.Stderr = console.Writer()
 	cmd.Stdin = strings.NewReader(dockerfileContents)
 
 	console.Debug("$ " + strings.Join(cmd.Args, " "))
@@ -74,15 +94,11 @@ func Build(dir, dockerfileContents, imageName string, secrets []string, noCache
 }
 
 func BuildAddLabelsAndSchemaToImage(image string, labels map[string]string, bundledSchemaFile string, bundledSchemaPy string) error {
-	var args []string
-
-	args = append(args,
-		"buildx", "build",
-	)
+	args := buildCommand()
 
 	if util.IsAppleSiliconMac(runtime.GOOS, runtime.GOARCH) {
 		// Fixes "WARNING: The requested image's platform (linux/amd64) does not match the detected host platform (linux/arm64/v8) and no specific platform was requested"
-		args = append(args, "--platform", "linux/amd64", "--load")
+		args = append(args, platformArgs()...)
 	}
 
 	args = append(args,
@@ -96,7 +112,7 @@ func BuildAddLabelsAndSchemaToImage(image string, labels map[string]string, bund
 	}
 	// We're not using context, but Docker requires we pass a context
 	args = append(args, ".")
-	cmd := exec.Command("docker", args...)
+	cmd := exec.Command(DockerCommandFromEnvironment(), args...)
 
 	dockerfile := "FROM " + image + "\n"
 	dockerfile += "COPY " + bundledSchemaFile + " .cog\n"
@@ -110,3 +126,80 @@ func BuildAddLabelsAndSchemaToImage(image string, labels map[string]string, bund
 	}
 	return nil
 }
+
+// BuildAddBakedFilesToImage adds files from contextDir to the image, on top of its existing layers. files maps paths in
+// contextDir to where they go in the image.
+func BuildAddBakedFilesToImage(image string, contextDir string, files map[string]string) error {
+	args := buildCommand()
+
+	if util.IsAppleSiliconMac(runtime.GOOS, runtime.GOARCH) {
+		// Fixes "WARNING: The requested image's platform (linux/amd64) does not match the detected host platform (linux/arm64/v8) and no specific platform was requested"
+		args = append(args, platformArgs()...)
+	}
+
+	args = append(args,
+		"--file", "-",
+		"--tag", image,
+		contextDir,
+	)
+	cmd := exec.Command(DockerCommandFromEnvironment(), args...)
+	cmd.Stdin = strings.NewReader(bakedFilesDockerfile(image, files))
+
+	console.Debug("$ " + strings.Join(cmd.Args, " "))
+
+	if combinedOutput, err := cmd.CombinedOutput(); err != nil {
+		console.Info(string(combinedOutput))
+		return err
+	}
+	return nil
+}
+
+func bakedFilesDockerfile(image string, files map[string]string) string {
+	sources := make([]string, 0, len(files))
+	for src := range files {
+		sources = append(sources, src)
+	}
+	sort.Strings(sources)
+
+	dockerfile := "FROM " + image + "\n"
+	for _, src := range sources {
+		dockerfile += fmt.Sprintf("COPY [%q, %q]\n", src, files[src])
+	}
+	return dockerfile
+}
+
+// BuildAddLabelsToImage sets labels on an existing image, replacing any that are already set
+func BuildAddLabelsToImage(image string, labels map[string]string) error {
+	// Nothing is copied into the image, so the context is an empty directory
+	contextDir, err := dockercontext.MkdirTemp("cog-labels-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(contextDir)
+
+	args := buildCommand()
+
+	if util.IsAppleSiliconMac(runtime.GOOS, runtime.GOARCH) {
+		// Fixes "WARNING: The requested image's platform (linux/amd64) does not match the detected host platform (linux/arm64/v8) and no specific platform was requested"
+		args = append(args, platformArgs()...)
+	}
+
+	args = append(args,
+		"--file", "-",
+		"--tag", image,
+	)
+	for k, v := range labels {
+		args = append(args, "--label", fmt.Sprintf(`%s=%s`, k, v))
+	}
+	args = append(args, contextDir)
+	cmd := exec.Command(DockerCommandFromEnvironment(), args...)
+	cmd.Stdin = strings.NewReader("FROM " + image + "\n")
+
+	console.Debug("$ " + strings.Join(cmd.Args, " "))
+
+	if combinedOutput, err := cmd.CombinedOutput(); err != nil {
+		console.Info(string(combinedOutput))
+		return err
+	}
+	return nil
+}
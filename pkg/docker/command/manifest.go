@@ -21,3 +21,29 @@ var CogConfigLabelKey = global.LabelNamespace + "config"
 var CogVersionLabelKey = global.LabelNamespace + "version"
 var CogOpenAPISchemaLabelKey = global.LabelNamespace + "openapi_schema"
 var CogWeightsManifestLabelKey = global.LabelNamespace + "r8_weights_manifest"
+var CogBaseImageNameLabelKey = global.LabelNamespace + "cog-base-image-name"
+var CogBaseImageSourceNameLabelKey = global.LabelNamespace + "cog-base-image-source-name"
+var CogBaseImageLastLayerSHALabelKey = global.LabelNamespace + "cog-base-image-last-layer-sha"
+var CogBaseImageLastLayerIndexLabelKey = global.LabelNamespace + "cog-base-image-last-layer-idx"
+var CogSecurityLabelKey = global.LabelNamespace + "security"
+
+// CogPipFreezeLabelKey is the output of pip freeze in the image, so the Python packages of two images can be compared
+var CogPipFreezeLabelKey = global.LabelNamespace + "pip_freeze"
+
+// CogStatefulLabelKey marks images of models that keep state between predictions in sessions, so routers know to send
+// every step of a session to the same instance
+var CogStatefulLabelKey = global.LabelNamespace + "stateful"
+
+// CogMemoryLabelKey and CogGPUMemoryLabelKey are the memory and memory per GPU, in bytes, `cog measure` suggests
+// giving the model, so schedulers can place it on a machine it won't run out of memory on
+var CogMemoryLabelKey = global.LabelNamespace + "memory"
+var CogGPUMemoryLabelKey = global.LabelNamespace + "gpu_memory"
+
+// CogSetupTimeLabelKey and CogSetupPhasesLabelKey are how long setup() took when `cog measure` ran it, in seconds, and
+// the JSON breakdown of its phases, so cold-start time can be compared across versions of a model
+var CogSetupTimeLabelKey = global.LabelNamespace + "setup_time"
+var CogSetupPhasesLabelKey = global.LabelNamespace + "setup_phases"
+
+// CogContainerLabelKey is set on the containers Cog starts to run a model, to what started them, like predict,
+// train, serve or run, so `cog logs` and `cog ps` can find them
+var CogContainerLabelKey = global.LabelNamespace + "container"
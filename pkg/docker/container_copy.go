@@ -0,0 +1,38 @@
+package docker
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+var ErrContainerPathNotFound = errors.New("Path not found in container")
+
+// CopyFromContainer copies a file or directory out of a container, like `docker cp`. If dest doesn't exist,
+// it's created with the contents of src.
+func CopyFromContainer(id string, src string, dest string) error {
+	cmd := exec.Command(DockerCommandFromEnvironment(), "container", "cp", id+":"+src, dest)
+	cmd.Env = os.Environ()
+	console.Debug("$ " + strings.Join(cmd.Args, " "))
+
+	if combinedOutput, err := cmd.CombinedOutput(); err != nil {
+		if strings.Contains(string(combinedOutput), "Could not find the file") {
+			return ErrContainerPathNotFound
+		}
+		console.Info(string(combinedOutput))
+		return err
+	}
+	return nil
+}
+
+func RemoveContainer(id string) error {
+	cmd := exec.Command(DockerCommandFromEnvironment(), "container", "rm", "--force", id)
+	cmd.Env = os.Environ()
+	cmd.Stderr = console.Writer()
+
+	_, err := cmd.Output()
+	return err
+}
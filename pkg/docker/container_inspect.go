@@ -10,7 +10,7 @@ import (
 )
 
 func ContainerInspect(id string) (*types.ContainerJSON, error) {
-	cmd := exec.Command("docker", "container", "inspect", id)
+	cmd := exec.Command(DockerCommandFromEnvironment(), "container", "inspect", id)
 	cmd.Env = os.Environ()
 
 	out, err := cmd.Output()
@@ -27,3 +27,22 @@ func ContainerInspect(id string) (*types.ContainerJSON, error) {
 	}
 	return &slice[0], nil
 }
+
+// ContainersInspect inspects several containers at once
+func ContainersInspect(ids ...string) ([]types.ContainerJSON, error) {
+	if len(ids) == 0 {
+		return []types.ContainerJSON{}, nil
+	}
+	cmd := exec.Command(DockerCommandFromEnvironment(), append([]string{"container", "inspect"}, ids...)...)
+	cmd.Env = os.Environ()
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	var containers []types.ContainerJSON
+	if err := json.Unmarshal(out, &containers); err != nil {
+		return nil, err
+	}
+	return containers, nil
+}
@@ -0,0 +1,68 @@
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// ContainerSummary is a container, as listed by `docker ps`
+type ContainerSummary struct {
+	ID        string `json:"id"`
+	Image     string `json:"image"`
+	Names     string `json:"names"`
+	CreatedAt string `json:"created_at"`
+	Status    string `json:"status"`
+}
+
+// ContainerList lists the running containers that match all of filters, like `docker ps --filter`, newest first. If
+// all is set, it lists stopped containers too.
+func ContainerList(all bool, filters ...string) ([]ContainerSummary, error) {
+	args := []string{"ps", "--no-trunc", "--format", "{{json .}}"}
+	if all {
+		args = append(args, "--all")
+	}
+	for _, filter := range filters {
+		args = append(args, "--filter", filter)
+	}
+	cmd := exec.Command(DockerCommandFromEnvironment(), args...)
+	cmd.Env = os.Environ()
+	cmd.Stderr = console.Writer()
+	console.Debug("$ " + strings.Join(cmd.Args, " "))
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseContainerList(out)
+}
+
+func parseContainerList(out []byte) ([]ContainerSummary, error) {
+	containers := []ContainerSummary{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	// docker ps prints the labels of each container, which can include long ones like run.cog.openapi_schema
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		// docker ps prints fields in Go's default capitalization
+		var container struct {
+			ID        string
+			Image     string
+			Names     string
+			CreatedAt string
+			Status    string
+		}
+		if err := json.Unmarshal([]byte(line), &container); err != nil {
+			return nil, err
+		}
+		containers = append(containers, ContainerSummary(container))
+	}
+	return containers, scanner.Err()
+}
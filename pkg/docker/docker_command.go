@@ -17,6 +17,7 @@ import (
 	"github.com/docker/cli/cli/config/types"
 
 	"github.com/replicate/cog/pkg/docker/command"
+	"github.com/replicate/cog/pkg/retry"
 	"github.com/replicate/cog/pkg/util"
 	"github.com/replicate/cog/pkg/util/console"
 	"github.com/replicate/cog/pkg/util/slices"
@@ -32,14 +33,15 @@ func NewDockerCommand() *DockerCommand {
 	return &DockerCommand{}
 }
 
+// Pull pulls an image, retrying if it fails with a transient error
 func (c *DockerCommand) Pull(image string) error {
-	_, err := c.exec("pull", false, image, "--platform", "linux/amd64")
-	return err
+	return c.execWithRetry("Pulling "+image, "pull", image, "--platform", "linux/amd64")
 }
 
+// Push pushes an image, retrying if it fails with a transient error. Layers that were pushed before it failed
+// aren't pushed again.
 func (c *DockerCommand) Push(image string) error {
-	_, err := c.exec("push", false, image)
-	return err
+	return c.execWithRetry("Pushing "+image, "push", image)
 }
 
 func (c *DockerCommand) LoadUserInformation(registryHost string) (*command.UserInfo, error) {
@@ -137,22 +139,46 @@ func (c *DockerCommand) exec(name string, capture bool, args ...string) (string,
 	dockerCmd := DockerCommandFromEnvironment()
 	cmd := exec.Command(dockerCmd, cmdArgs...)
 	var out strings.Builder
+	var stderr strings.Builder
 	if !capture {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+		cmd.Stdout = console.Writer()
+		cmd.Stderr = io.MultiWriter(console.Writer(), &stderr)
 	} else {
 		cmd.Stdout = &out
-		cmd.Stderr = &out
+		cmd.Stderr = io.MultiWriter(&out, &stderr)
 	}
 
 	console.Debug("$ " + strings.Join(cmd.Args, " "))
 	err := cmd.Run()
 	if err != nil {
-		return "", err
+		return "", &execError{err: err, stderr: stderr.String()}
 	}
 	return out.String(), nil
 }
 
+// execWithRetry runs a Docker command like exec, retrying it as the retry policy says if it fails with a
+// transient error
+func (c *DockerCommand) execWithRetry(operation string, name string, args ...string) error {
+	return retry.Do(operation, func() error {
+		_, err := c.exec(name, false, args...)
+		var execErr *execError
+		if errors.As(err, &execErr) {
+			return retry.Classify(err, execErr.stderr)
+		}
+		return err
+	})
+}
+
+// execError is a Docker command that failed, with what it printed to stderr so the failure can be told apart
+type execError struct {
+	err    error
+	stderr string
+}
+
+func (e *execError) Error() string { return e.err.Error() }
+
+func (e *execError) Unwrap() error { return e.err }
+
 func loadAuthFromConfig(conf *configfile.ConfigFile, registryHost string) (types.AuthConfig, error) {
 	return conf.AuthConfigs[registryHost], nil
 }
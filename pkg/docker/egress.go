@@ -0,0 +1,130 @@
+package docker
+
+import (
+	"crypto/rand"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+//go:embed egress_proxy.py
+var egressProxyScript string
+
+const (
+	egressProxyPort  = 3128
+	egressProxyAlias = "cog-egress-proxy"
+	egressModelAlias = "model"
+)
+
+// EgressPolicy restricts the hosts a container can connect to. The container is put on an internal network with no
+// route out, and a proxy on that network and Docker's default one publishes its ports and proxies HTTP and HTTPS
+// requests to the allowed hosts.
+type EgressPolicy struct {
+	// Allow are the hosts the container can connect to, like huggingface.co or *.s3.amazonaws.com. It can't connect
+	// to anything if it's empty.
+	Allow []string `json:"allow,omitempty"`
+}
+
+// egressProxy is the network and proxy container for a container with an egress policy
+type egressProxy struct {
+	name string
+}
+
+// egressProxies are the proxies of containers started with RunDaemon, by container ID, so they're removed with the
+// container in Stop and GetPort can find the ports they publish
+var egressProxies sync.Map
+
+// startEgressProxy creates the internal network and proxy for a container with an egress policy, and returns the
+// options to run the container with on that network
+func startEgressProxy(options internalRunOptions, logs io.Writer) (internalRunOptions, *egressProxy, error) {
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		return options, nil, err
+	}
+	proxy := &egressProxy{name: "cog-egress-" + hex.EncodeToString(suffix)}
+
+	if err := dockerQuiet("network", "create", "--internal", proxy.name); err != nil {
+		return options, nil, fmt.Errorf("Failed to create network for egress policy: %w", err)
+	}
+	args := []string{"run", "--detach", "--rm", "--name", proxy.name, "--entrypoint", "python"}
+	for _, port := range options.Ports {
+		args = append(args, "--publish", port.publishArg())
+	}
+	if options.Platform != "" {
+		args = append(args, "--platform", options.Platform)
+	}
+	// The proxy runs in the model's image, so Python is run isolated, ignoring PYTHON* variables, site-packages and
+	// sitecustomize, so nothing the model installed runs in it. The script only uses the standard library.
+	args = append(args, options.Image, "-I", "-S", "-c", egressProxyScript)
+	for _, host := range options.Security.Egress.Allow {
+		args = append(args, "--allow", host)
+	}
+	for _, port := range options.Ports {
+		args = append(args, "--forward", fmt.Sprintf("%d:%s:%d", port.ContainerPort, egressModelAlias, port.ContainerPort))
+	}
+	if err := dockerQuiet(args...); err != nil {
+		proxy.remove()
+		return options, nil, fmt.Errorf("Failed to start egress proxy, which needs Python in the image: %w", err)
+	}
+	if err := dockerQuiet("network", "connect", "--alias", egressProxyAlias, proxy.name, proxy.name); err != nil {
+		proxy.remove()
+		return options, nil, fmt.Errorf("Failed to connect egress proxy: %w", err)
+	}
+	go func() {
+		_ = ContainerLogsFollow(proxy.name, logs)
+	}()
+
+	proxyURL := fmt.Sprintf("http://%s:%d", egressProxyAlias, egressProxyPort)
+	options.Network = proxy.name
+	options.NetworkAlias = egressModelAlias
+	// The proxy publishes the ports instead, because ports on internal networks can't be reached from the host
+	options.Ports = nil
+	options.Env = append(append([]string{}, options.Env...),
+		"HTTP_PROXY="+proxyURL, "HTTPS_PROXY="+proxyURL, "http_proxy="+proxyURL, "https_proxy="+proxyURL,
+		"NO_PROXY=localhost,127.0.0.1", "no_proxy=localhost,127.0.0.1",
+	)
+	return options, proxy, nil
+}
+
+// remove stops the proxy and removes the network
+func (p *egressProxy) remove() {
+	if err := dockerQuiet("container", "rm", "--force", p.name); err != nil {
+		console.Debugf("Failed to remove egress proxy %s: %s", p.name, err)
+	}
+	if err := dockerQuiet("network", "rm", p.name); err != nil {
+		console.Debugf("Failed to remove network %s: %s", p.name, err)
+	}
+}
+
+// removeEgressProxy removes the proxy of a container started with RunDaemon, if it has one
+func removeEgressProxy(containerID string) {
+	if proxy, ok := egressProxies.LoadAndDelete(containerID); ok {
+		proxy.(*egressProxy).remove()
+	}
+}
+
+// portContainer returns the container that publishes containerID's ports, which is its proxy if it has one
+func portContainer(containerID string) string {
+	if proxy, ok := egressProxies.Load(containerID); ok {
+		return proxy.(*egressProxy).name
+	}
+	return containerID
+}
+
+func dockerQuiet(args ...string) error {
+	cmd := exec.Command(DockerCommandFromEnvironment(), args...)
+	cmd.Env = os.Environ()
+	console.Debug("$ " + strings.Join(cmd.Args, " "))
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
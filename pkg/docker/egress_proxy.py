@@ -0,0 +1,139 @@
+"""
+The proxy for a model container with an egress policy. The model container is on
+an internal network with no route out, so this is the only way in or out of it.
+
+It forwards ports published on the host to the model container, and proxies HTTP
+and HTTPS requests from the model to the allowed hosts. Connections to any other
+host are refused and logged.
+"""
+
+import argparse
+import asyncio
+import fnmatch
+import sys
+
+PROXY_PORT = 3128
+
+
+def log(message):
+    print(f"cog-egress-proxy: {message}", file=sys.stderr, flush=True)
+
+
+def allowed(host, patterns):
+    host = host.lower().rstrip(".")
+    for pattern in patterns:
+        pattern = pattern.lower()
+        if host == pattern or (pattern.startswith("*.") and fnmatch.fnmatch(host, pattern)):
+            return True
+    return False
+
+
+async def pipe(reader, writer):
+    try:
+        while data := await reader.read(65536):
+            writer.write(data)
+            await writer.drain()
+    except (ConnectionError, asyncio.CancelledError):
+        pass
+    finally:
+        writer.close()
+
+
+async def splice(reader, writer, target_reader, target_writer):
+    await asyncio.gather(pipe(reader, target_writer), pipe(target_reader, writer))
+
+
+def split_host_port(authority, default_port):
+    if authority.startswith("["):
+        host, _, rest = authority[1:].partition("]")
+        port = rest.lstrip(":")
+    else:
+        host, _, port = authority.rpartition(":") if authority.count(":") == 1 else (authority, "", "")
+    return host, int(port) if port else default_port
+
+
+def close_connection(head):
+    """
+    Rewrites a request's headers so the connection is closed after the response. Otherwise the client could send
+    more requests on it, to other hosts, that wouldn't be checked.
+    """
+    lines = head.rstrip(b"\r\n").split(b"\r\n")
+    lines = [line for line in lines[1:] if line.split(b":", 1)[0].strip().lower() not in (b"connection", b"proxy-connection", b"keep-alive")]
+    return b"\r\n".join([head.split(b"\r\n", 1)[0], *lines, b"Connection: close", b"", b""])
+
+
+async def handle_proxy(reader, writer, patterns):
+    try:
+        head = await reader.readuntil(b"\r\n\r\n")
+    except (asyncio.IncompleteReadError, asyncio.LimitOverrunError):
+        writer.close()
+        return
+    request_line = head.split(b"\r\n", 1)[0].decode("latin-1")
+    try:
+        method, target, _ = request_line.split(" ", 2)
+    except ValueError:
+        writer.close()
+        return
+
+    if method == "CONNECT":
+        host, port = split_host_port(target, 443)
+    else:
+        # Plain HTTP requests through a proxy have an absolute URL
+        if not target.startswith("http://"):
+            writer.write(b"HTTP/1.1 400 Bad Request\r\nContent-Length: 0\r\n\r\n")
+            writer.close()
+            return
+        host, port = split_host_port(target[len("http://") :].split("/", 1)[0], 80)
+
+    if not allowed(host, patterns):
+        log(f"blocked connection to {host}:{port}, which isn't in run.network.allow in cog.yaml")
+        body = f"{host} isn't allowed by the model's network policy\n".encode()
+        writer.write(b"HTTP/1.1 403 Forbidden\r\nContent-Length: %d\r\n\r\n%s" % (len(body), body))
+        writer.close()
+        return
+
+    try:
+        target_reader, target_writer = await asyncio.open_connection(host, port)
+    except OSError as e:
+        writer.write(b"HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\n\r\n")
+        writer.close()
+        log(f"failed to connect to {host}:{port}: {e}")
+        return
+    if method == "CONNECT":
+        writer.write(b"HTTP/1.1 200 Connection Established\r\n\r\n")
+    else:
+        target_writer.write(close_connection(head))
+    await splice(reader, writer, target_reader, target_writer)
+
+
+async def handle_forward(reader, writer, host, port):
+    try:
+        target_reader, target_writer = await asyncio.open_connection(host, port)
+    except OSError:
+        writer.close()
+        return
+    await splice(reader, writer, target_reader, target_writer)
+
+
+async def main():
+    parser = argparse.ArgumentParser()
+    parser.add_argument("--allow", action="append", default=[], help="A host the model can connect to")
+    parser.add_argument("--forward", action="append", default=[], help="LISTEN_PORT:HOST:PORT to forward to the model")
+    parser.add_argument("--port", type=int, default=PROXY_PORT, help="The port to proxy requests on")
+    args = parser.parse_args()
+
+    servers = [
+        await asyncio.start_server(lambda r, w: handle_proxy(r, w, args.allow), "0.0.0.0", args.port),
+    ]
+    for forward in args.forward:
+        listen_port, host, port = forward.split(":")
+        servers.append(
+            await asyncio.start_server(
+                lambda r, w, host=host, port=int(port): handle_forward(r, w, host, port), "0.0.0.0", int(listen_port)
+            )
+        )
+    await asyncio.gather(*(server.serve_forever() for server in servers))
+
+
+if __name__ == "__main__":
+    asyncio.run(main())
@@ -0,0 +1,92 @@
+package docker
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func startTestEgressProxy(t *testing.T, args ...string) int {
+	t.Helper()
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 isn't installed")
+	}
+	port := freePort(t)
+	cmd := exec.Command("python3", append([]string{"-I", "-S", "-c", egressProxyScript, "--port", strconv.Itoa(port)}, args...)...)
+	require.NoError(t, cmd.Start())
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}, 10*time.Second, 50*time.Millisecond)
+	return port
+}
+
+func TestEgressProxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+	port := startTestEgressProxy(t, "--allow", "127.0.0.1")
+	proxyURL, err := url.Parse(fmt.Sprintf("http://127.0.0.1:%d", port))
+	require.NoError(t, err)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "hello", string(body))
+
+	// localhost resolves to the same server, but isn't in the allowlist
+	resp, err = client.Get(fmt.Sprintf("http://localhost:%s/", server.URL[len("http://127.0.0.1:"):]))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestEgressProxyForward(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("prediction"))
+	}))
+	defer server.Close()
+	serverPort := server.Listener.Addr().(*net.TCPAddr).Port
+	listenPort := freePort(t)
+	startTestEgressProxy(t, "--forward", fmt.Sprintf("%d:127.0.0.1:%d", listenPort, serverPort))
+
+	var resp *http.Response
+	require.Eventually(t, func() bool {
+		var err error
+		resp, err = http.Get(fmt.Sprintf("http://127.0.0.1:%d/", listenPort))
+		return err == nil
+	}, 10*time.Second, 50*time.Millisecond)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, "prediction", string(body))
+}
@@ -1,13 +1,130 @@
 package docker
 
-import "os"
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/replicate/cog/pkg/util"
+)
 
 const DockerCommandEnvVarName = "R8_DOCKER_COMMAND"
 
+// DockerCommandFromEnvironment returns the container CLI that Cog runs. It can be set with R8_DOCKER_COMMAND,
+// e.g. to nerdctl or a path to the Docker CLI. Otherwise it's docker, or nerdctl if only nerdctl is installed,
+// like with Rancher Desktop's containerd mode or Colima's containerd runtime.
 func DockerCommandFromEnvironment() string {
 	command := os.Getenv(DockerCommandEnvVarName)
-	if command == "" {
-		command = "docker"
+	if command != "" {
+		return command
+	}
+	if _, err := exec.LookPath("docker"); err != nil {
+		if _, err := exec.LookPath("nerdctl"); err == nil {
+			return "nerdctl"
+		}
+	}
+	return "docker"
+}
+
+// IsNerdctl returns true if the container CLI is nerdctl, which mostly accepts the same commands as the Docker CLI
+// but talks to containerd instead of the Docker daemon
+func IsNerdctl() bool {
+	name := strings.TrimSuffix(filepath.Base(DockerCommandFromEnvironment()), ".exe")
+	return name == "nerdctl"
+}
+
+// buildCommand returns the subcommand that builds images with BuildKit. nerdctl always uses BuildKit, so it
+// doesn't have buildx.
+func buildCommand() []string {
+	if IsNerdctl() {
+		return []string{"build"}
+	}
+	return []string{"buildx", "build"}
+}
+
+// BuildPlatform returns the platform that images are built for: DOCKER_DEFAULT_PLATFORM if it's set, linux/amd64 on
+// Apple Silicon Macs, or the host's platform otherwise, which is returned as an empty string
+func BuildPlatform() string {
+	return buildPlatform(os.Getenv("DOCKER_DEFAULT_PLATFORM"), runtime.GOOS, runtime.GOARCH)
+}
+
+func buildPlatform(defaultPlatform string, goos string, goarch string) string {
+	if defaultPlatform != "" {
+		return defaultPlatform
+	}
+	if util.IsAppleSiliconMac(goos, goarch) {
+		return "linux/amd64"
+	}
+	return ""
+}
+
+// platformArgs returns the arguments to build linux/amd64 images. Docker needs --load to put images built for another
+// platform in the image store, which nerdctl always does.
+func platformArgs() []string {
+	if IsNerdctl() {
+		return []string{"--platform", "linux/amd64"}
+	}
+	return []string{"--platform", "linux/amd64", "--load"}
+}
+
+// isMissingDeviceDriver returns true if a container couldn't start because GPUs aren't available to it
+func isMissingDeviceDriver(stderr string) bool {
+	return strings.Contains(stderr, "could not select device driver") ||
+		strings.Contains(stderr, "nvidia-container-cli: initialization error") ||
+		// nerdctl runs nvidia-container-cli itself, so it fails when the NVIDIA Container Toolkit isn't installed
+		strings.Contains(stderr, `"nvidia-container-cli": executable file not found`)
+}
+
+// IsUnavailable returns true if err is from the container CLI not being installed, or not being able to connect to
+// the Docker daemon or containerd
+func IsUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var execErr *exec.Error
+	if errors.As(err, &execErr) && errors.Is(execErr.Err, exec.ErrNotFound) && execErr.Name == DockerCommandFromEnvironment() {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "Cannot connect to the Docker daemon") ||
+		strings.Contains(msg, "Is the docker daemon running?") ||
+		strings.Contains(msg, "cannot access containerd socket")
+}
+
+// Available returns an error if the container CLI isn't installed or its daemon isn't running
+func Available() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, DockerCommandFromEnvironment(), "info", "--format", "{{.ServerVersion}}")
+	cmd.Env = os.Environ()
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return errors.New(msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// RootDir returns the directory the Docker daemon stores images, containers and the build cache in. It's "" if the
+// daemon isn't on this machine, like with a remote DOCKER_HOST, because then the directory isn't one we can look at.
+func RootDir() (string, error) {
+	if host := os.Getenv("DOCKER_HOST"); host != "" && !strings.HasPrefix(host, "unix://") {
+		return "", nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, DockerCommandFromEnvironment(), "info", "--format", "{{.DockerRootDir}}")
+	cmd.Env = os.Environ()
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
 	}
-	return command
+	return strings.TrimSpace(string(out)), nil
 }
@@ -0,0 +1,32 @@
+package docker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerCommandFromEnvironment(t *testing.T) {
+	t.Setenv(DockerCommandEnvVarName, "/usr/local/bin/nerdctl")
+	require.Equal(t, "/usr/local/bin/nerdctl", DockerCommandFromEnvironment())
+	require.True(t, IsNerdctl())
+	require.Equal(t, []string{"build"}, buildCommand())
+	require.Equal(t, []string{"--platform", "linux/amd64"}, platformArgs())
+
+	t.Setenv(DockerCommandEnvVarName, "docker")
+	require.False(t, IsNerdctl())
+	require.Equal(t, []string{"buildx", "build"}, buildCommand())
+	require.Equal(t, []string{"--platform", "linux/amd64", "--load"}, platformArgs())
+}
+
+func TestIsMissingDeviceDriver(t *testing.T) {
+	require.True(t, isMissingDeviceDriver(`docker: Error response from daemon: could not select device driver "" with capabilities: [[gpu]].`))
+	require.True(t, isMissingDeviceDriver(`FATA[0000] failed to create shim task: OCI runtime create failed: exec: "nvidia-container-cli": executable file not found in $PATH`))
+	require.False(t, isMissingDeviceDriver("Unable to find image 'hotdog:latest' locally"))
+}
+
+func TestBuildPlatform(t *testing.T) {
+	require.Equal(t, "", buildPlatform("", "linux", "arm64"))
+	require.Equal(t, "linux/amd64", buildPlatform("", "darwin", "arm64"))
+	require.Equal(t, "linux/arm64", buildPlatform("linux/arm64", "darwin", "arm64"))
+}
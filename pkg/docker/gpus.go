@@ -0,0 +1,145 @@
+package docker
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// AllGPUs is the count of `--gpus all`, which gives the container every GPU on the host
+const AllGPUs = -1
+
+// FormatGPUs returns gpus as `docker run --gpus` parses it. Docker splits it on commas, so a list of devices like
+// device=0,1 has to be quoted to be read as one option.
+func FormatGPUs(gpus string) string {
+	if !strings.HasPrefix(gpus, "device=") || strings.Contains(gpus, `"`) {
+		return gpus
+	}
+	fields := strings.Split(gpus, ",")
+	devices := []string{fields[0]}
+	rest := []string{}
+	for _, field := range fields[1:] {
+		if len(rest) == 0 && !strings.Contains(field, "=") {
+			devices = append(devices, field)
+		} else {
+			rest = append(rest, field)
+		}
+	}
+	if len(devices) == 1 {
+		return gpus
+	}
+	return strings.Join(append([]string{`"` + strings.Join(devices, ",") + `"`}, rest...), ",")
+}
+
+// GPUCount returns the number of GPUs `docker run --gpus` gives the container, or AllGPUs if it gives it every GPU
+// on the host
+func GPUCount(gpus string) (int, error) {
+	if gpus == "" {
+		return 0, nil
+	}
+	if gpus == "all" {
+		return AllGPUs, nil
+	}
+	if n, err := strconv.Atoi(gpus); err == nil {
+		if n < 0 {
+			return 0, fmt.Errorf("Invalid --gpus %q: the number of GPUs can't be negative", gpus)
+		}
+		return n, nil
+	}
+	fields, err := csv.NewReader(strings.NewReader(FormatGPUs(gpus))).Read()
+	if err != nil {
+		return 0, fmt.Errorf("Invalid --gpus %q: %w", gpus, err)
+	}
+	count := AllGPUs
+	for _, field := range fields {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "device":
+			count = len(strings.Split(value, ","))
+		case "count":
+			if value == "all" {
+				count = AllGPUs
+			} else if count, err = strconv.Atoi(value); err != nil || count < 0 {
+				return 0, fmt.Errorf("Invalid --gpus %q: count must be a number or 'all'", gpus)
+			}
+		case "capabilities", "driver", "options":
+		default:
+			return 0, fmt.Errorf("Invalid --gpus %q: unknown option %q", gpus, key)
+		}
+	}
+	return count, nil
+}
+
+// ValidateGPUs returns an error if gpus gives the container fewer GPUs than the model needs, or more than the host
+// has. The host's GPUs are only checked if nvidia-smi is installed, because Docker might be running somewhere else.
+func ValidateGPUs(gpus string, required int) error {
+	count, err := GPUCount(gpus)
+	if err != nil {
+		return err
+	}
+	if required > 0 && count != AllGPUs && count < required {
+		return fmt.Errorf("The model needs %d GPUs, from gpu_count in cog.yaml, but --gpus %s gives it %d", required, gpus, count)
+	}
+	if count == 0 {
+		return nil
+	}
+	host, ok := hostGPUCount()
+	if !ok {
+		return nil
+	}
+	switch {
+	case count == AllGPUs && host < required:
+		return fmt.Errorf("The model needs %d GPUs, from gpu_count in cog.yaml, but this machine has %d", required, host)
+	case count > host:
+		return fmt.Errorf("--gpus %s asks for %d GPUs, but this machine has %d", gpus, count, host)
+	}
+	return nil
+}
+
+// hostGPUCount returns the number of NVIDIA GPUs on this machine, and false if it can't tell. It's replaced in tests.
+var hostGPUCount = func() (int, bool) {
+	if _, err := exec.LookPath("nvidia-smi"); err != nil {
+		return 0, false
+	}
+	out, err := exec.Command("nvidia-smi", "--list-gpus").Output()
+	if err != nil {
+		console.Debugf("Failed to list GPUs with nvidia-smi: %s", err)
+		return 0, false
+	}
+	return countGPULines(string(out)), true
+}
+
+func countGPULines(out string) int {
+	count := 0
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "GPU ") {
+			count++
+		}
+	}
+	return count
+}
+
+// multiGPUArgs are the docker run arguments for containers with more than one GPU. NCCL talks between GPUs through
+// shared memory and pinned host memory, which Docker's default IPC namespace and memlock limit are too small for.
+func multiGPUArgs(gpus string) []string {
+	count, err := GPUCount(gpus)
+	if err != nil {
+		return nil
+	}
+	// --gpus all gives the container every GPU on the host, so it has more than one unless the host is known not to
+	if count == AllGPUs {
+		if host, ok := hostGPUCount(); ok {
+			count = host
+		} else {
+			count = 2
+		}
+	}
+	if count < 2 {
+		return nil
+	}
+	return []string{"--ipc", "host", "--ulimit", "memlock=-1", "--ulimit", "stack=67108864"}
+}
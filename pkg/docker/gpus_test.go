@@ -0,0 +1,86 @@
+package docker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatGPUs(t *testing.T) {
+	require.Equal(t, "all", FormatGPUs("all"))
+	require.Equal(t, "2", FormatGPUs("2"))
+	require.Equal(t, "device=0", FormatGPUs("device=0"))
+	require.Equal(t, `"device=0,1"`, FormatGPUs("device=0,1"))
+	require.Equal(t, `"device=0,1",capabilities=compute`, FormatGPUs("device=0,1,capabilities=compute"))
+	require.Equal(t, `"device=0,1"`, FormatGPUs(`"device=0,1"`))
+	require.Equal(t, "count=2,capabilities=compute", FormatGPUs("count=2,capabilities=compute"))
+}
+
+func TestGPUCount(t *testing.T) {
+	for _, tt := range []struct {
+		gpus  string
+		count int
+		err   string
+	}{
+		{"", 0, ""},
+		{"all", AllGPUs, ""},
+		{"2", 2, ""},
+		{"device=1", 1, ""},
+		{"device=0,1", 2, ""},
+		{`"device=0,1,2"`, 3, ""},
+		{"device=GPU-3a23c669,GPU-5b34d770", 2, ""},
+		{"count=4,capabilities=compute", 4, ""},
+		{"count=all", AllGPUs, ""},
+		{"-1", 0, "can't be negative"},
+		{"count=lots", 0, "count must be"},
+		{"devices=0", 0, "unknown option"},
+	} {
+		t.Run(tt.gpus, func(t *testing.T) {
+			count, err := GPUCount(tt.gpus)
+			if tt.err != "" {
+				require.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.count, count)
+		})
+	}
+}
+
+func TestValidateGPUsRequired(t *testing.T) {
+	require.NoError(t, ValidateGPUs("", 0))
+	require.ErrorContains(t, ValidateGPUs("device=0", 2), "needs 2 GPUs")
+	require.ErrorContains(t, ValidateGPUs("", 2), "needs 2 GPUs")
+}
+
+func TestCountGPULines(t *testing.T) {
+	out := `GPU 0: NVIDIA A100-SXM4-80GB (UUID: GPU-3a23c669-1f69-c64e-cf85-44e9b07e7a2a)
+GPU 1: NVIDIA A100-SXM4-80GB (UUID: GPU-5b34d770-2a70-d75f-da96-55fac18f8b3b)
+`
+	require.Equal(t, 2, countGPULines(out))
+	require.Equal(t, 0, countGPULines(""))
+}
+
+func TestGenerateDockerArgsMultiGPU(t *testing.T) {
+	args := generateDockerArgs(internalRunOptions{RunOptions: RunOptions{Image: "cog-test", GPUs: "device=0,1"}})
+	require.Equal(t, []string{
+		"run", "--shm-size", "6G", "--rm",
+		"--gpus", `"device=0,1"`, "--ipc", "host", "--ulimit", "memlock=-1", "--ulimit", "stack=67108864",
+		"cog-test",
+	}, args)
+
+	// --gpus all is more than one GPU unless the host only has one
+	original := hostGPUCount
+	t.Cleanup(func() { hostGPUCount = original })
+	hostGPUCount = func() (int, bool) { return 1, true }
+	args = generateDockerArgs(internalRunOptions{RunOptions: RunOptions{Image: "cog-test", GPUs: "all"}})
+	require.Equal(t, []string{"run", "--shm-size", "6G", "--rm", "--gpus", "all", "cog-test"}, args)
+
+	hostGPUCount = func() (int, bool) { return 4, true }
+	args = generateDockerArgs(internalRunOptions{RunOptions: RunOptions{Image: "cog-test", GPUs: "all"}})
+	require.Contains(t, args, "--ipc")
+
+	hostGPUCount = func() (int, bool) { return 0, false }
+	args = generateDockerArgs(internalRunOptions{RunOptions: RunOptions{Image: "cog-test", GPUs: "all"}})
+	require.Contains(t, args, "--ipc")
+}
@@ -0,0 +1,128 @@
+package docker
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// SaveImage writes an image from the local Docker daemon to a tarball, like `docker save`
+func SaveImage(image string, path string) error {
+	cmd := exec.Command(DockerCommandFromEnvironment(), "image", "save", "--output", path, image)
+	cmd.Env = os.Environ()
+	cmd.Stderr = console.Writer()
+
+	console.Debug("$ " + strings.Join(cmd.Args, " "))
+	return cmd.Run()
+}
+
+// ImageArchiveOpener returns an opener for tarball.Image that streams image from the local Docker daemon, like
+// `docker save`, each time it's opened. Only the files that are read from the image are kept, so it doesn't have to
+// be exported to a file first.
+func ImageArchiveOpener(image string) tarball.Opener {
+	return func() (io.ReadCloser, error) {
+		cmd := exec.Command(DockerCommandFromEnvironment(), "image", "save", image)
+		cmd.Env = os.Environ()
+		r := &commandReader{cmd: cmd}
+		cmd.Stderr = &r.stderr
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		r.stdout = stdout
+		console.Debug("$ " + strings.Join(cmd.Args, " "))
+		if err := cmd.Start(); err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+}
+
+// commandReader reads the output of a command, and fails with what it printed to stderr if it fails
+type commandReader struct {
+	cmd     *exec.Cmd
+	stdout  io.ReadCloser
+	stderr  bytes.Buffer
+	once    sync.Once
+	waitErr error
+}
+
+func (r *commandReader) Read(p []byte) (int, error) {
+	n, err := r.stdout.Read(p)
+	if err == io.EOF {
+		if waitErr := r.wait(); waitErr != nil {
+			return n, fmt.Errorf("%w: %s", waitErr, strings.TrimSpace(r.stderr.String()))
+		}
+	}
+	return n, err
+}
+
+// Close stops the command, which is usually before it's finished, once the file that was wanted has been read
+func (r *commandReader) Close() error {
+	_ = r.stdout.Close()
+	_ = r.cmd.Process.Kill()
+	_ = r.wait()
+	return nil
+}
+
+func (r *commandReader) wait() error {
+	r.once.Do(func() {
+		r.waitErr = r.cmd.Wait()
+	})
+	return r.waitErr
+}
+
+// LoadImage loads the images in a tarball into the local Docker daemon, like `docker load`
+func LoadImage(path string) error {
+	cmd := exec.Command(DockerCommandFromEnvironment(), "image", "load", "--input", path)
+	cmd.Env = os.Environ()
+	cmd.Stderr = console.Writer()
+
+	console.Debug("$ " + strings.Join(cmd.Args, " "))
+	_, err := cmd.Output()
+	return err
+}
+
+// CopyImageToHost copies an image from the local Docker daemon to the one at host, like a remote machine with GPUs,
+// like `docker save <image> | docker --host <host> load`
+func CopyImageToHost(image string, host string) error {
+	reader, writer := io.Pipe()
+	save := exec.Command(DockerCommandFromEnvironment(), "image", "save", image)
+	save.Env = os.Environ()
+	save.Stdout = writer
+	save.Stderr = console.Writer()
+	load := exec.Command(DockerCommandFromEnvironment(), "image", "load")
+	load.Env = append(os.Environ(), "DOCKER_HOST="+host)
+	load.Stdin = reader
+	load.Stderr = console.Writer()
+
+	console.Debug("$ " + strings.Join(save.Args, " ") + " | DOCKER_HOST=" + host + " " + strings.Join(load.Args, " "))
+	if err := load.Start(); err != nil {
+		return err
+	}
+	err := save.Run()
+	_ = writer.CloseWithError(err)
+	if loadErr := load.Wait(); err == nil {
+		err = loadErr
+	}
+	return err
+}
+
+// RemoveImageFromHost removes an image from the Docker daemon at host
+func RemoveImageFromHost(image string, host string) error {
+	cmd := exec.Command(DockerCommandFromEnvironment(), "image", "rm", "--force", image)
+	cmd.Env = append(os.Environ(), "DOCKER_HOST="+host)
+	cmd.Stderr = console.Writer()
+
+	console.Debug("$ DOCKER_HOST=" + host + " " + strings.Join(cmd.Args, " "))
+	_, err := cmd.Output()
+	return err
+}
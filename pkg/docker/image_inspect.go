@@ -15,7 +15,7 @@ import (
 var ErrNoSuchImage = errors.New("No image returned")
 
 func ImageInspect(id string) (*types.ImageInspect, error) {
-	cmd := exec.Command("docker", "image", "inspect", id)
+	cmd := exec.Command(DockerCommandFromEnvironment(), "image", "inspect", id)
 	cmd.Env = os.Environ()
 	console.Debug("$ " + strings.Join(cmd.Args, " "))
 	out, err := cmd.Output()
@@ -23,7 +23,8 @@ func ImageInspect(id string) (*types.ImageInspect, error) {
 		if ee, ok := err.(*exec.ExitError); ok {
 			// TODO(andreas): this is fragile in case the
 			// error message changes
-			if strings.Contains(string(ee.Stderr), "No such image") {
+			// nerdctl says "no such image"
+			if strings.Contains(strings.ToLower(string(ee.Stderr)), "no such image") {
 				return nil, ErrNoSuchImage
 			}
 		}
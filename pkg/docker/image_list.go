@@ -0,0 +1,62 @@
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// ImageSummary is an image in the local Docker daemon, as listed by `docker images`
+type ImageSummary struct {
+	Repository string `json:"repository"`
+	Tag        string `json:"tag"`
+	ID         string `json:"id"`
+	CreatedAt  string `json:"created_at"`
+	Size       string `json:"size"`
+}
+
+// ImageList lists the images in the local Docker daemon that match filter, like `docker images --filter`
+func ImageList(filter string) ([]ImageSummary, error) {
+	args := []string{"images", "--format", "{{json .}}"}
+	if filter != "" {
+		args = append(args, "--filter", filter)
+	}
+	cmd := exec.Command(DockerCommandFromEnvironment(), args...)
+	cmd.Env = os.Environ()
+	cmd.Stderr = console.Writer()
+	console.Debug("$ " + strings.Join(cmd.Args, " "))
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseImageList(out)
+}
+
+func parseImageList(out []byte) ([]ImageSummary, error) {
+	images := []ImageSummary{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		// docker images prints fields in Go's default capitalization
+		var image struct {
+			Repository string
+			Tag        string
+			ID         string
+			CreatedAt  string
+			Size       string
+		}
+		if err := json.Unmarshal([]byte(line), &image); err != nil {
+			return nil, err
+		}
+		images = append(images, ImageSummary(image))
+	}
+	return images, scanner.Err()
+}
@@ -4,12 +4,52 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/replicate/cog/pkg/util/console"
 )
 
 func ContainerLogsFollow(containerID string, out io.Writer) error {
-	cmd := exec.Command("docker", "container", "logs", "--follow", containerID)
+	cmd := exec.Command(DockerCommandFromEnvironment(), "container", "logs", "--follow", containerID)
+	cmd.Env = os.Environ()
+	cmd.Stdout = out
+	cmd.Stderr = out
+	return cmd.Run()
+}
+
+// ContainerLogsTail returns the last lines lines of a container's stdout and stderr
+func ContainerLogsTail(containerID string, lines int) ([]byte, error) {
+	cmd := exec.Command(DockerCommandFromEnvironment(), "container", "logs", "--tail", strconv.Itoa(lines), containerID)
+	cmd.Env = os.Environ()
+	return cmd.CombinedOutput()
+}
+
+// LogsOptions are the options of `docker logs`
+type LogsOptions struct {
+	Follow     bool
+	Timestamps bool
+	// Tail is how many lines to show from the end of the logs, or "all"
+	Tail string
+}
+
+// ContainerLogs writes a container's stdout and stderr to out. If options.Follow is set, it keeps writing them until
+// the container stops.
+func ContainerLogs(containerID string, options LogsOptions, out io.Writer) error {
+	args := []string{"container", "logs"}
+	if options.Follow {
+		args = append(args, "--follow")
+	}
+	if options.Timestamps {
+		args = append(args, "--timestamps")
+	}
+	if options.Tail != "" {
+		args = append(args, "--tail", options.Tail)
+	}
+	cmd := exec.Command(DockerCommandFromEnvironment(), append(args, containerID)...)
 	cmd.Env = os.Environ()
 	cmd.Stdout = out
 	cmd.Stderr = out
+	console.Debug("$ " + strings.Join(cmd.Args, " "))
 	return cmd.Run()
 }
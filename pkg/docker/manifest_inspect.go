@@ -8,7 +8,12 @@ import (
 )
 
 func ManifestInspect(image string) error {
-	cmd := exec.Command("docker", "manifest", "inspect", image)
+	if IsNerdctl() {
+		// nerdctl doesn't have `manifest inspect`, so missing images are found when they're pushed
+		console.Debugf("Skipping manifest inspect of %s with nerdctl", image)
+		return nil
+	}
+	cmd := exec.Command(DockerCommandFromEnvironment(), "manifest", "inspect", image)
 	var out strings.Builder
 	cmd.Stdout = &out
 	cmd.Stderr = &out
@@ -0,0 +1,116 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// cgroupPeakMemoryFiles are where a container's cgroup records the most memory it has used, for cgroup v2 and v1
+var cgroupPeakMemoryFiles = []string{
+	"/sys/fs/cgroup/memory.peak",
+	"/sys/fs/cgroup/memory/memory.max_usage_in_bytes",
+}
+
+// ContainerPeakMemory returns the most memory, in bytes, the processes in a container have used since it started,
+// from its cgroup's memory accounting. cgroup v2 only records it on Linux 5.19 and later.
+func ContainerPeakMemory(containerID string) (int64, error) {
+	for _, path := range cgroupPeakMemoryFiles {
+		out, err := containerExec(containerID, "cat", path)
+		if err != nil {
+			continue
+		}
+		peak, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("Failed to parse %s: %w", path, err)
+		}
+		return peak, nil
+	}
+	return 0, fmt.Errorf("The container's cgroup doesn't record its peak memory. It needs cgroup v1, or cgroup v2 on Linux 5.19 or later.")
+}
+
+// ContainerGPUMemory returns how much memory, in bytes, is used on the GPU that's using the most of the container's
+// GPUs, from nvidia-smi in the container. It includes memory used by processes outside the container on the same GPU.
+func ContainerGPUMemory(containerID string) (int64, error) {
+	out, err := containerExec(containerID, "nvidia-smi", "--query-gpu=memory.used", "--format=csv,noheader,nounits")
+	if err != nil {
+		return 0, fmt.Errorf("Failed to query GPU memory with nvidia-smi: %w", err)
+	}
+	used, err := parseNvidiaSMIMemory(string(out))
+	if err != nil {
+		return 0, err
+	}
+	largest := int64(0)
+	for _, u := range used {
+		largest = max(largest, u)
+	}
+	return largest, nil
+}
+
+// HostMemory returns the memory, in bytes, Docker's containers can use, and false if Docker doesn't say. With Docker
+// Desktop, it's the memory of Docker's VM.
+func HostMemory() (int64, bool) {
+	cmd := exec.Command(DockerCommandFromEnvironment(), "info", "--format", "{{.MemTotal}}")
+	cmd.Env = os.Environ()
+	console.Debug("$ " + strings.Join(cmd.Args, " "))
+	out, err := cmd.Output()
+	if err != nil {
+		console.Debugf("Failed to get Docker's memory: %s", err)
+		return 0, false
+	}
+	memory, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil || memory <= 0 {
+		return 0, false
+	}
+	return memory, true
+}
+
+// HostGPUMemory returns the memory, in bytes, of the smallest NVIDIA GPU on this machine, and false if it can't tell.
+// Like hostGPUCount, it's only checked if nvidia-smi is installed.
+func HostGPUMemory() (int64, bool) {
+	if _, err := exec.LookPath("nvidia-smi"); err != nil {
+		return 0, false
+	}
+	out, err := exec.Command("nvidia-smi", "--query-gpu=memory.total", "--format=csv,noheader,nounits").Output()
+	if err != nil {
+		console.Debugf("Failed to get GPU memory with nvidia-smi: %s", err)
+		return 0, false
+	}
+	totals, err := parseNvidiaSMIMemory(string(out))
+	if err != nil || len(totals) == 0 {
+		return 0, false
+	}
+	smallest := totals[0]
+	for _, t := range totals[1:] {
+		smallest = min(smallest, t)
+	}
+	return smallest, true
+}
+
+// parseNvidiaSMIMemory parses the memory of each GPU from nvidia-smi --format=csv,noheader,nounits, which is in MiB
+func parseNvidiaSMIMemory(out string) ([]int64, error) {
+	memory := []int64{}
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		mib, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse GPU memory %q from nvidia-smi", line)
+		}
+		memory = append(memory, mib*1024*1024)
+	}
+	return memory, nil
+}
+
+func containerExec(containerID string, args ...string) ([]byte, error) {
+	cmd := exec.Command(DockerCommandFromEnvironment(), append([]string{"exec", containerID}, args...)...)
+	cmd.Env = os.Environ()
+	console.Debug("$ " + strings.Join(cmd.Args, " "))
+	return cmd.Output()
+}
@@ -0,0 +1,20 @@
+package docker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNvidiaSMIMemory(t *testing.T) {
+	memory, err := parseNvidiaSMIMemory("81920\n 40960 \n\n")
+	require.NoError(t, err)
+	require.Equal(t, []int64{80 << 30, 40 << 30}, memory)
+
+	memory, err = parseNvidiaSMIMemory("")
+	require.NoError(t, err)
+	require.Empty(t, memory)
+
+	_, err = parseNvidiaSMIMemory("[N/A]\n")
+	require.Error(t, err)
+}
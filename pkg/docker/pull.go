@@ -1,18 +1,117 @@
 package docker
 
 import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"regexp"
 	"strings"
 
+	"github.com/vbauerster/mpb/v8"
+	"github.com/vbauerster/mpb/v8/decor"
+
+	"github.com/replicate/cog/pkg/retry"
 	"github.com/replicate/cog/pkg/util/console"
 )
 
+// pullLayerPattern matches the progress lines `docker pull` prints for each layer, like "4f4fb700ef54: Pull complete"
+var pullLayerPattern = regexp.MustCompile(`^([0-9a-f]{12}): (.+)$`)
+
+// Pull pulls an image, retrying if it fails with a transient error
 func Pull(image string) error {
-	cmd := exec.Command("docker", "pull", image)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	return retry.Do("Pulling "+image, func() error {
+		cmd := exec.Command(DockerCommandFromEnvironment(), "pull", image)
+		var stderr bytes.Buffer
+		cmd.Stdout = console.Writer()
+		cmd.Stderr = io.MultiWriter(console.Writer(), &stderr)
+
+		console.Debug("$ " + strings.Join(cmd.Args, " "))
+		return retry.Classify(cmd.Run(), stderr.String())
+	})
+}
+
+// PullWithProgress pulls an image for platform like Pull, but shows how many of its layers have been pulled as a
+// progress bar instead of Docker's output, so several images can be pulled at once. If platform is empty, it's
+// pulled for the host's platform.
+func PullWithProgress(image string, platform string, p *mpb.Progress) error {
+	return retry.Do("Pulling "+image, func() error {
+		return pullWithProgress(image, platform, p)
+	})
+}
+
+func pullWithProgress(image string, platform string, p *mpb.Progress) error {
+	cmd := exec.Command(DockerCommandFromEnvironment(), pullArgs(image, platform)...)
+	cmd.Env = os.Environ()
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	bar := p.New(0,
+		mpb.BarStyle().Rbound("|"),
+		mpb.PrependDecorators(
+			decor.Name(image+" "),
+			decor.CountersNoUnit("%d / %d layers"),
+		),
+		mpb.AppendDecorators(
+			decor.Elapsed(decor.ET_STYLE_GO),
+		),
+	)
+	defer bar.Abort(false)
 
 	console.Debug("$ " + strings.Join(cmd.Args, " "))
-	return cmd.Run()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	layers := pullProgress{}
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		if layers.update(scanner.Text()) {
+			bar.SetTotal(int64(len(layers)), false)
+			bar.SetCurrent(int64(layers.pulled()))
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		return retry.Classify(fmt.Errorf("Failed to pull %s: %w\n%s", image, err, strings.TrimSpace(stderr.String())), stderr.String())
+	}
+	bar.SetTotal(-1, true)
+	return nil
+}
+
+func pullArgs(image string, platform string) []string {
+	args := []string{"pull"}
+	if platform != "" {
+		args = append(args, "--platform", platform)
+	}
+	return append(args, image)
+}
+
+// pullProgress tracks whether each layer in `docker pull` output has been pulled
+type pullProgress map[string]bool
+
+// update records the status of a layer from a line of `docker pull` output. It returns false if the line
+// isn't about a layer.
+func (p pullProgress) update(line string) bool {
+	match := pullLayerPattern.FindStringSubmatch(strings.TrimSpace(line))
+	if match == nil {
+		return false
+	}
+	layer, status := match[1], match[2]
+	p[layer] = p[layer] || status == "Pull complete" || status == "Already exists"
+	return true
+}
+
+func (p pullProgress) pulled() int {
+	pulled := 0
+	for _, done := range p {
+		if done {
+			pulled++
+		}
+	}
+	return pulled
 }
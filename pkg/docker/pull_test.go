@@ -0,0 +1,40 @@
+package docker
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPullProgress(t *testing.T) {
+	output := `cuda12.1-python3.11: Pulling from cog-base
+aece8493d397: Already exists
+45f7ea5367fe: Pulling fs layer
+3d97a47c3c73: Pulling fs layer
+45f7ea5367fe: Downloading  1.2MB/30.4MB
+45f7ea5367fe: Verifying Checksum
+45f7ea5367fe: Download complete
+45f7ea5367fe: Extracting  1.2MB/30.4MB
+45f7ea5367fe: Pull complete
+3d97a47c3c73: Waiting`
+
+	progress := pullProgress{}
+	updated := 0
+	for _, line := range strings.Split(output, "\n") {
+		if progress.update(line) {
+			updated++
+		}
+	}
+	require.Equal(t, 9, updated)
+	require.Equal(t, 3, len(progress))
+	require.Equal(t, 2, progress.pulled())
+
+	require.False(t, progress.update("Digest: sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"))
+	require.False(t, progress.update("Status: Downloaded newer image for r8.im/cog-base:cuda12.1-python3.11"))
+}
+
+func TestPullArgs(t *testing.T) {
+	require.Equal(t, []string{"pull", "r8.im/cog-base:python3.11"}, pullArgs("r8.im/cog-base:python3.11", ""))
+	require.Equal(t, []string{"pull", "--platform", "linux/amd64", "r8.im/cog-base:python3.11"}, pullArgs("r8.im/cog-base:python3.11", "linux/amd64"))
+}
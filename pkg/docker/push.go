@@ -16,7 +16,7 @@ type BuildInfo struct {
 	BuildID   string
 }
 
-func Push(image string, fast bool, projectDir string, command command.Command, buildInfo BuildInfo) error {
+func Push(image string, fast bool, projectDir string, command command.Command, buildInfo BuildInfo, options PushOptions) error {
 	ctx := context.Background()
 	client, err := http.ProvideHTTPClient(command)
 	if err != nil {
@@ -36,5 +36,8 @@ func Push(image string, fast bool, projectDir string, command command.Command, b
 		}
 		return FastPush(ctx, image, projectDir, command, webClient, monobeamClient)
 	}
+	if options.InProcess() {
+		return RegistryPush(image, options)
+	}
 	return StandardPush(image, command)
 }
@@ -71,7 +71,7 @@ func TestPush(t *testing.T) {
 	command := dockertest.NewMockCommand()
 
 	// Run fast push
-	err = Push("r8.im/username/modelname", true, dir, command, BuildInfo{})
+	err = Push("r8.im/username/modelname", true, dir, command, BuildInfo{}, PushOptions{})
 	require.NoError(t, err)
 }
 
@@ -140,6 +140,6 @@ func TestPushWithWeight(t *testing.T) {
 	command := dockertest.NewMockCommand()
 
 	// Run fast push
-	err = Push("r8.im/username/modelname", true, dir, command, BuildInfo{})
+	err = Push("r8.im/username/modelname", true, dir, command, BuildInfo{}, PushOptions{})
 	require.NoError(t, err)
 }
@@ -0,0 +1,280 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/vbauerster/mpb/v8"
+	"github.com/vbauerster/mpb/v8/decor"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/replicate/cog/pkg/dockercontext"
+	"github.com/replicate/cog/pkg/retry"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// DefaultUploadConcurrency is how many layers are uploaded at once if only a bandwidth limit is set, which is
+// the same as Docker's default
+const DefaultUploadConcurrency = 5
+
+// uploadChunkSize is the most that's read from a layer at once, so the bandwidth limit is applied smoothly
+const uploadChunkSize = 32 * 1024
+
+// PushOptions control how an image is uploaded to its registry
+type PushOptions struct {
+	// UploadConcurrency is how many layers are uploaded at once
+	UploadConcurrency int
+	// BandwidthLimit is the most bytes per second that are uploaded, across all layers
+	BandwidthLimit int64
+	// MountFrom are other repositories in the same registry that layers can be mounted from, instead of uploading
+	// them again
+	MountFrom []name.Repository
+}
+
+// InProcess returns whether the push needs to be done by Cog instead of Docker, which has no way to limit it or
+// choose where layers are mounted from
+func (o PushOptions) InProcess() bool {
+	return o.UploadConcurrency > 0 || o.BandwidthLimit > 0 || len(o.MountFrom) > 0
+}
+
+// RegistryPush pushes an image from the local Docker daemon to its registry, uploading its layers with the
+// concurrency and bandwidth limit in options, with a progress bar for each layer. Layers that are already in the
+// registry are skipped or mounted, and how much was actually uploaded is printed when it's done.
+func RegistryPush(image string, options PushOptions) error {
+	tag, err := name.NewTag(image)
+	if err != nil {
+		return fmt.Errorf("Failed to parse image name %s: %w", image, err)
+	}
+	tmpDir, err := dockercontext.MkdirTemp("cog-push-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	archive := filepath.Join(tmpDir, "image.tar")
+	if err := SaveImage(image, archive); err != nil {
+		return fmt.Errorf("Failed to export %s: %w", image, err)
+	}
+	img, err := tarball.ImageFromPath(archive, &tag)
+	if err != nil {
+		return err
+	}
+	// Layers that were uploaded before a push failed are skipped when it's retried
+	var summary *pushSummary
+	err = retry.Do("Pushing "+image, func() (err error) {
+		summary, err = pushImage(context.Background(), img, tag, options, console.Writer(), remote.WithAuthFromKeychain(authn.DefaultKeychain))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	console.Info(summary.String())
+	return nil
+}
+
+func pushImage(ctx context.Context, img v1.Image, tag name.Tag, options PushOptions, out io.Writer, remoteOptions ...remote.Option) (*pushSummary, error) {
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+	remoteOptions = append(remoteOptions, remote.WithContext(ctx))
+	concurrency := options.UploadConcurrency
+	if concurrency <= 0 {
+		concurrency = DefaultUploadConcurrency
+	}
+	limiter := newRateLimiter(options.BandwidthLimit)
+	summary := &pushSummary{}
+
+	p := mpb.NewWithContext(ctx, mpb.WithOutput(out), mpb.WithRefreshRate(180*time.Millisecond))
+	g, _ := errgroup.WithContext(ctx)
+	g.SetLimit(concurrency)
+	for _, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			return nil, err
+		}
+		size, err := layer.Size()
+		if err != nil {
+			return nil, err
+		}
+		bar := p.New(size,
+			mpb.BarStyle().Rbound("|"),
+			mpb.PrependDecorators(
+				decor.Name(digest.Hex[:12]+" "),
+				decor.Counters(decor.SizeB1024(0), "% .2f / % .2f"),
+			),
+			mpb.AppendDecorators(
+				decor.EwmaETA(decor.ET_STYLE_GO, 30),
+				decor.Name(" ] "),
+				decor.EwmaSpeed(decor.SizeB1024(0), "% .2f", 30),
+			),
+		)
+		g.Go(func() error {
+			defer bar.Abort(false)
+			if err := pushLayer(tag.Context(), layer, digest, size, bar, limiter, summary, options.MountFrom, remoteOptions); err != nil {
+				return fmt.Errorf("Failed to push layer %s: %w", digest, err)
+			}
+			bar.SetCurrent(size)
+			return nil
+		})
+	}
+	err = g.Wait()
+	p.Wait()
+	if err != nil {
+		return nil, err
+	}
+	// The layers are already there, so this only pushes the config and manifest
+	if err := remote.Write(tag, img, remoteOptions...); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// pushLayer uploads layer to repo, unless it's already there or can be mounted from one of the repositories in
+// mountFrom, which have to be in the same registry
+func pushLayer(repo name.Repository, layer v1.Layer, digest v1.Hash, size int64, bar *mpb.Bar, limiter *rateLimiter, summary *pushSummary, mountFrom []name.Repository, remoteOptions []remote.Option) error {
+	exists, err := blobExists(repo.Digest(digest.String()), remoteOptions)
+	if err != nil {
+		return err
+	}
+	if exists {
+		summary.add(size, &summary.existing)
+		return nil
+	}
+
+	throttled := &throttledLayer{Layer: layer, bar: bar, limiter: limiter}
+	var upload v1.Layer = throttled
+	for _, from := range mountFrom {
+		if from.RegistryStr() != repo.RegistryStr() || from.Name() == repo.Name() {
+			continue
+		}
+		ref := from.Digest(digest.String())
+		if exists, err := blobExists(ref, remoteOptions); err == nil && exists {
+			upload = &remote.MountableLayer{Layer: throttled, Reference: ref}
+			break
+		}
+	}
+	if err := remote.WriteLayer(repo, upload, remoteOptions...); err != nil {
+		return err
+	}
+	if _, ok := upload.(*remote.MountableLayer); ok && throttled.read.Load() == 0 {
+		summary.add(size, &summary.mounted)
+		return nil
+	}
+	summary.add(size, &summary.uploaded)
+	return nil
+}
+
+// blobExists checks whether a blob is in a repository with a HEAD request
+func blobExists(ref name.Digest, remoteOptions []remote.Option) (bool, error) {
+	layer, err := remote.Layer(ref, remoteOptions...)
+	if err != nil {
+		return false, err
+	}
+	return partial.Exists(layer)
+}
+
+// pushSummary counts the bytes in an image's layers, by how they got to the registry
+type pushSummary struct {
+	mu       sync.Mutex
+	uploaded int64
+	existing int64
+	mounted  int64
+}
+
+func (s *pushSummary) add(size int64, count *int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	*count += size
+}
+
+func (s *pushSummary) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := s.uploaded + s.existing + s.mounted
+	return fmt.Sprintf("Uploaded %s of %s: %s were already in the registry and %s were mounted from other repositories",
+		units.HumanSize(float64(s.uploaded)), units.HumanSize(float64(total)), units.HumanSize(float64(s.existing)), units.HumanSize(float64(s.mounted)))
+}
+
+// throttledLayer is a layer that's read at most as fast as its limiter allows, and reports how much has been
+// read to a progress bar
+type throttledLayer struct {
+	v1.Layer
+	bar     *mpb.Bar
+	limiter *rateLimiter
+	// read is how many bytes have been read, which is nothing if the layer was mounted
+	read atomic.Int64
+}
+
+func (l *throttledLayer) Compressed() (io.ReadCloser, error) {
+	rc, err := l.Layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	// Uploads are retried from the start
+	l.bar.SetCurrent(0)
+	l.read.Store(0)
+	return &throttledReader{ReadCloser: rc, layer: l}, nil
+}
+
+type throttledReader struct {
+	io.ReadCloser
+	layer *throttledLayer
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > uploadChunkSize {
+		p = p[:uploadChunkSize]
+	}
+	start := time.Now()
+	n, err := r.ReadCloser.Read(p)
+	r.layer.limiter.wait(n)
+	r.layer.read.Add(int64(n))
+	r.layer.bar.EwmaIncrBy(n, time.Since(start))
+	return n, err
+}
+
+// rateLimiter spaces out reads so they add up to at most bytesPerSecond. It's shared between layers, so the
+// limit is for the whole push.
+type rateLimiter struct {
+	bytesPerSecond int64
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{bytesPerSecond: bytesPerSecond}
+}
+
+// wait blocks until n more bytes can be sent
+func (l *rateLimiter) wait(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.bytesPerSecond))
+	l.mu.Unlock()
+	time.Sleep(delay)
+}
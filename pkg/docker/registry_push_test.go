@@ -0,0 +1,91 @@
+package docker
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPushImage(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	tag, err := name.NewTag(strings.TrimPrefix(server.URL, "http://") + "/alice/model:latest")
+	require.NoError(t, err)
+	img, err := random.Image(64*1024, 3)
+	require.NoError(t, err)
+
+	start := time.Now()
+	summary, err := pushImage(context.Background(), img, tag, PushOptions{UploadConcurrency: 2, BandwidthLimit: 512 * 1024}, io.Discard)
+	require.NoError(t, err)
+	require.Zero(t, summary.existing)
+	require.Positive(t, summary.uploaded)
+	// 192KB at 512KB/s, less the first chunk, which isn't held back
+	require.GreaterOrEqual(t, time.Since(start), 250*time.Millisecond)
+
+	pushed, err := remote.Head(tag)
+	require.NoError(t, err)
+	digest, err := img.Digest()
+	require.NoError(t, err)
+	require.Equal(t, digest, pushed.Digest)
+
+	// Pushing it again skips the layers that are already there
+	summary, err = pushImage(context.Background(), img, tag, PushOptions{UploadConcurrency: 1}, io.Discard)
+	require.NoError(t, err)
+	require.Zero(t, summary.uploaded)
+	require.Positive(t, summary.existing)
+}
+
+func TestPushImageMountFrom(t *testing.T) {
+	reg := registry.New()
+	mounts := 0
+	// The test registry keeps blobs for every repository together, so this makes it look like the target doesn't
+	// have them, and mounts them when asked to
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v2/alice/prod/blobs/") {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			if r.Method == http.MethodPost && r.URL.Query().Get("from") == "alice/staging" {
+				mounts++
+				w.Header().Set("Location", "/v2/alice/prod/blobs/"+r.URL.Query().Get("mount"))
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	source, err := name.NewTag(host + "/alice/staging:latest")
+	require.NoError(t, err)
+	target, err := name.NewTag(host + "/alice/prod:latest")
+	require.NoError(t, err)
+	img, err := random.Image(1024, 2)
+	require.NoError(t, err)
+
+	_, err = pushImage(context.Background(), img, source, PushOptions{}, io.Discard)
+	require.NoError(t, err)
+	summary, err := pushImage(context.Background(), img, target, PushOptions{MountFrom: []name.Repository{source.Context()}}, io.Discard)
+	require.NoError(t, err)
+	require.Equal(t, 2, mounts)
+	require.Zero(t, summary.uploaded)
+	require.Positive(t, summary.mounted)
+}
+
+func TestPushOptionsInProcess(t *testing.T) {
+	require.False(t, PushOptions{}.InProcess())
+	require.True(t, PushOptions{UploadConcurrency: 2}.InProcess())
+	require.True(t, PushOptions{BandwidthLimit: 1024}.InProcess())
+	require.True(t, PushOptions{MountFrom: []name.Repository{name.MustParseReference("registry.example.com/ml/staging").Context()}}.InProcess())
+}
@@ -11,6 +11,7 @@ import (
 	"os"
 	"os/exec"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -25,11 +26,23 @@ import (
 type Port struct {
 	HostPort      int
 	ContainerPort int
+	// HostIP is the address on the host the port is published on, like 127.0.0.1 so only the host can connect to it.
+	// It's published on all of the host's addresses if it's empty.
+	HostIP string
+}
+
+// publishArg returns the value of docker run's --publish option for the port
+func (p Port) publishArg() string {
+	if p.HostIP != "" {
+		return fmt.Sprintf("%s:%d:%d", p.HostIP, p.HostPort, p.ContainerPort)
+	}
+	return fmt.Sprintf("%d:%d", p.HostPort, p.ContainerPort)
 }
 
 type Volume struct {
 	Source      string
 	Destination string
+	ReadOnly    bool
 }
 
 type RunOptions struct {
@@ -41,6 +54,60 @@ type RunOptions struct {
 	Volumes  []Volume
 	Workdir  string
 	Platform string
+	Security SecurityOptions
+	// Runtime is the name of the container runtime registered with Docker to run the container with, like a
+	// sandbox from ResolveRuntime. Docker's default runtime is used if it's empty.
+	Runtime string
+	// Labels are set on the container, not the image
+	Labels map[string]string
+	// Host is the Docker daemon the container runs on, like ssh://user@gpu-host, in the format of DOCKER_HOST.
+	// The image has to be there already. The daemon Docker is configured with is used if it's empty.
+	Host string
+	// ExtraHosts are added to the container's /etc/hosts, like host.docker.internal:host-gateway so it can connect to
+	// the host
+	ExtraHosts []string
+}
+
+// SecurityOptions harden a container. They're the options in cog.yaml's run stanza, with the seccomp profile as a
+// path on the host.
+type SecurityOptions struct {
+	ReadOnly bool `json:"read_only,omitempty"`
+	// WritablePaths are mounted as tmpfs in a read-only container, as well as /tmp
+	WritablePaths []string `json:"writable_paths,omitempty"`
+	// Seccomp is the path of a seccomp profile, or "unconfined"
+	Seccomp         string `json:"seccomp,omitempty"`
+	AppArmor        string `json:"apparmor,omitempty"`
+	NoNewPrivileges bool   `json:"no_new_privileges,omitempty"`
+	// Egress restricts the hosts the container can connect to. It can connect to anything if it's nil.
+	Egress *EgressPolicy `json:"egress,omitempty"`
+}
+
+// securityArgs returns the `docker run` arguments for options
+func securityArgs(options SecurityOptions) []string {
+	args := []string{}
+	if options.ReadOnly {
+		args = append(args, "--read-only")
+		writable := append([]string{"/tmp"}, options.WritablePaths...)
+		seen := map[string]bool{}
+		for _, p := range writable {
+			if seen[p] {
+				continue
+			}
+			seen[p] = true
+			// Models load compiled extensions from caches like /tmp/torchinductor, so the mounts are executable
+			args = append(args, "--tmpfs", p+":rw,exec")
+		}
+	}
+	if options.Seccomp != "" {
+		args = append(args, "--security-opt", "seccomp="+options.Seccomp)
+	}
+	if options.AppArmor != "" {
+		args = append(args, "--security-opt", "apparmor="+options.AppArmor)
+	}
+	if options.NoNewPrivileges {
+		args = append(args, "--security-opt", "no-new-privileges")
+	}
+	return args
 }
 
 // used for generating arguments, with a few options not exposed by public API
@@ -49,6 +116,11 @@ type internalRunOptions struct {
 	Detach      bool
 	Interactive bool
 	TTY         bool
+	// Name names the container and keeps it when it exits, instead of removing it
+	Name string
+	// Network and NetworkAlias connect the container to a network other than Docker's default one
+	Network      string
+	NetworkAlias string
 }
 
 var ErrMissingDeviceDriver = errors.New("Docker is missing required device driver")
@@ -57,27 +129,41 @@ func generateDockerArgs(options internalRunOptions) []string {
 	// Use verbose options for clarity
 	dockerArgs := []string{
 		"run",
-		"--rm",
 		"--shm-size", "6G",
 		// https://github.com/pytorch/pytorch/issues/2244
 		// https://github.com/replicate/cog/issues/1293
 		// TODO: relative to pwd and cog.yaml
 	}
 
+	if options.Name != "" {
+		dockerArgs = append(dockerArgs, "--name", options.Name)
+	} else {
+		dockerArgs = append(dockerArgs, "--rm")
+	}
+
 	if options.Detach {
 		dockerArgs = append(dockerArgs, "--detach")
 	}
+	labelKeys := make([]string, 0, len(options.Labels))
+	for key := range options.Labels {
+		labelKeys = append(labelKeys, key)
+	}
+	sort.Strings(labelKeys)
+	for _, key := range labelKeys {
+		dockerArgs = append(dockerArgs, "--label", key+"="+options.Labels[key])
+	}
 	for _, env := range options.Env {
 		dockerArgs = append(dockerArgs, "--env", env)
 	}
 	if options.GPUs != "" {
-		dockerArgs = append(dockerArgs, "--gpus", options.GPUs)
+		dockerArgs = append(dockerArgs, "--gpus", FormatGPUs(options.GPUs))
+		dockerArgs = append(dockerArgs, multiGPUArgs(options.GPUs)...)
 	}
 	if options.Interactive {
 		dockerArgs = append(dockerArgs, "--interactive")
 	}
 	for _, port := range options.Ports {
-		dockerArgs = append(dockerArgs, "--publish", fmt.Sprintf("%d:%d", port.HostPort, port.ContainerPort))
+		dockerArgs = append(dockerArgs, "--publish", port.publishArg())
 	}
 	if options.TTY {
 		dockerArgs = append(dockerArgs, "--tty")
@@ -85,7 +171,11 @@ func generateDockerArgs(options internalRunOptions) []string {
 	for _, volume := range options.Volumes {
 		// This needs escaping if we want to support commas in filenames
 		// https://github.com/moby/moby/issues/8604
-		dockerArgs = append(dockerArgs, "--mount", "type=bind,source="+volume.Source+",destination="+volume.Destination)
+		mount := "type=bind,source=" + volume.Source + ",destination=" + volume.Destination
+		if volume.ReadOnly {
+			mount += ",readonly"
+		}
+		dockerArgs = append(dockerArgs, "--mount", mount)
 	}
 	if options.Workdir != "" {
 		dockerArgs = append(dockerArgs, "--workdir", options.Workdir)
@@ -93,6 +183,19 @@ func generateDockerArgs(options internalRunOptions) []string {
 	if options.Platform != "" {
 		dockerArgs = append(dockerArgs, "--platform", options.Platform)
 	}
+	if options.Runtime != "" {
+		dockerArgs = append(dockerArgs, "--runtime", options.Runtime)
+	}
+	for _, host := range options.ExtraHosts {
+		dockerArgs = append(dockerArgs, "--add-host", host)
+	}
+	if options.Network != "" {
+		dockerArgs = append(dockerArgs, "--network", options.Network)
+		if options.NetworkAlias != "" {
+			dockerArgs = append(dockerArgs, "--network-alias", options.NetworkAlias)
+		}
+	}
+	dockerArgs = append(dockerArgs, securityArgs(options.Security)...)
 	dockerArgs = append(dockerArgs, options.Image)
 	dockerArgs = append(dockerArgs, options.Args...)
 	return dockerArgs
@@ -104,6 +207,9 @@ func generateEnv(options internalRunOptions) []string {
 		// Fixes "WARNING: The requested image's platform (linux/amd64) does not match the detected host platform (linux/arm64/v8) and no specific platform was requested"
 		env = append(env, "DOCKER_DEFAULT_PLATFORM=linux/amd64")
 	}
+	if options.Host != "" {
+		env = append(env, "DOCKER_HOST="+options.Host)
+	}
 
 	return env
 }
@@ -120,11 +226,31 @@ func RunWithIO(options RunOptions, stdin io.Reader, stdout, stderr io.Writer) er
 			internalOptions.TTY = isatty.IsTerminal(f.Fd())
 		}
 	}
+	return run(internalOptions, stdin, stdout, stderr)
+}
+
+// RunAndKeepContainer runs a container like RunWithIO, but keeps it with the given name when it exits, so
+// files can be copied out of it with CopyFromContainer. Remove it with RemoveContainer when you're done.
+func RunAndKeepContainer(options RunOptions, name string, stdout, stderr io.Writer) error {
+	return run(internalRunOptions{RunOptions: options, Name: name}, nil, stdout, stderr)
+}
+
+func run(internalOptions internalRunOptions, stdin io.Reader, stdout, stderr io.Writer) error {
+	if internalOptions.Security.Egress != nil {
+		var proxy *egressProxy
+		var err error
+		internalOptions, proxy, err = startEgressProxy(internalOptions, stderr)
+		if err != nil {
+			return err
+		}
+		defer proxy.remove()
+	}
+
 	stderrCopy := new(bytes.Buffer)
 	stderrMultiWriter := io.MultiWriter(stderr, stderrCopy)
 
 	dockerArgs := generateDockerArgs(internalOptions)
-	cmd := exec.Command("docker", dockerArgs...)
+	cmd := exec.Command(DockerCommandFromEnvironment(), dockerArgs...)
 	cmd.Env = generateEnv(internalOptions)
 	cmd.Stdout = stdout
 	cmd.Stdin = stdin
@@ -134,7 +260,7 @@ func RunWithIO(options RunOptions, stdin io.Reader, stdout, stderr io.Writer) er
 	err := cmd.Run()
 	if err != nil {
 		stderrString := stderrCopy.String()
-		if strings.Contains(stderrString, "could not select device driver") || strings.Contains(stderrString, "nvidia-container-cli: initialization error") {
+		if isMissingDeviceDriver(stderrString) {
 			return ErrMissingDeviceDriver
 		}
 		return err
@@ -143,23 +269,45 @@ func RunWithIO(options RunOptions, stdin io.Reader, stdout, stderr io.Writer) er
 }
 
 func RunDaemon(options RunOptions, stderr io.Writer) (string, error) {
-	internalOptions := internalRunOptions{RunOptions: options}
+	return runDaemon(internalRunOptions{RunOptions: options}, stderr)
+}
+
+// RunDaemonAndKeepContainer runs a container like RunDaemon, but keeps it with the given name when it exits, so why
+// it exited can be inspected. Remove it with RemoveContainer when you're done.
+func RunDaemonAndKeepContainer(options RunOptions, name string, stderr io.Writer) (string, error) {
+	return runDaemon(internalRunOptions{RunOptions: options, Name: name}, stderr)
+}
+
+func runDaemon(internalOptions internalRunOptions, stderr io.Writer) (string, error) {
 	internalOptions.Detach = true
+	options := internalOptions.RunOptions
+
+	var proxy *egressProxy
+	if options.Security.Egress != nil {
+		var err error
+		internalOptions, proxy, err = startEgressProxy(internalOptions, stderr)
+		if err != nil {
+			return "", err
+		}
+	}
 
 	stderrCopy := new(bytes.Buffer)
 	stderrMultiWriter := io.MultiWriter(stderr, stderrCopy)
 
 	dockerArgs := generateDockerArgs(internalOptions)
-	cmd := exec.Command("docker", dockerArgs...)
+	cmd := exec.Command(DockerCommandFromEnvironment(), dockerArgs...)
 	cmd.Env = generateEnv(internalOptions)
 	cmd.Stderr = stderrMultiWriter
 
 	console.Debug("$ " + strings.Join(cmd.Args, " "))
 
 	containerID, err := cmd.Output()
+	if err != nil && proxy != nil {
+		proxy.remove()
+	}
 
 	stderrString := stderrCopy.String()
-	if strings.Contains(stderrString, "could not select device driver") || strings.Contains(stderrString, "nvidia-container-cli: initialization error") {
+	if isMissingDeviceDriver(stderrString) {
 		return "", ErrMissingDeviceDriver
 	}
 
@@ -167,11 +315,15 @@ func RunDaemon(options RunOptions, stderr io.Writer) (string, error) {
 		return "", err
 	}
 
-	return strings.TrimSpace(string(containerID)), nil
+	id := strings.TrimSpace(string(containerID))
+	if proxy != nil {
+		egressProxies.Store(id, proxy)
+	}
+	return id, nil
 }
 
 func GetPort(containerID string, containerPort int) (int, error) {
-	cmd := exec.Command("docker", "port", containerID, fmt.Sprintf("%d", containerPort)) //#nosec G204
+	cmd := exec.Command(DockerCommandFromEnvironment(), "port", portContainer(containerID), fmt.Sprintf("%d", containerPort)) //#nosec G204
 	cmd.Env = os.Environ()
 	cmd.Stderr = os.Stderr
 
@@ -0,0 +1,71 @@
+package docker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateDockerArgsSecurity(t *testing.T) {
+	args := generateDockerArgs(internalRunOptions{RunOptions: RunOptions{
+		Image:   "cog-test",
+		Args:    []string{"python", "predict.py"},
+		Runtime: "runsc",
+		Security: SecurityOptions{
+			ReadOnly:        true,
+			WritablePaths:   []string{"/root/.cache", "/tmp"},
+			Seccomp:         "/tmp/seccomp.json",
+			AppArmor:        "cog-model",
+			NoNewPrivileges: true,
+		},
+	}})
+	require.Equal(t, []string{
+		"run", "--shm-size", "6G", "--rm",
+		"--runtime", "runsc",
+		"--read-only", "--tmpfs", "/tmp:rw,exec", "--tmpfs", "/root/.cache:rw,exec",
+		"--security-opt", "seccomp=/tmp/seccomp.json",
+		"--security-opt", "apparmor=cog-model",
+		"--security-opt", "no-new-privileges",
+		"cog-test", "python", "predict.py",
+	}, args)
+}
+
+func TestGenerateDockerArgsWithoutSecurity(t *testing.T) {
+	args := generateDockerArgs(internalRunOptions{RunOptions: RunOptions{Image: "cog-test"}})
+	require.Equal(t, []string{"run", "--shm-size", "6G", "--rm", "cog-test"}, args)
+}
+
+func TestGenerateDockerArgsNetwork(t *testing.T) {
+	args := generateDockerArgs(internalRunOptions{
+		RunOptions:   RunOptions{Image: "cog-test"},
+		Network:      "cog-egress-abc",
+		NetworkAlias: "model",
+	})
+	require.Equal(t, []string{"run", "--shm-size", "6G", "--rm", "--network", "cog-egress-abc", "--network-alias", "model", "cog-test"}, args)
+}
+
+func TestGenerateDockerArgsLabels(t *testing.T) {
+	args := generateDockerArgs(internalRunOptions{RunOptions: RunOptions{
+		Image:  "cog-test",
+		Labels: map[string]string{"run.cog.container": "serve", "com.example.team": "ml"},
+	}})
+	require.Equal(t, []string{
+		"run", "--shm-size", "6G", "--rm",
+		"--label", "com.example.team=ml", "--label", "run.cog.container=serve",
+		"cog-test",
+	}, args)
+}
+
+func TestGenerateDockerArgsPortsAndHosts(t *testing.T) {
+	args := generateDockerArgs(internalRunOptions{RunOptions: RunOptions{
+		Image:      "cog-test",
+		Ports:      []Port{{HostPort: 8393, ContainerPort: 5000, HostIP: "127.0.0.1"}, {HostPort: 9000, ContainerPort: 9000}},
+		ExtraHosts: []string{"host.docker.internal:host-gateway"},
+	}})
+	require.Equal(t, []string{
+		"run", "--shm-size", "6G", "--rm",
+		"--publish", "127.0.0.1:8393:5000", "--publish", "9000:9000",
+		"--add-host", "host.docker.internal:host-gateway",
+		"cog-test",
+	}, args)
+}
@@ -0,0 +1,89 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// sandboxRuntimes are the names Docker might have each sandbox registered as, in the order they're preferred
+var sandboxRuntimes = map[string][]string{
+	config.SandboxGVisor: {"runsc", "io.containerd.runsc.v1"},
+	config.SandboxKata:   {"kata", "kata-runtime", "io.containerd.kata.v2", "kata-qemu", "kata-clh", "kata-fc"},
+}
+
+// sandboxInstallURLs are where to find out how to install each sandbox
+var sandboxInstallURLs = map[string]string{
+	config.SandboxGVisor: "https://gvisor.dev/docs/user_guide/install/",
+	config.SandboxKata:   "https://github.com/kata-containers/kata-containers/tree/main/docs/install",
+}
+
+// dockerRuntime is a container runtime registered with the Docker daemon, as it's reported by `docker info`
+type dockerRuntime struct {
+	Path        string   `json:"path"`
+	RuntimeType string   `json:"runtimeType"`
+	RuntimeArgs []string `json:"runtimeArgs"`
+}
+
+// ResolveRuntime returns the name the Docker daemon has the runtime called name registered as, for `docker run
+// --runtime`. It fails if it isn't installed, or if it can't give the container the GPUs in gpus.
+func ResolveRuntime(name string, gpus string) (string, error) {
+	if name == "" || name == config.RuntimeRunc {
+		return "", nil
+	}
+	if err := config.ValidateSandbox(name); err != nil {
+		return "", err
+	}
+	cmd := exec.Command(DockerCommandFromEnvironment(), "info", "--format", "{{json .Runtimes}}")
+	cmd.Env = os.Environ()
+	console.Debug("$ " + strings.Join(cmd.Args, " "))
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("Failed to list Docker's runtimes: %w", err)
+	}
+	runtimes := map[string]dockerRuntime{}
+	if err := json.Unmarshal(out, &runtimes); err != nil {
+		return "", fmt.Errorf("Failed to parse Docker's runtimes: %w", err)
+	}
+	return resolveRuntime(name, gpus, runtimes)
+}
+
+func resolveRuntime(name string, gpus string, runtimes map[string]dockerRuntime) (string, error) {
+	registered := ""
+	for _, candidate := range sandboxRuntimes[name] {
+		if _, ok := runtimes[candidate]; ok {
+			registered = candidate
+			break
+		}
+	}
+	if registered == "" {
+		installed := []string{}
+		for installedName := range runtimes {
+			installed = append(installed, installedName)
+		}
+		sort.Strings(installed)
+		return "", fmt.Errorf("The %s runtime isn't registered with Docker, which has %s. See %s to install it.", name, strings.Join(installed, ", "), sandboxInstallURLs[name])
+	}
+	if gpus == "" {
+		return registered, nil
+	}
+
+	switch name {
+	case config.SandboxGVisor:
+		// gVisor only lets containers use GPUs through nvproxy, which is off by default
+		if !slices.Contains(runtimes[registered].RuntimeArgs, "--nvproxy") && !slices.Contains(runtimes[registered].RuntimeArgs, "--nvproxy=true") {
+			return "", fmt.Errorf("gVisor can only give the container GPUs with nvproxy, which isn't enabled for the %s runtime. Add \"runtimeArgs\": [\"--nvproxy\"] to it in /etc/docker/daemon.json and restart Docker, or run the model without --runtime.", registered)
+		}
+	case config.SandboxKata:
+		// Kata runs containers in a VM, which Docker's --gpus can't pass GPUs into
+		return "", fmt.Errorf("Kata Containers can't give the container GPUs with --gpus, because they have to be passed through to Kata's VM with VFIO. Run the model with --runtime %s, which supports GPUs with nvproxy, or without --runtime.", config.SandboxGVisor)
+	}
+	return registered, nil
+}
@@ -0,0 +1,48 @@
+package docker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/config"
+)
+
+func TestResolveRuntime(t *testing.T) {
+	runtimes := map[string]dockerRuntime{
+		"runc":                  {Path: "runc"},
+		"runsc":                 {Path: "/usr/local/bin/runsc"},
+		"io.containerd.kata.v2": {RuntimeType: "io.containerd.kata.v2"},
+	}
+
+	name, err := resolveRuntime(config.SandboxGVisor, "", runtimes)
+	require.NoError(t, err)
+	require.Equal(t, "runsc", name)
+
+	name, err = resolveRuntime(config.SandboxKata, "", runtimes)
+	require.NoError(t, err)
+	require.Equal(t, "io.containerd.kata.v2", name)
+
+	_, err = resolveRuntime(config.SandboxGVisor, "all", runtimes)
+	require.ErrorContains(t, err, "nvproxy")
+
+	runtimes["runsc"] = dockerRuntime{Path: "/usr/local/bin/runsc", RuntimeArgs: []string{"--nvproxy"}}
+	name, err = resolveRuntime(config.SandboxGVisor, "all", runtimes)
+	require.NoError(t, err)
+	require.Equal(t, "runsc", name)
+
+	_, err = resolveRuntime(config.SandboxKata, "all", runtimes)
+	require.ErrorContains(t, err, "Kata Containers can't give the container GPUs")
+
+	_, err = resolveRuntime(config.SandboxGVisor, "", map[string]dockerRuntime{"runc": {Path: "runc"}})
+	require.ErrorContains(t, err, "The runsc runtime isn't registered with Docker, which has runc")
+}
+
+func TestResolveRuntimeDefault(t *testing.T) {
+	name, err := ResolveRuntime("", "all")
+	require.NoError(t, err)
+	require.Empty(t, name)
+
+	_, err = ResolveRuntime("crun", "")
+	require.ErrorContains(t, err, "Unknown runtime")
+}
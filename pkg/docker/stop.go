@@ -3,12 +3,15 @@ package docker
 import (
 	"os"
 	"os/exec"
+
+	"github.com/replicate/cog/pkg/util/console"
 )
 
 func Stop(id string) error {
-	cmd := exec.Command("docker", "container", "stop", "--time", "3", id)
+	defer removeEgressProxy(id)
+	cmd := exec.Command(DockerCommandFromEnvironment(), "container", "stop", "--time", "3", id)
 	cmd.Env = os.Environ()
-	cmd.Stderr = os.Stderr
+	cmd.Stderr = console.Writer()
 
 	_, err := cmd.Output()
 	return err
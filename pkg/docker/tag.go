@@ -0,0 +1,17 @@
+package docker
+
+import (
+	"os/exec"
+	"strings"
+
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+func Tag(source string, target string) error {
+	cmd := exec.Command(DockerCommandFromEnvironment(), "tag", source, target)
+	cmd.Stdout = console.Writer()
+	cmd.Stderr = console.Writer()
+
+	console.Debug("$ " + strings.Join(cmd.Args, " "))
+	return cmd.Run()
+}
@@ -0,0 +1,56 @@
+package docker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// SignedTag is a tag signed with Docker Content Trust
+type SignedTag struct {
+	SignedTag string
+	// Digest is the hex digest of the signed manifest, without the sha256: prefix
+	Digest  string
+	Signers []string
+}
+
+// TrustData is the Docker Content Trust data for a repository
+type TrustData struct {
+	Name       string
+	SignedTags []SignedTag
+}
+
+// TrustInspect returns the Docker Content Trust data for image with `docker trust inspect`. Images without
+// any signatures have no trust data.
+func TrustInspect(image string) (*TrustData, error) {
+	if IsNerdctl() {
+		return nil, errors.New("Docker Content Trust needs the Docker CLI, and nerdctl is being used. Verify base images with cosign instead.")
+	}
+	cmd := exec.Command(DockerCommandFromEnvironment(), "trust", "inspect", image)
+	cmd.Env = os.Environ()
+	console.Debug("$ " + strings.Join(cmd.Args, " "))
+	out, err := cmd.Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			stderr := string(ee.Stderr)
+			if strings.Contains(stderr, "No signatures") || strings.Contains(stderr, "does not have trust data") {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("Failed to inspect trust data for %s: %s", image, strings.TrimSpace(stderr))
+		}
+		return nil, err
+	}
+	var data []TrustData
+	if err := json.Unmarshal(out, &data); err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return &data[0], nil
+}
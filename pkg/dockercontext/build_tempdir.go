@@ -1,15 +1,77 @@
 package dockercontext
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
 	"os"
-	"path"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 )
 
 const CogBuildArtifactsFolder = ".cog"
 
+// TmpDirEnvVarName is the environment variable that sets where Cog puts the files it generates for builds, like
+// build contexts, rather than in .cog/tmp in the project. It overrides build.tmp_dir in cog.yaml.
+const TmpDirEnvVarName = "COG_TMPDIR"
+
+// TmpBuildContextName is the build context a generator's temporary directory is passed to Docker as when it's
+// outside the project, so the files in it aren't in the project's build context
+const TmpBuildContextName = "cog-tmp"
+
+var (
+	mu             sync.Mutex
+	configuredRoot string
+)
+
+// SetTmpRoot sets where Cog puts the files it generates for builds, from build.tmp_dir in cog.yaml. It's an absolute
+// path, or "" for .cog/tmp in the project.
+func SetTmpRoot(path string) {
+	mu.Lock()
+	defer mu.Unlock()
+	configuredRoot = path
+}
+
+// tmpRoot returns the directory set by COG_TMPDIR or build.tmp_dir, or "" if neither is set
+func tmpRoot() string {
+	if root := os.Getenv(TmpDirEnvVarName); root != "" {
+		if abs, err := filepath.Abs(root); err == nil {
+			return abs
+		}
+		return root
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return configuredRoot
+}
+
+// ProjectTmpDir returns where the files Cog generates for builds of the project in dir go. That's .cog/tmp in the
+// project, unless COG_TMPDIR or build.tmp_dir is set, like when the project is on a small network mount. Then it's
+// a directory in there named after the project, so projects with the same name don't share it.
+func ProjectTmpDir(dir string) string {
+	root := tmpRoot()
+	if root == "" {
+		return filepath.Join(dir, CogBuildArtifactsFolder, "tmp")
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		abs = dir
+	}
+	hash := sha256.Sum256([]byte(abs))
+	return filepath.Join(root, filepath.Base(abs)+"-"+hex.EncodeToString(hash[:])[:8])
+}
+
+// IsTmpDirInProject returns whether the files Cog generates for builds of the project in dir are in the project, so
+// they're in its build context
+func IsTmpDirInProject(dir string) bool {
+	rel, err := filepath.Rel(dir, ProjectTmpDir(dir))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
 func CogTempDir(dir string, contextDir string) string {
-	return path.Join(dir, CogBuildArtifactsFolder, "tmp", contextDir)
+	return filepath.Join(ProjectTmpDir(dir), contextDir)
 }
 
 func BuildCogTempDir(dir string, subDir string) (string, error) {
@@ -21,7 +83,39 @@ func BuildCogTempDir(dir string, subDir string) (string, error) {
 }
 
 func BuildTempDir(dir string) (string, error) {
-	// tmpDir ends up being something like dir/.cog/tmp/build20240620123456.000000
+	// tmpDir ends up being something like dir/.cog/tmp/build20240620123456.000000, or in COG_TMPDIR
 	now := time.Now().Format("20060102150405.000000")
 	return BuildCogTempDir(dir, "build"+now)
 }
+
+// MkdirTemp creates a temporary directory like os.MkdirTemp, in COG_TMPDIR or build.tmp_dir if either is set, so
+// everything Cog stages for builds is there
+func MkdirTemp(pattern string) (string, error) {
+	root := tmpRoot()
+	if root != "" {
+		if err := os.MkdirAll(root, 0o777); err != nil {
+			return "", err
+		}
+	}
+	return os.MkdirTemp(root, pattern)
+}
+
+// RemoveAll removes path and everything in it, like os.RemoveAll. Windows won't delete read-only files, which
+// end up in temporary directories when they're copied from read-only files in the project, so if removing fails
+// everything is made writable and removed again.
+func RemoveAll(path string) error {
+	err := os.RemoveAll(path)
+	if err == nil {
+		return nil
+	}
+	_ = filepath.WalkDir(path, func(p string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.Mode().Perm()&0o200 == 0 {
+			_ = os.Chmod(p, info.Mode().Perm()|0o200)
+		}
+		return nil
+	})
+	return os.RemoveAll(path)
+}
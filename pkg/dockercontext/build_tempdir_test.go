@@ -1,6 +1,7 @@
 package dockercontext
 
 import (
+	"os"
 	"path/filepath"
 	"testing"
 
@@ -13,3 +14,36 @@ func TestBuildCogTempDir(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, filepath.Join(tmpDir, ".cog/tmp/weights"), cogTmpDir)
 }
+
+func TestProjectTmpDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "my-model")
+	require.Equal(t, filepath.Join(dir, ".cog/tmp"), ProjectTmpDir(dir))
+	require.True(t, IsTmpDirInProject(dir))
+
+	scratch := t.TempDir()
+	SetTmpRoot(scratch)
+	t.Cleanup(func() { SetTmpRoot("") })
+	tmpDir := ProjectTmpDir(dir)
+	require.Equal(t, scratch, filepath.Dir(tmpDir))
+	require.Regexp(t, `^my-model-[0-9a-f]{8}$`, filepath.Base(tmpDir))
+	require.NotEqual(t, tmpDir, ProjectTmpDir(filepath.Join(t.TempDir(), "my-model")))
+	require.False(t, IsTmpDirInProject(dir))
+
+	// COG_TMPDIR overrides build.tmp_dir
+	override := t.TempDir()
+	t.Setenv(TmpDirEnvVarName, override)
+	require.Equal(t, override, filepath.Dir(ProjectTmpDir(dir)))
+
+	tmp, err := MkdirTemp("cog-bake-")
+	require.NoError(t, err)
+	require.Equal(t, override, filepath.Dir(tmp))
+}
+
+func TestRemoveAllWithReadOnlyFiles(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "build")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "src"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "src", "predict.py"), []byte(""), 0o444))
+
+	require.NoError(t, RemoveAll(dir))
+	require.NoDirExists(t, dir)
+}
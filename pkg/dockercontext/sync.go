@@ -0,0 +1,254 @@
+package dockercontext
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+
+	ignore "github.com/sabhiram/go-gitignore"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// SyncOptions configures how a directory is staged into a build context with Sync
+type SyncOptions struct {
+	// Matcher excludes paths, usually created from .dockerignore. It may be nil.
+	Matcher *ignore.GitIgnore
+	// Skip are paths relative to the source directory that aren't copied, such as weights that are handled separately
+	Skip map[string]bool
+	// Workers is the number of files linked or copied in parallel. Defaults to the number of CPUs.
+	Workers int
+}
+
+// SyncStats counts what Sync did to each file
+type SyncStats struct {
+	Linked    int64
+	Copied    int64
+	Unchanged int64
+	Removed   int64
+}
+
+// Sync mirrors src into dest, like rsync --delete. Files are hard linked where possible and copied
+// otherwise, e.g. when dest is on another filesystem. Files that are unchanged since the last sync,
+// going by size and mtime and then by content hash, are left alone so repeated builds only touch what changed.
+// The .cog directory and anything excluded by the matcher are not synced.
+func Sync(src string, dest string, options SyncOptions) (*SyncStats, error) {
+	if err := os.MkdirAll(dest, 0o755); err != nil {
+		return nil, err
+	}
+	destRel, err := filepath.Rel(src, dest)
+	if err != nil {
+		return nil, err
+	}
+
+	workers := options.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	stats := &SyncStats{}
+	var group errgroup.Group
+	group.SetLimit(workers)
+
+	used := map[string]bool{".": true}
+	walkErr := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if relPath == "." {
+			return nil
+		}
+		if relPath == destRel || (info.IsDir() && info.Name() == CogBuildArtifactsFolder) {
+			return filepath.SkipDir
+		}
+		if options.Matcher != nil && options.Matcher.MatchesPath(relPath) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if options.Skip[relPath] {
+			return nil
+		}
+
+		used[relPath] = true
+		target := filepath.Join(dest, relPath)
+		switch {
+		case info.IsDir():
+			// Directories are created while walking so workers can write into them
+			return syncDir(target, info.Mode().Perm())
+		case info.Mode().IsRegular() || info.Mode()&os.ModeSymlink != 0:
+			group.Go(func() error {
+				return syncFile(path, target, stats)
+			})
+		}
+		return nil
+	})
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+	if walkErr != nil {
+		return nil, walkErr
+	}
+
+	// Remove files that are no longer in the source directory
+	err = filepath.Walk(dest, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dest, path)
+		if err != nil {
+			return err
+		}
+		if used[relPath] {
+			return nil
+		}
+		console.Debug("Deleting " + relPath)
+		if err := RemoveAll(path); err != nil {
+			return err
+		}
+		stats.Removed++
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func syncDir(target string, perm os.FileMode) error {
+	info, err := os.Lstat(target)
+	switch {
+	case os.IsNotExist(err):
+		return os.MkdirAll(target, perm)
+	case err != nil:
+		return err
+	case !info.IsDir():
+		if err := os.Remove(target); err != nil {
+			return err
+		}
+		return os.MkdirAll(target, perm)
+	case info.Mode().Perm() != perm:
+		return os.Chmod(target, perm)
+	}
+	return nil
+}
+
+func syncFile(src string, target string, stats *SyncStats) error {
+	// Link to the file a symlink points at, rather than the symlink itself
+	src, err := filepath.EvalSymlinks(src)
+	if err != nil {
+		return err
+	}
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if srcInfo.IsDir() {
+		return syncDir(target, srcInfo.Mode().Perm())
+	}
+
+	if targetInfo, err := os.Lstat(target); err == nil {
+		unchanged, err := isUnchanged(src, srcInfo, target, targetInfo)
+		if err != nil {
+			return err
+		}
+		if unchanged {
+			atomic.AddInt64(&stats.Unchanged, 1)
+			return nil
+		}
+		if err := RemoveAll(target); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.Link(src, target); err == nil {
+		console.Debug("Linking " + target + " to " + src)
+		atomic.AddInt64(&stats.Linked, 1)
+		return nil
+	}
+	console.Debug("Copying " + src + " to " + target)
+	if err := copyPreservingTimes(src, srcInfo, target); err != nil {
+		return err
+	}
+	atomic.AddInt64(&stats.Copied, 1)
+	return nil
+}
+
+// isUnchanged reports whether target already has the contents of src. Hard links to the same file
+// and files with the same size and mtime are assumed to be unchanged. Files with the same size but a
+// different mtime are compared by hash, and their mtime is updated if they match so the next check is cheap.
+func isUnchanged(src string, srcInfo os.FileInfo, target string, targetInfo os.FileInfo) (bool, error) {
+	if !targetInfo.Mode().IsRegular() {
+		return false, nil
+	}
+	if os.SameFile(srcInfo, targetInfo) {
+		return true, nil
+	}
+	if srcInfo.Size() != targetInfo.Size() || srcInfo.Mode().Perm() != targetInfo.Mode().Perm() {
+		return false, nil
+	}
+	if srcInfo.ModTime().Equal(targetInfo.ModTime()) {
+		return true, nil
+	}
+	srcHash, err := hashFile(src)
+	if err != nil {
+		return false, err
+	}
+	targetHash, err := hashFile(target)
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(srcHash, targetHash) {
+		return false, nil
+	}
+	return true, os.Chtimes(target, srcInfo.ModTime(), srcInfo.ModTime())
+}
+
+func hashFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, fmt.Errorf("Failed to hash %s: %w", path, err)
+	}
+	return h.Sum(nil), nil
+}
+
+func copyPreservingTimes(src string, srcInfo os.FileInfo, target string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("Failed to open %s while copying to %s: %w", src, target, err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, srcInfo.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("Failed to create %s while copying %s: %w", target, src, err)
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		return fmt.Errorf("Failed to copy %s to %s: %w", src, target, err)
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(target, srcInfo.ModTime(), srcInfo.ModTime())
+}
@@ -0,0 +1,91 @@
+package dockercontext
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ignore "github.com/sabhiram/go-gitignore"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestFile(t *testing.T, path string, contents string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0o644))
+}
+
+func TestSync(t *testing.T) {
+	src := t.TempDir()
+	writeTestFile(t, filepath.Join(src, "predict.py"), "print(1)")
+	writeTestFile(t, filepath.Join(src, "lib/util.py"), "x = 1")
+	writeTestFile(t, filepath.Join(src, "data/big.bin"), "ignored")
+	writeTestFile(t, filepath.Join(src, "weights.pth"), "weights")
+	dest, err := BuildCogTempDir(src, SrcBuildDir)
+	require.NoError(t, err)
+
+	options := SyncOptions{
+		Matcher: ignore.CompileIgnoreLines("data"),
+		Skip:    map[string]bool{"weights.pth": true},
+		Workers: 2,
+	}
+	stats, err := Sync(src, dest, options)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), stats.Linked+stats.Copied)
+
+	contents, err := os.ReadFile(filepath.Join(dest, "lib/util.py"))
+	require.NoError(t, err)
+	require.Equal(t, "x = 1", string(contents))
+	require.NoFileExists(t, filepath.Join(dest, "data/big.bin"))
+	require.NoFileExists(t, filepath.Join(dest, "weights.pth"))
+	require.NoDirExists(t, filepath.Join(dest, CogBuildArtifactsFolder))
+
+	// A second sync leaves everything in place
+	stats, err = Sync(src, dest, options)
+	require.NoError(t, err)
+	require.Equal(t, &SyncStats{Unchanged: 2}, stats)
+
+	// Replacing a file (as editors do) and deleting another are picked up
+	require.NoError(t, os.Remove(filepath.Join(src, "predict.py")))
+	writeTestFile(t, filepath.Join(src, "predict.py"), "print(2)")
+	require.NoError(t, os.RemoveAll(filepath.Join(src, "lib")))
+	stats, err = Sync(src, dest, options)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), stats.Linked+stats.Copied)
+	require.Equal(t, int64(1), stats.Removed)
+	contents, err = os.ReadFile(filepath.Join(dest, "predict.py"))
+	require.NoError(t, err)
+	require.Equal(t, "print(2)", string(contents))
+	require.NoDirExists(t, filepath.Join(dest, "lib"))
+}
+
+func TestIsUnchangedComparesHashes(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a")
+	target := filepath.Join(dir, "b")
+	writeTestFile(t, src, "same")
+	writeTestFile(t, target, "same")
+	past := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(target, past, past))
+
+	srcInfo, err := os.Stat(src)
+	require.NoError(t, err)
+	targetInfo, err := os.Stat(target)
+	require.NoError(t, err)
+	unchanged, err := isUnchanged(src, srcInfo, target, targetInfo)
+	require.NoError(t, err)
+	require.True(t, unchanged)
+
+	targetInfo, err = os.Stat(target)
+	require.NoError(t, err)
+	require.True(t, srcInfo.ModTime().Equal(targetInfo.ModTime()))
+
+	writeTestFile(t, target, "diff")
+	require.NoError(t, os.Chtimes(target, past, past))
+	targetInfo, err = os.Stat(target)
+	require.NoError(t, err)
+	unchanged, err = isUnchanged(src, srcInfo, target, targetInfo)
+	require.NoError(t, err)
+	require.False(t, unchanged)
+}
@@ -0,0 +1,46 @@
+package dockerfile
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/replicate/cog/pkg/config"
+)
+
+// aptRepositoriesCommand returns the RUN instruction adding the apt repositories in cog.yaml and
+// their signing keys, or an empty string if there are none
+func aptRepositoriesCommand(repos []config.AptRepository) string {
+	if len(repos) == 0 {
+		return ""
+	}
+	commands := []string{
+		"apt-get update -qq",
+		"apt-get install -qqy --no-install-recommends ca-certificates curl gnupg",
+		"mkdir -p /etc/apt/keyrings",
+	}
+	for _, repo := range repos {
+		keyring := repo.KeyringPath()
+		commands = append(commands, "curl -fsSL "+shellQuote(repo.KeyURL)+" | gpg --dearmor --yes -o "+keyring)
+		if repo.KeyFingerprint != "" {
+			commands = append(commands, "(gpg --show-keys --with-colons "+keyring+" | grep -q '^fpr:::::::::"+repo.Fingerprint()+":$'"+
+				" || (echo "+shellQuote("The signing key for apt repository "+repo.Name+" doesn't match key_fingerprint in cog.yaml")+" >&2 && exit 1))")
+		}
+		commands = append(commands, "echo "+shellQuote(repo.SignedSource())+" > "+repo.SourcesListPath())
+	}
+	commands = append(commands, "rm -rf /var/lib/apt/lists/*")
+	return "RUN --mount=type=cache,target=/var/cache/apt,sharing=locked " + strings.Join(commands, " && ")
+}
+
+// aptSnapshotCommand returns the RUN instruction that points apt at the Debian or Ubuntu snapshot
+// archive for build.apt_snapshot, so system packages resolve to the versions published at that time.
+// It returns an empty string if no snapshot is set.
+func aptSnapshotCommand(cfg *config.Config) (string, error) {
+	timestamp, err := cfg.Build.AptSnapshotTimestamp()
+	if err != nil || timestamp == "" {
+		return "", err
+	}
+	// Snapshot Release files expire, but an old snapshot is what we're asking for
+	validUntil := `printf 'Acquire::Check-Valid-Until "false";\n' > /etc/apt/apt.conf.d/99cog-snapshot`
+	rewrite := fmt.Sprintf(`sed -i -E 's#https?://(deb|security)\.debian\.org/(debian(-security)?)#http://snapshot.debian.org/archive/\2/%[1]s#g; s#https?://(([a-z]+\.)?archive|security)\.ubuntu\.com/ubuntu#http://snapshot.ubuntu.com/ubuntu/%[1]s#g' $(ls /etc/apt/sources.list /etc/apt/sources.list.d/*.list /etc/apt/sources.list.d/*.sources 2>/dev/null)`, timestamp)
+	return "RUN " + validUntil + " && " + rewrite, nil
+}
@@ -0,0 +1,137 @@
+package dockerfile
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/lockfile"
+)
+
+// pinBaseImage returns cfg.Build.PinnedBaseImage, the base image pinned to the digest its signature was verified
+// for, if it's baseImage, so the build uses the image that was verified even if its tag has moved since
+func pinBaseImage(cfg *config.Config, baseImage string) string {
+	pinned := cfg.Build.PinnedBaseImage
+	name, _, _ := strings.Cut(baseImage, "@")
+	if pinnedName, _, _ := strings.Cut(pinned, "@"); pinned != "" && pinnedName == name {
+		return pinned
+	}
+	return baseImage
+}
+
+// BaseImageResolution describes the base images a build would use, and why they were picked
+type BaseImageResolution struct {
+	// BaseImage is the image the model's Dockerfile starts from
+	BaseImage string `json:"base_image"`
+	// CogBaseImage is the cog base image for the model, if there's one it can use
+	CogBaseImage string `json:"cog_base_image,omitempty"`
+	// CUDABaseImage is the nvidia/cuda image for the model, if it uses a GPU
+	CUDABaseImage string `json:"cuda_base_image,omitempty"`
+	// Reasons explain how the images were picked
+	Reasons []string `json:"reasons"`
+}
+
+func (r *BaseImageResolution) String() string {
+	lines := []string{"Base image: " + r.BaseImage}
+	if r.CogBaseImage != "" {
+		lines = append(lines, "Cog base image: "+r.CogBaseImage)
+	}
+	if r.CUDABaseImage != "" {
+		lines = append(lines, "CUDA base image: "+r.CUDABaseImage)
+	}
+	lines = append(lines, "", "Reasons:")
+	for _, reason := range r.Reasons {
+		lines = append(lines, "  - "+reason)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ResolveBaseImages works out which base images a build with this generator's options would use, without building anything
+func (g *StandardGenerator) ResolveBaseImages() (*BaseImageResolution, error) {
+	resolution := &BaseImageResolution{}
+	addReason := func(format string, a ...any) {
+		resolution.Reasons = append(resolution.Reasons, fmt.Sprintf(format, a...))
+	}
+
+	if g.Config.Build.GPU {
+		cudaBaseImage, err := g.Config.CUDABaseImageTag()
+		if err != nil {
+			return nil, err
+		}
+		resolution.CUDABaseImage = cudaBaseImage
+		addReason("The CUDA base image is for CUDA %s and cuDNN %s", g.Config.Build.CUDA, g.Config.Build.CuDNN)
+	}
+
+	switch {
+	case g.Config.Build.CogBaseImage != "":
+		resolution.CogBaseImage = g.Config.Build.CogBaseImage
+		addReason("The cog base image is set by build.cog_base_image in cog.yaml")
+	default:
+		cogBaseImage, err := g.determineBaseImageName()
+		if err != nil {
+			addReason("There's no cog base image for %s: %s", g.describeVersions(), err)
+			break
+		}
+		addReason("%s is the cog base image for %s", cogBaseImage, g.describeVersions())
+		resolution.CogBaseImage, err = lockedCogBaseImage(g.Dir, cogBaseImage)
+		if err != nil {
+			return nil, err
+		}
+		if resolution.CogBaseImage != cogBaseImage {
+			addReason("The cog base image is pinned to the digest recorded in %s", lockfile.Filename)
+		}
+	}
+
+	explicit := g.useCogBaseImage != nil
+	usingCogBaseImage := g.IsUsingCogBaseImage()
+	baseImage, err := g.BaseImage()
+	if err != nil {
+		return nil, err
+	}
+	resolution.BaseImage = baseImage
+	switch {
+	case g.IsUsingCogBaseImage() && explicit:
+		addReason("The build uses the cog base image, because --use-cog-base-image is set")
+	case g.IsUsingCogBaseImage():
+		addReason("The build uses the cog base image, which is the default")
+	default:
+		if usingCogBaseImage {
+			addReason("The build can't use a cog base image, so it falls back to another base image")
+		} else if explicit {
+			addReason("The build doesn't use a cog base image, because --use-cog-base-image=false is set")
+		}
+		if g.Config.Build.GPU && g.useCudaBaseImage {
+			addReason("The build uses the CUDA base image, because the model uses a GPU")
+		} else {
+			addReason("The build uses the Python base image for Python %s", g.Config.Build.PythonVersion)
+		}
+	}
+	return resolution, nil
+}
+
+func (g *StandardGenerator) describeVersions() string {
+	versions := []string{}
+	if g.Config.Build.GPU && g.Config.Build.CUDA != "" {
+		versions = append(versions, "CUDA "+g.Config.Build.CUDA)
+	}
+	versions = append(versions, "Python "+g.Config.Build.PythonVersion)
+	if torchVersion, ok := g.Config.TorchVersion(); ok {
+		versions = append(versions, "torch "+torchVersion)
+	}
+	return strings.Join(versions, ", ")
+}
+
+// ResolveBaseImages works out which base images a build with generator would use, without building anything
+func ResolveBaseImages(generator Generator) (*BaseImageResolution, error) {
+	if g, ok := generator.(*StandardGenerator); ok {
+		return g.ResolveBaseImages()
+	}
+	baseImage, err := generator.BaseImage()
+	if err != nil {
+		return nil, err
+	}
+	return &BaseImageResolution{
+		BaseImage: baseImage,
+		Reasons:   []string{fmt.Sprintf("Models built with the %s generator always use this base image", generator.Name())},
+	}, nil
+}
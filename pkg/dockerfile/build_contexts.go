@@ -0,0 +1,36 @@
+package dockerfile
+
+import (
+	"fmt"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/util/files"
+)
+
+// ConfigBuildContexts resolves build.contexts in cog.yaml to absolute directories, to pass to docker build with --build-context
+func ConfigBuildContexts(cfg *config.Config, dir string) (map[string]string, error) {
+	contexts := map[string]string{}
+	for _, name := range cfg.Build.BuildContextNames() {
+		context := cfg.Build.Contexts[name]
+		path, err := context.AbsPath(dir)
+		if err != nil {
+			return nil, err
+		}
+		isDir, err := files.IsDir(path)
+		if err != nil || !isDir {
+			return nil, fmt.Errorf("The build context %q in cog.yaml points to %s, which is not a directory", name, context.Path)
+		}
+		contexts[name] = path
+	}
+	return contexts, nil
+}
+
+// copyBuildContexts copies each of build.contexts into the image
+func copyBuildContexts(cfg *config.Config) string {
+	lines := []string{}
+	for _, name := range cfg.Build.BuildContextNames() {
+		context := cfg.Build.Contexts[name]
+		lines = append(lines, fmt.Sprintf("COPY --from=%s . %s", name, context.TargetPath(name)))
+	}
+	return joinStringsWithoutLineSpace(lines)
+}
@@ -0,0 +1,27 @@
+package dockerfile
+
+// Stages of the Dockerfile the standard generator makes, which can be built on their own with cog build --target
+const (
+	// DepsStageName is the model's environment: system packages, Python, Python packages and build.run commands
+	DepsStageName = "deps"
+	// ModelStageName is the finished model, with its code and weights
+	ModelStageName = "model"
+	// WeightsStageName is the weights image, when the model is built with --separate-weights
+	WeightsStageName = "weights"
+)
+
+// BuildTargets returns the stages of the Dockerfile that generator makes which can be built with cog build --target
+func BuildTargets(generator Generator) []string {
+	g, ok := generator.(*StandardGenerator)
+	if !ok {
+		return nil
+	}
+	targets := []string{}
+	if g.Config.Build.PrecompileWheels() {
+		targets = append(targets, WheelsStageName)
+	}
+	if len(g.Config.Build.CUDAExtensions()) > 0 {
+		targets = append(targets, CUDAExtensionsStageName)
+	}
+	return append(targets, DepsStageName, WeightsStageName, ModelStageName)
+}
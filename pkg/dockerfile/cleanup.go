@@ -0,0 +1,110 @@
+package dockerfile
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	CleanupStrip    = "strip"
+	CleanupTests    = "tests"
+	CleanupPycache  = "pycache"
+	CleanupDocs     = "docs"
+	CleanupPipCache = "pip_cache"
+	CleanupAptLists = "apt_lists"
+)
+
+// CleanupRule is a single step of a cleanup policy.
+type CleanupRule struct {
+	Name string
+	// Find is a shell pipeline that prints one path per line for everything the rule touches.
+	Find string
+	// Apply is the shell command that performs the cleanup. If empty, the paths printed by Find are removed.
+	Apply string
+	// Measure is a shell command printing the number of bytes the rule would save.
+	// If empty, the disk usage of the paths printed by Find is used.
+	Measure string
+}
+
+var cleanupRules = map[string]CleanupRule{
+	CleanupStrip: {
+		Name:  CleanupStrip,
+		Find:  `find / -xdev -type f -name "*python*.so" -not -name "*cpython*.so"`,
+		Apply: StripDebugSymbolsCommand,
+		Measure: `find / -xdev -type f -name "*python*.so" -not -name "*cpython*.so" -exec sh -c ` +
+			`'a=$(stat -c %s "$1"); strip -S -o /tmp/.cog-strip "$1" 2>/dev/null && echo $((a - $(stat -c %s /tmp/.cog-strip)))' _ {} \; ` +
+			`| awk '{s+=$1} END {print s+0}'; rm -f /tmp/.cog-strip`,
+	},
+	CleanupTests: {
+		Name: CleanupTests,
+		Find: `find / -xdev -path "*/site-packages/*" -type d \( -name tests -o -name test \) -prune`,
+	},
+	CleanupPycache: {
+		Name: CleanupPycache,
+		Find: `find / -xdev -path "*/site-packages/*" -type d -name __pycache__ -prune`,
+	},
+	CleanupDocs: {
+		Name: CleanupDocs,
+		Find: `{ find / -xdev -path "*/site-packages/*" -type d \( -name docs -o -name doc \) -prune; ls -d /usr/share/doc /usr/share/man 2>/dev/null; }`,
+	},
+	// The caches are skipped where they're cache mounts, which aren't in the image, and are kept between builds
+	CleanupPipCache: {
+		Name: CleanupPipCache,
+		Find: `{ grep -qs " /root/.cache/pip " /proc/mounts || ls -d /root/.cache/pip 2>/dev/null; }`,
+	},
+	CleanupAptLists: {
+		Name: CleanupAptLists,
+		Find: `find /var/lib/apt/lists $(grep -qs " /var/cache/apt " /proc/mounts || echo /var/cache/apt/archives) -mindepth 1 -maxdepth 1 -not -name lock -not -name partial 2>/dev/null`,
+	},
+}
+
+// CleanupRules resolves the rule names from cog.yaml into cleanup rules, in the order they were given.
+func CleanupRules(names []string) ([]CleanupRule, error) {
+	rules := []CleanupRule{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		rule, ok := cleanupRules[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown cleanup rule %q", name)
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// CleanupCommands returns the shell commands applying all the rules, to chain onto another RUN instruction, or an
+// empty string if there are none.
+func CleanupCommands(rules []CleanupRule) string {
+	commands := []string{}
+	for _, rule := range rules {
+		commands = append(commands, rule.applyCommand())
+	}
+	return strings.Join(commands, " && ")
+}
+
+// CleanupMeasureScript returns a shell script which prints "<rule>\t<bytes>" for every rule without modifying the filesystem.
+func CleanupMeasureScript(rules []CleanupRule) string {
+	lines := []string{}
+	for _, rule := range rules {
+		lines = append(lines, fmt.Sprintf(`printf '%%s\t%%s\n' %q "$(%s)"`, rule.Name, rule.measureCommand()))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (r CleanupRule) applyCommand() string {
+	if r.Apply != "" {
+		return r.Apply
+	}
+	return "(" + r.Find + " | xargs -r rm -rf)"
+}
+
+func (r CleanupRule) measureCommand() string {
+	if r.Measure != "" {
+		return r.Measure
+	}
+	return r.Find + ` | xargs -r du -sb 2>/dev/null | awk '{s+=$1} END {print s+0}'`
+}
@@ -0,0 +1,59 @@
+package dockerfile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/docker/dockertest"
+)
+
+func TestCleanupRules(t *testing.T) {
+	rules, err := CleanupRules([]string{"pycache", "apt_lists", "pycache"})
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	require.Equal(t, CleanupPycache, rules[0].Name)
+	require.Equal(t, CleanupAptLists, rules[1].Name)
+
+	_, err = CleanupRules([]string{"everything"})
+	require.Error(t, err)
+}
+
+func TestCleanupCommandsEmpty(t *testing.T) {
+	require.Equal(t, "", CleanupCommands(nil))
+}
+
+func TestCleanupMeasureScript(t *testing.T) {
+	rules, err := CleanupRules([]string{"tests", "pip_cache"})
+	require.NoError(t, err)
+	script := CleanupMeasureScript(rules)
+	require.Contains(t, script, `printf '%s\t%s\n' "tests"`)
+	require.Contains(t, script, `printf '%s\t%s\n' "pip_cache"`)
+	require.NotContains(t, script, "rm -rf")
+}
+
+func TestGenerateWithCleanup(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	conf, err := config.FromYAML([]byte(`
+build:
+  python_version: "3.12"
+  cleanup:
+    - pycache
+    - apt_lists
+predict: predict.py:Predictor
+`))
+	require.NoError(t, err)
+	require.NoError(t, conf.ValidateAndComplete(""))
+
+	gen, err := NewStandardGenerator(conf, tmpDir, dockertest.NewMockCommand())
+	require.NoError(t, err)
+	gen.SetUseCogBaseImage(false)
+	actual, err := gen.GenerateDockerfileWithoutSeparateWeights()
+	require.NoError(t, err)
+
+	// Each install deletes what the rules match in its own layer, so it isn't in the image
+	require.Contains(t, actual, `pip install --no-cache-dir /tmp/cog-0.0.1.dev0-py3-none-any.whl 'pydantic<2' && (find / -xdev -path "*/site-packages/*" -type d -name __pycache__ -prune | xargs -r rm -rf) && (find /var/lib/apt/lists`)
+	require.NotContains(t, actual, "\nRUN (find")
+}
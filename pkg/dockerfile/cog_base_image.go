@@ -0,0 +1,48 @@
+package dockerfile
+
+import (
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+
+	"github.com/replicate/cog/pkg/lockfile"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// configuredCogBaseImage returns build.cog_base_image, warning if it's tagged as a different base image to
+// the one cog would pick for the versions in cog.yaml
+func (g *StandardGenerator) configuredCogBaseImage() string {
+	configured := g.Config.Build.CogBaseImage
+	determined, err := g.determineBaseImageName()
+	if err != nil {
+		return configured
+	}
+	if tag, ok := imageTag(configured); ok && tag != determined {
+		console.Warnf("build.cog_base_image in cog.yaml is %s, but the base image for the CUDA, Python and torch versions in cog.yaml is %s", configured, determined)
+	}
+	return configured
+}
+
+// lockedCogBaseImage returns baseImage pinned to the digest recorded for it in cog.lock, so rebuilding
+// uses the same base image even if its tag has moved since. It returns baseImage if it isn't in cog.lock.
+func lockedCogBaseImage(dir string, baseImage string) (string, error) {
+	lock, err := lockfile.Load(dir)
+	if err != nil {
+		return "", err
+	}
+	if lock.BaseImage == nil || lock.BaseImage.Name != baseImage {
+		return baseImage, nil
+	}
+	return lock.BaseImage.Reference(), nil
+}
+
+// imageTag returns the repository and tag of an image reference without its digest, like r8.im/cog-base:python3.12
+// for r8.im/cog-base:python3.12@sha256:.... It returns false if the reference doesn't have a tag.
+func imageTag(reference string) (string, bool) {
+	reference, _, _ = strings.Cut(reference, "@")
+	tag, err := name.NewTag(reference, name.StrictValidation)
+	if err != nil {
+		return "", false
+	}
+	return tag.String(), true
+}
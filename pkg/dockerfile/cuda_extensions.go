@@ -0,0 +1,54 @@
+package dockerfile
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/replicate/cog/pkg/config"
+)
+
+// CUDAExtensionsStageName is the builder stage that compiles build.precompile.cuda_extensions into wheels
+const CUDAExtensionsStageName = "cuda-extensions"
+
+const cudaExtensionsWheelDir = "/cuda-extensions"
+
+// cudaExtensionsBuildPackages are needed to build CUDA extensions without build isolation
+var cudaExtensionsBuildPackages = []string{"ninja", "packaging", "psutil", "wheel"}
+
+// cudaExtensionsStage returns a builder stage that compiles the CUDA extensions in cog.yaml into wheels.
+// It starts with the same steps as the model image, so it shares their cache and compiles against the
+// same nvcc, Python and torch. Pip caches the wheels it builds, so extensions are only compiled again
+// when their version changes, even if the stage has to be rebuilt.
+func cudaExtensionsStage(cfg *config.Config, baseImage string, steps []string) string {
+	extensions := cfg.Build.CUDAExtensions()
+	if len(extensions) == 0 {
+		return ""
+	}
+	env := []string{}
+	if cfg.Build.Precompile.CUDAArchList != "" {
+		env = append(env, "TORCH_CUDA_ARCH_LIST="+shellQuote(cfg.Build.Precompile.CUDAArchList))
+	}
+	if cfg.Build.Precompile.MaxJobs > 0 {
+		env = append(env, "MAX_JOBS="+strconv.Itoa(cfg.Build.Precompile.MaxJobs))
+	}
+	quoted := []string{}
+	for _, extension := range extensions {
+		quoted = append(quoted, shellQuote(extension))
+	}
+
+	stage := append([]string{"FROM " + baseImage + " AS " + CUDAExtensionsStageName}, steps...)
+	stage = append(stage,
+		pipInstallCommand(cfg)+" "+strings.Join(cudaExtensionsBuildPackages, " "),
+		pipCommand(cfg, "wheel", env)+" --no-deps --no-build-isolation --wheel-dir "+cudaExtensionsWheelDir+" "+strings.Join(quoted, " "),
+	)
+	return joinStringsWithoutLineSpace(stage)
+}
+
+// installCUDAExtensions returns the RUN instruction installing the wheels compiled in the CUDA extensions stage
+func installCUDAExtensions(cfg *config.Config) string {
+	if len(cfg.Build.CUDAExtensions()) == 0 {
+		return ""
+	}
+	return "RUN --mount=type=bind,from=" + CUDAExtensionsStageName + ",source=" + cudaExtensionsWheelDir + ",target=/tmp" + cudaExtensionsWheelDir +
+		" pip install --no-deps /tmp" + cudaExtensionsWheelDir + "/*.whl"
+}
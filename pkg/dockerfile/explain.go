@@ -0,0 +1,169 @@
+package dockerfile
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/replicate/cog/pkg/config"
+)
+
+// Instruction is an instruction in a generated Dockerfile, and why the generator emitted it
+type Instruction struct {
+	// Line is where the instruction starts in the Dockerfile, counting from 1
+	Line        int    `json:"line"`
+	Instruction string `json:"instruction"`
+	// Stage is the name of the build stage the instruction is in, if it's named
+	Stage string `json:"stage,omitempty"`
+	// Fields are the cog.yaml fields that caused the instruction, like "build.system_packages" or "build.run[0]"
+	Fields []string `json:"fields,omitempty"`
+	Reason string   `json:"reason"`
+}
+
+// Explanation describes every instruction in a generated Dockerfile
+type Explanation struct {
+	Generator    string        `json:"generator"`
+	Instructions []Instruction `json:"instructions"`
+}
+
+// Dockerfile returns the Dockerfile the explanation describes
+func (e *Explanation) Dockerfile() string {
+	lines := []string{}
+	for _, instruction := range e.Instructions {
+		lines = append(lines, instruction.Instruction)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (e *Explanation) String() string {
+	lines := []string{}
+	for _, instruction := range e.Instructions {
+		source := "(generated)"
+		if len(instruction.Fields) > 0 {
+			source = strings.Join(instruction.Fields, ", ")
+		}
+		lines = append(lines, fmt.Sprintf("%d: %s", instruction.Line, firstLine(instruction.Instruction)))
+		lines = append(lines, fmt.Sprintf("    %s: %s", source, instruction.Reason))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// step is part of a generated Dockerfile, with the cog.yaml fields it comes from and why it's there
+type step struct {
+	text   string
+	fields []string
+	reason string
+}
+
+func newStep(text string, reason string, fields ...string) step {
+	return step{text: text, fields: fields, reason: reason}
+}
+
+// joinSteps returns the Dockerfile made of steps
+func joinSteps(steps []step) string {
+	return joinStringsWithoutLineSpace(stepTexts(steps))
+}
+
+// explainSteps describes each instruction in the Dockerfile made of steps. Instructions continued over several
+// lines with a backslash are described once.
+func explainSteps(generator string, steps []step) *Explanation {
+	explanation := &Explanation{Generator: generator, Instructions: []Instruction{}}
+	line := 0
+	stage := ""
+	for _, s := range steps {
+		lines := filterEmpty(strings.Split(s.text, "\n"))
+		for i := 0; i < len(lines); i++ {
+			line++
+			start := line
+			instruction := lines[i]
+			for strings.HasSuffix(lines[i], "\\") && i+1 < len(lines) {
+				i++
+				line++
+				instruction += "\n" + lines[i]
+			}
+			if fields := strings.Fields(instruction); len(fields) > 0 && strings.EqualFold(fields[0], "FROM") {
+				stage = ""
+				if len(fields) == 4 && strings.EqualFold(fields[2], "AS") {
+					stage = fields[3]
+				}
+			}
+			explanation.Instructions = append(explanation.Instructions, Instruction{
+				Line:        start,
+				Instruction: instruction,
+				Stage:       stage,
+				Fields:      s.fields,
+				Reason:      s.reason,
+			})
+		}
+	}
+	return explanation
+}
+
+// explainDockerfile describes each instruction in a Dockerfile with the same reason, for generators that don't
+// keep track of why they emit each instruction
+func explainDockerfile(generator string, dockerfile string, reason string, fields ...string) *Explanation {
+	return explainSteps(generator, []step{newStep(dockerfile, reason, fields...)})
+}
+
+func firstLine(s string) string {
+	line, _, found := strings.Cut(s, "\n")
+	if found {
+		return line + " ..."
+	}
+	return line
+}
+
+func stepTexts(steps []step) []string {
+	texts := []string{}
+	for _, s := range steps {
+		texts = append(texts, s.text)
+	}
+	return texts
+}
+
+// systemPackageSteps are the steps that install build.system_packages, for generators that don't use a cog base image
+func systemPackageSteps(cfg *config.Config) ([]step, error) {
+	snapshot, err := aptSnapshotCommand(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return []step{
+		newStep("ENV DEBIAN_FRONTEND=noninteractive", "Stops apt-get from asking questions"),
+		newStep(snapshot, "Installs system packages from the Debian or Ubuntu snapshot at this time", "build.apt_snapshot"),
+		newStep(aptRepositoriesCommand(cfg.Build.AptRepositories), "Adds the extra apt repositories", "build.apt_repositories"),
+		newStep(aptInstallCommand(cfg.Build.SystemPackages), "Installs the system packages", "build.system_packages"),
+	}, nil
+}
+
+// runItemSteps are the steps that run the commands in build.run
+func runItemSteps(cfg *config.Config) ([]step, error) {
+	steps := []step{}
+	for i, run := range cfg.Build.Run {
+		command := strings.TrimSpace(run.Command)
+		if strings.Contains(command, "\n") {
+			return nil, fmt.Errorf("One of the commands in 'run' contains a new line, which won't work. This is the offending line: %s", command)
+		}
+		reason := "Runs a setup command"
+		if run.Network == config.NetworkNone {
+			reason += " without network access"
+		}
+		steps = append(steps, newStep(runInstruction(run, command), reason, fmt.Sprintf("build.run[%d]", i)))
+	}
+	return steps, nil
+}
+
+// serverSteps are the steps that start the model's HTTP server with cmd
+func serverSteps(cmd step) []step {
+	return []step{
+		newStep(`WORKDIR /src`, "The model's code is in /src"),
+		newStep(`EXPOSE 5000`, "The model's HTTP server listens on port 5000"),
+		cmd,
+	}
+}
+
+// copySteps are the steps that copy the model's code into the image
+func copySteps(cfg *config.Config) []step {
+	return []step{
+		newStep(`COPY . /src`, "Copies the model's code and weights, except for files in .dockerignore"),
+		newStep(copyBuildContexts(cfg), "Copies the extra build contexts into the image", "build.contexts"),
+	}
+}
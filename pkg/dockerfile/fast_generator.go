@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
 	"path"
 	"path/filepath"
 	"strings"
@@ -70,6 +69,16 @@ func (g *FastGenerator) GenerateDockerfileWithoutSeparateWeights() (string, erro
 	return g.generate()
 }
 
+// Explain describes the Dockerfile that GenerateDockerfileWithoutSeparateWeights generates. The fast generator
+// doesn't keep track of which cog.yaml fields cause each instruction.
+func (g *FastGenerator) Explain() (*Explanation, error) {
+	dockerfile, err := g.generate()
+	if err != nil {
+		return nil, err
+	}
+	return explainDockerfile(g.Name(), dockerfile, "Generated by the fast generator, which installs the model's environment with monobase", "build.fast"), nil
+}
+
 func (g *FastGenerator) GenerateModelBase() (string, error) {
 	return "", errors.New("GenerateModelBase not supported in FastGenerator")
 }
@@ -133,12 +142,15 @@ func (g *FastGenerator) BuildContexts() (map[string]string, error) {
 	if err != nil {
 		return nil, err
 	}
-	return map[string]string{
-		dockercontext.AptBuildContextName:          aptDir,
-		dockercontext.MonobaseBuildContextName:     monobaseDir,
-		dockercontext.RequirementsBuildContextName: requirementsDir,
-		dockercontext.SrcBuildContextName:          srcDir,
-	}, nil
+	contexts, err := ConfigBuildContexts(g.Config, g.Dir)
+	if err != nil {
+		return nil, err
+	}
+	contexts[dockercontext.AptBuildContextName] = aptDir
+	contexts[dockercontext.MonobaseBuildContextName] = monobaseDir
+	contexts[dockercontext.RequirementsBuildContextName] = requirementsDir
+	contexts[dockercontext.SrcBuildContextName] = srcDir
+	return contexts, nil
 }
 
 func (g *FastGenerator) generate() (string, error) {
@@ -312,7 +324,7 @@ func (g *FastGenerator) copyWeights(lines []string, weightsInfo []weights.Weight
 			}
 			weightPaths = append(weightPaths, weights.WeightManifest{
 				Source:      weightPathAbs,
-				Destination: weight.Path,
+				Destination: filepath.ToSlash(weight.Path),
 			})
 		}
 		jsonBytes, err := json.Marshal(weightPaths)
@@ -323,7 +335,7 @@ func (g *FastGenerator) copyWeights(lines []string, weightsInfo []weights.Weight
 		lines = append(lines, "LABEL "+command.CogWeightsManifestLabelKey+"=\""+escapedJSON+"\"")
 	} else {
 		for _, weight := range weightsInfo {
-			lines = append(lines, "COPY --link \""+weight.Path+"\" \""+filepath.Join(FUSE_RPC_WEIGHTS_PATH, weight.Digest)+"\"")
+			lines = append(lines, "COPY --link \""+filepath.ToSlash(weight.Path)+"\" \""+path.Join(FUSE_RPC_WEIGHTS_PATH, weight.Digest)+"\"")
 		}
 	}
 
@@ -334,7 +346,7 @@ func (g *FastGenerator) installApt(lines []string, aptTarFile string) ([]string,
 	// Install apt packages
 
 	if aptTarFile != "" {
-		lines = append(lines, "RUN --mount=from="+dockercontext.AptBuildContextName+",target="+buildTmpDir+" tar -xf \""+filepath.Join(buildTmpDir, aptTarFile)+"\" -C /")
+		lines = append(lines, "RUN --mount=from="+dockercontext.AptBuildContextName+",target="+buildTmpDir+" tar -xf \""+path.Join(buildTmpDir, aptTarFile)+"\" -C /")
 	}
 	return lines, nil
 }
@@ -344,6 +356,9 @@ func (g *FastGenerator) installPython(lines []string, tmpDir string) ([]string,
 	if len(g.Config.Build.PythonPackages) > 0 {
 		return nil, fmt.Errorf("python_packages is no longer supported, use python_requirements instead")
 	}
+	if len(g.Config.Build.LocalPackages) > 0 {
+		return nil, fmt.Errorf("build.local_packages isn't supported by fast builds, list the packages in python_requirements instead, like -e ./lib/mymodel")
+	}
 	// No Python requirements
 	if g.Config.Build.PythonRequirements == "" {
 		return lines, nil
@@ -354,11 +369,24 @@ func (g *FastGenerator) installPython(lines []string, tmpDir string) ([]string,
 		return nil, err
 	}
 	if requirementsFile != "" {
-		lines = append(lines, "RUN "+strings.Join([]string{
+		mounts := []string{
 			"--mount=from=" + dockercontext.RequirementsBuildContextName + ",target=/buildtmp",
 			"--mount=from=" + dockercontext.SrcBuildContextName + ",target=/src",
 			UV_CACHE_MOUNT,
-		}, " ")+" cd /src && UV_CACHE_DIR=\""+UV_CACHE_DIR+"\" UV_LINK_MODE=copy UV_COMPILE_BYTECODE=0 /opt/r8/monobase/run.sh monobase.user --requirements=/buildtmp/requirements.txt")
+		}
+		env := "UV_CACHE_DIR=\"" + UV_CACHE_DIR + "\" UV_LINK_MODE=copy UV_COMPILE_BYTECODE=0"
+		extraMounts, extraEnv := pipMountsAndEnv(g.Config)
+		mounts = append(mounts, extraMounts...)
+		if hasPipIndexConfig(g.Config) {
+			extraEnv = append(extraEnv, uvIndexEnv(g.Config))
+		}
+		if len(extraEnv) > 0 {
+			env += " " + strings.Join(extraEnv, " ")
+		}
+		if g.Config.Build.SSH {
+			lines = append(lines, sshKnownHostsCommand())
+		}
+		lines = append(lines, "RUN "+strings.Join(mounts, " ")+" cd /src && "+env+" /opt/r8/monobase/run.sh monobase.user --requirements=/buildtmp/requirements.txt")
 	}
 	return lines, nil
 }
@@ -383,7 +411,7 @@ func (g *FastGenerator) installSrc(lines []string, weights []weights.Weight) ([]
 	if !g.localImage {
 		copyCommand := "COPY --link --exclude='.cog' "
 		for _, weight := range weights {
-			copyCommand += "--exclude='" + weight.Path + "' "
+			copyCommand += "--exclude='" + filepath.ToSlash(weight.Path) + "' "
 		}
 		copyCommand += ". /src"
 		lines = append(lines, copyCommand)
@@ -396,16 +424,19 @@ func (g *FastGenerator) installSrc(lines []string, weights []weights.Weight) ([]
 		if err != nil {
 			return nil, err
 		}
-		copyCommand := "COPY --link " + relSrcDir + "/. /src"
+		copyCommand := "COPY --link " + filepath.ToSlash(relSrcDir) + "/. /src"
 		lines = append(lines, copyCommand)
 	}
+	if copyContexts := copyBuildContexts(g.Config); copyContexts != "" {
+		lines = append(lines, copyContexts)
+	}
 
 	// Link to weights
 	// If it is a local image we do this with a runtime mount instead to make builds faster.
 	if len(weights) > 0 && !g.localImage {
 		linkCommands := []string{}
 		for _, weight := range weights {
-			linkCommands = append(linkCommands, "ln -s \""+filepath.Join(FUSE_RPC_WEIGHTS_PATH, weight.Digest)+"\" \"/src/"+weight.Path+"\"")
+			linkCommands = append(linkCommands, "ln -s \""+path.Join(FUSE_RPC_WEIGHTS_PATH, weight.Digest)+"\" \"/src/"+filepath.ToSlash(weight.Path)+"\"")
 		}
 		lines = append(lines, "RUN "+strings.Join(linkCommands, " && "))
 	}
@@ -423,13 +454,31 @@ func (g *FastGenerator) entrypoint(lines []string) ([]string, error) {
 }
 
 func (g *FastGenerator) generateAptTarball(tmpDir string) (string, error) {
-	return docker.CreateAptTarball(tmpDir, g.dockerCommand, g.Config.Build.SystemPackages...)
+	return docker.CreateAptTarball(tmpDir, g.dockerCommand, systemPackages(g.Config)...)
 }
 
 func (g *FastGenerator) validateConfig() error {
 	if len(g.Config.Build.Run) > 0 {
 		return errors.New("cog builds with --x-fast do not support build run commands.")
 	}
+	if len(g.Config.Build.AptRepositories) > 0 {
+		return errors.New("cog builds with --x-fast do not support build.apt_repositories.")
+	}
+	if g.Config.Build.AptSnapshot != "" {
+		return errors.New("cog builds with --x-fast do not support build.apt_snapshot.")
+	}
+	if len(g.Config.Build.CUDAExtensions()) > 0 {
+		return errors.New("cog builds with --x-fast do not support build.precompile.cuda_extensions.")
+	}
+	if g.Config.Build.PrecompileWheels() {
+		return errors.New("cog builds with --x-fast do not support build.precompile.wheels.")
+	}
+	if g.Config.Build.Bake != nil {
+		return errors.New("cog builds with --x-fast do not support build.bake.")
+	}
+	if len(g.Config.Pipeline) > 0 {
+		return errors.New("cog builds with --x-fast do not support pipeline.")
+	}
 	return nil
 }
 
@@ -439,148 +488,19 @@ func (g *FastGenerator) rsyncSrc(srcDir string, weights []weights.Weight) error
 		return err
 	}
 
-	relPath, err := filepath.Rel(g.Dir, srcDir)
-	if err != nil {
-		return err
-	}
-
-	// Build weights path set
+	// Skip weights, we handle them separately
 	weightPaths := map[string]bool{}
 	for _, weight := range weights {
 		weightPaths[weight.Path] = true
 	}
 
-	// Find files we haven't copied over yet.
-	usedFiles := make(map[string]bool)
-	usedFiles[relPath] = true
-	err = filepath.Walk(g.Dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if matcher != nil && matcher.MatchesPath(path) {
-			if info.IsDir() {
-				return filepath.SkipDir
-			}
-			return nil
-		}
-
-		if info.IsDir() && info.Name() == dockercontext.CogBuildArtifactsFolder {
-			return filepath.SkipDir
-		}
-
-		relPath, err := filepath.Rel(g.Dir, path)
-		if err != nil {
-			return err
-		}
-
-		// Skip weights, we handle them separately
-		_, ok := weightPaths[relPath]
-		if ok {
-			return nil
-		}
-
-		copyPath := filepath.Join(srcDir, relPath)
-		err = ensureFSObjectExists(copyPath, path)
-		if err != nil {
-			return err
-		}
-		usedFiles[relPath] = true
-		return nil
-	})
-	if err != nil {
-		return err
-	}
-
-	// Remove files that we no longer need in our tmp dir.
-	err = filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		relPath, err := filepath.Rel(srcDir, path)
-		if err != nil {
-			return err
-		}
-
-		_, ok := usedFiles[relPath]
-		if !ok {
-			console.Debug("Deleting " + relPath)
-			err = os.RemoveAll(path)
-			if err != nil {
-				return err
-			}
-		}
-
-		return nil
+	stats, err := dockercontext.Sync(g.Dir, srcDir, dockercontext.SyncOptions{
+		Matcher: matcher,
+		Skip:    weightPaths,
 	})
 	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func linkFile(destination string, src string) error {
-	console.Debug("Linking " + destination + " to " + src)
-
-	fileInfo, err := os.Lstat(src)
-	if err != nil {
-		return err
-	}
-	// If we are a symlink, link to the original target
-	if fileInfo.Mode()&os.ModeSymlink == os.ModeSymlink {
-		destination, err = os.Readlink(src)
-		if err != nil {
-			return err
-		}
-	}
-
-	err = os.Link(src, destination)
-	if err != nil {
-		return err
+		return fmt.Errorf("Failed to prepare build context: %w", err)
 	}
-	return nil
-}
-
-func ensureFSObjectExists(destination string, src string) error {
-	exists, err := files.Exists(destination)
-	if err != nil {
-		return err
-	}
-
-	info, err := os.Stat(src)
-	if err != nil {
-		return err
-	}
-	mode := info.Mode()
-
-	if !exists {
-		switch {
-		case mode.IsDir():
-			err := os.MkdirAll(destination, mode.Perm())
-			if err != nil {
-				return err
-			}
-		case mode.IsRegular():
-			err := linkFile(destination, src)
-			if err != nil {
-				return err
-			}
-		}
-	} else if mode.IsDir() {
-		destinationInfo, err := os.Stat(destination)
-		if err != nil {
-			return err
-		}
-		destinationMode := destinationInfo.Mode()
-		if destinationMode.Perm() != mode.Perm() {
-			err = os.Chmod(destination, mode.Perm())
-			if err != nil {
-				return err
-			}
-		}
-	}
-
+	console.Debugf("Build context: %d linked, %d copied, %d unchanged, %d removed", stats.Linked, stats.Copied, stats.Unchanged, stats.Removed)
 	return nil
 }
@@ -265,3 +265,40 @@ func TestValidateConfigWithBuildRunItems(t *testing.T) {
 	err = generator.validateConfig()
 	require.Error(t, err)
 }
+
+func TestGenerateSSH(t *testing.T) {
+	dir := t.TempDir()
+	build := config.Build{
+		PythonVersion:      "3.9",
+		PythonRequirements: writeRequirements(t, "acme @ git+ssh://git@github.com/acme/acme.git@v1.0.0"),
+		SSH:                true,
+	}
+	config := config.Config{
+		Build: &build,
+	}
+	command := dockertest.NewMockCommand()
+
+	matrix := MonobaseMatrix{
+		Id:             1,
+		CudaVersions:   []string{"2.4"},
+		CudnnVersions:  []string{"1.0"},
+		PythonVersions: []string{"3.9"},
+		TorchVersions:  []string{"2.5.1"},
+		Venvs: []MonobaseVenv{
+			{
+				Python: "3.9",
+				Torch:  "2.5.1",
+				Cuda:   "2.4",
+			},
+		},
+	}
+
+	generator, err := NewFastGenerator(&config, dir, command, &matrix, true)
+	require.NoError(t, err)
+	dockerfile, err := generator.GenerateDockerfileWithoutSeparateWeights()
+	require.NoError(t, err)
+	require.Contains(t, dockerfile, "RUN --mount=from=apt,target=/buildtmp tar -xf")
+	require.Contains(t, dockerfile, "RUN mkdir -p /etc/ssh && printf ")
+	require.Contains(t, dockerfile, "--mount=type=ssh --mount=type=secret,id=ssh-known-hosts,target=/root/.ssh/known_hosts cd /src && UV_CACHE_DIR=\"/srv/r8/monobase/uv/cache\" UV_LINK_MODE=copy UV_COMPILE_BYTECODE=0 GIT_SSH_COMMAND='ssh -o StrictHostKeyChecking=yes' /opt/r8/monobase/run.sh")
+	require.Nil(t, build.SystemPackages)
+}
@@ -19,4 +19,6 @@ type Generator interface {
 	Name() string
 	BuildDir() (string, error)
 	BuildContexts() (map[string]string, error)
+	// Explain describes each instruction in the Dockerfile GenerateDockerfileWithoutSeparateWeights generates
+	Explain() (*Explanation, error)
 }
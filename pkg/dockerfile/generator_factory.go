@@ -7,13 +7,21 @@ import (
 	"github.com/replicate/cog/pkg/docker/command"
 )
 
-func NewGenerator(config *config.Config, dir string, buildFast bool, command command.Command, localImage bool) (Generator, error) {
+func NewGenerator(cfg *config.Config, dir string, buildFast bool, command command.Command, localImage bool) (Generator, error) {
+	switch cfg.PredictorLanguage() {
+	case config.LanguageNode:
+		return NewNodeGenerator(cfg, dir)
+	case config.LanguageR:
+		return NewRGenerator(cfg, dir)
+	case config.LanguageCustom:
+		return NewServerGenerator(cfg, dir)
+	}
 	if buildFast {
 		matrix, err := NewMonobaseMatrix(http.DefaultClient)
 		if err != nil {
 			return nil, err
 		}
-		return NewFastGenerator(config, dir, command, matrix, localImage)
+		return NewFastGenerator(cfg, dir, command, matrix, localImage)
 	}
-	return NewStandardGenerator(config, dir, command)
+	return NewStandardGenerator(cfg, dir, command)
 }
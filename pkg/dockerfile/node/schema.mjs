@@ -0,0 +1,122 @@
+// Generates the Cog OpenAPI schema for a TypeScript/JavaScript predictor by
+// reading the types of the predictor's predict() method with the TypeScript
+// compiler API. Run as a script, it prints the schema as JSON.
+import path from "node:path";
+import { pathToFileURL } from "node:url";
+import ts from "typescript";
+
+export function predictorRef() {
+  const ref = process.env.COG_NODE_PREDICTOR;
+  if (!ref) {
+    throw new Error("COG_NODE_PREDICTOR is not set");
+  }
+  const [file, className] = ref.split(":");
+  return { file: path.resolve("/src", file), className };
+}
+
+function jsonSchemaFor(checker, type) {
+  if (type.flags & ts.TypeFlags.StringLike) return { type: "string" };
+  if (type.flags & ts.TypeFlags.NumberLike) return { type: "number" };
+  if (type.flags & ts.TypeFlags.BooleanLike) return { type: "boolean" };
+  if (checker.isArrayType(type)) {
+    const [item] = checker.getTypeArguments(type);
+    return { type: "array", items: jsonSchemaFor(checker, item) };
+  }
+  if (type.isUnion()) {
+    const literals = type.types.filter((t) => t.isStringLiteral() || t.isNumberLiteral());
+    if (literals.length === type.types.length) {
+      return { type: typeof literals[0].value, enum: literals.map((t) => t.value) };
+    }
+    const nonNull = type.types.filter((t) => !(t.flags & (ts.TypeFlags.Undefined | ts.TypeFlags.Null)));
+    if (nonNull.length === 1) return jsonSchemaFor(checker, nonNull[0]);
+    // boolean is the union true | false, so an optional one is true | false | undefined
+    if (nonNull.length > 0 && nonNull.every((t) => t.flags & ts.TypeFlags.BooleanLike)) return { type: "boolean" };
+  }
+  return {};
+}
+
+function unwrapPromise(checker, type) {
+  const symbol = type.getSymbol();
+  if (symbol && symbol.getName() === "Promise") {
+    const [inner] = checker.getTypeArguments(type);
+    return inner;
+  }
+  return type;
+}
+
+export function generateSchema() {
+  const { file, className } = predictorRef();
+  const program = ts.createProgram([file], { allowJs: true, checkJs: false, strict: true });
+  const checker = program.getTypeChecker();
+  const source = program.getSourceFile(file);
+  if (!source) throw new Error(`Could not load ${file}`);
+
+  let predict;
+  ts.forEachChild(source, (node) => {
+    if (ts.isClassDeclaration(node) && node.name?.text === className) {
+      predict = node.members.find((m) => ts.isMethodDeclaration(m) && m.name.getText(source) === "predict");
+    }
+  });
+  if (!predict) throw new Error(`Could not find ${className}.predict() in ${file}`);
+
+  const properties = {};
+  const required = [];
+  const [param] = predict.parameters;
+  if (param) {
+    const inputType = checker.getTypeAtLocation(param);
+    checker.getPropertiesOfType(inputType).forEach((prop, i) => {
+      const propType = checker.getTypeOfSymbolAtLocation(prop, param);
+      const schema = { title: prop.getName(), "x-order": i, ...jsonSchemaFor(checker, propType) };
+      const description = ts.displayPartsToString(prop.getDocumentationComment(checker));
+      if (description) schema.description = description;
+      properties[prop.getName()] = schema;
+      if (!(prop.flags & ts.SymbolFlags.Optional)) required.push(prop.getName());
+    });
+  }
+
+  const signature = checker.getSignatureFromDeclaration(predict);
+  const returnType = unwrapPromise(checker, checker.getReturnTypeOfSignature(signature));
+  const output = { title: "Output", ...jsonSchemaFor(checker, returnType) };
+
+  return {
+    openapi: "3.0.2",
+    info: { title: "Cog", version: "0.1.0" },
+    paths: {
+      "/predictions": {
+        post: {
+          summary: "Predict",
+          operationId: "predict_predictions_post",
+          requestBody: {
+            content: { "application/json": { schema: { $ref: "#/components/schemas/PredictionRequest" } } },
+          },
+          responses: {
+            200: {
+              description: "Successful Response",
+              content: { "application/json": { schema: { $ref: "#/components/schemas/PredictionResponse" } } },
+            },
+          },
+        },
+      },
+    },
+    components: {
+      schemas: {
+        Input: { title: "Input", type: "object", properties, required },
+        Output: output,
+        PredictionRequest: {
+          title: "PredictionRequest",
+          type: "object",
+          properties: { input: { $ref: "#/components/schemas/Input" } },
+        },
+        PredictionResponse: {
+          title: "PredictionResponse",
+          type: "object",
+          properties: { output: { $ref: "#/components/schemas/Output" }, status: { type: "string" } },
+        },
+      },
+    },
+  };
+}
+
+if (import.meta.url === pathToFileURL(process.argv[1]).href) {
+  process.stdout.write(JSON.stringify(generateSchema()));
+}
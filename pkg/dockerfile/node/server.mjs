@@ -0,0 +1,89 @@
+// HTTP server implementing the Cog prediction API for TypeScript/JavaScript
+// predictors. It is run with tsx so TypeScript sources can be imported directly.
+import http from "node:http";
+import { pathToFileURL } from "node:url";
+import { generateSchema, predictorRef } from "./schema.mjs";
+
+let status = "STARTING";
+let predictor;
+let schema;
+
+async function setup() {
+  const { file, className } = predictorRef();
+  const module = await import(pathToFileURL(file).href);
+  const Predictor = module[className];
+  if (!Predictor) throw new Error(`${className} is not exported from ${file}`);
+  predictor = new Predictor();
+  schema = generateSchema();
+  if (typeof predictor.setup === "function") await predictor.setup();
+  status = "READY";
+}
+
+function send(res, code, body) {
+  res.writeHead(code, { "Content-Type": "application/json" });
+  res.end(JSON.stringify(body));
+}
+
+function validate(input) {
+  const { properties, required } = schema.components.schemas.Input;
+  const detail = [];
+  for (const name of required) {
+    if (!(name in input)) detail.push({ loc: ["body", "input", name], msg: "field required", type: "value_error.missing" });
+  }
+  for (const [name, value] of Object.entries(input)) {
+    const prop = properties[name];
+    if (!prop) continue;
+    if (prop.type === "number" && typeof value === "string" && value.trim() !== "" && !isNaN(Number(value))) {
+      input[name] = Number(value);
+    } else if (prop.type === "boolean" && (value === "true" || value === "false")) {
+      input[name] = value === "true";
+    } else if (prop.type && prop.type !== "array" && typeof input[name] !== prop.type) {
+      detail.push({ loc: ["body", "input", name], msg: `value is not a valid ${prop.type}`, type: "type_error" });
+    }
+  }
+  return detail;
+}
+
+async function readBody(req) {
+  const chunks = [];
+  for await (const chunk of req) chunks.push(chunk);
+  return chunks.length ? JSON.parse(Buffer.concat(chunks).toString("utf8")) : {};
+}
+
+const server = http.createServer(async (req, res) => {
+  try {
+    if (req.method === "GET" && req.url === "/health-check") {
+      return send(res, 200, { status });
+    }
+    if (req.method === "GET" && req.url === "/openapi.json") {
+      return send(res, 200, schema ?? generateSchema());
+    }
+    if (req.method === "POST" && req.url === "/predictions") {
+      if (status !== "READY") return send(res, 503, { detail: "Model is not ready" });
+      const body = await readBody(req);
+      const input = body.input ?? {};
+      const detail = validate(input);
+      if (detail.length) return send(res, 422, { detail });
+      const started = new Date().toISOString();
+      try {
+        const output = await predictor.predict(input);
+        return send(res, 200, { status: "succeeded", output, started_at: started, completed_at: new Date().toISOString() });
+      } catch (err) {
+        return send(res, 200, { status: "failed", error: String(err?.message ?? err), output: null });
+      }
+    }
+    return send(res, 404, { detail: "Not Found" });
+  } catch (err) {
+    return send(res, 500, { detail: String(err?.message ?? err) });
+  }
+});
+
+server.listen(Number(process.env.PORT ?? 5000), "0.0.0.0");
+setup().catch((err) => {
+  console.error(err);
+  status = "SETUP_FAILED";
+});
+
+for (const signal of ["SIGINT", "SIGTERM"]) {
+  process.on(signal, () => server.close(() => process.exit(0)));
+}
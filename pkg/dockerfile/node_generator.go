@@ -0,0 +1,238 @@
+package dockerfile
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/dockercontext"
+	"github.com/replicate/cog/pkg/weights"
+)
+
+const NODE_GENERATOR_NAME = "NODE_GENERATOR"
+const NodeRuntimeDir = "/opt/cog/node"
+
+// NodeTsxPath is the TypeScript runner used to run the server and schema extractor
+const NodeTsxPath = NodeRuntimeDir + "/node_modules/.bin/tsx"
+
+//go:embed node/*.mjs
+var nodeRuntime embed.FS
+
+var errNodeSeparateWeights = errors.New("Separate weights are not supported for Node predictors")
+
+// NodeGenerator generates Dockerfiles for TypeScript and JavaScript predictors.
+type NodeGenerator struct {
+	Config *config.Config
+	Dir    string
+
+	// absolute path to tmpDir, a directory that will be cleaned up
+	tmpDir string
+	// tmpDir relative to Dir, with forward slashes so it can be used in the Dockerfile
+	relativeTmpDir string
+}
+
+func NewNodeGenerator(config *config.Config, dir string) (*NodeGenerator, error) {
+	tmpDir, relativeTmpDir, err := newTmpDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &NodeGenerator{
+		Config:         config,
+		Dir:            dir,
+		tmpDir:         tmpDir,
+		relativeTmpDir: relativeTmpDir,
+	}, nil
+}
+
+func (g *NodeGenerator) GenerateInitialSteps() (string, error) {
+	steps, err := g.initialSteps()
+	if err != nil {
+		return "", err
+	}
+	return joinSteps(steps), nil
+}
+
+func (g *NodeGenerator) initialSteps() ([]step, error) {
+	baseImage, err := g.BaseImage()
+	if err != nil {
+		return nil, err
+	}
+	systemPackages, err := systemPackageSteps(g.Config)
+	if err != nil {
+		return nil, err
+	}
+	installRuntime, err := g.installRuntime()
+	if err != nil {
+		return nil, err
+	}
+	runCommands, err := runItemSteps(g.Config)
+	if err != nil {
+		return nil, err
+	}
+	steps := []step{
+		newStep("#syntax=docker/dockerfile:1.4", "Enables BuildKit features like cache and secret mounts"),
+		newStep("FROM "+baseImage, "The base image for the model's Node.js version, or CUDA version if it uses a GPU", "build.gpu", "build.cuda", "build.node_version"),
+	}
+	// Node.js is installed before the extra apt repositories are added, so they can't replace it
+	steps = append(steps, systemPackages[:2]...)
+	steps = append(steps, newStep(g.installNode(), "Installs Node.js, because the CUDA base image doesn't have it", "build.gpu", "build.node_version"))
+	steps = append(steps, systemPackages[2:]...)
+	steps = append(steps,
+		newStep(installRuntime, "Installs the Cog server for Node.js predictors"),
+		newStep("ENV COG_NODE_PREDICTOR="+g.Config.Predict, "Tells the Cog server where the predictor is", "predict"),
+		newStep(g.npmInstall(), "Installs the packages in package.json"),
+	)
+	return append(steps, runCommands...), nil
+}
+
+func (g *NodeGenerator) modelBaseSteps() ([]step, error) {
+	steps, err := g.initialSteps()
+	if err != nil {
+		return nil, err
+	}
+	return append(steps, serverSteps(newStep(`CMD ["`+NodeTsxPath+`", "`+NodeRuntimeDir+`/server.mjs"]`, "Starts the Cog server for Node.js predictors", "predict"))...), nil
+}
+
+func (g *NodeGenerator) GenerateModelBase() (string, error) {
+	steps, err := g.modelBaseSteps()
+	if err != nil {
+		return "", err
+	}
+	return joinSteps(steps), nil
+}
+
+func (g *NodeGenerator) GenerateDockerfileWithoutSeparateWeights() (string, error) {
+	steps, err := g.modelBaseSteps()
+	if err != nil {
+		return "", err
+	}
+	return joinSteps(append(steps, copySteps(g.Config)...)), nil
+}
+
+// Explain describes each instruction in the Dockerfile that GenerateDockerfileWithoutSeparateWeights generates,
+// and the cog.yaml fields that caused it
+func (g *NodeGenerator) Explain() (*Explanation, error) {
+	steps, err := g.modelBaseSteps()
+	if err != nil {
+		return nil, err
+	}
+	return explainSteps(g.Name(), append(steps, copySteps(g.Config)...)), nil
+}
+
+func (g *NodeGenerator) GenerateModelBaseWithSeparateWeights(imageName string) (string, string, string, error) {
+	return "", "", "", errNodeSeparateWeights
+}
+
+func (g *NodeGenerator) GenerateWeightsManifest() (*weights.Manifest, error) {
+	return nil, errNodeSeparateWeights
+}
+
+func (g *NodeGenerator) BaseImage() (string, error) {
+	if g.Config.Build.GPU {
+		baseImage, err := g.Config.CUDABaseImageTag()
+		return pinBaseImage(g.Config, baseImage), err
+	}
+	return pinBaseImage(g.Config, "node:"+g.Config.Build.NodeVersionOrDefault()+"-slim"), nil
+}
+
+func (g *NodeGenerator) Cleanup() error {
+	if err := dockercontext.RemoveAll(g.tmpDir); err != nil {
+		return fmt.Errorf("Failed to clean up %s: %w", g.tmpDir, err)
+	}
+	return nil
+}
+
+func (g *NodeGenerator) IsUsingCogBaseImage() bool {
+	return false
+}
+
+func (g *NodeGenerator) SetUseCogBaseImage(useCogBaseImage bool) {}
+
+func (g *NodeGenerator) SetUseCogBaseImagePtr(useCogBaseImage *bool) {}
+
+func (g *NodeGenerator) SetUseCudaBaseImage(argumentValue string) {}
+
+func (g *NodeGenerator) SetStrip(strip bool) {}
+
+func (g *NodeGenerator) SetPrecompile(precompile bool) {}
+
+func (g *NodeGenerator) Name() string {
+	return NODE_GENERATOR_NAME
+}
+
+func (g *NodeGenerator) BuildDir() (string, error) {
+	return dockercontext.StandardBuildDirectory, nil
+}
+
+func (g *NodeGenerator) BuildContexts() (map[string]string, error) {
+	contexts, err := ConfigBuildContexts(g.Config, g.Dir)
+	if err != nil {
+		return nil, err
+	}
+	return tmpDirBuildContexts(contexts, g.tmpDir, g.relativeTmpDir), nil
+}
+
+func (g *NodeGenerator) installNode() string {
+	if !g.Config.Build.GPU {
+		return ""
+	}
+	// CUDA base images don't come with Node.js
+	return fmt.Sprintf(`RUN --mount=type=cache,target=/var/cache/apt,sharing=locked apt-get update -qq && apt-get install -qqy --no-install-recommends curl ca-certificates && \
+curl -fsSL https://deb.nodesource.com/setup_%s.x | bash - && \
+apt-get install -qqy nodejs && rm -rf /var/lib/apt/lists/*`, g.Config.Build.NodeVersionOrDefault())
+}
+
+// aptInstallCommand returns the RUN instruction installing packages, or an empty string if there are none
+func aptInstallCommand(packages []string) string {
+	if len(packages) == 0 {
+		return ""
+	}
+	return "RUN --mount=type=cache,target=/var/cache/apt,sharing=locked apt-get update -qq && apt-get install -qqy " +
+		strings.Join(packages, " ") +
+		" && rm -rf /var/lib/apt/lists/*"
+}
+
+// installRuntime copies the Cog server and schema extractor into the image, along with the packages they need
+func (g *NodeGenerator) installRuntime() (string, error) {
+	files, err := nodeRuntime.ReadDir("node")
+	if err != nil {
+		return "", err
+	}
+	runtimeDir := filepath.Join(g.tmpDir, "cog-node")
+	if err := os.MkdirAll(runtimeDir, 0o755); err != nil {
+		return "", err
+	}
+	for _, f := range files {
+		data, err := nodeRuntime.ReadFile(path.Join("node", f.Name()))
+		if err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(filepath.Join(runtimeDir, f.Name()), data, 0o644); err != nil {
+			return "", fmt.Errorf("Failed to write %s: %w", f.Name(), err)
+		}
+	}
+	return strings.Join([]string{
+		copyFromTmpDir(g.relativeTmpDir, "cog-node", NodeRuntimeDir),
+		"RUN --mount=type=cache,target=/root/.npm npm install --prefix " + NodeRuntimeDir + " --no-save tsx typescript",
+	}, "\n"), nil
+}
+
+func (g *NodeGenerator) npmInstall() string {
+	if _, err := os.Stat(filepath.Join(g.Dir, "package.json")); err != nil {
+		return ""
+	}
+	install := "npm install"
+	if _, err := os.Stat(filepath.Join(g.Dir, "package-lock.json")); err == nil {
+		install = "npm ci"
+	}
+	return strings.Join([]string{
+		"WORKDIR /src",
+		"COPY package*.json /src/",
+		"RUN --mount=type=cache,target=/root/.npm " + install,
+	}, "\n")
+}
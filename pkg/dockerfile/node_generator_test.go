@@ -0,0 +1,65 @@
+package dockerfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/docker/dockertest"
+)
+
+func TestGeneratorFactoryNodeGenerator(t *testing.T) {
+	dir := t.TempDir()
+	conf := config.Config{
+		Build:   &config.Build{},
+		Predict: "predict.ts:Predictor",
+	}
+	generator, err := NewGenerator(&conf, dir, false, dockertest.NewMockCommand(), false)
+	require.NoError(t, err)
+	require.Equal(t, NODE_GENERATOR_NAME, generator.Name())
+}
+
+func TestNodeGenerateDockerfile(t *testing.T) {
+	tmpDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "package.json"), []byte("{}"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "package-lock.json"), []byte("{}"), 0o644))
+
+	conf, err := config.FromYAML([]byte(`
+build:
+  node_version: "22"
+  system_packages:
+    - ffmpeg
+predict: predict.ts:Predictor
+`))
+	require.NoError(t, err)
+	require.NoError(t, conf.ValidateAndComplete(""))
+
+	gen, err := NewNodeGenerator(conf, tmpDir)
+	require.NoError(t, err)
+	actual, err := gen.GenerateDockerfileWithoutSeparateWeights()
+	require.NoError(t, err)
+
+	expected := `#syntax=docker/dockerfile:1.4
+FROM node:22-slim
+ENV DEBIAN_FRONTEND=noninteractive
+RUN --mount=type=cache,target=/var/cache/apt,sharing=locked apt-get update -qq && apt-get install -qqy ffmpeg && rm -rf /var/lib/apt/lists/*
+COPY ` + gen.relativeTmpDir + `/cog-node /opt/cog/node
+RUN --mount=type=cache,target=/root/.npm npm install --prefix /opt/cog/node --no-save tsx typescript
+ENV COG_NODE_PREDICTOR=predict.ts:Predictor
+WORKDIR /src
+COPY package*.json /src/
+RUN --mount=type=cache,target=/root/.npm npm ci
+WORKDIR /src
+EXPOSE 5000
+CMD ["/opt/cog/node/node_modules/.bin/tsx", "/opt/cog/node/server.mjs"]
+COPY . /src`
+	require.Equal(t, expected, actual)
+	require.FileExists(t, filepath.Join(gen.tmpDir, "cog-node", "server.mjs"))
+	require.FileExists(t, filepath.Join(gen.tmpDir, "cog-node", "schema.mjs"))
+
+	_, _, _, err = gen.GenerateModelBaseWithSeparateWeights("test")
+	require.Error(t, err)
+}
@@ -0,0 +1,62 @@
+package dockerfile
+
+import (
+	"strings"
+
+	"github.com/replicate/cog/pkg/config"
+)
+
+// PipNetrcSecretID is the build secret pip and uv read credentials for private package indexes from, in netrc format.
+// Pass it with `cog build --secret id=pip-netrc,src=$HOME/.netrc`. It's optional, so builds work without it.
+const PipNetrcSecretID = "pip-netrc"
+
+const pipNetrcMount = "--mount=type=secret,id=" + PipNetrcSecretID + ",target=/root/.netrc"
+
+func hasPipIndexConfig(cfg *config.Config) bool {
+	return cfg.Build.PipIndexURL != "" || len(cfg.Build.PipExtraIndexURLs) > 0 || len(cfg.Build.PipTrustedHosts) > 0
+}
+
+// pipInstallCommand returns the start of a RUN instruction that runs pip install with the package indexes
+// and SSH agent forwarding in cog.yaml
+func pipInstallCommand(cfg *config.Config) string {
+	return pipCommand(cfg, "install", nil)
+}
+
+// pipCommand returns the start of a RUN instruction that runs a pip subcommand that downloads packages,
+// with the package indexes and SSH agent forwarding in cog.yaml and any extra environment variables
+func pipCommand(cfg *config.Config, subcommand string, extraEnv []string) string {
+	mounts, env := pipMountsAndEnv(cfg)
+	args := append([]string{"RUN --mount=type=cache,target=/root/.cache/pip"}, mounts...)
+	args = append(args, env...)
+	args = append(args, extraEnv...)
+	args = append(args, "pip "+subcommand)
+	if cfg.Build.PipIndexURL != "" {
+		args = append(args, "--index-url", shellQuote(cfg.Build.PipIndexURL))
+	}
+	for _, url := range cfg.Build.PipExtraIndexURLs {
+		args = append(args, "--extra-index-url", shellQuote(url))
+	}
+	for _, host := range cfg.Build.PipTrustedHosts {
+		args = append(args, "--trusted-host", shellQuote(host))
+	}
+	return strings.Join(args, " ")
+}
+
+// uvIndexEnv returns the environment variables that configure uv with the package indexes in cog.yaml
+func uvIndexEnv(cfg *config.Config) string {
+	env := []string{}
+	if cfg.Build.PipIndexURL != "" {
+		env = append(env, "UV_INDEX_URL="+shellQuote(cfg.Build.PipIndexURL))
+	}
+	if len(cfg.Build.PipExtraIndexURLs) > 0 {
+		env = append(env, "UV_EXTRA_INDEX_URL="+shellQuote(strings.Join(cfg.Build.PipExtraIndexURLs, " ")))
+	}
+	if len(cfg.Build.PipTrustedHosts) > 0 {
+		env = append(env, "UV_INSECURE_HOST="+shellQuote(strings.Join(cfg.Build.PipTrustedHosts, " ")))
+	}
+	return strings.Join(env, " ")
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
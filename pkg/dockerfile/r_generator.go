@@ -0,0 +1,213 @@
+package dockerfile
+
+import (
+	// blank import for embeds
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/dockercontext"
+	"github.com/replicate/cog/pkg/weights"
+)
+
+const R_GENERATOR_NAME = "R_GENERATOR"
+const RServerPath = "/opt/cog/r/server.R"
+
+//go:embed r/server.R
+var rServer []byte
+
+var errRSeparateWeights = errors.New("Separate weights are not supported for R predictors")
+
+// RGenerator generates Dockerfiles for R predictors, served with plumber.
+type RGenerator struct {
+	Config *config.Config
+	Dir    string
+
+	// absolute path to tmpDir, a directory that will be cleaned up
+	tmpDir string
+	// tmpDir relative to Dir, with forward slashes so it can be used in the Dockerfile
+	relativeTmpDir string
+}
+
+func NewRGenerator(config *config.Config, dir string) (*RGenerator, error) {
+	tmpDir, relativeTmpDir, err := newTmpDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &RGenerator{
+		Config:         config,
+		Dir:            dir,
+		tmpDir:         tmpDir,
+		relativeTmpDir: relativeTmpDir,
+	}, nil
+}
+
+func (g *RGenerator) GenerateInitialSteps() (string, error) {
+	steps, err := g.initialSteps()
+	if err != nil {
+		return "", err
+	}
+	return joinSteps(steps), nil
+}
+
+func (g *RGenerator) initialSteps() ([]step, error) {
+	baseImage, err := g.BaseImage()
+	if err != nil {
+		return nil, err
+	}
+	systemPackages, err := systemPackageSteps(g.Config)
+	if err != nil {
+		return nil, err
+	}
+	installServer, err := g.installServer()
+	if err != nil {
+		return nil, err
+	}
+	runCommands, err := runItemSteps(g.Config)
+	if err != nil {
+		return nil, err
+	}
+	schema := g.Config.Build.OpenAPISchema
+	if schema == "" {
+		schema = "schema.json"
+	}
+	steps := []step{
+		newStep("#syntax=docker/dockerfile:1.4", "Enables BuildKit features like cache and secret mounts"),
+		newStep("FROM "+baseImage, "The base image for the model's R version", "build.r_version"),
+	}
+	steps = append(steps, systemPackages...)
+	steps = append(steps,
+		newStep(rInstallCommand([]string{"plumber", "jsonlite", "remotes"}), "Installs the R packages the Cog server needs"),
+		newStep(g.rPackageInstalls(), "Installs the model's R packages", "build.r_packages"),
+		newStep(installServer, "Installs the Cog server for R predictors"),
+		newStep("ENV COG_R_PREDICTOR="+g.Config.Predict, "Tells the Cog server where the predictor is", "predict"),
+		newStep("ENV COG_R_SCHEMA="+filepath.ToSlash(filepath.Join("/src", schema)), "Tells the Cog server where the model's schema is", "build.openapi_schema"),
+	)
+	return append(steps, runCommands...), nil
+}
+
+func (g *RGenerator) modelBaseSteps() ([]step, error) {
+	steps, err := g.initialSteps()
+	if err != nil {
+		return nil, err
+	}
+	return append(steps, serverSteps(newStep(`CMD ["Rscript", "`+RServerPath+`"]`, "Starts the Cog server for R predictors"))...), nil
+}
+
+func (g *RGenerator) GenerateModelBase() (string, error) {
+	steps, err := g.modelBaseSteps()
+	if err != nil {
+		return "", err
+	}
+	return joinSteps(steps), nil
+}
+
+func (g *RGenerator) GenerateDockerfileWithoutSeparateWeights() (string, error) {
+	steps, err := g.modelBaseSteps()
+	if err != nil {
+		return "", err
+	}
+	return joinSteps(append(steps, copySteps(g.Config)...)), nil
+}
+
+// Explain describes each instruction in the Dockerfile that GenerateDockerfileWithoutSeparateWeights generates,
+// and the cog.yaml fields that caused it
+func (g *RGenerator) Explain() (*Explanation, error) {
+	steps, err := g.modelBaseSteps()
+	if err != nil {
+		return nil, err
+	}
+	return explainSteps(g.Name(), append(steps, copySteps(g.Config)...)), nil
+}
+
+func (g *RGenerator) GenerateModelBaseWithSeparateWeights(imageName string) (string, string, string, error) {
+	return "", "", "", errRSeparateWeights
+}
+
+func (g *RGenerator) GenerateWeightsManifest() (*weights.Manifest, error) {
+	return nil, errRSeparateWeights
+}
+
+func (g *RGenerator) BaseImage() (string, error) {
+	image := "rocker/r-ver:" + g.Config.Build.RVersionOrDefault()
+	if g.Config.Build.GPU {
+		// The rocker CUDA images are tagged with the R version and include CUDA
+		image = "rocker/cuda:" + g.Config.Build.RVersionOrDefault()
+	}
+	return pinBaseImage(g.Config, image), nil
+}
+
+func (g *RGenerator) Cleanup() error {
+	if err := dockercontext.RemoveAll(g.tmpDir); err != nil {
+		return fmt.Errorf("Failed to clean up %s: %w", g.tmpDir, err)
+	}
+	return nil
+}
+
+func (g *RGenerator) IsUsingCogBaseImage() bool {
+	return false
+}
+
+func (g *RGenerator) SetUseCogBaseImage(useCogBaseImage bool) {}
+
+func (g *RGenerator) SetUseCogBaseImagePtr(useCogBaseImage *bool) {}
+
+func (g *RGenerator) SetUseCudaBaseImage(argumentValue string) {}
+
+func (g *RGenerator) SetStrip(strip bool) {}
+
+func (g *RGenerator) SetPrecompile(precompile bool) {}
+
+func (g *RGenerator) Name() string {
+	return R_GENERATOR_NAME
+}
+
+func (g *RGenerator) BuildDir() (string, error) {
+	return dockercontext.StandardBuildDirectory, nil
+}
+
+func (g *RGenerator) BuildContexts() (map[string]string, error) {
+	contexts, err := ConfigBuildContexts(g.Config, g.Dir)
+	if err != nil {
+		return nil, err
+	}
+	return tmpDirBuildContexts(contexts, g.tmpDir, g.relativeTmpDir), nil
+}
+
+func (g *RGenerator) installServer() (string, error) {
+	serverPath := filepath.Join(g.tmpDir, "server.R")
+	if err := os.WriteFile(serverPath, rServer, 0o644); err != nil {
+		return "", fmt.Errorf("Failed to write server.R: %w", err)
+	}
+	return copyFromTmpDir(g.relativeTmpDir, "server.R", RServerPath), nil
+}
+
+// rPackageInstalls installs r_packages from cog.yaml. Packages pinned with package==version are installed with remotes::install_version.
+func (g *RGenerator) rPackageInstalls() string {
+	unpinned := []string{}
+	lines := []string{}
+	for _, pkg := range g.Config.Build.RPackages {
+		name, version, pinned := strings.Cut(pkg, "==")
+		if !pinned {
+			unpinned = append(unpinned, strings.TrimSpace(pkg))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf(`RUN Rscript -e 'remotes::install_version("%s", version = "%s", repos = "https://cloud.r-project.org")'`, strings.TrimSpace(name), strings.TrimSpace(version)))
+	}
+	return strings.Join(append([]string{rInstallCommand(unpinned)}, lines...), "\n")
+}
+
+func rInstallCommand(packages []string) string {
+	if len(packages) == 0 {
+		return ""
+	}
+	quoted := []string{}
+	for _, pkg := range packages {
+		quoted = append(quoted, `"`+pkg+`"`)
+	}
+	return fmt.Sprintf(`RUN Rscript -e 'install.packages(c(%s), repos = "https://cloud.r-project.org")'`, strings.Join(quoted, ", "))
+}
@@ -0,0 +1,49 @@
+package dockerfile
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/config"
+)
+
+func TestRGenerateDockerfile(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	conf, err := config.FromYAML([]byte(`
+build:
+  r_version: "4.3.2"
+  r_packages:
+    - ranger
+    - xgboost==1.7.8.1
+  openapi_schema: cog/schema.json
+predict: predict.R:predict
+`))
+	require.NoError(t, err)
+	require.NoError(t, conf.ValidateAndComplete(""))
+	require.Equal(t, config.LanguageR, conf.PredictorLanguage())
+	require.True(t, conf.RequiresSchemaFile())
+
+	gen, err := NewRGenerator(conf, tmpDir)
+	require.NoError(t, err)
+	actual, err := gen.GenerateDockerfileWithoutSeparateWeights()
+	require.NoError(t, err)
+
+	expected := `#syntax=docker/dockerfile:1.4
+FROM rocker/r-ver:4.3.2
+ENV DEBIAN_FRONTEND=noninteractive
+RUN Rscript -e 'install.packages(c("plumber", "jsonlite", "remotes"), repos = "https://cloud.r-project.org")'
+RUN Rscript -e 'install.packages(c("ranger"), repos = "https://cloud.r-project.org")'
+RUN Rscript -e 'remotes::install_version("xgboost", version = "1.7.8.1", repos = "https://cloud.r-project.org")'
+COPY ` + gen.relativeTmpDir + `/server.R /opt/cog/r/server.R
+ENV COG_R_PREDICTOR=predict.R:predict
+ENV COG_R_SCHEMA=/src/cog/schema.json
+WORKDIR /src
+EXPOSE 5000
+CMD ["Rscript", "/opt/cog/r/server.R"]
+COPY . /src`
+	require.Equal(t, expected, actual)
+	require.FileExists(t, filepath.Join(gen.tmpDir, "server.R"))
+}
@@ -0,0 +1,137 @@
+package dockerfile
+
+import (
+	"errors"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/dockercontext"
+	"github.com/replicate/cog/pkg/weights"
+)
+
+const SERVER_GENERATOR_NAME = "SERVER_GENERATOR"
+const DefaultServerBaseImage = "debian:bookworm-slim"
+
+var errServerSeparateWeights = errors.New("Separate weights are not supported with build.server_command")
+
+// ServerGenerator generates Dockerfiles for models which bring their own HTTP server with build.server_command.
+// None of the Cog Python scaffolding is installed.
+type ServerGenerator struct {
+	Config *config.Config
+	Dir    string
+}
+
+func NewServerGenerator(config *config.Config, dir string) (*ServerGenerator, error) {
+	return &ServerGenerator{
+		Config: config,
+		Dir:    dir,
+	}, nil
+}
+
+func (g *ServerGenerator) GenerateInitialSteps() (string, error) {
+	steps, err := g.initialSteps()
+	if err != nil {
+		return "", err
+	}
+	return joinSteps(steps), nil
+}
+
+func (g *ServerGenerator) initialSteps() ([]step, error) {
+	baseImage, err := g.BaseImage()
+	if err != nil {
+		return nil, err
+	}
+	systemPackages, err := systemPackageSteps(g.Config)
+	if err != nil {
+		return nil, err
+	}
+	runCommands, err := runItemSteps(g.Config)
+	if err != nil {
+		return nil, err
+	}
+	steps := []step{
+		newStep("#syntax=docker/dockerfile:1.4", "Enables BuildKit features like cache and secret mounts"),
+		newStep("FROM "+baseImage, "The base image for a model with its own server", "build.gpu", "build.cuda"),
+	}
+	steps = append(steps, systemPackages...)
+	return append(steps, runCommands...), nil
+}
+
+func (g *ServerGenerator) modelBaseSteps() ([]step, error) {
+	steps, err := g.initialSteps()
+	if err != nil {
+		return nil, err
+	}
+	return append(steps, serverSteps(newStep("CMD "+g.Config.Build.ServerCommand, "Starts the model's own HTTP server", "build.server_command"))...), nil
+}
+
+func (g *ServerGenerator) GenerateModelBase() (string, error) {
+	steps, err := g.modelBaseSteps()
+	if err != nil {
+		return "", err
+	}
+	return joinSteps(steps), nil
+}
+
+func (g *ServerGenerator) GenerateDockerfileWithoutSeparateWeights() (string, error) {
+	steps, err := g.modelBaseSteps()
+	if err != nil {
+		return "", err
+	}
+	return joinSteps(append(steps, copySteps(g.Config)...)), nil
+}
+
+// Explain describes each instruction in the Dockerfile that GenerateDockerfileWithoutSeparateWeights generates,
+// and the cog.yaml fields that caused it
+func (g *ServerGenerator) Explain() (*Explanation, error) {
+	steps, err := g.modelBaseSteps()
+	if err != nil {
+		return nil, err
+	}
+	return explainSteps(g.Name(), append(steps, copySteps(g.Config)...)), nil
+}
+
+func (g *ServerGenerator) GenerateModelBaseWithSeparateWeights(imageName string) (string, string, string, error) {
+	return "", "", "", errServerSeparateWeights
+}
+
+func (g *ServerGenerator) GenerateWeightsManifest() (*weights.Manifest, error) {
+	return nil, errServerSeparateWeights
+}
+
+func (g *ServerGenerator) BaseImage() (string, error) {
+	if g.Config.Build.GPU {
+		baseImage, err := g.Config.CUDABaseImageTag()
+		return pinBaseImage(g.Config, baseImage), err
+	}
+	return pinBaseImage(g.Config, DefaultServerBaseImage), nil
+}
+
+func (g *ServerGenerator) Cleanup() error {
+	return nil
+}
+
+func (g *ServerGenerator) IsUsingCogBaseImage() bool {
+	return false
+}
+
+func (g *ServerGenerator) SetUseCogBaseImage(useCogBaseImage bool) {}
+
+func (g *ServerGenerator) SetUseCogBaseImagePtr(useCogBaseImage *bool) {}
+
+func (g *ServerGenerator) SetUseCudaBaseImage(argumentValue string) {}
+
+func (g *ServerGenerator) SetStrip(strip bool) {}
+
+func (g *ServerGenerator) SetPrecompile(precompile bool) {}
+
+func (g *ServerGenerator) Name() string {
+	return SERVER_GENERATOR_NAME
+}
+
+func (g *ServerGenerator) BuildDir() (string, error) {
+	return dockercontext.StandardBuildDirectory, nil
+}
+
+func (g *ServerGenerator) BuildContexts() (map[string]string, error) {
+	return ConfigBuildContexts(g.Config, g.Dir)
+}
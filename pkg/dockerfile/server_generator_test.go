@@ -0,0 +1,49 @@
+package dockerfile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/docker/dockertest"
+)
+
+func TestGeneratorFactoryServerGenerator(t *testing.T) {
+	conf := config.Config{
+		Build: &config.Build{ServerCommand: "./server"},
+	}
+	generator, err := NewGenerator(&conf, t.TempDir(), false, dockertest.NewMockCommand(), false)
+	require.NoError(t, err)
+	require.Equal(t, SERVER_GENERATOR_NAME, generator.Name())
+}
+
+func TestServerGenerateDockerfile(t *testing.T) {
+	conf, err := config.FromYAML([]byte(`
+build:
+  system_packages:
+    - build-essential
+  run:
+    - make server
+  server_command: ./server --port 5000
+  openapi_schema: schema.json
+`))
+	require.NoError(t, err)
+	require.NoError(t, conf.ValidateAndComplete(""))
+
+	gen, err := NewServerGenerator(conf, t.TempDir())
+	require.NoError(t, err)
+	actual, err := gen.GenerateDockerfileWithoutSeparateWeights()
+	require.NoError(t, err)
+
+	expected := `#syntax=docker/dockerfile:1.4
+FROM debian:bookworm-slim
+ENV DEBIAN_FRONTEND=noninteractive
+RUN --mount=type=cache,target=/var/cache/apt,sharing=locked apt-get update -qq && apt-get install -qqy build-essential && rm -rf /var/lib/apt/lists/*
+RUN make server
+WORKDIR /src
+EXPOSE 5000
+CMD ./server --port 5000
+COPY . /src`
+	require.Equal(t, expected, actual)
+}
@@ -0,0 +1,39 @@
+# Predictor used when cog.yaml sets `serving_backend: vllm`. It is generated
+# into the image by Cog; the model and engine arguments come from cog.yaml.
+import dataclasses
+import json
+import os
+
+from cog import BasePredictor, Input
+
+
+class Predictor(BasePredictor):
+    def setup(self) -> None:
+        from vllm import LLM
+        from vllm.engine.arg_utils import EngineArgs
+        from vllm.utils import FlexibleArgumentParser
+
+        args = ["--model", os.environ["COG_SERVING_MODEL"]]
+        args += json.loads(os.environ.get("COG_SERVING_ARGS", "[]"))
+        parser = EngineArgs.add_cli_args(FlexibleArgumentParser())
+        engine_args = EngineArgs.from_cli_args(parser.parse_args(args))
+        self.llm = LLM(**dataclasses.asdict(engine_args))
+
+    def predict(
+        self,
+        prompt: str = Input(description="Prompt to complete"),
+        max_tokens: int = Input(description="Maximum number of tokens to generate", default=512, ge=1),
+        temperature: float = Input(description="Sampling temperature", default=0.7, ge=0),
+        top_p: float = Input(description="Nucleus sampling probability", default=0.95, gt=0, le=1),
+        seed: int = Input(description="Random seed, -1 for random", default=-1),
+    ) -> str:
+        from vllm import SamplingParams
+
+        params = SamplingParams(
+            max_tokens=max_tokens,
+            temperature=temperature,
+            top_p=top_p,
+            seed=None if seed < 0 else seed,
+        )
+        outputs = self.llm.generate([prompt], params)
+        return outputs[0].outputs[0].text
@@ -0,0 +1,66 @@
+package dockerfile
+
+import (
+	_ "embed"
+	"strings"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/util/slices"
+)
+
+// SSHKnownHostsSecretID is the build secret with the host keys of other git servers, in known_hosts format. Pass it
+// with `cog build --secret id=ssh-known-hosts,src=$HOME/.ssh/known_hosts`. It's optional, so builds from GitHub,
+// GitLab and Bitbucket work without it.
+const SSHKnownHostsSecretID = "ssh-known-hosts"
+
+// sshMount forwards the SSH agent passed with `docker build --ssh default` into a RUN instruction
+const sshMount = "--mount=type=ssh"
+
+const sshKnownHostsMount = "--mount=type=secret,id=" + SSHKnownHostsSecretID + ",target=/root/.ssh/known_hosts"
+
+// gitSSHEnv makes git only connect to hosts whose keys are in /etc/ssh/ssh_known_hosts or the ssh-known-hosts secret
+const gitSSHEnv = "GIT_SSH_COMMAND='ssh -o StrictHostKeyChecking=yes'"
+
+// forgeKnownHosts are the host keys that GitHub, GitLab and Bitbucket publish
+//
+//go:embed known_hosts
+var forgeKnownHosts string
+
+// sshSystemPackages are needed to clone git repositories over SSH
+var sshSystemPackages = []string{"git", "openssh-client"}
+
+// systemPackages returns the system packages in cog.yaml, and the ones SSH agent forwarding needs if it's enabled
+func systemPackages(cfg *config.Config) []string {
+	packages := append([]string{}, cfg.Build.SystemPackages...)
+	if cfg.Build.SSH {
+		for _, pkg := range sshSystemPackages {
+			if !slices.ContainsString(packages, pkg) {
+				packages = append(packages, pkg)
+			}
+		}
+	}
+	return packages
+}
+
+// sshKnownHostsCommand returns the RUN instruction that adds the host keys in forgeKnownHosts to the image's
+// known hosts
+func sshKnownHostsCommand() string {
+	lines := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(forgeKnownHosts), "\n") {
+		lines = append(lines, shellQuote(line))
+	}
+	return "RUN mkdir -p /etc/ssh && printf '%s\\n' " + strings.Join(lines, " ") + " >> /etc/ssh/ssh_known_hosts"
+}
+
+// pipMountsAndEnv returns the extra mounts and environment variables that package installs need for the
+// package indexes and SSH agent forwarding configured in cog.yaml
+func pipMountsAndEnv(cfg *config.Config) (mounts []string, env []string) {
+	if hasPipIndexConfig(cfg) {
+		mounts = append(mounts, pipNetrcMount)
+	}
+	if cfg.Build.SSH {
+		mounts = append(mounts, sshMount, sshKnownHostsMount)
+		env = append(env, gitSSHEnv)
+	}
+	return mounts, env
+}
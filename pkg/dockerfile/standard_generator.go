@@ -1,16 +1,21 @@
 package dockerfile
 
 import (
+	// blank import for embeds
+	_ "embed"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 
 	"github.com/replicate/cog/pkg/config"
 	"github.com/replicate/cog/pkg/docker/command"
 	"github.com/replicate/cog/pkg/dockercontext"
+	"github.com/replicate/cog/pkg/lfs"
 	"github.com/replicate/cog/pkg/util/console"
 	"github.com/replicate/cog/pkg/util/slices"
 	"github.com/replicate/cog/pkg/util/version"
@@ -44,6 +49,16 @@ const StripDebugSymbolsCommand = "find / -type f -name \"*python*.so\" -not -nam
 const CFlags = "ENV CFLAGS=\"-O3 -funroll-loops -fno-strict-aliasing -flto -S\""
 const PrecompilePythonCommand = "RUN find / -type f -name \"*.py[co]\" -delete && find / -type f -name \"*.py\" -exec touch -t 197001010000 {} \\; && find / -type f -name \"*.py\" -printf \"%h\\n\" | sort -u | /usr/bin/python3 -m compileall --invalidation-mode timestamp -o 2 -j 0"
 const STANDARD_GENERATOR_NAME = "STANDARD_GENERATOR"
+const ServingPredictorPath = "/opt/cog/serving/predictor.py"
+const servingPredictorFilename = "serving_predictor.py"
+const pipelinePredictorFilename = "pipeline_predictor.py"
+
+// pipelinePredictor is the predictor generated into the image for models with a `pipeline` in cog.yaml. The runner
+// is part of the Cog Python package, and reads the steps from cog.yaml.
+const pipelinePredictor = "from cog.pipeline import Pipeline as Predictor\n"
+
+//go:embed serving_vllm.py
+var servingVLLMPredictor []byte
 
 type StandardGenerator struct {
 	Config *config.Config
@@ -60,7 +75,7 @@ type StandardGenerator struct {
 
 	// absolute path to tmpDir, a directory that will be cleaned up
 	tmpDir string
-	// tmpDir relative to Dir
+	// tmpDir relative to Dir, with forward slashes so it can be used in the Dockerfile, or "" if it is outside Dir
 	relativeTmpDir string
 
 	fileWalker weights.FileWalker
@@ -73,12 +88,7 @@ type StandardGenerator struct {
 }
 
 func NewStandardGenerator(config *config.Config, dir string, command command.Command) (*StandardGenerator, error) {
-	tmpDir, err := dockercontext.BuildTempDir(dir)
-	if err != nil {
-		return nil, err
-	}
-	// tmpDir, but without dir prefix. This is the path used in the Dockerfile.
-	relativeTmpDir, err := filepath.Rel(dir, tmpDir)
+	tmpDir, relativeTmpDir, err := newTmpDir(dir)
 	if err != nil {
 		return nil, err
 	}
@@ -130,88 +140,189 @@ func (g *StandardGenerator) SetPrecompile(precompile bool) {
 }
 
 func (g *StandardGenerator) GenerateInitialSteps() (string, error) {
-	baseImage, err := g.BaseImage()
+	steps, err := g.initialSteps()
 	if err != nil {
 		return "", err
 	}
+	return joinSteps(steps), nil
+}
+
+func (g *StandardGenerator) initialSteps() ([]step, error) {
+	baseImage, err := g.BaseImage()
+	if err != nil {
+		return nil, err
+	}
+	cleanup, err := g.cleanup()
+	if err != nil {
+		return nil, err
+	}
 	installPython, err := g.installPython()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	aptInstalls, err := g.aptInstalls()
+	aptInstalls, err := g.aptSteps()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	runCommands, err := g.runCommands()
+	runCommands, err := g.runSteps()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	pipInstalls, err := g.pipInstalls()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	installCog, err := g.installCog()
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	servingBackend, err := g.servingBackend()
+	if err != nil {
+		return nil, err
+	}
+	pipeline, err := g.pipeline()
+	if err != nil {
+		return nil, err
+	}
+	localPackageInstalls, err := g.localPackageInstalls()
+	if err != nil {
+		return nil, err
 	}
 
+	precompile := g.precompile || g.Config.Build.PrecompilePython()
+	syntax := newStep("#syntax=docker/dockerfile:1.4", "Enables BuildKit features like cache and secret mounts")
+	installCogStep := withCleanup(newStep(installCog, "Installs the Cog Python package, which runs the model"), cleanup)
+	pipInstallsStep := withCleanup(newStep(pipInstalls, "Installs the model's Python packages",
+		"build.python_requirements", "build.python_packages", "build.pip_index_url", "build.pip_extra_index_urls", "build.pip_trusted_hosts"), cleanup)
+	cudaExtensionsStep := newStep(installCUDAExtensions(g.Config), "Installs the CUDA extensions compiled in the cuda-extensions stage", "build.precompile.cuda_extensions")
+	servingBackendStep := withCleanup(newStep(servingBackend, "Serves the model with the configured serving backend", "serving_backend"), cleanup)
+	pipelineStep := newStep(pipeline, "Runs the predictors in the pipeline one after the other", "pipeline")
+	localPackagesStep := withCleanup(newStep(localPackageInstalls, "Installs the Python packages in the project directory after the others, so changing them doesn't install the others again",
+		"build.local_packages", "build.python_requirements", "build.python_packages"), cleanup)
+	for i := range aptInstalls {
+		aptInstalls[i] = withCleanup(aptInstalls[i], cleanup)
+	}
+	for i := range runCommands {
+		runCommands[i] = withCleanup(runCommands[i], cleanup)
+	}
+	precompileStep := newStep(PrecompilePythonCommand, "Compiles Python files to bytecode so the model starts faster", "build.precompile.python")
+
+	wheelsFields := []string{"build.precompile.wheels", "build.python_requirements", "build.python_packages"}
+	wheelsReason := "Builds the Python packages into wheels in their own stage, and keeps them in a cache, so packages with C or CUDA extensions aren't compiled on every build"
+
 	if g.IsUsingCogBaseImage() {
-		steps := []string{
-			"#syntax=docker/dockerfile:1.4",
-			"FROM " + baseImage,
-			aptInstalls,
-			installCog,
-			pipInstalls,
+		wheels, err := g.wheelsStage(baseImage, stepTexts(aptInstalls))
+		if err != nil {
+			return nil, err
 		}
-		if g.precompile {
-			steps = append(steps, PrecompilePythonCommand)
+		envSteps := append(aptInstalls, installCogStep, pipInstallsStep)
+		steps := []step{
+			syntax,
+			newStep(wheels, wheelsReason, wheelsFields...),
+			newStep(cudaExtensionsStage(g.Config, baseImage, stepTexts(envSteps)), "Compiles CUDA extensions into wheels in their own stage, so they're only compiled again when they change", "build.precompile.cuda_extensions"),
+			newStep("FROM "+baseImage+" AS "+DepsStageName, "The cog base image, which has CUDA, Python and torch installed already", g.baseImageFields()...),
 		}
-		steps = append(steps, runCommands)
-
-		return joinStringsWithoutLineSpace(steps), nil
+		steps = append(steps, envSteps...)
+		steps = append(steps, cudaExtensionsStep, servingBackendStep, pipelineStep, localPackagesStep)
+		if precompile {
+			steps = append(steps, precompileStep)
+		}
+		steps = append(steps, runCommands...)
+		return steps, nil
 	}
 
-	steps := []string{
-		"#syntax=docker/dockerfile:1.4",
-		"FROM " + baseImage,
-		g.preamble(),
-		g.installTini(),
-		aptInstalls,
-		installPython,
-		pipInstalls,
-		installCog,
+	envSteps := []step{
+		newStep(g.preamble(), "Sets the environment variables Cog models need"),
+		newStep(g.installTini(), "Installs tini as the entrypoint, to forward signals and reap processes"),
+	}
+	envSteps = append(envSteps, aptInstalls...)
+	envSteps = append(envSteps, withCleanup(newStep(installPython, "Installs Python, because the CUDA base image doesn't have it", "build.python_version"), cleanup))
+	wheels, err := g.wheelsStage(baseImage, stepTexts(envSteps))
+	if err != nil {
+		return nil, err
+	}
+	envSteps = append(envSteps, pipInstallsStep)
+	steps := []step{
+		syntax,
+		newStep(wheels, wheelsReason, wheelsFields...),
+		newStep(cudaExtensionsStage(g.Config, baseImage, stepTexts(envSteps)), "Compiles CUDA extensions into wheels in their own stage, so they're only compiled again when they change", "build.precompile.cuda_extensions"),
+		newStep("FROM "+baseImage+" AS "+DepsStageName, "The base image for the model's GPU, CUDA and Python versions", g.baseImageFields()...),
 	}
-	if g.precompile {
-		steps = append(steps, PrecompilePythonCommand)
+	steps = append(steps, envSteps...)
+	steps = append(steps, cudaExtensionsStep, installCogStep, servingBackendStep, pipelineStep, localPackagesStep)
+	if precompile {
+		steps = append(steps, precompileStep)
 	}
-	steps = append(steps, LDConfigCacheBuildCommand, runCommands)
+	steps = append(steps, newStep(LDConfigCacheBuildCommand, "Lets the dynamic linker find the shared libraries of Python packages"))
+	steps = append(steps, runCommands...)
+	return steps, nil
+}
 
-	return joinStringsWithoutLineSpace(steps), nil
+// baseImageFields are the cog.yaml fields that decide the base image
+func (g *StandardGenerator) baseImageFields() []string {
+	fields := []string{"build.gpu"}
+	if g.Config.Build.GPU {
+		fields = append(fields, "build.cuda", "build.cudnn")
+	}
+	fields = append(fields, "build.python_version")
+	if g.IsUsingCogBaseImage() {
+		fields = append(fields, "build.python_packages")
+		if g.Config.Build.CogBaseImage != "" {
+			fields = append(fields, "build.cog_base_image")
+		}
+	}
+	return fields
 }
 
 func (g *StandardGenerator) GenerateModelBase() (string, error) {
-	initialSteps, err := g.GenerateInitialSteps()
+	steps, err := g.modelBaseSteps()
 	if err != nil {
 		return "", err
 	}
-	return strings.Join([]string{
-		initialSteps,
-		`WORKDIR /src`,
-		`EXPOSE 5000`,
-		`CMD ["python", "-m", "cog.server.http"]`,
-	}, "\n"), nil
+	return joinSteps(steps), nil
+}
+
+func (g *StandardGenerator) modelBaseSteps() ([]step, error) {
+	steps, err := g.initialSteps()
+	if err != nil {
+		return nil, err
+	}
+	return append(steps,
+		newStep("FROM "+DepsStageName+" AS "+ModelStageName, "Starts the model stage from the environment in the deps stage"),
+		newStep(`WORKDIR /src`, "The model's code is in /src"),
+		newStep(`EXPOSE 5000`, "The Cog HTTP server listens on port 5000"),
+		newStep(`CMD ["python", "-m", "cog.server.http"]`, "Starts the Cog HTTP server, which loads the predictor", "predict"),
+	), nil
 }
 
 // GenerateDockerfileWithoutSeparateWeights generates a Dockerfile that doesn't write model weights to a separate layer.
 func (g *StandardGenerator) GenerateDockerfileWithoutSeparateWeights() (string, error) {
-	base, err := g.GenerateModelBase()
+	steps, err := g.dockerfileSteps()
 	if err != nil {
 		return "", err
 	}
-	return joinStringsWithoutLineSpace([]string{
-		base,
-		`COPY . /src`,
-	}), nil
+	return joinSteps(steps), nil
+}
+
+func (g *StandardGenerator) dockerfileSteps() ([]step, error) {
+	steps, err := g.modelBaseSteps()
+	if err != nil {
+		return nil, err
+	}
+	return append(steps,
+		newStep(`COPY . /src`, "Copies the model's code and weights, except for files in .dockerignore"),
+		newStep(copyBuildContexts(g.Config), "Copies the extra build contexts into the image", "build.contexts"),
+	), nil
+}
+
+// Explain describes each instruction in the Dockerfile that GenerateDockerfileWithoutSeparateWeights generates,
+// and the cog.yaml fields that caused it
+func (g *StandardGenerator) Explain() (*Explanation, error) {
+	steps, err := g.dockerfileSteps()
+	if err != nil {
+		return nil, err
+	}
+	return explainSteps(g.Name(), steps), nil
 }
 
 // GenerateModelBaseWithSeparateWeights creates the Dockerfile and .dockerignore file contents for model weights
@@ -235,16 +346,17 @@ func (g *StandardGenerator) GenerateModelBaseWithSeparateWeights(imageName strin
 	initialStepsLines := strings.Split(initialSteps, "\n")
 	for i, line := range initialStepsLines {
 		if strings.HasPrefix(line, "FROM ") {
-			base = append(base, fmt.Sprintf("FROM %s AS %s", imageName+"-weights", "weights"))
+			base = append(base, fmt.Sprintf("FROM %s AS %s", imageName+"-weights", WeightsStageName))
 			base = append(base, initialStepsLines[i:]...)
 			break
 		} else {
 			base = append(base, line)
 		}
 	}
+	base = append(base, "FROM "+DepsStageName+" AS "+ModelStageName)
 
 	for _, p := range append(g.modelDirs, g.modelFiles...) {
-		base = append(base, "COPY --from=weights --link "+path.Join("/src", p)+" "+path.Join("/src", p))
+		base = append(base, "COPY --from="+WeightsStageName+" --link "+path.Join("/src", p)+" "+path.Join("/src", p))
 	}
 
 	base = append(base,
@@ -252,6 +364,7 @@ func (g *StandardGenerator) GenerateModelBaseWithSeparateWeights(imageName strin
 		`EXPOSE 5000`,
 		`CMD ["python", "-m", "cog.server.http"]`,
 		`COPY . /src`,
+		copyBuildContexts(g.Config),
 	)
 
 	dockerignoreContents = makeDockerignoreForWeights(g.modelDirs, g.modelFiles)
@@ -263,6 +376,8 @@ func (g *StandardGenerator) generateForWeights() (string, []string, []string, er
 	if err != nil {
 		return "", nil, nil, err
 	}
+	// The paths are used in the Dockerfile and .dockerignore, which need forward slashes on Windows too
+	modelDirs, modelFiles = toSlashes(modelDirs), toSlashes(modelFiles)
 	// generate dockerfile to store these model weights files
 	dockerfileContents := `#syntax=docker/dockerfile:1.4
 FROM scratch
@@ -274,6 +389,14 @@ FROM scratch
 	return dockerfileContents, modelDirs, modelFiles, nil
 }
 
+func toSlashes(paths []string) []string {
+	slashed := make([]string, len(paths))
+	for i, p := range paths {
+		slashed[i] = filepath.ToSlash(p)
+	}
+	return slashed
+}
+
 func makeDockerignoreForWeights(dirs, files []string) string {
 	var contents string
 	for _, p := range dirs {
@@ -286,16 +409,30 @@ func makeDockerignoreForWeights(dirs, files []string) string {
 }
 
 func (g *StandardGenerator) Cleanup() error {
-	if err := os.RemoveAll(g.tmpDir); err != nil {
+	if err := dockercontext.RemoveAll(g.tmpDir); err != nil {
 		return fmt.Errorf("Failed to clean up %s: %w", g.tmpDir, err)
 	}
 	return nil
 }
 
 func (g *StandardGenerator) BaseImage() (string, error) {
+	baseImage, err := g.unpinnedBaseImage()
+	if err != nil {
+		return "", err
+	}
+	return pinBaseImage(g.Config, baseImage), nil
+}
+
+func (g *StandardGenerator) unpinnedBaseImage() (string, error) {
 	if g.IsUsingCogBaseImage() {
+		if g.Config.Build.CogBaseImage != "" {
+			return g.configuredCogBaseImage(), nil
+		}
 		baseImage, err := g.determineBaseImageName()
-		if err == nil || g.useCogBaseImage != nil {
+		if err == nil {
+			return lockedCogBaseImage(g.Dir, baseImage)
+		}
+		if g.useCogBaseImage != nil {
 			return baseImage, err
 		}
 		console.Warnf("Could not find a suitable base image, continuing without base image support (%v).", err)
@@ -320,7 +457,11 @@ func (g *StandardGenerator) BuildDir() (string, error) {
 }
 
 func (g *StandardGenerator) BuildContexts() (map[string]string, error) {
-	return map[string]string{}, nil
+	contexts, err := ConfigBuildContexts(g.Config, g.Dir)
+	if err != nil {
+		return nil, err
+	}
+	return tmpDirBuildContexts(contexts, g.tmpDir, g.relativeTmpDir), nil
 }
 
 func (g *StandardGenerator) preamble() string {
@@ -350,10 +491,18 @@ chmod +x /sbin/tini`,
 	return strings.Join(lines, "\n")
 }
 
-func (g *StandardGenerator) aptInstalls() (string, error) {
-	packages := g.Config.Build.SystemPackages
+func (g *StandardGenerator) aptSteps() ([]step, error) {
+	packages := systemPackages(g.Config)
+	snapshot, err := aptSnapshotCommand(g.Config)
+	if err != nil {
+		return nil, err
+	}
+	steps := []step{
+		newStep(snapshot, "Installs system packages from the Debian or Ubuntu snapshot at this time", "build.apt_snapshot"),
+		newStep(aptRepositoriesCommand(g.Config.Build.AptRepositories), "Adds the extra apt repositories", "build.apt_repositories"),
+	}
 	if len(packages) == 0 {
-		return "", nil
+		return steps, nil
 	}
 
 	if g.IsUsingCogBaseImage() {
@@ -362,9 +511,17 @@ func (g *StandardGenerator) aptInstalls() (string, error) {
 		})
 	}
 
-	return "RUN --mount=type=cache,target=/var/cache/apt,sharing=locked apt-get update -qq && apt-get install -qqy " +
-		strings.Join(packages, " ") +
-		" && rm -rf /var/lib/apt/lists/*", nil
+	fields := []string{"build.system_packages"}
+	if g.Config.Build.SSH {
+		fields = append(fields, "build.ssh")
+	}
+	steps = append(steps, newStep("RUN --mount=type=cache,target=/var/cache/apt,sharing=locked apt-get update -qq && apt-get install -qqy "+
+		strings.Join(packages, " ")+
+		" && rm -rf /var/lib/apt/lists/*", "Installs the system packages", fields...))
+	if g.Config.Build.SSH {
+		steps = append(steps, newStep(sshKnownHostsCommand(), "Trusts the SSH host keys of GitHub, GitLab and Bitbucket, so git only clones from them over SSH if it's really them", "build.ssh"))
+	}
+	return steps, nil
 }
 
 func (g *StandardGenerator) installPython() (string, error) {
@@ -430,7 +587,7 @@ func (g *StandardGenerator) installCog() (string, error) {
 	if err != nil {
 		return "", err
 	}
-	pipInstallLine := "RUN --mount=type=cache,target=/root/.cache/pip pip install --no-cache-dir"
+	pipInstallLine := pipInstallCommand(g.Config) + " --no-cache-dir"
 	pipInstallLine += " " + containerPath
 	// Install pydantic<2 for now, installing pydantic>2 wouldn't allow a downgrade later,
 	// but upgrading works fine
@@ -469,7 +626,14 @@ func (g *StandardGenerator) pipInstalls() (string, error) {
 		return "", err
 	}
 
-	pipInstallLine := "RUN --mount=type=cache,target=/root/.cache/pip pip install -r " + containerPath
+	if g.Config.Build.PrecompileWheels() {
+		return strings.Join([]string{copyLine[0], installWheels(g.strip)}, "\n"), nil
+	}
+
+	pipInstallLine := pipInstallCommand(g.Config) + " -r " + containerPath
+	if g.Config.Build.RequirementsHashPinned() {
+		pipInstallLine += " --require-hashes"
+	}
 	if g.strip {
 		pipInstallLine += " && " + StripDebugSymbolsCommand
 	}
@@ -481,50 +645,168 @@ func (g *StandardGenerator) pipInstalls() (string, error) {
 	}, "\n"), nil
 }
 
+// pipeline adds the predictor that runs the steps of the model's pipeline, and makes it the model's predictor
+func (g *StandardGenerator) pipeline() (string, error) {
+	if len(g.Config.Pipeline) == 0 {
+		return "", nil
+	}
+	if _, _, err := g.writeTemp(pipelinePredictorFilename, []byte(pipelinePredictor)); err != nil {
+		return "", err
+	}
+	predictorPath, _, _ := strings.Cut(config.PipelinePredictorRef, ":")
+	return strings.Join([]string{
+		copyFromTmpDir(g.relativeTmpDir, pipelinePredictorFilename, predictorPath),
+		"ENV COG_PREDICT_TYPE_STUB=" + config.PipelinePredictorRef,
+	}, "\n"), nil
+}
+
+// localPackageInstalls installs the Python packages in the project directory. Only their directories are copied
+// before they're installed, so the rest of the model's code can change without installing them again.
+func (g *StandardGenerator) localPackageInstalls() (string, error) {
+	packages := g.Config.Build.LocalPythonPackages()
+	if len(packages) == 0 {
+		return "", nil
+	}
+	lines := []string{}
+	args := []string{pipInstallCommand(g.Config)}
+	for _, pkg := range packages {
+		containerPath := path.Join("/src", pkg.Path)
+		lines = append(lines, fmt.Sprintf("COPY %s %s", pkg.Path, containerPath))
+		if pkg.Editable {
+			args = append(args, "-e")
+		}
+		args = append(args, shellQuote(containerPath))
+	}
+	lines = append(lines, strings.Join(args, " "))
+	return strings.Join(lines, "\n"), nil
+}
+
 func (g *StandardGenerator) runCommands() (string, error) {
+	steps, err := g.runSteps()
+	if err != nil {
+		return "", err
+	}
+	return joinSteps(steps), nil
+}
+
+func (g *StandardGenerator) runSteps() ([]step, error) {
 	runCommands := g.Config.Build.Run
+	fields := []string{}
+	for i := range runCommands {
+		fields = append(fields, fmt.Sprintf("build.run[%d]", i))
+	}
 
 	// For backwards compatibility
-	for _, command := range g.Config.Build.PreInstall {
+	for i, command := range g.Config.Build.PreInstall {
 		runCommands = append(runCommands, config.RunItem{Command: command})
+		fields = append(fields, fmt.Sprintf("build.pre_install[%d]", i))
 	}
 
-	lines := []string{}
-	for _, run := range runCommands {
+	steps := []step{}
+	for i, run := range runCommands {
 		command := strings.TrimSpace(run.Command)
 		if strings.Contains(command, "\n") {
-			return "", fmt.Errorf(`One of the commands in 'run' contains a new line, which won't work. You need to create a new list item in YAML prefixed with '-' for each command.
+			return nil, fmt.Errorf(`One of the commands in 'run' contains a new line, which won't work. You need to create a new list item in YAML prefixed with '-' for each command.
 
 This is the offending line: %s`, command)
 		}
+		reason := "Runs a setup command"
+		if run.Network == config.NetworkNone {
+			reason += " without network access"
+		}
+		steps = append(steps, newStep(runInstruction(run, command), reason, fields[i]))
+	}
+	return steps, nil
+}
+
+// runInstruction returns the RUN instruction for a command in build.run, with its mounts and network mode
+func runInstruction(run config.RunItem, command string) string {
+	flags := []string{}
+	for _, mount := range run.Mounts {
+		if mount.Type == "secret" {
+			flags = append(flags, fmt.Sprintf("--mount=type=secret,id=%s,target=%s", mount.ID, mount.Target))
+		}
+	}
+	if run.Network == config.NetworkNone {
+		flags = append(flags, "--network=none")
+	}
+	return strings.Join(append(append([]string{"RUN"}, flags...), command), " ")
+}
+
+func (g *StandardGenerator) servingBackend() (string, error) {
+	backend := g.Config.ServingBackend
+	if backend == nil {
+		return "", nil
+	}
+	if _, _, err := g.writeTemp(servingPredictorFilename, servingVLLMPredictor); err != nil {
+		return "", err
+	}
+	args, err := json.Marshal(backend.Args)
+	if err != nil {
+		return "", err
+	}
+	if backend.Args == nil {
+		args = []byte("[]")
+	}
+	return strings.Join([]string{
+		pipInstallCommand(g.Config) + " vllm==" + backend.PackageVersion(),
+		copyFromTmpDir(g.relativeTmpDir, servingPredictorFilename, ServingPredictorPath),
+		"ENV COG_SERVING_MODEL=" + strconv.Quote(backend.ModelPath()),
+		"ENV COG_SERVING_ARGS=" + strconv.Quote(string(args)),
+		"ENV COG_PREDICT_TYPE_STUB=" + ServingPredictorPath + ":Predictor",
+	}, "\n"), nil
+}
+
+// withCleanup chains cleanup, the commands of the cleanup rules, onto each RUN instruction of s. Files are only
+// removed from the image if they're deleted in the layer that adds them, so a RUN of their own at the end would only
+// hide them.
+func withCleanup(s step, cleanup string) step {
+	if cleanup == "" || s.text == "" {
+		return s
+	}
+	lines := strings.Split(s.text, "\n")
+	inRun := false
+	for i, line := range lines {
+		if strings.HasPrefix(line, "RUN ") {
+			inRun = true
+		}
+		if inRun && !strings.HasSuffix(line, "\\") {
+			lines[i] = line + " && " + cleanup
+			inRun = false
+		}
+	}
+	s.text = strings.Join(lines, "\n")
+	s.fields = append(append([]string{}, s.fields...), "build.cleanup")
+	return s
+}
 
-		if len(run.Mounts) > 0 {
-			mounts := []string{}
-			for _, mount := range run.Mounts {
-				if mount.Type == "secret" {
-					secretMount := fmt.Sprintf("--mount=type=secret,id=%s,target=%s", mount.ID, mount.Target)
-					mounts = append(mounts, secretMount)
-				}
+// cleanup returns the commands of the cleanup rules in cog.yaml, to chain onto the instructions that install things
+func (g *StandardGenerator) cleanup() (string, error) {
+	rules, err := CleanupRules(g.Config.Build.Cleanup)
+	if err != nil {
+		return "", err
+	}
+	if g.precompile || g.Config.Build.PrecompilePython() {
+		for _, rule := range rules {
+			if rule.Name == CleanupPycache {
+				return "", fmt.Errorf("The %s cleanup rule would delete the bytecode that build.precompile.python or --precompile compiles, so it can't be used with them", CleanupPycache)
 			}
-			lines = append(lines, fmt.Sprintf("RUN %s %s", strings.Join(mounts, " "), command))
-		} else {
-			lines = append(lines, "RUN "+command)
 		}
 	}
-	return strings.Join(lines, "\n"), nil
+	return CleanupCommands(rules), nil
 }
 
 // writeTemp writes a temporary file that can be used as part of the build process
 // It returns the lines to add to Dockerfile to make it available and the filename it ends up as inside the container
 func (g *StandardGenerator) writeTemp(filename string, contents []byte) ([]string, string, error) {
-	path := filepath.Join(g.tmpDir, filename)
-	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+	tmpPath := filepath.Join(g.tmpDir, filename)
+	if err := os.MkdirAll(filepath.Dir(tmpPath), 0o755); err != nil {
 		return []string{}, "", fmt.Errorf("Failed to write %s: %w", filename, err)
 	}
-	if err := os.WriteFile(path, contents, 0o644); err != nil {
+	if err := os.WriteFile(tmpPath, contents, 0o644); err != nil {
 		return []string{}, "", fmt.Errorf("Failed to write %s: %w", filename, err)
 	}
-	return []string{fmt.Sprintf("COPY %s /tmp/%s", filepath.Join(g.relativeTmpDir, filename), filename)}, "/tmp/" + filename, nil
+	return []string{copyFromTmpDir(g.relativeTmpDir, filename, "/tmp/"+filename)}, "/tmp/" + filename, nil
 }
 
 func joinStringsWithoutLineSpace(chunks []string) string {
@@ -572,6 +854,11 @@ func (g *StandardGenerator) GenerateWeightsManifest() (*weights.Manifest, error)
 		}
 	}
 
+	// Projects that aren't in a Git repository, or don't use Git LFS, have no LFS objects to record
+	if oids, err := lfs.ListFiles(g.Dir); err == nil {
+		m.AddLFSObjects(oids)
+	}
+
 	return m, nil
 }
 
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -12,6 +13,8 @@ import (
 
 	"github.com/replicate/cog/pkg/config"
 	"github.com/replicate/cog/pkg/docker/dockertest"
+	"github.com/replicate/cog/pkg/dockercontext"
+	"github.com/replicate/cog/pkg/lockfile"
 )
 
 func testTini() string {
@@ -103,13 +106,14 @@ predict: predict.py:Predictor
 
 	expected := `#syntax=docker/dockerfile:1.4
 FROM r8.im/replicate/cog-test-weights AS weights
-FROM python:3.12-slim
+FROM python:3.12-slim AS deps
 ENV DEBIAN_FRONTEND=noninteractive
 ENV PYTHONUNBUFFERED=1
 ENV LD_LIBRARY_PATH=$LD_LIBRARY_PATH:/usr/lib/x86_64-linux-gnu:/usr/local/nvidia/lib64:/usr/local/nvidia/bin
 ENV NVIDIA_DRIVER_CAPABILITIES=all
 ` + testTini() + testInstallCog(gen.relativeTmpDir, gen.strip) + `
 RUN find / -type f -name "*python*.so" -printf "%h\n" | sort -u > /etc/ld.so.conf.d/cog.conf && ldconfig
+FROM deps AS model
 WORKDIR /src
 EXPOSE 5000
 CMD ["python", "-m", "cog.server.http"]
@@ -118,6 +122,33 @@ COPY . /src`
 	require.Equal(t, expected, actual)
 }
 
+func TestGenerateWithTmpDirOutsideProject(t *testing.T) {
+	tmpDir := t.TempDir()
+	dockercontext.SetTmpRoot(t.TempDir())
+	t.Cleanup(func() { dockercontext.SetTmpRoot("") })
+
+	conf, err := config.FromYAML([]byte(`
+build:
+  python_version: "3.12"
+predict: predict.py:Predictor
+`))
+	require.NoError(t, err)
+	require.NoError(t, conf.ValidateAndComplete(""))
+	command := dockertest.NewMockCommand()
+
+	gen, err := NewStandardGenerator(conf, tmpDir, command)
+	require.NoError(t, err)
+	gen.SetUseCogBaseImage(false)
+	_, actual, _, err := gen.GenerateModelBaseWithSeparateWeights("r8.im/replicate/cog-test")
+	require.NoError(t, err)
+	require.Equal(t, "", gen.relativeTmpDir)
+	require.Contains(t, actual, "COPY --from=cog-tmp ")
+
+	contexts, err := gen.BuildContexts()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{dockercontext.TmpBuildContextName: gen.tmpDir}, contexts)
+}
+
 func TestGenerateEmptyGPU(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -138,7 +169,7 @@ predict: predict.py:Predictor
 
 	expected := `#syntax=docker/dockerfile:1.4
 FROM r8.im/replicate/cog-test-weights AS weights
-FROM nvidia/cuda:11.8.0-cudnn8-devel-ubuntu22.04
+FROM nvidia/cuda:11.8.0-cudnn8-devel-ubuntu22.04 AS deps
 ENV DEBIAN_FRONTEND=noninteractive
 ENV PYTHONUNBUFFERED=1
 ENV LD_LIBRARY_PATH=$LD_LIBRARY_PATH:/usr/lib/x86_64-linux-gnu:/usr/local/nvidia/lib64:/usr/local/nvidia/bin
@@ -146,6 +177,7 @@ ENV NVIDIA_DRIVER_CAPABILITIES=all
 ` + testTini() + testInstallPython("3.12") + "RUN rm -rf /usr/bin/python3 && ln -s `realpath \\`pyenv which python\\`` /usr/bin/python3 && chmod +x /usr/bin/python3" + `
 ` + testInstallCog(gen.relativeTmpDir, gen.strip) + `
 RUN find / -type f -name "*python*.so" -printf "%h\n" | sort -u > /etc/ld.so.conf.d/cog.conf && ldconfig
+FROM deps AS model
 WORKDIR /src
 EXPOSE 5000
 CMD ["python", "-m", "cog.server.http"]
@@ -182,7 +214,7 @@ predict: predict.py:Predictor
 
 	expected := `#syntax=docker/dockerfile:1.4
 FROM r8.im/replicate/cog-test-weights AS weights
-FROM python:3.12-slim
+FROM python:3.12-slim AS deps
 ENV DEBIAN_FRONTEND=noninteractive
 ENV PYTHONUNBUFFERED=1
 ENV LD_LIBRARY_PATH=$LD_LIBRARY_PATH:/usr/lib/x86_64-linux-gnu:/usr/local/nvidia/lib64:/usr/local/nvidia/bin
@@ -195,6 +227,7 @@ ENV CFLAGS=
 ` + testInstallCog(gen.relativeTmpDir, gen.strip) + `
 RUN find / -type f -name "*python*.so" -printf "%h\n" | sort -u > /etc/ld.so.conf.d/cog.conf && ldconfig
 RUN cowsay moo
+FROM deps AS model
 WORKDIR /src
 EXPOSE 5000
 CMD ["python", "-m", "cog.server.http"]
@@ -237,7 +270,7 @@ predict: predict.py:Predictor
 
 	expected := `#syntax=docker/dockerfile:1.4
 FROM r8.im/replicate/cog-test-weights AS weights
-FROM nvidia/cuda:11.8.0-cudnn8-devel-ubuntu22.04
+FROM nvidia/cuda:11.8.0-cudnn8-devel-ubuntu22.04 AS deps
 ENV DEBIAN_FRONTEND=noninteractive
 ENV PYTHONUNBUFFERED=1
 ENV LD_LIBRARY_PATH=$LD_LIBRARY_PATH:/usr/lib/x86_64-linux-gnu:/usr/local/nvidia/lib64:/usr/local/nvidia/bin
@@ -251,6 +284,7 @@ ENV CFLAGS=
 ` + testInstallCog(gen.relativeTmpDir, gen.strip) + `
 RUN find / -type f -name "*python*.so" -printf "%h\n" | sort -u > /etc/ld.so.conf.d/cog.conf && ldconfig
 RUN cowsay moo
+FROM deps AS model
 WORKDIR /src
 EXPOSE 5000
 CMD ["python", "-m", "cog.server.http"]
@@ -288,7 +322,7 @@ build:
 
 	expected := `#syntax=docker/dockerfile:1.4
 FROM r8.im/replicate/cog-test-weights AS weights
-FROM python:3.12-slim
+FROM python:3.12-slim AS deps
 ENV DEBIAN_FRONTEND=noninteractive
 ENV PYTHONUNBUFFERED=1
 ENV LD_LIBRARY_PATH=$LD_LIBRARY_PATH:/usr/lib/x86_64-linux-gnu:/usr/local/nvidia/lib64:/usr/local/nvidia/bin
@@ -297,6 +331,7 @@ ENV NVIDIA_DRIVER_CAPABILITIES=all
 ` + testInstallCog(gen.relativeTmpDir, gen.strip) + `
 RUN find / -type f -name "*python*.so" -printf "%h\n" | sort -u > /etc/ld.so.conf.d/cog.conf && ldconfig
 RUN cowsay moo
+FROM deps AS model
 WORKDIR /src
 EXPOSE 5000
 CMD ["python", "-m", "cog.server.http"]
@@ -305,6 +340,35 @@ COPY . /src`
 
 }
 
+func TestRunWithoutNetwork(t *testing.T) {
+	tmpDir := t.TempDir()
+	conf, err := config.FromYAML([]byte(`
+build:
+  python_version: "3.12"
+  run:
+    - command: make install
+      network: none
+    - command: cat /run/secrets/token > /dev/null
+      network: none
+      mounts:
+        - type: secret
+          id: token
+          target: /run/secrets/token
+    - command: curl -LO https://example.com/data.tar.gz
+      network: default
+predict: predict.py:Predictor
+`))
+	require.NoError(t, err)
+	require.NoError(t, conf.ValidateAndComplete(""))
+	gen, err := NewStandardGenerator(conf, tmpDir, dockertest.NewMockCommand())
+	require.NoError(t, err)
+	actual, err := gen.runCommands()
+	require.NoError(t, err)
+	require.Equal(t, `RUN --network=none make install
+RUN --mount=type=secret,id=token,target=/run/secrets/token --network=none cat /run/secrets/token > /dev/null
+RUN curl -LO https://example.com/data.tar.gz`, actual)
+}
+
 func TestPythonRequirements(t *testing.T) {
 	tmpDir := t.TempDir()
 	err := os.WriteFile(path.Join(tmpDir, "my-requirements.txt"), []byte("torch==1.0.0"), 0o644)
@@ -326,6 +390,59 @@ build:
 	require.Contains(t, actual, `pip install -r /tmp/requirements.txt`)
 }
 
+func TestPythonRequirementsHashPinned(t *testing.T) {
+	tmpDir := t.TempDir()
+	requirements := "numpy==1.26.4 \\\n    --hash=sha256:aaaa\ntorch==2.1.0 \\\n    --hash=sha256:bbbb \\\n    --hash=sha256:cccc\n"
+	require.NoError(t, os.WriteFile(path.Join(tmpDir, "requirements.txt"), []byte(requirements), 0o644))
+	conf, err := config.FromYAML([]byte(`
+build:
+  gpu: true
+  python_version: "3.11"
+  python_requirements: requirements.txt
+`))
+	require.NoError(t, err)
+	require.NoError(t, conf.ValidateAndComplete(tmpDir))
+	gen, err := NewStandardGenerator(conf, tmpDir, dockertest.NewMockCommand())
+	require.NoError(t, err)
+	gen.SetUseCogBaseImage(false)
+	_, actual, _, err := gen.GenerateModelBaseWithSeparateWeights("r8.im/replicate/cog-test")
+	require.NoError(t, err)
+	require.Contains(t, actual, `pip install -r /tmp/requirements.txt --require-hashes`)
+	// torch isn't swapped for the CUDA build, because the hashes are for the files that were pinned
+	require.Equal(t, "numpy==1.26.4     --hash=sha256:aaaa\ntorch==2.1.0     --hash=sha256:bbbb     --hash=sha256:cccc", gen.pythonRequirementsContents)
+}
+
+func TestLocalPackagesInstalledAfterRequirements(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, dir := range []string{"lib/mymodel", "lib/tokenizer"} {
+		require.NoError(t, os.MkdirAll(path.Join(tmpDir, dir), 0o755))
+		require.NoError(t, os.WriteFile(path.Join(tmpDir, dir, "pyproject.toml"), []byte("[project]\nname = \"x\"\n"), 0o644))
+	}
+	require.NoError(t, os.WriteFile(path.Join(tmpDir, "requirements.txt"), []byte("numpy==1.26.4\n-e ./lib/mymodel\n"), 0o644))
+	conf, err := config.FromYAML([]byte(`
+build:
+  python_version: "3.11"
+  python_requirements: requirements.txt
+  local_packages:
+    - lib/tokenizer
+`))
+	require.NoError(t, err)
+	require.NoError(t, conf.ValidateAndComplete(tmpDir))
+	gen, err := NewStandardGenerator(conf, tmpDir, dockertest.NewMockCommand())
+	require.NoError(t, err)
+	gen.SetUseCogBaseImage(false)
+	actual, err := gen.GenerateDockerfileWithoutSeparateWeights()
+	require.NoError(t, err)
+
+	require.Equal(t, "numpy==1.26.4", gen.pythonRequirementsContents)
+	install := `COPY lib/mymodel /src/lib/mymodel
+COPY lib/tokenizer /src/lib/tokenizer
+RUN --mount=type=cache,target=/root/.cache/pip pip install -e '/src/lib/mymodel' '/src/lib/tokenizer'`
+	require.Contains(t, actual, install)
+	require.Less(t, strings.Index(actual, "pip install -r /tmp/requirements.txt"), strings.Index(actual, install))
+	require.Less(t, strings.Index(actual, install), strings.Index(actual, "COPY . /src"))
+}
+
 // mockFileInfo is a test type to mock os.FileInfo
 type mockFileInfo struct {
 	size int64
@@ -398,7 +515,7 @@ COPY root-large /src/root-large`
 	// model copy should be run before dependency install and code copy
 	expected = `#syntax=docker/dockerfile:1.4
 FROM r8.im/replicate/cog-test-weights AS weights
-FROM nvidia/cuda:11.8.0-cudnn8-devel-ubuntu22.04
+FROM nvidia/cuda:11.8.0-cudnn8-devel-ubuntu22.04 AS deps
 ENV DEBIAN_FRONTEND=noninteractive
 ENV PYTHONUNBUFFERED=1
 ENV LD_LIBRARY_PATH=$LD_LIBRARY_PATH:/usr/lib/x86_64-linux-gnu:/usr/local/nvidia/lib64:/usr/local/nvidia/bin
@@ -412,6 +529,7 @@ ENV CFLAGS=
 ` + testInstallCog(gen.relativeTmpDir, gen.strip) + `
 RUN find / -type f -name "*python*.so" -printf "%h\n" | sort -u > /etc/ld.so.conf.d/cog.conf && ldconfig
 RUN cowsay moo
+FROM deps AS model
 COPY --from=weights --link /src/checkpoints /src/checkpoints
 COPY --from=weights --link /src/models /src/models
 COPY --from=weights --link /src/root-large /src/root-large
@@ -477,13 +595,14 @@ predict: predict.py:Predictor
 	require.NoError(t, err)
 
 	expected := `#syntax=docker/dockerfile:1.4
-FROM python:3.12-slim
+FROM python:3.12-slim AS deps
 ENV DEBIAN_FRONTEND=noninteractive
 ENV PYTHONUNBUFFERED=1
 ENV LD_LIBRARY_PATH=$LD_LIBRARY_PATH:/usr/lib/x86_64-linux-gnu:/usr/local/nvidia/lib64:/usr/local/nvidia/bin
 ENV NVIDIA_DRIVER_CAPABILITIES=all
 ` + testTini() + testInstallCog(gen.relativeTmpDir, gen.strip) + `
 RUN find / -type f -name "*python*.so" -printf "%h\n" | sort -u > /etc/ld.so.conf.d/cog.conf && ldconfig
+FROM deps AS model
 WORKDIR /src
 EXPOSE 5000
 CMD ["python", "-m", "cog.server.http"]
@@ -512,8 +631,9 @@ predict: predict.py:Predictor
 
 	expected := `#syntax=docker/dockerfile:1.4
 FROM r8.im/replicate/cog-test-weights AS weights
-FROM r8.im/cog-base:python3.12
+FROM r8.im/cog-base:python3.12 AS deps
 ` + testInstallCog(gen.relativeTmpDir, gen.strip) + `
+FROM deps AS model
 WORKDIR /src
 EXPOSE 5000
 CMD ["python", "-m", "cog.server.http"]
@@ -549,7 +669,7 @@ predict: predict.py:Predictor
 
 	expected := `#syntax=docker/dockerfile:1.4
 FROM r8.im/replicate/cog-test-weights AS weights
-FROM r8.im/cog-base:python3.12
+FROM r8.im/cog-base:python3.12 AS deps
 RUN --mount=type=cache,target=/var/cache/apt,sharing=locked apt-get update -qq && apt-get install -qqy cowsay && rm -rf /var/lib/apt/lists/*
 ` + testInstallCog(gen.relativeTmpDir, gen.strip) + `
 COPY ` + gen.relativeTmpDir + `/requirements.txt /tmp/requirements.txt
@@ -557,6 +677,7 @@ ENV CFLAGS="-O3 -funroll-loops -fno-strict-aliasing -flto -S"
 RUN --mount=type=cache,target=/root/.cache/pip pip install -r /tmp/requirements.txt
 ENV CFLAGS=
 RUN cowsay moo
+FROM deps AS model
 WORKDIR /src
 EXPOSE 5000
 CMD ["python", "-m", "cog.server.http"]
@@ -605,7 +726,7 @@ predict: predict.py:Predictor
 		}
 		expected := fmt.Sprintf(`#syntax=docker/dockerfile:1.4
 FROM r8.im/replicate/cog-test-weights AS weights
-FROM r8.im/cog-base:cuda11.8-python3.11-torch%s
+FROM r8.im/cog-base:cuda11.8-python3.11-torch%s AS deps
 RUN --mount=type=cache,target=/var/cache/apt,sharing=locked apt-get update -qq && apt-get install -qqy cowsay && rm -rf /var/lib/apt/lists/*
 `+testInstallCog(gen.relativeTmpDir, gen.strip)+`
 COPY `+gen.relativeTmpDir+`/requirements.txt /tmp/requirements.txt
@@ -613,6 +734,7 @@ ENV CFLAGS="-O3 -funroll-loops -fno-strict-aliasing -flto -S"
 RUN --mount=type=cache,target=/root/.cache/pip pip install -r /tmp/requirements.txt
 ENV CFLAGS=
 RUN cowsay moo
+FROM deps AS model
 WORKDIR /src
 EXPOSE 5000
 CMD ["python", "-m", "cog.server.http"]
@@ -659,7 +781,7 @@ predict: predict.py:Predictor
 
 	expected := `#syntax=docker/dockerfile:1.4
 FROM r8.im/replicate/cog-test-weights AS weights
-FROM r8.im/cog-base:cuda11.8-python3.12-torch2.3.1
+FROM r8.im/cog-base:cuda11.8-python3.12-torch2.3.1 AS deps
 RUN --mount=type=cache,target=/var/cache/apt,sharing=locked apt-get update -qq && apt-get install -qqy cowsay && rm -rf /var/lib/apt/lists/*
 ` + testInstallCog(gen.relativeTmpDir, gen.strip) + `
 COPY ` + gen.relativeTmpDir + `/requirements.txt /tmp/requirements.txt
@@ -667,6 +789,7 @@ ENV CFLAGS="-O3 -funroll-loops -fno-strict-aliasing -flto -S"
 RUN --mount=type=cache,target=/root/.cache/pip pip install -r /tmp/requirements.txt
 ENV CFLAGS=
 RUN cowsay moo
+FROM deps AS model
 WORKDIR /src
 EXPOSE 5000
 CMD ["python", "-m", "cog.server.http"]
@@ -712,7 +835,7 @@ predict: predict.py:Predictor
 
 	expected := `#syntax=docker/dockerfile:1.4
 FROM r8.im/replicate/cog-test-weights AS weights
-FROM r8.im/cog-base:cuda11.8-python3.12-torch2.3.1
+FROM r8.im/cog-base:cuda11.8-python3.12-torch2.3.1 AS deps
 RUN --mount=type=cache,target=/var/cache/apt,sharing=locked apt-get update -qq && apt-get install -qqy cowsay && rm -rf /var/lib/apt/lists/*
 ` + testInstallCog(gen.relativeTmpDir, gen.strip) + `
 COPY ` + gen.relativeTmpDir + `/requirements.txt /tmp/requirements.txt
@@ -720,6 +843,7 @@ ENV CFLAGS="-O3 -funroll-loops -fno-strict-aliasing -flto -S"
 RUN --mount=type=cache,target=/root/.cache/pip pip install -r /tmp/requirements.txt && find / -type f -name "*python*.so" -not -name "*cpython*.so" -exec strip -S {} \;
 ENV CFLAGS=
 RUN cowsay moo
+FROM deps AS model
 WORKDIR /src
 EXPOSE 5000
 CMD ["python", "-m", "cog.server.http"]
@@ -798,7 +922,7 @@ predict: predict.py:Predictor
 
 	expected := `#syntax=docker/dockerfile:1.4
 FROM r8.im/replicate/cog-test-weights AS weights
-FROM r8.im/cog-base:cuda11.8-python3.12-torch2.3.1
+FROM r8.im/cog-base:cuda11.8-python3.12-torch2.3.1 AS deps
 RUN --mount=type=cache,target=/var/cache/apt,sharing=locked apt-get update -qq && apt-get install -qqy cowsay && rm -rf /var/lib/apt/lists/*
 ` + testInstallCog(gen.relativeTmpDir, gen.strip) + `
 COPY ` + gen.relativeTmpDir + `/requirements.txt /tmp/requirements.txt
@@ -807,6 +931,7 @@ RUN --mount=type=cache,target=/root/.cache/pip pip install -r /tmp/requirements.
 ENV CFLAGS=
 RUN find / -type f -name "*.py[co]" -delete && find / -type f -name "*.py" -exec touch -t 197001010000 {} \; && find / -type f -name "*.py" -printf "%h\n" | sort -u | /usr/bin/python3 -m compileall --invalidation-mode timestamp -o 2 -j 0
 RUN cowsay moo
+FROM deps AS model
 WORKDIR /src
 EXPOSE 5000
 CMD ["python", "-m", "cog.server.http"]
@@ -820,3 +945,453 @@ COPY . /src`
 torch==2.3.1
 pandas==2.0.3`, string(requirements))
 }
+
+func TestGenerateWithVLLMServingBackend(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	conf, err := config.FromYAML([]byte(`
+build:
+  python_version: "3.12"
+serving_backend:
+  name: vllm
+  model: facebook/opt-125m
+  args: ["--max-model-len", "2048"]
+`))
+	require.NoError(t, err)
+	require.NoError(t, conf.ValidateAndComplete(""))
+
+	gen, err := NewStandardGenerator(conf, tmpDir, dockertest.NewMockCommand())
+	require.NoError(t, err)
+	gen.SetUseCogBaseImage(false)
+	actual, err := gen.GenerateDockerfileWithoutSeparateWeights()
+	require.NoError(t, err)
+
+	require.Contains(t, actual, `RUN --mount=type=cache,target=/root/.cache/pip pip install vllm==`+config.DefaultVLLMVersion+`
+COPY `+gen.relativeTmpDir+`/serving_predictor.py /opt/cog/serving/predictor.py
+ENV COG_SERVING_MODEL="facebook/opt-125m"
+ENV COG_SERVING_ARGS="[\"--max-model-len\",\"2048\"]"
+ENV COG_PREDICT_TYPE_STUB=/opt/cog/serving/predictor.py:Predictor`)
+	require.FileExists(t, path.Join(gen.tmpDir, "serving_predictor.py"))
+}
+
+func TestGenerateWithPipeline(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	conf, err := config.FromYAML([]byte(`
+build:
+  python_version: "3.12"
+pipeline:
+  - predict: preprocess.py:Preprocessor
+  - predict: predict.py:Predictor
+    input: image
+`))
+	require.NoError(t, err)
+	require.NoError(t, conf.ValidateAndComplete(""))
+
+	gen, err := NewStandardGenerator(conf, tmpDir, dockertest.NewMockCommand())
+	require.NoError(t, err)
+	gen.SetUseCogBaseImage(false)
+	actual, err := gen.GenerateDockerfileWithoutSeparateWeights()
+	require.NoError(t, err)
+
+	require.Contains(t, actual, `COPY `+gen.relativeTmpDir+`/pipeline_predictor.py /opt/cog/pipeline/predictor.py
+ENV COG_PREDICT_TYPE_STUB=/opt/cog/pipeline/predictor.py:Predictor`)
+	contents, err := os.ReadFile(path.Join(gen.tmpDir, "pipeline_predictor.py"))
+	require.NoError(t, err)
+	require.Equal(t, "from cog.pipeline import Pipeline as Predictor\n", string(contents))
+}
+
+func TestGenerateWithBuildContexts(t *testing.T) {
+	tmpDir := t.TempDir()
+	assetsDir := t.TempDir()
+
+	conf, err := config.FromYAML([]byte(`
+build:
+  python_version: "3.12"
+  contexts:
+    assets: ` + assetsDir + `
+`))
+	require.NoError(t, err)
+	require.NoError(t, conf.ValidateAndComplete(""))
+
+	gen, err := NewStandardGenerator(conf, tmpDir, dockertest.NewMockCommand())
+	require.NoError(t, err)
+	gen.SetUseCogBaseImage(false)
+	actual, err := gen.GenerateDockerfileWithoutSeparateWeights()
+	require.NoError(t, err)
+	require.True(t, strings.HasSuffix(actual, "COPY . /src\nCOPY --from=assets . /src/assets"))
+
+	contexts, err := gen.BuildContexts()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"assets": assetsDir}, contexts)
+
+	conf.Build.Contexts["assets"] = config.BuildContext{Path: "does-not-exist"}
+	_, err = gen.BuildContexts()
+	require.ErrorContains(t, err, "not a directory")
+}
+
+func TestGenerateWithPipIndex(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	conf, err := config.FromYAML([]byte(`
+build:
+  python_version: "3.12"
+  python_packages:
+    - "acme-internal==1.0.0"
+  pip_index_url: https://artifactory.example.com/api/pypi/pypi/simple
+  pip_extra_index_urls:
+    - https://nexus.example.com/repository/pypi/simple
+  pip_trusted_hosts:
+    - nexus.example.com
+`))
+	require.NoError(t, err)
+	require.NoError(t, conf.ValidateAndComplete(""))
+
+	gen, err := NewStandardGenerator(conf, tmpDir, dockertest.NewMockCommand())
+	require.NoError(t, err)
+	gen.SetUseCogBaseImage(false)
+	actual, err := gen.GenerateDockerfileWithoutSeparateWeights()
+	require.NoError(t, err)
+
+	require.Contains(t, actual, "RUN --mount=type=cache,target=/root/.cache/pip --mount=type=secret,id=pip-netrc,target=/root/.netrc pip install --index-url 'https://artifactory.example.com/api/pypi/pypi/simple' --extra-index-url 'https://nexus.example.com/repository/pypi/simple' --trusted-host 'nexus.example.com' -r /tmp/requirements.txt")
+}
+
+func TestGenerateWithSSH(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	conf, err := config.FromYAML([]byte(`
+build:
+  python_version: "3.12"
+  ssh: true
+  system_packages:
+    - ffmpeg
+  python_packages:
+    - "acme @ git+ssh://git@github.com/acme/acme.git@v1.0.0"
+`))
+	require.NoError(t, err)
+	require.NoError(t, conf.ValidateAndComplete(""))
+
+	gen, err := NewStandardGenerator(conf, tmpDir, dockertest.NewMockCommand())
+	require.NoError(t, err)
+	gen.SetUseCogBaseImage(false)
+	actual, err := gen.GenerateDockerfileWithoutSeparateWeights()
+	require.NoError(t, err)
+
+	require.Contains(t, actual, "apt-get install -qqy ffmpeg git openssh-client &&")
+	require.Contains(t, actual, "RUN mkdir -p /etc/ssh && printf '%s\\n' 'github.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl' ")
+	require.Contains(t, actual, "RUN --mount=type=cache,target=/root/.cache/pip --mount=type=ssh --mount=type=secret,id=ssh-known-hosts,target=/root/.ssh/known_hosts GIT_SSH_COMMAND='ssh -o StrictHostKeyChecking=yes' pip install -r /tmp/requirements.txt")
+	require.Equal(t, []string{"ffmpeg"}, conf.Build.SystemPackages)
+}
+
+func TestGenerateWithAptRepositories(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	conf, err := config.FromYAML([]byte(`
+build:
+  python_version: "3.12"
+  system_packages:
+    - example-tool=1.2.3-1
+  apt_repositories:
+    - name: example
+      source: deb https://apt.example.com stable main
+      key_url: https://apt.example.com/key.asc
+      key_fingerprint: 0123 4567 89ab cdef 0123  4567 89ab cdef 0123 4567
+`))
+	require.NoError(t, err)
+	require.NoError(t, conf.ValidateAndComplete(""))
+
+	gen, err := NewStandardGenerator(conf, tmpDir, dockertest.NewMockCommand())
+	require.NoError(t, err)
+	gen.SetUseCogBaseImage(false)
+	actual, err := gen.GenerateDockerfileWithoutSeparateWeights()
+	require.NoError(t, err)
+
+	expected := "RUN --mount=type=cache,target=/var/cache/apt,sharing=locked apt-get update -qq && apt-get install -qqy --no-install-recommends ca-certificates curl gnupg && mkdir -p /etc/apt/keyrings" +
+		" && curl -fsSL 'https://apt.example.com/key.asc' | gpg --dearmor --yes -o /etc/apt/keyrings/example.gpg" +
+		` && (gpg --show-keys --with-colons /etc/apt/keyrings/example.gpg | grep -q '^fpr:::::::::0123456789ABCDEF0123456789ABCDEF01234567:$' || (echo 'The signing key for apt repository example doesn'\''t match key_fingerprint in cog.yaml' >&2 && exit 1))` +
+		" && echo 'deb [signed-by=/etc/apt/keyrings/example.gpg] https://apt.example.com stable main' > /etc/apt/sources.list.d/example.list" +
+		" && rm -rf /var/lib/apt/lists/*\n" +
+		"RUN --mount=type=cache,target=/var/cache/apt,sharing=locked apt-get update -qq && apt-get install -qqy example-tool=1.2.3-1 && rm -rf /var/lib/apt/lists/*"
+	require.Contains(t, actual, expected)
+}
+
+func TestGenerateWithAptSnapshot(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	conf, err := config.FromYAML([]byte(`
+build:
+  python_version: "3.12"
+  apt_snapshot: 2024-03-01
+  system_packages:
+    - ffmpeg
+`))
+	require.NoError(t, err)
+	require.NoError(t, conf.ValidateAndComplete(""))
+
+	gen, err := NewStandardGenerator(conf, tmpDir, dockertest.NewMockCommand())
+	require.NoError(t, err)
+	gen.SetUseCogBaseImage(false)
+	actual, err := gen.GenerateDockerfileWithoutSeparateWeights()
+	require.NoError(t, err)
+
+	snapshot := `RUN printf 'Acquire::Check-Valid-Until "false";\n' > /etc/apt/apt.conf.d/99cog-snapshot && sed -i -E 's#https?://(deb|security)\.debian\.org/(debian(-security)?)#http://snapshot.debian.org/archive/\2/20240301T000000Z#g; s#https?://(([a-z]+\.)?archive|security)\.ubuntu\.com/ubuntu#http://snapshot.ubuntu.com/ubuntu/20240301T000000Z#g' $(ls /etc/apt/sources.list /etc/apt/sources.list.d/*.list /etc/apt/sources.list.d/*.sources 2>/dev/null)`
+	require.Contains(t, actual, snapshot+"\nRUN --mount=type=cache,target=/var/cache/apt,sharing=locked apt-get update -qq && apt-get install -qqy ffmpeg")
+}
+
+func TestGenerateWithCUDAExtensions(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	conf, err := config.FromYAML([]byte(`
+build:
+  gpu: true
+  cuda: "12.1"
+  python_version: "3.11"
+  python_packages:
+    - torch==2.3.1
+  precompile:
+    python: true
+    cuda_extensions:
+      - flash-attn==2.6.3
+    cuda_arch_list: "8.0;9.0"
+    max_jobs: 4
+`))
+	require.NoError(t, err)
+	require.NoError(t, conf.ValidateAndComplete(""))
+
+	gen, err := NewStandardGenerator(conf, tmpDir, dockertest.NewMockCommand())
+	require.NoError(t, err)
+	gen.SetUseCogBaseImage(false)
+	actual, err := gen.GenerateDockerfileWithoutSeparateWeights()
+	require.NoError(t, err)
+
+	lines := strings.Split(actual, "\n")
+	require.Equal(t, "#syntax=docker/dockerfile:1.4", lines[0])
+	require.True(t, strings.HasPrefix(lines[1], "FROM nvidia/cuda:12.1.1-cudnn8-devel-ubuntu22.04 AS cuda-extensions"), lines[1])
+	require.Contains(t, actual, "RUN --mount=type=cache,target=/root/.cache/pip pip install ninja packaging psutil wheel\n"+
+		"RUN --mount=type=cache,target=/root/.cache/pip TORCH_CUDA_ARCH_LIST='8.0;9.0' MAX_JOBS=4 pip wheel --no-deps --no-build-isolation --wheel-dir /cuda-extensions 'flash-attn==2.6.3'\n"+
+		"FROM nvidia/cuda:12.1.1-cudnn8-devel-ubuntu22.04 AS deps\n")
+	require.Contains(t, actual, "RUN --mount=type=bind,from=cuda-extensions,source=/cuda-extensions,target=/tmp/cuda-extensions pip install --no-deps /tmp/cuda-extensions/*.whl")
+	require.Contains(t, actual, PrecompilePythonCommand)
+}
+
+func TestPycacheCleanupWithPrecompile(t *testing.T) {
+	conf, err := config.FromYAML([]byte(`
+build:
+  python_version: "3.12"
+  cleanup:
+    - pycache
+predict: predict.py:Predictor
+`))
+	require.NoError(t, err)
+	require.NoError(t, conf.ValidateAndComplete(""))
+	gen, err := NewStandardGenerator(conf, t.TempDir(), dockertest.NewMockCommand())
+	require.NoError(t, err)
+	gen.SetUseCogBaseImage(false)
+	_, err = gen.GenerateDockerfileWithoutSeparateWeights()
+	require.NoError(t, err)
+
+	gen.SetPrecompile(true)
+	_, err = gen.GenerateDockerfileWithoutSeparateWeights()
+	require.ErrorContains(t, err, "The pycache cleanup rule would delete the bytecode")
+}
+
+func TestBuildTargets(t *testing.T) {
+	conf, err := config.FromYAML([]byte(`
+build:
+  gpu: true
+  python_version: "3.11"
+  python_packages:
+    - torch==2.3.1
+predict: predict.py:Predictor
+`))
+	require.NoError(t, err)
+	require.NoError(t, conf.ValidateAndComplete(""))
+	gen, err := NewStandardGenerator(conf, t.TempDir(), dockertest.NewMockCommand())
+	require.NoError(t, err)
+	require.Equal(t, []string{"deps", "weights", "model"}, BuildTargets(gen))
+
+	conf.Build.Precompile = &config.Precompile{CUDAExtensions: []string{"flash-attn==2.6.3"}}
+	require.Equal(t, []string{"cuda-extensions", "deps", "weights", "model"}, BuildTargets(gen))
+
+	conf.Build.Precompile = &config.Precompile{Wheels: true}
+	require.Equal(t, []string{"wheels", "deps", "weights", "model"}, BuildTargets(gen))
+}
+
+func TestGenerateWithWheelsStage(t *testing.T) {
+	tmpDir := t.TempDir()
+	conf, err := config.FromYAML([]byte(`
+build:
+  python_version: "3.11"
+  python_packages:
+    - pycocotools==2.0.8
+  precompile:
+    wheels: true
+predict: predict.py:Predictor
+`))
+	require.NoError(t, err)
+	require.NoError(t, conf.ValidateAndComplete(""))
+	gen, err := NewStandardGenerator(conf, tmpDir, dockertest.NewMockCommand())
+	require.NoError(t, err)
+	gen.SetUseCogBaseImage(false)
+	actual, err := gen.GenerateDockerfileWithoutSeparateWeights()
+	require.NoError(t, err)
+
+	stage := actual[strings.Index(actual, "FROM python:3.11-slim AS wheels"):strings.Index(actual, "FROM python:3.11-slim AS deps")]
+	require.Contains(t, stage, "ENTRYPOINT")
+	require.Contains(t, stage, "RUN --mount=type=cache,target=/wheelhouse,id=cog-wheelhouse,sharing=locked --mount=type=cache,target=/root/.cache/pip pip wheel --find-links /wheelhouse --wheel-dir /wheels -r /tmp/requirements.txt && cp -n /wheels/*.whl /wheelhouse/")
+	require.Contains(t, actual, "RUN --mount=type=bind,from=wheels,source=/wheels,target=/tmp/wheels pip install --no-deps /tmp/wheels/*.whl\n")
+	require.NotContains(t, actual, "pip install -r /tmp/requirements.txt")
+}
+
+func TestShellQuote(t *testing.T) {
+	require.Equal(t, `'https://example.com/simple'`, shellQuote("https://example.com/simple"))
+	require.Equal(t, `'it'\''s'`, shellQuote("it's"))
+}
+
+func TestBaseImageWithPinnedCogBaseImage(t *testing.T) {
+	tmpDir := t.TempDir()
+	digest := "sha256:" + strings.Repeat("a", 64)
+
+	conf, err := config.FromYAML([]byte(`
+build:
+  gpu: false
+  python_version: "3.12"
+  cog_base_image: r8.im/cog-base:python3.12@` + digest + `
+predict: predict.py:Predictor
+`))
+	require.NoError(t, err)
+	require.NoError(t, conf.ValidateAndComplete(""))
+	gen, err := NewStandardGenerator(conf, tmpDir, dockertest.NewMockCommand())
+	require.NoError(t, err)
+	gen.SetUseCogBaseImage(true)
+
+	baseImage, err := gen.BaseImage()
+	require.NoError(t, err)
+	require.Equal(t, "r8.im/cog-base:python3.12@"+digest, baseImage)
+}
+
+func TestBaseImageWithCogBaseImageInLockfile(t *testing.T) {
+	tmpDir := t.TempDir()
+	digest := "sha256:" + strings.Repeat("a", 64)
+
+	conf, err := config.FromYAML([]byte(`
+build:
+  gpu: false
+  python_version: "3.12"
+predict: predict.py:Predictor
+`))
+	require.NoError(t, err)
+	require.NoError(t, conf.ValidateAndComplete(""))
+	gen, err := NewStandardGenerator(conf, tmpDir, dockertest.NewMockCommand())
+	require.NoError(t, err)
+	gen.SetUseCogBaseImage(true)
+
+	// A base image for other versions in cog.lock isn't used
+	lock := &lockfile.Lockfile{BaseImage: &lockfile.Image{Name: "r8.im/cog-base:python3.11", Digest: digest}}
+	require.NoError(t, lock.Save(tmpDir))
+	baseImage, err := gen.BaseImage()
+	require.NoError(t, err)
+	require.Equal(t, "r8.im/cog-base:python3.12", baseImage)
+
+	lock.BaseImage.Name = "r8.im/cog-base:python3.12"
+	require.NoError(t, lock.Save(tmpDir))
+	baseImage, err = gen.BaseImage()
+	require.NoError(t, err)
+	require.Equal(t, "r8.im/cog-base:python3.12@"+digest, baseImage)
+}
+
+func TestResolveBaseImages(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	conf, err := config.FromYAML([]byte(`
+build:
+  gpu: true
+  cuda: "12.1"
+  python_version: "3.11"
+  python_packages:
+    - torch==2.3.1
+predict: predict.py:Predictor
+`))
+	require.NoError(t, err)
+	require.NoError(t, conf.ValidateAndComplete(""))
+	gen, err := NewStandardGenerator(conf, tmpDir, dockertest.NewMockCommand())
+	require.NoError(t, err)
+
+	resolution, err := ResolveBaseImages(gen)
+	require.NoError(t, err)
+	require.Equal(t, "r8.im/cog-base:cuda12.1-python3.11-torch2.3.1", resolution.BaseImage)
+	require.Equal(t, "r8.im/cog-base:cuda12.1-python3.11-torch2.3.1", resolution.CogBaseImage)
+	require.Equal(t, "nvidia/cuda:12.1.1-cudnn8-devel-ubuntu22.04", resolution.CUDABaseImage)
+	require.Contains(t, resolution.Reasons, "The build uses the cog base image, which is the default")
+
+	gen.SetUseCogBaseImage(false)
+	resolution, err = ResolveBaseImages(gen)
+	require.NoError(t, err)
+	require.Equal(t, "nvidia/cuda:12.1.1-cudnn8-devel-ubuntu22.04", resolution.BaseImage)
+	require.Contains(t, resolution.Reasons, "The build uses the CUDA base image, because the model uses a GPU")
+}
+
+func TestExplain(t *testing.T) {
+	conf, err := config.FromYAML([]byte(`
+build:
+  python_version: "3.12"
+  system_packages:
+    - ffmpeg
+  run:
+    - command: make install
+predict: predict.py:Predictor
+`))
+	require.NoError(t, err)
+	require.NoError(t, conf.ValidateAndComplete(""))
+	gen, err := NewStandardGenerator(conf, t.TempDir(), dockertest.NewMockCommand())
+	require.NoError(t, err)
+	gen.SetUseCogBaseImage(false)
+
+	dockerfile, err := gen.GenerateDockerfileWithoutSeparateWeights()
+	require.NoError(t, err)
+	explanation, err := gen.Explain()
+	require.NoError(t, err)
+	require.Equal(t, dockerfile, explanation.Dockerfile())
+
+	stages := map[string]bool{}
+	fields := map[string]string{}
+	for i, instruction := range explanation.Instructions {
+		require.NotEmpty(t, instruction.Reason, instruction.Instruction)
+		if i > 0 {
+			require.Greater(t, instruction.Line, explanation.Instructions[i-1].Line)
+		}
+		stages[instruction.Stage] = true
+		for _, field := range instruction.Fields {
+			fields[field] = instruction.Instruction
+		}
+	}
+	require.True(t, stages[DepsStageName])
+	require.True(t, stages[ModelStageName])
+	require.Equal(t, "RUN make install", fields["build.run[0]"])
+	require.Contains(t, fields["build.system_packages"], "ffmpeg")
+}
+
+func TestBaseImagePinnedToVerifiedDigest(t *testing.T) {
+	conf, err := config.FromYAML([]byte(`
+build:
+  gpu: false
+  python_version: "3.12"
+predict: predict.py:Predictor
+`))
+	require.NoError(t, err)
+	require.NoError(t, conf.ValidateAndComplete(""))
+	gen, err := NewStandardGenerator(conf, t.TempDir(), dockertest.NewMockCommand())
+	require.NoError(t, err)
+	gen.SetUseCogBaseImage(false)
+
+	conf.Build.PinnedBaseImage = "python:3.12-slim@sha256:abc123"
+	baseImage, err := gen.BaseImage()
+	require.NoError(t, err)
+	require.Equal(t, "python:3.12-slim@sha256:abc123", baseImage)
+
+	// A pin for a different image, like one verified with other flags, is ignored
+	conf.Build.PinnedBaseImage = "python:3.11-slim@sha256:abc123"
+	baseImage, err = gen.BaseImage()
+	require.NoError(t, err)
+	require.Equal(t, "python:3.12-slim", baseImage)
+}
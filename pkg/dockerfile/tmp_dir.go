@@ -0,0 +1,44 @@
+package dockerfile
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+
+	"github.com/replicate/cog/pkg/dockercontext"
+)
+
+// newTmpDir creates a generator's temporary directory for a build of the project in dir. relativeTmpDir is it
+// relative to dir, with forward slashes so it can be used in the Dockerfile, or "" if it's outside the project, like
+// with COG_TMPDIR, and so has to be passed to Docker as another build context.
+func newTmpDir(dir string) (tmpDir string, relativeTmpDir string, err error) {
+	tmpDir, err = dockercontext.BuildTempDir(dir)
+	if err != nil {
+		return "", "", err
+	}
+	if !dockercontext.IsTmpDirInProject(dir) {
+		return tmpDir, "", nil
+	}
+	relativeTmpDir, err = filepath.Rel(dir, tmpDir)
+	if err != nil {
+		return "", "", err
+	}
+	return tmpDir, filepath.ToSlash(relativeTmpDir), nil
+}
+
+// copyFromTmpDir returns the instruction that copies src, in a generator's temporary directory, to dest in the image
+func copyFromTmpDir(relativeTmpDir string, src string, dest string) string {
+	if relativeTmpDir == "" {
+		return fmt.Sprintf("COPY --from=%s %s %s", dockercontext.TmpBuildContextName, src, dest)
+	}
+	return fmt.Sprintf("COPY %s %s", path.Join(relativeTmpDir, src), dest)
+}
+
+// tmpDirBuildContexts adds a generator's temporary directory to the build contexts from cog.yaml if it's outside the
+// project
+func tmpDirBuildContexts(contexts map[string]string, tmpDir string, relativeTmpDir string) map[string]string {
+	if relativeTmpDir == "" {
+		contexts[dockercontext.TmpBuildContextName] = tmpDir
+	}
+	return contexts
+}
@@ -0,0 +1,44 @@
+package dockerfile
+
+import "strings"
+
+// WheelsStageName is the builder stage that builds the Python requirements into wheels, with build.precompile.wheels
+const WheelsStageName = "wheels"
+
+const wheelsDir = "/wheels"
+
+// wheelhouseMount is a BuildKit cache that keeps the wheels between builds, so packages that compile C or CUDA
+// extensions are only compiled again when they change, even when the wheels stage is rebuilt
+const wheelhouseMount = "--mount=type=cache,target=/wheelhouse,id=cog-wheelhouse,sharing=locked"
+
+// wheelsStage returns a builder stage that builds the model's Python requirements, and everything they depend on,
+// into wheels. It starts with the same steps as the model image, so packages are compiled against the same system
+// packages and Python.
+func (g *StandardGenerator) wheelsStage(baseImage string, steps []string) (string, error) {
+	if !g.Config.Build.PrecompileWheels() || strings.TrimSpace(g.pythonRequirementsContents) == "" {
+		return "", nil
+	}
+	copyLine, containerPath, err := g.writeTemp("requirements.txt", []byte(g.pythonRequirementsContents))
+	if err != nil {
+		return "", err
+	}
+	stage := append([]string{"FROM " + baseImage + " AS " + WheelsStageName}, steps...)
+	stage = append(stage,
+		copyLine[0],
+		CFlags,
+		"RUN "+wheelhouseMount+" "+strings.TrimPrefix(pipCommand(g.Config, "wheel", nil), "RUN ")+
+			" --find-links /wheelhouse --wheel-dir "+wheelsDir+" -r "+containerPath+" && cp -n "+wheelsDir+"/*.whl /wheelhouse/",
+	)
+	return joinStringsWithoutLineSpace(stage), nil
+}
+
+// installWheels returns the RUN instruction installing the wheels built in the wheels stage. They're the
+// requirements and all their dependencies, so they're installed without looking for any others.
+func installWheels(strip bool) string {
+	install := "RUN --mount=type=bind,from=" + WheelsStageName + ",source=" + wheelsDir + ",target=/tmp" + wheelsDir +
+		" pip install --no-deps /tmp" + wheelsDir + "/*.whl"
+	if strip {
+		install += " && " + StripDebugSymbolsCommand
+	}
+	return install
+}
@@ -0,0 +1,145 @@
+package dockerignore
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	ignore "github.com/sabhiram/go-gitignore"
+)
+
+// Rule is a single pattern from .dockerignore
+type Rule struct {
+	LineNo  int
+	Pattern string
+	Negate  bool
+}
+
+// ContextFile is a file in the build context directory
+type ContextFile struct {
+	Path string
+	Size int64
+	// Rule is the .dockerignore rule that excluded the file, or nil if it is sent to the build context
+	Rule *Rule
+	// Dir is set for an excluded directory that nothing in is re-included, so its files aren't listed
+	Dir bool
+}
+
+// Rules returns the effective rules in the .dockerignore file in dir, skipping blank lines and comments.
+func Rules(dir string) ([]Rule, error) {
+	patterns, err := readDockerIgnore(filepath.Join(dir, ".dockerignore"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Rule{}, nil
+		}
+		return nil, err
+	}
+	rules := []Rule{}
+	for i, line := range patterns {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		rules = append(rules, Rule{
+			LineNo:  i + 1,
+			Pattern: strings.TrimPrefix(trimmed, "!"),
+			Negate:  strings.HasPrefix(trimmed, "!"),
+		})
+	}
+	return rules, nil
+}
+
+// WalkContext walks all files in dir and returns them sorted by path, noting which .dockerignore rule excluded each one.
+func WalkContext(dir string) ([]ContextFile, error) {
+	matcher, err := CreateMatcher(dir)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := Rules(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []ContextFile{}
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			// Excluded directories, like ones full of weights or data, aren't walked unless a negated rule could
+			// re-include something in them
+			if rel == "." || matcher == nil {
+				return nil
+			}
+			if matches, pattern := matcher.MatchesPathHow(rel); matches && !negatedUnder(rules, filepath.ToSlash(rel)) {
+				result = append(result, ContextFile{Path: filepath.ToSlash(rel), Rule: ruleFromPattern(pattern), Dir: true})
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		file := ContextFile{Path: filepath.ToSlash(rel), Size: info.Size()}
+		if matcher != nil {
+			if matches, pattern := matcher.MatchesPathHow(rel); matches {
+				file.Rule = ruleFromPattern(pattern)
+			}
+		}
+		result = append(result, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result, nil
+}
+
+// negatedUnder returns whether a negated rule could match a path in dir. Patterns without a slash match at any
+// depth, and other patterns can only match under dir if the part before their first wildcard is a path in it, or
+// a parent of it.
+func negatedUnder(rules []Rule, dir string) bool {
+	for _, rule := range rules {
+		if !rule.Negate {
+			continue
+		}
+		pattern := strings.TrimPrefix(filepath.ToSlash(rule.Pattern), "/")
+		if !strings.Contains(strings.TrimSuffix(pattern, "/"), "/") || strings.HasPrefix(pattern, "**") {
+			return true
+		}
+		prefix := pattern
+		if i := strings.IndexAny(pattern, "*?["); i >= 0 {
+			prefix = pattern[:i]
+		}
+		if strings.HasPrefix(prefix, dir+"/") || strings.HasPrefix(dir+"/", prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Included filters files down to the ones that are sent to the build context
+func Included(files []ContextFile) []ContextFile {
+	included := []ContextFile{}
+	for _, f := range files {
+		if f.Rule == nil {
+			included = append(included, f)
+		}
+	}
+	return included
+}
+
+func ruleFromPattern(pattern *ignore.IgnorePattern) *Rule {
+	if pattern == nil {
+		return nil
+	}
+	line := strings.TrimSpace(pattern.Line)
+	return &Rule{
+		LineNo:  pattern.LineNo,
+		Pattern: strings.TrimPrefix(line, "!"),
+		Negate:  strings.HasPrefix(line, "!"),
+	}
+}
@@ -0,0 +1,75 @@
+package dockerignore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, dir string, path string, contents string) {
+	t.Helper()
+	full := filepath.Join(dir, path)
+	require.NoError(t, os.MkdirAll(filepath.Dir(full), 0o755))
+	require.NoError(t, os.WriteFile(full, []byte(contents), 0o644))
+}
+
+func TestRules(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, ".dockerignore", "# comment\n\nweights\n!weights/keep.bin\n")
+
+	rules, err := Rules(dir)
+	require.NoError(t, err)
+	require.Equal(t, []Rule{
+		{LineNo: 3, Pattern: "weights"},
+		{LineNo: 4, Pattern: "weights/keep.bin", Negate: true},
+	}, rules)
+}
+
+func TestRulesWithoutDockerignore(t *testing.T) {
+	rules, err := Rules(t.TempDir())
+	require.NoError(t, err)
+	require.Empty(t, rules)
+}
+
+func TestWalkContext(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, ".dockerignore", "*.log\nweights\n")
+	writeFile(t, dir, "predict.py", "print(1)")
+	writeFile(t, dir, "debug.log", "log")
+	writeFile(t, dir, "weights/model.bin", "12345")
+
+	files, err := WalkContext(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 4)
+
+	require.Equal(t, "debug.log", files[1].Path)
+	require.Equal(t, &Rule{LineNo: 1, Pattern: "*.log"}, files[1].Rule)
+	// Excluded directories aren't walked
+	require.Equal(t, ContextFile{Path: "weights", Rule: &Rule{LineNo: 2, Pattern: "weights"}, Dir: true}, files[3])
+
+	included := Included(files)
+	require.Len(t, included, 2)
+	require.Equal(t, ".dockerignore", included[0].Path)
+	require.Equal(t, "predict.py", included[1].Path)
+}
+
+func TestWalkContextNegatedInExcludedDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, ".dockerignore", "weights\ndata\n!weights/keep.bin\n")
+	writeFile(t, dir, "weights/model.bin", "12345")
+	writeFile(t, dir, "weights/keep.bin", "1")
+	writeFile(t, dir, "data/train.csv", "a,b")
+
+	files, err := WalkContext(dir)
+	require.NoError(t, err)
+	paths := []string{}
+	for _, f := range files {
+		paths = append(paths, f.Path)
+	}
+	// weights is walked because a file in it is re-included, but data isn't
+	require.Equal(t, []string{".dockerignore", "data", "weights/keep.bin", "weights/model.bin"}, paths)
+	require.Nil(t, files[2].Rule)
+	require.NotNil(t, files[3].Rule)
+}
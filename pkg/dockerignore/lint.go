@@ -0,0 +1,222 @@
+package dockerignore
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	ignore "github.com/sabhiram/go-gitignore"
+)
+
+// LintOptions describes the paths a .dockerignore file is checked against
+type LintOptions struct {
+	// Required are paths that must be sent to the build context, such as cog.yaml and the predictor
+	Required []string
+	// Weights are model weights files and directories, which shouldn't be re-included by negated rules
+	Weights []string
+	// Files are the files in dir from WalkContext, if they've already been listed. dir is walked if they're nil.
+	Files []ContextFile
+}
+
+// Problem is an issue found in a .dockerignore file
+type Problem struct {
+	LineNo  int
+	Message string
+	// Fix is a suggestion for how to fix the problem
+	Fix string
+	// Fatal problems will break the build
+	Fatal bool
+}
+
+func (p Problem) String() string {
+	s := fmt.Sprintf(".dockerignore line %d: %s", p.LineNo, p.Message)
+	if p.Fix != "" {
+		s += " (" + p.Fix + ")"
+	}
+	return s
+}
+
+// Lint checks the .dockerignore file in dir for rules that exclude files Cog needs,
+// redundant rules, rules that re-include model weights, and negated rules that have no effect.
+func Lint(dir string, options LintOptions) ([]Problem, error) {
+	rules, err := Rules(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return []Problem{}, nil
+	}
+	matcher, err := CreateMatcher(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := options.Files
+	if files == nil {
+		if files, err = WalkContext(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	problems := []Problem{}
+	problems = append(problems, lintRequired(matcher, options.Required)...)
+	problems = append(problems, lintRedundant(rules)...)
+	problems = append(problems, lintWeights(rules, options.Weights)...)
+	problems = append(problems, lintNegations(rules, files)...)
+	return problems, nil
+}
+
+func lintRequired(matcher *ignore.GitIgnore, required []string) []Problem {
+	problems := []Problem{}
+	for _, path := range required {
+		matches, pattern := matcher.MatchesPathHow(filepath.ToSlash(path))
+		if !matches || pattern == nil {
+			continue
+		}
+		problems = append(problems, Problem{
+			LineNo:  pattern.LineNo,
+			Message: fmt.Sprintf("%q excludes %s, which Cog needs to build the image", strings.TrimSpace(pattern.Line), path),
+			Fix:     fmt.Sprintf("remove line %d, or add \"!%s\" after it", pattern.LineNo, path),
+			Fatal:   true,
+		})
+	}
+	return problems
+}
+
+// lintRedundant finds rules that repeat or are covered by an earlier rule with no negation in between
+func lintRedundant(rules []Rule) []Problem {
+	problems := []Problem{}
+	for i, rule := range rules {
+		for _, earlier := range rules[:i] {
+			if earlier.Negate != rule.Negate || negatedBetween(rules, earlier, rule) {
+				continue
+			}
+			a, b := normalizePattern(earlier.Pattern), normalizePattern(rule.Pattern)
+			var message string
+			switch {
+			case a == b:
+				message = fmt.Sprintf("%q duplicates line %d", rule.Pattern, earlier.LineNo)
+			case !rule.Negate && !hasGlob(a) && strings.HasPrefix(b, a+"/"):
+				message = fmt.Sprintf("%q is already excluded by %q on line %d", rule.Pattern, earlier.Pattern, earlier.LineNo)
+			default:
+				continue
+			}
+			problems = append(problems, Problem{
+				LineNo:  rule.LineNo,
+				Message: message,
+				Fix:     fmt.Sprintf("remove line %d", rule.LineNo),
+			})
+			break
+		}
+	}
+	return problems
+}
+
+func lintWeights(rules []Rule, weights []string) []Problem {
+	problems := []Problem{}
+	for _, rule := range rules {
+		if !rule.Negate {
+			continue
+		}
+		single := ignore.CompileIgnoreLines(rule.Pattern)
+		for _, path := range weights {
+			if !single.MatchesPath(filepath.ToSlash(path)) {
+				continue
+			}
+			problems = append(problems, Problem{
+				LineNo:  rule.LineNo,
+				Message: fmt.Sprintf("\"!%s\" includes model weights %s in the image", rule.Pattern, path),
+				Fix:     fmt.Sprintf("narrow the pattern on line %d so it doesn't match %s", rule.LineNo, path),
+			})
+			break
+		}
+	}
+	return problems
+}
+
+// lintNegations finds negated rules that don't re-include anything, either because
+// no earlier rule excludes the paths they match or because a later rule excludes them again.
+func lintNegations(rules []Rule, files []ContextFile) []Problem {
+	problems := []Problem{}
+	for i, rule := range rules {
+		if !rule.Negate {
+			continue
+		}
+		single := ignore.CompileIgnoreLines(rule.Pattern)
+		paths := []string{}
+		for _, f := range files {
+			if single.MatchesPath(f.Path) {
+				paths = append(paths, f.Path)
+			}
+		}
+		if len(paths) == 0 && !hasGlob(rule.Pattern) {
+			paths = append(paths, normalizePattern(rule.Pattern))
+		}
+		if len(paths) == 0 {
+			continue
+		}
+
+		before := ignore.CompileIgnoreLines(rulePatterns(rules[:i])...)
+		excludedBefore := false
+		for _, path := range paths {
+			if before.MatchesPath(path) {
+				excludedBefore = true
+				break
+			}
+		}
+		if !excludedBefore {
+			problems = append(problems, Problem{
+				LineNo:  rule.LineNo,
+				Message: fmt.Sprintf("\"!%s\" has no effect because no earlier rule excludes it", rule.Pattern),
+				Fix:     fmt.Sprintf("move line %d below the rule it is meant to override", rule.LineNo),
+			})
+			continue
+		}
+
+		upTo := ignore.CompileIgnoreLines(rulePatterns(rules[:i+1])...)
+		for _, path := range paths {
+			if upTo.MatchesPath(path) {
+				continue
+			}
+			later := ignore.CompileIgnoreLines(rulePatterns(rules)...)
+			if matches, pattern := later.MatchesPathHow(path); matches && pattern != nil {
+				lineNo := rules[pattern.LineNo-1].LineNo
+				problems = append(problems, Problem{
+					LineNo:  rule.LineNo,
+					Message: fmt.Sprintf("\"!%s\" is overridden by %q on line %d", rule.Pattern, strings.TrimSpace(pattern.Line), lineNo),
+					Fix:     fmt.Sprintf("move line %d below line %d", rule.LineNo, lineNo),
+				})
+				break
+			}
+		}
+	}
+	return problems
+}
+
+func negatedBetween(rules []Rule, from Rule, to Rule) bool {
+	for _, r := range rules {
+		if r.LineNo > from.LineNo && r.LineNo < to.LineNo && r.Negate {
+			return true
+		}
+	}
+	return false
+}
+
+func rulePatterns(rules []Rule) []string {
+	patterns := []string{}
+	for _, r := range rules {
+		if r.Negate {
+			patterns = append(patterns, "!"+r.Pattern)
+		} else {
+			patterns = append(patterns, r.Pattern)
+		}
+	}
+	return patterns
+}
+
+func normalizePattern(pattern string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(pattern, "./"), "/"), "/")
+}
+
+func hasGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
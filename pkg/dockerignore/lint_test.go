@@ -0,0 +1,83 @@
+package dockerignore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLintRequired(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, ".dockerignore", "# ignore python\n*.py\n")
+	writeFile(t, dir, "predict.py", "")
+
+	problems, err := Lint(dir, LintOptions{Required: []string{"cog.yaml", "predict.py"}})
+	require.NoError(t, err)
+	require.Equal(t, []Problem{{
+		LineNo:  2,
+		Message: `"*.py" excludes predict.py, which Cog needs to build the image`,
+		Fix:     `remove line 2, or add "!predict.py" after it`,
+		Fatal:   true,
+	}}, problems)
+}
+
+func TestLintRedundant(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, ".dockerignore", "data\n/data/\ndata/raw\n*.log\n")
+
+	problems, err := Lint(dir, LintOptions{})
+	require.NoError(t, err)
+	require.Len(t, problems, 2)
+	require.Equal(t, 2, problems[0].LineNo)
+	require.Equal(t, `"/data/" duplicates line 1`, problems[0].Message)
+	require.Equal(t, 3, problems[1].LineNo)
+	require.Equal(t, `"data/raw" is already excluded by "data" on line 1`, problems[1].Message)
+	require.False(t, problems[1].Fatal)
+}
+
+func TestLintWeights(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, ".dockerignore", "checkpoints\n!checkpoints/*.safetensors\n")
+	writeFile(t, dir, "checkpoints/model.safetensors", "")
+
+	problems, err := Lint(dir, LintOptions{Weights: []string{"checkpoints/model.safetensors"}})
+	require.NoError(t, err)
+	require.Len(t, problems, 1)
+	require.Equal(t, `"!checkpoints/*.safetensors" includes model weights checkpoints/model.safetensors in the image`, problems[0].Message)
+}
+
+func TestLintNegationBeforeExclusion(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, ".dockerignore", "!data/keep.txt\ndata\n")
+	writeFile(t, dir, "data/keep.txt", "")
+
+	problems, err := Lint(dir, LintOptions{})
+	require.NoError(t, err)
+	require.Len(t, problems, 1)
+	require.Equal(t, 1, problems[0].LineNo)
+	require.Equal(t, `"!data/keep.txt" has no effect because no earlier rule excludes it`, problems[0].Message)
+	require.Equal(t, "move line 1 below the rule it is meant to override", problems[0].Fix)
+}
+
+func TestLintNegationOverridden(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, ".dockerignore", "*.txt\n!keep.txt\nkeep*\n")
+	writeFile(t, dir, "keep.txt", "")
+
+	problems, err := Lint(dir, LintOptions{})
+	require.NoError(t, err)
+	require.Len(t, problems, 1)
+	require.Equal(t, `"!keep.txt" is overridden by "keep*" on line 3`, problems[0].Message)
+	require.Equal(t, "move line 2 below line 3", problems[0].Fix)
+}
+
+func TestLintClean(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, ".dockerignore", "data\n!data/keep.txt\n")
+	writeFile(t, dir, "data/keep.txt", "")
+	writeFile(t, dir, "predict.py", "")
+
+	problems, err := Lint(dir, LintOptions{Required: []string{"predict.py"}})
+	require.NoError(t, err)
+	require.Empty(t, problems)
+}
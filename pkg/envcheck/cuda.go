@@ -0,0 +1,113 @@
+package envcheck
+
+import (
+	"sort"
+	"strings"
+)
+
+// cudaDrivers are the minimum Linux NVIDIA driver versions for each CUDA toolkit, from CUDA's release notes
+var cudaDrivers = map[string]string{
+	"9.0":  "384.81",
+	"9.1":  "387.26",
+	"9.2":  "396.26",
+	"10.0": "410.48",
+	"10.1": "418.39",
+	"10.2": "440.33",
+	"11.0": "450.36.06",
+	"11.1": "455.23",
+	"11.2": "460.27.03",
+	"11.3": "465.19.01",
+	"11.4": "470.42.01",
+	"11.5": "495.29.05",
+	"11.6": "510.39.01",
+	"11.7": "515.43.04",
+	"11.8": "520.61.05",
+	"12.0": "525.60.13",
+	"12.1": "530.30.02",
+	"12.2": "535.54.03",
+	"12.3": "545.23.06",
+	"12.4": "550.54.14",
+	"12.5": "555.42.02",
+	"12.6": "560.28.03",
+	"12.8": "570.26",
+	"12.9": "575.51.03",
+}
+
+// cudaMinorCompatibilityDrivers are the oldest drivers that can run any CUDA toolkit of a major version, with CUDA's
+// minor version compatibility
+var cudaMinorCompatibilityDrivers = map[string]string{
+	"11": "450.80.02",
+	"12": "525.60.13",
+}
+
+// cudaMinComputeCapabilities are the oldest GPU architectures each major version of CUDA supports
+var cudaMinComputeCapabilities = map[string]string{
+	"9":  "3.0",
+	"10": "3.0",
+	"11": "3.5",
+	"12": "5.0",
+}
+
+// computeCapabilityCUDAs are the first CUDA versions that support each GPU architecture
+var computeCapabilityCUDAs = map[string]string{
+	"7.0":  "9.0",
+	"7.5":  "10.0",
+	"8.0":  "11.0",
+	"8.6":  "11.1",
+	"8.9":  "11.8",
+	"9.0":  "11.8",
+	"10.0": "12.8",
+	"12.0": "12.8",
+}
+
+// gpuComputeCapabilities are the compute capabilities of common data center and workstation GPUs, by the name
+// nvidia-smi reports them with, without the "NVIDIA" or "Tesla" prefix
+var gpuComputeCapabilities = map[string]string{
+	"K80":       "3.7",
+	"M60":       "5.2",
+	"P4":        "6.1",
+	"P40":       "6.1",
+	"P100":      "6.0",
+	"V100":      "7.0",
+	"T4":        "7.5",
+	"RTX 2080":  "7.5",
+	"A100":      "8.0",
+	"A30":       "8.0",
+	"A10":       "8.6",
+	"A10G":      "8.6",
+	"A40":       "8.6",
+	"RTX A6000": "8.6",
+	"RTX 3090":  "8.6",
+	"L4":        "8.9",
+	"L40":       "8.9",
+	"L40S":      "8.9",
+	"RTX 4090":  "8.9",
+	"H100":      "9.0",
+	"H200":      "9.0",
+	"GH200":     "9.0",
+	"B100":      "10.0",
+	"B200":      "10.0",
+	"GB200":     "10.0",
+	"RTX 5090":  "12.0",
+}
+
+// computeCapability returns the compute capability of a GPU, like "NVIDIA A100-SXM4-80GB" or "a100", and the model
+// it was matched to
+func computeCapability(gpu string) (string, string, bool) {
+	name := strings.ToUpper(gpu)
+	for _, prefix := range []string{"NVIDIA ", "TESLA ", "GEFORCE "} {
+		name = strings.TrimPrefix(name, prefix)
+	}
+	// Match the longest model first, so A100 isn't matched as A10
+	models := make([]string, 0, len(gpuComputeCapabilities))
+	for model := range gpuComputeCapabilities {
+		models = append(models, model)
+	}
+	sort.Slice(models, func(i, j int) bool { return len(models[i]) > len(models[j]) })
+	for _, model := range models {
+		if name == model || strings.HasPrefix(name, model+"-") || strings.HasPrefix(name, model+" ") {
+			return gpuComputeCapabilities[model], model, true
+		}
+	}
+	return "", "", false
+}
@@ -0,0 +1,205 @@
+// Package envcheck checks a model image against the environment it'll be deployed to, like a node in a Kubernetes
+// cluster, so incompatibilities are found before it's deployed.
+package envcheck
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/replicate/cog/pkg/config"
+)
+
+// Severities of problems. Errors mean the model won't run in the environment, and warnings that it might not.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Environment is where a model will be deployed, as it's declared in an environment file
+type Environment struct {
+	// Arch is the CPU architecture, like amd64 or arm64
+	Arch string `json:"arch,omitempty" yaml:"arch"`
+	// GPU is the GPU model, like "NVIDIA A100-SXM4-80GB" as it's reported by nvidia-smi, or just "A100"
+	GPU string `json:"gpu,omitempty" yaml:"gpu"`
+	// GPUCount is the number of GPUs the model can use
+	GPUCount int `json:"gpu_count,omitempty" yaml:"gpu_count"`
+	// Driver is the NVIDIA driver version, like 535.104.05
+	Driver string `json:"driver,omitempty" yaml:"driver"`
+}
+
+// Image is what a model image needs from the environment it runs in
+type Image struct {
+	Arch     string
+	GPU      bool
+	GPUCount int
+	CUDA     string
+}
+
+// Problem is an incompatibility between an image and an environment
+type Problem struct {
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s", p.Severity, p.Message)
+}
+
+// LoadEnvironment reads an environment file
+func LoadEnvironment(path string) (*Environment, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read environment file: %w", err)
+	}
+	env := new(Environment)
+	if err := yaml.UnmarshalStrict(data, env); err != nil {
+		return nil, fmt.Errorf("Failed to parse environment file %s: %w", path, err)
+	}
+	if env.GPU != "" && env.GPUCount == 0 {
+		env.GPUCount = 1
+	}
+	return env, nil
+}
+
+// NewImage returns what an image needs from its architecture and the cog.yaml it was built from
+func NewImage(arch string, cfg *config.Config) Image {
+	image := Image{Arch: arch}
+	if cfg.Build != nil {
+		image.GPU = cfg.Build.GPU
+		image.GPUCount = cfg.Build.GPUCount
+		image.CUDA = cfg.Build.CUDA
+	}
+	if image.GPU && image.GPUCount == 0 {
+		image.GPUCount = 1
+	}
+	return image
+}
+
+// Check returns the problems running image in env would have
+func Check(image Image, env Environment) []Problem {
+	problems := []Problem{}
+	add := func(severity string, format string, args ...any) {
+		problems = append(problems, Problem{Severity: severity, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if env.Arch != "" && image.Arch != "" && normalizeArch(env.Arch) != normalizeArch(image.Arch) {
+		add(SeverityError, "The image is built for %s, but the environment is %s", normalizeArch(image.Arch), normalizeArch(env.Arch))
+	}
+
+	if !image.GPU {
+		return problems
+	}
+	if env.GPUCount == 0 {
+		add(SeverityError, "The model needs %s, but the environment doesn't have any", pluralGPUs(image.GPUCount))
+		return problems
+	}
+	if env.GPUCount < image.GPUCount {
+		add(SeverityError, "The model needs %s, but the environment has %d", pluralGPUs(image.GPUCount), env.GPUCount)
+	}
+	if image.CUDA == "" {
+		return problems
+	}
+
+	if env.Driver != "" {
+		checkDriver(image.CUDA, env.Driver, add)
+	}
+	if env.GPU != "" {
+		checkGPU(image.CUDA, env.GPU, add)
+	}
+	return problems
+}
+
+// HasErrors returns whether any of the problems are errors
+func HasErrors(problems []Problem) bool {
+	for _, p := range problems {
+		if p.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func checkDriver(cuda string, driver string, add func(string, string, ...any)) {
+	required, ok := cudaDrivers[majorMinor(cuda)]
+	if !ok {
+		add(SeverityWarning, "Cog doesn't know which NVIDIA driver CUDA %s needs, so it can't check driver %s", cuda, driver)
+		return
+	}
+	if compareVersions(driver, required) >= 0 {
+		return
+	}
+	// CUDA's minor version compatibility lets an older driver from the same major version run it, without some
+	// features like PTX JIT compilation
+	major := strings.Split(cuda, ".")[0]
+	if minimum, ok := cudaMinorCompatibilityDrivers[major]; ok && compareVersions(driver, minimum) >= 0 {
+		add(SeverityWarning, "The image uses CUDA %s, which needs NVIDIA driver %s or later, but the environment has %s. It might run with CUDA's minor version compatibility, but PTX JIT compilation won't work.", cuda, required, driver)
+		return
+	}
+	add(SeverityError, "The image uses CUDA %s, which needs NVIDIA driver %s or later, but the environment has %s", cuda, required, driver)
+}
+
+func checkGPU(cuda string, gpu string, add func(string, string, ...any)) {
+	capability, name, ok := computeCapability(gpu)
+	if !ok {
+		add(SeverityWarning, "Cog doesn't know the compute capability of %q, so it can't check the image's CUDA %s supports it", gpu, cuda)
+		return
+	}
+	major := strings.Split(cuda, ".")[0]
+	if minimum, ok := cudaMinComputeCapabilities[major]; ok && compareVersions(capability, minimum) < 0 {
+		add(SeverityError, "The %s GPU has compute capability %s, which CUDA %s doesn't support. CUDA %s.x needs compute capability %s or later.", name, capability, cuda, major, minimum)
+	}
+	if required, ok := computeCapabilityCUDAs[capability]; ok && compareVersions(cuda, required) < 0 {
+		add(SeverityError, "The %s GPU has compute capability %s, which needs CUDA %s or later, but the image uses CUDA %s", name, capability, required, cuda)
+	}
+}
+
+func pluralGPUs(n int) string {
+	if n == 1 {
+		return "a GPU"
+	}
+	return fmt.Sprintf("%d GPUs", n)
+}
+
+func normalizeArch(arch string) string {
+	arch = strings.TrimPrefix(strings.ToLower(arch), "linux/")
+	switch arch {
+	case "x86_64", "x86-64", "amd64":
+		return "amd64"
+	case "aarch64", "arm64", "arm64/v8":
+		return "arm64"
+	}
+	return arch
+}
+
+func majorMinor(version string) string {
+	parts := strings.Split(version, ".")
+	if len(parts) < 2 {
+		return version
+	}
+	return parts[0] + "." + parts[1]
+}
+
+// compareVersions compares dotted numeric versions like 535.104.05, returning -1, 0 or 1
+func compareVersions(a string, b string) int {
+	as := strings.Split(a, ".")
+	bs := strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			_, _ = fmt.Sscanf(as[i], "%d", &x)
+		}
+		if i < len(bs) {
+			_, _ = fmt.Sscanf(bs[i], "%d", &y)
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
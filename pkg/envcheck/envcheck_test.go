@@ -0,0 +1,128 @@
+package envcheck
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/config"
+)
+
+func messages(problems []Problem) []string {
+	result := []string{}
+	for _, p := range problems {
+		result = append(result, p.String())
+	}
+	return result
+}
+
+func TestCheck(t *testing.T) {
+	gpuImage := Image{Arch: "amd64", GPU: true, GPUCount: 1, CUDA: "12.1"}
+	for _, tt := range []struct {
+		name     string
+		image    Image
+		env      Environment
+		problems []string
+	}{
+		{
+			name:     "compatible",
+			image:    gpuImage,
+			env:      Environment{Arch: "x86_64", GPU: "NVIDIA A100-SXM4-80GB", GPUCount: 8, Driver: "535.104.05"},
+			problems: []string{},
+		},
+		{
+			name:     "cpu model",
+			image:    Image{Arch: "amd64"},
+			env:      Environment{Arch: "linux/amd64"},
+			problems: []string{},
+		},
+		{
+			name:     "wrong arch",
+			image:    Image{Arch: "amd64"},
+			env:      Environment{Arch: "aarch64"},
+			problems: []string{"error: The image is built for amd64, but the environment is arm64"},
+		},
+		{
+			name:     "no gpus",
+			image:    gpuImage,
+			env:      Environment{Arch: "amd64"},
+			problems: []string{"error: The model needs a GPU, but the environment doesn't have any"},
+		},
+		{
+			name:     "too few gpus",
+			image:    Image{GPU: true, GPUCount: 4, CUDA: "12.1"},
+			env:      Environment{GPU: "H100", GPUCount: 2},
+			problems: []string{"error: The model needs 4 GPUs, but the environment has 2"},
+		},
+		{
+			name:     "old driver",
+			image:    gpuImage,
+			env:      Environment{GPU: "A10G", GPUCount: 1, Driver: "470.182.03"},
+			problems: []string{"error: The image uses CUDA 12.1, which needs NVIDIA driver 530.30.02 or later, but the environment has 470.182.03"},
+		},
+		{
+			name:     "minor version compatibility",
+			image:    gpuImage,
+			env:      Environment{GPUCount: 1, Driver: "525.105.17"},
+			problems: []string{"warning: The image uses CUDA 12.1, which needs NVIDIA driver 530.30.02 or later, but the environment has 525.105.17. It might run with CUDA's minor version compatibility, but PTX JIT compilation won't work."},
+		},
+		{
+			name:     "gpu too old for cuda",
+			image:    gpuImage,
+			env:      Environment{GPU: "Tesla K80", GPUCount: 1},
+			problems: []string{"error: The K80 GPU has compute capability 3.7, which CUDA 12.1 doesn't support. CUDA 12.x needs compute capability 5.0 or later."},
+		},
+		{
+			name:     "cuda too old for gpu",
+			image:    Image{GPU: true, GPUCount: 1, CUDA: "11.7"},
+			env:      Environment{GPU: "NVIDIA H100 80GB HBM3", GPUCount: 1},
+			problems: []string{"error: The H100 GPU has compute capability 9.0, which needs CUDA 11.8 or later, but the image uses CUDA 11.7"},
+		},
+		{
+			name:     "unknown gpu",
+			image:    gpuImage,
+			env:      Environment{GPU: "Acme Tensor 9000", GPUCount: 1},
+			problems: []string{`warning: Cog doesn't know the compute capability of "Acme Tensor 9000", so it can't check the image's CUDA 12.1 supports it`},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.problems, messages(Check(tt.image, tt.env)))
+		})
+	}
+}
+
+func TestComputeCapability(t *testing.T) {
+	capability, name, ok := computeCapability("NVIDIA A100-SXM4-80GB")
+	require.True(t, ok)
+	require.Equal(t, "8.0", capability)
+	require.Equal(t, "A100", name)
+
+	capability, name, ok = computeCapability("NVIDIA A10G")
+	require.True(t, ok)
+	require.Equal(t, "8.6", capability)
+	require.Equal(t, "A10G", name)
+
+	capability, _, ok = computeCapability("NVIDIA GeForce RTX 4090")
+	require.True(t, ok)
+	require.Equal(t, "8.9", capability)
+}
+
+func TestLoadEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "env.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("arch: amd64\ngpu: NVIDIA L4\ndriver: 550.54.15\n"), 0o644))
+	env, err := LoadEnvironment(path)
+	require.NoError(t, err)
+	require.Equal(t, &Environment{Arch: "amd64", GPU: "NVIDIA L4", GPUCount: 1, Driver: "550.54.15"}, env)
+
+	require.NoError(t, os.WriteFile(path, []byte("gpus: 2\n"), 0o644))
+	_, err = LoadEnvironment(path)
+	require.ErrorContains(t, err, "field gpus not found")
+}
+
+func TestNewImage(t *testing.T) {
+	image := NewImage("amd64", &config.Config{Build: &config.Build{GPU: true, CUDA: "12.4"}})
+	require.Equal(t, Image{Arch: "amd64", GPU: true, GPUCount: 1, CUDA: "12.4"}, image)
+}
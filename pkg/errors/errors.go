@@ -1,9 +1,51 @@
 package errors
 
+import (
+	"errors"
+)
+
 const (
 	CodeConfigNotFound = "CONFIG_NOT_FOUND"
 )
 
+// Category is a kind of failure, which the CLI exits with its own code for, so scripts can tell them apart
+type Category string
+
+const (
+	CategoryUnknown           Category = "error"
+	CategoryConfig            Category = "config_error"
+	CategoryDockerUnavailable Category = "docker_unavailable"
+	CategoryBuild             Category = "build_failed"
+	CategorySchema            Category = "schema_invalid"
+	CategoryPushAuth          Category = "push_auth_failed"
+	CategoryPrediction        Category = "prediction_failed"
+)
+
+// exitCodes are the codes the CLI exits with for each category. They're part of the CLI's interface, so they mustn't
+// change.
+var exitCodes = map[Category]int{
+	CategoryUnknown:           1,
+	CategoryConfig:            3,
+	CategoryDockerUnavailable: 4,
+	CategoryBuild:             5,
+	CategorySchema:            6,
+	CategoryPushAuth:          7,
+	CategoryPrediction:        8,
+}
+
+// ExitCode is the code the CLI exits with for errors in the category
+func (c Category) ExitCode() int {
+	if code, ok := exitCodes[c]; ok {
+		return code
+	}
+	return exitCodes[CategoryUnknown]
+}
+
+// Categories returns every category, in the order of their exit codes
+func Categories() []Category {
+	return []Category{CategoryUnknown, CategoryConfig, CategoryDockerUnavailable, CategoryBuild, CategorySchema, CategoryPushAuth, CategoryPrediction}
+}
+
 // Types ////////////////////////////////////////
 
 type CodedError interface {
@@ -23,6 +65,19 @@ func (e *codedError) Code() string {
 	return e.code
 }
 
+type categorizedError struct {
+	category Category
+	err      error
+}
+
+func (e *categorizedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *categorizedError) Unwrap() error {
+	return e.err
+}
+
 // Error Creators ///////////////////////////////
 
 // The Cog config was not found
@@ -33,6 +88,15 @@ func ConfigNotFound(msg string) error {
 	}
 }
 
+// WithCategory marks err as a failure of the category. Errors that already have a category keep it, so the most
+// specific one, closest to where the error happened, wins. It returns nil if err is nil.
+func WithCategory(category Category, err error) error {
+	if err == nil || CategoryOf(err) != CategoryUnknown {
+		return err
+	}
+	return &categorizedError{category: category, err: err}
+}
+
 // Helpers //////////////////////////////////////
 
 func IsConfigNotFound(err error) bool {
@@ -47,3 +111,16 @@ func Code(err error) string {
 
 	return ""
 }
+
+// CategoryOf returns the category of err, or CategoryUnknown if it doesn't have one
+func CategoryOf(err error) Category {
+	var categorized *categorizedError
+	if errors.As(err, &categorized) {
+		return categorized.category
+	}
+	var coded CodedError
+	if errors.As(err, &coded) && coded.Code() == CodeConfigNotFound {
+		return CategoryConfig
+	}
+	return CategoryUnknown
+}
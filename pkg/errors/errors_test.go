@@ -0,0 +1,37 @@
+package errors
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCategoryOf(t *testing.T) {
+	require.Equal(t, CategoryUnknown, CategoryOf(errors.New("boom")))
+	require.Equal(t, CategoryConfig, CategoryOf(ConfigNotFound("cog.yaml not found")))
+	require.NoError(t, WithCategory(CategoryBuild, nil))
+
+	schemaErr := WithCategory(CategorySchema, errors.New("Model schema is invalid"))
+	require.Equal(t, CategorySchema, CategoryOf(schemaErr))
+	require.Equal(t, "Model schema is invalid", schemaErr.Error())
+
+	// The category closest to where the error happened wins
+	wrapped := WithCategory(CategoryBuild, fmt.Errorf("Failed to build: %w", schemaErr))
+	require.Equal(t, CategorySchema, CategoryOf(wrapped))
+	require.Equal(t, "Failed to build: Model schema is invalid", wrapped.Error())
+}
+
+func TestExitCodes(t *testing.T) {
+	seen := map[int]Category{}
+	for _, category := range Categories() {
+		code := category.ExitCode()
+		_, duplicate := seen[code]
+		require.False(t, duplicate, "%s has the same exit code as %s", category, seen[code])
+		seen[code] = category
+	}
+	require.Equal(t, 1, CategoryUnknown.ExitCode())
+	require.Equal(t, 1, Category("something_else").ExitCode())
+	require.Equal(t, 8, CategoryPrediction.ExitCode())
+}
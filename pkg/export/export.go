@@ -0,0 +1,150 @@
+package export
+
+import (
+	// blank import for embeds
+	_ "embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/dockercontext"
+	"github.com/replicate/cog/pkg/util"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+//go:embed export.py
+var exportScript []byte
+
+const (
+	FormatONNX     = "onnx"
+	FormatTensorRT = "tensorrt"
+
+	// ExportedModelEnvVarName is set in optimized images to the path of the exported model inside the container.
+	ExportedModelEnvVarName = "COG_EXPORTED_MODEL"
+)
+
+var Formats = []string{FormatONNX, FormatTensorRT}
+
+type Options struct {
+	Format string
+	Output string
+	Opset  int
+	FP16   bool
+	GPUs   string
+}
+
+// DefaultOutput returns the default path for an exported artifact, relative to the project directory.
+func DefaultOutput(format string) string {
+	if format == FormatTensorRT {
+		return "model.plan"
+	}
+	return "model.onnx"
+}
+
+func ValidateFormat(format string) error {
+	for _, f := range Formats {
+		if f == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unsupported export format %q, must be one of: %s", format, strings.Join(Formats, ", "))
+}
+
+// Run runs the export script inside imageName with the project directory mounted at /src.
+func Run(projectDir string, imageName string, options Options) error {
+	if err := ValidateFormat(options.Format); err != nil {
+		return err
+	}
+	output, err := containerPath(projectDir, options.Output)
+	if err != nil {
+		return err
+	}
+
+	scriptDir, err := dockercontext.BuildCogTempDir(projectDir, "export")
+	if err != nil {
+		return err
+	}
+	defer dockercontext.RemoveAll(scriptDir)
+	scriptPath := filepath.Join(scriptDir, "export.py")
+	if err := os.WriteFile(scriptPath, exportScript, 0o644); err != nil {
+		return fmt.Errorf("Failed to write export script: %w", err)
+	}
+	relativeScriptPath, err := filepath.Rel(projectDir, scriptPath)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"python", filepath.ToSlash(relativeScriptPath), options.Format, "--output", output}
+	if options.Opset > 0 {
+		args = append(args, "--opset", fmt.Sprintf("%d", options.Opset))
+	}
+	if options.FP16 {
+		args = append(args, "--fp16")
+	}
+
+	runOptions := docker.RunOptions{
+		Args:    args,
+		GPUs:    options.GPUs,
+		Image:   imageName,
+		Volumes: []docker.Volume{{Source: projectDir, Destination: "/src"}},
+		Workdir: "/src",
+	}
+	if util.IsAppleSiliconMac(runtime.GOOS, runtime.GOARCH) {
+		runOptions.Platform = "linux/amd64"
+	}
+
+	console.Infof("Exporting model to %s...", options.Format)
+	err = docker.Run(runOptions)
+	if runOptions.GPUs == "all" && err == docker.ErrMissingDeviceDriver {
+		console.Info("Missing device driver, re-trying without GPU")
+		runOptions.GPUs = ""
+		err = docker.Run(runOptions)
+	}
+	return err
+}
+
+// OptimizedDockerfile returns a Dockerfile that adds the exported artifact to baseImage.
+func OptimizedDockerfile(baseImage string, output string) string {
+	output = filepath.ToSlash(output)
+	return strings.Join([]string{
+		"FROM " + baseImage,
+		"COPY " + output + " /src/" + output,
+		fmt.Sprintf("ENV %s=/src/%s", ExportedModelEnvVarName, output),
+	}, "\n")
+}
+
+// BuildOptimizedImage builds imageName from baseImage with the exported artifact added to it.
+func BuildOptimizedImage(projectDir string, baseImage string, imageName string, output string, progressOutput string) error {
+	relativeOutput, err := relativePath(projectDir, output)
+	if err != nil {
+		return err
+	}
+	console.Infof("Building optimized image %s...", imageName)
+	return docker.Build(projectDir, OptimizedDockerfile(baseImage, relativeOutput), imageName, []string{}, nil, false, progressOutput, config.BuildSourceEpochTimestamp, dockercontext.StandardBuildDirectory, nil)
+}
+
+func relativePath(projectDir string, output string) (string, error) {
+	if !filepath.IsAbs(output) {
+		output = filepath.Join(projectDir, output)
+	}
+	rel, err := filepath.Rel(projectDir, output)
+	if err != nil {
+		return "", err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("Export output %s must be inside the project directory %s", output, projectDir)
+	}
+	return rel, nil
+}
+
+func containerPath(projectDir string, output string) (string, error) {
+	rel, err := relativePath(projectDir, output)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(filepath.Join("/src", rel)), nil
+}
@@ -0,0 +1,79 @@
+# Standard export script run by `cog export` inside the model environment.
+#
+# The predictor is set up as usual, then the model to export is taken from
+# `predictor.export_model()` if defined, otherwise `predictor.model`. Example
+# inputs used for tracing come from `predictor.export_inputs()`.
+import argparse
+import importlib.util
+import os
+import shutil
+import subprocess
+import sys
+import tempfile
+
+import yaml
+
+
+def load_predictor(ref):
+    module_path, class_name = ref.split(":", 1)
+    spec = importlib.util.spec_from_file_location("predict", module_path)
+    module = importlib.util.module_from_spec(spec)
+    sys.modules["predict"] = module
+    spec.loader.exec_module(module)
+    return getattr(module, class_name)()
+
+
+def export_onnx(model, inputs, output, opset):
+    import torch
+
+    model.eval()
+    if not isinstance(inputs, tuple):
+        inputs = (inputs,)
+    with torch.no_grad():
+        torch.onnx.export(model, inputs, output, opset_version=opset)
+
+
+def export_tensorrt(model, inputs, output, opset, fp16):
+    with tempfile.TemporaryDirectory() as tmp:
+        onnx_path = os.path.join(tmp, "model.onnx")
+        export_onnx(model, inputs, onnx_path, opset)
+        trtexec = shutil.which("trtexec") or "/usr/src/tensorrt/bin/trtexec"
+        cmd = [trtexec, "--onnx=" + onnx_path, "--saveEngine=" + output]
+        if fp16:
+            cmd.append("--fp16")
+        subprocess.run(cmd, check=True)
+
+
+def main():
+    parser = argparse.ArgumentParser()
+    parser.add_argument("format", choices=["onnx", "tensorrt"])
+    parser.add_argument("--output", required=True)
+    parser.add_argument("--opset", type=int, default=17)
+    parser.add_argument("--fp16", action="store_true")
+    args = parser.parse_args()
+
+    with open("cog.yaml") as f:
+        config = yaml.safe_load(f)
+    predictor = load_predictor(config["predict"])
+    predictor.setup()
+
+    if hasattr(predictor, "export_model"):
+        model = predictor.export_model()
+    elif hasattr(predictor, "model"):
+        model = predictor.model
+    else:
+        sys.exit("Predictor must define export_model() or a `model` attribute to be exported")
+    if not hasattr(predictor, "export_inputs"):
+        sys.exit("Predictor must define export_inputs() returning example inputs for the model")
+    inputs = predictor.export_inputs()
+
+    os.makedirs(os.path.dirname(os.path.abspath(args.output)), exist_ok=True)
+    if args.format == "onnx":
+        export_onnx(model, inputs, args.output, args.opset)
+    else:
+        export_tensorrt(model, inputs, args.output, args.opset, args.fp16)
+    print("Exported " + args.format + " model to " + args.output)
+
+
+if __name__ == "__main__":
+    main()
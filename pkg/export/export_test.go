@@ -0,0 +1,41 @@
+package export
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateFormat(t *testing.T) {
+	require.NoError(t, ValidateFormat("onnx"))
+	require.NoError(t, ValidateFormat("tensorrt"))
+	require.Error(t, ValidateFormat("tflite"))
+}
+
+func TestContainerPath(t *testing.T) {
+	dir := t.TempDir()
+
+	p, err := containerPath(dir, "exports/model.onnx")
+	require.NoError(t, err)
+	require.Equal(t, "/src/exports/model.onnx", p)
+
+	p, err = containerPath(dir, filepath.Join(dir, "model.plan"))
+	require.NoError(t, err)
+	require.Equal(t, "/src/model.plan", p)
+
+	p, err = containerPath(dir, "..data/model.onnx")
+	require.NoError(t, err)
+	require.Equal(t, "/src/..data/model.onnx", p)
+
+	_, err = containerPath(dir, "../model.onnx")
+	require.Error(t, err)
+	_, err = containerPath(dir, filepath.Join(dir, ".."))
+	require.Error(t, err)
+}
+
+func TestOptimizedDockerfile(t *testing.T) {
+	require.Equal(t, `FROM r8.im/user/model
+COPY model.plan /src/model.plan
+ENV COG_EXPORTED_MODEL=/src/model.plan`, OptimizedDockerfile("r8.im/user/model", "model.plan"))
+}
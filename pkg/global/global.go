@@ -10,4 +10,6 @@ var (
 	ReplicateRegistryHost = "r8.im"
 	ReplicateWebsiteHost  = "replicate.com"
 	LabelNamespace        = "run.cog."
+	// Environment is the named project environment selected with --environment
+	Environment = ""
 )
@@ -0,0 +1,136 @@
+package hooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/docker/command"
+	"github.com/replicate/cog/pkg/dockercontext"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+const (
+	PreBuild   = "pre-build"
+	PrePush    = "pre-push"
+	PrePromote = "pre-promote"
+)
+
+// Dir returns where the hooks for the project in projectDir are, which is .cog/hooks
+func Dir(projectDir string) string {
+	return filepath.Join(projectDir, dockercontext.CogBuildArtifactsFolder, "hooks")
+}
+
+// Context is what a hook is running for. It's written to the hook's stdin as JSON.
+type Context struct {
+	Hook       string         `json:"hook"`
+	Image      string         `json:"image"`
+	ProjectDir string         `json:"project_dir"`
+	Config     *config.Config `json:"config"`
+	// Source is the image being promoted, for pre-promote hooks
+	Source string `json:"source,omitempty"`
+	// Labels, Schema and Size are set for pre-push and pre-promote hooks, which run after the image is built
+	Labels map[string]string `json:"labels,omitempty"`
+	Schema json.RawMessage   `json:"schema,omitempty"`
+	Size   int64             `json:"size,omitempty"`
+}
+
+// RunPreBuild runs the pre-build hook before imageName is built
+func RunPreBuild(cfg *config.Config, projectDir string, imageName string) error {
+	return Run(Context{Hook: PreBuild, Image: imageName, ProjectDir: projectDir, Config: cfg})
+}
+
+// RunPrePush runs the pre-push hook before imageName is pushed, with the labels, schema and size of the built image
+func RunPrePush(cfg *config.Config, projectDir string, imageName string) error {
+	if _, err := os.Stat(filepath.Join(Dir(projectDir), PrePush)); errors.Is(err, os.ErrNotExist) {
+		// Don't inspect the image if there's no hook
+		return nil
+	}
+	inspect, err := docker.ImageInspect(imageName)
+	if err != nil {
+		return fmt.Errorf("Failed to inspect %s for the %s hook: %w", imageName, PrePush, err)
+	}
+	context := Context{Hook: PrePush, Image: imageName, ProjectDir: projectDir, Config: cfg}
+	if inspect.Config != nil {
+		context.setImage(inspect.Config.Labels, inspect.Size)
+	}
+	return Run(context)
+}
+
+// RunPrePromote runs the pre-promote hook before source is copied to target in another registry, with the
+// labels and size of the image in the registry. The config is the one the image was built with.
+func RunPrePromote(projectDir string, source string, target string, labels map[string]string, size int64) error {
+	context := Context{Hook: PrePromote, Image: target, Source: source, ProjectDir: projectDir}
+	if cfg := labels[command.CogConfigLabelKey]; cfg != "" {
+		context.Config = new(config.Config)
+		if err := json.Unmarshal([]byte(cfg), context.Config); err != nil {
+			return fmt.Errorf("Failed to parse config from %s: %w", source, err)
+		}
+	}
+	context.setImage(labels, size)
+	return Run(context)
+}
+
+func (c *Context) setImage(labels map[string]string, size int64) {
+	c.Labels = labels
+	c.Size = size
+	if schema := labels[command.CogOpenAPISchemaLabelKey]; json.Valid([]byte(schema)) {
+		c.Schema = json.RawMessage(schema)
+	}
+}
+
+// Run runs the hook named context.Hook in the project, if there is one. Hooks are executables in .cog/hooks, like
+// Git hooks. They're run in the project directory with the context as JSON on stdin, and the image name in
+// COG_IMAGE. If a hook exits with a non-zero status, Run returns an error with what the hook printed, so the
+// operation is aborted.
+func Run(context Context) error {
+	hookPath := filepath.Join(Dir(context.ProjectDir), context.Hook)
+	info, err := os.Stat(hookPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("The %s hook at %s is a directory", context.Hook, hookPath)
+	}
+	if info.Mode().Perm()&0o111 == 0 {
+		console.Warnf("Skipping the %s hook at %s because it isn't executable. Run 'chmod +x %s' to enable it.", context.Hook, hookPath, hookPath)
+		return nil
+	}
+
+	input, err := json.Marshal(context)
+	if err != nil {
+		return err
+	}
+
+	console.Infof("Running %s hook...", context.Hook)
+	cmd := exec.Command(hookPath)
+	cmd.Dir = context.ProjectDir
+	cmd.Env = append(os.Environ(), "COG_HOOK="+context.Hook, "COG_IMAGE="+context.Image)
+	cmd.Stdin = bytes.NewReader(input)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	console.Debug("$ " + hookPath)
+	err = cmd.Run()
+	if out := strings.TrimSpace(output.String()); out != "" {
+		console.Info(out)
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("The %s hook failed with exit status %d, so %s was aborted", context.Hook, exitErr.ExitCode(), strings.TrimPrefix(context.Hook, "pre-"))
+		}
+		return fmt.Errorf("Failed to run the %s hook: %w", context.Hook, err)
+	}
+	return nil
+}
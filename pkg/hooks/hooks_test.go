@@ -0,0 +1,82 @@
+package hooks
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/docker/command"
+)
+
+func writeHook(t *testing.T, projectDir string, name string, script string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(Dir(projectDir), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(Dir(projectDir), name), []byte(script), 0o755))
+}
+
+func TestRunWithoutHook(t *testing.T) {
+	require.NoError(t, RunPreBuild(&config.Config{}, t.TempDir(), "hotdog"))
+	require.NoError(t, RunPrePush(&config.Config{}, t.TempDir(), "hotdog"))
+}
+
+func TestRunPassesContext(t *testing.T) {
+	projectDir := t.TempDir()
+	writeHook(t, projectDir, PreBuild, "#!/bin/sh\ncat > context.json\necho $COG_HOOK $COG_IMAGE > env.txt\n")
+
+	cfg := &config.Config{Predict: "predict.py:Predictor"}
+	require.NoError(t, RunPreBuild(cfg, projectDir, "r8.im/alice/hotdog"))
+
+	data, err := os.ReadFile(filepath.Join(projectDir, "context.json"))
+	require.NoError(t, err)
+	var context map[string]any
+	require.NoError(t, json.Unmarshal(data, &context))
+	require.Equal(t, PreBuild, context["hook"])
+	require.Equal(t, "r8.im/alice/hotdog", context["image"])
+	require.Equal(t, "predict.py:Predictor", context["config"].(map[string]any)["predict"])
+
+	env, err := os.ReadFile(filepath.Join(projectDir, "env.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "pre-build r8.im/alice/hotdog\n", string(env))
+}
+
+func TestRunFailingHookAborts(t *testing.T) {
+	projectDir := t.TempDir()
+	writeHook(t, projectDir, PreBuild, "#!/bin/sh\necho 'image names must start with r8.im/acme'\nexit 3\n")
+
+	err := RunPreBuild(&config.Config{}, projectDir, "hotdog")
+	require.EqualError(t, err, "The pre-build hook failed with exit status 3, so build was aborted")
+}
+
+func TestRunSkipsHookThatIsNotExecutable(t *testing.T) {
+	projectDir := t.TempDir()
+	writeHook(t, projectDir, PreBuild, "#!/bin/sh\nexit 1\n")
+	require.NoError(t, os.Chmod(filepath.Join(Dir(projectDir), PreBuild), 0o644))
+
+	require.NoError(t, RunPreBuild(&config.Config{}, projectDir, "hotdog"))
+}
+
+func TestRunPrePromote(t *testing.T) {
+	projectDir := t.TempDir()
+	writeHook(t, projectDir, PrePromote, "#!/bin/sh\ncat > context.json\n")
+
+	labels := map[string]string{
+		command.CogConfigLabelKey:        `{"predict":"predict.py:Predictor"}`,
+		command.CogOpenAPISchemaLabelKey: `{"openapi":"3.0.2"}`,
+	}
+	require.NoError(t, RunPrePromote(projectDir, "staging.example.com/hotdog@sha256:abc", "prod.example.com/hotdog:v3", labels, 1024))
+
+	data, err := os.ReadFile(filepath.Join(projectDir, "context.json"))
+	require.NoError(t, err)
+	var context map[string]any
+	require.NoError(t, json.Unmarshal(data, &context))
+	require.Equal(t, PrePromote, context["hook"])
+	require.Equal(t, "staging.example.com/hotdog@sha256:abc", context["source"])
+	require.Equal(t, "prod.example.com/hotdog:v3", context["image"])
+	require.Equal(t, "predict.py:Predictor", context["config"].(map[string]any)["predict"])
+	require.Equal(t, "3.0.2", context["schema"].(map[string]any)["openapi"])
+	require.Equal(t, float64(1024), context["size"])
+}
@@ -0,0 +1,106 @@
+package image
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/dockercontext"
+	"github.com/replicate/cog/pkg/dockerfile"
+	"github.com/replicate/cog/pkg/global"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// adoptTini is the init that's added to images that don't have one, so signals are forwarded to Cog's HTTP server.
+// Cog builds linux/amd64 images, so it's the amd64 build.
+const adoptTini = "https://github.com/krallin/tini/releases/download/v0.19.0/tini-amd64"
+
+// Adopt makes a Cog model from baseImage, an image that wasn't built by Cog. Cog's HTTP server is installed in it,
+// with the predictor in dir, and it's built as imageName like `cog build --dockerfile`, so it gets a schema and
+// Cog's labels. baseImage needs Python and pip.
+func Adopt(cfg *config.Config, dir, baseImage, imageName, schemaFile, progressOutput string) error {
+	if _, err := docker.ImageInspect(baseImage); err != nil {
+		console.Infof("Pulling %s...", baseImage)
+		if err := docker.Pull(baseImage); err != nil {
+			return fmt.Errorf("Failed to pull %s: %w", baseImage, err)
+		}
+	}
+	inspect, err := docker.ImageInspect(baseImage)
+	if err != nil {
+		return fmt.Errorf("Failed to inspect %s: %w", baseImage, err)
+	}
+	hasInit := false
+	if inspect.Config != nil {
+		hasInit = inspect.Config.Labels[global.LabelNamespace+"has_init"] == "true"
+	}
+
+	tmpDir, err := dockercontext.BuildCogTempDir(dir, "adopt")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := dockercontext.RemoveAll(tmpDir); err != nil {
+			console.Warnf("Failed to remove %s: %s", tmpDir, err)
+		}
+	}()
+	wheel, err := writeCogWheel(tmpDir)
+	if err != nil {
+		return err
+	}
+	relativeWheel, err := filepath.Rel(dir, wheel)
+	if err != nil {
+		return err
+	}
+	dockerfilePath := filepath.Join(tmpDir, "Dockerfile")
+	if err := os.WriteFile(dockerfilePath, []byte(adoptDockerfile(baseImage, filepath.ToSlash(relativeWheel), hasInit)), 0o644); err != nil {
+		return err
+	}
+
+	return Build(cfg, dir, imageName, nil, nil, false, false, "", progressOutput, schemaFile, dockerfilePath, nil, false, false, false, nil, false, nil)
+}
+
+// writeCogWheel writes the Cog wheel that's embedded in the CLI to dir, and returns its path
+func writeCogWheel(dir string) (string, error) {
+	files, err := dockerfile.CogEmbed.ReadDir("embed")
+	if err != nil {
+		return "", err
+	}
+	if len(files) != 1 {
+		return "", fmt.Errorf("should only have one cog wheel embedded")
+	}
+	data, err := dockerfile.CogEmbed.ReadFile("embed/" + files[0].Name())
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, files[0].Name())
+	return path, os.WriteFile(path, data, 0o644)
+}
+
+// adoptDockerfile returns the Dockerfile that installs Cog's HTTP server in baseImage. tini is added as the
+// entrypoint unless the image has an init already.
+func adoptDockerfile(baseImage string, wheel string, hasInit bool) string {
+	lines := []string{
+		"#syntax=docker/dockerfile:1.4",
+		"FROM " + baseImage,
+	}
+	if !hasInit {
+		lines = append(lines,
+			"ADD --chmod=755 "+adoptTini+" /sbin/tini",
+			`ENTRYPOINT ["/sbin/tini", "--"]`,
+		)
+	}
+	wheelPath := "/tmp/" + filepath.Base(wheel)
+	lines = append(lines,
+		"COPY "+wheel+" "+wheelPath,
+		// Install pydantic<2 like the standard generator, because Cog's HTTP server needs it
+		"RUN python -m pip install --no-cache-dir "+wheelPath+" 'pydantic<2' && rm "+wheelPath,
+		"WORKDIR /src",
+		"EXPOSE 5000",
+		`CMD ["python", "-m", "cog.server.http"]`,
+		"COPY . /src",
+	)
+	return strings.Join(lines, "\n") + "\n"
+}
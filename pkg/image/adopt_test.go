@@ -0,0 +1,32 @@
+package image
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/lint"
+)
+
+func TestAdoptDockerfile(t *testing.T) {
+	dockerfile := adoptDockerfile("my-legacy-model:1.2", ".cog/tmp/adopt/cog-0.0.1-py3-none-any.whl", false)
+	require.Equal(t, `#syntax=docker/dockerfile:1.4
+FROM my-legacy-model:1.2
+ADD --chmod=755 https://github.com/krallin/tini/releases/download/v0.19.0/tini-amd64 /sbin/tini
+ENTRYPOINT ["/sbin/tini", "--"]
+COPY .cog/tmp/adopt/cog-0.0.1-py3-none-any.whl /tmp/cog-0.0.1-py3-none-any.whl
+RUN python -m pip install --no-cache-dir /tmp/cog-0.0.1-py3-none-any.whl 'pydantic<2' && rm /tmp/cog-0.0.1-py3-none-any.whl
+WORKDIR /src
+EXPOSE 5000
+CMD ["python", "-m", "cog.server.http"]
+COPY . /src
+`, dockerfile)
+
+	// It passes the checks on Dockerfiles passed to cog build --dockerfile
+	cfg := &config.Config{Build: &config.Build{}, Predict: "predict.py:Predictor"}
+	require.Empty(t, lint.LintDockerfile(cfg, dockerfile))
+
+	// Images built by Cog have an init already
+	require.NotContains(t, adoptDockerfile("r8.im/my-org/my-model", "cog.whl", true), "tini")
+}
@@ -0,0 +1,76 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/dockercontext"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// bake runs the model's setup in the built image and adds the files it writes to build.bake.paths to the
+// image, so things like compiled kernels and TensorRT engines don't have to be generated every time the model starts
+func bake(cfg *config.Config, imageName string) error {
+	paths := cfg.Build.BakePaths()
+	if len(paths) == 0 {
+		return nil
+	}
+	console.Info("Running setup to bake generated files into the image...")
+
+	gpus := ""
+	if cfg.Build.GPU {
+		gpus = "all"
+	}
+	containerName := "cog-bake-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	defer func() {
+		if err := docker.RemoveContainer(containerName); err != nil {
+			console.Warnf("Failed to remove container %s: %s", containerName, err)
+		}
+	}()
+	err := docker.RunAndKeepContainer(docker.RunOptions{
+		Image: imageName,
+		Args:  []string{"python", "-m", "cog.command.bake"},
+		GPUs:  gpus,
+	}, containerName, os.Stderr, os.Stderr)
+	if err == docker.ErrMissingDeviceDriver {
+		return fmt.Errorf("build.bake in cog.yaml runs setup on a GPU, but Docker can't access one on this machine")
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to run setup: %w", err)
+	}
+
+	contextDir, err := dockercontext.MkdirTemp("cog-bake-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(contextDir)
+
+	files := map[string]string{}
+	for i, path := range paths {
+		src := strconv.Itoa(i)
+		err := docker.CopyFromContainer(containerName, path, filepath.Join(contextDir, src))
+		if errors.Is(err, docker.ErrContainerPathNotFound) {
+			console.Warnf("Setup didn't create %s, so it won't be baked into the image", path)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to copy %s out of the container: %w", path, err)
+		}
+		files[src] = path
+	}
+	if len(files) == 0 {
+		console.Warn("None of the paths in build.bake were created by setup, so nothing was baked into the image")
+		return nil
+	}
+
+	if err := docker.BuildAddBakedFilesToImage(imageName, contextDir, files); err != nil {
+		return fmt.Errorf("Failed to add baked files to image: %w", err)
+	}
+	return nil
+}
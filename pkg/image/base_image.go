@@ -0,0 +1,51 @@
+package image
+
+import (
+	"fmt"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/docker/command"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// PushBaseImage pushes the cog base image a model was built on to the model's registry, and points the
+// model's base image label at it, so everything the model needs can be pulled from one registry
+func PushBaseImage(imageName string, dockerCommand command.Command) error {
+	manifest, err := dockerCommand.Inspect(imageName)
+	if err != nil {
+		return fmt.Errorf("Failed to inspect %s: %w", imageName, err)
+	}
+	baseImage := manifest.Config.Labels[command.CogBaseImageNameLabelKey]
+	if baseImage == "" {
+		return fmt.Errorf("%s wasn't built on a cog base image, so there's no base image to push. Run cog push without --include-base", imageName)
+	}
+	if source := manifest.Config.Labels[command.CogBaseImageSourceNameLabelKey]; source != "" {
+		// The base image was already pushed and the label rewritten, by an earlier push of this image
+		baseImage = source
+	}
+	mirroredBaseImage, err := config.MirroredBaseImageName(imageName, baseImage)
+	if err != nil {
+		return err
+	}
+
+	console.Infof("Pushing base image '%s' as '%s'...", baseImage, mirroredBaseImage)
+	if err := dockerCommand.Pull(baseImage); err != nil {
+		return fmt.Errorf("Failed to pull base image %s: %w", baseImage, err)
+	}
+	if err := docker.Tag(baseImage, mirroredBaseImage); err != nil {
+		return fmt.Errorf("Failed to tag base image as %s: %w", mirroredBaseImage, err)
+	}
+	if err := docker.StandardPush(mirroredBaseImage, dockerCommand); err != nil {
+		return fmt.Errorf("Failed to push base image to %s: %w", mirroredBaseImage, err)
+	}
+
+	labels := map[string]string{
+		command.CogBaseImageNameLabelKey:       mirroredBaseImage,
+		command.CogBaseImageSourceNameLabelKey: baseImage,
+	}
+	if err := docker.BuildAddLabelsToImage(imageName, labels); err != nil {
+		return fmt.Errorf("Failed to add base image labels to image: %w", err)
+	}
+	return nil
+}
@@ -2,41 +2,52 @@ package image
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"strings"
-	"time"
 
 	"github.com/getkin/kin-openapi/openapi3"
 	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 
+	"github.com/replicate/cog/pkg/buildcache"
 	"github.com/replicate/cog/pkg/config"
 	"github.com/replicate/cog/pkg/docker"
 	"github.com/replicate/cog/pkg/docker/command"
 	"github.com/replicate/cog/pkg/dockercontext"
 	"github.com/replicate/cog/pkg/dockerfile"
 	"github.com/replicate/cog/pkg/dockerignore"
+	cogerrors "github.com/replicate/cog/pkg/errors"
 	"github.com/replicate/cog/pkg/global"
+	"github.com/replicate/cog/pkg/lfs"
+	"github.com/replicate/cog/pkg/lint"
+	"github.com/replicate/cog/pkg/lockfile"
+	"github.com/replicate/cog/pkg/predict"
+	"github.com/replicate/cog/pkg/retry"
+	"github.com/replicate/cog/pkg/util"
 	"github.com/replicate/cog/pkg/util/console"
+	"github.com/replicate/cog/pkg/vcs"
 	"github.com/replicate/cog/pkg/weights"
 )
 
 const dockerignoreBackupPath = ".dockerignore.cog.bak"
-const weightsManifestPath = ".cog/cache/weights_manifest.json"
+const weightsManifestFile = "weights_manifest.json"
 const bundledSchemaFile = ".cog/openapi_schema.json"
 const bundledSchemaPy = ".cog/schema.py"
 
-var errGit = errors.New("git error")
-
 // Build a Cog model from a config
 //
 // This is separated out from docker.Build(), so that can be as close as possible to the behavior of 'docker build'.
-func Build(cfg *config.Config, dir, imageName string, secrets []string, noCache, separateWeights bool, useCudaBaseImage string, progressOutput string, schemaFile string, dockerfileFile string, useCogBaseImage *bool, strip bool, precompile bool, fastFlag bool, annotations map[string]string, localImage bool) error {
+func Build(cfg *config.Config, dir, imageName string, secrets []string, ssh []string, noCache, separateWeights bool, useCudaBaseImage string, progressOutput string, schemaFile string, dockerfileFile string, useCogBaseImage *bool, strip bool, precompile bool, fastFlag bool, annotations map[string]string, localImage bool, stages []buildcache.Stage) (err error) {
+	// Failures that don't have a more specific category, like an invalid schema, are build failures
+	defer func() {
+		err = cogerrors.WithCategory(cogerrors.CategoryBuild, err)
+	}()
+
 	console.Infof("Building Docker image from environment in cog.yaml as %s...", imageName)
 	if fastFlag {
 		console.Info("Fast build enabled.")
@@ -46,9 +57,72 @@ func Build(cfg *config.Config, dir, imageName string, secrets []string, noCache,
 	_ = os.Remove(bundledSchemaFile)
 	_ = os.Remove(bundledSchemaPy)
 
-	if err := checkCompatibleDockerIgnore(dir); err != nil {
+	// The build context is walked once for all the checks, and for the build's fingerprint
+	files, err := dockerignore.WalkContext(dir)
+	if err != nil {
+		return fmt.Errorf("Failed to read build context: %w", err)
+	}
+	// Problems with cog.yaml or the build context are config errors, rather than failed builds
+	if err := checkCompatibleDockerIgnore(cfg, dir, files); err != nil {
+		return cogerrors.WithCategory(cogerrors.CategoryConfig, err)
+	}
+	if files, err = checkLFSPointers(dir, files); err != nil {
+		return cogerrors.WithCategory(cogerrors.CategoryConfig, err)
+	}
+	contextSize, err := checkContextSize(cfg, files)
+	if err != nil {
+		return cogerrors.WithCategory(cogerrors.CategoryConfig, err)
+	}
+	if err := checkContextSecrets(cfg, dir, files); err != nil {
+		return cogerrors.WithCategory(cogerrors.CategoryConfig, err)
+	}
+	lintModel(cfg, dir)
+
+	// A schema that's given rather than generated from the image is checked before building, so a mistake in it
+	// doesn't waste a build
+	if schemaFile == "" && cfg.Build.OpenAPISchema != "" {
+		schemaFile = filepath.Join(dir, cfg.Build.OpenAPISchema)
+	}
+	if schemaFile == "" && cfg.RequiresSchemaFile() {
+		return cogerrors.WithCategory(cogerrors.CategorySchema, fmt.Errorf("An OpenAPI schema must be provided with build.openapi_schema in cog.yaml or --openapi-schema for this model"))
+	}
+	var schemaJSON []byte
+	if schemaFile != "" {
+		console.Infof("Validating model schema from %s...", schemaFile)
+		if schemaJSON, err = os.ReadFile(schemaFile); err != nil {
+			return cogerrors.WithCategory(cogerrors.CategorySchema, fmt.Errorf("Failed to read schema file: %w", err))
+		}
+		if _, err := loadSchema(schemaJSON); err != nil {
+			return err
+		}
+	}
+
+	if _, err := introspectWith(); err != nil {
 		return err
 	}
+	if dockerfileFile == "" {
+		if err := checkRequirementsResolve(cfg, dir, secrets); err != nil {
+			return cogerrors.WithCategory(cogerrors.CategoryConfig, err)
+		}
+	}
+	ssh, err = sshForwards(cfg, ssh)
+	if err != nil {
+		return cogerrors.WithCategory(cogerrors.CategoryConfig, err)
+	}
+
+	dockerfileHash := ""
+	if dockerfileFile != "" {
+		if dockerfileHash, err = util.SHA256HashFile(dockerfileFile); err != nil {
+			return fmt.Errorf("Failed to read Dockerfile at %s: %w", dockerfileFile, err)
+		}
+	}
+	state := newBuildState(cfg, dir, files, stages, config.BuildResume, imageName, dockerfileHash, schemaFile, useCudaBaseImage,
+		fmt.Sprint(separateWeights, noCache, strip, precompile, fastFlag, localImage, useCogBaseImage != nil && *useCogBaseImage))
+	defer func() {
+		if err != nil {
+			state.fail()
+		}
+	}()
 
 	var cogBaseImageName string
 
@@ -57,14 +131,27 @@ func Build(cfg *config.Config, dir, imageName string, secrets []string, noCache,
 		if err != nil {
 			return fmt.Errorf("Failed to read Dockerfile at %s: %w", dockerfileFile, err)
 		}
-		if err := docker.Build(dir, string(dockerfileContents), imageName, secrets, noCache, progressOutput, config.BuildSourceEpochTimestamp, dockercontext.StandardBuildDirectory, nil); err != nil {
-			return fmt.Errorf("Failed to build Docker image: %w", err)
+		if err := lintDockerfile(cfg, dockerfileFile, string(dockerfileContents)); err != nil {
+			return cogerrors.WithCategory(cogerrors.CategoryConfig, err)
+		}
+		buildContexts, err := dockerfile.ConfigBuildContexts(cfg, dir)
+		if err != nil {
+			return cogerrors.WithCategory(cogerrors.CategoryConfig, err)
+		}
+		if err := checkDiskSpace(cfg, dir, "", contextSize, false); err != nil {
+			return err
+		}
+		if skip, _ := state.skip(buildStageImage); !skip {
+			if err := docker.Build(dir, string(dockerfileContents), imageName, secrets, ssh, noCache, progressOutput, config.BuildSourceEpochTimestamp, dockercontext.StandardBuildDirectory, buildContexts); err != nil {
+				return fmt.Errorf("Failed to build Docker image: %w", err)
+			}
+			state.complete(buildStageImage, imageName, "")
 		}
 	} else {
 		command := docker.NewDockerCommand()
 		generator, err := dockerfile.NewGenerator(cfg, dir, fastFlag, command, localImage)
 		if err != nil {
-			return fmt.Errorf("Error creating Dockerfile generator: %w", err)
+			return cogerrors.WithCategory(cogerrors.CategoryConfig, fmt.Errorf("Error creating Dockerfile generator: %w", err))
 		}
 		contextDir, err := generator.BuildDir()
 		if err != nil {
@@ -89,9 +176,22 @@ func Build(cfg *config.Config, dir, imageName string, secrets []string, noCache,
 		if generator.IsUsingCogBaseImage() {
 			cogBaseImageName, err = generator.BaseImage()
 			if err != nil {
-				return fmt.Errorf("Failed to get cog base image name: %s", err)
+				return cogerrors.WithCategory(cogerrors.CategoryConfig, fmt.Errorf("Failed to get cog base image name: %s", err))
+			}
+			// The generator reads the digest from cog.lock once it's recorded, so the Dockerfile uses it too
+			if cogBaseImageName, err = pinCogBaseImage(cfg, dir, cogBaseImageName); err != nil {
+				console.Warnf("Failed to record cog base image in %s: %s", lockfile.Filename, err)
 			}
 		}
+		baseImage := cogBaseImageName
+		if baseImage == "" && !fastFlag {
+			if baseImage, err = generator.BaseImage(); err != nil {
+				return fmt.Errorf("Failed to get base image name: %w", err)
+			}
+		}
+		if err := checkDiskSpace(cfg, dir, baseImage, contextSize, separateWeights); err != nil {
+			return err
+		}
 
 		if separateWeights {
 			weightsDockerfile, runnerDockerfile, dockerignore, err := generator.GenerateModelBaseWithSeparateWeights(imageName)
@@ -99,7 +199,7 @@ func Build(cfg *config.Config, dir, imageName string, secrets []string, noCache,
 				return fmt.Errorf("Failed to generate Dockerfile: %w", err)
 			}
 
-			if err := backupDockerignore(); err != nil {
+			if err := backupDockerignore(dir); err != nil {
 				return fmt.Errorf("Failed to backup .dockerignore file: %w", err)
 			}
 
@@ -107,11 +207,17 @@ func Build(cfg *config.Config, dir, imageName string, secrets []string, noCache,
 			if err != nil {
 				return fmt.Errorf("Failed to generate weights manifest: %w", err)
 			}
+			weightsManifestPath := filepath.Join(dir, config.CacheDir(dir), "cache", weightsManifestFile)
 			cachedManifest, _ := weights.LoadManifest(weightsManifestPath)
 			changed := cachedManifest == nil || !weightsManifest.Equal(cachedManifest)
 			if changed {
-				if err := buildWeightsImage(dir, weightsDockerfile, imageName+"-weights", secrets, noCache, progressOutput, contextDir, buildContexts); err != nil {
-					return fmt.Errorf("Failed to build model weights Docker image: %w", err)
+				if skip, _ := state.skip(buildStageWeights); skip {
+					console.Info("Weights image was built before the build failed, skip rebuilding it...")
+				} else {
+					if err := buildWeightsImage(dir, weightsDockerfile, imageName+"-weights", secrets, noCache, progressOutput, contextDir, buildContexts); err != nil {
+						return fmt.Errorf("Failed to build model weights Docker image: %w", err)
+					}
+					state.complete(buildStageWeights, imageName+"-weights", "")
 				}
 				err := weightsManifest.Save(weightsManifestPath)
 				if err != nil {
@@ -121,58 +227,75 @@ func Build(cfg *config.Config, dir, imageName string, secrets []string, noCache,
 				console.Info("Weights unchanged, skip rebuilding and use cached image...")
 			}
 
-			if err := buildRunnerImage(dir, runnerDockerfile, dockerignore, imageName, secrets, noCache, progressOutput, contextDir, buildContexts); err != nil {
-				return fmt.Errorf("Failed to build runner Docker image: %w", err)
+			if skip, _ := state.skip(buildStageImage); skip {
+				// The runner image's build restores .dockerignore, so restore it here instead
+				if err := restoreDockerignore(dir); err != nil {
+					return fmt.Errorf("Failed to restore backup .dockerignore file: %w", err)
+				}
+			} else {
+				if err := buildRunnerImage(dir, runnerDockerfile, dockerignore, imageName, secrets, ssh, noCache, progressOutput, contextDir, buildContexts); err != nil {
+					return fmt.Errorf("Failed to build runner Docker image: %w", err)
+				}
+				state.complete(buildStageImage, imageName, "")
 			}
 		} else {
 			dockerfileContents, err := generator.GenerateDockerfileWithoutSeparateWeights()
 			if err != nil {
 				return fmt.Errorf("Failed to generate Dockerfile: %w", err)
 			}
-			if err := docker.Build(dir, dockerfileContents, imageName, secrets, noCache, progressOutput, config.BuildSourceEpochTimestamp, contextDir, buildContexts); err != nil {
-				return fmt.Errorf("Failed to build Docker image: %w", err)
+			if skip, _ := state.skip(buildStageImage); !skip {
+				if err := docker.Build(dir, dockerfileContents, imageName, secrets, ssh, noCache, progressOutput, config.BuildSourceEpochTimestamp, contextDir, buildContexts); err != nil {
+					return fmt.Errorf("Failed to build Docker image: %w", err)
+				}
+				state.complete(buildStageImage, imageName, "")
 			}
 		}
 	}
 
-	var schemaJSON []byte
-	if schemaFile != "" {
-		console.Infof("Validating model schema from %s...", schemaFile)
-		data, err := os.ReadFile(schemaFile)
-		if err != nil {
-			return fmt.Errorf("Failed to read schema file: %w", err)
+	if len(cfg.Build.BakePaths()) > 0 {
+		if skip, _ := state.skip(buildStageBake); !skip {
+			if err := bake(cfg, imageName); err != nil {
+				return err
+			}
+			state.complete(buildStageBake, imageName, "")
 		}
+	}
 
-		schemaJSON = data
-	} else {
-		console.Info("Validating model schema...")
-		schema, err := GenerateOpenAPISchema(imageName, cfg.Build.GPU)
-		if err != nil {
-			return fmt.Errorf("Failed to get type signature: %w", err)
-		}
+	cleanupIntrospection, err := prepareIntrospection(imageName)
+	if err != nil {
+		return err
+	}
+	defer cleanupIntrospection()
 
-		data, err := json.Marshal(schema)
-		if err != nil {
-			return fmt.Errorf("Failed to convert type signature to JSON: %w", err)
-		}
+	// Unless it was read from the schema file before building, it's generated from the image
+	if schemaJSON == nil {
+		if skip, schema := state.skip(buildStageSchema); skip {
+			console.Info("Validating model schema from the build that failed...")
+			schemaJSON = []byte(schema)
+		} else {
+			console.Info("Validating model schema...")
+			schema, err := GenerateOpenAPISchema(imageName, cfg.Build.GPU, cfg.PredictorLanguage())
+			if err != nil {
+				return cogerrors.WithCategory(cogerrors.CategorySchema, fmt.Errorf("Failed to get type signature: %w", err))
+			}
 
-		schemaJSON = data
+			data, err := json.Marshal(schema)
+			if err != nil {
+				return fmt.Errorf("Failed to convert type signature to JSON: %w", err)
+			}
+
+			schemaJSON = data
+			state.complete(buildStageSchema, "", string(schemaJSON))
+		}
 	}
 
 	// save open_api schema file
 	if err := os.WriteFile(bundledSchemaFile, schemaJSON, 0o644); err != nil {
 		return fmt.Errorf("failed to store bundled schema file %s: %w", bundledSchemaFile, err)
 	}
-
-	loader := openapi3.NewLoader()
-	loader.IsExternalRefsAllowed = true
-	doc, err := loader.LoadFromData(schemaJSON)
-	if err != nil {
-		return fmt.Errorf("Failed to load model schema JSON: %w", err)
-	}
-	err = doc.Validate(loader.Context)
+	doc, err := loadSchema(schemaJSON)
 	if err != nil {
-		return fmt.Errorf("Model schema is invalid: %w\n\n%s", err, string(schemaJSON))
+		return err
 	}
 
 	console.Info("Adding labels to image...")
@@ -185,30 +308,66 @@ func Build(cfg *config.Config, dir, imageName string, secrets []string, noCache,
 		return fmt.Errorf("Failed to convert config to JSON: %w", err)
 	}
 
-	pipFreeze, err := GeneratePipFreeze(imageName, fastFlag)
-	if err != nil {
-		return fmt.Errorf("Failed to generate pip freeze from image: %w", err)
+	pipFreeze := ""
+	if cfg.PredictorLanguage() == config.LanguagePython {
+		skip, output := state.skip(buildStagePipFreeze)
+		if skip {
+			pipFreeze = output
+		} else {
+			pipFreeze, err = GeneratePipFreeze(imageName, fastFlag)
+			if err != nil {
+				return fmt.Errorf("Failed to generate pip freeze from image: %w", err)
+			}
+			state.complete(buildStagePipFreeze, "", pipFreeze)
+		}
+		if err := recordTorchWheels(cfg, dir, pipFreeze); err != nil {
+			console.Warnf("Failed to record torch wheels in %s: %s", lockfile.Filename, err)
+		}
+		if err := checkLicenses(cfg, dir, imageName, fastFlag); err != nil {
+			return err
+		}
 	}
 
 	labels := map[string]string{
 		command.CogVersionLabelKey:               global.Version,
 		command.CogConfigLabelKey:                string(bytes.TrimSpace(configJSON)),
 		global.LabelNamespace + "openapi_schema": string(schemaJSON),
-		global.LabelNamespace + "pip_freeze":     pipFreeze,
+		command.CogPipFreezeLabelKey:             pipFreeze,
+	}
+	if cfg.PredictorLanguage() == config.LanguagePython {
 		// Mark the image as having an appropriate init entrypoint. We can use this
 		// to decide how/if to shim the image.
-		global.LabelNamespace + "has_init": "true",
+		labels[global.LabelNamespace+"has_init"] = "true"
+	}
+	if predict.IsStateful(doc) {
+		labels[command.CogStatefulLabelKey] = "true"
+	}
+	if aptSnapshot, _ := cfg.Build.AptSnapshotTimestamp(); aptSnapshot != "" {
+		// Record the snapshot that system packages were resolved against, so the image can be rebuilt with the same packages
+		labels[global.LabelNamespace+"apt_snapshot"] = aptSnapshot
+	}
+	security, err := securityLabelValue(cfg, dir)
+	if err != nil {
+		return err
+	}
+	if security != "" {
+		// Record how the container is hardened, so schedulers can run it the same way as `cog predict`
+		labels[command.CogSecurityLabelKey] = security
 	}
 
 	if cogBaseImageName != "" {
-		labels[global.LabelNamespace+"cog-base-image-name"] = cogBaseImageName
+		labels[command.CogBaseImageNameLabelKey] = cogBaseImageName
 
 		ref, err := name.ParseReference(cogBaseImageName)
 		if err != nil {
 			return fmt.Errorf("Failed to parse cog base image reference: %w", err)
 		}
 
-		img, err := remote.Image(ref)
+		var img v1.Image
+		err = retry.Do("Fetching cog base image "+cogBaseImageName, func() (err error) {
+			img, err = remote.Image(ref)
+			return err
+		})
 		if err != nil {
 			return fmt.Errorf("Failed to fetch cog base image: %w", err)
 		}
@@ -231,20 +390,19 @@ func Build(cfg *config.Config, dir, imageName string, secrets []string, noCache,
 		lastLayer := layerLayerDigest.String()
 		console.Debugf("Last layer of the cog base image: %s", lastLayer)
 
-		labels[global.LabelNamespace+"cog-base-image-last-layer-sha"] = lastLayer
-		labels[global.LabelNamespace+"cog-base-image-last-layer-idx"] = fmt.Sprintf("%d", lastLayerIndex)
+		labels[command.CogBaseImageLastLayerSHALabelKey] = lastLayer
+		labels[command.CogBaseImageLastLayerIndexLabelKey] = fmt.Sprintf("%d", lastLayerIndex)
 	}
 
-	if commit, err := gitHead(dir); commit != "" && err == nil {
-		labels["org.opencontainers.image.revision"] = commit
-	} else {
-		console.Info("Unable to determine Git commit")
+	vcsMetadata := vcs.Detect(dir)
+	if vcsMetadata.Revision == "" {
+		console.Info("Unable to determine source revision")
 	}
-
-	if tag, err := gitTag(dir); tag != "" && err == nil {
-		labels["org.opencontainers.image.version"] = tag
-	} else {
-		console.Info("Unable to determine Git tag")
+	if vcsMetadata.Version == "" {
+		console.Info("Unable to determine source version tag")
+	}
+	for key, val := range vcsMetadata.Labels() {
+		labels[key] = val
 	}
 
 	for key, val := range annotations {
@@ -254,6 +412,7 @@ func Build(cfg *config.Config, dir, imageName string, secrets []string, noCache,
 	if err := docker.BuildAddLabelsAndSchemaToImage(imageName, labels, bundledSchemaFile, bundledSchemaPy); err != nil {
 		return fmt.Errorf("Failed to add labels to image: %w", err)
 	}
+	state.finish()
 	return nil
 }
 
@@ -276,6 +435,10 @@ func BuildBase(cfg *config.Config, dir string, useCudaBaseImage string, useCogBa
 	if err != nil {
 		return "", err
 	}
+	ssh, err := sshForwards(cfg, nil)
+	if err != nil {
+		return "", err
+	}
 	defer func() {
 		if err := generator.Cleanup(); err != nil {
 			console.Warnf("Error cleaning up Dockerfile generator: %s", err)
@@ -291,113 +454,64 @@ func BuildBase(cfg *config.Config, dir string, useCudaBaseImage string, useCogBa
 	if err != nil {
 		return "", fmt.Errorf("Failed to generate Dockerfile: %w", err)
 	}
-	if err := docker.Build(dir, dockerfileContents, imageName, []string{}, false, progressOutput, config.BuildSourceEpochTimestamp, contextDir, buildContexts); err != nil {
+	if err := docker.Build(dir, dockerfileContents, imageName, []string{}, ssh, false, progressOutput, config.BuildSourceEpochTimestamp, contextDir, buildContexts); err != nil {
 		return "", fmt.Errorf("Failed to build Docker image: %w", err)
 	}
 	return imageName, nil
 }
 
-func isGitWorkTree(dir string) bool {
-	ctx, cancel := context.WithTimeout(context.TODO(), 3*time.Second)
-	defer cancel()
-
-	out, err := exec.CommandContext(ctx, "git", "-C", dir, "rev-parse", "--is-inside-work-tree").Output()
-	if err != nil {
-		return false
-	}
-
-	return strings.TrimSpace(string(out)) == "true"
-}
-
-func gitHead(dir string) (string, error) {
-	if v, ok := os.LookupEnv("GITHUB_SHA"); ok && v != "" {
-		return v, nil
-	}
-
-	if isGitWorkTree(dir) {
-		ctx, cancel := context.WithTimeout(context.TODO(), 3*time.Second)
-		defer cancel()
-
-		out, err := exec.CommandContext(ctx, "git", "-C", dir, "rev-parse", "HEAD").Output()
-		if err != nil {
-			return "", err
-		}
-
-		return string(bytes.TrimSpace(out)), nil
-	}
-
-	return "", fmt.Errorf("Failed to find HEAD commit: %w", errGit)
-}
-
-func gitTag(dir string) (string, error) {
-	if v, ok := os.LookupEnv("GITHUB_REF_NAME"); ok && v != "" {
-		return v, nil
-	}
-
-	if isGitWorkTree(dir) {
-		ctx, cancel := context.WithTimeout(context.TODO(), 3*time.Second)
-		defer cancel()
-
-		out, err := exec.CommandContext(ctx, "git", "-C", dir, "describe", "--tags", "--dirty").Output()
-		if err != nil {
-			return "", err
-		}
-
-		return string(bytes.TrimSpace(out)), nil
-	}
-
-	return "", fmt.Errorf("Failed to find ref name: %w", errGit)
-}
-
 func buildWeightsImage(dir, dockerfileContents, imageName string, secrets []string, noCache bool, progressOutput string, contextDir string, buildContexts map[string]string) error {
-	if err := makeDockerignoreForWeightsImage(); err != nil {
+	if err := makeDockerignoreForWeightsImage(dir); err != nil {
 		return fmt.Errorf("Failed to create .dockerignore file: %w", err)
 	}
-	if err := docker.Build(dir, dockerfileContents, imageName, secrets, noCache, progressOutput, config.BuildSourceEpochTimestamp, contextDir, buildContexts); err != nil {
+	if err := docker.Build(dir, dockerfileContents, imageName, secrets, nil, noCache, progressOutput, config.BuildSourceEpochTimestamp, contextDir, buildContexts); err != nil {
 		return fmt.Errorf("Failed to build Docker image for model weights: %w", err)
 	}
 	return nil
 }
 
-func buildRunnerImage(dir, dockerfileContents, dockerignoreContents, imageName string, secrets []string, noCache bool, progressOutput string, contextDir string, buildContexts map[string]string) error {
-	if err := writeDockerignore(dockerignoreContents); err != nil {
+func buildRunnerImage(dir, dockerfileContents, dockerignoreContents, imageName string, secrets []string, ssh []string, noCache bool, progressOutput string, contextDir string, buildContexts map[string]string) error {
+	if err := writeDockerignore(dir, dockerignoreContents); err != nil {
 		return fmt.Errorf("Failed to write .dockerignore file with weights included: %w", err)
 	}
-	if err := docker.Build(dir, dockerfileContents, imageName, secrets, noCache, progressOutput, config.BuildSourceEpochTimestamp, contextDir, buildContexts); err != nil {
+	if err := docker.Build(dir, dockerfileContents, imageName, secrets, ssh, noCache, progressOutput, config.BuildSourceEpochTimestamp, contextDir, buildContexts); err != nil {
 		return fmt.Errorf("Failed to build Docker image: %w", err)
 	}
-	if err := restoreDockerignore(); err != nil {
+	if err := restoreDockerignore(dir); err != nil {
 		return fmt.Errorf("Failed to restore backup .dockerignore file: %w", err)
 	}
 	return nil
 }
 
-func makeDockerignoreForWeightsImage() error {
-	if err := backupDockerignore(); err != nil {
+func makeDockerignoreForWeightsImage(dir string) error {
+	if err := backupDockerignore(dir); err != nil {
 		return fmt.Errorf("Failed to backup .dockerignore file: %w", err)
 	}
 
-	if err := writeDockerignore(dockerfile.DockerignoreHeader); err != nil {
+	if err := writeDockerignore(dir, dockerfile.DockerignoreHeader); err != nil {
 		return fmt.Errorf("Failed to write .dockerignore file: %w", err)
 	}
 	return nil
 }
 
-func writeDockerignore(contents string) error {
-	// read existing file contents from .dockerignore.cog.bak if it exists, and append to the new contents
-	if _, err := os.Stat(dockerignoreBackupPath); err == nil {
-		existingContents, err := os.ReadFile(dockerignoreBackupPath)
+// writeDockerignore writes the .dockerignore in dir that's used while building with separate weights.
+// The .dockerignore it replaces is kept in the backup file, and its patterns still apply.
+func writeDockerignore(dir string, contents string) error {
+	backupPath := filepath.Join(dir, dockerignoreBackupPath)
+	if _, err := os.Stat(backupPath); err == nil {
+		existingContents, err := os.ReadFile(backupPath)
 		if err != nil {
 			return err
 		}
 		contents = string(existingContents) + "\n" + contents
 	}
 
-	return os.WriteFile(".dockerignore", []byte(contents), 0o644)
+	return os.WriteFile(filepath.Join(dir, ".dockerignore"), []byte(contents), 0o644)
 }
 
-func backupDockerignore() error {
-	if _, err := os.Stat(".dockerignore"); err != nil {
+func backupDockerignore(dir string) error {
+	dockerignorePath := filepath.Join(dir, ".dockerignore")
+	if _, err := os.Stat(dockerignorePath); err != nil {
 		if os.IsNotExist(err) {
 			// .dockerignore file does not exist, nothing to backup
 			return nil
@@ -406,15 +520,17 @@ func backupDockerignore() error {
 	}
 
 	// rename the .dockerignore file to a new name
-	return os.Rename(".dockerignore", dockerignoreBackupPath)
+	return os.Rename(dockerignorePath, filepath.Join(dir, dockerignoreBackupPath))
 }
 
-func restoreDockerignore() error {
-	if err := os.Remove(".dockerignore"); err != nil {
+func restoreDockerignore(dir string) error {
+	dockerignorePath := filepath.Join(dir, ".dockerignore")
+	if err := os.Remove(dockerignorePath); err != nil {
 		return err
 	}
 
-	if _, err := os.Stat(dockerignoreBackupPath); err != nil {
+	backupPath := filepath.Join(dir, dockerignoreBackupPath)
+	if _, err := os.Stat(backupPath); err != nil {
 		if os.IsNotExist(err) {
 			// .dockerignore backup file does not exist, nothing to restore
 			return nil
@@ -422,10 +538,24 @@ func restoreDockerignore() error {
 		return err
 	}
 
-	return os.Rename(dockerignoreBackupPath, ".dockerignore")
+	return os.Rename(backupPath, dockerignorePath)
 }
 
-func checkCompatibleDockerIgnore(dir string) error {
+// loadSchema loads schemaJSON, checking that it's a valid OpenAPI schema
+func loadSchema(schemaJSON []byte) (*openapi3.T, error) {
+	loader := openapi3.NewLoader()
+	loader.IsExternalRefsAllowed = true
+	doc, err := loader.LoadFromData(schemaJSON)
+	if err != nil {
+		return nil, cogerrors.WithCategory(cogerrors.CategorySchema, fmt.Errorf("Failed to load model schema JSON: %w", err))
+	}
+	if err := doc.Validate(loader.Context); err != nil {
+		return nil, cogerrors.WithCategory(cogerrors.CategorySchema, fmt.Errorf("Model schema is invalid: %w\n\n%s", err, string(schemaJSON)))
+	}
+	return doc, nil
+}
+
+func checkCompatibleDockerIgnore(cfg *config.Config, dir string, files []dockerignore.ContextFile) error {
 	matcher, err := dockerignore.CreateMatcher(dir)
 	if err != nil {
 		return err
@@ -437,5 +567,93 @@ func checkCompatibleDockerIgnore(dir string) error {
 	if matcher.MatchesPath(".cog") {
 		return errors.New("The .cog tmp path cannot be ignored by docker in .dockerignore.")
 	}
+
+	required := []string{global.ConfigFilename}
+	for _, ref := range append(cfg.PredictorRefs(), cfg.Train) {
+		if file, _, _ := strings.Cut(ref, ":"); file != "" {
+			required = append(required, file)
+		}
+	}
+	modelDirs, modelFiles, err := weights.FindWeights(weights.RelativeFileWalker(dir))
+	if err != nil {
+		return err
+	}
+	problems, err := dockerignore.Lint(dir, dockerignore.LintOptions{
+		Required: required,
+		Weights:  append(modelDirs, modelFiles...),
+		Files:    files,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to check .dockerignore: %w", err)
+	}
+	var fatal error
+	for _, problem := range problems {
+		if problem.Fatal {
+			fatal = errors.Join(fatal, errors.New(problem.String()))
+			continue
+		}
+		console.Warn(problem.String())
+	}
+	return fatal
+}
+
+// lintModel warns about common mistakes in the model, which don't stop it building
+func lintModel(cfg *config.Config, dir string) {
+	suggestions, err := lint.Lint(cfg, dir)
+	if err != nil {
+		console.Debugf("Failed to lint the model: %s", err)
+		return
+	}
+	for _, suggestion := range suggestions {
+		console.Warn(suggestion.String())
+	}
+}
+
+// lintDockerfile warns about mistakes in a Dockerfile passed with --dockerfile, and fails if it would build an image
+// Cog can't run
+func lintDockerfile(cfg *config.Config, path string, contents string) error {
+	errs := 0
+	for _, suggestion := range lint.LintDockerfile(cfg, contents) {
+		console.Warnf("%s: %s [%s]", path, suggestion, suggestion.Rule)
+		if suggestion.Error {
+			errs++
+		}
+	}
+	if errs > 0 {
+		return fmt.Errorf("%s would build an image Cog can't run. Fix the problems above and build again.", path)
+	}
 	return nil
 }
+
+// checkLFSPointers makes sure Git LFS pointer files aren't built into the image in place of the files they point to.
+// If git-lfs is installed the files are pulled, otherwise the build fails.
+func checkLFSPointers(dir string, files []dockerignore.ContextFile) ([]dockerignore.ContextFile, error) {
+	pointers, err := lfs.FindPointersIn(dir, files)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to check for Git LFS pointer files: %w", err)
+	}
+	if len(pointers) == 0 {
+		return files, nil
+	}
+	if !lfs.Installed() {
+		return nil, fmt.Errorf("%d files in the build context are Git LFS pointers, not the files they point to (e.g. %s). Install git-lfs and run 'git lfs pull', or add them to .dockerignore.", len(pointers), pointers[0])
+	}
+
+	console.Infof("Pulling %d Git LFS files...", len(pointers))
+	if err := lfs.Pull(dir, pointers); err != nil {
+		return nil, err
+	}
+	// Pulling replaced the pointers with bigger files, so the context is listed again
+	files, err = dockerignore.WalkContext(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read build context: %w", err)
+	}
+	remaining, err := lfs.FindPointersIn(dir, files)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to check for Git LFS pointer files: %w", err)
+	}
+	if len(remaining) > 0 {
+		return nil, fmt.Errorf("%d files in the build context are still Git LFS pointers after running 'git lfs pull' (e.g. %s). Check that you have access to the LFS objects, or add them to .dockerignore.", len(remaining), remaining[0])
+	}
+	return files, nil
+}
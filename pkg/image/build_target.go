@@ -0,0 +1,80 @@
+package image
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/dockerfile"
+	"github.com/replicate/cog/pkg/util/console"
+	"github.com/replicate/cog/pkg/util/slices"
+)
+
+// BuildTarget builds one stage of the model's Dockerfile as imageName, like the environment without the model's
+// code, so it can be debugged or cached on its own. The image doesn't have the labels and schema that Build adds.
+func BuildTarget(cfg *config.Config, dir, imageName string, target string, secrets []string, ssh []string, noCache bool, useCudaBaseImage string, progressOutput string, useCogBaseImage *bool, strip bool, precompile bool) (err error) {
+	command := docker.NewDockerCommand()
+	generator, err := dockerfile.NewGenerator(cfg, dir, false, command, false)
+	if err != nil {
+		return fmt.Errorf("Error creating Dockerfile generator: %w", err)
+	}
+	defer func() {
+		if err := generator.Cleanup(); err != nil {
+			console.Warnf("Error cleaning up Dockerfile generator: %s", err)
+		}
+	}()
+	targets := dockerfile.BuildTargets(generator)
+	if len(targets) == 0 {
+		return fmt.Errorf("Models built with the %s generator don't have targets", generator.Name())
+	}
+	if !slices.ContainsString(targets, target) {
+		return fmt.Errorf("Unknown target %q. The targets are: %s", target, strings.Join(targets, ", "))
+	}
+
+	contextDir, err := generator.BuildDir()
+	if err != nil {
+		return err
+	}
+	buildContexts, err := generator.BuildContexts()
+	if err != nil {
+		return err
+	}
+	ssh, err = sshForwards(cfg, ssh)
+	if err != nil {
+		return err
+	}
+	generator.SetStrip(strip)
+	generator.SetPrecompile(precompile)
+	generator.SetUseCudaBaseImage(useCudaBaseImage)
+	if useCogBaseImage != nil {
+		generator.SetUseCogBaseImage(*useCogBaseImage)
+	}
+
+	console.Infof("Building target %s from cog.yaml as %s...", target, imageName)
+	if target == dockerfile.WeightsStageName {
+		// The weights stage is built from its own Dockerfile, like with --separate-weights
+		var weightsDockerfile string
+		weightsDockerfile, _, _, err = generator.GenerateModelBaseWithSeparateWeights(imageName)
+		if err != nil {
+			return fmt.Errorf("Failed to generate Dockerfile: %w", err)
+		}
+		// buildWeightsImage replaces .dockerignore, so it's put back even if the build fails, otherwise the next
+		// build would back up the replacement instead
+		defer func() {
+			if restoreErr := restoreDockerignore(dir); restoreErr != nil && err == nil {
+				err = fmt.Errorf("Failed to restore backup .dockerignore file: %w", restoreErr)
+			}
+		}()
+		return buildWeightsImage(dir, weightsDockerfile, imageName, secrets, noCache, progressOutput, contextDir, buildContexts)
+	}
+
+	dockerfileContents, err := generator.GenerateDockerfileWithoutSeparateWeights()
+	if err != nil {
+		return fmt.Errorf("Failed to generate Dockerfile: %w", err)
+	}
+	if err := docker.BuildTarget(dir, dockerfileContents, imageName, target, secrets, ssh, noCache, progressOutput, config.BuildSourceEpochTimestamp, contextDir, buildContexts); err != nil {
+		return fmt.Errorf("Failed to build target %s: %w", target, err)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package image
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/dockerfile"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+type CleanupSavings struct {
+	Rule  string
+	Bytes int64
+}
+
+// MeasureCleanup runs the cleanup rules against an image in dry-run mode and returns the bytes each rule would save.
+func MeasureCleanup(imageName string, rules []dockerfile.CleanupRule) ([]CleanupSavings, error) {
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+
+	err := docker.RunWithIO(docker.RunOptions{
+		Image: imageName,
+		Args:  []string{"sh", "-c", dockerfile.CleanupMeasureScript(rules)},
+	}, nil, &stdout, &stderr)
+	if err != nil {
+		console.Info(stdout.String())
+		console.Info(stderr.String())
+		return nil, err
+	}
+
+	return parseCleanupSavings(stdout.String())
+}
+
+func parseCleanupSavings(output string) ([]CleanupSavings, error) {
+	savings := []CleanupSavings{}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if line == "" {
+			continue
+		}
+		rule, value, ok := strings.Cut(line, "\t")
+		if !ok {
+			return nil, fmt.Errorf("Unexpected cleanup measurement output: %q", line)
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse bytes for cleanup rule %s: %w", rule, err)
+		}
+		savings = append(savings, CleanupSavings{Rule: rule, Bytes: n})
+	}
+	return savings, nil
+}
@@ -0,0 +1,56 @@
+package image
+
+import (
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/lockfile"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// pinCogBaseImage records the digest of the cog base image in cog.lock, so later builds use the same base image,
+// and returns the base image to build from. It's called before the model is built, so the cog.lock in the image is
+// the one it was built with. If the base image was pinned by cog.lock already, the registry isn't checked. If it was
+// pinned by build.cog_base_image, it warns if its tag now points at a different image.
+func pinCogBaseImage(cfg *config.Config, dir string, baseImage string) (string, error) {
+	tagName, pinnedDigest, pinned := strings.Cut(baseImage, "@")
+	if pinned && cfg.Build.CogBaseImage == "" {
+		return baseImage, nil
+	}
+	tag, err := name.NewTag(tagName, name.StrictValidation)
+	if err != nil {
+		// Pinned by digest without a tag, so there's nothing it can drift from
+		return baseImage, nil
+	}
+	desc, err := remote.Head(tag)
+	if err != nil {
+		console.Warnf("Failed to check the digest of cog base image %s: %s", tagName, err)
+		return baseImage, nil
+	}
+	digest := desc.Digest.String()
+
+	if pinned {
+		if digest != pinnedDigest {
+			console.Warnf("Cog base image %s has been updated since build.cog_base_image in cog.yaml was pinned to %s. To use the new version, change it to %s@%s", tagName, pinnedDigest, tagName, digest)
+		}
+		return baseImage, nil
+	}
+	if cfg.Build.CogBaseImage != "" {
+		// build.cog_base_image is a tag, so use whatever it points to, like cog.yaml says
+		return baseImage, nil
+	}
+
+	lock, err := lockfile.Load(dir)
+	if err != nil {
+		return baseImage, err
+	}
+	lock.BaseImage = &lockfile.Image{Name: tagName, Digest: digest}
+	console.Infof("Recording cog base image %s in %s", lock.BaseImage.Reference(), lockfile.Filename)
+	if err := lock.Save(dir); err != nil {
+		return baseImage, err
+	}
+	return lock.BaseImage.Reference(), nil
+}
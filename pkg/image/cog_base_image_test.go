@@ -0,0 +1,40 @@
+package image
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/lockfile"
+)
+
+func TestPinCogBaseImage(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	img, err := random.Image(1024, 1)
+	require.NoError(t, err)
+	digest, err := img.Digest()
+	require.NoError(t, err)
+	baseImage := hostOf(t, server.URL) + "/cog-base:python3.11"
+	require.NoError(t, remote.Write(mustTag(t, baseImage), img))
+
+	dir := t.TempDir()
+	cfg := &config.Config{Build: &config.Build{}}
+	pinned, err := pinCogBaseImage(cfg, dir, baseImage)
+	require.NoError(t, err)
+	require.Equal(t, baseImage+"@"+digest.String(), pinned)
+	lock, err := lockfile.Load(dir)
+	require.NoError(t, err)
+	require.Equal(t, pinned, lock.BaseImage.Reference())
+
+	// Once it's in cog.lock, the registry isn't checked
+	server.Close()
+	again, err := pinCogBaseImage(cfg, dir, pinned)
+	require.NoError(t, err)
+	require.Equal(t, pinned, again)
+}
@@ -0,0 +1,55 @@
+package image
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/dockerignore"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// contextSizeTopFiles is the number of largest files listed when reporting the build context size
+const contextSizeTopFiles = 5
+
+// ContextSize is the total size of the files sent to the Docker build context
+type ContextSize struct {
+	Total   int64
+	Files   int
+	Largest []dockerignore.ContextFile
+}
+
+// measureContext sums the sizes of the files from dockerignore.WalkContext that aren't excluded by .dockerignore, and
+// lists the top largest
+func measureContext(files []dockerignore.ContextFile, top int) *ContextSize {
+	included := dockerignore.Included(files)
+	size := &ContextSize{Files: len(included)}
+	for _, f := range included {
+		size.Total += f.Size
+	}
+	sort.SliceStable(included, func(i, j int) bool { return included[i].Size > included[j].Size })
+	if len(included) > top {
+		included = included[:top]
+	}
+	size.Largest = included
+	return size
+}
+
+// checkContextSize reports the size of the build context and fails if it is larger than build.max_context_size. It
+// returns the size, in bytes.
+func checkContextSize(cfg *config.Config, files []dockerignore.ContextFile) (int64, error) {
+	limit, err := cfg.Build.MaxContextSizeBytes()
+	if err != nil {
+		return 0, err
+	}
+	size := measureContext(files, contextSizeTopFiles)
+
+	console.Infof("Build context is %s (%d files). Largest files:", console.FormatBytes(size.Total), size.Files)
+	for _, f := range size.Largest {
+		console.Infof("  %10s  %s", console.FormatBytes(f.Size), f.Path)
+	}
+	if limit > 0 && size.Total > limit {
+		return 0, fmt.Errorf("The build context is %s, which is larger than build.max_context_size (%s). Add the files you don't need in the image to .dockerignore, or raise the limit in cog.yaml.", console.FormatBytes(size.Total), cfg.Build.MaxContextSize)
+	}
+	return size.Total, nil
+}
@@ -0,0 +1,149 @@
+#!/usr/bin/env python3
+"""
+Decrypts the weights that `cog build --encrypt-weights` encrypted, then runs the
+model's command. It's the image's entrypoint, so the key is only needed where the
+model runs, not wherever the image is stored.
+
+The weights are decrypted into a tmpfs, so they're never written to disk and the
+root filesystem can be read-only. The image has symlinks from the weights' paths
+to their decrypted copies.
+
+The key is read from COG_WEIGHTS_KEY (hex), the file in COG_WEIGHTS_KEY_FILE, or
+is unwrapped with the KMS the image was encrypted with. Files are encrypted with
+AES-256-CTR and authenticated with HMAC-SHA256, with keys derived from it.
+"""
+
+import base64
+import hashlib
+import hmac
+import json
+import os
+import subprocess
+import sys
+import tempfile
+
+MANIFEST_PATH = os.environ.get("COG_ENCRYPTED_WEIGHTS_MANIFEST", "/src/.cog/encrypted-weights.json")
+ENCRYPTED_SUFFIX = ".enc"
+
+
+def fail(message):
+    print(f"cog-decrypt-weights: {message}", file=sys.stderr)
+    sys.exit(1)
+
+
+def parse_key(data):
+    if len(data) == 32:
+        return data
+    try:
+        key = bytes.fromhex(data.decode().strip())
+    except ValueError:
+        key = b""
+    if len(key) != 32:
+        fail("The weights key must be 32 bytes, or 64 hex characters")
+    return key
+
+
+def unwrap_key(kms, wrapped):
+    with tempfile.NamedTemporaryFile() as f:
+        f.write(wrapped)
+        f.flush()
+        try:
+            if kms.startswith("awskms://"):
+                output = subprocess.run(
+                    ["aws", "kms", "decrypt", "--ciphertext-blob", f"fileb://{f.name}", "--output", "text", "--query", "Plaintext"],
+                    check=True,
+                    capture_output=True,
+                ).stdout
+                return base64.b64decode(output)
+            if kms.startswith("gcpkms://"):
+                return subprocess.run(
+                    ["gcloud", "kms", "decrypt", "--key", kms[len("gcpkms://") :], "--ciphertext-file", f.name, "--plaintext-file", "-"],
+                    check=True,
+                    capture_output=True,
+                ).stdout
+        except FileNotFoundError as e:
+            fail(f"{e.filename} isn't installed, so the weights key can't be unwrapped with {kms}. Set COG_WEIGHTS_KEY instead.")
+        except subprocess.CalledProcessError as e:
+            fail(f"Failed to unwrap the weights key with {kms}: {e.stderr.decode().strip()}")
+    fail(f"Unknown KMS {kms}")
+
+
+def load_key(manifest):
+    if os.environ.get("COG_WEIGHTS_KEY"):
+        return parse_key(os.environ["COG_WEIGHTS_KEY"].encode())
+    if os.environ.get("COG_WEIGHTS_KEY_FILE"):
+        with open(os.environ["COG_WEIGHTS_KEY_FILE"], "rb") as f:
+            return parse_key(f.read())
+    if manifest.get("kms"):
+        return parse_key(unwrap_key(manifest["kms"], base64.b64decode(manifest["wrapped_key"])))
+    fail("The model's weights are encrypted. Set COG_WEIGHTS_KEY or COG_WEIGHTS_KEY_FILE to the key.")
+
+
+def decrypt(path, entry, decrypted_dir, encryption_key, mac_key, decrypted_ivs):
+    encrypted = path + ENCRYPTED_SUFFIX
+    decrypted = decrypted_dir + path
+    if os.path.exists(decrypted) or not os.path.exists(encrypted):
+        # Already decrypted, if the container was restarted, or deleted in a later layer
+        return
+    os.makedirs(os.path.dirname(decrypted), exist_ok=True)
+    if entry["iv"] in decrypted_ivs:
+        # Hard links to the same weights are decrypted once
+        os.link(decrypted_ivs[entry["iv"]], decrypted)
+        return
+    mac = hmac.new(mac_key, bytes.fromhex(entry["iv"]), hashlib.sha256)
+    with open(encrypted, "rb") as f:
+        for chunk in iter(lambda: f.read(1024 * 1024), b""):
+            mac.update(chunk)
+    if not hmac.compare_digest(mac.hexdigest(), entry["mac"]):
+        fail(f"{path} doesn't match its MAC. The key is wrong, or the file was changed.")
+    # Python's standard library doesn't have AES, and openssl is in every image with ca-certificates
+    partial = decrypted + ".partial"
+    result = subprocess.run(
+        ["openssl", "enc", "-d", "-aes-256-ctr", "-K", encryption_key.hex(), "-iv", entry["iv"], "-in", encrypted, "-out", partial],
+        capture_output=True,
+    )
+    if result.returncode != 0:
+        fail(f"Failed to decrypt {path}: {result.stderr.decode().strip()}")
+    os.chmod(partial, entry.get("mode", 0o644))
+    os.rename(partial, decrypted)
+    decrypted_ivs[entry["iv"]] = decrypted
+
+
+def check_space(decrypted_dir, files):
+    needed = 0
+    ivs = set()
+    for entry in files:
+        encrypted = entry["path"] + ENCRYPTED_SUFFIX
+        if entry["iv"] in ivs or os.path.exists(decrypted_dir + entry["path"]) or not os.path.exists(encrypted):
+            continue
+        ivs.add(entry["iv"])
+        needed += os.path.getsize(encrypted)
+    stat = os.statvfs(decrypted_dir)
+    free = stat.f_bavail * stat.f_frsize
+    if needed > free:
+        fail(
+            f"The weights need {needed} bytes in {decrypted_dir}, but it only has {free} free. "
+            "Give the container more shared memory, like with docker run --shm-size."
+        )
+
+
+def main():
+    if os.path.exists(MANIFEST_PATH):
+        with open(MANIFEST_PATH) as f:
+            manifest = json.load(f)
+        key = load_key(manifest)
+        encryption_key = hmac.new(key, b"cog weights encryption", hashlib.sha256).digest()
+        mac_key = hmac.new(key, b"cog weights authentication", hashlib.sha256).digest()
+        decrypted_dir = manifest["decrypted_dir"]
+        os.makedirs(decrypted_dir, exist_ok=True)
+        check_space(decrypted_dir, manifest["files"])
+        decrypted_ivs = {}
+        for entry in manifest["files"]:
+            decrypt(entry["path"], entry, decrypted_dir, encryption_key, mac_key, decrypted_ivs)
+    if len(sys.argv) < 2:
+        fail("No command to run")
+    os.execvp(sys.argv[1], sys.argv[1:])
+
+
+if __name__ == "__main__":
+    main()
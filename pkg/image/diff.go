@@ -0,0 +1,514 @@
+package image
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/docker/command"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// Statuses of a change between two images
+const (
+	DiffAdded    = "added"
+	DiffRemoved  = "removed"
+	DiffModified = "modified"
+)
+
+// Change is a value that's different in two images, like a package's version. A is empty if it was added, and B is
+// empty if it was removed.
+type Change struct {
+	Key    string `json:"key"`
+	Status string `json:"status"`
+	A      string `json:"a,omitempty"`
+	B      string `json:"b,omitempty"`
+}
+
+// LayerInfo describes a layer that's only in one of the images
+type LayerInfo struct {
+	DiffID    string `json:"diff_id"`
+	Size      int64  `json:"size"`
+	CreatedBy string `json:"created_by,omitempty"`
+}
+
+// LayerDiff is how the layers of two images differ. Layers are compared by their uncompressed digests, so an image
+// in the local Docker daemon can be compared with one in a registry.
+type LayerDiff struct {
+	Shared  int         `json:"shared"`
+	OnlyInA []LayerInfo `json:"only_in_a"`
+	OnlyInB []LayerInfo `json:"only_in_b"`
+}
+
+// FileChange is a file in /src that's different in two images
+type FileChange struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+	SizeA  int64  `json:"size_a,omitempty"`
+	SizeB  int64  `json:"size_b,omitempty"`
+}
+
+// ImageDiff is what's different between two model images
+type ImageDiff struct {
+	A        string       `json:"a"`
+	B        string       `json:"b"`
+	Packages []Change     `json:"packages"`
+	Config   []Change     `json:"config"`
+	Schema   []Change     `json:"schema"`
+	Labels   []Change     `json:"labels"`
+	Layers   LayerDiff    `json:"layers"`
+	Files    []FileChange `json:"files"`
+	// Warnings are parts of the images that couldn't be compared, like the source of an image that wasn't built by
+	// Cog
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Empty returns whether the images are the same
+func (d *ImageDiff) Empty() bool {
+	return len(d.Packages) == 0 && len(d.Config) == 0 && len(d.Schema) == 0 && len(d.Labels) == 0 &&
+		len(d.Layers.OnlyInA) == 0 && len(d.Layers.OnlyInB) == 0 && len(d.Files) == 0
+}
+
+// Diff compares two model images, from the local Docker daemon if they're there, or their registry if they aren't
+func Diff(imageA string, imageB string) (*ImageDiff, error) {
+	a, err := loadImage(imageA)
+	if err != nil {
+		return nil, err
+	}
+	b, err := loadImage(imageB)
+	if err != nil {
+		return nil, err
+	}
+	diff, err := diffImages(a, b)
+	if err != nil {
+		return nil, err
+	}
+	diff.A = imageA
+	diff.B = imageB
+	return diff, nil
+}
+
+// loadImage returns an image from the local Docker daemon, or from its registry if it isn't in the daemon. Images in
+// the daemon are streamed from it as they're read, rather than exported to a file first.
+func loadImage(imageName string) (v1.Image, error) {
+	exists, err := docker.ImageExists(imageName)
+	if err != nil {
+		console.Debugf("Failed to check whether %s exists locally, so fetching it from its registry: %s", imageName, err)
+	}
+	if exists {
+		img, err := tarball.Image(docker.ImageArchiveOpener(imageName), nil)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read %s: %w", imageName, err)
+		}
+		return img, nil
+	}
+	ref, err := name.ParseReference(imageName)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse image name %s: %w", imageName, err)
+	}
+	console.Infof("Fetching %s...", imageName)
+	img, err := remote.Image(ref, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	if err != nil {
+		return nil, fmt.Errorf("%s isn't in the local Docker daemon, and failed to fetch it from its registry: %w", imageName, err)
+	}
+	return img, nil
+}
+
+func diffImages(a v1.Image, b v1.Image) (*ImageDiff, error) {
+	configA, err := a.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	configB, err := b.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	labelsA, labelsB := configA.Config.Labels, configB.Config.Labels
+	diff := &ImageDiff{}
+
+	diff.Packages = diffMaps(parsePipFreeze(labelsA[command.CogPipFreezeLabelKey]), parsePipFreeze(labelsB[command.CogPipFreezeLabelKey]))
+	if diff.Config, err = diffJSONLabels(labelsA, labelsB, command.CogConfigLabelKey); err != nil {
+		return nil, err
+	}
+	if diff.Schema, err = diffJSONLabels(labelsA, labelsB, command.CogOpenAPISchemaLabelKey); err != nil {
+		return nil, err
+	}
+	otherLabels := func(labels map[string]string) map[string]string {
+		other := map[string]string{}
+		for key, value := range labels {
+			if key != command.CogPipFreezeLabelKey && key != command.CogConfigLabelKey && key != command.CogOpenAPISchemaLabelKey {
+				other[key] = value
+			}
+		}
+		return other
+	}
+	diff.Labels = diffMaps(otherLabels(labelsA), otherLabels(labelsB))
+	if labelsA[command.CogPipFreezeLabelKey] == "" || labelsB[command.CogPipFreezeLabelKey] == "" {
+		diff.Warnings = append(diff.Warnings, "The Python packages of images without a pip freeze label can't be compared")
+	}
+
+	layersA, err := imageLayers(a, configA)
+	if err != nil {
+		return nil, err
+	}
+	layersB, err := imageLayers(b, configB)
+	if err != nil {
+		return nil, err
+	}
+	diff.Layers = diffLayers(layersA, layersB)
+
+	sourceA, sourceB := sourceLayer(layersA), sourceLayer(layersB)
+	switch {
+	case sourceA == nil || sourceB == nil:
+		diff.Warnings = append(diff.Warnings, "The files in /src can't be compared, because the layer that copies them in isn't in both images")
+	case sourceA.info.DiffID == sourceB.info.DiffID:
+		diff.Files = []FileChange{}
+	default:
+		filesA, err := sourceFiles(sourceA.layer)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read the files in /src: %w", err)
+		}
+		filesB, err := sourceFiles(sourceB.layer)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read the files in /src: %w", err)
+		}
+		diff.Files = diffFiles(filesA, filesB)
+	}
+	return diff, nil
+}
+
+// parsePipFreeze returns the packages in the output of pip freeze, by name, with the line each one is on, like
+// "torch==2.3.1"
+func parsePipFreeze(freeze string) map[string]string {
+	packages := map[string]string{}
+	for _, line := range strings.Split(freeze, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name := config.PipPackageNameRegex.FindString(line)
+		if name == "" || strings.HasPrefix(line, "-") {
+			name = line
+		}
+		packages[strings.ReplaceAll(strings.ToLower(name), "_", "-")] = line
+	}
+	return packages
+}
+
+// diffJSONLabels compares a label that's a JSON document, like the OpenAPI schema, by the path to each value in it
+func diffJSONLabels(labelsA map[string]string, labelsB map[string]string, key string) ([]Change, error) {
+	flatA, err := flattenJSONLabel(labelsA[key], key)
+	if err != nil {
+		return nil, err
+	}
+	flatB, err := flattenJSONLabel(labelsB[key], key)
+	if err != nil {
+		return nil, err
+	}
+	return diffMaps(flatA, flatB), nil
+}
+
+func flattenJSONLabel(value string, key string) (map[string]string, error) {
+	flat := map[string]string{}
+	if value == "" {
+		return flat, nil
+	}
+	var v any
+	if err := json.Unmarshal([]byte(value), &v); err != nil {
+		return nil, fmt.Errorf("Failed to parse the %s label: %w", key, err)
+	}
+	flattenJSON("", v, flat)
+	return flat, nil
+}
+
+// flattenJSON adds the values in v to flat by their path, like "build.python_version"
+func flattenJSON(prefix string, v any, flat map[string]string) {
+	join := func(key string) string {
+		if prefix == "" {
+			return key
+		}
+		return prefix + "." + key
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			flattenJSON(join(key), value, flat)
+		}
+	case []any:
+		for i, value := range v {
+			flattenJSON(fmt.Sprintf("%s[%d]", prefix, i), value, flat)
+		}
+	default:
+		data, _ := json.Marshal(v)
+		flat[prefix] = string(data)
+	}
+}
+
+// diffMaps returns the keys whose values are different in a and b, sorted by key
+func diffMaps(a map[string]string, b map[string]string) []Change {
+	changes := []Change{}
+	for key, valueA := range a {
+		valueB, ok := b[key]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Key: key, Status: DiffRemoved, A: valueA})
+		case valueA != valueB:
+			changes = append(changes, Change{Key: key, Status: DiffModified, A: valueA, B: valueB})
+		}
+	}
+	for key, valueB := range b {
+		if _, ok := a[key]; !ok {
+			changes = append(changes, Change{Key: key, Status: DiffAdded, B: valueB})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+// imageLayer is a layer of an image, with the history entry that created it
+type imageLayer struct {
+	layer v1.Layer
+	info  LayerInfo
+}
+
+func imageLayers(img v1.Image, configFile *v1.ConfigFile) ([]imageLayer, error) {
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+	// History has an entry for every step, but only the ones that aren't empty layers have a layer
+	history := []v1.History{}
+	for _, h := range configFile.History {
+		if !h.EmptyLayer {
+			history = append(history, h)
+		}
+	}
+	result := []imageLayer{}
+	for i, layer := range layers {
+		diffID, err := layer.DiffID()
+		if err != nil {
+			return nil, err
+		}
+		size, err := layer.Size()
+		if err != nil {
+			return nil, err
+		}
+		info := LayerInfo{DiffID: diffID.String(), Size: size}
+		if len(history) == len(layers) {
+			info.CreatedBy = history[i].CreatedBy
+		}
+		result = append(result, imageLayer{layer: layer, info: info})
+	}
+	return result, nil
+}
+
+func diffLayers(a []imageLayer, b []imageLayer) LayerDiff {
+	diff := LayerDiff{OnlyInA: []LayerInfo{}, OnlyInB: []LayerInfo{}}
+	inA, inB := map[string]bool{}, map[string]bool{}
+	for _, layer := range a {
+		inA[layer.info.DiffID] = true
+	}
+	for _, layer := range b {
+		inB[layer.info.DiffID] = true
+	}
+	for _, layer := range a {
+		if inB[layer.info.DiffID] {
+			diff.Shared++
+		} else {
+			diff.OnlyInA = append(diff.OnlyInA, layer.info)
+		}
+	}
+	for _, layer := range b {
+		if !inA[layer.info.DiffID] {
+			diff.OnlyInB = append(diff.OnlyInB, layer.info)
+		}
+	}
+	return diff
+}
+
+// sourceLayer returns the layer that copies the model's code into /src, or the code layer of an image with an
+// optimized layout, or nil if there isn't one
+func sourceLayer(layers []imageLayer) *imageLayer {
+	for i := len(layers) - 1; i >= 0; i-- {
+		createdBy := layers[i].info.CreatedBy
+		if strings.Contains(createdBy, "COPY . /src") || createdBy == "cog: "+partitionNames[partitionCode] {
+			return &layers[i]
+		}
+	}
+	return nil
+}
+
+// sourceFile is a file in /src, with a digest of its contents
+type sourceFile struct {
+	size   int64
+	digest string
+}
+
+// sourceFiles returns the files under /src in layer, by their path relative to it
+func sourceFiles(layer v1.Layer) (map[string]sourceFile, error) {
+	reader, err := layer.Uncompressed()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	files := map[string]sourceFile{}
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		rel, ok := strings.CutPrefix(path.Clean("/"+header.Name), "/src/")
+		if !ok || strings.HasPrefix(path.Base(rel), whiteoutPrefix) {
+			continue
+		}
+		switch header.Typeflag {
+		case tar.TypeReg:
+			hash := sha256.New()
+			if _, err := io.Copy(hash, tr); err != nil {
+				return nil, err
+			}
+			files[rel] = sourceFile{size: header.Size, digest: hex.EncodeToString(hash.Sum(nil))}
+		case tar.TypeSymlink, tar.TypeLink:
+			files[rel] = sourceFile{digest: "-> " + header.Linkname}
+		}
+	}
+}
+
+func diffFiles(a map[string]sourceFile, b map[string]sourceFile) []FileChange {
+	changes := []FileChange{}
+	for p, fileA := range a {
+		fileB, ok := b[p]
+		switch {
+		case !ok:
+			changes = append(changes, FileChange{Path: p, Status: DiffRemoved, SizeA: fileA.size})
+		case fileA != fileB:
+			changes = append(changes, FileChange{Path: p, Status: DiffModified, SizeA: fileA.size, SizeB: fileB.size})
+		}
+	}
+	for p, fileB := range b {
+		if _, ok := a[p]; !ok {
+			changes = append(changes, FileChange{Path: p, Status: DiffAdded, SizeB: fileB.size})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// maxDiffValueLength is how much of a value WriteDiff prints, so long labels don't flood the terminal
+const maxDiffValueLength = 80
+
+// WriteDiff writes diff for people to read. Added values are marked with +, removed ones with -, and modified ones
+// with ~.
+func WriteDiff(w io.Writer, diff *ImageDiff) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", diff.A, diff.B)
+	if diff.Empty() {
+		b.WriteString("\nThe images are the same\n")
+	}
+	writeChanges(&b, "Python packages", diff.Packages, func(c Change) string {
+		if c.Status == DiffModified {
+			return fmt.Sprintf("~ %s → %s", c.A, c.B)
+		}
+		return changeMarker(c.Status) + " " + c.A + c.B
+	})
+	keyValue := func(c Change) string {
+		switch c.Status {
+		case DiffAdded:
+			return fmt.Sprintf("+ %s: %s", c.Key, truncateDiffValue(c.B))
+		case DiffRemoved:
+			return fmt.Sprintf("- %s: %s", c.Key, truncateDiffValue(c.A))
+		default:
+			return fmt.Sprintf("~ %s: %s → %s", c.Key, truncateDiffValue(c.A), truncateDiffValue(c.B))
+		}
+	}
+	writeChanges(&b, "Config", diff.Config, keyValue)
+	writeChanges(&b, "Schema", diff.Schema, keyValue)
+	writeChanges(&b, "Labels", diff.Labels, keyValue)
+
+	if len(diff.Layers.OnlyInA) > 0 || len(diff.Layers.OnlyInB) > 0 {
+		fmt.Fprintf(&b, "\nLayers (%d the same):\n", diff.Layers.Shared)
+		for _, layer := range diff.Layers.OnlyInA {
+			fmt.Fprintf(&b, "  - %s\n", formatLayer(layer))
+		}
+		for _, layer := range diff.Layers.OnlyInB {
+			fmt.Fprintf(&b, "  + %s\n", formatLayer(layer))
+		}
+	}
+
+	if len(diff.Files) > 0 {
+		b.WriteString("\nFiles in /src:\n")
+		for _, file := range diff.Files {
+			switch file.Status {
+			case DiffAdded:
+				fmt.Fprintf(&b, "  + %s (%s)\n", file.Path, console.FormatBytes(file.SizeB))
+			case DiffRemoved:
+				fmt.Fprintf(&b, "  - %s (%s)\n", file.Path, console.FormatBytes(file.SizeA))
+			default:
+				fmt.Fprintf(&b, "  ~ %s (%s → %s)\n", file.Path, console.FormatBytes(file.SizeA), console.FormatBytes(file.SizeB))
+			}
+		}
+	}
+
+	for _, warning := range diff.Warnings {
+		fmt.Fprintf(&b, "\n%s\n", warning)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeChanges(b *strings.Builder, title string, changes []Change, format func(Change) string) {
+	if len(changes) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "\n%s:\n", title)
+	for _, change := range changes {
+		fmt.Fprintf(b, "  %s\n", format(change))
+	}
+}
+
+func changeMarker(status string) string {
+	switch status {
+	case DiffAdded:
+		return "+"
+	case DiffRemoved:
+		return "-"
+	default:
+		return "~"
+	}
+}
+
+func formatLayer(layer LayerInfo) string {
+	s := fmt.Sprintf("%s %s", strings.TrimPrefix(layer.DiffID, "sha256:")[:12], console.FormatBytes(layer.Size))
+	if layer.CreatedBy != "" {
+		s += " " + truncateDiffValue(layer.CreatedBy)
+	}
+	return s
+}
+
+func truncateDiffValue(value string) string {
+	value = strings.ReplaceAll(value, "\n", " ")
+	if len(value) > maxDiffValueLength {
+		return value[:maxDiffValueLength-3] + "..."
+	}
+	return value
+}
@@ -0,0 +1,93 @@
+package image
+
+import (
+	"bytes"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/docker/command"
+)
+
+func modelImage(t *testing.T, base v1.Layer, src map[string]string, labels map[string]string) v1.Image {
+	t.Helper()
+	img, err := mutate.Append(empty.Image,
+		mutate.Addendum{Layer: base, History: v1.History{CreatedBy: "RUN pip install -r requirements.txt"}},
+		mutate.Addendum{Layer: tarLayer(t, src), History: v1.History{CreatedBy: "COPY . /src # buildkit"}},
+	)
+	require.NoError(t, err)
+	return withLabels(t, img, labels)
+}
+
+func TestDiffImages(t *testing.T) {
+	base := tarLayer(t, map[string]string{"usr/lib/python3/site-packages/torch/__init__.py": "torch"})
+	a := modelImage(t, base, map[string]string{
+		"src/predict.py":    "v1",
+		"src/old.py":        "old",
+		"src/cog.yaml":      "predict: predict.py:Predictor",
+		"etc/not-source.sh": "ignored",
+	}, map[string]string{
+		command.CogPipFreezeLabelKey:     "torch==2.3.0\nnumpy==1.26.4\nPillow==10.0.0\n",
+		command.CogConfigLabelKey:        `{"build":{"gpu":true,"python_version":"3.11"},"predict":"predict.py:Predictor"}`,
+		command.CogOpenAPISchemaLabelKey: `{"components":{"schemas":{"Input":{"required":["image"]}}}}`,
+		command.CogVersionLabelKey:       "0.13.0",
+	})
+	b := modelImage(t, base, map[string]string{
+		"src/predict.py": "v2 is longer",
+		"src/new.py":     "new",
+		"src/cog.yaml":   "predict: predict.py:Predictor",
+	}, map[string]string{
+		command.CogPipFreezeLabelKey:     "torch==2.3.1\nnumpy==1.26.4\neinops==0.8.0\n",
+		command.CogConfigLabelKey:        `{"build":{"gpu":true,"python_version":"3.12"},"predict":"predict.py:Predictor"}`,
+		command.CogOpenAPISchemaLabelKey: `{"components":{"schemas":{"Input":{"required":["image","prompt"]}}}}`,
+		command.CogVersionLabelKey:       "0.14.0",
+	})
+
+	diff, err := diffImages(a, b)
+	require.NoError(t, err)
+	require.Equal(t, []Change{
+		{Key: "einops", Status: DiffAdded, B: "einops==0.8.0"},
+		{Key: "pillow", Status: DiffRemoved, A: "Pillow==10.0.0"},
+		{Key: "torch", Status: DiffModified, A: "torch==2.3.0", B: "torch==2.3.1"},
+	}, diff.Packages)
+	require.Equal(t, []Change{
+		{Key: "build.python_version", Status: DiffModified, A: `"3.11"`, B: `"3.12"`},
+	}, diff.Config)
+	require.Equal(t, []Change{
+		{Key: "components.schemas.Input.required[1]", Status: DiffAdded, B: `"prompt"`},
+	}, diff.Schema)
+	require.Equal(t, []Change{
+		{Key: command.CogVersionLabelKey, Status: DiffModified, A: "0.13.0", B: "0.14.0"},
+	}, diff.Labels)
+	require.Equal(t, 1, diff.Layers.Shared)
+	require.Len(t, diff.Layers.OnlyInA, 1)
+	require.Equal(t, "COPY . /src # buildkit", diff.Layers.OnlyInB[0].CreatedBy)
+	require.Equal(t, []FileChange{
+		{Path: "new.py", Status: DiffAdded, SizeB: 3},
+		{Path: "old.py", Status: DiffRemoved, SizeA: 3},
+		{Path: "predict.py", Status: DiffModified, SizeA: 2, SizeB: 12},
+	}, diff.Files)
+	require.Empty(t, diff.Warnings)
+
+	diff.A, diff.B = "my-model:v41", "my-model:v42"
+	var out bytes.Buffer
+	require.NoError(t, WriteDiff(&out, diff))
+	require.Contains(t, out.String(), "~ torch==2.3.0 → torch==2.3.1")
+	require.Contains(t, out.String(), `~ build.python_version: "3.11" → "3.12"`)
+	require.Contains(t, out.String(), "+ new.py (3 B)")
+}
+
+func TestDiffImagesSame(t *testing.T) {
+	base := tarLayer(t, map[string]string{"usr/bin/python": "python"})
+	labels := map[string]string{command.CogPipFreezeLabelKey: "torch==2.3.1\n"}
+	a := modelImage(t, base, map[string]string{"src/predict.py": "v1"}, labels)
+	b := modelImage(t, base, map[string]string{"src/predict.py": "v1"}, labels)
+
+	diff, err := diffImages(a, b)
+	require.NoError(t, err)
+	require.True(t, diff.Empty())
+	require.Equal(t, 2, diff.Layers.Shared)
+}
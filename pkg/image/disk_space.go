@@ -0,0 +1,195 @@
+package image
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/dockercontext"
+	"github.com/replicate/cog/pkg/util/console"
+	"github.com/replicate/cog/pkg/util/files"
+	"github.com/replicate/cog/pkg/util/slices"
+)
+
+// diskSpaceReserve is how much space a build leaves free, so Docker, and everything else on the machine, doesn't run
+// out of space when the build is done
+const diskSpaceReserve = 2 << 30
+
+// Rough sizes of base images, pulled and extracted. They're on the low side, so builds aren't stopped when there's
+// enough space.
+const (
+	gpuBaseImageSize = 5 << 30
+	cpuBaseImageSize = 500 << 20
+)
+
+// packageSizes are roughly how much space the largest Python packages take installed, with their dependencies. Other
+// packages are counted as defaultPackageSize.
+var packageSizes = map[string]struct{ gpu, cpu int64 }{
+	"torch":           {4 << 30, 1 << 30},
+	"tensorflow":      {2 << 30, 1 << 30},
+	"jax":             {1 << 30, 200 << 20},
+	"vllm":            {1 << 30, 1 << 30},
+	"xformers":        {500 << 20, 500 << 20},
+	"onnxruntime-gpu": {500 << 20, 500 << 20},
+}
+
+const defaultPackageSize = 30 << 20
+
+// cogBaseImagePackages are installed in cog base images, so they don't take any more space
+var cogBaseImagePackages = []string{"torch", "torchvision", "torchaudio"}
+
+// freeSpace returns the space available in the filesystem path is on, and is replaced in tests
+var freeSpace = files.FreeSpace
+
+// sameFilesystem returns whether two paths are on the same filesystem, and is replaced in tests
+var sameFilesystem = func(a, b string) bool {
+	var statA, statB unix.Stat_t
+	if unix.Stat(a, &statA) != nil || unix.Stat(b, &statB) != nil {
+		return false
+	}
+	return statA.Dev == statB.Dev
+}
+
+// DiskSpaceEstimate is roughly how much space a build needs, in bytes
+type DiskSpaceEstimate struct {
+	// BaseImage is the space to pull and extract the base image, which is 0 if it's already pulled
+	BaseImage int64
+	// Packages is the space the Python packages take installed
+	Packages int64
+	// Context is the space the build context takes in the image. It's also sent to Docker, and weights are copied
+	// to the project's tmp directory when they're built into a separate image.
+	Context int64
+	// Weights is the space the weights copied to the project's tmp directory take
+	Weights int64
+}
+
+// Docker returns the space the build needs where Docker stores images and its build cache
+func (e DiskSpaceEstimate) Docker() int64 {
+	return e.BaseImage + e.Packages + 2*e.Context
+}
+
+// estimateDiskSpace estimates the space a build of cfg needs. baseImage is "" if it isn't known, like when building
+// from a Dockerfile, and baseImageExists is whether it's already pulled.
+func estimateDiskSpace(cfg *config.Config, baseImage string, baseImageExists bool, contextSize int64, separateWeights bool) DiskSpaceEstimate {
+	estimate := DiskSpaceEstimate{Context: contextSize}
+	if separateWeights {
+		estimate.Weights = contextSize
+	}
+	if baseImage != "" && !baseImageExists {
+		estimate.BaseImage = cpuBaseImageSize
+		if cfg.Build.GPU {
+			estimate.BaseImage = gpuBaseImageSize
+		}
+	}
+	isCogBaseImage := strings.Contains(baseImage, "cog-base")
+	for _, req := range cfg.Build.PythonRequirementsContent() {
+		req = strings.TrimSpace(req)
+		if req == "" || strings.HasPrefix(req, "-") || strings.HasPrefix(req, "#") {
+			continue
+		}
+		name := strings.ToLower(config.PipPackageNameRegex.FindString(req))
+		if isCogBaseImage && slices.ContainsString(cogBaseImagePackages, name) {
+			continue
+		}
+		size, ok := packageSizes[name]
+		switch {
+		case !ok:
+			estimate.Packages += defaultPackageSize
+		case cfg.Build.GPU:
+			estimate.Packages += size.gpu
+		default:
+			estimate.Packages += size.cpu
+		}
+	}
+	return estimate
+}
+
+// checkDiskSpace fails the build before it starts if there isn't enough space for it where Docker stores images or
+// in the project's tmp directory, rather than it failing with "no space left on device" after pulling and installing
+// most of it
+func checkDiskSpace(cfg *config.Config, dir string, baseImage string, contextSize int64, separateWeights bool) error {
+	if config.BuildSkipDiskCheck {
+		return nil
+	}
+	baseImageExists := false
+	if baseImage != "" {
+		exists, err := docker.ImageExists(baseImage)
+		if err != nil {
+			console.Debugf("Failed to check whether %s is pulled: %s", baseImage, err)
+		}
+		baseImageExists = exists
+	}
+	estimate := estimateDiskSpace(cfg, baseImage, baseImageExists, contextSize, separateWeights)
+
+	dockerRoot, err := docker.RootDir()
+	if err != nil {
+		console.Debugf("Failed to get Docker's root directory, so not checking its free space: %s", err)
+		dockerRoot = ""
+	}
+	return compareDiskSpace(estimate, dockerRoot, existingParent(dockercontext.CogTempDir(dir, "")))
+}
+
+// compareDiskSpace fails if the estimated space, with diskSpaceReserve left over, isn't free in dockerRoot or
+// tmpDir. dockerRoot is "" if it isn't known or isn't on this machine, like with Docker Desktop, which stores images
+// in a VM.
+func compareDiskSpace(estimate DiskSpaceEstimate, dockerRoot string, tmpDir string) error {
+	type location struct {
+		path     string
+		name     string
+		required int64
+	}
+	locations := []location{}
+	if dockerRoot != "" {
+		locations = append(locations, location{dockerRoot, "Docker's data directory", estimate.Docker()})
+	}
+	if estimate.Weights > 0 {
+		if dockerRoot != "" && sameFilesystem(dockerRoot, tmpDir) {
+			locations[0].required += estimate.Weights
+		} else {
+			locations = append(locations, location{tmpDir, "the project's tmp directory", estimate.Weights})
+		}
+	}
+
+	for _, loc := range locations {
+		free, err := freeSpace(loc.path)
+		if err != nil {
+			console.Debugf("Failed to get the free space in %s, so not checking it: %s", loc.path, err)
+			continue
+		}
+		console.Debugf("The build needs about %s in %s (%s), and %s is free", console.FormatBytes(loc.required), loc.name, loc.path, console.FormatBytes(free))
+		if free-loc.required >= diskSpaceReserve {
+			continue
+		}
+		return fmt.Errorf(`There isn't enough disk space to build the image. It needs about %s in %s (%s), plus %s left free, but only %s is free.
+
+The estimate is %s for the base image, %s for the Python packages and %s for the build context, counted twice because it's sent to Docker and copied into the image. To free up space:
+  - Remove unused images, containers and build cache with 'docker system prune' and 'docker builder prune'
+  - Add files the image doesn't need, like checkpoints you aren't using, to .dockerignore
+  - Move Docker's data directory to a bigger disk
+
+If you're sure there's enough space, pass --no-disk-check to build anyway.`,
+			console.FormatBytes(loc.required), loc.name, loc.path, console.FormatBytes(diskSpaceReserve), console.FormatBytes(free),
+			console.FormatBytes(estimate.BaseImage), console.FormatBytes(estimate.Packages), console.FormatBytes(estimate.Context))
+	}
+	return nil
+}
+
+// existingParent returns path, or its closest parent that exists, so the filesystem it will be on can be checked
+// before it's created
+func existingParent(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
@@ -0,0 +1,54 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/config"
+)
+
+func TestEstimateDiskSpace(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "requirements.txt"), []byte("torch==2.3.0\nnumpy\n"), 0o644))
+	cfg, err := config.FromYAML([]byte("build:\n  gpu: true\n  python_version: \"3.12\"\n  python_requirements: requirements.txt\npredict: predict.py:Predictor\n"))
+	require.NoError(t, err)
+	require.NoError(t, cfg.ValidateAndComplete(dir))
+
+	estimate := estimateDiskSpace(cfg, "nvidia/cuda:12.1.1-cudnn8-devel-ubuntu22.04", false, 1<<30, true)
+	require.Equal(t, DiskSpaceEstimate{BaseImage: gpuBaseImageSize, Packages: 4<<30 + defaultPackageSize, Context: 1 << 30, Weights: 1 << 30}, estimate)
+
+	// torch is already in cog base images, and pulled base images don't take any more space
+	estimate = estimateDiskSpace(cfg, "r8.im/cog-base:cuda12.1-python3.12-torch2.3.0", true, 0, false)
+	require.Equal(t, DiskSpaceEstimate{Packages: defaultPackageSize}, estimate)
+}
+
+func TestCompareDiskSpace(t *testing.T) {
+	originalFreeSpace, originalSameFilesystem := freeSpace, sameFilesystem
+	t.Cleanup(func() {
+		freeSpace, sameFilesystem = originalFreeSpace, originalSameFilesystem
+	})
+	free := map[string]int64{"/var/lib/docker": 9 << 30, "/project/.cog": 3 << 30}
+	freeSpace = func(path string) (int64, error) { return free[path], nil }
+	same := false
+	sameFilesystem = func(a, b string) bool { return same }
+
+	estimate := DiskSpaceEstimate{BaseImage: 5 << 30, Packages: 1 << 30, Context: 512 << 20, Weights: 512 << 20}
+	require.NoError(t, compareDiskSpace(estimate, "/var/lib/docker", "/project/.cog"))
+
+	// Docker's data directory is checked if it's known
+	estimate.Context = 1 << 30
+	err := compareDiskSpace(estimate, "/var/lib/docker", "/project/.cog")
+	require.ErrorContains(t, err, "It needs about 8.0 GiB in Docker's data directory (/var/lib/docker), plus 2.0 GiB left free, but only 9.0 GiB is free")
+	require.NoError(t, compareDiskSpace(estimate, "", "/project/.cog"))
+
+	// Weights copied to the tmp directory are counted with Docker's if they're on the same filesystem
+	estimate = DiskSpaceEstimate{Context: 3 << 30, Weights: 3 << 30}
+	err = compareDiskSpace(estimate, "/var/lib/docker", "/project/.cog")
+	require.ErrorContains(t, err, "the project's tmp directory")
+	same = true
+	err = compareDiskSpace(estimate, "/var/lib/docker", "/project/.cog")
+	require.ErrorContains(t, err, "It needs about 9.0 GiB in Docker's data directory")
+}
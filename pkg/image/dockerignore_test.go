@@ -0,0 +1,42 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/config"
+	cogerrors "github.com/replicate/cog/pkg/errors"
+)
+
+func TestDockerignoreBackupIsInProjectDir(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".dockerignore"), []byte("data\r\n"), 0o644))
+
+	require.NoError(t, backupDockerignore(dir))
+	require.FileExists(t, filepath.Join(dir, dockerignoreBackupPath))
+	require.NoError(t, writeDockerignore(dir, "weights\n"))
+	contents, err := os.ReadFile(filepath.Join(dir, ".dockerignore"))
+	require.NoError(t, err)
+	require.Equal(t, "data\r\n\nweights\n", string(contents))
+
+	require.NoError(t, restoreDockerignore(dir))
+	contents, err = os.ReadFile(filepath.Join(dir, ".dockerignore"))
+	require.NoError(t, err)
+	require.Equal(t, "data\r\n", string(contents))
+	require.NoFileExists(t, filepath.Join(dir, dockerignoreBackupPath))
+}
+
+func TestBuildIncompatibleDockerignoreIsConfigError(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".dockerignore"), []byte(".cog\n"), 0o644))
+	cfg, err := config.FromYAML([]byte("build:\n  python_version: \"3.12\"\npredict: predict.py:Predictor\n"))
+	require.NoError(t, err)
+	require.NoError(t, cfg.ValidateAndComplete(""))
+
+	err = Build(cfg, dir, "my-model", nil, nil, false, false, "", "plain", "", "", nil, false, false, false, nil, false, nil)
+	require.ErrorContains(t, err, "cannot be ignored")
+	require.Equal(t, cogerrors.CategoryConfig, cogerrors.CategoryOf(err))
+}
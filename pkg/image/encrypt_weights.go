@@ -0,0 +1,435 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/docker/command"
+	"github.com/replicate/cog/pkg/dockercontext"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+//go:embed decrypt_weights.py
+var decryptWeightsShim []byte
+
+const (
+	// EncryptedWeightsManifestPath is where the list of encrypted weights is in the image
+	EncryptedWeightsManifestPath = "src/.cog/encrypted-weights.json"
+	// DecryptWeightsShimPath is the entrypoint that decrypts the weights when the container starts
+	DecryptWeightsShimPath = "usr/local/bin/cog-decrypt-weights"
+	// DecryptedWeightsDir is the tmpfs the weights are decrypted into, so the decrypted weights are never written to
+	// disk, and the container's root filesystem can be read-only. Each weight's path is a symlink to its copy there.
+	DecryptedWeightsDir = "/dev/shm/cog-weights"
+
+	encryptedWeightsSuffix = ".enc"
+	weightsKeySize         = 32
+)
+
+// EncryptedWeights is the manifest of the weights in an image that are encrypted, which the shim decrypts
+type EncryptedWeights struct {
+	Version int `json:"version"`
+	// KMS is the KMS key the key is wrapped with, like awskms://alias/models, if it isn't a key file
+	KMS        string `json:"kms,omitempty"`
+	WrappedKey string `json:"wrapped_key,omitempty"`
+	// DecryptedDir is where the weights are decrypted to, under their paths
+	DecryptedDir string                `json:"decrypted_dir"`
+	Files        []EncryptedWeightFile `json:"files"`
+}
+
+// EncryptedWeightFile is a weight file that's stored in the image with .enc appended to its path, and a symlink to
+// where it's decrypted at its path
+type EncryptedWeightFile struct {
+	Path string `json:"path"`
+	IV   string `json:"iv"`
+	MAC  string `json:"mac"`
+	Mode int64  `json:"mode"`
+}
+
+// weightsKey is the key that weights are encrypted with, and how it's wrapped if it's from a KMS
+type weightsKey struct {
+	key        []byte
+	kms        string
+	wrappedKey []byte
+}
+
+// EncryptWeights encrypts the weights in a model image in the local Docker daemon, so it can be stored in a
+// registry that isn't trusted with them. keyRef is a path to a 32 byte key, raw or in hex, or a KMS key like
+// awskms://alias/models or gcpkms://projects/.../cryptoKeys/..., which wraps a key that's generated for the image.
+// Weights are found in dir the same way as for `cog build --separate-weights`. The image's entrypoint is replaced
+// with a shim that decrypts them when the container starts.
+func EncryptWeights(imageName string, dir string, keyRef string) error {
+	weightPaths, err := findWeightPaths(dir)
+	if err != nil {
+		return fmt.Errorf("Failed to find weights: %w", err)
+	}
+	if len(weightPaths) == 0 {
+		return errors.New("No weights were found to encrypt")
+	}
+	key, err := resolveWeightsKey(keyRef)
+	if err != nil {
+		return err
+	}
+	tag, err := name.NewTag(imageName)
+	if err != nil {
+		return fmt.Errorf("Failed to parse image name %s: %w", imageName, err)
+	}
+
+	tmpDir, err := dockercontext.MkdirTemp("cog-encrypt-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	console.Info("Encrypting weights...")
+	archive := filepath.Join(tmpDir, "image.tar")
+	if err := docker.SaveImage(imageName, archive); err != nil {
+		return fmt.Errorf("Failed to export %s: %w", imageName, err)
+	}
+	img, err := tarball.ImageFromPath(archive, &tag)
+	if err != nil {
+		return err
+	}
+	encrypted, err := encryptWeights(img, weightPaths, key, tmpDir)
+	if err != nil {
+		return fmt.Errorf("Failed to encrypt the weights in %s: %w", imageName, err)
+	}
+	encryptedArchive := filepath.Join(tmpDir, "encrypted.tar")
+	if err := tarball.WriteToFile(encryptedArchive, tag, encrypted); err != nil {
+		return err
+	}
+	if err := docker.LoadImage(encryptedArchive); err != nil {
+		return fmt.Errorf("Failed to load encrypted image: %w", err)
+	}
+	return nil
+}
+
+func encryptWeights(img v1.Image, weightPaths []string, key *weightsKey, tmpDir string) (v1.Image, error) {
+	configFile, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+	// The base image doesn't have the model's weights, so its layers don't need to be read
+	first := 0
+	if label := configFile.Config.Labels[command.CogBaseImageLastLayerIndexLabelKey]; label != "" {
+		if lastBaseLayer, err := strconv.Atoi(label); err == nil && lastBaseLayer < len(layers) {
+			first = lastBaseLayer + 1
+		}
+	}
+
+	encryptionKey, macKey := deriveWeightsKeys(key.key)
+	manifest := EncryptedWeights{Version: 1, KMS: key.kms, DecryptedDir: DecryptedWeightsDir, Files: []EncryptedWeightFile{}}
+	if key.wrappedKey != nil {
+		manifest.WrappedKey = base64.StdEncoding.EncodeToString(key.wrappedKey)
+	}
+	encryptedLayers := append([]v1.Layer{}, layers...)
+	for i := first; i < len(layers); i++ {
+		encryptedPath := filepath.Join(tmpDir, fmt.Sprintf("layer-%d.tar", i))
+		files, err := encryptLayer(layers[i], weightPaths, encryptionKey, macKey, encryptedPath)
+		if err != nil {
+			return nil, err
+		}
+		if files == nil {
+			continue
+		}
+		manifest.Files = append(manifest.Files, files...)
+		encryptedLayers[i], err = tarball.LayerFromFile(encryptedPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(manifest.Files) == 0 {
+		return nil, errors.New("None of the weights are in the image")
+	}
+
+	shimLayer, err := decryptWeightsLayer(manifest)
+	if err != nil {
+		return nil, err
+	}
+	encryptedConfig := configFile.DeepCopy()
+	encryptedConfig.History = nil
+	encryptedConfig.RootFS.DiffIDs = nil
+	encryptedConfig.Config.Entrypoint = append([]string{"/" + DecryptWeightsShimPath}, configFile.Config.Entrypoint...)
+	encrypted, err := mutate.ConfigFile(empty.Image, encryptedConfig)
+	if err != nil {
+		return nil, err
+	}
+	adds := addendums(configFile.History, encryptedLayers, 0)
+	adds = append(adds, mutate.Addendum{
+		Layer: shimLayer,
+		History: v1.History{
+			Created:   configFile.Created,
+			CreatedBy: "cog: decrypt weights",
+			Comment:   "Created by cog build --encrypt-weights",
+		},
+	})
+	return mutate.Append(encrypted, adds...)
+}
+
+// encryptLayer writes layer to encryptedPath with the weights in it encrypted, and returns them. If there aren't
+// any weights in the layer, nothing is written and it returns nil.
+func encryptLayer(layer v1.Layer, weightPaths []string, encryptionKey []byte, macKey []byte, encryptedPath string) (files []EncryptedWeightFile, err error) {
+	hasWeights := false
+	err = walkLayer(layer, func(header *tar.Header, _ io.Reader) error {
+		if isWeightPath(whiteoutTarget(layerPath(header.Name)), weightPaths) {
+			hasWeights = true
+		}
+		return nil
+	})
+	if err != nil || !hasWeights {
+		return nil, err
+	}
+
+	f, err := os.Create(encryptedPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	defer func() {
+		if closeErr := tw.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	files = []EncryptedWeightFile{}
+	encryptedFiles := map[string]EncryptedWeightFile{}
+	err = walkLayer(layer, func(header *tar.Header, contents io.Reader) error {
+		p := layerPath(header.Name)
+		target := whiteoutTarget(p)
+		if !isWeightPath(target, weightPaths) {
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			_, err := io.Copy(tw, contents)
+			return err
+		}
+
+		if target != p {
+			// Whiteouts of weights delete their encrypted files instead
+			header.Name += encryptedWeightsSuffix
+			return tw.WriteHeader(header)
+		}
+		switch header.Typeflag {
+		case tar.TypeReg:
+			file, err := encryptFile(tw, header, contents, encryptionKey, macKey)
+			if err != nil {
+				return err
+			}
+			encryptedFiles[p] = *file
+			files = append(files, *file)
+			return nil
+		case tar.TypeLink:
+			linked, ok := encryptedFiles[layerPath(header.Linkname)]
+			if !ok {
+				return fmt.Errorf("%s is a hard link to %s, which isn't a weight in the same layer", p, header.Linkname)
+			}
+			header.Name += encryptedWeightsSuffix
+			header.Linkname += encryptedWeightsSuffix
+			linked.Path = "/" + p
+			files = append(files, linked)
+			return tw.WriteHeader(header)
+		}
+		return tw.WriteHeader(header)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// encryptFile writes a weight file to tw, encrypted with AES-256-CTR, with .enc appended to its name. The MAC is
+// an HMAC-SHA256 of the IV and the encrypted file.
+func encryptFile(tw *tar.Writer, header *tar.Header, contents io.Reader, encryptionKey []byte, macKey []byte) (*EncryptedWeightFile, error) {
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(iv)
+
+	encryptedHeader := *header
+	encryptedHeader.Name += encryptedWeightsSuffix
+	if err := tw.WriteHeader(&encryptedHeader); err != nil {
+		return nil, err
+	}
+	writer := &cipher.StreamWriter{S: cipher.NewCTR(block, iv), W: io.MultiWriter(tw, mac)}
+	if _, err := io.Copy(writer, contents); err != nil {
+		return nil, err
+	}
+	return &EncryptedWeightFile{
+		Path: "/" + layerPath(header.Name),
+		IV:   hex.EncodeToString(iv),
+		MAC:  hex.EncodeToString(mac.Sum(nil)),
+		Mode: header.Mode & 0o7777,
+	}, nil
+}
+
+// decryptWeightsLayer is a layer with the shim that decrypts the weights, the manifest of what to decrypt, and
+// symlinks from the weights' paths to where they're decrypted
+func decryptWeightsLayer(manifest EncryptedWeights) (v1.Layer, error) {
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	linked := map[string]bool{}
+	for _, file := range manifest.Files {
+		if linked[file.Path] {
+			continue
+		}
+		linked[file.Path] = true
+		header := &tar.Header{Name: strings.TrimPrefix(file.Path, "/"), Linkname: manifest.DecryptedDir + file.Path, Mode: 0o777, Typeflag: tar.TypeSymlink}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+	}
+	for _, file := range []struct {
+		path     string
+		contents []byte
+		mode     int64
+	}{
+		{DecryptWeightsShimPath, decryptWeightsShim, 0o755},
+		{EncryptedWeightsManifestPath, manifestJSON, 0o644},
+	} {
+		if err := tw.WriteHeader(&tar.Header{Name: file.path, Mode: file.mode, Size: int64(len(file.contents)), Typeflag: tar.TypeReg}); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(file.contents); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+	return tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	})
+}
+
+// resolveWeightsKey reads the key in the file at keyRef, or generates one and wraps it with the KMS key keyRef
+// refers to
+func resolveWeightsKey(keyRef string) (*weightsKey, error) {
+	if !strings.HasPrefix(keyRef, "awskms://") && !strings.HasPrefix(keyRef, "gcpkms://") {
+		data, err := os.ReadFile(keyRef)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read weights key: %w", err)
+		}
+		key, err := parseWeightsKey(data)
+		if err != nil {
+			return nil, err
+		}
+		return &weightsKey{key: key}, nil
+	}
+
+	key := make([]byte, weightsKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	wrapped, err := wrapWeightsKey(keyRef, key)
+	if err != nil {
+		return nil, err
+	}
+	return &weightsKey{key: key, kms: keyRef, wrappedKey: wrapped}, nil
+}
+
+// parseWeightsKey parses a 32 byte key, which can be raw or in hex
+func parseWeightsKey(data []byte) ([]byte, error) {
+	if len(data) == weightsKeySize {
+		return data, nil
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != weightsKeySize {
+		return nil, fmt.Errorf("The weights key must be %d bytes, or %d hex characters. Generate one with 'openssl rand -hex %d'", weightsKeySize, weightsKeySize*2, weightsKeySize)
+	}
+	return key, nil
+}
+
+// wrapWeightsKey encrypts key with a KMS key, using the aws or gcloud CLI
+func wrapWeightsKey(kms string, key []byte) ([]byte, error) {
+	keyFile, err := os.CreateTemp("", "cog-weights-key-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(keyFile.Name())
+	if _, err := keyFile.Write(key); err != nil {
+		keyFile.Close()
+		return nil, err
+	}
+	keyFile.Close()
+
+	var cmd *exec.Cmd
+	if keyID, ok := strings.CutPrefix(kms, "awskms://"); ok {
+		cmd = exec.Command("aws", "kms", "encrypt", "--key-id", keyID, "--plaintext", "fileb://"+keyFile.Name(), "--output", "text", "--query", "CiphertextBlob")
+	} else {
+		cmd = exec.Command("gcloud", "kms", "encrypt", "--key", strings.TrimPrefix(kms, "gcpkms://"), "--plaintext-file", keyFile.Name(), "--ciphertext-file", "-")
+	}
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	console.Debug("$ " + strings.Join(cmd.Args, " "))
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to wrap the weights key with %s: %w %s", kms, err, strings.TrimSpace(stderr.String()))
+	}
+	if strings.HasPrefix(kms, "awskms://") {
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(string(output)))
+	}
+	return output, nil
+}
+
+// deriveWeightsKeys derives separate keys for encrypting and authenticating weights from key
+func deriveWeightsKeys(key []byte) (encryptionKey []byte, macKey []byte) {
+	derive := func(purpose string) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(purpose))
+		return mac.Sum(nil)
+	}
+	return derive("cog weights encryption"), derive("cog weights authentication")
+}
+
+func isWeightPath(p string, weightPaths []string) bool {
+	return classifyPath(p, weightPaths) == partitionWeights
+}
+
+// whiteoutTarget returns the path a whiteout deletes, or p if it isn't one
+func whiteoutTarget(p string) string {
+	dir, base := path.Split(p)
+	if strings.HasPrefix(base, whiteoutPrefix) && base != opaqueWhiteout {
+		return path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))
+	}
+	return p
+}
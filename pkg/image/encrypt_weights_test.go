@@ -0,0 +1,166 @@
+package image
+
+import (
+	"archive/tar"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/docker/command"
+)
+
+var testWeightsKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestEncryptWeights(t *testing.T) {
+	base, err := random.Image(1024, 2)
+	require.NoError(t, err)
+	img, err := mutate.AppendLayers(base,
+		tarLayer(t, map[string]string{"src/predict.py": "predict", "src/weights/model.bin": "weights", "src/weights/.wh.old.bin": ""}),
+	)
+	require.NoError(t, err)
+	img = withLabels(t, img, map[string]string{
+		command.CogBaseImageLastLayerIndexLabelKey: "1",
+	})
+	configFile, err := img.ConfigFile()
+	require.NoError(t, err)
+	configFile.Config.Entrypoint = []string{"/sbin/tini", "--"}
+	img, err = mutate.ConfigFile(img, configFile)
+	require.NoError(t, err)
+
+	encrypted, err := encryptWeights(img, []string{"src/weights"}, &weightsKey{key: testWeightsKey}, t.TempDir())
+	require.NoError(t, err)
+
+	layers, err := encrypted.Layers()
+	require.NoError(t, err)
+	require.Len(t, layers, 4)
+	require.Equal(t, diffIDs(t, base), diffIDs(t, encrypted)[:2])
+	files := layerFiles(t, layers[2])
+	require.Equal(t, "predict", files["src/predict.py"])
+	require.NotContains(t, files, "src/weights/model.bin")
+	require.Contains(t, files, "src/weights/.wh.old.bin.enc")
+
+	shim := layerFiles(t, layers[3])
+	require.Equal(t, string(decryptWeightsShim), shim[DecryptWeightsShimPath])
+	manifest := EncryptedWeights{}
+	require.NoError(t, json.Unmarshal([]byte(shim[EncryptedWeightsManifestPath]), &manifest))
+	require.Len(t, manifest.Files, 1)
+	file := manifest.Files[0]
+	require.Equal(t, "/src/weights/model.bin", file.Path)
+	require.Equal(t, DecryptedWeightsDir, manifest.DecryptedDir)
+
+	// The weights' paths link to where they're decrypted
+	links := map[string]string{}
+	require.NoError(t, walkLayer(layers[3], func(header *tar.Header, _ io.Reader) error {
+		if header.Typeflag == tar.TypeSymlink {
+			links[header.Name] = header.Linkname
+		}
+		return nil
+	}))
+	require.Equal(t, map[string]string{"src/weights/model.bin": "/dev/shm/cog-weights/src/weights/model.bin"}, links)
+
+	// The weights decrypt with the IV and MAC in the manifest
+	ciphertext := []byte(files["src/weights/model.bin.enc"])
+	encryptionKey, macKey := deriveWeightsKeys(testWeightsKey)
+	iv, err := hex.DecodeString(file.IV)
+	require.NoError(t, err)
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(iv)
+	mac.Write(ciphertext)
+	require.Equal(t, file.MAC, hex.EncodeToString(mac.Sum(nil)))
+	block, err := aes.NewCipher(encryptionKey)
+	require.NoError(t, err)
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCTR(block, iv).XORKeyStream(plaintext, ciphertext)
+	require.Equal(t, "weights", string(plaintext))
+
+	encryptedConfig, err := encrypted.ConfigFile()
+	require.NoError(t, err)
+	require.Equal(t, []string{"/" + DecryptWeightsShimPath, "/sbin/tini", "--"}, encryptedConfig.Config.Entrypoint)
+}
+
+func TestEncryptWeightsWithoutWeights(t *testing.T) {
+	img, err := mutate.AppendLayers(empty.Image, tarLayer(t, map[string]string{"src/predict.py": "predict"}))
+	require.NoError(t, err)
+	_, err = encryptWeights(img, []string{"src/weights"}, &weightsKey{key: testWeightsKey}, t.TempDir())
+	require.ErrorContains(t, err, "None of the weights are in the image")
+}
+
+func TestDecryptWeightsShim(t *testing.T) {
+	for _, bin := range []string{"python3", "openssl"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s isn't installed", bin)
+		}
+	}
+	img, err := mutate.AppendLayers(empty.Image, tarLayer(t, map[string]string{"src/weights/model.bin": strings.Repeat("weights", 10000)}))
+	require.NoError(t, err)
+	encrypted, err := encryptWeights(img, []string{"src/weights"}, &weightsKey{key: testWeightsKey}, t.TempDir())
+	require.NoError(t, err)
+	layers, err := encrypted.Layers()
+	require.NoError(t, err)
+	files := layerFiles(t, layers[0])
+	shim := layerFiles(t, layers[1])
+
+	// Lay out the image's files in a temporary directory, instead of /
+	dir := t.TempDir()
+	manifest := EncryptedWeights{}
+	require.NoError(t, json.Unmarshal([]byte(shim[EncryptedWeightsManifestPath]), &manifest))
+	for i := range manifest.Files {
+		manifest.Files[i].Path = filepath.Join(dir, manifest.Files[i].Path)
+	}
+	manifest.DecryptedDir = filepath.Join(dir, "shm")
+	manifestJSON, err := json.Marshal(manifest)
+	require.NoError(t, err)
+	manifestPath := filepath.Join(dir, "encrypted-weights.json")
+	require.NoError(t, os.WriteFile(manifestPath, manifestJSON, 0o644))
+	weightsPath := filepath.Join(dir, "src/weights/model.bin")
+	require.NoError(t, os.MkdirAll(filepath.Dir(weightsPath), 0o755))
+	require.NoError(t, os.WriteFile(weightsPath+encryptedWeightsSuffix, []byte(files["src/weights/model.bin.enc"]), 0o644))
+	decryptedPath := manifest.DecryptedDir + weightsPath
+	require.NoError(t, os.Symlink(decryptedPath, weightsPath))
+	shimPath := filepath.Join(dir, "cog-decrypt-weights")
+	require.NoError(t, os.WriteFile(shimPath, decryptWeightsShim, 0o755))
+
+	cmd := exec.Command("python3", shimPath, "cat", weightsPath)
+	cmd.Env = append(os.Environ(), "COG_ENCRYPTED_WEIGHTS_MANIFEST="+manifestPath, "COG_WEIGHTS_KEY="+hex.EncodeToString(testWeightsKey))
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(output))
+	require.Equal(t, strings.Repeat("weights", 10000), string(output))
+	// The encrypted weights are left as they are, so the root filesystem can be read-only
+	require.FileExists(t, weightsPath+encryptedWeightsSuffix)
+	require.FileExists(t, decryptedPath)
+
+	// The wrong key is caught by the MAC
+	require.NoError(t, os.Remove(decryptedPath))
+	cmd = exec.Command("python3", shimPath, "true")
+	cmd.Env = append(os.Environ(), "COG_ENCRYPTED_WEIGHTS_MANIFEST="+manifestPath, "COG_WEIGHTS_KEY="+strings.Repeat("00", 32))
+	output, err = cmd.CombinedOutput()
+	require.Error(t, err)
+	require.Contains(t, string(output), "doesn't match its MAC")
+}
+
+func TestParseWeightsKey(t *testing.T) {
+	key, err := parseWeightsKey(testWeightsKey)
+	require.NoError(t, err)
+	require.Equal(t, testWeightsKey, key)
+
+	key, err = parseWeightsKey([]byte(hex.EncodeToString(testWeightsKey) + "\n"))
+	require.NoError(t, err)
+	require.Equal(t, testWeightsKey, key)
+
+	_, err = parseWeightsKey([]byte("too short"))
+	require.ErrorContains(t, err, "openssl rand -hex 32")
+}
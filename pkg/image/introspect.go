@@ -0,0 +1,123 @@
+package image
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/retry"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// How the built image is run to get its schema and pip freeze, with --introspect-with or COG_INTROSPECT_WITH.
+// It can also be a Docker host, like ssh://user@gpu-host, to run it on a machine with GPUs.
+const (
+	IntrospectWithEnvVarName = "COG_INTROSPECT_WITH"
+	// IntrospectAuto runs it locally, with GPUs if the model needs them and Docker has them
+	IntrospectAuto = "auto"
+	// IntrospectCPU runs it locally without GPUs, hiding them from the model, for CI machines without GPUs
+	IntrospectCPU = "cpu"
+)
+
+// introspectHostSchemes are the kinds of Docker host the built image can be introspected on
+var introspectHostSchemes = map[string]bool{"ssh": true, "tcp": true, "unix": true, "npipe": true}
+
+// cpuEnv hides GPUs from the model's code, so libraries that look for one when they're imported, like
+// torch.cuda.is_available() at the top of predict.py, carry on without one
+var cpuEnv = []string{"CUDA_VISIBLE_DEVICES=", "NVIDIA_VISIBLE_DEVICES=void"}
+
+// introspectWith returns how the built image is run to get its schema and pip freeze, from --introspect-with, or
+// COG_INTROSPECT_WITH if it isn't set
+func introspectWith() (string, error) {
+	with := config.BuildIntrospectWith
+	if with == "" {
+		with = os.Getenv(IntrospectWithEnvVarName)
+	}
+	switch with {
+	case "":
+		return IntrospectAuto, nil
+	case IntrospectAuto, IntrospectCPU:
+		return with, nil
+	}
+	if u, err := url.Parse(with); err != nil || !introspectHostSchemes[u.Scheme] {
+		return "", fmt.Errorf("Invalid --introspect-with %q. It must be %q, %q, or a Docker host like ssh://user@gpu-host", with, IntrospectAuto, IntrospectCPU)
+	}
+	return with, nil
+}
+
+// prepareIntrospection copies the image to the Docker host it's introspected on, if that isn't the local one.
+// It returns a function that removes it from there again.
+func prepareIntrospection(imageName string) (func(), error) {
+	with, err := introspectWith()
+	if err != nil {
+		return nil, err
+	}
+	if with == IntrospectAuto || with == IntrospectCPU {
+		return func() {}, nil
+	}
+	console.Infof("Copying %s to %s to introspect it...", imageName, with)
+	if err := docker.CopyImageToHost(imageName, with); err != nil {
+		return nil, fmt.Errorf("Failed to copy %s to %s: %w", imageName, with, err)
+	}
+	return func() {
+		if err := docker.RemoveImageFromHost(imageName, with); err != nil {
+			console.Warnf("Failed to remove %s from %s: %s", imageName, with, err)
+		}
+	}, nil
+}
+
+// runIntrospection runs a command in the built image, with GPUs if enableGPU is set, wherever --introspect-with says
+func runIntrospection(options docker.RunOptions, enableGPU bool, stdout, stderr *bytes.Buffer) error {
+	with, err := introspectWith()
+	if err != nil {
+		return err
+	}
+	switch with {
+	case IntrospectCPU:
+		options.Env = append(options.Env, cpuEnv...)
+		enableGPU = false
+	case IntrospectAuto:
+	default:
+		options.Host = with
+	}
+	if enableGPU {
+		options.GPUs = "all"
+	}
+
+	err = runContainer(options, stdout, stderr)
+	if with == IntrospectAuto && enableGPU && err == docker.ErrMissingDeviceDriver {
+		console.Debug(stdout.String())
+		console.Debug(stderr.String())
+		console.Debugf("Missing device driver, re-trying without GPU. Set %s=%s to skip trying with one.", IntrospectWithEnvVarName, IntrospectCPU)
+		stdout.Reset()
+		stderr.Reset()
+		options.GPUs = ""
+		options.Env = append(options.Env, cpuEnv...)
+		return runContainer(options, stdout, stderr)
+	}
+	return err
+}
+
+// dockerRunErrorExitCode is what `docker run` exits with when Docker fails to run the container, rather than the
+// command in it failing
+const dockerRunErrorExitCode = 125
+
+// runContainer runs the built image, retrying if Docker fails to run it with a transient error, like when it's
+// introspected on a remote host that drops the connection. Errors from the model's code aren't retried.
+func runContainer(options docker.RunOptions, stdout, stderr *bytes.Buffer) error {
+	return retry.Do("Running "+options.Image, func() error {
+		stdout.Reset()
+		stderr.Reset()
+		err := docker.RunWithIO(options, nil, stdout, stderr)
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == dockerRunErrorExitCode {
+			return retry.Classify(err, stderr.String())
+		}
+		return retry.Fatal(err)
+	})
+}
@@ -0,0 +1,32 @@
+package image
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/config"
+)
+
+func TestIntrospectWith(t *testing.T) {
+	t.Setenv(IntrospectWithEnvVarName, "")
+	with, err := introspectWith()
+	require.NoError(t, err)
+	require.Equal(t, IntrospectAuto, with)
+
+	t.Setenv(IntrospectWithEnvVarName, "cpu")
+	with, err = introspectWith()
+	require.NoError(t, err)
+	require.Equal(t, IntrospectCPU, with)
+
+	// The flag overrides the environment variable
+	config.BuildIntrospectWith = "ssh://ci@gpu-host"
+	t.Cleanup(func() { config.BuildIntrospectWith = "" })
+	with, err = introspectWith()
+	require.NoError(t, err)
+	require.Equal(t, "ssh://ci@gpu-host", with)
+
+	config.BuildIntrospectWith = "gpu-host"
+	_, err = introspectWith()
+	require.ErrorContains(t, err, `Invalid --introspect-with "gpu-host"`)
+}
@@ -0,0 +1,57 @@
+package image
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/licenses"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// checkLicenses writes a report of the licenses of the packages installed in the image, and fails if any of them
+// aren't allowed by the license policy in cog.yaml
+func checkLicenses(cfg *config.Config, dir string, imageName string, fastFlag bool) error {
+	policy := cfg.Build.Licenses
+	if fastFlag {
+		// Fast builds don't have the packages in the image, they're mounted at runtime
+		if policy != nil {
+			console.Warn("Licenses aren't checked with fast builds")
+		}
+		return nil
+	}
+
+	console.Info("Collecting package licenses...")
+	report, err := licenses.Collect(imageName)
+	if err != nil {
+		if policy == nil {
+			console.Warnf("Failed to collect package licenses: %s", err)
+			return nil
+		}
+		return fmt.Errorf("Failed to collect package licenses: %w", err)
+	}
+	if err := report.Write(dir); err != nil {
+		return fmt.Errorf("Failed to write license report: %w", err)
+	}
+	console.Infof("Wrote license report for %d packages to %s", len(report.Packages), licenses.ReportPath)
+	if policy == nil {
+		return nil
+	}
+
+	if unknown := report.Unknown(); len(unknown) > 0 {
+		names := []string{}
+		for _, pkg := range unknown {
+			names = append(names, pkg.Name)
+		}
+		console.Warnf("The licenses of %d packages aren't known, so they weren't checked: %s", len(unknown), strings.Join(names, ", "))
+	}
+	violations := report.Check(policy)
+	if len(violations) == 0 {
+		return nil
+	}
+	lines := []string{}
+	for _, violation := range violations {
+		lines = append(lines, "  "+violation.String())
+	}
+	return fmt.Errorf("%d packages have licenses that aren't allowed by the licenses policy in cog.yaml:\n%s\n\nAdd them to licenses.ignore if they're acceptable.", len(violations), strings.Join(lines, "\n"))
+}
@@ -0,0 +1,69 @@
+package image
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/docker/command"
+)
+
+// memoryHeadroom is how much more memory than was measured is suggested, because inputs and allocators vary
+const memoryHeadroom = 1.2
+
+// memoryRounding is what suggested memory is rounded up to a multiple of
+const memoryRounding = 256 << 20
+
+// MemoryRequirements are the memory and memory per GPU, in bytes, a model needs. They're 0 if they aren't known.
+type MemoryRequirements struct {
+	Memory    int64
+	GPUMemory int64
+}
+
+// SuggestMemory returns the memory to give a model that used peak bytes, with some headroom
+func SuggestMemory(peak int64) int64 {
+	if peak <= 0 {
+		return 0
+	}
+	suggested := int64(float64(peak) * memoryHeadroom)
+	return (suggested + memoryRounding - 1) / memoryRounding * memoryRounding
+}
+
+// Labels returns the labels that record the requirements on an image
+func (r MemoryRequirements) Labels() map[string]string {
+	labels := map[string]string{}
+	if r.Memory > 0 {
+		labels[command.CogMemoryLabelKey] = strconv.FormatInt(r.Memory, 10)
+	}
+	if r.GPUMemory > 0 {
+		labels[command.CogGPUMemoryLabelKey] = strconv.FormatInt(r.GPUMemory, 10)
+	}
+	return labels
+}
+
+// GetMemoryRequirements returns the requirements recorded on an image by `cog measure`
+func GetMemoryRequirements(imageName string) (MemoryRequirements, error) {
+	inspect, err := docker.ImageInspect(imageName)
+	if err != nil {
+		return MemoryRequirements{}, fmt.Errorf("Failed to inspect %s: %w", imageName, err)
+	}
+	return parseMemoryLabels(inspect.Config.Labels)
+}
+
+func parseMemoryLabels(labels map[string]string) (MemoryRequirements, error) {
+	requirements := MemoryRequirements{}
+	for key, value := range map[string]*int64{
+		command.CogMemoryLabelKey:    &requirements.Memory,
+		command.CogGPUMemoryLabelKey: &requirements.GPUMemory,
+	} {
+		if labels[key] == "" {
+			continue
+		}
+		parsed, err := strconv.ParseInt(labels[key], 10, 64)
+		if err != nil {
+			return MemoryRequirements{}, fmt.Errorf("Failed to parse %s label: %w", key, err)
+		}
+		*value = parsed
+	}
+	return requirements, nil
+}
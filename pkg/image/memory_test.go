@@ -0,0 +1,37 @@
+package image
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/docker/command"
+)
+
+func TestSuggestMemory(t *testing.T) {
+	require.Equal(t, int64(0), SuggestMemory(0))
+	require.Equal(t, int64(256<<20), SuggestMemory(100<<20))
+	// 10GiB with 20% headroom is 12GiB
+	require.Equal(t, int64(12<<30), SuggestMemory(10<<30))
+	require.Equal(t, int64(12<<30+256<<20), SuggestMemory(10<<30+1))
+}
+
+func TestMemoryLabels(t *testing.T) {
+	requirements := MemoryRequirements{Memory: 12 << 30, GPUMemory: 20 << 30}
+	labels := requirements.Labels()
+	require.Equal(t, map[string]string{
+		command.CogMemoryLabelKey:    "12884901888",
+		command.CogGPUMemoryLabelKey: "21474836480",
+	}, labels)
+
+	parsed, err := parseMemoryLabels(labels)
+	require.NoError(t, err)
+	require.Equal(t, requirements, parsed)
+
+	parsed, err = parseMemoryLabels(map[string]string{command.CogMemoryLabelKey: "1024"})
+	require.NoError(t, err)
+	require.Equal(t, MemoryRequirements{Memory: 1024}, parsed)
+
+	_, err = parseMemoryLabels(map[string]string{command.CogMemoryLabelKey: "lots"})
+	require.Error(t, err)
+}
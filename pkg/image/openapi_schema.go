@@ -7,37 +7,24 @@ import (
 
 	"github.com/getkin/kin-openapi/openapi3"
 
+	"github.com/replicate/cog/pkg/config"
 	"github.com/replicate/cog/pkg/docker"
 	"github.com/replicate/cog/pkg/docker/command"
+	"github.com/replicate/cog/pkg/dockerfile"
 	"github.com/replicate/cog/pkg/util/console"
 )
 
 // GenerateOpenAPISchema by running the image and executing Cog
 // This will be run as part of the build process then added as a label to the image. It can be retrieved more efficiently with the label by using GetOpenAPISchema
-func GenerateOpenAPISchema(imageName string, enableGPU bool) (map[string]any, error) {
+func GenerateOpenAPISchema(imageName string, enableGPU bool, language string) (map[string]any, error) {
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
 
 	// FIXME(bfirsh): we could detect this by reading the config label on the image
-	gpus := ""
-	if enableGPU {
-		gpus = "all"
-	}
-
-	err := docker.RunWithIO(docker.RunOptions{
+	err := runIntrospection(docker.RunOptions{
 		Image: imageName,
-		Args: []string{
-			"python", "-m", "cog.command.openapi_schema",
-		},
-		GPUs: gpus,
-	}, nil, &stdout, &stderr)
-
-	if enableGPU && err == docker.ErrMissingDeviceDriver {
-		console.Debug(stdout.String())
-		console.Debug(stderr.String())
-		console.Debug("Missing device driver, re-trying without GPU")
-		return GenerateOpenAPISchema(imageName, false)
-	}
+		Args:  openAPISchemaCommand(language),
+	}, enableGPU, &stdout, &stderr)
 
 	if err != nil {
 		console.Info(stdout.String())
@@ -55,6 +42,13 @@ func GenerateOpenAPISchema(imageName string, enableGPU bool) (map[string]any, er
 	return schema, nil
 }
 
+func openAPISchemaCommand(language string) []string {
+	if language == config.LanguageNode {
+		return []string{dockerfile.NodeTsxPath, dockerfile.NodeRuntimeDir + "/schema.mjs"}
+	}
+	return []string{"python", "-m", "cog.command.openapi_schema"}
+}
+
 func GetOpenAPISchema(imageName string) (*openapi3.T, error) {
 	image, err := docker.ImageInspect(imageName)
 	if err != nil {
@@ -0,0 +1,319 @@
+package image
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/docker/command"
+	"github.com/replicate/cog/pkg/dockercontext"
+	"github.com/replicate/cog/pkg/util/console"
+	"github.com/replicate/cog/pkg/weights"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	opaqueWhiteout = ".wh..wh..opq"
+)
+
+// layoutPartition is one of the layers that OptimizeLayout puts the files above the base image in
+type layoutPartition int
+
+const (
+	partitionEnvironment layoutPartition = iota
+	partitionWeights
+	partitionCode
+)
+
+var partitionNames = []string{"environment", "weights", "code"}
+
+// OptimizeLayout restructures a model image in the local Docker daemon so everything above its cog base image is
+// in three layers: the environment the model runs in, its weights, and its code. Each of them only changes when
+// that part of the model changes, so pushing and pulling a new version only transfers what changed, and the
+// layers can be pulled in parallel. Weights are found in dir the same way as for `cog build --separate-weights`,
+// and go below the code because they usually change less often.
+func OptimizeLayout(imageName string, dir string) error {
+	weightPaths, err := findWeightPaths(dir)
+	if err != nil {
+		return fmt.Errorf("Failed to find weights: %w", err)
+	}
+	tag, err := name.NewTag(imageName)
+	if err != nil {
+		return fmt.Errorf("Failed to parse image name %s: %w", imageName, err)
+	}
+
+	tmpDir, err := dockercontext.MkdirTemp("cog-layout-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	console.Info("Optimizing image layout...")
+	archive := filepath.Join(tmpDir, "image.tar")
+	if err := docker.SaveImage(imageName, archive); err != nil {
+		return fmt.Errorf("Failed to export %s: %w", imageName, err)
+	}
+	img, err := tarball.ImageFromPath(archive, &tag)
+	if err != nil {
+		return err
+	}
+	optimized, err := optimizeLayout(img, weightPaths, tmpDir)
+	if err != nil {
+		return fmt.Errorf("Failed to optimize the layout of %s: %w", imageName, err)
+	}
+	optimizedArchive := filepath.Join(tmpDir, "optimized.tar")
+	if err := tarball.WriteToFile(optimizedArchive, tag, optimized); err != nil {
+		return err
+	}
+	if err := docker.LoadImage(optimizedArchive); err != nil {
+		return fmt.Errorf("Failed to load optimized image: %w", err)
+	}
+	return nil
+}
+
+// findWeightPaths returns the paths of the weights in dir, as they're named in the image's layers
+func findWeightPaths(dir string) ([]string, error) {
+	walker := func(root string, walkFn filepath.WalkFunc) error {
+		return filepath.Walk(filepath.Join(dir, root), func(p string, info os.FileInfo, err error) error {
+			rel, relErr := filepath.Rel(dir, p)
+			if relErr != nil {
+				return relErr
+			}
+			return walkFn(rel, info, err)
+		})
+	}
+	dirs, rootFiles, err := weights.FindWeights(walker)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for _, p := range append(dirs, rootFiles...) {
+		paths = append(paths, path.Join("src", filepath.ToSlash(p)))
+	}
+	return paths, nil
+}
+
+func optimizeLayout(img v1.Image, weightPaths []string, tmpDir string) (v1.Image, error) {
+	configFile, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	lastBaseLayerLabel := configFile.Config.Labels[command.CogBaseImageLastLayerIndexLabelKey]
+	if lastBaseLayerLabel == "" {
+		return nil, errors.New("It wasn't built on a cog base image, so the base image layers can't be told apart from the model's")
+	}
+	lastBaseLayer, err := strconv.Atoi(lastBaseLayerLabel)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s label: %w", command.CogBaseImageLastLayerIndexLabelKey, err)
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+	if lastBaseLayer < 0 || lastBaseLayer >= len(layers) {
+		return nil, fmt.Errorf("Its base image ends at layer %d, but it only has %d layers", lastBaseLayer, len(layers))
+	}
+	baseLayers, modelLayers := layers[:lastBaseLayer+1], layers[lastBaseLayer+1:]
+
+	winners, err := squashedFiles(modelLayers)
+	if err != nil {
+		return nil, err
+	}
+	partitionPaths := []string{}
+	for _, partition := range partitionNames {
+		partitionPaths = append(partitionPaths, filepath.Join(tmpDir, partition+".tar"))
+	}
+	counts, err := writePartitions(modelLayers, winners, weightPaths, partitionPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	optimizedConfig := configFile.DeepCopy()
+	optimizedConfig.History = nil
+	optimizedConfig.RootFS.DiffIDs = nil
+	optimized, err := mutate.ConfigFile(empty.Image, optimizedConfig)
+	if err != nil {
+		return nil, err
+	}
+	adds := addendums(configFile.History, baseLayers, 0)
+	for i, partitionPath := range partitionPaths {
+		if counts[i] == 0 {
+			continue
+		}
+		layer, err := tarball.LayerFromFile(partitionPath)
+		if err != nil {
+			return nil, err
+		}
+		adds = append(adds, mutate.Addendum{
+			Layer: layer,
+			History: v1.History{
+				Created:   configFile.Created,
+				CreatedBy: "cog: " + partitionNames[i],
+				Comment:   "Created by cog push --optimize-layout",
+			},
+		})
+	}
+	return mutate.Append(optimized, adds...)
+}
+
+// squashedFiles works out which layer each file in the squashed layers comes from. Files that are overwritten or
+// deleted by a higher layer are left out. Whiteouts that delete files in lower layers are kept, because they
+// might delete files in the base image.
+func squashedFiles(layers []v1.Layer) (map[string]int, error) {
+	winners := map[string]int{}
+	deleted := map[string]bool{}
+	opaque := map[string]bool{}
+	for i := len(layers) - 1; i >= 0; i-- {
+		// Whiteouts only apply to lower layers
+		layerDeleted := map[string]bool{}
+		layerOpaque := map[string]bool{}
+		err := walkLayer(layers[i], func(header *tar.Header, _ io.Reader) error {
+			p := layerPath(header.Name)
+			if _, ok := winners[p]; ok || isDeleted(p, deleted, opaque) {
+				return nil
+			}
+			dir, base := path.Split(p)
+			switch {
+			case base == opaqueWhiteout:
+				layerOpaque[path.Clean(dir)] = true
+			case strings.HasPrefix(base, whiteoutPrefix):
+				target := path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))
+				if _, ok := winners[target]; ok {
+					// A higher layer created the file again
+					return nil
+				}
+				layerDeleted[target] = true
+			}
+			winners[p] = i
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for p := range layerDeleted {
+			deleted[p] = true
+		}
+		for p := range layerOpaque {
+			opaque[p] = true
+		}
+	}
+	return winners, nil
+}
+
+func isDeleted(p string, deleted map[string]bool, opaque map[string]bool) bool {
+	if deleted[p] {
+		return true
+	}
+	for dir := path.Dir(p); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if deleted[dir] || opaque[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// writePartitions writes the files in the squashed layers to a tarball for each partition, from the lowest layer
+// up so hard links come after the files they link to. It returns how many files are in each partition.
+func writePartitions(layers []v1.Layer, winners map[string]int, weightPaths []string, partitionPaths []string) (counts []int, err error) {
+	writers := []*tar.Writer{}
+	for _, partitionPath := range partitionPaths {
+		f, err := os.Create(partitionPath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		tw := tar.NewWriter(f)
+		defer func() {
+			if closeErr := tw.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		writers = append(writers, tw)
+	}
+
+	counts = make([]int, len(partitionPaths))
+	written := map[string]bool{}
+	for i, layer := range layers {
+		err := walkLayer(layer, func(header *tar.Header, contents io.Reader) error {
+			p := layerPath(header.Name)
+			if winner, ok := winners[p]; !ok || winner != i || written[p] {
+				return nil
+			}
+			written[p] = true
+			partition := classifyPath(p, weightPaths)
+			switch {
+			case strings.HasPrefix(path.Base(p), whiteoutPrefix):
+				// Whiteouts left after squashing delete files in the base image. They go in the lowest layer so
+				// they can't hide files in the other layers, e.g. an opaque /src hiding the weights.
+				partition = partitionEnvironment
+			case header.Typeflag == tar.TypeLink:
+				// Hard links have to be in the same layer as the file they link to
+				partition = classifyPath(layerPath(header.Linkname), weightPaths)
+			}
+			if err := writers[partition].WriteHeader(header); err != nil {
+				return err
+			}
+			if _, err := io.Copy(writers[partition], contents); err != nil {
+				return err
+			}
+			counts[partition]++
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return counts, nil
+}
+
+// classifyPath returns which partition a file in the image goes in
+func classifyPath(p string, weightPaths []string) layoutPartition {
+	for _, weightPath := range weightPaths {
+		if p == weightPath || strings.HasPrefix(p, weightPath+"/") {
+			return partitionWeights
+		}
+	}
+	if p == "src" || strings.HasPrefix(p, "src/") {
+		return partitionCode
+	}
+	return partitionEnvironment
+}
+
+func walkLayer(layer v1.Layer, fn func(header *tar.Header, contents io.Reader) error) error {
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	tr := tar.NewReader(rc)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(header, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// layerPath normalizes a path in a layer tarball, which may or may not start with / or ./
+func layerPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
@@ -0,0 +1,83 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/docker/command"
+)
+
+func tarLayer(t *testing.T, files map[string]string) v1.Layer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, contents := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(contents)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(contents))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	require.NoError(t, err)
+	return layer
+}
+
+func layerFiles(t *testing.T, layer v1.Layer) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	require.NoError(t, walkLayer(layer, func(header *tar.Header, contents io.Reader) error {
+		b, err := io.ReadAll(contents)
+		files[header.Name] = string(b)
+		return err
+	}))
+	return files
+}
+
+func TestOptimizeLayout(t *testing.T) {
+	base, err := random.Image(1024, 2)
+	require.NoError(t, err)
+	baseIDs := diffIDs(t, base)
+	img, err := mutate.AppendLayers(base,
+		tarLayer(t, map[string]string{"usr/lib/python3/torch.py": "torch", "tmp/cache": "cache"}),
+		tarLayer(t, map[string]string{"src/predict.py": "v1", "src/weights/model.bin": "weights"}),
+		tarLayer(t, map[string]string{"src/predict.py": "v2", "tmp/.wh.cache": "", "etc/.wh.motd": ""}),
+	)
+	require.NoError(t, err)
+	img = withLabels(t, img, map[string]string{
+		command.CogBaseImageLastLayerIndexLabelKey: "1",
+	})
+
+	optimized, err := optimizeLayout(img, []string{"src/weights"}, t.TempDir())
+	require.NoError(t, err)
+
+	layers, err := optimized.Layers()
+	require.NoError(t, err)
+	require.Len(t, layers, 5)
+	require.Equal(t, baseIDs, diffIDs(t, optimized)[:2])
+	require.Equal(t, map[string]string{"usr/lib/python3/torch.py": "torch", "tmp/.wh.cache": "", "etc/.wh.motd": ""}, layerFiles(t, layers[2]))
+	require.Equal(t, map[string]string{"src/weights/model.bin": "weights"}, layerFiles(t, layers[3]))
+	require.Equal(t, map[string]string{"src/predict.py": "v2"}, layerFiles(t, layers[4]))
+
+	configFile, err := optimized.ConfigFile()
+	require.NoError(t, err)
+	require.Equal(t, "1", configFile.Config.Labels[command.CogBaseImageLastLayerIndexLabelKey])
+	require.Len(t, configFile.History, 5)
+	require.Equal(t, "cog: code", configFile.History[4].CreatedBy)
+}
+
+func TestOptimizeLayoutRequiresCogBaseImage(t *testing.T) {
+	img, err := random.Image(1024, 3)
+	require.NoError(t, err)
+	_, err = optimizeLayout(img, nil, t.TempDir())
+	require.ErrorContains(t, err, "cog base image")
+}
@@ -21,11 +21,11 @@ func GeneratePipFreeze(imageName string, fastFlag bool) (string, error) {
 		args = []string{"uv", "pip", "freeze"}
 		env = []string{"VIRTUAL_ENV=/root/.venv"}
 	}
-	err := docker.RunWithIO(docker.RunOptions{
+	err := runIntrospection(docker.RunOptions{
 		Image: imageName,
 		Args:  args,
 		Env:   env,
-	}, nil, &stdout, &stderr)
+	}, false, &stdout, &stderr)
 
 	if err != nil {
 		console.Info(stdout.String())
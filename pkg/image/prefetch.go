@@ -0,0 +1,64 @@
+package image
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vbauerster/mpb/v8"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/dockerfile"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// Prefetch pulls the images that building the model would pull, in parallel, so they're already there when
+// it's built. If separateWeights is set, the weights image for imageName is pulled too, if it's been pushed.
+func Prefetch(cfg *config.Config, dir string, imageName string, separateWeights bool, useCudaBaseImage string, useCogBaseImage *bool) error {
+	resolution, err := ResolveBaseImages(cfg, dir, useCudaBaseImage, useCogBaseImage)
+	if err != nil {
+		return err
+	}
+
+	var group errgroup.Group
+	p := mpb.New(
+		mpb.WithRefreshRate(180 * time.Millisecond),
+	)
+	group.Go(func() error {
+		return docker.PullWithProgress(resolution.BaseImage, docker.BuildPlatform(), p)
+	})
+	if separateWeights && imageName != "" {
+		weightsImage := imageName + "-weights"
+		group.Go(func() error {
+			// The weights image won't exist until the model has been pushed with --separate-weights, and it's
+			// rebuilt if it's not there, so it's fine if it can't be pulled
+			if err := docker.PullWithProgress(weightsImage, docker.BuildPlatform(), p); err != nil {
+				console.Debugf("%s", err)
+				_, _ = fmt.Fprintf(p, "Skipping %s, because it couldn't be pulled\n", weightsImage)
+			}
+			return nil
+		})
+	}
+	err = group.Wait()
+	p.Wait()
+	return err
+}
+
+// ResolveBaseImages works out which base images a build with these options would use
+func ResolveBaseImages(cfg *config.Config, dir string, useCudaBaseImage string, useCogBaseImage *bool) (*dockerfile.BaseImageResolution, error) {
+	generator, err := dockerfile.NewGenerator(cfg, dir, false, docker.NewDockerCommand(), false)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating Dockerfile generator: %w", err)
+	}
+	defer func() {
+		if err := generator.Cleanup(); err != nil {
+			console.Warnf("Error cleaning up Dockerfile generator: %s", err)
+		}
+	}()
+	generator.SetUseCudaBaseImage(useCudaBaseImage)
+	if useCogBaseImage != nil {
+		generator.SetUseCogBaseImage(*useCogBaseImage)
+	}
+	return dockerfile.ResolveBaseImages(generator)
+}
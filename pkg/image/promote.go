@@ -0,0 +1,172 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// cosignSuffixes are the tags cosign attaches artifacts to an image with, as sha256-<digest>.<suffix> in the
+// image's repository
+var cosignSuffixes = []string{"sig", "att", "sbom"}
+
+// Promotion copies a model image from one registry to another by digest, with the artifacts attached to it
+type Promotion struct {
+	// Source is the image being promoted, pinned to the digest it had when the promotion started
+	Source name.Digest
+	Target name.Reference
+	Labels map[string]string
+	// Size is the compressed size of the image's layers and config, in bytes
+	Size int64
+
+	sourceRef name.Reference
+	desc      *remote.Descriptor
+	options   []remote.Option
+}
+
+// NewPromotion resolves source in its registry and reads its labels, so policies can be checked before it's copied
+// to target
+func NewPromotion(source string, target string) (*Promotion, error) {
+	options := []remote.Option{remote.WithAuthFromKeychain(authn.DefaultKeychain)}
+	sourceRef, err := name.ParseReference(source)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse image name %s: %w", source, err)
+	}
+	targetRef, err := name.ParseReference(target)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse image name %s: %w", target, err)
+	}
+
+	console.Infof("Fetching %s...", source)
+	desc, err := remote.Get(sourceRef, options...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch %s: %w", source, err)
+	}
+	p := &Promotion{
+		Source:    sourceRef.Context().Digest(desc.Digest.String()),
+		Target:    targetRef,
+		sourceRef: sourceRef,
+		desc:      desc,
+		options:   options,
+	}
+
+	if desc.MediaType.IsImage() {
+		img, err := desc.Image()
+		if err != nil {
+			return nil, err
+		}
+		configFile, err := img.ConfigFile()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read config of %s: %w", source, err)
+		}
+		p.Labels = configFile.Config.Labels
+		manifest, err := img.Manifest()
+		if err != nil {
+			return nil, err
+		}
+		p.Size = manifest.Config.Size
+		for _, layer := range manifest.Layers {
+			p.Size += layer.Size
+		}
+	}
+	return p, nil
+}
+
+// Verify checks the signature of the source image with each of methods, which are the same as for --verify-base
+func (p *Promotion) Verify(methods []string) error {
+	for _, method := range methods {
+		console.Infof("Verifying the signature of %s with %s...", p.Source, method)
+		var err error
+		switch method {
+		case VerifyWithCosign:
+			_, err = verifyCosign(p.Source.String())
+		case VerifyWithContentTrust:
+			tag, ok := p.sourceRef.(name.Tag)
+			if !ok {
+				return fmt.Errorf("Docker Content Trust signs tags, so it can't verify images that are promoted by digest")
+			}
+			_, err = verifyContentTrust(tag.String() + "@" + p.Source.DigestStr())
+		default:
+			return fmt.Errorf("Invalid verification %q, it must be %q or %q", method, VerifyWithCosign, VerifyWithContentTrust)
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to verify %s with %s: %w", p.Source, method, err)
+		}
+	}
+	return nil
+}
+
+// Copy copies the image to the target registry, then the signatures, attestations and SBOMs attached to it with
+// cosign or the OCI referrers API. It returns the artifacts that were copied.
+func (p *Promotion) Copy() ([]string, error) {
+	console.Infof("Copying %s to %s...", p.Source, p.Target)
+	if err := copyDescriptor(p.desc, p.Target, p.options); err != nil {
+		return nil, fmt.Errorf("Failed to push %s: %w", p.Target, err)
+	}
+
+	sourceRepo := p.Source.Context()
+	targetRepo := p.Target.Context()
+	copied := []string{}
+
+	for _, suffix := range cosignSuffixes {
+		tag := strings.Replace(p.Source.DigestStr(), ":", "-", 1) + "." + suffix
+		desc, err := remote.Get(sourceRepo.Tag(tag), p.options...)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return copied, fmt.Errorf("Failed to fetch %s: %w", sourceRepo.Tag(tag), err)
+		}
+		if err := copyDescriptor(desc, targetRepo.Tag(tag), p.options); err != nil {
+			return copied, fmt.Errorf("Failed to copy %s: %w", sourceRepo.Tag(tag), err)
+		}
+		copied = append(copied, tag)
+	}
+
+	referrers, err := remote.Referrers(p.Source, p.options...)
+	if err != nil {
+		return copied, fmt.Errorf("Failed to list artifacts attached to %s: %w", p.Source, err)
+	}
+	manifest, err := referrers.IndexManifest()
+	if err != nil {
+		return copied, err
+	}
+	for _, referrer := range manifest.Manifests {
+		desc, err := remote.Get(sourceRepo.Digest(referrer.Digest.String()), p.options...)
+		if err != nil {
+			return copied, fmt.Errorf("Failed to fetch artifact %s: %w", referrer.Digest, err)
+		}
+		if err := copyDescriptor(desc, targetRepo.Digest(referrer.Digest.String()), p.options); err != nil {
+			return copied, fmt.Errorf("Failed to copy artifact %s: %w", referrer.Digest, err)
+		}
+		copied = append(copied, fmt.Sprintf("%s (%s)", referrer.Digest, referrer.ArtifactType))
+	}
+	return copied, nil
+}
+
+// PromotionTarget returns where image is promoted to in registry, which keeps its repository path and tag or digest.
+// registry can include a path to put the repository under, like registry.example.com/prod.
+func PromotionTarget(imageName string, registry string) (string, error) {
+	ref, err := name.ParseReference(imageName)
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse image name %s: %w", imageName, err)
+	}
+	separator := ":"
+	if _, ok := ref.(name.Digest); ok {
+		separator = "@"
+	}
+	return strings.TrimSuffix(registry, "/") + "/" + ref.Context().RepositoryStr() + separator + ref.Identifier(), nil
+}
+
+func isNotFound(err error) bool {
+	var terr *transport.Error
+	return errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound
+}
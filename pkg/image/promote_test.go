@@ -0,0 +1,76 @@
+package image
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/docker/command"
+)
+
+func TestPromotion(t *testing.T) {
+	staging := httptest.NewServer(registry.New())
+	defer staging.Close()
+	prod := httptest.NewServer(registry.New())
+	defer prod.Close()
+	stagingHost := hostOf(t, staging.URL)
+	prodHost := hostOf(t, prod.URL)
+
+	img, err := random.Image(1024, 2)
+	require.NoError(t, err)
+	configFile, err := img.ConfigFile()
+	require.NoError(t, err)
+	configFile.Config.Labels = map[string]string{command.CogVersionLabelKey: "0.9.0"}
+	img, err = mutate.ConfigFile(img, configFile)
+	require.NoError(t, err)
+	digest, err := img.Digest()
+	require.NoError(t, err)
+
+	source := stagingHost + "/ml/my-model:v3"
+	require.NoError(t, remote.Write(mustTag(t, source), img))
+	signature, err := random.Image(128, 1)
+	require.NoError(t, err)
+	signatureTag := "sha256-" + digest.Hex + ".sig"
+	require.NoError(t, remote.Write(mustTag(t, stagingHost+"/ml/my-model:"+signatureTag), signature))
+
+	target, err := PromotionTarget(source, prodHost)
+	require.NoError(t, err)
+	require.Equal(t, prodHost+"/ml/my-model:v3", target)
+
+	promotion, err := NewPromotion(source, target)
+	require.NoError(t, err)
+	require.Equal(t, digest.String(), promotion.Source.DigestStr())
+	require.Equal(t, "0.9.0", promotion.Labels[command.CogVersionLabelKey])
+	require.Greater(t, promotion.Size, int64(2048))
+
+	copied, err := promotion.Copy()
+	require.NoError(t, err)
+	require.Equal(t, []string{signatureTag}, copied)
+
+	promoted, err := remote.Head(mustTag(t, target))
+	require.NoError(t, err)
+	require.Equal(t, digest, promoted.Digest)
+	_, err = remote.Head(mustTag(t, prodHost+"/ml/my-model:"+signatureTag))
+	require.NoError(t, err)
+}
+
+func hostOf(t *testing.T, rawURL string) string {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	require.NoError(t, err)
+	return u.Host
+}
+
+func mustTag(t *testing.T, s string) name.Tag {
+	t.Helper()
+	tag, err := name.NewTag(s)
+	require.NoError(t, err)
+	return tag
+}
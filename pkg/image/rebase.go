@@ -0,0 +1,158 @@
+package image
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"github.com/replicate/cog/pkg/docker/command"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// Rebase replaces the cog base image of a model image in a registry with newBase, and pushes the result as
+// target. The model's own layers are reused as they are, so nothing is rebuilt.
+func Rebase(imageName string, newBase string, target string) error {
+	options := []remote.Option{remote.WithAuthFromKeychain(authn.DefaultKeychain)}
+	origRef, err := name.ParseReference(imageName)
+	if err != nil {
+		return fmt.Errorf("Failed to parse image name %s: %w", imageName, err)
+	}
+	newBaseRef, err := name.ParseReference(newBase)
+	if err != nil {
+		return fmt.Errorf("Failed to parse base image name %s: %w", newBase, err)
+	}
+	targetRef, err := name.ParseReference(target)
+	if err != nil {
+		return fmt.Errorf("Failed to parse image name %s: %w", target, err)
+	}
+
+	console.Infof("Fetching %s and %s...", imageName, newBase)
+	orig, err := remote.Image(origRef, options...)
+	if err != nil {
+		return fmt.Errorf("Failed to fetch %s: %w", imageName, err)
+	}
+	newBaseImage, err := remote.Image(newBaseRef, options...)
+	if err != nil {
+		return fmt.Errorf("Failed to fetch %s: %w", newBase, err)
+	}
+
+	rebased, err := rebaseImage(orig, newBaseImage, newBase)
+	if err != nil {
+		return fmt.Errorf("Failed to rebase %s: %w", imageName, err)
+	}
+
+	console.Infof("Pushing rebased image to %s...", target)
+	if err := remote.Write(targetRef, rebased, options...); err != nil {
+		return fmt.Errorf("Failed to push %s: %w", target, err)
+	}
+	return nil
+}
+
+// rebaseImage replaces the cog base image layers of orig, which end at the layer its labels record, with the
+// layers of newBase. The base image labels are updated to describe newBase.
+func rebaseImage(orig v1.Image, newBase v1.Image, newBaseName string) (v1.Image, error) {
+	origConfig, err := orig.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	labels := origConfig.Config.Labels
+	if labels[command.CogBaseImageNameLabelKey] == "" || labels[command.CogBaseImageLastLayerIndexLabelKey] == "" {
+		return nil, fmt.Errorf("It wasn't built on a cog base image, so there's no base image to replace")
+	}
+	lastBaseLayer, err := strconv.Atoi(labels[command.CogBaseImageLastLayerIndexLabelKey])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s label: %w", command.CogBaseImageLastLayerIndexLabelKey, err)
+	}
+
+	origLayers, err := orig.Layers()
+	if err != nil {
+		return nil, err
+	}
+	if lastBaseLayer < 0 || lastBaseLayer >= len(origLayers) {
+		return nil, fmt.Errorf("Its base image ends at layer %d, but it only has %d layers", lastBaseLayer, len(origLayers))
+	}
+	diffID, err := origLayers[lastBaseLayer].DiffID()
+	if err != nil {
+		return nil, err
+	}
+	if diffID.String() != labels[command.CogBaseImageLastLayerSHALabelKey] {
+		return nil, fmt.Errorf("Layer %d is %s, but its labels say its base image ends with %s", lastBaseLayer, diffID, labels[command.CogBaseImageLastLayerSHALabelKey])
+	}
+
+	newBaseConfig, err := newBase.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	newBaseLayers, err := newBase.Layers()
+	if err != nil {
+		return nil, err
+	}
+	if len(newBaseLayers) == 0 {
+		return nil, fmt.Errorf("%s has no layers", newBaseName)
+	}
+	newLastLayer, err := newBaseLayers[len(newBaseLayers)-1].DiffID()
+	if err != nil {
+		return nil, err
+	}
+
+	config := *origConfig.Config.DeepCopy()
+	config.Labels[command.CogBaseImageNameLabelKey] = newBaseName
+	config.Labels[command.CogBaseImageLastLayerSHALabelKey] = newLastLayer.String()
+	config.Labels[command.CogBaseImageLastLayerIndexLabelKey] = strconv.Itoa(len(newBaseLayers) - 1)
+	rebased, err := mutate.Config(empty.Image, config)
+	if err != nil {
+		return nil, err
+	}
+	rebasedConfig, err := rebased.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	rebasedConfig.Architecture = newBaseConfig.Architecture
+	rebasedConfig.OS = newBaseConfig.OS
+	rebasedConfig.OSVersion = newBaseConfig.OSVersion
+	rebased, err = mutate.ConfigFile(rebased, rebasedConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	rebased, err = mutate.Append(rebased, addendums(newBaseConfig.History, newBaseLayers, 0)...)
+	if err != nil {
+		return nil, err
+	}
+	return mutate.Append(rebased, addendums(origConfig.History, origLayers, lastBaseLayer+1)...)
+}
+
+// addendums pairs layers with their history, starting from the history entry of layer start. History also has
+// entries for steps that don't create layers, like ENV, so it's walked separately from the layers.
+func addendums(history []v1.History, layers []v1.Layer, start int) []mutate.Addendum {
+	adds := []mutate.Addendum{}
+	layer := 0
+	for _, entry := range history {
+		if entry.EmptyLayer {
+			if layer >= start {
+				adds = append(adds, mutate.Addendum{History: entry})
+			}
+			continue
+		}
+		if layer >= len(layers) {
+			break
+		}
+		if layer >= start {
+			adds = append(adds, mutate.Addendum{Layer: layers[layer], History: entry})
+		}
+		layer++
+	}
+	// Images without history still have their layers
+	for ; layer < len(layers); layer++ {
+		if layer >= start {
+			adds = append(adds, mutate.Addendum{Layer: layers[layer]})
+		}
+	}
+	return adds
+}
@@ -0,0 +1,87 @@
+package image
+
+import (
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/docker/command"
+)
+
+func withLabels(t *testing.T, img v1.Image, labels map[string]string) v1.Image {
+	t.Helper()
+	configFile, err := img.ConfigFile()
+	require.NoError(t, err)
+	config := *configFile.Config.DeepCopy()
+	config.Labels = labels
+	img, err = mutate.Config(img, config)
+	require.NoError(t, err)
+	return img
+}
+
+func diffIDs(t *testing.T, img v1.Image) []string {
+	t.Helper()
+	layers, err := img.Layers()
+	require.NoError(t, err)
+	ids := []string{}
+	for _, layer := range layers {
+		id, err := layer.DiffID()
+		require.NoError(t, err)
+		ids = append(ids, id.String())
+	}
+	return ids
+}
+
+func TestRebaseImage(t *testing.T) {
+	orig, err := random.Image(1024, 5)
+	require.NoError(t, err)
+	origIDs := diffIDs(t, orig)
+	orig = withLabels(t, orig, map[string]string{
+		command.CogBaseImageNameLabelKey:           "r8.im/cog-base:python3.12",
+		command.CogBaseImageLastLayerSHALabelKey:   origIDs[2],
+		command.CogBaseImageLastLayerIndexLabelKey: "2",
+		command.CogVersionLabelKey:                 "0.14.0",
+	})
+	newBase, err := random.Image(1024, 4)
+	require.NoError(t, err)
+	newBaseIDs := diffIDs(t, newBase)
+
+	rebased, err := rebaseImage(orig, newBase, "r8.im/cog-base:python3.12-patched")
+	require.NoError(t, err)
+	require.Equal(t, append(newBaseIDs, origIDs[3:]...), diffIDs(t, rebased))
+
+	configFile, err := rebased.ConfigFile()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		command.CogBaseImageNameLabelKey:           "r8.im/cog-base:python3.12-patched",
+		command.CogBaseImageLastLayerSHALabelKey:   newBaseIDs[3],
+		command.CogBaseImageLastLayerIndexLabelKey: "3",
+		command.CogVersionLabelKey:                 "0.14.0",
+	}, configFile.Config.Labels)
+}
+
+func TestRebaseImageChecksLabels(t *testing.T) {
+	orig, err := random.Image(1024, 3)
+	require.NoError(t, err)
+	newBase, err := random.Image(1024, 2)
+	require.NoError(t, err)
+
+	_, err = rebaseImage(withLabels(t, orig, map[string]string{}), newBase, "r8.im/cog-base:python3.12")
+	require.ErrorContains(t, err, "wasn't built on a cog base image")
+
+	_, err = rebaseImage(withLabels(t, orig, map[string]string{
+		command.CogBaseImageNameLabelKey:           "r8.im/cog-base:python3.12",
+		command.CogBaseImageLastLayerSHALabelKey:   "sha256:0000",
+		command.CogBaseImageLastLayerIndexLabelKey: "1",
+	}), newBase, "r8.im/cog-base:python3.12")
+	require.ErrorContains(t, err, "its labels say its base image ends with sha256:0000")
+
+	_, err = rebaseImage(withLabels(t, orig, map[string]string{
+		command.CogBaseImageNameLabelKey:           "r8.im/cog-base:python3.12",
+		command.CogBaseImageLastLayerIndexLabelKey: "5",
+	}), newBase, "r8.im/cog-base:python3.12")
+	require.ErrorContains(t, err, "only has 3 layers")
+}
@@ -0,0 +1,173 @@
+package image
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/dockerfile"
+	"github.com/replicate/cog/pkg/util/console"
+	"github.com/replicate/cog/pkg/util/version"
+)
+
+// resolverImage is the image Python requirements are resolved in before a build, which has uv and the model's
+// version of Python, for building packages that only have source distributions
+const resolverImage = "ghcr.io/astral-sh/uv:python%s-bookworm-slim"
+
+// uvNoSolution starts uv's explanation of why requirements can't be installed together
+const uvNoSolution = "No solution found when resolving dependencies"
+
+// resolvedRequirementsFile records the hash of the requirements that last resolved, so they're only resolved again
+// when they change
+const resolvedRequirementsFile = "resolved_requirements"
+
+// checkRequirementsResolve resolves the model's Python requirements with uv before building, so requirements that
+// conflict fail in seconds, with the requirements that conflict, rather than minutes into the build. uv uses the
+// package indexes in cog.yaml, and the credentials in the pip-netrc build secret, if it's one of secrets, like pip
+// does in the build. If uv can't be run, or can't fetch packages, like when there's no network or the credentials are
+// wrong, the build carries on and pip finds any conflicts.
+func checkRequirementsResolve(cfg *config.Config, dir string, secrets []string) error {
+	if config.BuildSkipResolve || cfg.Build.PythonVersion == "" {
+		return nil
+	}
+	requirements, err := cfg.PythonRequirementsForArch("linux", "amd64", nil)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(requirements) == "" {
+		return nil
+	}
+
+	pythonVersion := version.StripPatch(cfg.Build.PythonVersion)
+	args := resolverArgs(cfg, pythonVersion)
+
+	hash := sha256.Sum256([]byte(strings.Join(append(args, requirements), "\n")))
+	resolvedPath := filepath.Join(dir, config.CacheDir(dir), resolvedRequirementsFile)
+	if resolved, err := os.ReadFile(resolvedPath); err == nil && strings.TrimSpace(string(resolved)) == hex.EncodeToString(hash[:]) {
+		console.Debug("Python requirements haven't changed since they were last resolved")
+		return nil
+	}
+
+	console.Info("Checking the Python requirements can be installed together...")
+	runOptions := docker.RunOptions{
+		Image: fmt.Sprintf(resolverImage, pythonVersion),
+		Args:  args,
+		// pip picks the best match across all indexes, like the PyTorch index and PyPI, rather than the first that
+		// has a package
+		Env: []string{"UV_INDEX_STRATEGY=unsafe-best-match"},
+	}
+	netrcPath, cleanup, err := pipNetrcFile(secrets)
+	if err != nil {
+		console.Warnf("Failed to read the %s build secret, so private package indexes may not be found when checking the Python requirements: %s", dockerfile.PipNetrcSecretID, err)
+	}
+	defer cleanup()
+	if netrcPath != "" {
+		runOptions.Volumes = append(runOptions.Volumes, docker.Volume{Source: netrcPath, Destination: "/root/.netrc", ReadOnly: true})
+	}
+	var stdout, stderr bytes.Buffer
+	err = docker.RunWithIO(runOptions, strings.NewReader(requirements+"\n"), &stdout, &stderr)
+	if err != nil {
+		if conflict, ok := resolverConflict(stderr.String()); ok {
+			return fmt.Errorf("The Python requirements can't be installed together:\n\n%s\n\nChange the versions in cog.yaml or its requirements file so they're compatible, or build with --no-resolve to skip this check.", conflict)
+		}
+		console.Warnf("Failed to check the Python requirements can be installed together, so any conflicts will be found by pip during the build: %s", err)
+		console.Debug(stderr.String())
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(resolvedPath), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(resolvedPath, []byte(hex.EncodeToString(hash[:])+"\n"), 0o644)
+}
+
+// resolverArgs returns the command that resolves requirements from stdin for pythonVersion, with the package indexes
+// in cog.yaml
+func resolverArgs(cfg *config.Config, pythonVersion string) []string {
+	args := []string{"uv", "pip", "compile", "-", "--quiet", "--no-header", "--python-version", pythonVersion, "--python-platform", "linux"}
+	if cfg.Build.PipIndexURL != "" {
+		args = append(args, "--index-url", cfg.Build.PipIndexURL)
+	}
+	for _, url := range cfg.Build.PipExtraIndexURLs {
+		args = append(args, "--extra-index-url", url)
+	}
+	for _, host := range cfg.Build.PipTrustedHosts {
+		args = append(args, "--allow-insecure-host", host)
+	}
+	return args
+}
+
+// pipNetrcFile returns the path of the netrc file in the pip-netrc build secret, if it's one of secrets, which are
+// the --secret options of `cog build`, like id=pip-netrc,src=$HOME/.netrc. A secret from an environment variable is
+// written to a temporary file, which cleanup deletes.
+func pipNetrcFile(secrets []string) (path string, cleanup func(), err error) {
+	cleanup = func() {}
+	for _, secret := range secrets {
+		fields := map[string]string{}
+		for _, field := range strings.Split(secret, ",") {
+			key, value, _ := strings.Cut(field, "=")
+			fields[key] = value
+		}
+		if fields["id"] != dockerfile.PipNetrcSecretID {
+			continue
+		}
+		if src := fields["src"] + fields["source"]; src != "" {
+			if path, err = filepath.Abs(src); err != nil {
+				return "", cleanup, err
+			}
+			return path, cleanup, nil
+		}
+		env := fields["env"]
+		if env == "" {
+			// Like docker build, a secret without a source is read from the environment variable named after it
+			env = fields["id"]
+		}
+		contents, ok := os.LookupEnv(env)
+		if !ok {
+			return "", cleanup, fmt.Errorf("%s isn't set", env)
+		}
+		f, err := os.CreateTemp("", "cog-netrc-")
+		if err != nil {
+			return "", cleanup, err
+		}
+		cleanup = func() { os.Remove(f.Name()) }
+		if _, err := f.WriteString(contents); err != nil {
+			f.Close()
+			return "", cleanup, err
+		}
+		return f.Name(), cleanup, f.Close()
+	}
+	return "", cleanup, nil
+}
+
+// uvFetchFailures are in uv's output when it didn't find packages because it couldn't fetch them, like when there's
+// no network or the index rejects the credentials, so it says there's no solution when there might be
+var uvFetchFailures = []string{
+	"not found in the package registry",
+	"not found in the provided package locations",
+	"Failed to fetch",
+	"error sending request",
+	"401 Unauthorized",
+	"403 Forbidden",
+}
+
+// resolverConflict returns uv's explanation of which requirements conflict from its output, and false if it failed
+// for some other reason, including failing to fetch packages
+func resolverConflict(output string) (string, bool) {
+	i := strings.Index(output, uvNoSolution)
+	if i < 0 {
+		return "", false
+	}
+	for _, failure := range uvFetchFailures {
+		if strings.Contains(output, failure) {
+			return "", false
+		}
+	}
+	return strings.TrimSpace(output[i:]), true
+}
@@ -0,0 +1,68 @@
+package image
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/config"
+)
+
+func TestResolverArgs(t *testing.T) {
+	cfg := &config.Config{Build: &config.Build{
+		PipIndexURL:       "https://pypi.example.com/simple",
+		PipExtraIndexURLs: []string{"https://download.pytorch.org/whl/cu121"},
+		PipTrustedHosts:   []string{"pypi.example.com"},
+	}}
+	require.Equal(t, []string{
+		"uv", "pip", "compile", "-", "--quiet", "--no-header", "--python-version", "3.11", "--python-platform", "linux",
+		"--index-url", "https://pypi.example.com/simple",
+		"--extra-index-url", "https://download.pytorch.org/whl/cu121",
+		"--allow-insecure-host", "pypi.example.com",
+	}, resolverArgs(cfg, "3.11"))
+}
+
+func TestPipNetrcFile(t *testing.T) {
+	path, cleanup, err := pipNetrcFile([]string{"id=other,src=/tmp/other", "id=pip-netrc,src=/home/me/.netrc"})
+	require.NoError(t, err)
+	defer cleanup()
+	require.Equal(t, "/home/me/.netrc", path)
+
+	t.Setenv("NETRC_CONTENTS", "machine pypi.example.com login me password secret\n")
+	path, cleanup, err = pipNetrcFile([]string{"id=pip-netrc,env=NETRC_CONTENTS"})
+	require.NoError(t, err)
+	contents, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "machine pypi.example.com login me password secret\n", string(contents))
+	cleanup()
+	require.NoFileExists(t, path)
+
+	path, cleanup, err = pipNetrcFile(nil)
+	require.NoError(t, err)
+	defer cleanup()
+	require.Empty(t, path)
+}
+
+func TestResolverConflict(t *testing.T) {
+	output := `Unable to find image 'ghcr.io/astral-sh/uv:python3.11-bookworm-slim' locally
+  × No solution found when resolving dependencies:
+  ╰─▶ Because torch==2.1.0 depends on triton==2.1.0 and you require triton==3.0.0, we can conclude that your
+      requirements are unsatisfiable.
+`
+	conflict, ok := resolverConflict(output)
+	require.True(t, ok)
+	require.Equal(t, `No solution found when resolving dependencies:
+  ╰─▶ Because torch==2.1.0 depends on triton==2.1.0 and you require triton==3.0.0, we can conclude that your
+      requirements are unsatisfiable.`, conflict)
+
+	_, ok = resolverConflict("error: Failed to fetch: `https://pypi.org/simple/torch/`")
+	require.False(t, ok)
+
+	// Packages uv couldn't fetch, like from an index without credentials, aren't conflicts
+	_, ok = resolverConflict(`  × No solution found when resolving dependencies:
+  ╰─▶ Because private-package was not found in the package registry and you require private-package, we can
+      conclude that your requirements are unsatisfiable.
+`)
+	require.False(t, ok)
+}
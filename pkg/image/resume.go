@@ -0,0 +1,239 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/replicate/cog/pkg/buildcache"
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/dockerignore"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+const buildStateFile = "build_state.json"
+
+// The stages of a build that are recorded as they complete, so a build that failed can be resumed with --resume
+const (
+	buildStageWeights   = "weights image"
+	buildStageImage     = "image"
+	buildStageBake      = "bake"
+	buildStageSchema    = "schema"
+	buildStagePipFreeze = "pip freeze"
+)
+
+// imageID returns the ID of an image, and is replaced in tests
+var imageID = func(imageName string) (string, error) {
+	image, err := docker.ImageInspect(imageName)
+	if err != nil {
+		return "", err
+	}
+	return image.ID, nil
+}
+
+// completedStage is a stage of a build that completed
+type completedStage struct {
+	Name string `json:"name"`
+	// Image is the image the stage built or changed, and ImageID is its ID afterwards
+	Image   string `json:"image,omitempty"`
+	ImageID string `json:"image_id,omitempty"`
+	// Output is what the stage generated, like the schema, so it isn't generated again
+	Output string `json:"output,omitempty"`
+}
+
+// buildState records which stages of a build have completed, so if it fails, `cog build --resume` can skip them
+// rather than relying on Docker's cache, which is often partially invalidated, like after the builder is pruned
+type buildState struct {
+	// Inputs is the digest of everything the build depends on, so a build is only resumed if nothing changed
+	Inputs    string           `json:"inputs"`
+	Completed []completedStage `json:"completed"`
+
+	path string
+	// fingerprint returns Inputs, which is left empty until the build fails if it doesn't need to be resumed
+	fingerprint func() (string, error)
+	// resumable are the stages that can be skipped. Once a stage is run, the stages after it are run too.
+	resumable []completedStage
+}
+
+// newBuildState starts recording the stages of a build of the project in dir. If resume is set and the last build
+// of the project failed after completing some stages, with the same inputs, those stages can be skipped. files are
+// the build context from dockerignore.WalkContext, stages are its build cache stages if they've already been
+// computed, or nil, and settings are anything else the build depends on, like its options.
+//
+// Fingerprinting the build hashes the source files, so unless it's being resumed, or the last build left a record
+// that has to be replaced, it's only done if the build fails.
+func newBuildState(cfg *config.Config, dir string, files []dockerignore.ContextFile, stages []buildcache.Stage, resume bool, settings ...string) *buildState {
+	state := &buildState{path: filepath.Join(dir, config.CacheDir(dir), buildStateFile)}
+	state.fingerprint = func() (string, error) {
+		return buildInputs(cfg, dir, files, stages, settings...)
+	}
+	if _, err := os.Stat(state.path); err != nil && !resume {
+		return state
+	}
+	inputs, err := state.fingerprint()
+	if err != nil {
+		console.Debugf("Failed to fingerprint the build, so it can't be resumed: %s", err)
+		return nil
+	}
+	state.Inputs = inputs
+	if !resume {
+		state.save()
+		return state
+	}
+
+	previous := &buildState{}
+	data, err := os.ReadFile(state.path)
+	if err == nil {
+		err = json.Unmarshal(data, previous)
+	}
+	switch {
+	case err != nil || len(previous.Completed) == 0:
+		console.Info("There's no failed build to resume, so building from the start...")
+	case previous.Inputs != inputs:
+		console.Info("The project has changed since the build failed, so building from the start...")
+	default:
+		state.resumable = resumableStages(previous.Completed)
+		names := []string{}
+		for _, stage := range state.resumable {
+			names = append(names, stage.Name)
+		}
+		if len(names) == 0 {
+			console.Info("The images from the build that failed have changed, so building from the start...")
+		} else {
+			console.Infof("Resuming the build that failed, skipping the %s stages...", strings.Join(names, ", "))
+		}
+	}
+	state.save()
+	return state
+}
+
+// resumableStages returns the completed stages up to the first one whose image has changed since, like if it was
+// removed or rebuilt by another build
+func resumableStages(completed []completedStage) []completedStage {
+	// Later stages change the images of earlier ones, like bake does, so compare with the last ID of each image
+	lastIDs := map[string]string{}
+	for _, stage := range completed {
+		if stage.Image != "" {
+			lastIDs[stage.Image] = stage.ImageID
+		}
+	}
+	for i, stage := range completed {
+		if stage.Image == "" {
+			continue
+		}
+		if id, err := imageID(stage.Image); err != nil || id != lastIDs[stage.Image] {
+			return completed[:i]
+		}
+	}
+	return completed
+}
+
+// skip returns whether a stage was completed by the build that's being resumed, and the output it recorded
+func (s *buildState) skip(name string) (bool, string) {
+	if s == nil {
+		return false, ""
+	}
+	for i, stage := range s.resumable {
+		if stage.Name != name {
+			continue
+		}
+		// Stages recorded before this one that this build didn't need, like a weights image that's cached anyway,
+		// are passed over
+		s.resumable = s.resumable[i+1:]
+		s.Completed = append(s.Completed, stage)
+		s.save()
+		return true, stage.Output
+	}
+	s.resumable = nil
+	return false, ""
+}
+
+// complete records that a stage completed, with the image it built or changed, if there is one, and its output
+func (s *buildState) complete(name string, imageName string, output string) {
+	if s == nil {
+		return
+	}
+	stage := completedStage{Name: name, Image: imageName, Output: output}
+	if imageName != "" {
+		id, err := imageID(imageName)
+		if err != nil {
+			console.Debugf("Failed to get the ID of %s, so the build can't be resumed from after %s: %s", imageName, name, err)
+			return
+		}
+		stage.ImageID = id
+	}
+	s.Completed = append(s.Completed, stage)
+	s.save()
+}
+
+// finish removes the record of the build, because it succeeded so there's nothing to resume
+func (s *buildState) finish() {
+	if s == nil {
+		return
+	}
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		console.Debugf("Failed to remove %s: %s", s.path, err)
+	}
+}
+
+// fail records the stages that completed before the build failed, so it can be resumed
+func (s *buildState) fail() {
+	if s == nil {
+		return
+	}
+	if s.Inputs == "" {
+		inputs, err := s.fingerprint()
+		if err != nil {
+			console.Debugf("Failed to fingerprint the build, so it can't be resumed: %s", err)
+			return
+		}
+		s.Inputs = inputs
+	}
+	s.save()
+}
+
+// save writes the record of the build, once it's been fingerprinted
+func (s *buildState) save() {
+	if s.Inputs == "" {
+		return
+	}
+	data, err := json.Marshal(s)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(s.path), 0o755)
+	}
+	if err == nil {
+		err = os.WriteFile(s.path, data, 0o644)
+	}
+	if err != nil {
+		console.Debugf("Failed to record the build's progress in %s: %s", s.path, err)
+	}
+}
+
+// buildInputs returns a digest of everything a build depends on: cog.yaml, the source files, and settings. The
+// source files are hashed for the build cache stages, unless they're given.
+func buildInputs(cfg *config.Config, dir string, files []dockerignore.ContextFile, stages []buildcache.Stage, settings ...string) (string, error) {
+	if stages == nil {
+		var err error
+		if stages, err = buildcache.StagesForContext(cfg, dir, files); err != nil {
+			return "", err
+		}
+	}
+	configJSON, err := json.Marshal(cfg)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	for _, stage := range stages {
+		fmt.Fprintf(h, "%s\x00%s\x00", stage.Name, stage.Digest)
+	}
+	fmt.Fprintf(h, "%s\x00", configJSON)
+	for _, setting := range settings {
+		fmt.Fprintf(h, "%s\x00", setting)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,88 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/dockerignore"
+)
+
+func TestBuildStateResume(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "predict.py"), []byte("print(1)"), 0o644))
+	cfg, err := config.FromYAML([]byte("build:\n  python_version: \"3.12\"\npredict: predict.py:Predictor\n"))
+	require.NoError(t, err)
+	require.NoError(t, cfg.ValidateAndComplete(""))
+	files, err := dockerignore.WalkContext(dir)
+	require.NoError(t, err)
+
+	ids := map[string]string{}
+	imageID = func(imageName string) (string, error) { return ids[imageName], nil }
+	t.Cleanup(func() {
+		imageID = func(imageName string) (string, error) { return "", nil }
+	})
+
+	// A build that fails after building the images and generating the schema
+	state := newBuildState(cfg, dir, files, nil, false, "my-model")
+	ids["my-model-weights"] = "sha256:weights"
+	state.complete(buildStageWeights, "my-model-weights", "")
+	ids["my-model"] = "sha256:runner"
+	state.complete(buildStageImage, "my-model", "")
+	ids["my-model"] = "sha256:baked"
+	state.complete(buildStageBake, "my-model", "")
+	state.complete(buildStageSchema, "", `{"openapi":"3.0.2"}`)
+	// It's only fingerprinted and recorded once it fails
+	require.Empty(t, state.Inputs)
+	require.NoFileExists(t, state.path)
+	state.fail()
+	require.NotEmpty(t, state.Inputs)
+	require.FileExists(t, state.path)
+
+	// Resuming skips them
+	state = newBuildState(cfg, dir, files, nil, true, "my-model")
+	skip, _ := state.skip(buildStageWeights)
+	require.True(t, skip)
+	skip, _ = state.skip(buildStageImage)
+	require.True(t, skip)
+	skip, _ = state.skip(buildStageBake)
+	require.True(t, skip)
+	skip, schema := state.skip(buildStageSchema)
+	require.True(t, skip)
+	require.Equal(t, `{"openapi":"3.0.2"}`, schema)
+	skip, _ = state.skip(buildStagePipFreeze)
+	require.False(t, skip)
+
+	// Stages after a changed image are run again
+	ids["my-model"] = "sha256:other"
+	state = newBuildState(cfg, dir, files, nil, true, "my-model")
+	skip, _ = state.skip(buildStageWeights)
+	require.True(t, skip)
+	skip, _ = state.skip(buildStageImage)
+	require.False(t, skip)
+	skip, _ = state.skip(buildStageSchema)
+	require.False(t, skip)
+
+	// Once a stage is run, the stages after it are too
+	state.complete(buildStageImage, "my-model", "")
+	state = newBuildState(cfg, dir, files, nil, true, "my-model")
+	skip, _ = state.skip(buildStageBake)
+	require.False(t, skip)
+	skip, _ = state.skip(buildStageSchema)
+	require.False(t, skip)
+
+	// Nothing is skipped if the inputs changed
+	state = newBuildState(cfg, dir, files, nil, true, "another-model")
+	skip, _ = state.skip(buildStageWeights)
+	require.False(t, skip)
+
+	// or the build succeeded
+	state.complete(buildStageWeights, "my-model-weights", "")
+	state.finish()
+	state = newBuildState(cfg, dir, files, nil, true, "another-model")
+	skip, _ = state.skip(buildStageWeights)
+	require.False(t, skip)
+}
@@ -0,0 +1,87 @@
+package image
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// Retag points tag at the image source refers to, in the registry, without pulling it. tag can be a full image
+// name, or just a tag in the same repository as source. It returns the image name that was tagged.
+func Retag(source string, tag string) (string, error) {
+	options := []remote.Option{remote.WithAuthFromKeychain(authn.DefaultKeychain)}
+	sourceRef, err := name.ParseReference(source)
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse image name %s: %w", source, err)
+	}
+	targetRef, err := retagTarget(sourceRef, tag)
+	if err != nil {
+		return "", err
+	}
+
+	console.Infof("Fetching manifest for %s...", source)
+	desc, err := remote.Get(sourceRef, options...)
+	if err != nil {
+		return "", fmt.Errorf("Failed to fetch %s: %w", source, err)
+	}
+
+	if targetRef.Context().Name() == sourceRef.Context().Name() {
+		// Tagging in the same repository only uploads the manifest
+		if err := remote.Tag(targetRef, desc, options...); err != nil {
+			return "", fmt.Errorf("Failed to tag %s: %w", targetRef, err)
+		}
+		return targetRef.String(), nil
+	}
+
+	// Copying to another repository mounts the layers from the source repository if they're in the same
+	// registry, so they aren't downloaded
+	console.Infof("Copying %s to %s...", source, targetRef)
+	if err := copyDescriptor(desc, targetRef, options); err != nil {
+		return "", fmt.Errorf("Failed to push %s: %w", targetRef, err)
+	}
+	return targetRef.String(), nil
+}
+
+// RemoteDigest returns the digest imageName points to in its registry, like r8.im/alice/bunny-detector@sha256:...
+func RemoteDigest(imageName string) (string, error) {
+	ref, err := name.ParseReference(imageName)
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse image name %s: %w", imageName, err)
+	}
+	desc, err := remote.Head(ref, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	if err != nil {
+		return "", fmt.Errorf("Failed to fetch %s: %w", imageName, err)
+	}
+	return ref.Context().Digest(desc.Digest.String()).String(), nil
+}
+
+func copyDescriptor(desc *remote.Descriptor, target name.Reference, options []remote.Option) error {
+	if desc.MediaType.IsIndex() {
+		index, err := desc.ImageIndex()
+		if err != nil {
+			return err
+		}
+		return remote.WriteIndex(target, index, options...)
+	}
+	img, err := desc.Image()
+	if err != nil {
+		return err
+	}
+	return remote.Write(target, img, options...)
+}
+
+func retagTarget(source name.Reference, tag string) (name.Tag, error) {
+	if !strings.ContainsAny(tag, "/:") {
+		tag = source.Context().String() + ":" + tag
+	}
+	target, err := name.NewTag(tag)
+	if err != nil {
+		return name.Tag{}, fmt.Errorf("Failed to parse tag %s: %w", tag, err)
+	}
+	return target, nil
+}
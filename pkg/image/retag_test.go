@@ -0,0 +1,31 @@
+package image
+
+import (
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetagTarget(t *testing.T) {
+	source, err := name.ParseReference("r8.im/alice/my-model@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+	require.NoError(t, err)
+
+	target, err := retagTarget(source, "prod")
+	require.NoError(t, err)
+	require.Equal(t, "r8.im/alice/my-model:prod", target.String())
+
+	target, err = retagTarget(source, "registry.example.com/ml/my-model:prod")
+	require.NoError(t, err)
+	require.Equal(t, "registry.example.com/ml/my-model:prod", target.String())
+}
+
+func TestPromotionTarget(t *testing.T) {
+	target, err := PromotionTarget("staging.example.com/ml/my-model:v3", "prod.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "prod.example.com/ml/my-model:v3", target)
+
+	target, err = PromotionTarget("staging.example.com/my-model@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", "prod.example.com/models/")
+	require.NoError(t, err)
+	require.Equal(t, "prod.example.com/models/my-model@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", target)
+}
@@ -0,0 +1,171 @@
+package image
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/dockerignore"
+	"github.com/replicate/cog/pkg/secrets"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// secretScanPrefixes are the directories in an image that are scanned for credentials. The rest of the image is
+// the operating system and installed packages, which have plenty of example keys in their tests.
+var secretScanPrefixes = []string{"src/", "root/", "home/"}
+
+// checkContextSecrets fails if there are credentials in the files sent to the build context that aren't allowed by
+// build.allow_secrets
+func checkContextSecrets(cfg *config.Config, dir string, files []dockerignore.ContextFile) error {
+	findings := []secrets.Finding{}
+	for _, file := range dockerignore.Included(files) {
+		if file.Size > secrets.MaxFileSize || cfg.Build.SecretAllowed(file.Path) {
+			continue
+		}
+		fileFindings, err := scanFile(filepath.Join(dir, file.Path), file.Path)
+		if err != nil {
+			return fmt.Errorf("Failed to scan %s for credentials: %w", file.Path, err)
+		}
+		findings = append(findings, fileFindings...)
+	}
+	if len(findings) > 0 {
+		return secrets.Error("the build context", secrets.Sort(findings))
+	}
+	return nil
+}
+
+func scanFile(path string, name string) ([]secrets.Finding, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return secrets.Scan(name, f)
+}
+
+// CheckImageSecrets fails if there are credentials in the model's files, home directories or environment variables
+// in imageName, in the local Docker daemon. Every layer that can have them is scanned, because a file that's deleted
+// in a later layer can still be read from the one it was added in.
+func CheckImageSecrets(cfg *config.Config, imageName string) error {
+	console.Info("Scanning image for credentials...")
+	// The image is streamed from Docker, rather than exported to a file, so only the layers that are scanned are kept
+	img, err := tarball.Image(docker.ImageArchiveOpener(imageName), nil)
+	if err != nil {
+		return fmt.Errorf("Failed to read %s from Docker: %w", imageName, err)
+	}
+	findings, err := scanImage(img, cfg)
+	if err != nil {
+		return fmt.Errorf("Failed to scan %s for credentials: %w", imageName, err)
+	}
+	if len(findings) > 0 {
+		return secrets.Error(imageName, findings)
+	}
+	return nil
+}
+
+func scanImage(img v1.Image, cfg *config.Config) ([]secrets.Finding, error) {
+	findings := []secrets.Finding{}
+	configFile, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	for i, env := range configFile.Config.Env {
+		findings = append(findings, secrets.ScanLine("ENV", i+1, env)...)
+	}
+
+	layers, err := scannedLayers(img, configFile, cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, layer := range layers {
+		err := walkLayer(layer, func(header *tar.Header, contents io.Reader) error {
+			p := layerPath(header.Name)
+			if header.Typeflag != tar.TypeReg || header.Size > secrets.MaxFileSize || !scannedForSecrets(p, cfg) {
+				return nil
+			}
+			fileFindings, err := secrets.Scan("/"+p, contents)
+			if err != nil {
+				return err
+			}
+			findings = append(findings, fileFindings...)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return secrets.Sort(findings), nil
+}
+
+// scannedLayers returns the layers of img that can have the model's files or home directories in them, which are
+// found from the commands in configFile's history that created them. The base image and the layers that install
+// packages are skipped. Every layer is scanned if the history doesn't match the layers.
+func scannedLayers(img v1.Image, configFile *v1.ConfigFile, cfg *config.Config) ([]v1.Layer, error) {
+	history := []v1.History{}
+	for _, h := range configFile.History {
+		if !h.EmptyLayer {
+			history = append(history, h)
+		}
+	}
+	diffIDs := configFile.RootFS.DiffIDs
+	if len(history) != len(diffIDs) {
+		return img.Layers()
+	}
+	layers := []v1.Layer{}
+	for i, h := range history {
+		if !layerScannedForSecrets(h.CreatedBy, cfg) {
+			continue
+		}
+		layer, err := img.LayerByDiffID(diffIDs[i])
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, layer)
+	}
+	return layers, nil
+}
+
+// layerScannedForSecrets returns whether a layer created by createdBy can have files in the directories in
+// secretScanPrefixes, because it copies files into them, mentions them, or is one of the commands in build.run.
+// Layers without a history of what created them are always scanned.
+func layerScannedForSecrets(createdBy string, cfg *config.Config) bool {
+	if createdBy == "" {
+		return true
+	}
+	for _, prefix := range secretScanPrefixes {
+		if strings.Contains(createdBy, "/"+strings.TrimSuffix(prefix, "/")) {
+			return true
+		}
+	}
+	for _, run := range cfg.Build.Run {
+		if command := strings.TrimSpace(run.Command); command != "" && strings.Contains(createdBy, command) {
+			return true
+		}
+	}
+	return false
+}
+
+// scannedForSecrets returns whether the file at p in an image is scanned for credentials. Files in /src are allowed
+// by build.allow_secrets like they are in the project directory.
+func scannedForSecrets(p string, cfg *config.Config) bool {
+	if strings.HasSuffix(p, encryptedWeightsSuffix) {
+		return false
+	}
+	if rel, ok := strings.CutPrefix(p, "src/"); ok {
+		return !cfg.Build.SecretAllowed(rel)
+	}
+	for _, prefix := range secretScanPrefixes {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
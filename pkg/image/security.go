@@ -0,0 +1,100 @@
+package image
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/docker/command"
+)
+
+// securityLabel is the container hardening in cog.yaml, as it's recorded in an image. The seccomp profile is
+// included, because schedulers don't have the project directory it's in.
+type securityLabel struct {
+	docker.SecurityOptions
+	SeccompProfile json.RawMessage `json:"seccomp_profile,omitempty"`
+}
+
+// ConfigSecurityOptions returns the container hardening in cog.yaml
+func ConfigSecurityOptions(cfg *config.Config, dir string) docker.SecurityOptions {
+	if cfg.Run == nil {
+		return docker.SecurityOptions{}
+	}
+	options := docker.SecurityOptions{
+		ReadOnly:        cfg.Run.ReadOnly,
+		WritablePaths:   cfg.Run.WritablePaths,
+		Seccomp:         cfg.Run.Seccomp,
+		AppArmor:        cfg.Run.AppArmor,
+		NoNewPrivileges: cfg.Run.NoNewPrivileges,
+	}
+	if profilePath := cfg.Run.SeccompProfilePath(dir); profilePath != "" {
+		options.Seccomp = profilePath
+	}
+	if cfg.Run.Network.Restricted() {
+		options.Egress = &docker.EgressPolicy{Allow: cfg.Run.Network.Allow}
+	}
+	return options
+}
+
+// securityLabelValue returns the label that records the container hardening in cog.yaml, or "" if there isn't any
+func securityLabelValue(cfg *config.Config, dir string) (string, error) {
+	options := ConfigSecurityOptions(cfg, dir)
+	if !options.ReadOnly && options.Seccomp == "" && options.AppArmor == "" && !options.NoNewPrivileges && options.Egress == nil {
+		return "", nil
+	}
+	label := securityLabel{SecurityOptions: options}
+	if profilePath := cfg.Run.SeccompProfilePath(dir); profilePath != "" {
+		data, err := os.ReadFile(profilePath)
+		if err != nil {
+			return "", fmt.Errorf("Failed to read seccomp profile: %w", err)
+		}
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, data); err != nil {
+			return "", fmt.Errorf("Failed to parse seccomp profile %s: %w", cfg.Run.Seccomp, err)
+		}
+		label.Seccomp = filepath.Base(profilePath)
+		label.SeccompProfile = compact.Bytes()
+	}
+	value, err := json.Marshal(label)
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// SecurityOptions returns the container hardening recorded in imageName when it was built. The seccomp profile is
+// written to a temporary file, so it can be passed to `docker run`.
+func SecurityOptions(imageName string) (docker.SecurityOptions, error) {
+	inspect, err := docker.ImageInspect(imageName)
+	if err != nil {
+		return docker.SecurityOptions{}, fmt.Errorf("Failed to inspect %s: %w", imageName, err)
+	}
+	value := inspect.Config.Labels[command.CogSecurityLabelKey]
+	if value == "" {
+		return docker.SecurityOptions{}, nil
+	}
+	return parseSecurityLabel(value, os.TempDir())
+}
+
+func parseSecurityLabel(value string, tmpDir string) (docker.SecurityOptions, error) {
+	label := securityLabel{}
+	if err := json.Unmarshal([]byte(value), &label); err != nil {
+		return docker.SecurityOptions{}, fmt.Errorf("Failed to parse %s label: %w", command.CogSecurityLabelKey, err)
+	}
+	if len(label.SeccompProfile) > 0 {
+		// Named by its contents, so it doesn't need cleaning up and is shared by containers of the same image
+		sum := sha256.Sum256(label.SeccompProfile)
+		profilePath := filepath.Join(tmpDir, "cog-seccomp-"+hex.EncodeToString(sum[:8])+".json")
+		if err := os.WriteFile(profilePath, label.SeccompProfile, 0o644); err != nil {
+			return docker.SecurityOptions{}, fmt.Errorf("Failed to write seccomp profile: %w", err)
+		}
+		label.Seccomp = profilePath
+	}
+	return label.SecurityOptions, nil
+}
@@ -0,0 +1,48 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/docker"
+)
+
+func TestSecurityLabel(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "seccomp.json"), []byte("{\n  \"defaultAction\": \"SCMP_ACT_ERRNO\"\n}\n"), 0o644))
+	cfg := &config.Config{Run: &config.Runtime{
+		ReadOnly:        true,
+		WritablePaths:   []string{"/root/.cache"},
+		Seccomp:         "seccomp.json",
+		NoNewPrivileges: true,
+	}}
+
+	value, err := securityLabelValue(cfg, dir)
+	require.NoError(t, err)
+	require.Equal(t, `{"read_only":true,"writable_paths":["/root/.cache"],"seccomp":"seccomp.json","no_new_privileges":true,"seccomp_profile":{"defaultAction":"SCMP_ACT_ERRNO"}}`, value)
+
+	tmpDir := t.TempDir()
+	options, err := parseSecurityLabel(value, tmpDir)
+	require.NoError(t, err)
+	require.True(t, options.ReadOnly)
+	require.Equal(t, []string{"/root/.cache"}, options.WritablePaths)
+	require.True(t, options.NoNewPrivileges)
+	require.Equal(t, tmpDir, filepath.Dir(options.Seccomp))
+	profile, err := os.ReadFile(options.Seccomp)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"defaultAction": "SCMP_ACT_ERRNO"}`, string(profile))
+}
+
+func TestSecurityLabelWithoutRuntime(t *testing.T) {
+	value, err := securityLabelValue(&config.Config{}, t.TempDir())
+	require.NoError(t, err)
+	require.Empty(t, value)
+
+	options, err := parseSecurityLabel(`{"apparmor":"unconfined"}`, t.TempDir())
+	require.NoError(t, err)
+	require.Equal(t, docker.SecurityOptions{AppArmor: "unconfined"}, options)
+}
@@ -0,0 +1,64 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/replicate/cog/pkg/docker/command"
+)
+
+// SetupTiming is how long a model's setup() took, and how long each of its phases took, in seconds. The phases are
+// import, which loads the predictor, setup, which is all of setup(), and the phases the model times itself.
+type SetupTiming struct {
+	Seconds float64
+	Phases  map[string]float64
+}
+
+// NewSetupTiming returns the timing of a setup that took duration, with phases
+func NewSetupTiming(duration time.Duration, phases map[string]float64) SetupTiming {
+	return SetupTiming{Seconds: duration.Seconds(), Phases: phases}
+}
+
+// Labels returns the labels that record the timing on an image
+func (t SetupTiming) Labels() (map[string]string, error) {
+	labels := map[string]string{}
+	if t.Seconds > 0 {
+		labels[command.CogSetupTimeLabelKey] = strconv.FormatFloat(t.Seconds, 'f', 3, 64)
+	}
+	if len(t.Phases) > 0 {
+		phases, err := json.Marshal(t.Phases)
+		if err != nil {
+			return nil, err
+		}
+		labels[command.CogSetupPhasesLabelKey] = string(phases)
+	}
+	return labels, nil
+}
+
+// String returns the breakdown of the timing, with the longest phases first, like
+// "41.2s (setup 40.1s, load weights 31.0s, import 1.1s)"
+func (t SetupTiming) String() string {
+	names := make([]string, 0, len(t.Phases))
+	for name := range t.Phases {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if t.Phases[names[i]] != t.Phases[names[j]] {
+			return t.Phases[names[i]] > t.Phases[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	total := fmt.Sprintf("%.1fs", t.Seconds)
+	if len(names) == 0 {
+		return total
+	}
+	phases := make([]string, len(names))
+	for i, name := range names {
+		phases[i] = fmt.Sprintf("%s %.1fs", name, t.Phases[name])
+	}
+	return total + " (" + strings.Join(phases, ", ") + ")"
+}
@@ -0,0 +1,30 @@
+package image
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/docker/command"
+)
+
+func TestSetupTiming(t *testing.T) {
+	timing := NewSetupTiming(41200*time.Millisecond, map[string]float64{
+		"import":       1.1,
+		"setup":        40.1,
+		"load weights": 31,
+	})
+	require.Equal(t, "41.2s (setup 40.1s, load weights 31.0s, import 1.1s)", timing.String())
+
+	labels, err := timing.Labels()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		command.CogSetupTimeLabelKey:   "41.200",
+		command.CogSetupPhasesLabelKey: `{"import":1.1,"load weights":31,"setup":40.1}`,
+	}, labels)
+
+	labels, err = SetupTiming{}.Labels()
+	require.NoError(t, err)
+	require.Empty(t, labels)
+}
@@ -0,0 +1,26 @@
+package image
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/replicate/cog/pkg/config"
+)
+
+// sshForwards returns the SSH agents to forward into the build, in the format of `docker build --ssh`.
+// If cog.yaml sets build.ssh and none were passed on the command line, the default agent in SSH_AUTH_SOCK is used.
+func sshForwards(cfg *config.Config, ssh []string) ([]string, error) {
+	if len(ssh) > 0 {
+		if !cfg.Build.SSH {
+			return nil, fmt.Errorf("--ssh was passed, but build.ssh isn't enabled in cog.yaml, so it wouldn't be used by any build step")
+		}
+		return ssh, nil
+	}
+	if !cfg.Build.SSH {
+		return nil, nil
+	}
+	if os.Getenv("SSH_AUTH_SOCK") == "" {
+		return nil, fmt.Errorf("build.ssh is enabled in cog.yaml, but no SSH agent is running. Start one with `eval $(ssh-agent) && ssh-add`, or pass a key with `--ssh default=$HOME/.ssh/id_ed25519`")
+	}
+	return []string{"default"}, nil
+}
@@ -0,0 +1,149 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/google/go-containerregistry/pkg/name"
+
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/docker/command"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// StateVersion is the version of the state file format. It changes if fields are removed or change meaning.
+const StateVersion = 1
+
+// State pins the exact artifact a build or push produced, for infrastructure-as-code tools like Terraform and
+// Pulumi that deploy it
+type State struct {
+	Version int    `json:"version"`
+	Image   string `json:"image"`
+	ImageID string `json:"image_id"`
+	// ImageDigest is the digest of the image in its registry, like r8.im/alice/bunny-detector@sha256:..., which
+	// is only known once it's been pushed or pulled
+	ImageDigest   string            `json:"image_digest,omitempty"`
+	WeightsImage  string            `json:"weights_image,omitempty"`
+	WeightsID     string            `json:"weights_image_id,omitempty"`
+	WeightsDigest string            `json:"weights_image_digest,omitempty"`
+	CogVersion    string            `json:"cog_version,omitempty"`
+	SchemaSHA256  string            `json:"schema_sha256,omitempty"`
+	BaseImages    []BaseImageState  `json:"base_images"`
+	Labels        map[string]string `json:"labels"`
+}
+
+// BaseImageState is an image a model was built on
+type BaseImageState struct {
+	Image  string `json:"image"`
+	Digest string `json:"digest,omitempty"`
+}
+
+// WriteState writes the State of imageName to path as JSON. If separateWeights is set, it includes the weights
+// image that was built with it.
+func WriteState(path string, imageName string, separateWeights bool) error {
+	state, err := GetState(imageName, separateWeights)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("Failed to create directory for %s: %w", path, err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("Failed to write state to %s: %w", path, err)
+	}
+	console.Infof("Wrote state to %s", path)
+	return nil
+}
+
+// GetState returns the State of imageName, from the local Docker daemon
+func GetState(imageName string, separateWeights bool) (*State, error) {
+	inspect, err := docker.ImageInspect(imageName)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to inspect %s: %w", imageName, err)
+	}
+	state := stateFromInspect(imageName, inspect)
+
+	if separateWeights {
+		weightsImage := imageName + "-weights"
+		weights, err := docker.ImageInspect(weightsImage)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to inspect %s: %w", weightsImage, err)
+		}
+		state.WeightsImage = weightsImage
+		state.WeightsID = weights.ID
+		state.WeightsDigest = repoDigest(weightsImage, weights.RepoDigests)
+	}
+
+	for i, base := range state.BaseImages {
+		baseInspect, err := docker.ImageInspect(base.Image)
+		if errors.Is(err, docker.ErrNoSuchImage) {
+			// The base image may have been pruned since it was built on
+			console.Debugf("Base image %s isn't available locally, so its digest isn't recorded", base.Image)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to inspect %s: %w", base.Image, err)
+		}
+		state.BaseImages[i].Digest = repoDigest(base.Image, baseInspect.RepoDigests)
+	}
+
+	return state, nil
+}
+
+func stateFromInspect(imageName string, inspect *types.ImageInspect) *State {
+	state := &State{
+		Version:     StateVersion,
+		Image:       imageName,
+		ImageID:     inspect.ID,
+		ImageDigest: repoDigest(imageName, inspect.RepoDigests),
+		BaseImages:  []BaseImageState{},
+		Labels:      map[string]string{},
+	}
+	if inspect.Config == nil || inspect.Config.Labels == nil {
+		return state
+	}
+	state.Labels = inspect.Config.Labels
+	state.CogVersion = state.Labels[command.CogVersionLabelKey]
+	if schema := state.Labels[command.CogOpenAPISchemaLabelKey]; schema != "" {
+		hash := sha256.Sum256([]byte(schema))
+		state.SchemaSHA256 = hex.EncodeToString(hash[:])
+	}
+	if baseImage := state.Labels[command.CogBaseImageNameLabelKey]; baseImage != "" {
+		state.BaseImages = append(state.BaseImages, BaseImageState{Image: baseImage})
+	}
+	return state
+}
+
+// repoDigest returns the digest in repoDigests that's in the same repository as imageName. Docker shortens
+// repository names, like docker.io/library/python to python, so they're compared after parsing.
+func repoDigest(imageName string, repoDigests []string) string {
+	ref, err := name.ParseReference(imageName)
+	if err != nil {
+		return ""
+	}
+	for _, digest := range repoDigests {
+		repo, _, ok := strings.Cut(digest, "@")
+		if !ok {
+			continue
+		}
+		digestRepo, err := name.NewRepository(repo)
+		if err != nil {
+			continue
+		}
+		if digestRepo.Name() == ref.Context().Name() {
+			return digest
+		}
+	}
+	return ""
+}
@@ -0,0 +1,44 @@
+package image
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/docker/command"
+)
+
+func TestStateFromInspect(t *testing.T) {
+	inspect := &types.ImageInspect{
+		ID: "sha256:1234",
+		RepoDigests: []string{
+			"registry.example.com/other@sha256:aaaa",
+			"r8.im/alice/bunny-detector@sha256:bbbb",
+		},
+		Config: &container.Config{
+			Labels: map[string]string{
+				command.CogVersionLabelKey:       "0.9.0",
+				command.CogOpenAPISchemaLabelKey: "{}",
+				command.CogBaseImageNameLabelKey: "r8.im/cog-base:cuda12.1-python3.11",
+			},
+		},
+	}
+	state := stateFromInspect("r8.im/alice/bunny-detector:latest", inspect)
+	require.Equal(t, StateVersion, state.Version)
+	require.Equal(t, "sha256:1234", state.ImageID)
+	require.Equal(t, "r8.im/alice/bunny-detector@sha256:bbbb", state.ImageDigest)
+	require.Equal(t, "0.9.0", state.CogVersion)
+	// sha256 of "{}"
+	require.Equal(t, "44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a", state.SchemaSHA256)
+	require.Equal(t, []BaseImageState{{Image: "r8.im/cog-base:cuda12.1-python3.11"}}, state.BaseImages)
+}
+
+func TestRepoDigest(t *testing.T) {
+	digests := []string{"python@sha256:cccc"}
+	require.Equal(t, "python@sha256:cccc", repoDigest("docker.io/library/python:3.11", digests))
+	require.Equal(t, "python@sha256:cccc", repoDigest("python", digests))
+	require.Equal(t, "", repoDigest("my-model", digests))
+	require.Equal(t, "", repoDigest("my-model", nil))
+}
@@ -0,0 +1,66 @@
+package image
+
+import (
+	"reflect"
+	"runtime"
+	"strings"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/lockfile"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// recordTorchWheels writes the wheels that torch, torchvision and torchaudio were installed from to the
+// lockfile, going by the versions pip freeze reports in the built image
+func recordTorchWheels(cfg *config.Config, dir string, pipFreeze string) error {
+	lock, err := lockfile.Load(dir)
+	if err != nil {
+		return err
+	}
+	// Wheels that are already in the lockfile don't need to be resolved again
+	locked := map[string]lockfile.Wheel{}
+	for _, wheel := range lock.Wheels {
+		locked[wheel.Name+"=="+wheel.Version] = wheel
+	}
+
+	pythonVersion := cfg.Build.PythonVersion
+	if parts := strings.SplitN(pythonVersion, ".", 3); len(parts) > 2 {
+		pythonVersion = parts[0] + "." + parts[1]
+	}
+	// Images are built for the machine's architecture, unless Docker is told to build for another platform
+	platform := docker.BuildPlatform()
+	if platform == "" {
+		platform = "linux/" + runtime.GOARCH
+	}
+	wheels := []lockfile.Wheel{}
+	for _, line := range strings.Split(pipFreeze, "\n") {
+		line = strings.TrimSpace(line)
+		if name, url, ok := strings.Cut(line, " @ "); ok {
+			// Installed from a URL, so there's nothing to resolve
+			if config.IsTorchPackage(name) {
+				wheels = append(wheels, lockfile.Wheel{Name: name, URL: url})
+			}
+			continue
+		}
+		name, version, ok := strings.Cut(line, "==")
+		if !ok || !config.IsTorchPackage(name) {
+			continue
+		}
+		if wheel, ok := locked[line]; ok && wheel.IsForPlatform(platform) {
+			wheels = append(wheels, wheel)
+			continue
+		}
+		wheel, err := lockfile.ResolveWheel(lockfile.IndexURLForVersion(version), name, version, pythonVersion, platform)
+		if err != nil {
+			return err
+		}
+		wheels = append(wheels, *wheel)
+	}
+	if len(wheels) == 0 || reflect.DeepEqual(lock.Wheels, wheels) {
+		return nil
+	}
+	lock.Wheels = wheels
+	console.Infof("Recording torch wheels in %s", lockfile.Filename)
+	return lock.Save(dir)
+}
@@ -0,0 +1,147 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/cosign"
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/util/console"
+	"github.com/replicate/cog/pkg/util/slices"
+)
+
+const (
+	// VerifyBaseImageEnvVarName is set by organizations that require base images to be verified, e.g. on CI
+	// runners. Verification can't be turned off with flags when it's set.
+	VerifyBaseImageEnvVarName  = "COG_VERIFY_BASE"
+	CosignKeyEnvVarName        = "COG_COSIGN_KEY"
+	CosignIdentityEnvVarName   = "COG_COSIGN_IDENTITY"
+	CosignOIDCIssuerEnvVarName = "COG_COSIGN_OIDC_ISSUER"
+)
+
+const (
+	VerifyWithCosign       = "cosign"
+	VerifyWithContentTrust = "content-trust"
+)
+
+// BaseImageVerifications returns how base images have to be verified, from the --verify-base flag and the
+// COG_VERIFY_BASE policy. Both are applied if they ask for different methods.
+func BaseImageVerifications(flag string) ([]string, error) {
+	methods := []string{}
+	for _, method := range []string{flag, os.Getenv(VerifyBaseImageEnvVarName)} {
+		if method == "" || slices.ContainsString(methods, method) {
+			continue
+		}
+		if method != VerifyWithCosign && method != VerifyWithContentTrust {
+			return nil, fmt.Errorf("Invalid base image verification %q, it must be %q or %q", method, VerifyWithCosign, VerifyWithContentTrust)
+		}
+		methods = append(methods, method)
+	}
+	return methods, nil
+}
+
+// VerifyBaseImage checks the signature of the base image a build would use, whether it's a cog base image, a CUDA
+// image or a Python image, with each of methods. It fails if the image isn't signed by a trusted signer.
+//
+// It returns the base image pinned to the digest that was verified, for cfg.Build.PinnedBaseImage, so the build
+// uses that image even if the tag is moved after it's verified.
+func VerifyBaseImage(cfg *config.Config, dir string, useCudaBaseImage string, useCogBaseImage *bool, methods []string) (string, error) {
+	if len(methods) == 0 {
+		return "", nil
+	}
+	resolution, err := ResolveBaseImages(cfg, dir, useCudaBaseImage, useCogBaseImage)
+	if err != nil {
+		return "", err
+	}
+	verifiedDigest := ""
+	for _, method := range methods {
+		console.Infof("Verifying the signature of %s with %s...", resolution.BaseImage, method)
+		var digest string
+		switch method {
+		case VerifyWithCosign:
+			digest, err = verifyCosign(resolution.BaseImage)
+		case VerifyWithContentTrust:
+			digest, err = verifyContentTrust(resolution.BaseImage)
+		}
+		if err != nil {
+			return "", fmt.Errorf("Failed to verify base image %s with %s: %w", resolution.BaseImage, method, err)
+		}
+		if verifiedDigest != "" && digest != verifiedDigest {
+			return "", fmt.Errorf("Base image %s was verified as %s with %s, but as %s with %s", resolution.BaseImage, verifiedDigest, methods[0], digest, method)
+		}
+		verifiedDigest = digest
+	}
+	ref, _, _ := strings.Cut(resolution.BaseImage, "@")
+	return ref + "@" + verifiedDigest, nil
+}
+
+// verifyCosign verifies image with cosign, and returns the digest it verified
+func verifyCosign(image string) (string, error) {
+	if !cosign.Installed() {
+		return "", errors.New("cosign isn't installed. Install it from https://docs.sigstore.dev/cosign/system_config/installation/")
+	}
+	options := cosign.VerifyOptions{
+		Key:        os.Getenv(CosignKeyEnvVarName),
+		Identity:   os.Getenv(CosignIdentityEnvVarName),
+		OIDCIssuer: os.Getenv(CosignOIDCIssuerEnvVarName),
+	}
+	if options.Key == "" && (options.Identity == "" || options.OIDCIssuer == "") {
+		return "", fmt.Errorf("Set %s to the public key the image is signed with, or %s and %s to the identity and OIDC issuer of its signing certificate", CosignKeyEnvVarName, CosignIdentityEnvVarName, CosignOIDCIssuerEnvVarName)
+	}
+	digest, err := cosign.Verify(image, options)
+	if err != nil {
+		return "", err
+	}
+	console.Infof("%s has a valid cosign signature for %s", image, digest)
+	return digest, nil
+}
+
+// verifyContentTrust verifies image with Docker Content Trust, and returns the digest its tag is signed with
+func verifyContentTrust(image string) (string, error) {
+	// Content trust signs tags, so images pinned by digest are looked up by their tag and the digests compared
+	ref, pinnedDigest, _ := strings.Cut(image, "@")
+	tag, err := name.NewTag(ref)
+	if err != nil {
+		return "", fmt.Errorf("Docker Content Trust needs a tag to verify: %w", err)
+	}
+	data, err := docker.TrustInspect(tag.String())
+	if err != nil {
+		return "", err
+	}
+	signers, digest, err := checkContentTrust(tag.TagStr(), pinnedDigest, data)
+	if err != nil {
+		return "", err
+	}
+	console.Infof("%s is signed by %s", image, strings.Join(signers, ", "))
+	return digest, nil
+}
+
+// checkContentTrust checks that tag is signed in data, with pinnedDigest if it's set, and returns who signed it
+// and the digest it's signed with
+func checkContentTrust(tag string, pinnedDigest string, data *docker.TrustData) ([]string, string, error) {
+	if data == nil || len(data.SignedTags) == 0 {
+		return nil, "", errors.New("It has no Docker Content Trust signatures")
+	}
+	signedTags := []string{}
+	for _, signed := range data.SignedTags {
+		signedTags = append(signedTags, signed.SignedTag)
+		if signed.SignedTag != tag {
+			continue
+		}
+		signedDigest := "sha256:" + signed.Digest
+		if pinnedDigest != "" && pinnedDigest != signedDigest {
+			return nil, "", fmt.Errorf("Tag %s is signed with digest %s, but the build is pinned to %s", tag, signedDigest, pinnedDigest)
+		}
+		signers := signed.Signers
+		if len(signers) == 0 {
+			signers = []string{"the repository key"}
+		}
+		return signers, signedDigest, nil
+	}
+	return nil, "", fmt.Errorf("Tag %s isn't signed. The signed tags are: %s", tag, strings.Join(signedTags, ", "))
+}
@@ -0,0 +1,61 @@
+package image
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/docker"
+)
+
+func TestBaseImageVerifications(t *testing.T) {
+	t.Setenv(VerifyBaseImageEnvVarName, "")
+	methods, err := BaseImageVerifications("")
+	require.NoError(t, err)
+	require.Empty(t, methods)
+
+	methods, err = BaseImageVerifications(VerifyWithCosign)
+	require.NoError(t, err)
+	require.Equal(t, []string{VerifyWithCosign}, methods)
+
+	t.Setenv(VerifyBaseImageEnvVarName, VerifyWithContentTrust)
+	methods, err = BaseImageVerifications("")
+	require.NoError(t, err)
+	require.Equal(t, []string{VerifyWithContentTrust}, methods)
+
+	methods, err = BaseImageVerifications(VerifyWithCosign)
+	require.NoError(t, err)
+	require.Equal(t, []string{VerifyWithCosign, VerifyWithContentTrust}, methods)
+
+	_, err = BaseImageVerifications("gpg")
+	require.ErrorContains(t, err, `Invalid base image verification "gpg"`)
+}
+
+func TestCheckContentTrust(t *testing.T) {
+	data := &docker.TrustData{
+		Name: "r8.im/cog-base",
+		SignedTags: []docker.SignedTag{
+			{SignedTag: "python3.12", Digest: "abc123", Signers: []string{"replicate"}},
+			{SignedTag: "python3.11", Digest: "def456"},
+		},
+	}
+
+	signers, digest, err := checkContentTrust("python3.12", "", data)
+	require.NoError(t, err)
+	require.Equal(t, []string{"replicate"}, signers)
+	require.Equal(t, "sha256:abc123", digest)
+
+	signers, digest, err = checkContentTrust("python3.11", "sha256:def456", data)
+	require.NoError(t, err)
+	require.Equal(t, []string{"the repository key"}, signers)
+	require.Equal(t, "sha256:def456", digest)
+
+	_, _, err = checkContentTrust("python3.12", "sha256:fff000", data)
+	require.ErrorContains(t, err, "Tag python3.12 is signed with digest sha256:abc123, but the build is pinned to sha256:fff000")
+
+	_, _, err = checkContentTrust("python3.10", "", data)
+	require.ErrorContains(t, err, "Tag python3.10 isn't signed. The signed tags are: python3.12, python3.11")
+
+	_, _, err = checkContentTrust("python3.12", "", nil)
+	require.ErrorContains(t, err, "no Docker Content Trust signatures")
+}
@@ -0,0 +1,158 @@
+// Package lfs detects Git LFS pointer files, so that they aren't built into images in place of the files they point to.
+package lfs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/replicate/cog/pkg/dockerignore"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+const pointerVersion = "version https://git-lfs.github.com/spec/v1"
+
+// Pointer files are small text files. Anything larger than this is real content.
+const maxPointerSize = 1024
+
+// Pointer is the contents of a Git LFS pointer file
+type Pointer struct {
+	OID  string
+	Size int64
+}
+
+// ParsePointer parses a Git LFS pointer file, returning false if data is not a pointer
+func ParsePointer(data []byte) (*Pointer, bool) {
+	if len(data) > maxPointerSize || !bytes.HasPrefix(data, []byte(pointerVersion+"\n")) {
+		return nil, false
+	}
+	pointer := &Pointer{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), " ")
+		switch key {
+		case "oid":
+			pointer.OID = value
+		case "size":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, false
+			}
+			pointer.Size = size
+		}
+	}
+	if pointer.OID == "" {
+		return nil, false
+	}
+	return pointer, true
+}
+
+// ReadPointer reads the file at path, returning nil if it isn't a Git LFS pointer
+func ReadPointer(path string) (*Pointer, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() || info.Size() > maxPointerSize {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if pointer, ok := ParsePointer(data); ok {
+		return pointer, nil
+	}
+	return nil, nil
+}
+
+// FindPointers returns the paths, relative to dir, of Git LFS pointer files that will be sent to the build context
+func FindPointers(dir string) ([]string, error) {
+	files, err := dockerignore.WalkContext(dir)
+	if err != nil {
+		return nil, err
+	}
+	return FindPointersIn(dir, files)
+}
+
+// FindPointersIn is FindPointers for the files in dir that have already been listed with dockerignore.WalkContext
+func FindPointersIn(dir string, files []dockerignore.ContextFile) ([]string, error) {
+	pointers := []string{}
+	for _, f := range dockerignore.Included(files) {
+		if f.Size > maxPointerSize {
+			continue
+		}
+		pointer, err := ReadPointer(filepath.Join(dir, f.Path))
+		if err != nil {
+			return nil, err
+		}
+		if pointer != nil {
+			pointers = append(pointers, f.Path)
+		}
+	}
+	return pointers, nil
+}
+
+// Installed returns true if the git-lfs extension is available
+func Installed() bool {
+	_, err := exec.LookPath("git-lfs")
+	return err == nil
+}
+
+// Pull downloads the content of the given pointer files with `git lfs pull`
+func Pull(dir string, paths []string) error {
+	patterns := []string{}
+	for _, p := range paths {
+		patterns = append(patterns, filepath.ToSlash(p))
+	}
+	cmd := exec.Command("git", "-C", dir, "lfs", "pull", "--include", strings.Join(patterns, ","))
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	console.Debug("$ " + strings.Join(cmd.Args, " "))
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Failed to run git lfs pull: %w", err)
+	}
+	return nil
+}
+
+// ListFiles returns the LFS object IDs of the files tracked by Git LFS under dir, keyed by path relative to dir
+func ListFiles(dir string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancel()
+
+	prefix, err := exec.CommandContext(ctx, "git", "-C", dir, "rev-parse", "--show-prefix").Output()
+	if err != nil {
+		return nil, err
+	}
+	out, err := exec.CommandContext(ctx, "git", "-C", dir, "lfs", "ls-files", "--long").Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseLsFiles(string(out), strings.TrimSpace(string(prefix))), nil
+}
+
+// parseLsFiles parses the output of `git lfs ls-files --long`, which has lines like
+//
+//	<oid> <* or -> <path relative to repository root>
+func parseLsFiles(out string, prefix string) map[string]string {
+	oids := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		path, ok := strings.CutPrefix(fields[2], prefix)
+		if !ok {
+			continue
+		}
+		oids[path] = "sha256:" + fields[0]
+	}
+	return oids
+}
@@ -0,0 +1,51 @@
+package lfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testPointer = `version https://git-lfs.github.com/spec/v1
+oid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393
+size 12345
+`
+
+func TestParsePointer(t *testing.T) {
+	pointer, ok := ParsePointer([]byte(testPointer))
+	require.True(t, ok)
+	require.Equal(t, &Pointer{
+		OID:  "sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393",
+		Size: 12345,
+	}, pointer)
+
+	_, ok = ParsePointer([]byte("import torch\n"))
+	require.False(t, ok)
+	_, ok = ParsePointer([]byte("version https://git-lfs.github.com/spec/v1\nsize 1\n"))
+	require.False(t, ok)
+}
+
+func TestFindPointers(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "model.safetensors"), []byte(testPointer), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ignored.bin"), []byte(testPointer), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "predict.py"), []byte("import torch\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".dockerignore"), []byte("ignored.bin\n"), 0o644))
+
+	pointers, err := FindPointers(dir)
+	require.NoError(t, err)
+	require.Equal(t, []string{"model.safetensors"}, pointers)
+}
+
+func TestParseLsFiles(t *testing.T) {
+	out := `4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393 * models/sdxl/weights/unet.safetensors
+1f2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d3e4f5a6b7c8d9e0f1a2b - models/sdxl/vae.bin
+aaaa - other/file.bin
+`
+	require.Equal(t, map[string]string{
+		"weights/unet.safetensors": "sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393",
+		"vae.bin":                  "sha256:1f2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d3e4f5a6b7c8d9e0f1a2b",
+	}, parseLsFiles(out, "models/sdxl/"))
+}
@@ -0,0 +1,85 @@
+"""
+Prints the licenses of the Python and Debian packages installed in the image as
+JSON, for `cog build` to check against the license policy in cog.yaml.
+"""
+
+import json
+import subprocess
+import sys
+from importlib import metadata
+
+
+def python_licenses(dist):
+    expression = dist.metadata.get("License-Expression")
+    if expression:
+        return [expression]
+    classifiers = [
+        c.split("::")[-1].strip()
+        for c in dist.metadata.get_all("Classifier") or []
+        if c.startswith("License ::") and c.count("::") > 1
+    ]
+    if classifiers:
+        return classifiers
+    license = (dist.metadata.get("License") or "").strip()
+    # Some packages put the whole license text here, which isn't a license name
+    if license and license.upper() != "UNKNOWN" and "\n" not in license and len(license) <= 64:
+        return [license]
+    return []
+
+
+def python_packages():
+    packages = {}
+    for dist in metadata.distributions():
+        name = dist.metadata.get("Name")
+        if name and name.lower() not in packages:
+            packages[name.lower()] = {
+                "name": name,
+                "version": dist.version,
+                "source": "pip",
+                "licenses": python_licenses(dist),
+            }
+    return list(packages.values())
+
+
+def debian_licenses(package):
+    # Machine-readable copyright files have a License field for each set of files
+    licenses = []
+    try:
+        with open(f"/usr/share/doc/{package}/copyright", errors="replace") as f:
+            for line in f:
+                if line.startswith("License:"):
+                    license = line[len("License:") :].strip()
+                    if license and license not in licenses:
+                        licenses.append(license)
+    except OSError:
+        pass
+    return licenses
+
+
+def debian_packages():
+    try:
+        output = subprocess.run(
+            ["dpkg-query", "-W", "-f", "${Package}\t${Version}\t${db:Status-Abbrev}\n"],
+            check=True,
+            capture_output=True,
+            text=True,
+        ).stdout
+    except (OSError, subprocess.CalledProcessError):
+        return []
+    packages = []
+    for line in output.splitlines():
+        name, version, status = (line.split("\t") + ["", ""])[:3]
+        if status.startswith("ii"):
+            packages.append(
+                {
+                    "name": name,
+                    "version": version,
+                    "source": "apt",
+                    "licenses": debian_licenses(name.split(":")[0]),
+                }
+            )
+    return packages
+
+
+if __name__ == "__main__":
+    json.dump({"packages": python_packages() + debian_packages()}, sys.stdout)
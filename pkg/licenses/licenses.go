@@ -0,0 +1,214 @@
+// Package licenses collects the licenses of the Python and system packages installed in a model image, and checks
+// them against the license policy in cog.yaml.
+package licenses
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/dockercontext"
+)
+
+//go:embed collect_licenses.py
+var collectScript string
+
+// ReportPath is where the license report of the last build is written, relative to the project
+var ReportPath = filepath.Join(dockercontext.CogBuildArtifactsFolder, "licenses.json")
+
+// Package is a package installed in the image
+type Package struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// Source is pip or apt
+	Source string `json:"source"`
+	// Licenses are the package's licenses, as they're declared. It's empty if they aren't known.
+	Licenses []string `json:"licenses"`
+}
+
+// Report is the licenses of every package in an image
+type Report struct {
+	Image    string    `json:"image"`
+	Packages []Package `json:"packages"`
+}
+
+// Violation is a package whose license isn't allowed by the policy
+type Violation struct {
+	Package Package
+	Reason  string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s %s (%s): %s", v.Package.Name, v.Package.Version, v.Package.Source, v.Reason)
+}
+
+// Collect runs a script in imageName that reads the licenses of the pip and apt packages installed in it
+func Collect(imageName string) (*Report, error) {
+	var stdout, stderr bytes.Buffer
+	err := docker.RunWithIO(docker.RunOptions{
+		Image: imageName,
+		Args:  []string{"python", "-c", collectScript},
+	}, nil, &stdout, &stderr)
+	if err != nil {
+		return nil, fmt.Errorf("%w\n%s", err, stderr.String())
+	}
+	report := &Report{}
+	if err := json.Unmarshal(stdout.Bytes(), report); err != nil {
+		return nil, fmt.Errorf("Failed to parse licenses: %w", err)
+	}
+	report.Image = imageName
+	sort.Slice(report.Packages, func(i, j int) bool {
+		if report.Packages[i].Source != report.Packages[j].Source {
+			return report.Packages[i].Source > report.Packages[j].Source
+		}
+		return strings.ToLower(report.Packages[i].Name) < strings.ToLower(report.Packages[j].Name)
+	})
+	return report, nil
+}
+
+// Write writes the report to ReportPath in dir
+func (r *Report) Write(dir string) error {
+	path := filepath.Join(dir, ReportPath)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// Unknown returns the packages whose licenses aren't known
+func (r *Report) Unknown() []Package {
+	unknown := []Package{}
+	for _, pkg := range r.Packages {
+		if len(pkg.Licenses) == 0 {
+			unknown = append(unknown, pkg)
+		}
+	}
+	return unknown
+}
+
+// Check returns the packages whose licenses the policy doesn't allow. A package is denied if any of its licenses
+// are denied, because they might apply to different parts of it, and allowed if any of them are allowed. Packages
+// whose licenses aren't known aren't checked.
+func (r *Report) Check(policy *config.Licenses) []Violation {
+	violations := []Violation{}
+	if policy == nil {
+		return violations
+	}
+	ignored := map[string]bool{}
+	for _, name := range policy.Ignore {
+		ignored[strings.ToLower(name)] = true
+	}
+	for _, pkg := range r.Packages {
+		if ignored[strings.ToLower(pkg.Name)] || len(pkg.Licenses) == 0 {
+			continue
+		}
+		ids := []string{}
+		for _, license := range pkg.Licenses {
+			ids = append(ids, Normalize(license)...)
+		}
+		if denied := matchAny(ids, policy.Deny); denied != "" {
+			violations = append(violations, Violation{Package: pkg, Reason: fmt.Sprintf("%s is denied", denied)})
+			continue
+		}
+		if len(policy.Allow) > 0 && matchAny(ids, policy.Allow) == "" {
+			violations = append(violations, Violation{Package: pkg, Reason: fmt.Sprintf("%s isn't allowed", strings.Join(pkg.Licenses, ", "))})
+		}
+	}
+	return violations
+}
+
+// aliases are the SPDX IDs of license names in Python classifiers and Debian copyright files
+var aliases = map[string]string{
+	"mit license":                          "MIT",
+	"expat":                                "MIT",
+	"bsd license":                          "BSD",
+	"apache software license":              "Apache-2.0",
+	"apache license 2.0":                   "Apache-2.0",
+	"apache 2.0":                           "Apache-2.0",
+	"isc license (iscl)":                   "ISC",
+	"python software foundation license":   "PSF-2.0",
+	"mozilla public license 2.0 (mpl 2.0)": "MPL-2.0",
+	"the unlicense (unlicense)":            "Unlicense",
+	"public domain":                        "Public-Domain",
+	"gnu affero general public license v3": "AGPL-3.0",
+	"gnu affero general public license v3 or later (agplv3+)": "AGPL-3.0-or-later",
+	"gnu general public license (gpl)":                        "GPL",
+	"gnu general public license v2 (gplv2)":                   "GPL-2.0",
+	"gnu general public license v2 or later (gplv2+)":         "GPL-2.0-or-later",
+	"gnu general public license v3 (gplv3)":                   "GPL-3.0",
+	"gnu general public license v3 or later (gplv3+)":         "GPL-3.0-or-later",
+	"gnu lesser general public license v2 (lgplv2)":           "LGPL-2.0",
+	"gnu lesser general public license v2 or later (lgplv2+)": "LGPL-2.0-or-later",
+	"gnu lesser general public license v3 (lgplv3)":           "LGPL-3.0",
+	"gnu lesser general public license v3 or later (lgplv3+)": "LGPL-3.0-or-later",
+	"gnu library or lesser general public license (lgpl)":     "LGPL",
+}
+
+var (
+	expressionSeparator = regexp.MustCompile(`(?i)\s*[()/,]\s*(?:(?:or|and|with)\s+)?|\s+(?:or|and|with)\s+`)
+	debianVersion       = regexp.MustCompile(`^(.*-)(\d+)(\.\d+)?(\+?)$`)
+)
+
+// Normalize returns the SPDX IDs in a license name or expression, like "MIT OR Apache-2.0". Debian's short names,
+// like GPL-2+, are converted to SPDX, like GPL-2.0-or-later.
+func Normalize(license string) []string {
+	license = strings.TrimSpace(license)
+	if id, ok := aliases[strings.ToLower(license)]; ok {
+		return []string{id}
+	}
+	ids := []string{}
+	for _, part := range expressionSeparator.Split(license, -1) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if id, ok := aliases[strings.ToLower(part)]; ok {
+			ids = append(ids, id)
+			continue
+		}
+		if m := debianVersion.FindStringSubmatch(part); m != nil && !strings.HasSuffix(part, "-only") {
+			minor := m[3]
+			if minor == "" {
+				minor = ".0"
+			}
+			part = m[1] + m[2] + minor
+			if m[4] == "+" {
+				part += "-or-later"
+			}
+		}
+		ids = append(ids, part)
+	}
+	return ids
+}
+
+// Matches returns whether a license is in a family of licenses, like GPL-2.0-or-later in GPL or GPL-2.0, but not
+// LGPL-2.1 in GPL
+func Matches(license string, family string) bool {
+	license = strings.ToLower(license)
+	family = strings.ToLower(strings.TrimSpace(family))
+	return license == family || strings.HasPrefix(license, family+"-") || strings.HasPrefix(license, family+".")
+}
+
+// matchAny returns the first license in ids that's in one of families, or "" if there aren't any
+func matchAny(ids []string, families []string) string {
+	for _, id := range ids {
+		for _, family := range families {
+			if Matches(id, family) {
+				return id
+			}
+		}
+	}
+	return ""
+}
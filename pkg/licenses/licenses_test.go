@@ -0,0 +1,80 @@
+package licenses
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/config"
+)
+
+func TestNormalize(t *testing.T) {
+	for license, expected := range map[string][]string{
+		"MIT":                                  {"MIT"},
+		"MIT License":                          {"MIT"},
+		"Expat":                                {"MIT"},
+		"Apache Software License":              {"Apache-2.0"},
+		"MIT OR Apache-2.0":                    {"MIT", "Apache-2.0"},
+		"(MIT AND BSD-3-Clause) OR Apache-2.0": {"MIT", "BSD-3-Clause", "Apache-2.0"},
+		"GNU Affero General Public License v3": {"AGPL-3.0"},
+		"GPL-2+":                               {"GPL-2.0-or-later"},
+		"LGPL-2.1+":                            {"LGPL-2.1-or-later"},
+		"GPL-3.0-only":                         {"GPL-3.0-only"},
+		"GPL-2.0-or-later":                     {"GPL-2.0-or-later"},
+		"BSD-3-clause":                         {"BSD-3-clause"},
+	} {
+		require.Equal(t, expected, Normalize(license), license)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	require.True(t, Matches("AGPL-3.0", "AGPL"))
+	require.True(t, Matches("gpl-3.0-or-later", "GPL-3.0"))
+	require.True(t, Matches("GPL-2.0", "gpl"))
+	require.True(t, Matches("MIT", "MIT"))
+	require.False(t, Matches("LGPL-2.1", "GPL"))
+	require.False(t, Matches("GPL-2.0", "GPL-3.0"))
+	require.False(t, Matches("MIT-0", "MIT-CMU"))
+}
+
+func TestCheck(t *testing.T) {
+	report := &Report{Packages: []Package{
+		{Name: "torch", Version: "2.3.0", Source: "pip", Licenses: []string{"BSD-3-Clause"}},
+		{Name: "ultralytics", Version: "8.2.0", Source: "pip", Licenses: []string{"GNU Affero General Public License v3"}},
+		{Name: "dual", Version: "1.0", Source: "pip", Licenses: []string{"MIT OR GPL-3.0"}},
+		{Name: "mystery", Version: "0.1", Source: "pip"},
+		{Name: "libgomp1", Version: "12.2.0", Source: "apt", Licenses: []string{"GPL-3+", "LGPL-2.1+"}},
+	}}
+
+	require.Empty(t, report.Check(nil))
+
+	violations := report.Check(&config.Licenses{Deny: []string{"AGPL", "GPL-3.0"}})
+	require.Len(t, violations, 3)
+	require.Equal(t, "ultralytics 8.2.0 (pip): AGPL-3.0 is denied", violations[0].String())
+	require.Equal(t, "dual", violations[1].Package.Name)
+	require.Equal(t, "libgomp1", violations[2].Package.Name)
+
+	violations = report.Check(&config.Licenses{Deny: []string{"AGPL", "GPL-3.0"}, Ignore: []string{"Dual", "libgomp1"}})
+	require.Len(t, violations, 1)
+
+	violations = report.Check(&config.Licenses{Allow: []string{"MIT", "BSD", "LGPL"}})
+	require.Len(t, violations, 1)
+	require.Equal(t, "ultralytics", violations[0].Package.Name)
+	require.Contains(t, violations[0].Reason, "isn't allowed")
+
+	require.Equal(t, []Package{report.Packages[3]}, report.Unknown())
+}
+
+func TestWrite(t *testing.T) {
+	dir := t.TempDir()
+	report := &Report{Image: "cog-test", Packages: []Package{{Name: "torch", Version: "2.3.0", Source: "pip", Licenses: []string{"BSD-3-Clause"}}}}
+	require.NoError(t, report.Write(dir))
+	data, err := os.ReadFile(filepath.Join(dir, ReportPath))
+	require.NoError(t, err)
+	written := &Report{}
+	require.NoError(t, json.Unmarshal(data, written))
+	require.Equal(t, report, written)
+}
@@ -0,0 +1,480 @@
+package lint
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/replicate/cog/pkg/config"
+)
+
+// Instruction is one instruction in a Dockerfile, with its continuation lines joined
+type Instruction struct {
+	// Line is the line it starts on, from 1
+	Line int
+	// Command is the instruction's name in upper case, like RUN
+	Command string
+	Args    string
+}
+
+// Dockerfile is a Dockerfile passed to cog build --dockerfile, with the config of the model it builds
+type Dockerfile struct {
+	Config       *config.Config
+	Instructions []Instruction
+}
+
+// DockerfileRule checks a Dockerfile for one kind of mistake
+type DockerfileRule struct {
+	Name        string
+	Description string
+	Check       func(d *Dockerfile) []Suggestion
+}
+
+// DockerfileRules are the rules LintDockerfile checks, in order. The first ones are Cog's, and the rest are general
+// good practice, like hadolint's.
+var DockerfileRules = []DockerfileRule{
+	{
+		Name:        "entrypoint-exec-form",
+		Description: "ENTRYPOINT is in exec form, so the commands Cog runs in the image are passed to it",
+		Check:       checkEntrypointExecForm,
+	},
+	{
+		Name:        "cmd-cog-server",
+		Description: "CMD starts Cog's HTTP server, so cog predict and cog serve can run the model",
+		Check:       checkCmdCogServer,
+	},
+	{
+		Name:        "expose-port",
+		Description: "EXPOSE includes port 5000, which Cog's HTTP server listens on",
+		Check:       checkExposePort,
+	},
+	{
+		Name:        "src-layout",
+		Description: "The model's code is copied to /src, and WORKDIR is /src",
+		Check:       checkSrcLayout,
+	},
+	{
+		Name:        "cog-labels",
+		Description: "LABEL doesn't set labels Cog sets after building",
+		Check:       checkCogLabels,
+	},
+	{
+		Name:        "base-image-tag",
+		Description: "Base images are pinned to a version",
+		Check:       checkBaseImageTag,
+	},
+	{
+		Name:        "apt-get",
+		Description: "apt-get install has --no-install-recommends, and cleans up the package lists",
+		Check:       checkAptGet,
+	},
+	{
+		Name:        "pip-no-cache",
+		Description: "pip install doesn't keep its cache in the image",
+		Check:       checkPipNoCache,
+	},
+	{
+		Name:        "add-local",
+		Description: "COPY is used instead of ADD for local files",
+		Check:       checkAddLocal,
+	},
+	{
+		Name:        "workdir-absolute",
+		Description: "WORKDIR is an absolute path",
+		Check:       checkWorkdirAbsolute,
+	},
+	{
+		Name:        "sudo",
+		Description: "RUN doesn't use sudo",
+		Check:       checkSudo,
+	},
+}
+
+// LintDockerfile checks the Dockerfile with contents, which builds the model with cfg, against DockerfileRules
+func LintDockerfile(cfg *config.Config, contents string) []Suggestion {
+	d := &Dockerfile{Config: cfg, Instructions: ParseDockerfile(contents)}
+	suggestions := []Suggestion{}
+	for _, rule := range DockerfileRules {
+		for _, s := range rule.Check(d) {
+			s.Rule = rule.Name
+			suggestions = append(suggestions, s)
+		}
+	}
+	return suggestions
+}
+
+var heredocPattern = regexp.MustCompile(`<<-?["']?([A-Za-z_][A-Za-z0-9_]*)["']?`)
+
+// ParseDockerfile splits a Dockerfile into its instructions. Comments are skipped, lines ending in a backslash are
+// joined with the next, and here-documents are included in the instruction they're in.
+func ParseDockerfile(contents string) []Instruction {
+	lines := strings.Split(strings.ReplaceAll(contents, "\r\n", "\n"), "\n")
+	instructions := []Instruction{}
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		start := i
+		for strings.HasSuffix(line, "\\") && i+1 < len(lines) {
+			i++
+			next := strings.TrimSpace(lines[i])
+			if strings.HasPrefix(next, "#") {
+				continue
+			}
+			line = strings.TrimSuffix(line, "\\") + " " + next
+		}
+		if match := heredocPattern.FindStringSubmatch(line); match != nil {
+			for i+1 < len(lines) {
+				i++
+				if strings.TrimSpace(lines[i]) == match[1] {
+					break
+				}
+				line += "\n" + lines[i]
+			}
+		}
+		command, args, _ := strings.Cut(line, " ")
+		instructions = append(instructions, Instruction{Line: start + 1, Command: strings.ToUpper(command), Args: strings.TrimSpace(args)})
+	}
+	return instructions
+}
+
+// stage is a build stage, from its FROM to the next one
+type stage struct {
+	from         Instruction
+	base         string
+	name         string
+	instructions []Instruction
+}
+
+func (d *Dockerfile) stages() []stage {
+	stages := []stage{}
+	for _, in := range d.Instructions {
+		if in.Command == "FROM" {
+			fields := withoutFlags(strings.Fields(in.Args))
+			s := stage{from: in}
+			if len(fields) > 0 {
+				s.base = fields[0]
+			}
+			if len(fields) == 3 && strings.EqualFold(fields[1], "AS") {
+				s.name = strings.ToLower(fields[2])
+			}
+			stages = append(stages, s)
+			continue
+		}
+		if len(stages) > 0 {
+			stages[len(stages)-1].instructions = append(stages[len(stages)-1].instructions, in)
+		}
+	}
+	return stages
+}
+
+// finalInstructions returns the instructions that make the image, which are the last stage's and the stages it's
+// built from, in the order they run
+func (d *Dockerfile) finalInstructions() []Instruction {
+	stages := d.stages()
+	if len(stages) == 0 {
+		return nil
+	}
+	chain := []stage{stages[len(stages)-1]}
+	for {
+		base := strings.ToLower(chain[0].base)
+		found := false
+		for i := len(stages) - 2; i >= 0; i-- {
+			if stages[i].name != "" && stages[i].name == base && stages[i].from.Line < chain[0].from.Line {
+				chain = append([]stage{stages[i]}, chain...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			break
+		}
+	}
+	instructions := []Instruction{}
+	for _, s := range chain {
+		instructions = append(instructions, s.instructions...)
+	}
+	return instructions
+}
+
+// last returns the last of instructions with command, and false if there isn't one
+func last(instructions []Instruction, command string) (Instruction, bool) {
+	for i := len(instructions) - 1; i >= 0; i-- {
+		if instructions[i].Command == command {
+			return instructions[i], true
+		}
+	}
+	return Instruction{}, false
+}
+
+// withoutFlags removes flags like --platform=linux/amd64 from the start of an instruction's arguments
+func withoutFlags(fields []string) []string {
+	for len(fields) > 0 && strings.HasPrefix(fields[0], "--") {
+		fields = fields[1:]
+	}
+	return fields
+}
+
+// execForm returns the arguments of an instruction in exec form, like ["python", "-m", "cog.server.http"], and false
+// if it's in shell form
+func execForm(args string) ([]string, bool) {
+	if !strings.HasPrefix(args, "[") {
+		return nil, false
+	}
+	var values []string
+	if err := json.Unmarshal([]byte(args), &values); err != nil {
+		return nil, false
+	}
+	return values, true
+}
+
+func checkEntrypointExecForm(d *Dockerfile) []Suggestion {
+	entrypoint, ok := last(d.finalInstructions(), "ENTRYPOINT")
+	if !ok {
+		return nil
+	}
+	if _, ok := execForm(entrypoint.Args); ok {
+		return nil
+	}
+	return []Suggestion{{
+		Line:    entrypoint.Line,
+		Error:   true,
+		Message: "ENTRYPOINT is in shell form, so it ignores the commands Cog runs in the image, like the one that gets the model's schema",
+		Fix:     `Use the exec form, like ENTRYPOINT ["/sbin/tini", "--"]`,
+	}}
+}
+
+func checkCmdCogServer(d *Dockerfile) []Suggestion {
+	if d.Config == nil || d.Config.PredictorLanguage() != config.LanguagePython || d.Config.Build.ServerCommand != "" {
+		return nil
+	}
+	cmd, ok := last(d.finalInstructions(), "CMD")
+	if !ok || strings.Contains(cmd.Args, "cog.server.http") {
+		return nil
+	}
+	return []Suggestion{{
+		Line:    cmd.Line,
+		Message: "CMD doesn't start Cog's HTTP server, so cog predict and cog serve can't run the model",
+		Fix:     `Use CMD ["python", "-m", "cog.server.http"]`,
+	}}
+}
+
+func checkExposePort(d *Dockerfile) []Suggestion {
+	expose, ok := last(d.finalInstructions(), "EXPOSE")
+	if !ok {
+		return nil
+	}
+	for _, port := range strings.Fields(expose.Args) {
+		if port == "5000" || port == "5000/tcp" {
+			return nil
+		}
+	}
+	return []Suggestion{{
+		Line:    expose.Line,
+		Message: fmt.Sprintf("EXPOSE %s doesn't include port 5000, which Cog's HTTP server listens on", expose.Args),
+		Fix:     "Use EXPOSE 5000",
+	}}
+}
+
+func checkSrcLayout(d *Dockerfile) []Suggestion {
+	instructions := d.finalInstructions()
+	if len(instructions) == 0 {
+		return nil
+	}
+	suggestions := []Suggestion{}
+	workdir := "/"
+	copied := false
+	for _, in := range instructions {
+		switch in.Command {
+		case "WORKDIR":
+			workdir = strings.TrimSuffix(in.Args, "/")
+		case "COPY", "ADD":
+			fields := withoutFlags(strings.Fields(in.Args))
+			if values, ok := execForm(in.Args); ok {
+				fields = values
+			}
+			if len(fields) < 2 {
+				continue
+			}
+			dest := strings.TrimSuffix(fields[len(fields)-1], "/")
+			if dest == "/src" || ((dest == "." || dest == "") && workdir == "/src") {
+				copied = true
+			}
+		}
+	}
+	if workdir != "/src" {
+		suggestions = append(suggestions, Suggestion{
+			Line:    instructions[len(instructions)-1].Line,
+			Message: "The image's WORKDIR isn't /src, where Cog expects the model's code",
+			Fix:     "Add WORKDIR /src",
+		})
+	}
+	if !copied {
+		suggestions = append(suggestions, Suggestion{
+			Line:    instructions[len(instructions)-1].Line,
+			Message: "The model's code isn't copied to /src, so the image can only run it when cog predict mounts the project there",
+			Fix:     "Add COPY . /src",
+		})
+	}
+	return suggestions
+}
+
+func checkCogLabels(d *Dockerfile) []Suggestion {
+	suggestions := []Suggestion{}
+	for _, in := range d.Instructions {
+		if in.Command != "LABEL" {
+			continue
+		}
+		for _, field := range strings.Fields(in.Args) {
+			key, _, _ := strings.Cut(strings.Trim(field, `"`), "=")
+			if strings.HasPrefix(key, "run.cog.") || strings.HasPrefix(key, "org.cogmodel.") {
+				suggestions = append(suggestions, Suggestion{
+					Line:    in.Line,
+					Message: fmt.Sprintf("The %s label is set by Cog after building, so this one is replaced", key),
+					Fix:     "Remove it from the Dockerfile",
+				})
+			}
+		}
+	}
+	return suggestions
+}
+
+func checkBaseImageTag(d *Dockerfile) []Suggestion {
+	suggestions := []Suggestion{}
+	stageNames := map[string]bool{}
+	for _, s := range d.stages() {
+		image := s.base
+		switch {
+		case image == "", strings.EqualFold(image, "scratch"), stageNames[strings.ToLower(image)], strings.Contains(image, "$"), strings.Contains(image, "@"):
+		default:
+			tag := ""
+			if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+				tag = image[i+1:]
+			}
+			if tag == "" || tag == "latest" {
+				suggestions = append(suggestions, Suggestion{
+					Line:    s.from.Line,
+					Message: fmt.Sprintf("FROM %s uses the latest version of the image, which changes without the Dockerfile changing", image),
+					Fix:     "Pin it to a version or a digest",
+				})
+			}
+		}
+		if s.name != "" {
+			stageNames[s.name] = true
+		}
+	}
+	return suggestions
+}
+
+// runs returns the RUN instructions in the Dockerfile that match pattern
+func (d *Dockerfile) runs(pattern *regexp.Regexp) []Instruction {
+	runs := []Instruction{}
+	for _, in := range d.Instructions {
+		if in.Command == "RUN" && pattern.MatchString(in.Args) {
+			runs = append(runs, in)
+		}
+	}
+	return runs
+}
+
+var aptInstallPattern = regexp.MustCompile(`\bapt(-get)? +(-\S+ +)*install\b`)
+
+func checkAptGet(d *Dockerfile) []Suggestion {
+	suggestions := []Suggestion{}
+	for _, run := range d.runs(aptInstallPattern) {
+		if !strings.Contains(run.Args, "--no-install-recommends") {
+			suggestions = append(suggestions, Suggestion{
+				Line:    run.Line,
+				Message: "apt-get install installs recommended packages too, which makes the image larger",
+				Fix:     "Add --no-install-recommends",
+			})
+		}
+		if !strings.Contains(run.Args, "/var/lib/apt/lists") {
+			suggestions = append(suggestions, Suggestion{
+				Line:    run.Line,
+				Message: "apt-get's package lists are left in the image, which makes it larger",
+				Fix:     "Add && rm -rf /var/lib/apt/lists/* to the end of the RUN instruction",
+			})
+		}
+	}
+	return suggestions
+}
+
+var pipInstallPattern = regexp.MustCompile(`\bpip3? +install\b`)
+
+func checkPipNoCache(d *Dockerfile) []Suggestion {
+	suggestions := []Suggestion{}
+	for _, run := range d.runs(pipInstallPattern) {
+		if strings.Contains(run.Args, "--no-cache-dir") || strings.Contains(run.Args, "--mount=type=cache") || strings.Contains(run.Args, "PIP_NO_CACHE_DIR") {
+			continue
+		}
+		suggestions = append(suggestions, Suggestion{
+			Line:    run.Line,
+			Message: "pip install keeps the packages it downloads in a cache in the image, which makes it larger",
+			Fix:     "Add --no-cache-dir, or mount a cache with RUN --mount=type=cache,target=/root/.cache/pip",
+		})
+	}
+	return suggestions
+}
+
+var archivePattern = regexp.MustCompile(`\.(tar|tar\.gz|tgz|tar\.bz2|tbz2|tar\.xz|txz)$`)
+
+func checkAddLocal(d *Dockerfile) []Suggestion {
+	suggestions := []Suggestion{}
+	for _, in := range d.Instructions {
+		if in.Command != "ADD" {
+			continue
+		}
+		fields := withoutFlags(strings.Fields(in.Args))
+		if values, ok := execForm(in.Args); ok {
+			fields = values
+		}
+		if len(fields) < 2 {
+			continue
+		}
+		local := true
+		for _, source := range fields[:len(fields)-1] {
+			if strings.Contains(source, "://") || strings.HasPrefix(source, "git@") || archivePattern.MatchString(source) {
+				local = false
+			}
+		}
+		if local {
+			suggestions = append(suggestions, Suggestion{
+				Line:    in.Line,
+				Message: "ADD is used to copy local files, which COPY does more predictably",
+				Fix:     "Use COPY",
+			})
+		}
+	}
+	return suggestions
+}
+
+func checkWorkdirAbsolute(d *Dockerfile) []Suggestion {
+	suggestions := []Suggestion{}
+	for _, in := range d.Instructions {
+		if in.Command == "WORKDIR" && !strings.HasPrefix(in.Args, "/") && !strings.HasPrefix(in.Args, "$") {
+			suggestions = append(suggestions, Suggestion{
+				Line:    in.Line,
+				Message: fmt.Sprintf("WORKDIR %s is relative to the previous WORKDIR, which is easy to get wrong", in.Args),
+				Fix:     "Use an absolute path",
+			})
+		}
+	}
+	return suggestions
+}
+
+var sudoPattern = regexp.MustCompile(`(^|[\s;&|])sudo\s`)
+
+func checkSudo(d *Dockerfile) []Suggestion {
+	suggestions := []Suggestion{}
+	for _, run := range d.runs(sudoPattern) {
+		suggestions = append(suggestions, Suggestion{
+			Line:    run.Line,
+			Message: "RUN uses sudo, which behaves unpredictably in containers",
+			Fix:     "Remove sudo, and switch users with USER if you need to",
+		})
+	}
+	return suggestions
+}
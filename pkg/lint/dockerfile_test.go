@@ -0,0 +1,81 @@
+package lint
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/config"
+)
+
+func TestParseDockerfile(t *testing.T) {
+	instructions := ParseDockerfile(`# syntax=docker/dockerfile:1.4
+FROM python:3.11-slim AS deps
+
+RUN apt-get update && \
+    # a comment in a continuation
+    apt-get install -y git
+COPY <<EOF /src/hello.txt
+hello
+EOF
+cmd ["python"]
+`)
+	require.Equal(t, []Instruction{
+		{Line: 2, Command: "FROM", Args: "python:3.11-slim AS deps"},
+		{Line: 4, Command: "RUN", Args: "apt-get update &&  apt-get install -y git"},
+		{Line: 7, Command: "COPY", Args: "<<EOF /src/hello.txt\nhello"},
+		{Line: 10, Command: "CMD", Args: `["python"]`},
+	}, instructions)
+}
+
+func ruleLines(suggestions []Suggestion) map[string]int {
+	lines := map[string]int{}
+	for _, s := range suggestions {
+		lines[s.Rule] = s.Line
+	}
+	return lines
+}
+
+func TestLintDockerfile(t *testing.T) {
+	cfg := &config.Config{Build: &config.Build{}, Predict: "predict.py:Predictor"}
+
+	good := `FROM python:3.11-slim AS deps
+RUN --mount=type=cache,target=/root/.cache/pip pip install torch==2.3.1
+RUN apt-get update && apt-get install -y --no-install-recommends git && rm -rf /var/lib/apt/lists/*
+FROM deps AS model
+WORKDIR /src
+EXPOSE 5000
+ENTRYPOINT ["/sbin/tini", "--"]
+CMD ["python", "-m", "cog.server.http"]
+COPY . /src
+`
+	require.Empty(t, LintDockerfile(cfg, good))
+
+	bad := `FROM ubuntu
+RUN sudo apt-get install -y git
+RUN pip install torch
+ADD weights /weights
+LABEL run.cog.config="{}"
+WORKDIR app
+EXPOSE 8080
+ENTRYPOINT python serve.py
+CMD ["python", "serve.py"]
+`
+	suggestions := LintDockerfile(cfg, bad)
+	require.Equal(t, map[string]int{
+		"entrypoint-exec-form": 8,
+		"cmd-cog-server":       9,
+		"expose-port":          7,
+		"src-layout":           9,
+		"cog-labels":           5,
+		"base-image-tag":       1,
+		"apt-get":              2,
+		"pip-no-cache":         3,
+		"add-local":            4,
+		"workdir-absolute":     6,
+		"sudo":                 2,
+	}, ruleLines(suggestions))
+	for _, s := range suggestions {
+		require.Equal(t, s.Rule == "entrypoint-exec-form", s.Error, s.Rule)
+	}
+}
@@ -0,0 +1,264 @@
+// Package lint finds common mistakes in a model's cog.yaml and the files in its project, like a version of torch that
+// isn't built for the CUDA that's set, and suggests how to fix them. Suggestions are shown on build and by `cog lint`.
+package lint
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/go-units"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/dockerfile"
+	"github.com/replicate/cog/pkg/dockerignore"
+	"github.com/replicate/cog/pkg/util/version"
+	"github.com/replicate/cog/pkg/weights"
+)
+
+// LargeFileSize is the size of files in the build context that are worth a suggestion if Cog doesn't treat them as
+// weights
+const LargeFileSize = 100 * 1024 * 1024
+
+// Suggestion is a mistake found in a model, and how to fix it
+type Suggestion struct {
+	// Rule is the name of the rule that found it
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+	Fix     string `json:"fix"`
+	// Line is the line of the Dockerfile it was found on, for Dockerfile rules
+	Line int `json:"line,omitempty"`
+	// Error is whether it makes the model unusable, rather than worse, so it fails the build
+	Error bool `json:"error,omitempty"`
+}
+
+func (s Suggestion) String() string {
+	if s.Line > 0 {
+		return fmt.Sprintf("Line %d: %s. %s.", s.Line, s.Message, s.Fix)
+	}
+	return fmt.Sprintf("%s. %s.", s.Message, s.Fix)
+}
+
+// Model is what rules check: the model's resolved config and the files in its project
+type Model struct {
+	Config *config.Config
+	Dir    string
+
+	files []dockerignore.ContextFile
+}
+
+// Rule checks a model for one kind of mistake
+type Rule struct {
+	Name        string
+	Description string
+	Check       func(m *Model) ([]Suggestion, error)
+}
+
+// Rules are the rules Lint checks, in order
+var Rules = []Rule{
+	{
+		Name:        "torch-cuda",
+		Description: "The version of torch in the requirements is built for the CUDA in cog.yaml",
+		Check:       checkTorchCUDA,
+	},
+	{
+		Name:        "large-files",
+		Description: "Large files in the build context are weights, or excluded with .dockerignore",
+		Check:       checkLargeFiles,
+	},
+	{
+		Name:        "dockerignore-git",
+		Description: ".git is excluded from the build context with .dockerignore",
+		Check:       checkDockerignoreGit,
+	},
+	{
+		Name:        "python-version",
+		Description: "There's a cog base image for build.python_version",
+		Check:       checkPythonVersion,
+	},
+}
+
+// Lint checks the model with cfg, which has been completed, in dir, against Rules
+func Lint(cfg *config.Config, dir string) ([]Suggestion, error) {
+	m := &Model{Config: cfg, Dir: dir}
+	suggestions := []Suggestion{}
+	for _, rule := range Rules {
+		found, err := rule.Check(m)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to check %s: %w", rule.Name, err)
+		}
+		for _, s := range found {
+			s.Rule = rule.Name
+			suggestions = append(suggestions, s)
+		}
+	}
+	return suggestions, nil
+}
+
+// contextFiles returns the files in the project, with the .dockerignore rule that excludes them from the build context
+func (m *Model) contextFiles() ([]dockerignore.ContextFile, error) {
+	if m.files == nil {
+		files, err := dockerignore.WalkContext(m.Dir)
+		if err != nil {
+			return nil, err
+		}
+		m.files = files
+	}
+	return m.files, nil
+}
+
+func checkTorchCUDA(m *Model) ([]Suggestion, error) {
+	if !m.Config.Build.GPU || m.Config.Build.CUDA == "" {
+		return nil, nil
+	}
+	torchVersion, torchCUDAs, err := m.Config.TorchCUDAs()
+	if err != nil || torchVersion == "" || len(torchCUDAs) == 0 {
+		// If Cog doesn't know torch's CUDAs, completing the config would have failed
+		return nil, nil
+	}
+	latest := ""
+	for _, cuda := range torchCUDAs {
+		if version.EqualMinor(cuda, m.Config.Build.CUDA) {
+			return nil, nil
+		}
+		if latest == "" || version.Greater(cuda, latest) {
+			latest = cuda
+		}
+	}
+	return []Suggestion{{
+		Message: fmt.Sprintf("torch==%s isn't built for CUDA %s, which is set by build.cuda, so the model might not be able to use the GPU", torchVersion, m.Config.Build.CUDA),
+		Fix:     fmt.Sprintf("Set build.cuda to %s, or remove it so Cog picks a CUDA torch is built for", latest),
+	}}, nil
+}
+
+func checkLargeFiles(m *Model) ([]Suggestion, error) {
+	files, err := m.contextFiles()
+	if err != nil {
+		return nil, err
+	}
+	weightDirs, weightFiles, err := weights.FindWeights(weights.RelativeFileWalker(m.Dir))
+	if err != nil {
+		return nil, err
+	}
+	isWeights := func(path string) bool {
+		for _, file := range weightFiles {
+			if filepath.ToSlash(file) == path {
+				return true
+			}
+		}
+		for _, dir := range weightDirs {
+			if strings.HasPrefix(path, filepath.ToSlash(dir)+"/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	suggestions := []Suggestion{}
+	for _, file := range files {
+		if file.Rule != nil || file.Size < LargeFileSize || strings.HasPrefix(file.Path, ".git/") || strings.HasPrefix(file.Path, ".cog/") || isWeights(file.Path) {
+			continue
+		}
+		suggestions = append(suggestions, Suggestion{
+			Message: fmt.Sprintf("%s is %s, and Cog doesn't treat it as weights, so it's copied into the image with the model's code and rebuilt with it", file.Path, units.HumanSize(float64(file.Size))),
+			Fix:     "Exclude it with .dockerignore if the model doesn't need it, or move it to a directory of weights without code in it",
+		})
+	}
+	return suggestions, nil
+}
+
+func checkDockerignoreGit(m *Model) ([]Suggestion, error) {
+	files, err := m.contextFiles()
+	if err != nil {
+		return nil, err
+	}
+	var size int64
+	sent := false
+	for _, file := range files {
+		if strings.HasPrefix(file.Path, ".git/") && file.Rule == nil {
+			size += file.Size
+			sent = true
+		}
+	}
+	if !sent {
+		return nil, nil
+	}
+	fix := "Add .git to .dockerignore"
+	rules, err := dockerignore.Rules(m.Dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		fix = "Create a .dockerignore with .git in it"
+	}
+	return []Suggestion{{
+		Message: fmt.Sprintf(".git (%s) is sent to the build context, which slows down builds and can put the repository's history in the image", units.HumanSize(float64(size))),
+		Fix:     fix,
+	}}, nil
+}
+
+func checkPythonVersion(m *Model) ([]Suggestion, error) {
+	build := m.Config.Build
+	if build.CogBaseImage != "" || build.PythonVersion == "" {
+		return nil, nil
+	}
+	cuda := ""
+	if build.GPU {
+		cuda = build.CUDA
+	}
+	torchVersion, _ := m.Config.TorchVersion()
+	pythonVersion := version.StripPatch(build.PythonVersion)
+	if hasCogBaseImage(cuda, pythonVersion, torchVersion) {
+		return nil, nil
+	}
+
+	supported := map[string]bool{}
+	for _, conf := range dockerfile.BaseImageConfigurations() {
+		if hasCogBaseImage(cuda, conf.PythonVersion, torchVersion) {
+			supported[conf.PythonVersion] = true
+		}
+	}
+	versions := make([]string, 0, len(supported))
+	for v := range supported {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return version.Greater(versions[j], versions[i]) })
+
+	what := "Python " + pythonVersion
+	if torchVersion != "" {
+		what += " with torch==" + torchVersion
+	}
+	if cuda != "" {
+		what += " and CUDA " + cuda
+	}
+	pinned := "Python and torch"
+	if cuda != "" {
+		pinned = "Python, torch and CUDA"
+	}
+	fix := fmt.Sprintf("Pin versions of %s there are cog base images for, or set build.cog_base_image", pinned)
+	if len(versions) > 0 {
+		fix = fmt.Sprintf("Set build.python_version to one there are cog base images for: %s", strings.Join(versions, ", "))
+	}
+	return []Suggestion{{
+		Message: fmt.Sprintf("There's no cog base image for %s, so builds can't use one", what),
+		Fix:     fix,
+	}}, nil
+}
+
+// hasCogBaseImage returns whether there's a cog base image for the versions of CUDA, Python and torch. They're empty
+// if they aren't used.
+func hasCogBaseImage(cuda string, python string, torch string) bool {
+	matches := func(confVersion string, requested string) bool {
+		if confVersion == "" || requested == "" {
+			return confVersion == requested
+		}
+		return version.Matches(requested, confVersion)
+	}
+	for _, conf := range dockerfile.BaseImageConfigurations() {
+		if matches(conf.CUDAVersion, cuda) && matches(conf.PythonVersion, python) && matches(conf.TorchVersion, torch) {
+			return true
+		}
+	}
+	return false
+}
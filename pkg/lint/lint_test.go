@@ -0,0 +1,116 @@
+package lint
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/config"
+)
+
+func writeProject(t *testing.T, files map[string]string) (*config.Config, string) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(contents), 0o644))
+	}
+	cfg, _, err := config.GetConfig(dir)
+	require.NoError(t, err)
+	return cfg, dir
+}
+
+func rules(suggestions []Suggestion) []string {
+	names := []string{}
+	for _, s := range suggestions {
+		names = append(names, s.Rule)
+	}
+	return names
+}
+
+func TestLintClean(t *testing.T) {
+	cfg, dir := writeProject(t, map[string]string{
+		"cog.yaml":   "build:\n  python_version: \"3.11\"\npredict: predict.py:Predictor\n",
+		"predict.py": "",
+	})
+	suggestions, err := Lint(cfg, dir)
+	require.NoError(t, err)
+	require.Empty(t, suggestions)
+}
+
+func TestLintTorchCUDA(t *testing.T) {
+	cfg, dir := writeProject(t, map[string]string{
+		"cog.yaml":         "build:\n  gpu: true\n  cuda: \"12.4\"\n  python_version: \"3.11\"\n  python_requirements: requirements.txt\n",
+		"requirements.txt": "torch==2.1.0\n",
+	})
+	suggestions, err := checkTorchCUDA(&Model{Config: cfg, Dir: dir})
+	require.NoError(t, err)
+	require.Len(t, suggestions, 1)
+	require.Equal(t, "torch==2.1.0 isn't built for CUDA 12.4, which is set by build.cuda, so the model might not be able to use the GPU", suggestions[0].Message)
+	require.Equal(t, "Set build.cuda to 12.1, or remove it so Cog picks a CUDA torch is built for", suggestions[0].Fix)
+
+	cfg.Build.CUDA = "12.1"
+	suggestions, err = checkTorchCUDA(&Model{Config: cfg, Dir: dir})
+	require.NoError(t, err)
+	require.Empty(t, suggestions)
+}
+
+func TestLintLargeFiles(t *testing.T) {
+	cfg, dir := writeProject(t, map[string]string{
+		"cog.yaml":      "build:\n  python_version: \"3.11\"\n",
+		".dockerignore": "ignored.mp4\n",
+	})
+	for _, name := range []string{"clip.mp4", "ignored.mp4", "weights/model.safetensors"} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		f, err := os.Create(path)
+		require.NoError(t, err)
+		require.NoError(t, f.Truncate(LargeFileSize))
+		require.NoError(t, f.Close())
+	}
+
+	suggestions, err := checkLargeFiles(&Model{Config: cfg, Dir: dir})
+	require.NoError(t, err)
+	require.Len(t, suggestions, 1)
+	require.Contains(t, suggestions[0].Message, "clip.mp4 is 104.9MB")
+}
+
+func TestLintDockerignoreGit(t *testing.T) {
+	cfg, dir := writeProject(t, map[string]string{
+		"cog.yaml":  "build:\n  python_version: \"3.11\"\n",
+		".git/HEAD": "ref: refs/heads/main\n",
+	})
+	suggestions, err := Lint(cfg, dir)
+	require.NoError(t, err)
+	require.Equal(t, []string{"dockerignore-git"}, rules(suggestions))
+	require.Equal(t, "Create a .dockerignore with .git in it", suggestions[0].Fix)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".dockerignore"), []byte("*.log\n"), 0o644))
+	suggestions, err = checkDockerignoreGit(&Model{Config: cfg, Dir: dir})
+	require.NoError(t, err)
+	require.Equal(t, "Add .git to .dockerignore", suggestions[0].Fix)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".dockerignore"), []byte(".git\n"), 0o644))
+	suggestions, err = checkDockerignoreGit(&Model{Config: cfg, Dir: dir})
+	require.NoError(t, err)
+	require.Empty(t, suggestions)
+}
+
+func TestLintPythonVersion(t *testing.T) {
+	cfg, dir := writeProject(t, map[string]string{
+		"cog.yaml": "build:\n  python_version: \"3.7\"\n",
+	})
+	suggestions, err := checkPythonVersion(&Model{Config: cfg, Dir: dir})
+	require.NoError(t, err)
+	require.Len(t, suggestions, 1)
+	require.Equal(t, "There's no cog base image for Python 3.7, so builds can't use one", suggestions[0].Message)
+	require.Contains(t, suggestions[0].Fix, "Set build.python_version to one there are cog base images for: 3.8, ")
+
+	cfg.Build.CogBaseImage = "r8.im/someone/base"
+	suggestions, err = checkPythonVersion(&Model{Config: cfg, Dir: dir})
+	require.NoError(t, err)
+	require.Empty(t, suggestions)
+}
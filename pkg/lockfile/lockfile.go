@@ -0,0 +1,66 @@
+package lockfile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Filename is the lockfile that `cog build` writes next to cog.yaml
+const Filename = "cog.lock"
+
+// Lockfile records what a build's dependencies resolved to, so they can be reviewed in version control
+// and the same versions can be installed again later
+type Lockfile struct {
+	// BaseImage is the cog base image the model was built on, so later builds use the same base image
+	// rather than whatever its tag points to by then
+	BaseImage *Image `json:"base_image,omitempty"`
+	// Wheels are the PyTorch wheels installed in the image
+	Wheels []Wheel `json:"wheels,omitempty"`
+}
+
+// Image is a Docker image tag and the digest it resolved to
+type Image struct {
+	Name   string `json:"name"`
+	Digest string `json:"digest"`
+}
+
+// Reference returns a reference to the image that's pinned to its digest, like r8.im/cog-base:python3.12@sha256:...
+func (i *Image) Reference() string {
+	return i.Name + "@" + i.Digest
+}
+
+// Wheel is a Python wheel that a package was installed from
+type Wheel struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	URL     string `json:"url"`
+	SHA256  string `json:"sha256,omitempty"`
+}
+
+// Load reads the lockfile in dir. It returns an empty lockfile if there isn't one.
+func Load(dir string) (*Lockfile, error) {
+	contents, err := os.ReadFile(filepath.Join(dir, Filename))
+	if errors.Is(err, os.ErrNotExist) {
+		return &Lockfile{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lockfile := &Lockfile{}
+	if err := json.Unmarshal(contents, lockfile); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %w", Filename, err)
+	}
+	return lockfile, nil
+}
+
+// Save writes the lockfile to dir
+func (l *Lockfile) Save(dir string) error {
+	contents, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, Filename), append(contents, '\n'), 0o644)
+}
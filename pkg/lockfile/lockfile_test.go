@@ -0,0 +1,74 @@
+package lockfile
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadAndSave(t *testing.T) {
+	dir := t.TempDir()
+
+	lockfile, err := Load(dir)
+	require.NoError(t, err)
+	require.Empty(t, lockfile.Wheels)
+
+	require.Nil(t, lockfile.BaseImage)
+
+	lockfile.BaseImage = &Image{Name: "r8.im/cog-base:python3.12", Digest: "sha256:abc"}
+	lockfile.Wheels = []Wheel{{Name: "torch", Version: "2.5.1+cu121", URL: "https://download.pytorch.org/whl/cu121/torch-2.5.1%2Bcu121-cp311-cp311-linux_x86_64.whl", SHA256: "abc"}}
+	require.NoError(t, lockfile.Save(dir))
+
+	loaded, err := Load(dir)
+	require.NoError(t, err)
+	require.Equal(t, lockfile, loaded)
+}
+
+func TestIndexURLForVersion(t *testing.T) {
+	require.Equal(t, "https://download.pytorch.org/whl/cu121", IndexURLForVersion("2.5.1+cu121"))
+	require.Equal(t, "https://download.pytorch.org/whl/cpu", IndexURLForVersion("2.5.1+cpu"))
+	require.Equal(t, "https://pypi.org/simple", IndexURLForVersion("2.5.1"))
+}
+
+func TestResolveWheel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/whl/cu121/torch/", r.URL.Path)
+		fmt.Fprint(w, `<!DOCTYPE html>
+<html><body>
+<a href="/whl/cu121/torch-2.5.1%2Bcu121-cp310-cp310-linux_x86_64.whl#sha256=111">torch-2.5.1+cu121-cp310-cp310-linux_x86_64.whl</a><br/>
+<a href="/whl/cu121/torch-2.5.1%2Bcu121-cp311-cp311-linux_x86_64.whl#sha256=222">torch-2.5.1+cu121-cp311-cp311-linux_x86_64.whl</a><br/>
+<a href="/whl/cu121/torch-2.5.1%2Bcu121-cp311-cp311-manylinux_2_28_aarch64.whl#sha256=444">torch-2.5.1+cu121-cp311-cp311-manylinux_2_28_aarch64.whl</a><br/>
+<a href="/whl/cu121/torch-2.5.1%2Bcu121-cp311-cp311-win_amd64.whl#sha256=333">torch-2.5.1+cu121-cp311-cp311-win_amd64.whl</a><br/>
+</body></html>`)
+	}))
+	defer server.Close()
+
+	wheel, err := ResolveWheel(server.URL+"/whl/cu121", "torch", "2.5.1+cu121", "3.11", "linux/amd64")
+	require.NoError(t, err)
+	require.Equal(t, &Wheel{
+		Name:    "torch",
+		Version: "2.5.1+cu121",
+		URL:     server.URL + "/whl/cu121/torch-2.5.1%2Bcu121-cp311-cp311-linux_x86_64.whl",
+		SHA256:  "222",
+	}, wheel)
+
+	_, err = ResolveWheel(server.URL+"/whl/cu121", "torch", "2.5.1+cu121", "3.12", "linux/amd64")
+	require.ErrorContains(t, err, "No wheel for torch==2.5.1+cu121 and Python 3.12")
+
+	wheel, err = ResolveWheel(server.URL+"/whl/cu121", "torch", "2.5.1+cu121", "3.11", "linux/arm64")
+	require.NoError(t, err)
+	require.Equal(t, server.URL+"/whl/cu121/torch-2.5.1%2Bcu121-cp311-cp311-manylinux_2_28_aarch64.whl", wheel.URL)
+	require.True(t, wheel.IsForPlatform("linux/arm64"))
+	require.False(t, wheel.IsForPlatform("linux/amd64"))
+}
+
+func TestWheelMatches(t *testing.T) {
+	require.True(t, wheelMatches("torch-2.5.1-cp312-cp312-manylinux1_x86_64.whl", "torch", "2.5.1", "cp312", "x86_64"))
+	require.True(t, wheelMatches("torch_audio-2.5.1-1-cp312-cp312-manylinux1_x86_64.whl", "torch-audio", "2.5.1", "cp312", "x86_64"))
+	require.False(t, wheelMatches("torch-2.5.1-cp312-cp312-manylinux2014_aarch64.whl", "torch", "2.5.1", "cp312", "x86_64"))
+	require.True(t, wheelMatches("torch-2.5.1-cp312-cp312-manylinux2014_aarch64.whl", "torch", "2.5.1", "cp312", "aarch64"))
+	require.False(t, wheelMatches("torch-2.5.1.tar.gz", "torch", "2.5.1", "cp312", "x86_64"))
+}
@@ -0,0 +1,118 @@
+package lockfile
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// PyPIIndexURL is the simple index of packages on PyPI
+const PyPIIndexURL = "https://pypi.org/simple"
+
+// PytorchIndexURL is the root of the PyTorch wheel indexes, which has an index for each CUDA version, e.g. /cu121
+const PytorchIndexURL = "https://download.pytorch.org/whl"
+
+var hrefPattern = regexp.MustCompile(`href="([^"]+)"`)
+
+var nameSeparatorPattern = regexp.MustCompile(`[-_.]+`)
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// wheelArchitectures are the architectures in wheel platform tags for each Docker platform architecture
+var wheelArchitectures = map[string]string{
+	"amd64": "x86_64",
+	"arm64": "aarch64",
+}
+
+// IndexURLForVersion returns the index a version of a PyTorch package is published to. Versions with a
+// local version label, like 2.5.1+cu121, come from the PyTorch index for that CUDA version, and others from PyPI.
+func IndexURLForVersion(version string) string {
+	if _, local, ok := strings.Cut(version, "+"); ok {
+		return PytorchIndexURL + "/" + local
+	}
+	return PyPIIndexURL
+}
+
+// ResolveWheel finds the wheel for a version of a package in a PEP 503 simple index, built for CPython
+// pythonVersion (e.g. "3.11") on the Docker platform the image is built for (e.g. "linux/amd64").
+func ResolveWheel(indexURL string, name string, version string, pythonVersion string, platform string) (*Wheel, error) {
+	wheelArch, ok := wheelArchitecture(platform)
+	if !ok {
+		return nil, fmt.Errorf("There are no %s wheels for the %s platform", name, platform)
+	}
+	pageURL, err := url.Parse(strings.TrimSuffix(indexURL, "/") + "/" + normalizeName(name) + "/")
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Get(pageURL.String())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch %s: %w", pageURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to fetch %s: %s", pageURL, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	pythonTag := "cp" + strings.ReplaceAll(pythonVersion, ".", "")
+	for _, match := range hrefPattern.FindAllStringSubmatch(string(body), -1) {
+		href, err := url.Parse(strings.ReplaceAll(match[1], "&amp;", "&"))
+		if err != nil {
+			continue
+		}
+		filename, err := url.PathUnescape(path.Base(href.Path))
+		if err != nil || !wheelMatches(filename, name, version, pythonTag, wheelArch) {
+			continue
+		}
+		wheel := &Wheel{Name: name, Version: version, URL: pageURL.ResolveReference(href).String()}
+		if sha, ok := strings.CutPrefix(href.Fragment, "sha256="); ok {
+			wheel.SHA256 = sha
+		}
+		// The hash is recorded separately, so keep the URL clean
+		wheel.URL = strings.TrimSuffix(wheel.URL, "#"+href.Fragment)
+		return wheel, nil
+	}
+	return nil, fmt.Errorf("No wheel for %s==%s and Python %s on %s in %s", name, version, pythonVersion, platform, indexURL)
+}
+
+// IsForPlatform reports whether the wheel is built for the Docker platform, so a wheel recorded when building for
+// another one is resolved again
+func (w Wheel) IsForPlatform(platform string) bool {
+	arch, ok := wheelArchitecture(platform)
+	return ok && strings.HasSuffix(strings.TrimSuffix(path.Base(w.URL), ".whl"), "_"+arch)
+}
+
+// wheelArchitecture returns the architecture in wheel platform tags for a Docker platform like linux/amd64
+func wheelArchitecture(platform string) (string, bool) {
+	_, arch, _ := strings.Cut(platform, "/")
+	arch, _, _ = strings.Cut(arch, "/")
+	wheelArch, ok := wheelArchitectures[arch]
+	return wheelArch, ok
+}
+
+// wheelMatches reports whether a wheel filename, in the format
+// {name}-{version}(-{build})?-{python}-{abi}-{platform}.whl, is the given version for CPython on Linux on arch
+func wheelMatches(filename string, name string, version string, pythonTag string, arch string) bool {
+	parts := strings.Split(strings.TrimSuffix(filename, ".whl"), "-")
+	if !strings.HasSuffix(filename, ".whl") || len(parts) < 5 || len(parts) > 6 {
+		return false
+	}
+	platform := parts[len(parts)-1]
+	return normalizeName(parts[0]) == normalizeName(name) &&
+		parts[1] == version &&
+		parts[len(parts)-3] == pythonTag &&
+		strings.Contains(platform, "linux") && strings.HasSuffix(platform, "_"+arch)
+}
+
+// normalizeName normalizes a package name as in PEP 503, treating wheel filename underscores like hyphens
+func normalizeName(name string) string {
+	return strings.ToLower(nameSeparatorPattern.ReplaceAllString(name, "-"))
+}
@@ -0,0 +1,137 @@
+// Package p2p seeds pushed models into peer-to-peer registry accelerators like Dragonfly and Spegel, so large
+// images spread across a GPU fleet from peers instead of every node pulling them from the central registry.
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+const (
+	Dragonfly = "dragonfly"
+	Spegel    = "spegel"
+)
+
+const (
+	// DragonflyManagerEnvVarName is the URL of the Dragonfly manager to create preheat jobs with
+	DragonflyManagerEnvVarName = "COG_DRAGONFLY_MANAGER"
+	// DragonflyTokenEnvVarName is a personal access token for the Dragonfly manager's open API
+	DragonflyTokenEnvVarName = "COG_DRAGONFLY_TOKEN"
+)
+
+// SeedLabelKey is set on images that are seeded, to the accelerator they're seeded into, so admission controllers
+// and schedulers can tell which images are expected to be available from peers
+const SeedLabelKey = "run.cog.p2p-seed"
+
+// Accelerators are the accelerators models can be seeded into
+var Accelerators = []string{Dragonfly, Spegel}
+
+// Seed is what was done to seed an image
+type Seed struct {
+	// JobID is the ID of the preheat job, if one was created
+	JobID string
+	// Commands are commands to run to seed the image, if it couldn't be done from here
+	Commands []string
+}
+
+// ValidateAccelerator returns an error if accelerator isn't one of Accelerators
+func ValidateAccelerator(accelerator string) error {
+	for _, a := range Accelerators {
+		if a == accelerator {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown P2P accelerator %q, it must be one of %s", accelerator, strings.Join(Accelerators, ", "))
+}
+
+// SeedImage seeds digest, like r8.im/alice/bunny-detector@sha256:..., into accelerator. With Dragonfly, a preheat
+// job is created on the manager in COG_DRAGONFLY_MANAGER, if it's set. Otherwise, it returns the commands to run
+// to seed it.
+func SeedImage(ctx context.Context, accelerator string, digest string) (*Seed, error) {
+	ref, err := name.NewDigest(digest)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse image digest %s: %w", digest, err)
+	}
+	switch accelerator {
+	case Dragonfly:
+		manifestURL := manifestURL(ref)
+		manager := os.Getenv(DragonflyManagerEnvVarName)
+		if manager == "" {
+			return &Seed{Commands: []string{dragonflyPreheatCommand(manifestURL)}}, nil
+		}
+		jobID, err := dragonflyPreheat(ctx, http.DefaultClient, manager, os.Getenv(DragonflyTokenEnvVarName), manifestURL)
+		if err != nil {
+			return nil, err
+		}
+		return &Seed{JobID: jobID}, nil
+	case Spegel:
+		// Spegel serves images that nodes already have, so pulling it onto one node makes it available to the rest
+		return &Seed{Commands: []string{"crictl pull " + ref.String()}}, nil
+	}
+	return nil, ValidateAccelerator(accelerator)
+}
+
+type dragonflyJobRequest struct {
+	Type string           `json:"type"`
+	Args dragonflyJobArgs `json:"args"`
+}
+
+type dragonflyJobArgs struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type dragonflyJob struct {
+	ID json.Number `json:"id"`
+}
+
+// dragonflyPreheat creates a job on a Dragonfly manager to preheat the image with the manifest at manifestURL
+// into its seed peers
+func dragonflyPreheat(ctx context.Context, client *http.Client, manager string, token string, manifestURL string) (string, error) {
+	body, err := json.Marshal(dragonflyJobRequest{
+		Type: "preheat",
+		Args: dragonflyJobArgs{Type: "image", URL: manifestURL},
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(manager, "/")+"/oapi/v1/jobs", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Failed to create preheat job on %s: %w", manager, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("Failed to create preheat job on %s: %s %s", manager, resp.Status, strings.TrimSpace(string(message)))
+	}
+	job := dragonflyJob{}
+	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		return "", fmt.Errorf("Failed to parse preheat job from %s: %w", manager, err)
+	}
+	return job.ID.String(), nil
+}
+
+func dragonflyPreheatCommand(manifestURL string) string {
+	return fmt.Sprintf(`curl -X POST "$%s/oapi/v1/jobs" -H "Authorization: Bearer $%s" -H "Content-Type: application/json" -d '{"type":"preheat","args":{"type":"image","url":"%s"}}'`,
+		DragonflyManagerEnvVarName, DragonflyTokenEnvVarName, manifestURL)
+}
+
+func manifestURL(ref name.Digest) string {
+	return fmt.Sprintf("%s://%s/v2/%s/manifests/%s", ref.Context().Scheme(), ref.Context().RegistryStr(), ref.Context().RepositoryStr(), ref.DigestStr())
+}
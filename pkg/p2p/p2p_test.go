@@ -0,0 +1,61 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testDigest = "r8.im/alice/bunny-detector@sha256:9b2e8a6cbbd1e5e5e0a4bf6d6b1a8a3e5c2f0d7e4b1c6a8f3e2d1c0b9a8f7e6d"
+
+func TestSeedImageDragonfly(t *testing.T) {
+	var request dragonflyJobRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/oapi/v1/jobs", r.URL.Path)
+		require.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		w.Write([]byte(`{"id": 42, "type": "preheat"}`))
+	}))
+	defer server.Close()
+	t.Setenv(DragonflyManagerEnvVarName, server.URL+"/")
+	t.Setenv(DragonflyTokenEnvVarName, "secret")
+
+	seed, err := SeedImage(context.Background(), Dragonfly, testDigest)
+	require.NoError(t, err)
+	require.Equal(t, "42", seed.JobID)
+	require.Equal(t, "preheat", request.Type)
+	require.Equal(t, "image", request.Args.Type)
+	require.Equal(t, "https://r8.im/v2/alice/bunny-detector/manifests/sha256:9b2e8a6cbbd1e5e5e0a4bf6d6b1a8a3e5c2f0d7e4b1c6a8f3e2d1c0b9a8f7e6d", request.Args.URL)
+}
+
+func TestSeedImageDragonflyError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("invalid token"))
+	}))
+	defer server.Close()
+	t.Setenv(DragonflyManagerEnvVarName, server.URL)
+
+	_, err := SeedImage(context.Background(), Dragonfly, testDigest)
+	require.ErrorContains(t, err, "401 Unauthorized invalid token")
+}
+
+func TestSeedImageCommands(t *testing.T) {
+	t.Setenv(DragonflyManagerEnvVarName, "")
+
+	seed, err := SeedImage(context.Background(), Dragonfly, testDigest)
+	require.NoError(t, err)
+	require.Len(t, seed.Commands, 1)
+	require.Contains(t, seed.Commands[0], "$COG_DRAGONFLY_MANAGER/oapi/v1/jobs")
+
+	seed, err = SeedImage(context.Background(), Spegel, testDigest)
+	require.NoError(t, err)
+	require.Equal(t, []string{"crictl pull " + testDigest}, seed.Commands)
+
+	_, err = SeedImage(context.Background(), "kraken", testDigest)
+	require.ErrorContains(t, err, "must be one of dragonfly, spegel")
+}
@@ -0,0 +1,344 @@
+package predict
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/mitchellh/go-homedir"
+
+	"github.com/replicate/cog/pkg/util/console"
+	"github.com/replicate/cog/pkg/util/mime"
+)
+
+// cacheTTL is how long a download is reused without checking whether it's changed, if the server didn't give it an
+// ETag or Last-Modified time to check it with
+const cacheTTL = time.Hour
+
+// DefaultMaxInputSize is the largest input Fetcher downloads by default. Inputs are sent to the model as data URLs,
+// so much larger ones are better mounted into the container.
+const DefaultMaxInputSize = 1 << 30
+
+// Fetcher downloads inputs given as URLs, like -i image=@https://example.com/cat.png, so they're sent to the model
+// like local files. Downloads are cached by URL in CacheDir, and are only downloaded again if they've changed.
+type Fetcher struct {
+	CacheDir string
+	// MaxSize is the largest input that's downloaded, in bytes. There's no limit if it's 0.
+	MaxSize int64
+	Client  *http.Client
+}
+
+// NewFetcher returns a Fetcher that caches downloads in the user's cache directory, like ~/.cache/cog/inputs
+func NewFetcher(maxSize int64) (*Fetcher, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to find cache directory for inputs: %w", err)
+	}
+	return &Fetcher{
+		CacheDir: filepath.Join(cacheDir, "cog", "inputs"),
+		MaxSize:  maxSize,
+		Client:   http.DefaultClient,
+	}, nil
+}
+
+// IsURL returns whether an input file is a URL Fetcher can download, rather than a local path
+func IsURL(file string) bool {
+	for _, scheme := range []string{"http://", "https://", "s3://"} {
+		if strings.HasPrefix(file, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fetch downloads the input files in inputs that are URLs, and returns inputs with their local paths instead
+func (f *Fetcher) Fetch(inputs Inputs) (Inputs, error) {
+	fetched := Inputs{}
+	for key, input := range inputs {
+		switch {
+		case input.File != nil && IsURL(*input.File):
+			local, err := f.Get(*input.File)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to fetch input %s: %w", key, err)
+			}
+			input = Input{File: &local}
+		case input.Array != nil:
+			array := make([]any, len(*input.Array))
+			for i, elem := range *input.Array {
+				array[i] = elem
+				if str, ok := elem.(string); ok && strings.HasPrefix(str, "@") && IsURL(str[1:]) {
+					local, err := f.Get(str[1:])
+					if err != nil {
+						return nil, fmt.Errorf("Failed to fetch input %s: %w", key, err)
+					}
+					array[i] = "@" + local
+				}
+			}
+			input = Input{Array: &array}
+		}
+		fetched[key] = input
+	}
+	return fetched, nil
+}
+
+// cacheEntry is what the server said about a cached download, so it can check whether it's changed
+type cacheEntry struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// Get downloads rawURL, or finds it in the cache, and returns the path of the local copy. The file has an
+// extension for its content type, so it's sent to the model with the right one. Cached HTTP downloads are
+// checked with a conditional request, so they're only downloaded again if they've changed.
+func (f *Fetcher) Get(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("Invalid URL %s: %w", rawURL, err)
+	}
+
+	sum := sha256.Sum256([]byte(rawURL))
+	dir := filepath.Join(f.CacheDir, hex.EncodeToString(sum[:]))
+	entryPath := dir + ".json"
+	cached := ""
+	entry := cacheEntry{}
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) == 1 {
+		cached = filepath.Join(dir, entries[0].Name())
+		if data, err := os.ReadFile(entryPath); err == nil {
+			_ = json.Unmarshal(data, &entry)
+		}
+		if entry.ETag == "" && entry.LastModified == "" {
+			if info, err := os.Stat(cached); err == nil && time.Since(info.ModTime()) < cacheTTL {
+				console.Debugf("Using cached download of %s", rawURL)
+				return cached, nil
+			}
+		}
+	}
+
+	var body io.ReadCloser
+	var size int64
+	var contentType string
+	switch u.Scheme {
+	case "s3":
+		body, size, contentType, err = getS3Object(context.Background(), u)
+		entry = cacheEntry{}
+	case "http", "https":
+		var resp *http.Response
+		resp, err = f.getHTTP(u, cached != "", entry)
+		if err == nil && resp.StatusCode == http.StatusNotModified {
+			resp.Body.Close()
+			console.Debugf("Using cached download of %s, which hasn't changed", rawURL)
+			return cached, nil
+		}
+		if err == nil {
+			body, size, contentType = resp.Body, resp.ContentLength, resp.Header.Get("Content-Type")
+			entry = cacheEntry{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+		}
+	default:
+		err = fmt.Errorf("Unsupported URL scheme %s", u.Scheme)
+	}
+	if err != nil {
+		if cached != "" {
+			console.Warnf("Failed to check whether %s has changed, so using the copy downloaded before: %s", rawURL, err)
+			return cached, nil
+		}
+		return "", err
+	}
+	defer body.Close()
+
+	if f.MaxSize > 0 && size > f.MaxSize {
+		return "", f.tooLargeError(rawURL)
+	}
+	console.Infof("Downloading %s...", rawURL)
+
+	if err := os.MkdirAll(f.CacheDir, 0o700); err != nil {
+		return "", fmt.Errorf("Failed to create input cache directory: %w", err)
+	}
+	// Download into a temporary directory, then move it into place, so an interrupted download isn't cached
+	tmpDir, err := os.MkdirTemp(f.CacheDir, "download-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	reader := bufio.NewReader(body)
+	// Sniff the content type if the URL and server don't say what it is
+	sniffed, _ := reader.Peek(512)
+	name := inputFileName(u.Path, contentType, sniffed)
+	tmpPath := filepath.Join(tmpDir, name)
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return "", err
+	}
+	var src io.Reader = reader
+	if f.MaxSize > 0 {
+		src = io.LimitReader(reader, f.MaxSize+1)
+	}
+	written, err := io.Copy(file, src)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("Failed to download %s: %w", rawURL, err)
+	}
+	if f.MaxSize > 0 && written > f.MaxSize {
+		return "", f.tooLargeError(rawURL)
+	}
+
+	if cached != "" {
+		if err := os.RemoveAll(dir); err != nil {
+			return "", fmt.Errorf("Failed to replace cached download of %s: %w", rawURL, err)
+		}
+	}
+	if data, err := json.Marshal(entry); err == nil {
+		if err := os.WriteFile(entryPath, data, 0o600); err != nil {
+			console.Debugf("Failed to record when %s was downloaded: %s", rawURL, err)
+		}
+	}
+	if err := os.Rename(tmpDir, dir); err != nil {
+		// Another download of the same URL might have finished first
+		if entries, readErr := os.ReadDir(dir); readErr == nil && len(entries) == 1 {
+			return filepath.Join(dir, entries[0].Name()), nil
+		}
+		return "", fmt.Errorf("Failed to cache download of %s: %w", rawURL, err)
+	}
+	return filepath.Join(dir, name), nil
+}
+
+func (f *Fetcher) tooLargeError(rawURL string) error {
+	return fmt.Errorf("%s is larger than %s. Set a larger limit with --max-input-size.", rawURL, units.BytesSize(float64(f.MaxSize)))
+}
+
+// getHTTP requests u. If revalidate is set, the request is conditional on the download in the cache having
+// changed, and the response is 304 Not Modified if it hasn't.
+func (f *Fetcher) getHTTP(u *url.URL, revalidate bool, cached cacheEntry) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	authorize(req)
+	if revalidate && cached.ETag != "" {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+	if revalidate && cached.LastModified != "" {
+		req.Header.Set("If-Modified-Since", cached.LastModified)
+	}
+
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to download %s: %w", u, err)
+	}
+	if resp.StatusCode == http.StatusNotModified && revalidate {
+		return resp, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			return nil, fmt.Errorf("Failed to download %s: status %d. Add credentials for %s to ~/.netrc.", u, resp.StatusCode, u.Hostname())
+		}
+		return nil, fmt.Errorf("Failed to download %s: status %d", u, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// authorize adds credentials for the request's host to it, from ~/.netrc, or HF_TOKEN for Hugging Face. Go drops
+// them if the request is redirected to another host, like a CDN.
+func authorize(req *http.Request) {
+	host := req.URL.Hostname()
+	if login, password, ok := netrcCredentials(host); ok {
+		req.SetBasicAuth(login, password)
+		return
+	}
+	if token := os.Getenv("HF_TOKEN"); token != "" && (host == "huggingface.co" || strings.HasSuffix(host, ".huggingface.co")) {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// netrcCredentials returns the login and password for host in the netrc file at $NETRC or ~/.netrc
+func netrcCredentials(host string) (string, string, bool) {
+	netrcPath := os.Getenv("NETRC")
+	if netrcPath == "" {
+		var err error
+		if netrcPath, err = homedir.Expand("~/.netrc"); err != nil {
+			return "", "", false
+		}
+	}
+	data, err := os.ReadFile(netrcPath)
+	if err != nil {
+		return "", "", false
+	}
+	return parseNetrc(data, host)
+}
+
+func parseNetrc(data []byte, host string) (string, string, bool) {
+	var login, password string
+	matched, found := false, false
+	fields := strings.Fields(string(data))
+	for i := 0; i < len(fields); i++ {
+		switch fields[i] {
+		case "machine", "default":
+			if found {
+				return login, password, true
+			}
+			if fields[i] == "default" {
+				matched = true
+			} else if i+1 < len(fields) {
+				i++
+				matched = fields[i] == host
+			}
+		case "login", "password", "account":
+			if i+1 >= len(fields) {
+				break
+			}
+			i++
+			if !matched {
+				continue
+			}
+			found = true
+			switch fields[i-1] {
+			case "login":
+				login = fields[i]
+			case "password":
+				password = fields[i]
+			}
+		}
+	}
+	return login, password, found
+}
+
+// inputFileName returns a file name for a download from urlPath, with an extension for its content type
+func inputFileName(urlPath string, contentType string, head []byte) string {
+	name := path.Base(urlPath)
+	if name == "." || name == "/" || name == "" {
+		name = "input"
+	}
+	ext := path.Ext(name)
+	if ext != "" && mime.TypeByExtension(ext) != "application/octet-stream" {
+		return name
+	}
+
+	typ, _, _ := strings.Cut(contentType, ";")
+	typ = strings.TrimSpace(typ)
+	if typ == "" || typ == "application/octet-stream" || typ == "binary/octet-stream" {
+		typ, _, _ = strings.Cut(http.DetectContentType(head), ";")
+	}
+	if typExt := mime.ExtensionByType(typ); typExt != "" && typ != "application/octet-stream" {
+		return strings.TrimSuffix(name, ext) + typExt
+	}
+	return name
+}
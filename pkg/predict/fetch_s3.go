@@ -0,0 +1,126 @@
+package predict
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/mitchellh/go-homedir"
+)
+
+const defaultS3Region = "us-east-1"
+
+// getS3Object opens an s3://bucket/key URL. Credentials are read like the AWS CLI reads them: from
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, or the AWS_PROFILE profile in ~/.aws/credentials. Public objects
+// are read anonymously if there aren't any.
+func getS3Object(ctx context.Context, u *url.URL) (io.ReadCloser, int64, string, error) {
+	bucket := u.Host
+	key := strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, 0, "", fmt.Errorf("Invalid S3 URL %s, expected s3://bucket/key", u)
+	}
+
+	cfg := aws.NewConfig()
+	cfg.Region = s3Region()
+	cfg.Credentials = s3Credentials()
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_S3")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	if endpoint != "" {
+		cfg.BaseEndpoint = &endpoint
+	}
+	client := s3.NewFromConfig(*cfg, func(o *s3.Options) {
+		// S3-compatible stores like MinIO don't support virtual-hosted buckets
+		o.UsePathStyle = endpoint != ""
+	})
+
+	output, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: &bucket, Key: &key})
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("Failed to download %s: %w", u, err)
+	}
+	return output.Body, aws.ToInt64(output.ContentLength), aws.ToString(output.ContentType), nil
+}
+
+func s3Credentials() aws.CredentialsProvider {
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		return credentials.NewStaticCredentialsProvider(id, secret, os.Getenv("AWS_SESSION_TOKEN"))
+	}
+	if profile := awsSharedFileProfile("AWS_SHARED_CREDENTIALS_FILE", "~/.aws/credentials", awsProfile()); profile != nil {
+		if profile["aws_access_key_id"] != "" && profile["aws_secret_access_key"] != "" {
+			return credentials.NewStaticCredentialsProvider(profile["aws_access_key_id"], profile["aws_secret_access_key"], profile["aws_session_token"])
+		}
+	}
+	return aws.AnonymousCredentials{}
+}
+
+func s3Region() string {
+	for _, name := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region := os.Getenv(name); region != "" {
+			return region
+		}
+	}
+	section := "profile " + awsProfile()
+	if awsProfile() == "default" {
+		section = "default"
+	}
+	if profile := awsSharedFileProfile("AWS_CONFIG_FILE", "~/.aws/config", section); profile["region"] != "" {
+		return profile["region"]
+	}
+	return defaultS3Region
+}
+
+func awsProfile() string {
+	if profile := os.Getenv("AWS_PROFILE"); profile != "" {
+		return profile
+	}
+	return "default"
+}
+
+// awsSharedFileProfile returns the keys in a section of an AWS shared config or credentials file, at the path in
+// the environment variable envVar or defaultPath
+func awsSharedFileProfile(envVar string, defaultPath string, section string) map[string]string {
+	filePath := os.Getenv(envVar)
+	if filePath == "" {
+		var err error
+		if filePath, err = homedir.Expand(defaultPath); err != nil {
+			return nil
+		}
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil
+	}
+	return parseINISection(string(data), section)
+}
+
+func parseINISection(data string, section string) map[string]string {
+	var values map[string]string
+	current := ""
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			current = strings.TrimSpace(line[1 : len(line)-1])
+			if current == section && values == nil {
+				values = map[string]string{}
+			}
+			continue
+		}
+		if current != section {
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok {
+			values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return values
+}
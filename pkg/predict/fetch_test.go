@@ -0,0 +1,171 @@
+package predict
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestFetcherCachesDownloads(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	fetcher := &Fetcher{CacheDir: t.TempDir()}
+	path, err := fetcher.Get(server.URL + "/greeting.txt")
+	require.NoError(t, err)
+	require.Equal(t, "greeting.txt", filepath.Base(path))
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(content))
+
+	cached, err := fetcher.Get(server.URL + "/greeting.txt")
+	require.NoError(t, err)
+	require.Equal(t, path, cached)
+	require.Equal(t, 1, requests)
+}
+
+func TestFetcherRevalidatesCachedDownloads(t *testing.T) {
+	etag := `"v1"`
+	content := "hello"
+	downloads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads++
+		w.Header().Set("ETag", etag)
+		_, _ = w.Write([]byte(content))
+	}))
+	defer server.Close()
+
+	fetcher := &Fetcher{CacheDir: t.TempDir()}
+	path, err := fetcher.Get(server.URL + "/greeting.txt")
+	require.NoError(t, err)
+	cached, err := fetcher.Get(server.URL + "/greeting.txt")
+	require.NoError(t, err)
+	require.Equal(t, path, cached)
+	require.Equal(t, 1, downloads)
+
+	etag = `"v2"`
+	content = "goodbye"
+	changed, err := fetcher.Get(server.URL + "/greeting.txt")
+	require.NoError(t, err)
+	require.Equal(t, 2, downloads)
+	data, err := os.ReadFile(changed)
+	require.NoError(t, err)
+	require.Equal(t, "goodbye", string(data))
+}
+
+func TestFetcherDetectsContentType(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/with-header" {
+			w.Header().Set("Content-Type", "audio/mpeg")
+		} else {
+			w.Header().Set("Content-Type", "application/octet-stream")
+		}
+		_, _ = w.Write(pngHeader)
+	}))
+	defer server.Close()
+
+	fetcher := &Fetcher{CacheDir: t.TempDir()}
+	path, err := fetcher.Get(server.URL + "/with-header")
+	require.NoError(t, err)
+	require.Equal(t, "with-header.mp3", filepath.Base(path))
+
+	path, err = fetcher.Get(server.URL + "/sniffed")
+	require.NoError(t, err)
+	require.Equal(t, "sniffed.png", filepath.Base(path))
+}
+
+func TestFetcherMaxSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/chunked" {
+			// Flushing before writing the body means there isn't a Content-Length
+			w.(http.Flusher).Flush()
+		}
+		_, _ = w.Write(make([]byte, 2048))
+	}))
+	defer server.Close()
+
+	cacheDir := t.TempDir()
+	fetcher := &Fetcher{CacheDir: cacheDir, MaxSize: 1024}
+	_, err := fetcher.Get(server.URL + "/large.bin")
+	require.ErrorContains(t, err, "is larger than 1KiB")
+	_, err = fetcher.Get(server.URL + "/chunked")
+	require.ErrorContains(t, err, "is larger than 1KiB")
+
+	entries, err := os.ReadDir(cacheDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestFetcherNetrcAuth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "someone" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte("private"))
+	}))
+	defer server.Close()
+
+	fetcher := &Fetcher{CacheDir: t.TempDir()}
+	netrc := filepath.Join(t.TempDir(), "netrc")
+	t.Setenv("NETRC", netrc)
+	_, err := fetcher.Get(server.URL + "/private.txt")
+	require.ErrorContains(t, err, "status 401")
+
+	require.NoError(t, os.WriteFile(netrc, []byte("machine example.com login other password wrong\nmachine 127.0.0.1\n  login someone\n  password secret\n"), 0o600))
+	path, err := fetcher.Get(server.URL + "/private.txt")
+	require.NoError(t, err)
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "private", string(content))
+}
+
+func TestFetcherFetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+
+	local := "local.txt"
+	url := server.URL + "/a.txt"
+	array := []any{"@" + server.URL + "/b.txt", "@local.txt", "text"}
+	fetcher := &Fetcher{CacheDir: t.TempDir()}
+	inputs, err := fetcher.Fetch(Inputs{
+		"url":   Input{File: &url},
+		"local": Input{File: &local},
+		"array": Input{Array: &array},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "a.txt", filepath.Base(*inputs["url"].File))
+	require.Equal(t, "local.txt", *inputs["local"].File)
+	fetchedArray := *inputs["array"].Array
+	require.Equal(t, "b.txt", filepath.Base(fetchedArray[0].(string)))
+	require.Equal(t, []any{"@local.txt", "text"}, fetchedArray[1:])
+}
+
+func TestParseINISection(t *testing.T) {
+	data := `[default]
+aws_access_key_id = AKIADEFAULT
+
+# A comment
+[profile dev]
+region = eu-west-1
+`
+	require.Equal(t, map[string]string{"aws_access_key_id": "AKIADEFAULT"}, parseINISection(data, "default"))
+	require.Equal(t, map[string]string{"region": "eu-west-1"}, parseINISection(data, "profile dev"))
+	require.Nil(t, parseINISection(data, "prod"))
+}
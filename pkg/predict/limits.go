@@ -0,0 +1,95 @@
+package predict
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/mitchellh/go-homedir"
+
+	"github.com/replicate/cog/pkg/util/mime"
+)
+
+// Schema extensions for the limits on file inputs, set in Python with Input(max_size=..., mime_types=...)
+const (
+	MaxSizeExtension   = "x-cog-max-size"
+	MIMETypesExtension = "x-cog-mime-types"
+)
+
+// CheckInputLimits checks inputs against the limits in the model's schema: the size and type of files, and the length
+// of strings. The model's server checks them too, but this fails before large files are sent to it.
+func CheckInputLimits(inputs Inputs, schema *openapi3.T, isTrain bool) error {
+	inputType := inputSchema(schema, isTrain)
+	if inputType == nil {
+		return nil
+	}
+	for key, input := range inputs {
+		prop, ok := inputType.Properties[key]
+		if !ok || prop.Value == nil {
+			continue
+		}
+		limits := prop.Value
+		if limits.Items != nil && limits.Items.Value != nil {
+			limits = limits.Items.Value
+		}
+		switch {
+		case input.String != nil:
+			if prop.Value.MaxLength != nil && uint64(utf8.RuneCountInString(*input.String)) > *prop.Value.MaxLength {
+				return fmt.Errorf("Input %s is %d characters long, but the model accepts at most %d", key, utf8.RuneCountInString(*input.String), *prop.Value.MaxLength)
+			}
+		case input.File != nil:
+			if err := checkFileLimits(key, *input.File, limits); err != nil {
+				return err
+			}
+		case input.Array != nil:
+			for _, elem := range *input.Array {
+				if str, ok := elem.(string); ok && strings.HasPrefix(str, "@") {
+					if err := checkFileLimits(key, str[1:], limits); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func checkFileLimits(key string, filePath string, schema *openapi3.Schema) error {
+	if maxSize, ok := schema.Extensions[MaxSizeExtension].(float64); ok {
+		expanded, err := homedir.Expand(filePath)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(expanded)
+		if err != nil {
+			return err
+		}
+		if info.Size() > int64(maxSize) {
+			return fmt.Errorf("Input %s is %d bytes, but the model accepts files up to %d bytes", key, info.Size(), int64(maxSize))
+		}
+	}
+	if allowed, ok := schema.Extensions[MIMETypesExtension].([]any); ok && len(allowed) > 0 {
+		mimeType := mime.TypeByExtension(filepath.Ext(filePath))
+		if mimeType == "" {
+			mimeType = "application/octet-stream"
+		}
+		mimeType, _, _ = strings.Cut(mimeType, ";")
+		patterns := make([]string, 0, len(allowed))
+		for _, a := range allowed {
+			pattern, ok := a.(string)
+			if !ok {
+				continue
+			}
+			if matched, _ := path.Match(strings.ToLower(pattern), strings.ToLower(mimeType)); matched {
+				return nil
+			}
+			patterns = append(patterns, pattern)
+		}
+		return fmt.Errorf("Input %s is a %s file, but the model accepts %s", key, mimeType, strings.Join(patterns, ", "))
+	}
+	return nil
+}
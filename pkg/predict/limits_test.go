@@ -0,0 +1,51 @@
+package predict
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/stretchr/testify/require"
+)
+
+const limitsSchema = `{
+  "openapi": "3.0.2",
+  "info": {"title": "Cog", "version": "0.1.0"},
+  "paths": {},
+  "components": {
+    "schemas": {
+      "Input": {
+        "type": "object",
+        "properties": {
+          "image": {"type": "string", "format": "uri", "x-cog-max-size": 10, "x-cog-mime-types": ["image/*"]},
+          "masks": {"type": "array", "items": {"type": "string", "format": "uri", "x-cog-mime-types": ["image/png"]}},
+          "prompt": {"type": "string", "maxLength": 5}
+        }
+      }
+    }
+  }
+}`
+
+func TestCheckInputLimits(t *testing.T) {
+	schema, err := openapi3.NewLoader().LoadFromData([]byte(limitsSchema))
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	small := filepath.Join(dir, "small.png")
+	require.NoError(t, os.WriteFile(small, []byte("12345"), 0o644))
+	large := filepath.Join(dir, "large.png")
+	require.NoError(t, os.WriteFile(large, make([]byte, 20), 0o644))
+	text := filepath.Join(dir, "notes.txt")
+	require.NoError(t, os.WriteFile(text, []byte("hi"), 0o644))
+
+	check := func(keyVals map[string][]string) error {
+		return CheckInputLimits(NewInputs(keyVals), schema, false)
+	}
+
+	require.NoError(t, check(map[string][]string{"image": {"@" + small}, "masks": {"@" + small, "@" + small}, "prompt": {"hello"}}))
+	require.ErrorContains(t, check(map[string][]string{"image": {"@" + large}}), "accepts files up to 10 bytes")
+	require.ErrorContains(t, check(map[string][]string{"image": {"@" + text}}), "is a text/plain file")
+	require.ErrorContains(t, check(map[string][]string{"masks": {"@" + small, "@" + text}}), "accepts image/png")
+	require.ErrorContains(t, check(map[string][]string{"prompt": {"hello!"}}), "at most 5")
+}
@@ -0,0 +1,95 @@
+package predict
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Phase is the part of a model server's life a log line is from
+type Phase string
+
+const (
+	// PhaseSetup is while the model's setup() runs
+	PhaseSetup Phase = "setup"
+	// PhasePredict is after setup, while the server runs predictions
+	PhasePredict Phase = "predict"
+)
+
+// ParsePhase parses a phase, like the --phase flag of `cog logs`
+func ParsePhase(s string) (Phase, error) {
+	switch Phase(s) {
+	case PhaseSetup, PhasePredict:
+		return Phase(s), nil
+	}
+	return "", fmt.Errorf("Unknown phase %q. It must be %s or %s", s, PhaseSetup, PhasePredict)
+}
+
+// PhaseWriter writes the lines of a container's logs from one phase to out. The logs must be written to it with the
+// timestamps from `docker logs --timestamps`, which it strips unless Timestamps is set.
+//
+// Lines are from setup if they were logged before setup completed. SetupCompletedAt returns when that was, or nil if
+// setup is still running. It's called again for later lines until it returns a time, so the phase can be followed.
+type PhaseWriter struct {
+	Out              io.Writer
+	Phase            Phase
+	Timestamps       bool
+	SetupCompletedAt func() *time.Time
+
+	completedAt *time.Time
+	buf         []byte
+}
+
+func (w *PhaseWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := w.buf[:i+1]
+		w.buf = w.buf[i+1:]
+		if err := w.writeLine(line); err != nil {
+			return len(p), err
+		}
+	}
+}
+
+// Flush writes what's left of a line that didn't end in a newline
+func (w *PhaseWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	line := w.buf
+	w.buf = nil
+	return w.writeLine(line)
+}
+
+func (w *PhaseWriter) writeLine(line []byte) error {
+	timestamp, message, _ := strings.Cut(string(line), " ")
+	loggedAt, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		// Not a line from docker logs --timestamps, so pass it on rather than lose it
+		_, err := w.Out.Write(line)
+		return err
+	}
+
+	if w.completedAt == nil && w.SetupCompletedAt != nil {
+		w.completedAt = w.SetupCompletedAt()
+	}
+	phase := PhaseSetup
+	if w.completedAt != nil && loggedAt.After(*w.completedAt) {
+		phase = PhasePredict
+	}
+	if phase != w.Phase {
+		return nil
+	}
+
+	if !w.Timestamps {
+		line = []byte(message)
+	}
+	_, err = w.Out.Write(line)
+	return err
+}
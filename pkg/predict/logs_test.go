@@ -0,0 +1,60 @@
+package predict
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testLogs = `2024-05-01T10:00:00.100000000Z Loading weights...
+2024-05-01T10:00:04.900000000Z Weights loaded
+2024-05-01T10:00:07.000000000Z Running prediction
+2024-05-01T10:00:08.000000000Z Done
+`
+
+func TestPhaseWriter(t *testing.T) {
+	completedAt := time.Date(2024, 5, 1, 10, 0, 5, 0, time.UTC)
+	setupCompletedAt := func() *time.Time { return &completedAt }
+
+	var setup bytes.Buffer
+	w := &PhaseWriter{Out: &setup, Phase: PhaseSetup, SetupCompletedAt: setupCompletedAt}
+	_, err := w.Write([]byte(testLogs))
+	require.NoError(t, err)
+	require.Equal(t, "Loading weights...\nWeights loaded\n", setup.String())
+
+	var predict bytes.Buffer
+	w = &PhaseWriter{Out: &predict, Phase: PhasePredict, Timestamps: true, SetupCompletedAt: setupCompletedAt}
+	// Lines can be split across writes
+	_, err = w.Write([]byte(testLogs[:100]))
+	require.NoError(t, err)
+	_, err = w.Write([]byte(testLogs[100:]))
+	require.NoError(t, err)
+	require.Equal(t, "2024-05-01T10:00:07.000000000Z Running prediction\n2024-05-01T10:00:08.000000000Z Done\n", predict.String())
+}
+
+func TestPhaseWriterWhileSetupRuns(t *testing.T) {
+	var completedAt *time.Time
+	var out bytes.Buffer
+	w := &PhaseWriter{Out: &out, Phase: PhasePredict, SetupCompletedAt: func() *time.Time { return completedAt }}
+
+	_, err := w.Write([]byte("2024-05-01T10:00:00.1Z Loading weights...\n"))
+	require.NoError(t, err)
+	require.Equal(t, "", out.String())
+
+	completed := time.Date(2024, 5, 1, 10, 0, 5, 0, time.UTC)
+	completedAt = &completed
+	_, err = w.Write([]byte("2024-05-01T10:00:07Z Running prediction\nnot from docker\n2024-05-01T10:00:08Z Done"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	require.Equal(t, "Running prediction\nnot from docker\nDone", out.String())
+}
+
+func TestParsePhase(t *testing.T) {
+	phase, err := ParsePhase("setup")
+	require.NoError(t, err)
+	require.Equal(t, PhaseSetup, phase)
+	_, err = ParsePhase("build")
+	require.Error(t, err)
+}
@@ -21,6 +21,27 @@ type status string
 
 type HealthcheckResponse struct {
 	Status string `json:"status"`
+	// PredictionsInFlight is how many predictions are still running while the server drains
+	PredictionsInFlight int          `json:"predictions_in_flight,omitempty"`
+	Setup               *SetupResult `json:"setup,omitempty"`
+}
+
+// SetupResult is when the model's setup() ran, and whether it succeeded. CompletedAt is nil while it's running.
+type SetupResult struct {
+	StartedAt   *time.Time `json:"started_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	Status      string     `json:"status"`
+	// Phases is how long each phase of setup took, in seconds, like import, setup, and the phases the model timed
+	// with cog.startup.setup_phase()
+	Phases map[string]float64 `json:"phases,omitempty"`
+}
+
+// Duration returns how long setup took, or 0 if it hasn't finished
+func (r *SetupResult) Duration() time.Duration {
+	if r == nil || r.StartedAt == nil || r.CompletedAt == nil {
+		return 0
+	}
+	return r.CompletedAt.Sub(*r.StartedAt)
 }
 
 type Request struct {
@@ -29,6 +50,8 @@ type Request struct {
 }
 
 type Response struct {
+	// ID is the prediction's ID, which is the session ID when a session is created
+	ID     string       `json:"id,omitempty"`
 	Status status       `json:"status"`
 	Output *interface{} `json:"output"`
 	Error  string       `json:"error"`
@@ -49,6 +72,7 @@ type Predictor struct {
 	// Running state
 	containerID string
 	port        int
+	setup       *SetupResult
 }
 
 func NewPredictor(runOptions docker.RunOptions, isTrain bool, fastFlag bool, dockerCommand command.Command) (*Predictor, error) {
@@ -76,7 +100,17 @@ func (p *Predictor) Start(logsWriter io.Writer, timeout time.Duration) error {
 
 	p.runOptions.Ports = append(p.runOptions.Ports, docker.Port{HostPort: 0, ContainerPort: containerPort})
 
-	p.containerID, err = docker.RunDaemon(p.runOptions, logsWriter)
+	// The container is kept when it exits, so if it crashes, why can be diagnosed. Stop removes it.
+	mode := "predict"
+	if p.isTrain {
+		mode = "train"
+	}
+	name := fmt.Sprintf("cog-%s-%d", mode, time.Now().UnixNano())
+	if p.runOptions.Labels == nil {
+		p.runOptions.Labels = map[string]string{}
+	}
+	p.runOptions.Labels[command.CogContainerLabelKey] = mode
+	p.containerID, err = docker.RunDaemonAndKeepContainer(p.runOptions, name, logsWriter)
 	if err != nil {
 		return fmt.Errorf("Failed to start container: %w", err)
 	}
@@ -131,11 +165,13 @@ func (p *Predictor) waitForContainerReady(timeout time.Duration) error {
 		}
 		// These status values are defined in python/cog/server/http.py
 		switch healthcheck.Status {
-		case "STARTING":
+		case "STARTING", "UNHEALTHY":
+			// UNHEALTHY is a sidecar that isn't healthy, which is restarted until it is
 			continue
 		case "SETUP_FAILED":
 			return fmt.Errorf("Model setup failed")
 		case "READY":
+			p.setup = healthcheck.Setup
 			return nil
 		default:
 			return fmt.Errorf("Container healthcheck returned unexpected status: %s", healthcheck.Status)
@@ -144,7 +180,20 @@ func (p *Predictor) waitForContainerReady(timeout time.Duration) error {
 }
 
 func (p *Predictor) Stop() error {
-	return docker.Stop(p.containerID)
+	if err := docker.Stop(p.containerID); err != nil {
+		return err
+	}
+	return docker.RemoveContainer(p.containerID)
+}
+
+// Setup returns how the model's setup() went, once it's started
+func (p *Predictor) Setup() *SetupResult {
+	return p.setup
+}
+
+// ContainerID returns the ID of the model's container, once it's started
+func (p *Predictor) ContainerID() string {
+	return p.containerID
 }
 
 func (p *Predictor) Predict(inputs Inputs) (*Response, error) {
@@ -164,6 +213,21 @@ func (p *Predictor) Predict(inputs Inputs) (*Response, error) {
 		return nil, fmt.Errorf("Failed to create HTTP request to %s: %w", url, err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	return p.send(req)
+}
+
+// PredictTable runs a prediction with the table input, an Arrow or Parquet file, as the body of the request rather
+// than in JSON, which is faster for large tables. table is the name of the input, from TableInput.
+func (p *Predictor) PredictTable(inputs Inputs, table string) (*Response, error) {
+	req, err := newTableRequest(p.url(), inputs, table)
+	if err != nil {
+		return nil, err
+	}
+	return p.send(req)
+}
+
+func (p *Predictor) send(req *http.Request) (*Response, error) {
+	url := req.URL.String()
 	req.Close = true
 
 	httpClient := &http.Client{}
@@ -216,6 +280,10 @@ func (p *Predictor) endpoint() string {
 	return "predictions"
 }
 
+func (p *Predictor) serverURL() string {
+	return fmt.Sprintf("http://localhost:%d", p.port)
+}
+
 func (p *Predictor) url() string {
 	return fmt.Sprintf("http://localhost:%d/%s", p.port, p.endpoint())
 }
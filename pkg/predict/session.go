@@ -0,0 +1,115 @@
+package predict
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+// SessionsPath is the endpoint stateful models open sessions at. It's only in the schema of models whose predictor
+// defines step().
+const SessionsPath = "/sessions"
+
+// IsStateful returns whether the model with schema keeps state between predictions in sessions
+func IsStateful(schema *openapi3.T) bool {
+	return schema != nil && schema.Paths != nil && schema.Paths.Value(SessionsPath) != nil
+}
+
+// CreateSession opens a session on the stateful model at serverURL, like http://localhost:8393, passing inputs to
+// the predictor's create_session(). It returns the session ID.
+func CreateSession(serverURL string, inputs Inputs) (string, error) {
+	resp, err := sessionRequest(http.MethodPost, serverURL+SessionsPath, inputs)
+	if err != nil {
+		return "", err
+	}
+	if resp.Status == "failed" {
+		return "", fmt.Errorf("Failed to create session: %s", resp.Error)
+	}
+	return resp.ID, nil
+}
+
+// Step runs a step of the session with the ID sessionID
+func Step(serverURL string, sessionID string, inputs Inputs) (*Response, error) {
+	return sessionRequest(http.MethodPost, serverURL+SessionsPath+"/"+url.PathEscape(sessionID)+"/step", inputs)
+}
+
+// CloseSession closes the session with the ID sessionID, so the model can free its state
+func CloseSession(serverURL string, sessionID string) error {
+	resp, err := sessionRequest(http.MethodDelete, serverURL+SessionsPath+"/"+url.PathEscape(sessionID), nil)
+	if err != nil {
+		return err
+	}
+	if resp.Status == "failed" {
+		return fmt.Errorf("Failed to close session %s: %s", sessionID, resp.Error)
+	}
+	return nil
+}
+
+// CreateSession opens a session on the running model. See CreateSession.
+func (p *Predictor) CreateSession(inputs Inputs) (string, error) {
+	return CreateSession(p.serverURL(), inputs)
+}
+
+// Step runs a step of a session on the running model. See Step.
+func (p *Predictor) Step(sessionID string, inputs Inputs) (*Response, error) {
+	return Step(p.serverURL(), sessionID, inputs)
+}
+
+// CloseSession closes a session on the running model. See CloseSession.
+func (p *Predictor) CloseSession(sessionID string) error {
+	return CloseSession(p.serverURL(), sessionID)
+}
+
+func sessionRequest(method string, endpoint string, inputs Inputs) (*Response, error) {
+	var body io.Reader
+	if inputs != nil {
+		inputMap, err := inputs.toMap()
+		if err != nil {
+			return nil, err
+		}
+		requestBody, err := json.Marshal(Request{Input: inputMap})
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewBuffer(requestBody)
+	}
+	req, err := http.NewRequest(method, endpoint, body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create HTTP request to %s: %w", endpoint, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to %s HTTP request to %s: %w", method, endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnprocessableEntity {
+		errorResponse := &ValidationErrorResponse{}
+		if err := json.NewDecoder(resp.Body).Decode(errorResponse); err != nil {
+			return nil, fmt.Errorf("%s returned status 422, and the response body failed to decode: %w", endpoint, err)
+		}
+		messages := []string{}
+		for _, validationError := range errorResponse.Detail {
+			messages = append(messages, fmt.Sprintf("- %s: %s", strings.Join(validationError.Location, "."), validationError.Message))
+		}
+		return nil, fmt.Errorf("The inputs could not be validated:\n\n%s", strings.Join(messages, "\n"))
+	}
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%s returned status %d: %s", endpoint, resp.StatusCode, bytes.TrimSpace(detail))
+	}
+
+	response := &Response{}
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return nil, fmt.Errorf("Failed to decode response from %s: %w", endpoint, err)
+	}
+	return response, nil
+}
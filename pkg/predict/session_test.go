@@ -0,0 +1,68 @@
+package predict
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSession(t *testing.T) {
+	total := 0
+	closed := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/sessions":
+			_, _ = w.Write([]byte(`{"id": "abc", "status": "succeeded"}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/sessions/abc/step":
+			request := Request{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+			if _, ok := request.Input["n"]; !ok {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				_, _ = w.Write([]byte(`{"detail": [{"loc": ["body", "input", "n"], "msg": "field required", "type": "value_error.missing"}]}`))
+				return
+			}
+			total++
+			_ = json.NewEncoder(w).Encode(map[string]any{"status": "succeeded", "output": total})
+		case r.Method == http.MethodDelete && r.URL.Path == "/sessions/abc":
+			closed = true
+			_, _ = w.Write([]byte(`{"id": "abc", "status": "succeeded"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"detail": "Unknown session"}`))
+		}
+	}))
+	defer server.Close()
+
+	sessionID, err := CreateSession(server.URL, Inputs{})
+	require.NoError(t, err)
+	require.Equal(t, "abc", sessionID)
+
+	n := "1"
+	for i := 1; i <= 2; i++ {
+		resp, err := Step(server.URL, sessionID, Inputs{"n": Input{String: &n}})
+		require.NoError(t, err)
+		require.Equal(t, float64(i), *resp.Output)
+	}
+
+	_, err = Step(server.URL, sessionID, Inputs{})
+	require.ErrorContains(t, err, "- body.input.n: field required")
+
+	_, err = Step(server.URL, "xyz", Inputs{"n": Input{String: &n}})
+	require.ErrorContains(t, err, "returned status 404: {\"detail\": \"Unknown session\"}")
+
+	require.NoError(t, CloseSession(server.URL, sessionID))
+	require.True(t, closed)
+}
+
+func TestIsStateful(t *testing.T) {
+	schema := &openapi3.T{Paths: openapi3.NewPaths()}
+	schema.Paths.Set("/predictions", &openapi3.PathItem{})
+	require.False(t, IsStateful(schema))
+
+	schema.Paths.Set(SessionsPath, &openapi3.PathItem{})
+	require.True(t, IsStateful(schema))
+}
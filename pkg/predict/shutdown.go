@@ -0,0 +1,44 @@
+package predict
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// StatusDraining is the health-check status of a server that's shutting down once its running predictions finish
+const StatusDraining = "DRAINING"
+
+// Shutdown asks the prediction server at serverURL, like http://localhost:8393, to shut down. It stops accepting
+// predictions straight away, and shuts down once the running ones finish, or after drainTimeout.
+func Shutdown(serverURL string, drainTimeout time.Duration) error {
+	query := url.Values{"drain_timeout": {strconv.FormatFloat(drainTimeout.Seconds(), 'f', -1, 64)}}
+	resp, err := http.Post(serverURL+"/shutdown?"+query.Encode(), "application/json", nil) //#nosec G107
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("/shutdown returned status %d: %s", resp.StatusCode, body)
+	}
+	return nil
+}
+
+// Healthcheck returns the health-check of the prediction server at serverURL
+func Healthcheck(serverURL string) (*HealthcheckResponse, error) {
+	resp, err := http.Get(serverURL + "/health-check") //#nosec G107
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	healthcheck := &HealthcheckResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(healthcheck); err != nil {
+		return nil, fmt.Errorf("Healthcheck returned invalid response: %w", err)
+	}
+	return healthcheck, nil
+}
@@ -0,0 +1,44 @@
+package predict
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestShutdown(t *testing.T) {
+	var drainTimeout string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/shutdown":
+			require.Equal(t, http.MethodPost, r.Method)
+			drainTimeout = r.URL.Query().Get("drain_timeout")
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = w.Write([]byte(`{"status": "DRAINING", "predictions_in_flight": 2}`))
+		case "/health-check":
+			_, _ = w.Write([]byte(`{"status": "DRAINING", "setup": {}, "predictions_in_flight": 2}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	require.NoError(t, Shutdown(server.URL, 90*time.Second))
+	require.Equal(t, "90", drainTimeout)
+
+	healthcheck, err := Healthcheck(server.URL)
+	require.NoError(t, err)
+	require.Equal(t, &HealthcheckResponse{Status: StatusDraining, PredictionsInFlight: 2, Setup: &SetupResult{}}, healthcheck)
+}
+
+func TestShutdownError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	require.ErrorContains(t, Shutdown(server.URL, time.Second), "/shutdown returned status 404")
+}
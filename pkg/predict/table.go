@@ -0,0 +1,114 @@
+package predict
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/mitchellh/go-homedir"
+
+	"github.com/replicate/cog/pkg/util/mime"
+)
+
+// TableExtension is the schema extension that marks a cog.Table input or output
+const TableExtension = "x-cog-table"
+
+// tableTypes are the MIME types of the files that can be sent as the body of a request to a model with a table input
+var tableTypes = map[string]bool{
+	"application/vnd.apache.arrow.file":   true,
+	"application/vnd.apache.arrow.stream": true,
+	"application/vnd.apache.parquet":      true,
+}
+
+// TableInput returns the name of the input that's a table, sent as an Arrow or Parquet file, if the inputs can be
+// sent with it as the body of the request and the others in the query string. Otherwise it returns "", and the
+// inputs are sent as JSON.
+func TableInput(inputs Inputs, schema *openapi3.T, isTrain bool) string {
+	inputType := inputSchema(schema, isTrain)
+	if inputType == nil {
+		return ""
+	}
+	table := ""
+	for key, in := range inputs {
+		switch {
+		case in.File != nil:
+			prop, ok := inputType.Properties[key]
+			if !ok || prop.Value == nil || table != "" {
+				return ""
+			}
+			if _, isTable := prop.Value.Extensions[TableExtension]; !isTable {
+				return ""
+			}
+			if !tableTypes[mime.TypeByExtension(filepath.Ext(*in.File))] {
+				return ""
+			}
+			table = key
+		case in.Array != nil:
+			for _, elem := range *in.Array {
+				if str, ok := elem.(string); ok && strings.HasPrefix(str, "@") {
+					return ""
+				}
+			}
+		}
+	}
+	return table
+}
+
+// newTableRequest returns a request with the table input as its body, and the other inputs in the query string
+func newTableRequest(endpoint string, inputs Inputs, table string) (*http.Request, error) {
+	query := url.Values{}
+	for key, in := range inputs {
+		switch {
+		case in.String != nil:
+			query.Set(key, *in.String)
+		case in.Array != nil:
+			for _, elem := range *in.Array {
+				query.Add(key, fmt.Sprint(elem))
+			}
+		}
+	}
+	path, err := homedir.Expand(*inputs[table].File)
+	if err != nil {
+		return nil, fmt.Errorf("error expanding homedir for '%s': %w", *inputs[table].File, err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("Failed to create HTTP request to %s: %w", endpoint, err)
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", mime.TypeByExtension(filepath.Ext(path)))
+	return req, nil
+}
+
+// inputSchema returns the schema of the model's inputs, or nil if it doesn't have one
+func inputSchema(schema *openapi3.T, isTrain bool) *openapi3.Schema {
+	name := "Input"
+	if isTrain {
+		name = "TrainingInput"
+	}
+	if schema == nil || schema.Components == nil {
+		return nil
+	}
+	ref, ok := schema.Components.Schemas[name]
+	if !ok || ref.Value == nil {
+		return nil
+	}
+	return ref.Value
+}
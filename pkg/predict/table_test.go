@@ -0,0 +1,70 @@
+package predict
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/stretchr/testify/require"
+)
+
+const tableSchema = `{
+  "openapi": "3.0.2",
+  "info": {"title": "Cog", "version": "0.1.0"},
+  "paths": {},
+  "components": {
+    "schemas": {
+      "Input": {
+        "type": "object",
+        "properties": {
+          "rows": {"type": "string", "format": "uri", "x-cog-table": {}},
+          "image": {"type": "string", "format": "uri"},
+          "threshold": {"type": "number"}
+        }
+      }
+    }
+  }
+}`
+
+func TestPredictTable(t *testing.T) {
+	schema, err := openapi3.NewLoader().LoadFromData([]byte(tableSchema))
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	rows := filepath.Join(dir, "rows.parquet")
+	require.NoError(t, os.WriteFile(rows, []byte("PAR1"), 0o644))
+	image := filepath.Join(dir, "image.png")
+	require.NoError(t, os.WriteFile(image, []byte("png"), 0o644))
+
+	tableInput := func(keyVals map[string][]string) string {
+		return TableInput(NewInputs(keyVals), schema, false)
+	}
+	require.Equal(t, "rows", tableInput(map[string][]string{"rows": {"@" + rows}, "threshold": {"0.5"}}))
+	// Other files can't go in the query string, so they're all sent as JSON
+	require.Equal(t, "", tableInput(map[string][]string{"rows": {"@" + rows}, "image": {"@" + image}}))
+	require.Equal(t, "", tableInput(map[string][]string{"rows": {"@" + image}}))
+	require.Equal(t, "", tableInput(map[string][]string{"image": {"@" + rows}}))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		require.Equal(t, "/predictions", r.URL.Path)
+		require.Equal(t, "0.5", r.URL.Query().Get("threshold"))
+		require.Equal(t, "application/vnd.apache.parquet", r.Header.Get("Content-Type"))
+		require.Equal(t, "PAR1", string(body))
+		_, _ = w.Write([]byte(`{"status":"succeeded","output":"done"}`))
+	}))
+	defer server.Close()
+	port, err := strconv.Atoi(server.URL[strings.LastIndex(server.URL, ":")+1:])
+	require.NoError(t, err)
+
+	predictor := &Predictor{port: port}
+	prediction, err := predictor.PredictTable(NewInputs(map[string][]string{"rows": {"@" + rows}, "threshold": {"0.5"}}), "rows")
+	require.NoError(t, err)
+	require.Equal(t, "done", (*prediction.Output).(string))
+}
@@ -0,0 +1,149 @@
+package predict
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+// UploadChunkSize is how much of a file is sent in each request of an upload
+const UploadChunkSize = 8 * 1024 * 1024
+
+// uploadRetries is how many times in a row a chunk is retried before the upload fails
+const uploadRetries = 5
+
+// UploadStatus is how much of an upload the model's server has received
+type UploadStatus struct {
+	ID       string `json:"id"`
+	URL      string `json:"url"`
+	Offset   int64  `json:"offset"`
+	Size     int64  `json:"size"`
+	Complete bool   `json:"complete"`
+}
+
+// Upload streams the file at path to the model's server in chunks, so it isn't held in memory, and returns the URL
+// to pass as the input. If a chunk fails, the upload is resumed from where the server got to. progress is called
+// when it starts and after each chunk, with how many bytes have been sent and the size of the file.
+func (p *Predictor) Upload(path string, progress func(sent int64, size int64)) (string, error) {
+	expanded, err := homedir.Expand(path)
+	if err != nil {
+		return "", fmt.Errorf("error expanding homedir for '%s': %w", path, err)
+	}
+	file, err := os.Open(expanded)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	body, err := json.Marshal(map[string]any{"filename": filepath.Base(expanded), "size": info.Size()})
+	if err != nil {
+		return "", err
+	}
+	status := &UploadStatus{}
+	if err := p.uploadRequest(http.MethodPost, "/uploads", bytes.NewReader(body), nil, status); err != nil {
+		return "", fmt.Errorf("Failed to start upload of %s: %w", path, err)
+	}
+	progress(status.Offset, status.Size)
+
+	failures := 0
+	for !status.Complete {
+		chunk := io.NewSectionReader(file, status.Offset, min(UploadChunkSize, status.Size-status.Offset))
+		header := http.Header{"Upload-Offset": {fmt.Sprint(status.Offset)}}
+		next := &UploadStatus{}
+		err := p.uploadRequest(http.MethodPatch, "/uploads/"+status.ID, chunk, header, next)
+		if err == nil {
+			status = next
+			failures = 0
+			progress(status.Offset, status.Size)
+			continue
+		}
+		var httpErr *uploadError
+		isHTTPErr := errors.As(err, &httpErr)
+		if isHTTPErr && httpErr.status != http.StatusConflict && httpErr.status < 500 {
+			return "", fmt.Errorf("Failed to upload %s: %w", path, err)
+		}
+		failures++
+		if failures > uploadRetries {
+			return "", fmt.Errorf("Failed to upload %s after %d retries: %w", path, uploadRetries, err)
+		}
+		// A conflict is the server being at a different offset, so there's no need to wait
+		if !isHTTPErr || httpErr.status != http.StatusConflict {
+			time.Sleep(time.Duration(failures) * time.Second)
+		}
+		// Resume from however much the server has
+		if err := p.uploadRequest(http.MethodGet, "/uploads/"+status.ID, nil, nil, next); err == nil {
+			status = next
+		}
+	}
+	return status.URL, nil
+}
+
+// UploadFiles uploads the file inputs with upload, and replaces them with the URLs of the uploads
+func (inputs Inputs) UploadFiles(upload func(path string) (string, error)) error {
+	for key, input := range inputs {
+		switch {
+		case input.File != nil:
+			url, err := upload(*input.File)
+			if err != nil {
+				return err
+			}
+			inputs[key] = Input{String: &url}
+		case input.Array != nil:
+			for i, elem := range *input.Array {
+				if str, ok := elem.(string); ok && strings.HasPrefix(str, "@") {
+					url, err := upload(str[1:])
+					if err != nil {
+						return err
+					}
+					(*input.Array)[i] = url
+				}
+			}
+		}
+	}
+	return nil
+}
+
+type uploadError struct {
+	status int
+	body   string
+}
+
+func (e *uploadError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.status, e.body)
+}
+
+func (p *Predictor) uploadRequest(method string, path string, body io.Reader, header http.Header, status *UploadStatus) error {
+	req, err := http.NewRequest(method, p.serverURL()+path, body)
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if method == http.MethodPost {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return &uploadError{status: resp.StatusCode, body: strings.TrimSpace(string(respBody))}
+	}
+	return json.NewDecoder(resp.Body).Decode(status)
+}
@@ -0,0 +1,78 @@
+package predict
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpload(t *testing.T) {
+	data := strings.Repeat("x", UploadChunkSize+100)
+	path := filepath.Join(t.TempDir(), "big.mp4")
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+
+	var mu sync.Mutex
+	received := []byte{}
+	dropped := false
+	status := func() UploadStatus {
+		return UploadStatus{ID: "abc", URL: "upload:abc", Offset: int64(len(received)), Size: int64(len(data)), Complete: len(received) == len(data)}
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/uploads":
+			var body map[string]any
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			require.Equal(t, "big.mp4", body["filename"])
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPatch && r.URL.Path == "/uploads/abc":
+			chunk, _ := io.ReadAll(r.Body)
+			offset, _ := strconv.Atoi(r.Header.Get("Upload-Offset"))
+			if offset != len(received) {
+				w.WriteHeader(http.StatusConflict)
+				_ = json.NewEncoder(w).Encode(map[string]any{"offset": len(received)})
+				return
+			}
+			received = append(received, chunk...)
+			// Lose the response to the first chunk, so the client resends it
+			if !dropped {
+				dropped = true
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+		case r.Method == http.MethodGet && r.URL.Path == "/uploads/abc":
+		default:
+			t.Fatalf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		_ = json.NewEncoder(w).Encode(status())
+	}))
+	defer server.Close()
+	port, err := strconv.Atoi(server.URL[strings.LastIndex(server.URL, ":")+1:])
+	require.NoError(t, err)
+
+	predictor := &Predictor{port: port}
+	sent := []int64{}
+	url, err := predictor.Upload(path, func(n int64, size int64) {
+		require.Equal(t, int64(len(data)), size)
+		sent = append(sent, n)
+	})
+	require.NoError(t, err)
+	require.Equal(t, "upload:abc", url)
+	require.Equal(t, data, string(received))
+	require.Equal(t, []int64{0, int64(len(data))}, sent)
+
+	inputs := NewInputs(map[string][]string{"video": {"@" + path}, "prompt": {"hello"}})
+	require.NoError(t, inputs.UploadFiles(func(string) (string, error) { return "upload:xyz", nil }))
+	require.Equal(t, "upload:xyz", *inputs["video"].String)
+	require.Equal(t, "hello", *inputs["prompt"].String)
+}
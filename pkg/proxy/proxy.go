@@ -0,0 +1,394 @@
+// Package proxy limits the predictions sent to a model's HTTP server, like the routers in front of models in
+// production do, so load tests and shared development servers behave the same way.
+package proxy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits are the limits on predictions. Zero values mean there's no limit.
+type Limits struct {
+	// MaxInFlight is how many predictions are sent to the model at once
+	MaxInFlight int
+	// QueueLength is how many predictions wait for one of the MaxInFlight to finish. Predictions that arrive when the
+	// queue is full are rejected.
+	QueueLength int
+	// RateLimit is how many predictions each client can make a second, with bursts of up to Burst
+	RateLimit float64
+	Burst     int
+}
+
+// Enabled returns whether any limits are set
+func (l Limits) Enabled() bool {
+	return l.MaxInFlight > 0 || l.RateLimit > 0
+}
+
+// webhookPath is where the model sends the webhooks of async predictions to the proxy, so it knows when they finish
+const webhookPath = "/.cog-proxy/webhooks/"
+
+// idleClientSweep is how often clients whose buckets have filled up again are forgotten
+const idleClientSweep = time.Minute
+
+// Proxy is an http.Handler that sends requests to a model's server, and rejects predictions that break its limits
+// with 429 Too Many Requests and a Retry-After header
+type Proxy struct {
+	limits Limits
+	proxy  *httputil.ReverseProxy
+	now    func() time.Time
+	// callback is the proxy's address as the model can reach it, for the webhooks of async predictions
+	callback *url.URL
+
+	slots chan struct{}
+
+	mu        sync.Mutex
+	queued    int
+	clients   map[string]*bucket
+	lastSweep time.Time
+	async     map[string]*asyncPrediction
+}
+
+// asyncPrediction is an async prediction that holds one of the MaxInFlight slots until the model sends its last
+// webhook
+type asyncPrediction struct {
+	release func()
+	// webhook is the client's webhook, which the model's webhooks are sent on to, if it set one
+	webhook string
+	// completed is whether the client asked for the webhook sent when the prediction finishes
+	completed bool
+}
+
+// New returns a Proxy that sends requests to target
+func New(target *url.URL, limits Limits) *Proxy {
+	p := &Proxy{
+		limits:  limits,
+		proxy:   httputil.NewSingleHostReverseProxy(target),
+		now:     time.Now,
+		clients: map[string]*bucket{},
+		async:   map[string]*asyncPrediction{},
+	}
+	if limits.MaxInFlight > 0 {
+		p.slots = make(chan struct{}, limits.MaxInFlight)
+	}
+	return p
+}
+
+// SetCallback sets the proxy's address as the model can reach it, like http://host.docker.internal:8393. Async
+// predictions then hold one of the MaxInFlight slots until they finish, rather than until the model accepts them:
+// their webhook is sent to the proxy, which passes it on to the client's.
+func (p *Proxy) SetCallback(callback *url.URL) {
+	p.callback = callback
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, webhookPath) {
+		p.handleWebhook(w, r)
+		return
+	}
+	if !isPrediction(r) {
+		p.proxy.ServeHTTP(w, r)
+		return
+	}
+
+	if p.limits.RateLimit > 0 {
+		if wait := p.take(ClientAddress(r)); wait > 0 {
+			tooManyRequests(w, wait, "Too many predictions from this client")
+			return
+		}
+	}
+
+	if p.slots != nil {
+		release, ok := p.acquire(r)
+		if !ok {
+			tooManyRequests(w, time.Second, "Too many predictions are running or queued")
+			return
+		}
+		if p.callback != nil && r.Header.Get("Prefer") == "respond-async" {
+			p.serveAsync(w, r, release)
+			return
+		}
+		defer release()
+	}
+
+	p.proxy.ServeHTTP(w, r)
+}
+
+// serveAsync sends an async prediction to the model with its webhook set to the proxy, so it holds its slot until
+// the proxy gets the webhook sent when it finishes
+func (p *Proxy) serveAsync(w http.ResponseWriter, r *http.Request, release func()) {
+	var once sync.Once
+	prediction := &asyncPrediction{release: func() { once.Do(release) }, completed: true}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		prediction.release()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	request := map[string]interface{}{}
+	if err := json.Unmarshal(body, &request); err != nil || request == nil {
+		// The model rejects it
+		prediction.release()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		p.proxy.ServeHTTP(w, r)
+		return
+	}
+
+	id, err := newWebhookID()
+	if err != nil {
+		prediction.release()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	prediction.webhook, _ = request["webhook"].(string)
+	if events, ok := request["webhook_events_filter"].([]interface{}); ok {
+		prediction.completed = false
+		for _, event := range events {
+			if event == "completed" {
+				prediction.completed = true
+			}
+		}
+		if !prediction.completed {
+			request["webhook_events_filter"] = append(events, "completed")
+		}
+	}
+	request["webhook"] = p.callback.JoinPath(webhookPath, id).String()
+	if body, err = json.Marshal(request); err != nil {
+		prediction.release()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	p.mu.Lock()
+	p.async[id] = prediction
+	p.mu.Unlock()
+	recorder := NewResponseRecorder(w)
+	p.proxy.ServeHTTP(recorder, r)
+	if recorder.Status != http.StatusAccepted {
+		// The model didn't start it, so it won't send webhooks
+		p.finishAsync(id)
+	}
+}
+
+// handleWebhook handles a webhook of an async prediction from the model, passing it on to the client's webhook, and
+// releasing the prediction's slot when it's finished
+func (p *Proxy) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, webhookPath)
+	p.mu.Lock()
+	prediction, ok := p.async[id]
+	p.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var response struct {
+		Status string `json:"status"`
+	}
+	_ = json.Unmarshal(body, &response)
+	finished := response.Status == "succeeded" || response.Status == "failed" || response.Status == "canceled"
+	if finished {
+		prediction.release()
+	}
+
+	if prediction.webhook != "" && (!finished || prediction.completed) {
+		resp, err := http.Post(prediction.webhook, "application/json", bytes.NewReader(body)) //#nosec G107
+		if err != nil {
+			// The model retries the webhooks of finished predictions
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			w.WriteHeader(resp.StatusCode)
+			return
+		}
+	}
+	if finished {
+		p.finishAsync(id)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// finishAsync releases the slot of the async prediction with id, and forgets it
+func (p *Proxy) finishAsync(id string) {
+	p.mu.Lock()
+	prediction, ok := p.async[id]
+	delete(p.async, id)
+	p.mu.Unlock()
+	if ok {
+		prediction.release()
+	}
+}
+
+func newWebhookID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// acquire waits for one of the MaxInFlight slots, and returns false if the queue is full or the request is canceled
+func (p *Proxy) acquire(r *http.Request) (release func(), ok bool) {
+	release = func() { <-p.slots }
+	select {
+	case p.slots <- struct{}{}:
+		return release, true
+	default:
+	}
+
+	p.mu.Lock()
+	if p.queued >= p.limits.QueueLength {
+		p.mu.Unlock()
+		return nil, false
+	}
+	p.queued++
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.queued--
+		p.mu.Unlock()
+	}()
+
+	select {
+	case p.slots <- struct{}{}:
+		return release, true
+	case <-r.Context().Done():
+		return nil, false
+	}
+}
+
+// bucket is a token bucket for a client's predictions
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take takes a token from the client's bucket, or returns how long until there's one
+func (p *Proxy) take(client string) time.Duration {
+	burst := float64(p.limits.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	p.sweepClients(now, burst)
+	b, ok := p.clients[client]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		p.clients[client] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*p.limits.RateLimit)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / p.limits.RateLimit * float64(time.Second))
+}
+
+// sweepClients forgets clients whose buckets have filled up again, which are the same as new ones, so clients that
+// stop making predictions don't use memory forever
+func (p *Proxy) sweepClients(now time.Time, burst float64) {
+	if now.Sub(p.lastSweep) < idleClientSweep {
+		return
+	}
+	p.lastSweep = now
+	for client, b := range p.clients {
+		if b.tokens+now.Sub(b.last).Seconds()*p.limits.RateLimit >= burst {
+			delete(p.clients, client)
+		}
+	}
+}
+
+// isPrediction returns whether r starts a prediction or training, which are what the limits apply to
+func isPrediction(r *http.Request) bool {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		return false
+	}
+	for _, prefix := range []string{"/predictions", "/trainings"} {
+		if r.URL.Path == prefix || (strings.HasPrefix(r.URL.Path, prefix+"/") && !strings.HasSuffix(r.URL.Path, "/cancel")) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientAddress returns the IP address of the client that sent r
+func ClientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ResponseRecorder is an http.ResponseWriter that records the status code of the response it writes
+type ResponseRecorder struct {
+	http.ResponseWriter
+	Status int
+	// Body is what the body of the response is copied to, if it's set
+	Body *bytes.Buffer
+}
+
+// NewResponseRecorder returns a ResponseRecorder that writes to w
+func NewResponseRecorder(w http.ResponseWriter) *ResponseRecorder {
+	return &ResponseRecorder{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (r *ResponseRecorder) WriteHeader(status int) {
+	r.Status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *ResponseRecorder) Write(b []byte) (int, error) {
+	if r.Body != nil {
+		r.Body.Write(b)
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter
+func (r *ResponseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Flush flushes what's been written, so streamed responses and server-sent events are passed on as they're written
+func (r *ResponseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func tooManyRequests(w http.ResponseWriter, retryAfter time.Duration, message string) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	_, _ = w.Write([]byte(`{"detail":"` + message + `"}`))
+}
@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestProxy(t *testing.T, limits Limits, handler http.Handler) (*Proxy, *httptest.Server) {
+	t.Helper()
+	model := httptest.NewServer(handler)
+	t.Cleanup(model.Close)
+	target, err := url.Parse(model.URL)
+	require.NoError(t, err)
+	p := New(target, limits)
+	server := httptest.NewServer(p)
+	t.Cleanup(server.Close)
+	return p, server
+}
+
+func post(t *testing.T, server *httptest.Server, path string) *http.Response {
+	t.Helper()
+	resp, err := http.Post(server.URL+path, "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	return resp
+}
+
+func TestRateLimit(t *testing.T) {
+	_, server := newTestProxy(t, Limits{RateLimit: 0.5, Burst: 2}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	require.Equal(t, http.StatusOK, post(t, server, "/predictions").StatusCode)
+	require.Equal(t, http.StatusOK, post(t, server, "/predictions").StatusCode)
+	resp := post(t, server, "/predictions")
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, "2", resp.Header.Get("Retry-After"))
+
+	// Only predictions are limited
+	getResp, err := http.Get(server.URL + "/health-check")
+	require.NoError(t, err)
+	getResp.Body.Close()
+	require.Equal(t, http.StatusOK, getResp.StatusCode)
+	require.Equal(t, http.StatusOK, post(t, server, "/predictions/abc/cancel").StatusCode)
+}
+
+func TestRateLimitRefills(t *testing.T) {
+	now := time.Now()
+	p := New(&url.URL{}, Limits{RateLimit: 1})
+	p.now = func() time.Time { return now }
+
+	require.Zero(t, p.take("a"))
+	require.Equal(t, time.Second, p.take("a"))
+	require.Zero(t, p.take("b"))
+	now = now.Add(time.Second)
+	require.Zero(t, p.take("a"))
+}
+
+func TestMaxInFlightAndQueue(t *testing.T) {
+	started := make(chan struct{}, 3)
+	finish := make(chan struct{})
+	p, server := newTestProxy(t, Limits{MaxInFlight: 1, QueueLength: 1}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-finish
+	}))
+
+	var wg sync.WaitGroup
+	codes := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- post(t, server, "/predictions").StatusCode
+		}()
+		if i == 0 {
+			<-started
+		}
+	}
+
+	// One prediction is running and one is queued, so the queue is full
+	require.Eventually(t, func() bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.queued == 1
+	}, time.Second, 10*time.Millisecond)
+	resp := post(t, server, "/predictions")
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, "1", resp.Header.Get("Retry-After"))
+
+	close(finish)
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		require.Equal(t, http.StatusOK, code)
+	}
+}
+
+func TestAsyncPredictionHoldsSlot(t *testing.T) {
+	webhooks := make(chan string, 2)
+	client := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		webhooks <- string(body)
+	}))
+	defer client.Close()
+
+	var modelWebhook string
+	p, server := newTestProxy(t, Limits{MaxInFlight: 1}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		modelWebhook = request["webhook"].(string)
+		require.Equal(t, []interface{}{"start", "completed"}, request["webhook_events_filter"])
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	callback, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	p.SetCallback(callback)
+
+	asyncPost := func(body string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/predictions", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Prefer", "respond-async")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	request := fmt.Sprintf(`{"input":{},"webhook":%q,"webhook_events_filter":["start"]}`, client.URL)
+	require.Equal(t, http.StatusAccepted, asyncPost(request).StatusCode)
+	require.True(t, strings.HasPrefix(modelWebhook, server.URL+webhookPath))
+
+	// It's still running, so it holds the only slot
+	require.Equal(t, http.StatusTooManyRequests, asyncPost(request).StatusCode)
+
+	resp, err := http.Post(modelWebhook, "application/json", strings.NewReader(`{"status":"processing"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, `{"status":"processing"}`, <-webhooks)
+
+	// The client didn't ask for the completed webhook, so it's not passed on, but the slot is released
+	resp, err = http.Post(modelWebhook, "application/json", strings.NewReader(`{"status":"succeeded"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Empty(t, webhooks)
+	require.Equal(t, http.StatusAccepted, asyncPost(request).StatusCode)
+
+	// Only the model, which was given the webhook, can send it
+	resp, err = http.Post(server.URL+webhookPath+"guessed", "application/json", strings.NewReader(`{"status":"succeeded"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestIdleClientsAreForgotten(t *testing.T) {
+	now := time.Now()
+	p := New(&url.URL{}, Limits{RateLimit: 1, Burst: 2})
+	p.now = func() time.Time { return now }
+
+	require.Zero(t, p.take("a"))
+	require.Zero(t, p.take("b"))
+	now = now.Add(2 * idleClientSweep)
+	require.Zero(t, p.take("c"))
+	require.Len(t, p.clients, 1)
+}
@@ -0,0 +1,87 @@
+package requirements
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const PYPROJECT_FILE = "pyproject.toml"
+
+var pythonVersionPattern = regexp.MustCompile(`3\.\d+`)
+
+// PyprojectDependencies returns the dependencies in the [project] table of the pyproject.toml at path, as they're
+// defined by PEP 621, and the oldest version of Python in its requires-python, like 3.10, or "" if it isn't set
+func PyprojectDependencies(path string) (dependencies []string, pythonVersion string, err error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	table := ""
+	found := false
+	lines := strings.Split(strings.ReplaceAll(string(contents), "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(line, "[") {
+			table = strings.Trim(line, "[] ")
+			continue
+		}
+		if table != "project" {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "requires-python":
+			pythonVersion = pythonVersionPattern.FindString(value)
+		case "dependencies":
+			// The array can span lines, so parse the rest of the file until it's closed
+			rest := strings.Join(append([]string{value}, lines[i+1:]...), "\n")
+			var consumed int
+			dependencies, consumed, err = parseTOMLStringArray(rest)
+			if err != nil {
+				return nil, "", fmt.Errorf("Failed to parse dependencies in %s: %w", path, err)
+			}
+			i += strings.Count(rest[:consumed], "\n")
+			found = true
+		}
+	}
+	if !found {
+		return nil, "", fmt.Errorf("%s doesn't list dependencies in its [project] table. Only dependencies in the format of PEP 621 can be imported.", path)
+	}
+	return dependencies, pythonVersion, nil
+}
+
+// parseTOMLStringArray parses the array of strings at the start of s, and returns its strings and how much of s it
+// was
+func parseTOMLStringArray(s string) ([]string, int, error) {
+	start := strings.Index(s, "[")
+	if start < 0 || strings.TrimSpace(s[:start]) != "" {
+		return nil, 0, fmt.Errorf("it isn't an array")
+	}
+	values := []string{}
+	for i := start + 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case ']':
+			return values, i + 1, nil
+		case '#':
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+		case '"', '\'':
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				return nil, 0, fmt.Errorf("a string isn't closed")
+			}
+			values = append(values, s[i+1:i+1+end])
+			i += end + 1
+		case ' ', '\t', '\n', '\r', ',':
+		default:
+			return nil, 0, fmt.Errorf("it has something other than strings in it")
+		}
+	}
+	return nil, 0, fmt.Errorf("the array isn't closed")
+}
@@ -72,3 +72,38 @@ flask>0.4
 	require.NoError(t, err)
 	require.Equal(t, []string{"foo==1.0.0", "fastapi>=0.6,<1", "flask>0.4", "-f http://example.com"}, requirements)
 }
+
+func TestPyprojectDependencies(t *testing.T) {
+	srcDir := t.TempDir()
+	pyproject := path.Join(srcDir, "pyproject.toml")
+	err := os.WriteFile(pyproject, []byte(`[build-system]
+requires = ["setuptools"]
+
+[project]
+name = "my-model"
+requires-python = ">=3.10"
+dependencies = [
+    "torch==2.5.1",  # the model needs CUDA 12
+    'transformers[torch]>=4.40,<5',
+]
+
+[project.optional-dependencies]
+dev = ["pytest"]
+`), 0o644)
+	require.NoError(t, err)
+
+	dependencies, pythonVersion, err := PyprojectDependencies(pyproject)
+	require.NoError(t, err)
+	require.Equal(t, []string{"torch==2.5.1", "transformers[torch]>=4.40,<5"}, dependencies)
+	require.Equal(t, "3.10", pythonVersion)
+}
+
+func TestPyprojectDependenciesMissing(t *testing.T) {
+	srcDir := t.TempDir()
+	pyproject := path.Join(srcDir, "pyproject.toml")
+	err := os.WriteFile(pyproject, []byte("[tool.poetry.dependencies]\npython = \"^3.11\"\n"), 0o644)
+	require.NoError(t, err)
+
+	_, _, err = PyprojectDependencies(pyproject)
+	require.ErrorContains(t, err, "doesn't list dependencies in its [project] table")
+}
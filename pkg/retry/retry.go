@@ -0,0 +1,194 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// Policy is how an operation that talks to Docker or a registry, like pulling or pushing an image, is retried when
+// it fails with an error that might go away, like a dropped connection or a registry that's briefly unavailable
+type Policy struct {
+	// Attempts is how many times the operation is tried, including the first time
+	Attempts int
+	// Delay is how long to wait before the first retry. It doubles after each retry, up to MaxDelay.
+	Delay time.Duration
+	// MaxDelay is the longest to wait between retries
+	MaxDelay time.Duration
+}
+
+// DefaultPolicy is the policy used if cog.yaml doesn't set one
+var DefaultPolicy = Policy{Attempts: 3, Delay: 2 * time.Second, MaxDelay: 30 * time.Second}
+
+var (
+	mu            sync.Mutex
+	defaultPolicy = DefaultPolicy
+)
+
+// sleep is replaced in tests
+var sleep = time.Sleep
+
+// SetDefault sets the policy used by Do
+func SetDefault(policy Policy) {
+	mu.Lock()
+	defer mu.Unlock()
+	defaultPolicy = policy
+}
+
+// Default returns the policy used by Do
+func Default() Policy {
+	mu.Lock()
+	defer mu.Unlock()
+	return defaultPolicy
+}
+
+// Do runs fn with the default policy
+func Do(operation string, fn func() error) error {
+	return Default().Do(operation, fn)
+}
+
+// Do runs fn until it succeeds, it fails with an error that isn't retryable, or it has been tried as many times as
+// the policy allows. operation describes what fn does, like "Pulling r8.im/user/model", for the warning printed
+// before each retry. It returns the last error fn returned, without the mark if it was marked with Fatal or
+// Retryable.
+func (p Policy) Do(operation string, fn func() error) error {
+	delay := p.Delay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.Attempts || !IsRetryable(err) {
+			return unmark(err)
+		}
+		console.Warnf("%s failed, retrying in %s (attempt %d of %d): %s", operation, delay, attempt+1, p.Attempts, err)
+		sleep(delay)
+		delay = min(delay*2, p.MaxDelay)
+	}
+}
+
+type fatalError struct{ error }
+
+func (e *fatalError) Unwrap() error { return e.error }
+
+type retryableError struct{ error }
+
+func (e *retryableError) Unwrap() error { return e.error }
+
+func unmark(err error) error {
+	switch e := err.(type) {
+	case *fatalError:
+		return e.error
+	case *retryableError:
+		return e.error
+	}
+	return err
+}
+
+// Fatal marks err as not worth retrying, even if it looks like it is
+func Fatal(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &fatalError{err}
+}
+
+// Retryable marks err as worth retrying, even if it doesn't look like it is
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &retryableError{err}
+}
+
+// fatalMessages are in errors that won't go away by trying again, like bad credentials or a missing image. They're
+// checked before transientMessages, because registries sometimes say an image is missing with a 5xx status.
+var fatalMessages = []string{
+	"unauthorized",
+	"authentication required",
+	"denied",
+	"not found",
+	"manifest unknown",
+	"name_unknown",
+	"no such image",
+	"invalid reference format",
+}
+
+// transientMessages are in errors that might go away by trying again, from Docker, registries and the network
+var transientMessages = []string{
+	"connection reset",
+	"connection refused",
+	"broken pipe",
+	"i/o timeout",
+	"tls handshake timeout",
+	"timeout exceeded",
+	"timed out",
+	"unexpected eof",
+	"temporary failure in name resolution",
+	"too many requests",
+	"toomanyrequests",
+	"internal server error",
+	"bad gateway",
+	"service unavailable",
+	"gateway timeout",
+}
+
+// Classify marks err, from a command that printed output, as retryable or fatal by what the output says. Errors
+// from running a command, like "exit status 1", don't say why it failed.
+func Classify(err error, output string) error {
+	if err == nil {
+		return nil
+	}
+	output = strings.ToLower(output)
+	if containsAny(output, fatalMessages) {
+		return Fatal(err)
+	}
+	if containsAny(output, transientMessages) {
+		return Retryable(err)
+	}
+	return err
+}
+
+// IsRetryable returns whether err might go away if the operation that returned it is tried again. Errors are only
+// retried if they're known to be transient, so anything unexpected fails straight away.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var fatal *fatalError
+	if errors.As(err, &fatal) {
+		return false
+	}
+	var retryable *retryableError
+	if errors.As(err, &retryable) {
+		return true
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	if containsAny(msg, fatalMessages) {
+		return false
+	}
+	// Registry errors from go-containerregistry say whether they're temporary, like 429s and 5xxs
+	var temporary interface{ Temporary() bool }
+	if errors.As(err, &temporary) && temporary.Temporary() {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return containsAny(msg, transientMessages)
+}
+
+func containsAny(s string, substrs []string) bool {
+	for _, substr := range substrs {
+		if strings.Contains(s, substr) {
+			return true
+		}
+	}
+	return false
+}
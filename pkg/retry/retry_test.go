@@ -0,0 +1,93 @@
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func noSleep(t *testing.T) *[]time.Duration {
+	t.Helper()
+	delays := []time.Duration{}
+	sleep = func(d time.Duration) { delays = append(delays, d) }
+	t.Cleanup(func() { sleep = time.Sleep })
+	return &delays
+}
+
+func TestDoRetriesTransientErrors(t *testing.T) {
+	delays := noSleep(t)
+	policy := Policy{Attempts: 4, Delay: time.Second, MaxDelay: 3 * time.Second}
+	calls := 0
+	err := policy.Do("Pulling", func() error {
+		calls++
+		if calls < 4 {
+			return errors.New("read tcp: connection reset by peer")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 4, calls)
+	require.Equal(t, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}, *delays)
+}
+
+func TestDoGivesUpAfterAttempts(t *testing.T) {
+	noSleep(t)
+	calls := 0
+	err := Policy{Attempts: 2, Delay: time.Second, MaxDelay: time.Second}.Do("Pushing", func() error {
+		calls++
+		return errors.New("503 Service Unavailable")
+	})
+	require.EqualError(t, err, "503 Service Unavailable")
+	require.Equal(t, 2, calls)
+}
+
+func TestDoReturnsUnmarkedErrors(t *testing.T) {
+	noSleep(t)
+	errFailed := errors.New("failed")
+	err := Policy{Attempts: 3}.Do("Running", func() error {
+		return Fatal(errFailed)
+	})
+	require.Equal(t, errFailed, err)
+}
+
+func TestDoDoesNotRetryFatalErrors(t *testing.T) {
+	noSleep(t)
+	for _, err := range []error{
+		errors.New("exit status 1"),
+		errors.New("unauthorized: authentication required"),
+		Fatal(errors.New("connection reset by peer")),
+	} {
+		calls := 0
+		result := Policy{Attempts: 3}.Do("Pulling", func() error {
+			calls++
+			return err
+		})
+		require.EqualError(t, result, err.Error())
+		require.Equal(t, 1, calls, err.Error())
+	}
+}
+
+type temporaryError struct{ temporary bool }
+
+func (e temporaryError) Error() string   { return "registry error" }
+func (e temporaryError) Temporary() bool { return e.temporary }
+
+func TestIsRetryable(t *testing.T) {
+	require.False(t, IsRetryable(nil))
+	require.True(t, IsRetryable(fmt.Errorf("Failed to fetch: %w", temporaryError{true})))
+	require.False(t, IsRetryable(temporaryError{false}))
+	require.True(t, IsRetryable(Retryable(errors.New("exit status 125"))))
+	require.False(t, IsRetryable(errors.New("manifest unknown: 503 Service Unavailable")))
+}
+
+func TestClassify(t *testing.T) {
+	err := errors.New("exit status 1")
+	require.True(t, IsRetryable(Classify(err, "Error response from daemon: Get https://r8.im/v2/: net/http: TLS handshake timeout")))
+	require.False(t, IsRetryable(Classify(err, "Error response from daemon: pull access denied for foo")))
+	require.False(t, IsRetryable(Classify(err, "something else")))
+	require.ErrorIs(t, Classify(err, "i/o timeout"), err)
+	require.NoError(t, Classify(nil, "i/o timeout"))
+}
@@ -0,0 +1,125 @@
+// Package secrets finds credentials, like cloud access keys, API tokens and private keys, in files that are about
+// to go into a model image.
+package secrets
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// MaxFileSize is the size of the largest file that's scanned. Larger files are weights or datasets.
+const MaxFileSize = 10 * 1024 * 1024
+
+// AllowMarker is a comment that allows a secret on the line it's on, like for a documented example key
+const AllowMarker = "cog:allow-secret"
+
+// Rule is a kind of credential
+type Rule struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+// Rules are the credentials that are looked for
+var Rules = []Rule{
+	{"AWS access key ID", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"AWS secret access key", regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?[A-Za-z0-9/+]{40}\b`)},
+	{"Hugging Face token", regexp.MustCompile(`\bhf_[A-Za-z0-9]{34,}\b`)},
+	{"Replicate API token", regexp.MustCompile(`\br8_[A-Za-z0-9]{37}\b`)},
+	{"GitHub token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36}|github_pat_[A-Za-z0-9_]{82})\b`)},
+	{"OpenAI API key", regexp.MustCompile(`\bsk-(?:proj-)?[A-Za-z0-9_-]{20,}T3BlbkFJ[A-Za-z0-9_-]{20,}`)},
+	{"Private key", regexp.MustCompile(`-----BEGIN (?:[A-Z]+ )*PRIVATE KEY-----`)},
+}
+
+// Finding is a credential in a file
+type Finding struct {
+	Path string
+	// Line is the line the credential is on, starting at 1
+	Line int
+	Rule string
+	// Redacted is the start of the credential, so it can be recognized without being printed
+	Redacted string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s:%d: %s (%s)", f.Path, f.Line, f.Rule, f.Redacted)
+}
+
+// Scan returns the credentials in r, which are reported as being in path. Binary files aren't scanned.
+func Scan(path string, r io.Reader) ([]Finding, error) {
+	reader := bufio.NewReader(r)
+	// Like git, a file is binary if there's a NUL byte near the start
+	head, err := reader.Peek(8000)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, err
+	}
+	if bytes.IndexByte(head, 0) >= 0 {
+		return nil, nil
+	}
+
+	findings := []Finding{}
+	for lineNo := 1; ; lineNo++ {
+		line, err := reader.ReadString('\n')
+		if line != "" && !strings.Contains(line, AllowMarker) {
+			findings = append(findings, ScanLine(path, lineNo, line)...)
+		}
+		if errors.Is(err, io.EOF) {
+			return findings, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// ScanLine returns the credentials in a line of a file
+func ScanLine(path string, lineNo int, line string) []Finding {
+	findings := []Finding{}
+	for _, rule := range Rules {
+		for _, match := range rule.Pattern.FindAllString(line, -1) {
+			findings = append(findings, Finding{Path: path, Line: lineNo, Rule: rule.Name, Redacted: redact(match)})
+		}
+	}
+	return findings
+}
+
+// Sort sorts findings by path and line, and removes duplicates, like the same file in more than one image layer
+func Sort(findings []Finding) []Finding {
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Path != findings[j].Path {
+			return findings[i].Path < findings[j].Path
+		}
+		return findings[i].Line < findings[j].Line
+	})
+	unique := []Finding{}
+	for i, finding := range findings {
+		if i == 0 || finding != findings[i-1] {
+			unique = append(unique, finding)
+		}
+	}
+	return unique
+}
+
+// Error is the error for finding credentials
+func Error(where string, findings []Finding) error {
+	lines := []string{}
+	for _, finding := range findings {
+		lines = append(lines, "  "+finding.String())
+	}
+	return fmt.Errorf("Found %d credentials in %s:\n%s\n\nRemove them and rotate them, because they may have already leaked. If they're not real, add a '%s' comment to the line, or add the file to build.allow_secrets in cog.yaml.", len(findings), where, strings.Join(lines, "\n"), AllowMarker)
+}
+
+func redact(match string) string {
+	if strings.HasPrefix(match, "-----") {
+		return match
+	}
+	if len(match) <= 8 {
+		return strings.Repeat("*", len(match))
+	}
+	return match[:8] + strings.Repeat("*", 8)
+}
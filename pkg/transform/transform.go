@@ -0,0 +1,207 @@
+// Package transform converts and shrinks file outputs of predictions, like a huge generated image or video, so
+// they're quick to look at.
+package transform
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/replicate/cog/pkg/util/console"
+	"github.com/replicate/cog/pkg/util/mime"
+)
+
+// Formats are the formats outputs can be converted to
+var Formats = []string{"png", "jpeg", "jpg", "gif", "webp", "mp4", "webm"}
+
+var imageFormats = []string{"png", "jpeg", "jpg", "gif", "webp"}
+var videoFormats = []string{"mp4", "webm", "gif"}
+
+// Options are the transforms to make to a file output
+type Options struct {
+	// Format is the format to convert it to, from Formats. It's kept in its format if it's empty.
+	Format string
+	// MaxSize is the largest width or height of an image or video, in pixels. It isn't resized if it's 0.
+	MaxSize int
+}
+
+// IsZero returns whether there's nothing to transform
+func (o Options) IsZero() bool {
+	return o.Format == "" && o.MaxSize == 0
+}
+
+// Validate returns an error if the options aren't valid
+func (o Options) Validate() error {
+	if o.Format != "" && !slices.Contains(Formats, o.Format) {
+		return fmt.Errorf("Can't convert outputs to %s. The formats are %s.", o.Format, strings.Join(Formats, ", "))
+	}
+	if o.MaxSize < 0 {
+		return fmt.Errorf("The maximum size of outputs can't be negative")
+	}
+	return nil
+}
+
+// Apply writes a transformed copy of the file at path next to it, and returns its path. The original is kept. It
+// returns an empty path if the file isn't an image or video, or can't be converted to the format, like a video to
+// a PNG.
+//
+// PNGs and JPEGs are transformed with Go's image packages. Other formats, like WebP and video, need ffmpeg.
+func Apply(path string, options Options) (string, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	typ := mime.TypeByExtension(ext)
+	isImage := strings.HasPrefix(typ, "image/")
+	isVideo := strings.HasPrefix(typ, "video/")
+	if options.IsZero() || (!isImage && !isVideo) {
+		return "", nil
+	}
+
+	format := options.Format
+	if format == "" {
+		format = strings.TrimPrefix(ext, ".")
+	}
+	// A GIF can be an animation, so it can be converted to and from video
+	if (isImage && ext != ".gif" && !slices.Contains(imageFormats, format)) || (isVideo && !slices.Contains(videoFormats, format)) {
+		console.Warnf("Can't convert %s to %s", path, format)
+		return "", nil
+	}
+
+	dest := destination(path, format, options.MaxSize)
+	if dest == path {
+		return "", nil
+	}
+	if goCanTransform(ext, format) {
+		return dest, transformImage(path, dest, format, options.MaxSize)
+	}
+	return dest, ffmpeg(path, dest, format, options.MaxSize)
+}
+
+// destination is the path of the transformed copy of path, like output.1024.webp
+func destination(path string, format string, maxSize int) string {
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	if maxSize > 0 {
+		base += fmt.Sprintf(".%d", maxSize)
+	}
+	return base + "." + format
+}
+
+func goCanTransform(ext string, format string) bool {
+	// GIFs can be animated, and image.Decode only reads the first frame, so they're left to ffmpeg
+	goFormats := []string{"png", "jpeg", "jpg"}
+	return slices.Contains(goFormats, strings.TrimPrefix(ext, ".")) && (format == "gif" || slices.Contains(goFormats, format))
+}
+
+func transformImage(path string, dest string, format string, maxSize int) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	img, _, err := image.Decode(in)
+	if err != nil {
+		return fmt.Errorf("Failed to decode %s: %w", path, err)
+	}
+	if maxSize > 0 {
+		img = Downscale(img, maxSize)
+	}
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case "png":
+		err = png.Encode(out, img)
+	case "jpeg", "jpg":
+		err = jpeg.Encode(out, img, &jpeg.Options{Quality: 90})
+	case "gif":
+		err = gif.Encode(out, img, nil)
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to write %s: %w", dest, err)
+	}
+	return nil
+}
+
+func ffmpeg(path string, dest string, format string, maxSize int) error {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return fmt.Errorf("Converting %s to %s needs ffmpeg, which isn't installed", path, format)
+	}
+	args := []string{"-y", "-loglevel", "error", "-i", path}
+	if maxSize > 0 {
+		// Only scale down, keeping the aspect ratio. Video encoders need even dimensions.
+		filter := fmt.Sprintf("scale='min(%[1]d,iw)':'min(%[1]d,ih)':force_original_aspect_ratio=decrease", maxSize)
+		if format == "mp4" || format == "webm" {
+			filter += ":force_divisible_by=2"
+		}
+		args = append(args, "-vf", filter)
+	}
+	if format == "png" || format == "jpeg" || format == "jpg" {
+		// The first frame of an animation or video
+		args = append(args, "-frames:v", "1")
+	}
+	args = append(args, dest)
+
+	cmd := exec.Command("ffmpeg", args...)
+	console.Debug("$ " + strings.Join(cmd.Args, " "))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		_ = os.Remove(dest)
+		return fmt.Errorf("Failed to convert %s to %s: %w\n%s", path, format, err, output)
+	}
+	return nil
+}
+
+// Downscale shrinks img so its width and height are at most maxSize, keeping its aspect ratio. Each pixel is the
+// average of the pixels it covers, so fine detail doesn't alias. It's returned as it is if it's small enough.
+func Downscale(img image.Image, maxSize int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= maxSize && h <= maxSize {
+		return img
+	}
+	dw, dh := maxSize, maxSize
+	if w >= h {
+		dh = max(1, h*maxSize/w)
+	} else {
+		dw = max(1, w*maxSize/h)
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0 := y * h / dh
+		y1 := max(y0+1, (y+1)*h/dh)
+		for x := 0; x < dw; x++ {
+			x0 := x * w / dw
+			x1 := max(x0+1, (x+1)*w/dw)
+			// RGBA is alpha-premultiplied, so the channels can be averaged separately
+			var sum [4]uint64
+			for sy := y0; sy < y1; sy++ {
+				offset := sy*src.Stride + x0*4
+				for sx := x0; sx < x1; sx++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += uint64(src.Pix[offset+c])
+					}
+					offset += 4
+				}
+			}
+			n := uint64((y1 - y0) * (x1 - x0))
+			offset := y*dst.Stride + x*4
+			for c := 0; c < 4; c++ {
+				dst.Pix[offset+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
@@ -0,0 +1,93 @@
+package transform
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownscale(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 400, 100))
+	// Alternate black and white columns, which should average to grey
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 400; x++ {
+			if x%2 == 0 {
+				img.Set(x, y, color.White)
+			} else {
+				img.Set(x, y, color.Black)
+			}
+		}
+	}
+
+	small := Downscale(img, 100)
+	require.Equal(t, image.Rect(0, 0, 100, 25), small.Bounds())
+	r, g, b, a := small.At(50, 10).RGBA()
+	require.InDelta(t, 0x7f7f, r, 0x200)
+	require.Equal(t, r, g)
+	require.Equal(t, r, b)
+	require.Equal(t, uint32(0xffff), a)
+
+	require.Same(t, img, Downscale(img, 400))
+}
+
+func TestApplyImage(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "output.png")
+	writePNG(t, path, 2048, 1024)
+
+	dest, err := Apply(path, Options{Format: "jpg", MaxSize: 512})
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "output.512.jpg"), dest)
+
+	f, err := os.Open(dest)
+	require.NoError(t, err)
+	defer f.Close()
+	config, err := jpeg.DecodeConfig(f)
+	require.NoError(t, err)
+	require.Equal(t, 512, config.Width)
+	require.Equal(t, 256, config.Height)
+
+	// The original is kept
+	require.FileExists(t, path)
+}
+
+func TestApplySkipsOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "output.txt")
+	require.NoError(t, os.WriteFile(path, []byte("hello"), 0o644))
+	dest, err := Apply(path, Options{Format: "webp"})
+	require.NoError(t, err)
+	require.Empty(t, dest)
+
+	// Nothing to do if it's already in the format
+	path = filepath.Join(dir, "output.png")
+	writePNG(t, path, 10, 10)
+	dest, err = Apply(path, Options{Format: "png"})
+	require.NoError(t, err)
+	require.Empty(t, dest)
+
+	// Images can't be converted to video
+	dest, err = Apply(path, Options{Format: "mp4"})
+	require.NoError(t, err)
+	require.Empty(t, dest)
+}
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, Options{Format: "webp", MaxSize: 1024}.Validate())
+	require.ErrorContains(t, Options{Format: "bmp"}.Validate(), "Can't convert outputs to bmp")
+	require.Error(t, Options{MaxSize: -1}.Validate())
+}
+
+func writePNG(t *testing.T, path string, width int, height int) {
+	t.Helper()
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, png.Encode(f, image.NewRGBA(image.Rect(0, 0, width, height))))
+}
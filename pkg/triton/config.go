@@ -0,0 +1,158 @@
+package triton
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+type Tensor struct {
+	Name     string
+	DataType string
+	Dims     []int
+	Optional bool
+}
+
+type ModelConfig struct {
+	Name    string
+	GPU     bool
+	Inputs  []Tensor
+	Outputs []Tensor
+}
+
+// NewModelConfig derives the Triton model configuration from a Cog model's OpenAPI schema.
+func NewModelConfig(name string, schema *openapi3.T, gpu bool) (*ModelConfig, error) {
+	if schema.Components == nil {
+		return nil, fmt.Errorf("Model schema has no components")
+	}
+	inputRef, ok := schema.Components.Schemas["Input"]
+	if !ok || inputRef.Value == nil {
+		return nil, fmt.Errorf("Model schema has no Input")
+	}
+	outputRef, ok := schema.Components.Schemas["Output"]
+	if !ok || outputRef.Value == nil {
+		return nil, fmt.Errorf("Model schema has no Output")
+	}
+
+	required := map[string]bool{}
+	for _, name := range inputRef.Value.Required {
+		required[name] = true
+	}
+
+	inputs := []Tensor{}
+	for _, name := range orderedProperties(inputRef.Value) {
+		prop := inputRef.Value.Properties[name].Value
+		dataType, dims := tensorType(prop)
+		inputs = append(inputs, Tensor{
+			Name:     name,
+			DataType: dataType,
+			Dims:     dims,
+			Optional: !required[name],
+		})
+	}
+
+	dataType, dims := tensorType(outputRef.Value)
+	outputs := []Tensor{{Name: "output", DataType: dataType, Dims: dims}}
+
+	return &ModelConfig{
+		Name:    name,
+		GPU:     gpu,
+		Inputs:  inputs,
+		Outputs: outputs,
+	}, nil
+}
+
+// String renders the model configuration in the protobuf text format used for config.pbtxt
+func (c *ModelConfig) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "name: %q\n", c.Name)
+	b.WriteString("backend: \"python\"\n")
+	b.WriteString("max_batch_size: 0\n")
+	writeTensors(&b, "input", c.Inputs)
+	writeTensors(&b, "output", c.Outputs)
+	kind := "KIND_CPU"
+	if c.GPU {
+		kind = "KIND_GPU"
+	}
+	fmt.Fprintf(&b, "instance_group [\n  {\n    count: 1\n    kind: %s\n  }\n]\n", kind)
+	return b.String()
+}
+
+func writeTensors(b *strings.Builder, field string, tensors []Tensor) {
+	fmt.Fprintf(b, "%s [\n", field)
+	for i, t := range tensors {
+		dims := []string{}
+		for _, d := range t.Dims {
+			dims = append(dims, fmt.Sprintf("%d", d))
+		}
+		b.WriteString("  {\n")
+		fmt.Fprintf(b, "    name: %q\n", t.Name)
+		fmt.Fprintf(b, "    data_type: %s\n", t.DataType)
+		fmt.Fprintf(b, "    dims: [ %s ]\n", strings.Join(dims, ", "))
+		if t.Optional {
+			b.WriteString("    optional: true\n")
+		}
+		b.WriteString("  }")
+		if i < len(tensors)-1 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("]\n")
+}
+
+// tensorType maps a JSON schema type to a Triton data type and shape.
+// Anything that isn't a scalar or a list of scalars is passed as a JSON encoded string.
+func tensorType(schema *openapi3.Schema) (string, []int) {
+	if schema.Type.Is("array") && schema.Items != nil && schema.Items.Value != nil {
+		if dataType, ok := scalarType(schema.Items.Value); ok {
+			return dataType, []int{-1}
+		}
+	}
+	if dataType, ok := scalarType(schema); ok {
+		return dataType, []int{1}
+	}
+	return "TYPE_STRING", []int{1}
+}
+
+func scalarType(schema *openapi3.Schema) (string, bool) {
+	switch {
+	case schema.Type.Is("integer"):
+		return "TYPE_INT64", true
+	case schema.Type.Is("number"):
+		return "TYPE_FP32", true
+	case schema.Type.Is("boolean"):
+		return "TYPE_BOOL", true
+	case schema.Type.Is("string"):
+		return "TYPE_STRING", true
+	}
+	return "", false
+}
+
+// orderedProperties returns property names sorted by their x-order extension, then by name.
+func orderedProperties(schema *openapi3.Schema) []string {
+	names := []string{}
+	for name := range schema.Properties {
+		names = append(names, name)
+	}
+	order := func(name string) float64 {
+		prop := schema.Properties[name].Value
+		if prop == nil {
+			return 0
+		}
+		if v, ok := prop.Extensions["x-order"].(float64); ok {
+			return v
+		}
+		return 0
+	}
+	sort.Slice(names, func(i, j int) bool {
+		oi, oj := order(names[i]), order(names[j])
+		if oi != oj {
+			return oi < oj
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
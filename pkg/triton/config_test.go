@@ -0,0 +1,105 @@
+package triton
+
+import (
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/stretchr/testify/require"
+)
+
+const testSchema = `{
+  "openapi": "3.0.2",
+  "info": {"title": "Cog", "version": "0.1.0"},
+  "paths": {},
+  "components": {
+    "schemas": {
+      "Input": {
+        "type": "object",
+        "required": ["prompt"],
+        "properties": {
+          "steps": {"type": "integer", "x-order": 1},
+          "prompt": {"type": "string", "x-order": 0},
+          "scales": {"type": "array", "items": {"type": "number"}, "x-order": 2}
+        }
+      },
+      "Output": {"type": "array", "items": {"type": "string", "format": "uri"}}
+    }
+  }
+}`
+
+func TestNewModelConfig(t *testing.T) {
+	schema, err := openapi3.NewLoader().LoadFromData([]byte(testSchema))
+	require.NoError(t, err)
+
+	modelConfig, err := NewModelConfig("my-model", schema, true)
+	require.NoError(t, err)
+
+	require.Equal(t, `name: "my-model"
+backend: "python"
+max_batch_size: 0
+input [
+  {
+    name: "prompt"
+    data_type: TYPE_STRING
+    dims: [ 1 ]
+  },
+  {
+    name: "steps"
+    data_type: TYPE_INT64
+    dims: [ 1 ]
+    optional: true
+  },
+  {
+    name: "scales"
+    data_type: TYPE_FP32
+    dims: [ -1 ]
+    optional: true
+  }
+]
+output [
+  {
+    name: "output"
+    data_type: TYPE_STRING
+    dims: [ -1 ]
+  }
+]
+instance_group [
+  {
+    count: 1
+    kind: KIND_GPU
+  }
+]
+`, modelConfig.String())
+}
+
+func TestNewModelConfigMissingInput(t *testing.T) {
+	schema, err := openapi3.NewLoader().LoadFromData([]byte(`{"openapi": "3.0.2", "info": {"title": "Cog", "version": "0.1.0"}, "paths": {}, "components": {"schemas": {}}}`))
+	require.NoError(t, err)
+	_, err = NewModelConfig("my-model", schema, false)
+	require.ErrorContains(t, err, "no Input")
+}
+
+func TestModelName(t *testing.T) {
+	require.Equal(t, "my-model", modelName("r8.im/user/my-model:latest"))
+	require.Equal(t, "my-model", modelName("my-model"))
+	require.Equal(t, "my-model", modelName("localhost:5000/my-model@sha256:abc"))
+}
+
+func TestWriteRepository(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, WriteRepository(dir, &ModelConfig{Name: "m"}))
+	require.FileExists(t, dir+"/m/config.pbtxt")
+	require.FileExists(t, dir+"/m/1/model.py")
+}
+
+func TestGenerateDockerfile(t *testing.T) {
+	dockerfile, err := generateDockerfile("my-model", "3.12.4")
+	require.NoError(t, err)
+	require.Contains(t, dockerfile, "FROM my-model AS model\n")
+	require.Contains(t, dockerfile, "FROM nvcr.io/nvidia/tritonserver:25.01-py3\n")
+	require.Contains(t, dockerfile, "COPY --from=model /src /src\n")
+	require.Contains(t, dockerfile, `CMD ["tritonserver", "--model-repository=/models"]`)
+
+	_, err = generateDockerfile("my-model", "3.9")
+	require.ErrorContains(t, err, "one of 3.10, 3.12")
+}
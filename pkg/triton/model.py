@@ -0,0 +1,56 @@
+# Triton Python backend model generated by `cog build --triton`. It loads the
+# Cog predictor from /src and runs predict() for each request.
+import json
+import os
+import sys
+
+import numpy as np
+import triton_python_backend_utils as pb_utils  # pylint: disable=import-error
+
+sys.path.insert(0, "/src")
+os.chdir("/src")
+
+
+class TritonPythonModel:
+    def initialize(self, args):
+        from cog.config import Config
+        from cog.mode import Mode
+        from cog.predictor import load_predictor_from_ref
+
+        self.model_config = json.loads(args["model_config"])
+        ref = Config().get_predictor_ref(Mode.PREDICT)
+        self.predictor = load_predictor_from_ref(ref)
+        self.predictor.setup()
+
+    def execute(self, requests):
+        responses = []
+        for request in requests:
+            kwargs = {}
+            for tensor in self.model_config["input"]:
+                value = pb_utils.get_input_tensor_by_name(request, tensor["name"])
+                if value is None:
+                    continue
+                kwargs[tensor["name"]] = _from_numpy(value.as_numpy(), tensor)
+            output = self.predictor.predict(**kwargs)
+            out = _to_numpy(output, self.model_config["output"][0])
+            responses.append(
+                pb_utils.InferenceResponse(
+                    output_tensors=[pb_utils.Tensor("output", out)]
+                )
+            )
+        return responses
+
+
+def _from_numpy(array, tensor):
+    values = [v.decode("utf-8") if isinstance(v, bytes) else v.item() for v in array.flatten()]
+    if [int(d) for d in tensor["dims"]] == [-1]:
+        return values
+    return values[0]
+
+
+def _to_numpy(value, tensor):
+    if tensor["data_type"] == "TYPE_STRING":
+        if not isinstance(value, str):
+            value = json.dumps(value, default=str)
+        return np.array([value.encode("utf-8")], dtype=np.object_)
+    return np.array(value if isinstance(value, list) else [value])
@@ -0,0 +1,136 @@
+package triton
+
+import (
+	// blank import for embeds
+	_ "embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/replicate/cog/pkg/config"
+	"github.com/replicate/cog/pkg/docker"
+	"github.com/replicate/cog/pkg/dockercontext"
+	"github.com/replicate/cog/pkg/image"
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+//go:embed model.py
+var modelPy []byte
+
+// RepositoryPath is where the Triton model repository is placed inside the image.
+const RepositoryPath = "/models"
+
+// tritonImages are the Triton Inference Server images whose Python backend runs each version of Python. Triton loads
+// the model's Python packages into its own interpreter, so they must have been installed for the same version.
+var tritonImages = map[string]string{
+	"3.10": "nvcr.io/nvidia/tritonserver:24.08-py3",
+	"3.12": "nvcr.io/nvidia/tritonserver:25.01-py3",
+}
+
+// sitePackagesPath is where the model's Python packages are copied to in the Triton image
+const sitePackagesPath = "/opt/cog/site-packages"
+
+// ImageName returns the name of the Triton image built from a Cog image.
+func ImageName(imageName string) string {
+	return imageName + "-triton"
+}
+
+// WriteRepository writes a Triton model repository for a single model to dir:
+//
+//	<dir>/<name>/config.pbtxt
+//	<dir>/<name>/1/model.py
+func WriteRepository(dir string, modelConfig *ModelConfig) error {
+	versionDir := filepath.Join(dir, modelConfig.Name, "1")
+	if err := os.MkdirAll(versionDir, 0o755); err != nil {
+		return fmt.Errorf("Failed to create Triton model repository: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, modelConfig.Name, "config.pbtxt"), []byte(modelConfig.String()), 0o644); err != nil {
+		return fmt.Errorf("Failed to write config.pbtxt: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(versionDir, "model.py"), modelPy, 0o644); err != nil {
+		return fmt.Errorf("Failed to write model.py: %w", err)
+	}
+	return nil
+}
+
+// Build packages a built Cog image as a Triton model repository, producing a new image named ImageName(imageName).
+func Build(cfg *config.Config, dir string, imageName string, progressOutput string) (string, error) {
+	dockerfile, err := generateDockerfile(imageName, cfg.Build.PythonVersion)
+	if err != nil {
+		return "", err
+	}
+	schema, err := image.GetOpenAPISchema(imageName)
+	if err != nil {
+		return "", err
+	}
+	modelConfig, err := NewModelConfig(modelName(imageName), schema, cfg.Build.GPU)
+	if err != nil {
+		return "", fmt.Errorf("Failed to generate Triton model config: %w", err)
+	}
+
+	tmpDir, err := dockercontext.BuildCogTempDir(dir, "triton")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := WriteRepository(tmpDir, modelConfig); err != nil {
+		return "", err
+	}
+	contextDir, err := filepath.Rel(dir, tmpDir)
+	if err != nil {
+		return "", err
+	}
+
+	tritonImageName := ImageName(imageName)
+	console.Infof("Packaging Triton model repository as %s...", tritonImageName)
+	if err := docker.Build(dir, dockerfile, tritonImageName, []string{}, nil, false, progressOutput, config.BuildSourceEpochTimestamp, contextDir, nil); err != nil {
+		return "", fmt.Errorf("Failed to build Triton image: %w", err)
+	}
+	return tritonImageName, nil
+}
+
+// generateDockerfile returns a Dockerfile that serves the model repository in the build context with Triton. The model's
+// source and Python packages are copied from the Cog image, because the Cog image doesn't have Triton in it.
+func generateDockerfile(imageName string, pythonVersion string) (string, error) {
+	version := pythonVersion
+	if parts := strings.SplitN(pythonVersion, ".", 3); len(parts) == 3 {
+		version = parts[0] + "." + parts[1]
+	}
+	tritonImage, ok := tritonImages[version]
+	if !ok {
+		versions := []string{}
+		for v := range tritonImages {
+			versions = append(versions, v)
+		}
+		sort.Strings(versions)
+		return "", fmt.Errorf("Triton's Python backend doesn't support Python %s. Set python_version in cog.yaml to one of %s to build a Triton image", pythonVersion, strings.Join(versions, ", "))
+	}
+	collectPackages := fmt.Sprintf(`import os, shutil, site; [shutil.copytree(p, %q, symlinks=True, dirs_exist_ok=True) for p in site.getsitepackages() if os.path.isdir(p)]`, sitePackagesPath)
+	return strings.Join([]string{
+		"FROM " + imageName + " AS model",
+		"RUN python -c '" + collectPackages + "'",
+		"FROM " + tritonImage,
+		"COPY --from=model " + sitePackagesPath + " " + sitePackagesPath,
+		"COPY --from=model /src /src",
+		"COPY . " + RepositoryPath,
+		"ENV PYTHONPATH=" + sitePackagesPath,
+		`CMD ["tritonserver", "--model-repository=` + RepositoryPath + `"]`,
+	}, "\n"), nil
+}
+
+// modelName derives a Triton model name from an image name, e.g. r8.im/user/my-model:latest -> my-model
+func modelName(imageName string) string {
+	name := imageName
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.Index(name, ":"); i >= 0 {
+		name = name[:i]
+	}
+	if i := strings.Index(name, "@"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
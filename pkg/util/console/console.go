@@ -3,6 +3,7 @@ package console
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"sync"
@@ -19,7 +20,9 @@ type Console struct {
 	Color     bool
 	IsMachine bool
 	Level     Level
-	mu        sync.Mutex
+	// Writer is where messages are written. If it's nil, they're written to stderr.
+	Writer io.Writer
+	mu     sync.Mutex
 }
 
 // Debug prints a verbose debugging message, that is not displayed by default to the user.
@@ -107,6 +110,13 @@ func (c *Console) log(level Level, msg string) {
 			line = aurora.Faint(line).String()
 		}
 		line = prompt + line
-		fmt.Fprintln(os.Stderr, line)
+		fmt.Fprintln(c.writer(), line)
 	}
 }
+
+func (c *Console) writer() io.Writer {
+	if c.Writer == nil {
+		return os.Stderr
+	}
+	return c.Writer
+}
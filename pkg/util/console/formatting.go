@@ -1,6 +1,7 @@
 package console
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/xeonx/timeago"
@@ -9,3 +10,17 @@ import (
 func FormatTime(t time.Time) string {
 	return timeago.English.Format(t)
 }
+
+// FormatBytes formats a byte count using binary units, e.g. 1.5 MiB
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -1,6 +1,7 @@
 package console
 
 import (
+	"io"
 	"os"
 
 	"github.com/mattn/go-isatty"
@@ -23,6 +24,24 @@ func SetColor(color bool) {
 	ConsoleInstance.Color = color
 }
 
+// SetWriter sets where messages are written, and returns where they were written before. If w is nil, they're
+// written to stderr.
+func SetWriter(w io.Writer) io.Writer {
+	ConsoleInstance.mu.Lock()
+	defer ConsoleInstance.mu.Unlock()
+	previous := ConsoleInstance.Writer
+	ConsoleInstance.Writer = w
+	return previous
+}
+
+// Writer returns where messages are written. Subcommands whose output is messaging, like docker build, write
+// there too.
+func Writer() io.Writer {
+	ConsoleInstance.mu.Lock()
+	defer ConsoleInstance.mu.Unlock()
+	return ConsoleInstance.writer()
+}
+
 // Debug level message.
 func Debug(msg string) {
 	ConsoleInstance.Debug(msg)
@@ -71,3 +71,12 @@ func WriteIfDifferent(file, content string) error {
 	}
 	return nil
 }
+
+// FreeSpace returns the bytes available to unprivileged users in the filesystem path is on
+func FreeSpace(path string) (int64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil //nolint:gosec,unconvert
+}
@@ -18,6 +18,9 @@ var typeToExtension = map[string]string{
 	"application/pdf":                                 ".pdf",
 	"application/rtf":                                 ".rtf",
 	"application/vnd.amazon.ebook":                    ".azw",
+	"application/vnd.apache.arrow.file":               ".arrow",
+	"application/vnd.apache.arrow.stream":             ".arrows",
+	"application/vnd.apache.parquet":                  ".parquet",
 	"application/vnd.apple.installer+xml":             ".mpkg",
 	"application/vnd.ms-excel":                        ".xls",
 	"application/vnd.ms-fontobject":                   ".eot",
@@ -38,6 +41,7 @@ var typeToExtension = map[string]string{
 	"application/x-freearc":         ".arc",
 	"application/x-httpd-php":       ".php",
 	"application/x-ndjson":          ".ndjson",
+	"application/x-npy":             ".npy",
 	"application/x-sh":              ".sh",
 	"application/x-shockwave-flash": ".swf",
 	"application/x-tar":             ".tar",
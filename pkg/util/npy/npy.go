@@ -0,0 +1,149 @@
+// Package npy reads and writes NumPy's .npy format, which models use for array outputs, like embeddings.
+package npy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var magic = []byte("\x93NUMPY")
+
+// Header is the description of the array in a .npy file
+type Header struct {
+	// DType is the type of the array's elements, like <f4 for little-endian float32
+	DType string
+	Shape []int
+}
+
+func (h Header) String() string {
+	shape := make([]string, len(h.Shape))
+	for i, n := range h.Shape {
+		shape[i] = strconv.Itoa(n)
+	}
+	return fmt.Sprintf("%s array of shape (%s)", dtypeName(h.DType), strings.Join(shape, ", "))
+}
+
+var dtypeNames = map[string]string{
+	"f2": "float16", "f4": "float32", "f8": "float64",
+	"i1": "int8", "i2": "int16", "i4": "int32", "i8": "int64",
+	"u1": "uint8", "u2": "uint16", "u4": "uint32", "u8": "uint64",
+	"b1": "bool",
+}
+
+func dtypeName(dtype string) string {
+	if name, ok := dtypeNames[strings.TrimLeft(dtype, "<>|=")]; ok {
+		return name
+	}
+	return dtype
+}
+
+var (
+	descrRegex = regexp.MustCompile(`'descr':\s*'([^']*)'`)
+	shapeRegex = regexp.MustCompile(`'shape':\s*\(([^)]*)\)`)
+)
+
+// ReadHeader returns the header of the .npy file in data
+func ReadHeader(data []byte) (Header, error) {
+	if !bytes.HasPrefix(data, magic) || len(data) < 10 {
+		return Header{}, fmt.Errorf("not a .npy file")
+	}
+	var length, start int
+	switch data[6] {
+	case 1:
+		length, start = int(binary.LittleEndian.Uint16(data[8:10])), 10
+	default:
+		if len(data) < 12 {
+			return Header{}, fmt.Errorf("not a .npy file")
+		}
+		length, start = int(binary.LittleEndian.Uint32(data[8:12])), 12
+	}
+	if len(data) < start+length {
+		return Header{}, fmt.Errorf("the .npy header is truncated")
+	}
+	dict := string(data[start : start+length])
+
+	descr := descrRegex.FindStringSubmatch(dict)
+	shape := shapeRegex.FindStringSubmatch(dict)
+	if descr == nil || shape == nil {
+		return Header{}, fmt.Errorf("invalid .npy header: %s", strings.TrimSpace(dict))
+	}
+	header := Header{DType: descr[1], Shape: []int{}}
+	for _, dim := range strings.Split(shape[1], ",") {
+		dim = strings.TrimSpace(dim)
+		if dim == "" {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(dim, "L"))
+		if err != nil {
+			return Header{}, fmt.Errorf("invalid shape in .npy header: %s", shape[1])
+		}
+		header.Shape = append(header.Shape, n)
+	}
+	return header, nil
+}
+
+// Encode returns a .npy file of float64 values with shape, in row-major order
+func Encode(values []float64, shape []int) []byte {
+	dims := make([]string, len(shape))
+	for i, n := range shape {
+		dims[i] = strconv.Itoa(n)
+	}
+	shapeStr := strings.Join(dims, ", ")
+	if len(shape) == 1 {
+		shapeStr += ","
+	}
+	dict := fmt.Sprintf("{'descr': '<f8', 'fortran_order': False, 'shape': (%s), }", shapeStr)
+	// The header is padded with spaces and a newline so the data is aligned to 64 bytes
+	headerLength := len(magic) + 2 + 2 + len(dict) + 1
+	padding := (64 - headerLength%64) % 64
+	dict += strings.Repeat(" ", padding) + "\n"
+
+	buf := bytes.NewBuffer(make([]byte, 0, headerLength+padding+8*len(values)))
+	buf.Write(magic)
+	buf.Write([]byte{1, 0})
+	_ = binary.Write(buf, binary.LittleEndian, uint16(len(dict)))
+	buf.WriteString(dict)
+	b := make([]byte, 8)
+	for _, v := range values {
+		binary.LittleEndian.PutUint64(b, math.Float64bits(v))
+		buf.Write(b)
+	}
+	return buf.Bytes()
+}
+
+// FromJSON returns the values and shape of v, a JSON array of numbers, or of arrays of numbers with the same shape.
+// It returns false if v isn't one, or is empty.
+func FromJSON(v any) ([]float64, []int, bool) {
+	list, ok := v.([]any)
+	if !ok || len(list) == 0 {
+		return nil, nil, false
+	}
+	if _, isList := list[0].([]any); !isList {
+		values := make([]float64, len(list))
+		for i, elem := range list {
+			n, ok := elem.(float64)
+			if !ok {
+				return nil, nil, false
+			}
+			values[i] = n
+		}
+		return values, []int{len(list)}, true
+	}
+	var values []float64
+	var shape []int
+	for _, elem := range list {
+		inner, innerShape, ok := FromJSON(elem)
+		if !ok || (shape != nil && !slices.Equal(shape, innerShape)) {
+			return nil, nil, false
+		}
+		shape = innerShape
+		values = append(values, inner...)
+	}
+	return values, append([]int{len(list)}, shape...), true
+}
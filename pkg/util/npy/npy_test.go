@@ -0,0 +1,64 @@
+package npy
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncode(t *testing.T) {
+	data := Encode([]float64{1, 2.5, -3, 4, 5, 6}, []int{2, 3})
+
+	headerLength := int(binary.LittleEndian.Uint16(data[8:10]))
+	require.Zero(t, (10+headerLength)%64)
+	require.Len(t, data, 10+headerLength+6*8)
+	require.Equal(t, 2.5, math.Float64frombits(binary.LittleEndian.Uint64(data[10+headerLength+8:])))
+
+	header, err := ReadHeader(data)
+	require.NoError(t, err)
+	require.Equal(t, Header{DType: "<f8", Shape: []int{2, 3}}, header)
+	require.Equal(t, "float64 array of shape (2, 3)", header.String())
+
+	header, err = ReadHeader(Encode([]float64{1}, []int{1}))
+	require.NoError(t, err)
+	require.Equal(t, []int{1}, header.Shape)
+}
+
+func TestReadHeader(t *testing.T) {
+	// The header numpy writes for np.zeros(768, dtype=np.float32)
+	dict := "{'descr': '<f4', 'fortran_order': False, 'shape': (768,), }"
+	data := append([]byte("\x93NUMPY\x01\x00"), byte(len(dict)), 0)
+	data = append(data, dict...)
+	header, err := ReadHeader(data)
+	require.NoError(t, err)
+	require.Equal(t, "float32 array of shape (768)", header.String())
+
+	_, err = ReadHeader([]byte("not npy"))
+	require.Error(t, err)
+}
+
+func TestFromJSON(t *testing.T) {
+	for _, tt := range []struct {
+		json   string
+		values []float64
+		shape  []int
+		ok     bool
+	}{
+		{`[1, 2, 3]`, []float64{1, 2, 3}, []int{3}, true},
+		{`[[1, 2], [3, 4], [5, 6]]`, []float64{1, 2, 3, 4, 5, 6}, []int{3, 2}, true},
+		{`[[1, 2], [3]]`, nil, nil, false},
+		{`[1, "a"]`, nil, nil, false},
+		{`[]`, nil, nil, false},
+		{`{"a": 1}`, nil, nil, false},
+	} {
+		var v any
+		require.NoError(t, json.Unmarshal([]byte(tt.json), &v))
+		values, shape, ok := FromJSON(v)
+		require.Equal(t, tt.ok, ok, tt.json)
+		require.Equal(t, tt.values, values, tt.json)
+		require.Equal(t, tt.shape, shape, tt.json)
+	}
+}
@@ -0,0 +1,102 @@
+package vcs
+
+import (
+	"os"
+	"strings"
+)
+
+// ciProvider describes the environment variables a CI system sets for the commit being built
+type ciProvider struct {
+	// detect is set whenever the build is running on this CI system
+	detect   string
+	revision string
+	version  string
+	// refType is a variable that has to be "tag" for version to be used, for CI systems that set version to the
+	// name of the branch when the build isn't of a tag
+	refType string
+	branch  []string
+	source  func() string
+}
+
+var ciProviders = []ciProvider{
+	{
+		detect:   "GITHUB_ACTIONS",
+		revision: "GITHUB_SHA",
+		version:  "GITHUB_REF_NAME",
+		refType:  "GITHUB_REF_TYPE",
+		branch:   []string{"GITHUB_HEAD_REF"},
+		source: func() string {
+			if os.Getenv("GITHUB_SERVER_URL") == "" || os.Getenv("GITHUB_REPOSITORY") == "" {
+				return ""
+			}
+			return os.Getenv("GITHUB_SERVER_URL") + "/" + os.Getenv("GITHUB_REPOSITORY")
+		},
+	},
+	{
+		detect:   "GITLAB_CI",
+		revision: "CI_COMMIT_SHA",
+		version:  "CI_COMMIT_TAG",
+		branch:   []string{"CI_COMMIT_BRANCH", "CI_MERGE_REQUEST_SOURCE_BRANCH_NAME"},
+		source:   envSource("CI_PROJECT_URL"),
+	},
+	{
+		detect:   "BUILDKITE",
+		revision: "BUILDKITE_COMMIT",
+		version:  "BUILDKITE_TAG",
+		branch:   []string{"BUILDKITE_BRANCH"},
+		source:   envSource("BUILDKITE_REPO"),
+	},
+	{
+		detect:   "JENKINS_URL",
+		revision: "GIT_COMMIT",
+		version:  "TAG_NAME",
+		branch:   []string{"GIT_LOCAL_BRANCH", "GIT_BRANCH"},
+		source:   envSource("GIT_URL"),
+	},
+}
+
+type ciMetadata struct {
+	Revision string
+	Version  string
+	Branch   string
+	Source   string
+}
+
+// detectCI returns the metadata of the commit being built by the CI system the build is running in, or nil.
+// The revision and version variables are also read outside of CI, so they can be set by hand.
+func detectCI() *ciMetadata {
+	for _, p := range ciProviders {
+		m := &ciMetadata{
+			Revision: os.Getenv(p.revision),
+			Version:  os.Getenv(p.version),
+		}
+		if p.refType != "" && os.Getenv(p.refType) != "tag" {
+			m.Version = ""
+		}
+		if os.Getenv(p.detect) == "" {
+			if m.Revision == "" && m.Version == "" {
+				continue
+			}
+			return m
+		}
+		// Buildkite sets BUILDKITE_COMMIT to HEAD for builds that aren't of a specific commit
+		if m.Revision == "HEAD" {
+			m.Revision = ""
+		}
+		for _, name := range p.branch {
+			if branch := os.Getenv(name); branch != "" {
+				m.Branch = strings.TrimPrefix(branch, "origin/")
+				break
+			}
+		}
+		m.Source = sanitizeURL(p.source())
+		return m
+	}
+	return nil
+}
+
+func envSource(name string) func() string {
+	return func() string {
+		return os.Getenv(name)
+	}
+}
@@ -0,0 +1,107 @@
+package vcs
+
+import (
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+type gitBackend struct{}
+
+func (gitBackend) detect(dir string) bool {
+	return isGitWorkTree(dir)
+}
+
+func (gitBackend) metadata(dir string) *Metadata {
+	return gitMetadata(dir)
+}
+
+func isGitWorkTree(dir string) bool {
+	out, err := git(dir, "rev-parse", "--is-inside-work-tree")
+	if err != nil {
+		return false
+	}
+	return out == "true"
+}
+
+func gitMetadata(dir string) *Metadata {
+	m := &Metadata{VCS: "git"}
+	m.Revision, _ = git(dir, "rev-parse", "HEAD")
+	// describe fails when there are no tags, which is common in shallow clones
+	m.Version, _ = git(dir, "describe", "--tags", "--dirty")
+
+	if branch, err := git(dir, "symbolic-ref", "--short", "-q", "HEAD"); err == nil {
+		m.Branch = branch
+	} else {
+		m.Detached = true
+	}
+	if shallow, err := git(dir, "rev-parse", "--is-shallow-repository"); err == nil {
+		m.Shallow = shallow == "true"
+	}
+	m.Worktree = isLinkedWorktree(dir)
+	if status, err := git(dir, "status", "--porcelain", "--untracked-files=no"); err == nil {
+		m.Dirty = status != ""
+	}
+	if remote, err := git(dir, "remote", "get-url", "origin"); err == nil {
+		m.Source = sanitizeURL(remote)
+	}
+	if status, err := git(dir, "submodule", "status"); err == nil {
+		m.Submodules = parseSubmoduleStatus(status)
+	}
+	return m
+}
+
+// isLinkedWorktree returns true if dir is in a worktree created with `git worktree add`, rather than the main checkout
+func isLinkedWorktree(dir string) bool {
+	gitDir, err := git(dir, "rev-parse", "--path-format=absolute", "--git-dir")
+	if err != nil {
+		return false
+	}
+	commonDir, err := git(dir, "rev-parse", "--path-format=absolute", "--git-common-dir")
+	if err != nil {
+		return false
+	}
+	return filepath.Clean(gitDir) != filepath.Clean(commonDir)
+}
+
+// parseSubmoduleStatus parses the output of `git submodule status`, which has lines like
+//
+//	[ -+U]<sha1> <path> (<describe>)
+func parseSubmoduleStatus(out string) []Submodule {
+	submodules := []Submodule{}
+	for _, line := range strings.Split(out, "\n") {
+		if len(line) < 2 {
+			continue
+		}
+		status := SubmoduleCurrent
+		switch line[0] {
+		case '-':
+			status = SubmoduleUninitialized
+		case '+':
+			status = SubmoduleModified
+		case 'U':
+			status = SubmoduleConflict
+		}
+		// The leading space of current submodules may have been trimmed
+		fields := strings.Fields(strings.TrimLeft(line, " -+U"))
+		if len(fields) < 2 {
+			continue
+		}
+		submodules = append(submodules, Submodule{Path: fields[1], Revision: fields[0], Status: status})
+	}
+	return submodules
+}
+
+// sanitizeURL removes credentials from a repository URL, which CI systems often include
+func sanitizeURL(remote string) string {
+	u, err := url.Parse(remote)
+	if err != nil || u.User == nil {
+		return remote
+	}
+	u.User = nil
+	return u.String()
+}
+
+func git(dir string, args ...string) (string, error) {
+	return run(dir, "git", args...)
+}
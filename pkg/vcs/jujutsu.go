@@ -0,0 +1,93 @@
+package vcs
+
+import (
+	"fmt"
+	"strings"
+)
+
+type jujutsuBackend struct{}
+
+func (jujutsuBackend) detect(dir string) bool {
+	_, err := jj(dir, "root")
+	return err == nil
+}
+
+// jujutsuLogTemplate prints the commit ID, whether the commit is empty, and its bookmarks, on separate lines
+const jujutsuLogTemplate = `commit_id ++ "\n" ++ empty ++ "\n" ++ bookmarks ++ "\n"`
+
+func (jujutsuBackend) metadata(dir string) *Metadata {
+	m := &Metadata{VCS: "jj"}
+	// The working copy is itself a commit in Jujutsu. It is dirty if it has changes. If it hasn't, it's usually a
+	// new change on top of the commit that was checked out, so that's the revision that's built.
+	rev := "@"
+	if out, err := jj(dir, "log", "--no-graph", "-r", rev, "-T", jujutsuLogTemplate); err == nil {
+		var empty bool
+		m.Revision, empty, m.Branch = parseJujutsuLog(out)
+		m.Dirty = !empty
+		if empty {
+			rev = "latest(@-)"
+			if out, err := jj(dir, "log", "--no-graph", "-r", rev, "-T", jujutsuLogTemplate); err == nil {
+				m.Revision, _, m.Branch = parseJujutsuLog(out)
+			}
+		}
+	}
+	tagged := "latest(::" + rev + " & tags())"
+	if tags, err := jj(dir, "log", "--no-graph", "-r", tagged, "-T", "tags"); err == nil && firstField(tags) != "" {
+		// Each commit since the tag is printed as one character, to count them
+		if since, err := jj(dir, "log", "--no-graph", "-r", tagged+".."+rev, "-T", `"x"`); err == nil {
+			m.Version = describeVersion(firstField(tags), len(since), m.Revision)
+		}
+	}
+	if remotes, err := jj(dir, "git", "remote", "list"); err == nil {
+		m.Source = sanitizeURL(parseJujutsuRemote(remotes, "origin"))
+	}
+	return m
+}
+
+// parseJujutsuLog parses the output of jujutsuLogTemplate, and returns the revision, whether it's empty, and its
+// first bookmark
+func parseJujutsuLog(out string) (string, bool, string) {
+	lines := strings.Split(out, "\n")
+	if len(lines) < 3 {
+		return "", false, ""
+	}
+	return lines[0], lines[1] == "true", firstField(lines[2])
+}
+
+// describeVersion describes revision like `git describe`: the tag if it's at the tag, and otherwise the tag
+// followed by the number of commits since it and the short revision
+func describeVersion(tag string, distance int, revision string) string {
+	if distance == 0 {
+		return tag
+	}
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	return fmt.Sprintf("%s-%d-%s", tag, distance, revision)
+}
+
+// parseJujutsuRemote finds a remote's URL in the output of `jj git remote list`, which has lines like
+//
+//	<name> <url>
+func parseJujutsuRemote(out string, name string) string {
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == name {
+			return fields[1]
+		}
+	}
+	return ""
+}
+
+// firstField returns the first of a space separated list of names, with any trailing marker such as `*` removed
+func firstField(s string) string {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.TrimRight(fields[0], "*?")
+}
+
+func jj(dir string, args ...string) (string, error) {
+	return run(dir, "jj", append([]string{"--color=never"}, args...)...)
+}
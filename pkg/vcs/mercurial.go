@@ -0,0 +1,54 @@
+package vcs
+
+import (
+	"strings"
+)
+
+type mercurialBackend struct{}
+
+func (mercurialBackend) detect(dir string) bool {
+	_, err := hg(dir, "root")
+	return err == nil
+}
+
+func (mercurialBackend) metadata(dir string) *Metadata {
+	m := &Metadata{VCS: "hg"}
+	out, err := hg(dir, "log", "-r", ".", "-T", "{node}\\n{branch}\\n{activebookmark}\\n{latesttag}\\n{latesttagdistance}\\n{node|short}")
+	if err == nil {
+		m.Revision, m.Branch, m.Version = parseMercurialLog(out)
+	}
+	// -mard lists modified, added, removed and deleted files, but not untracked ones
+	if status, err := hg(dir, "status", "-mard"); err == nil {
+		m.Dirty = status != ""
+	}
+	if source, err := hg(dir, "paths", "default"); err == nil {
+		m.Source = sanitizeURL(source)
+	}
+	return m
+}
+
+// parseMercurialLog parses the output of the template in metadata, and returns the revision, branch and version.
+// The version is described like `git describe`: the latest tag, followed by the distance and revision if it isn't at the tag.
+func parseMercurialLog(out string) (string, string, string) {
+	lines := strings.Split(out, "\n")
+	if len(lines) < 6 {
+		return "", "", ""
+	}
+	node, branch, bookmark, tag, distance, short := lines[0], lines[1], lines[2], lines[3], lines[4], lines[5]
+	if bookmark != "" {
+		branch = bookmark
+	}
+	version := ""
+	switch {
+	case tag == "" || tag == "null":
+	case distance == "0":
+		version = tag
+	default:
+		version = tag + "-" + distance + "-" + short
+	}
+	return node, branch, version
+}
+
+func hg(dir string, args ...string) (string, error) {
+	return run(dir, "hg", args...)
+}
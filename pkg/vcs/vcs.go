@@ -0,0 +1,137 @@
+// Package vcs detects the version control state of a model's source code, so it can be recorded in image labels.
+package vcs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+const (
+	LabelRevision   = "org.opencontainers.image.revision"
+	LabelVersion    = "org.opencontainers.image.version"
+	LabelSource     = "org.opencontainers.image.source"
+	LabelVCS        = "org.opencontainers.image.vcs.type"
+	LabelBranch     = "org.opencontainers.image.vcs.branch"
+	LabelDetached   = "org.opencontainers.image.vcs.detached"
+	LabelDirty      = "org.opencontainers.image.vcs.dirty"
+	LabelShallow    = "org.opencontainers.image.vcs.shallow"
+	LabelWorktree   = "org.opencontainers.image.vcs.worktree"
+	LabelSubmodules = "org.opencontainers.image.vcs.submodules"
+)
+
+// Submodule statuses, from the prefix of each line of `git submodule status`
+const (
+	SubmoduleCurrent       = "current"
+	SubmoduleUninitialized = "uninitialized"
+	SubmoduleModified      = "modified"
+	SubmoduleConflict      = "conflict"
+)
+
+type Submodule struct {
+	Path     string `json:"path"`
+	Revision string `json:"revision"`
+	Status   string `json:"status"`
+}
+
+// Metadata is the version control state of a directory
+type Metadata struct {
+	// VCS is the version control system, e.g. "git", "hg" or "jj", or empty if the directory isn't in a repository
+	VCS      string
+	Revision string
+	// Version is the tag the revision is at, or a description relative to the most recent tag
+	Version string
+	Branch  string
+	// Source is the URL of the repository
+	Source     string
+	Detached   bool
+	Dirty      bool
+	Shallow    bool
+	Worktree   bool
+	Submodules []Submodule
+}
+
+// backend reads metadata from a version control system
+type backend interface {
+	// detect returns true if dir is in a repository of this version control system
+	detect(dir string) bool
+	metadata(dir string) *Metadata
+}
+
+// backends are tried in order. Jujutsu comes before Git, because Jujutsu repositories are often colocated with a Git repository.
+var backends = []backend{jujutsuBackend{}, gitBackend{}, mercurialBackend{}}
+
+// Detect returns the version control metadata of dir. Values set by the CI system the build is running in
+// take precedence over what is found in the repository, because CI checkouts are often detached or shallow.
+func Detect(dir string) *Metadata {
+	m := &Metadata{}
+	for _, b := range backends {
+		if b.detect(dir) {
+			m = b.metadata(dir)
+			break
+		}
+	}
+	if ci := detectCI(); ci != nil {
+		m.Revision = firstNonEmpty(ci.Revision, m.Revision)
+		m.Version = firstNonEmpty(ci.Version, m.Version)
+		m.Branch = firstNonEmpty(ci.Branch, m.Branch)
+		m.Source = firstNonEmpty(ci.Source, m.Source)
+	}
+	return m
+}
+
+// Labels returns the image labels recording the metadata
+func (m *Metadata) Labels() map[string]string {
+	labels := map[string]string{}
+	set := func(key string, value string) {
+		if value != "" {
+			labels[key] = value
+		}
+	}
+	set(LabelRevision, m.Revision)
+	set(LabelVersion, m.Version)
+	set(LabelSource, m.Source)
+	set(LabelBranch, m.Branch)
+	if m.VCS == "" {
+		return labels
+	}
+	labels[LabelVCS] = m.VCS
+	labels[LabelDetached] = strconv.FormatBool(m.Detached)
+	labels[LabelDirty] = strconv.FormatBool(m.Dirty)
+	labels[LabelShallow] = strconv.FormatBool(m.Shallow)
+	labels[LabelWorktree] = strconv.FormatBool(m.Worktree)
+	if len(m.Submodules) > 0 {
+		if data, err := json.Marshal(m.Submodules); err == nil {
+			labels[LabelSubmodules] = string(data)
+		}
+	}
+	return labels
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// run runs a version control command in dir, returning its trimmed output with LF line endings
+func run(dir string, name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.TODO(), 3*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	// Git for Windows and Mercurial can print CRLF line endings
+	out = bytes.ReplaceAll(out, []byte("\r\n"), []byte("\n"))
+	return string(bytes.TrimSpace(out)), nil
+}
@@ -0,0 +1,67 @@
+package vcs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMercurialLog(t *testing.T) {
+	revision, branch, version := parseMercurialLog("4f1e0a7c2b9d\ndefault\n\nv1.2.0\n0\n4f1e0a7c")
+	require.Equal(t, "4f1e0a7c2b9d", revision)
+	require.Equal(t, "default", branch)
+	require.Equal(t, "v1.2.0", version)
+
+	_, branch, version = parseMercurialLog("4f1e0a7c2b9d\ndefault\nfeature-x\nv1.2.0\n3\n4f1e0a7c")
+	require.Equal(t, "feature-x", branch)
+	require.Equal(t, "v1.2.0-3-4f1e0a7c", version)
+
+	_, _, version = parseMercurialLog("4f1e0a7c2b9d\ndefault\n\nnull\n5\n4f1e0a7c")
+	require.Equal(t, "", version)
+}
+
+func TestParseJujutsuLog(t *testing.T) {
+	revision, empty, bookmark := parseJujutsuLog("4f1e0a7c2b9d4f1e0a7c2b9d\ntrue\nmain* feature\n")
+	require.Equal(t, "4f1e0a7c2b9d4f1e0a7c2b9d", revision)
+	require.True(t, empty)
+	require.Equal(t, "main", bookmark)
+
+	_, empty, bookmark = parseJujutsuLog("4f1e0a7c2b9d4f1e0a7c2b9d\nfalse\n\n")
+	require.False(t, empty)
+	require.Equal(t, "", bookmark)
+}
+
+func TestDescribeVersion(t *testing.T) {
+	require.Equal(t, "v1.2.0", describeVersion("v1.2.0", 0, "4f1e0a7c2b9d4f1e0a7c2b9d"))
+	require.Equal(t, "v1.2.0-3-4f1e0a7c2b9d", describeVersion("v1.2.0", 3, "4f1e0a7c2b9d4f1e0a7c2b9d"))
+}
+
+func TestParseJujutsuRemote(t *testing.T) {
+	out := "origin https://github.com/replicate/cog.git\nupstream git@github.com:replicate/cog.git"
+	require.Equal(t, "https://github.com/replicate/cog.git", parseJujutsuRemote(out, "origin"))
+	require.Equal(t, "", parseJujutsuRemote(out, "fork"))
+}
+
+func TestLabelsOutsideRepository(t *testing.T) {
+	require.Empty(t, (&Metadata{}).Labels())
+}
+
+func TestLabels(t *testing.T) {
+	m := &Metadata{
+		VCS:        "hg",
+		Revision:   "4f1e0a7c2b9d",
+		Version:    "v1.2.0",
+		Dirty:      true,
+		Submodules: []Submodule{{Path: "vendor/a", Revision: "1111", Status: SubmoduleCurrent}},
+	}
+	require.Equal(t, map[string]string{
+		LabelVCS:        "hg",
+		LabelRevision:   "4f1e0a7c2b9d",
+		LabelVersion:    "v1.2.0",
+		LabelDetached:   "false",
+		LabelDirty:      "true",
+		LabelShallow:    "false",
+		LabelWorktree:   "false",
+		LabelSubmodules: `[{"path":"vendor/a","revision":"1111","status":"current"}]`,
+	}, m.Labels())
+}
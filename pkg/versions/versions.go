@@ -0,0 +1,358 @@
+// Package versions keeps a log of every version of a model that was built or pushed from a project, in
+// .cog/versions.json, so any two versions can be compared.
+package versions
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/google/go-containerregistry/pkg/name"
+
+	"github.com/replicate/cog/pkg/docker/command"
+	"github.com/replicate/cog/pkg/dockercontext"
+	"github.com/replicate/cog/pkg/image"
+	"github.com/replicate/cog/pkg/vcs"
+)
+
+var logPath = filepath.Join(dockercontext.CogBuildArtifactsFolder, "versions.json")
+
+// Version is a model that was built from the project. Versions are identified by their image ID, so building the
+// same thing twice is one version.
+type Version struct {
+	ID     string `json:"id"`
+	Image  string `json:"image"`
+	Digest string `json:"digest,omitempty"`
+	// Parent is the ID of the version that was built before this one
+	Parent       string            `json:"parent,omitempty"`
+	BuiltAt      time.Time         `json:"built_at"`
+	PushedAt     *time.Time        `json:"pushed_at,omitempty"`
+	GitCommit    string            `json:"git_commit,omitempty"`
+	GitDirty     bool              `json:"git_dirty,omitempty"`
+	CogVersion   string            `json:"cog_version,omitempty"`
+	ConfigSHA256 string            `json:"config_sha256,omitempty"`
+	SchemaSHA256 string            `json:"schema_sha256,omitempty"`
+	Config       json.RawMessage   `json:"config,omitempty"`
+	Inputs       map[string]string `json:"inputs,omitempty"`
+	Output       string            `json:"output,omitempty"`
+}
+
+// Log is every version built from the project, oldest first
+type Log struct {
+	Versions []Version `json:"versions"`
+}
+
+// ShortID returns the ID without its algorithm, shortened like docker images does
+func (v *Version) ShortID() string {
+	id := strings.TrimPrefix(v.ID, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// Load reads the log in dir, returning an empty log if nothing has been built
+func Load(dir string) (*Log, error) {
+	data, err := os.ReadFile(filepath.Join(dir, logPath))
+	if os.IsNotExist(err) {
+		return &Log{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	log := &Log{}
+	if err := json.Unmarshal(data, log); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %w", logPath, err)
+	}
+	return log, nil
+}
+
+// Save writes the log to dir
+func (l *Log) Save(dir string) error {
+	path := filepath.Join(dir, logPath)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// Record adds imageName to the log in dir, or updates it if it's already there. Set pushed if it was just pushed.
+func Record(dir string, imageName string, pushed bool) (*Version, error) {
+	state, err := image.GetState(imageName, false)
+	if err != nil {
+		return nil, err
+	}
+	log, err := Load(dir)
+	if err != nil {
+		return nil, err
+	}
+	version := log.add(newVersion(state, vcs.Detect(dir), time.Now().UTC()))
+	if pushed {
+		now := time.Now().UTC()
+		version.PushedAt = &now
+	}
+	if err := log.Save(dir); err != nil {
+		return nil, fmt.Errorf("Failed to save %s: %w", logPath, err)
+	}
+	return version, nil
+}
+
+// Find returns the version whose ID starts with ref, with or without its sha256: prefix
+func (l *Log) Find(ref string) (*Version, error) {
+	ref = strings.TrimPrefix(ref, "sha256:")
+	var found *Version
+	for i := range l.Versions {
+		if strings.HasPrefix(strings.TrimPrefix(l.Versions[i].ID, "sha256:"), ref) {
+			if found != nil {
+				return nil, fmt.Errorf("%s matches more than one version. Use more of the ID.", ref)
+			}
+			found = &l.Versions[i]
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("No version matches %s. Run 'cog versions list' to see them.", ref)
+	}
+	return found, nil
+}
+
+// Latest returns the most recently built version, or nil if there aren't any
+func (l *Log) Latest() *Version {
+	if len(l.Versions) == 0 {
+		return nil
+	}
+	return &l.Versions[len(l.Versions)-1]
+}
+
+// Rollback returns the version pushed to repository n versions before the one with currentDigest, which is
+// what the tag being rolled back points to
+func (l *Log) Rollback(repository string, currentDigest string, n int) (*Version, error) {
+	repo, err := name.NewRepository(repository)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse repository %s: %w", repository, err)
+	}
+	_, currentHash, _ := strings.Cut(currentDigest, "@")
+	pushed := []*Version{}
+	current := -1
+	for i := range l.Versions {
+		version := &l.Versions[i]
+		digestRepo, digest, ok := strings.Cut(version.Digest, "@")
+		if !ok {
+			continue
+		}
+		if r, err := name.NewRepository(digestRepo); err != nil || r.Name() != repo.Name() {
+			continue
+		}
+		if digest == currentHash {
+			current = len(pushed)
+		}
+		pushed = append(pushed, version)
+	}
+	if current == -1 {
+		return nil, fmt.Errorf("%s isn't a version in %s, so there's no history to roll back through. Use 'cog retag' to tag a digest.", currentDigest, logPath)
+	}
+	if current-n < 0 {
+		return nil, fmt.Errorf("Only %d versions were pushed to %s before %s", current, repository, currentDigest)
+	}
+	return pushed[current-n], nil
+}
+
+// Repositories returns the repositories that versions were pushed to, in the order they were first pushed
+func (l *Log) Repositories() []string {
+	repositories := []string{}
+	seen := map[string]bool{}
+	for _, version := range l.Versions {
+		repository, _, ok := strings.Cut(version.Digest, "@")
+		if !ok || seen[repository] {
+			continue
+		}
+		seen[repository] = true
+		repositories = append(repositories, repository)
+	}
+	return repositories
+}
+
+// add appends version to the log, with the latest version as its parent. If a version with the same ID is
+// already in the log, it's updated instead, so pushing what was just built doesn't add another version.
+func (l *Log) add(version Version) *Version {
+	for i := range l.Versions {
+		existing := &l.Versions[i]
+		if existing.ID == version.ID {
+			existing.Image = version.Image
+			if version.Digest != "" {
+				existing.Digest = version.Digest
+			}
+			return existing
+		}
+	}
+	if latest := l.Latest(); latest != nil {
+		version.Parent = latest.ID
+	}
+	l.Versions = append(l.Versions, version)
+	return l.Latest()
+}
+
+func newVersion(state *image.State, vcsMetadata *vcs.Metadata, builtAt time.Time) Version {
+	version := Version{
+		ID:         state.ImageID,
+		Image:      state.Image,
+		Digest:     state.ImageDigest,
+		BuiltAt:    builtAt,
+		GitCommit:  vcsMetadata.Revision,
+		GitDirty:   vcsMetadata.Dirty,
+		CogVersion: state.CogVersion,
+	}
+	if cfg := state.Labels[command.CogConfigLabelKey]; cfg != "" && json.Valid([]byte(cfg)) {
+		version.Config = json.RawMessage(cfg)
+		version.ConfigSHA256 = sha256Hex(cfg)
+	}
+	version.SchemaSHA256 = state.SchemaSHA256
+	if schema := state.Labels[command.CogOpenAPISchemaLabelKey]; schema != "" {
+		version.Inputs, version.Output = summarizeSchema(schema)
+	}
+	return version
+}
+
+// summarizeSchema returns the type of each input and the output in an OpenAPI schema, to show how a model's API
+// changed between versions
+func summarizeSchema(schema string) (map[string]string, string) {
+	doc, err := openapi3.NewLoader().LoadFromData([]byte(schema))
+	if err != nil || doc.Components == nil {
+		return nil, ""
+	}
+	inputs := map[string]string{}
+	if input := doc.Components.Schemas["Input"]; input != nil && input.Value != nil {
+		for key, property := range input.Value.Properties {
+			inputs[key] = describeType(property)
+		}
+	}
+	output := ""
+	if ref := doc.Components.Schemas["Output"]; ref != nil {
+		output = describeType(ref)
+	}
+	return inputs, output
+}
+
+func describeType(ref *openapi3.SchemaRef) string {
+	if ref == nil || ref.Value == nil {
+		return ""
+	}
+	schema := ref.Value
+	if schema.Type == nil || len(*schema.Type) == 0 {
+		// Enums are a reference to their own schema
+		for _, one := range schema.AllOf {
+			if one.Ref != "" {
+				return one.Ref[strings.LastIndex(one.Ref, "/")+1:]
+			}
+		}
+		return "any"
+	}
+	t := strings.Join(schema.Type.Slice(), "|")
+	switch {
+	case t == "array":
+		return "array of " + describeType(schema.Items)
+	case schema.Format != "":
+		return fmt.Sprintf("%s (%s)", t, schema.Format)
+	}
+	return t
+}
+
+// Diff describes what changed from a to b, one change per line
+func Diff(a *Version, b *Version) []string {
+	changes := []string{}
+	if a.GitCommit != b.GitCommit {
+		changes = append(changes, fmt.Sprintf("git commit changed from %s to %s", orNone(a.GitCommit), orNone(b.GitCommit)))
+	}
+	if a.CogVersion != b.CogVersion {
+		changes = append(changes, fmt.Sprintf("cog version changed from %s to %s", orNone(a.CogVersion), orNone(b.CogVersion)))
+	}
+	if a.ConfigSHA256 != b.ConfigSHA256 {
+		changes = append(changes, diffMaps("config ", flattenJSON(a.Config), flattenJSON(b.Config))...)
+	}
+	if a.SchemaSHA256 != b.SchemaSHA256 {
+		schemaChanges := diffMaps("input ", a.Inputs, b.Inputs)
+		if a.Output != b.Output {
+			schemaChanges = append(schemaChanges, fmt.Sprintf("output changed from %s to %s", orNone(a.Output), orNone(b.Output)))
+		}
+		if len(schemaChanges) == 0 {
+			schemaChanges = []string{"schema changed"}
+		}
+		changes = append(changes, schemaChanges...)
+	}
+	return changes
+}
+
+func diffMaps(prefix string, a map[string]string, b map[string]string) []string {
+	changes := []string{}
+	for _, key := range sortedKeys(b) {
+		old, ok := a[key]
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("added %s%s: %s", prefix, key, b[key]))
+		case old != b[key]:
+			changes = append(changes, fmt.Sprintf("%s%s changed from %s to %s", prefix, key, old, b[key]))
+		}
+	}
+	for _, key := range sortedKeys(a) {
+		if _, ok := b[key]; !ok {
+			changes = append(changes, fmt.Sprintf("removed %s%s", prefix, key))
+		}
+	}
+	return changes
+}
+
+// flattenJSON turns a JSON object into a map of dotted paths, like build.gpu, to the JSON of their values
+func flattenJSON(data json.RawMessage) map[string]string {
+	flat := map[string]string{}
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return flat
+	}
+	var flatten func(prefix string, value any)
+	flatten = func(prefix string, value any) {
+		if object, ok := value.(map[string]any); ok && len(object) > 0 {
+			for key, child := range object {
+				if prefix != "" {
+					key = prefix + "." + key
+				}
+				flatten(key, child)
+			}
+			return
+		}
+		encoded, _ := json.Marshal(value)
+		flat[prefix] = string(encoded)
+	}
+	flatten("", value)
+	return flat
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+func sha256Hex(s string) string {
+	hash := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(hash[:])
+}
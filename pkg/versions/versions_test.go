@@ -0,0 +1,139 @@
+package versions
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testSchema = `{
+  "openapi": "3.0.2",
+  "info": {"title": "Cog", "version": "0.1.0"},
+  "paths": {},
+  "components": {
+    "schemas": {
+      "Input": {
+        "type": "object",
+        "properties": {
+          "image": {"type": "string", "format": "uri"},
+          "scale": {"type": "number"},
+          "mode": {"allOf": [{"$ref": "#/components/schemas/mode"}]}
+        }
+      },
+      "mode": {"type": "string", "enum": ["fast", "slow"]},
+      "Output": {"type": "array", "items": {"type": "string", "format": "uri"}}
+    }
+  }
+}`
+
+func TestAddAndFind(t *testing.T) {
+	log := &Log{}
+	log.add(Version{ID: "sha256:aaaa1111", Image: "my-model"})
+	log.add(Version{ID: "sha256:bbbb2222", Image: "my-model"})
+	// Pushing what was just built updates it
+	log.add(Version{ID: "sha256:bbbb2222", Image: "r8.im/alice/my-model", Digest: "r8.im/alice/my-model@sha256:cccc"})
+
+	require.Len(t, log.Versions, 2)
+	require.Equal(t, "sha256:aaaa1111", log.Versions[1].Parent)
+	require.Equal(t, "r8.im/alice/my-model@sha256:cccc", log.Latest().Digest)
+
+	version, err := log.Find("bbbb")
+	require.NoError(t, err)
+	require.Equal(t, "sha256:bbbb2222", version.ID)
+	version, err = log.Find("sha256:aaaa")
+	require.NoError(t, err)
+	require.Equal(t, "sha256:aaaa1111", version.ID)
+	_, err = log.Find("cccc")
+	require.ErrorContains(t, err, "No version matches")
+	_, err = log.Find("")
+	require.ErrorContains(t, err, "more than one version")
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	dir := t.TempDir()
+	log, err := Load(dir)
+	require.NoError(t, err)
+	require.Empty(t, log.Versions)
+
+	log.add(Version{ID: "sha256:aaaa1111", Image: "my-model", BuiltAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)})
+	require.NoError(t, log.Save(dir))
+
+	loaded, err := Load(dir)
+	require.NoError(t, err)
+	require.Equal(t, log, loaded)
+}
+
+func TestSummarizeSchema(t *testing.T) {
+	inputs, output := summarizeSchema(testSchema)
+	require.Equal(t, map[string]string{
+		"image": "string (uri)",
+		"scale": "number",
+		"mode":  "mode",
+	}, inputs)
+	require.Equal(t, "array of string (uri)", output)
+}
+
+func TestDiff(t *testing.T) {
+	a := &Version{
+		GitCommit:    "abc123",
+		CogVersion:   "0.9.0",
+		ConfigSHA256: "1",
+		Config:       json.RawMessage(`{"build":{"gpu":false,"python_version":"3.11"},"predict":"predict.py:Predictor"}`),
+		SchemaSHA256: "1",
+		Inputs:       map[string]string{"image": "string (uri)", "scale": "number"},
+		Output:       "string (uri)",
+	}
+	b := &Version{
+		GitCommit:    "def456",
+		CogVersion:   "0.9.0",
+		ConfigSHA256: "2",
+		Config:       json.RawMessage(`{"build":{"gpu":true,"python_version":"3.11"},"predict":"predict.py:Predictor","image":"r8.im/alice/my-model"}`),
+		SchemaSHA256: "2",
+		Inputs:       map[string]string{"image": "string (uri)", "seed": "integer"},
+		Output:       "string (uri)",
+	}
+	require.Equal(t, []string{
+		"git commit changed from abc123 to def456",
+		"config build.gpu changed from false to true",
+		`added config image: "r8.im/alice/my-model"`,
+		"added input seed: integer",
+		"removed input scale",
+	}, Diff(a, b))
+	require.Empty(t, Diff(a, a))
+}
+
+func TestRollback(t *testing.T) {
+	log := &Log{Versions: []Version{
+		{ID: "sha256:1", Digest: "r8.im/alice/my-model@sha256:aaaa"},
+		{ID: "sha256:2"},
+		{ID: "sha256:3", Digest: "registry.example.com/my-model@sha256:bbbb"},
+		{ID: "sha256:4", Digest: "r8.im/alice/my-model@sha256:cccc"},
+		{ID: "sha256:5", Digest: "r8.im/alice/my-model@sha256:dddd"},
+	}}
+
+	version, err := log.Rollback("r8.im/alice/my-model", "r8.im/alice/my-model@sha256:dddd", 1)
+	require.NoError(t, err)
+	require.Equal(t, "sha256:4", version.ID)
+
+	version, err = log.Rollback("r8.im/alice/my-model", "r8.im/alice/my-model@sha256:dddd", 2)
+	require.NoError(t, err)
+	require.Equal(t, "sha256:1", version.ID)
+
+	_, err = log.Rollback("r8.im/alice/my-model", "r8.im/alice/my-model@sha256:dddd", 3)
+	require.ErrorContains(t, err, "Only 2 versions were pushed")
+
+	_, err = log.Rollback("r8.im/alice/my-model", "r8.im/alice/my-model@sha256:eeee", 1)
+	require.ErrorContains(t, err, "isn't a version")
+}
+
+func TestRepositories(t *testing.T) {
+	log := &Log{Versions: []Version{
+		{ID: "sha256:1", Digest: "r8.im/alice/my-model@sha256:aaaa"},
+		{ID: "sha256:2"},
+		{ID: "sha256:3", Digest: "registry.example.com/my-model@sha256:bbbb"},
+		{ID: "sha256:4", Digest: "r8.im/alice/my-model@sha256:cccc"},
+	}}
+	require.Equal(t, []string{"r8.im/alice/my-model", "registry.example.com/my-model"}, log.Repositories())
+}
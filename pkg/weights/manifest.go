@@ -9,6 +9,9 @@ import (
 	"io"
 	"os"
 	"path"
+	"path/filepath"
+
+	"github.com/replicate/cog/pkg/lfs"
 )
 
 // Manifest contains metadata about weights files in a model
@@ -20,6 +23,8 @@ type Manifest struct {
 type Metadata struct {
 	// CRC32 is the CRC32 checksum of the file encoded as a hexadecimal string
 	CRC32 string `json:"crc32"`
+	// LFSOID is the Git LFS object ID of the file's content, if it is tracked by Git LFS
+	LFSOID string `json:"lfs_oid,omitempty"`
 }
 
 // NewManifest creates a new manifest
@@ -96,12 +101,31 @@ func (m *Manifest) AddFile(path string) error {
 	binary.LittleEndian.PutUint32(bytes, checksum)
 	encoded := hex.EncodeToString(bytes)
 
+	pointer, err := lfs.ReadPointer(path)
+	if err != nil {
+		return err
+	}
+	metadata := Metadata{CRC32: encoded}
+	if pointer != nil {
+		metadata.LFSOID = pointer.OID
+	}
+
 	if m.Files == nil {
 		m.Files = make(map[string]Metadata)
 	}
-	m.Files[path] = Metadata{
-		CRC32: encoded,
-	}
+	m.Files[path] = metadata
 
 	return nil
 }
+
+// AddLFSObjects records the Git LFS object IDs of files in the manifest, so that the manifest
+// changes when the content of an LFS object changes, whether or not it has been pulled.
+func (m *Manifest) AddLFSObjects(oids map[string]string) {
+	for path, metadata := range m.Files {
+		// Git lists paths with forward slashes on every platform
+		if oid, ok := oids[filepath.ToSlash(path)]; ok {
+			metadata.LFSOID = oid
+			m.Files[path] = metadata
+		}
+	}
+}
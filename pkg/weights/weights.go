@@ -20,6 +20,19 @@ var suffixesToIgnore = []string{
 // FileWalker is a function type that walks the file tree rooted at root, calling walkFn for each file or directory in the tree, including root.
 type FileWalker func(root string, walkFn filepath.WalkFunc) error
 
+// RelativeFileWalker walks dir, passing paths relative to dir to walkFn
+func RelativeFileWalker(dir string) FileWalker {
+	return func(root string, walkFn filepath.WalkFunc) error {
+		return filepath.Walk(filepath.Join(dir, root), func(path string, info os.FileInfo, err error) error {
+			rel, relErr := filepath.Rel(dir, path)
+			if relErr != nil {
+				return relErr
+			}
+			return walkFn(rel, info, err)
+		})
+	}
+}
+
 func FindWeights(fw FileWalker) ([]string, []string, error) {
 	var files []string
 	var codeFiles []string
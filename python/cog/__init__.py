@@ -2,9 +2,11 @@ import mimetypes
 
 from pydantic import BaseModel
 
+from .arrays import Array
 from .base_predictor import BasePredictor
 from .mimetypes_ext import install_mime_extensions
 from .server.scope import current_scope
+from .tables import Table
 from .types import (
     AsyncConcatenateIterator,
     ConcatenateIterator,
@@ -26,6 +28,7 @@ except ImportError:
 __all__ = [
     "__version__",
     "current_scope",
+    "Array",
     "AsyncConcatenateIterator",
     "BaseModel",
     "BasePredictor",
@@ -35,4 +38,5 @@ __all__ = [
     "Input",
     "Path",
     "Secret",
+    "Table",
 ]
@@ -0,0 +1,149 @@
+"""
+Array is an output type for large numeric arrays, like embeddings, that are
+sent as binary files rather than as JSON lists of numbers, which are several
+times bigger and slow to encode and decode.
+
+Arrays are saved as .npy files. Clients that would rather have Arrow files
+ask for them with an Accept header of application/vnd.apache.arrow.file.
+"""
+
+import tempfile
+from typing import Any, Dict, Optional, Tuple, Type
+
+import pydantic
+
+from .types import PYDANTIC_V2, Path
+
+NPY_MIME_TYPE = "application/x-npy"
+ARROW_MIME_TYPE = "application/vnd.apache.arrow.file"
+
+# The schema key that describes an Array output, with its dtype and shape
+ARRAY_SCHEMA_KEY = "x-cog-array"
+
+
+class Array(Path):  # pylint: disable=abstract-method
+    """
+    A numeric array output, saved as a .npy file:
+
+        def predict(self, text: str) -> Array["float32", 768]:
+            return Array.save(self.model.encode(text))
+
+    The dtype and shape are optional, and only describe the array in the
+    model's schema. Use None for dimensions whose size varies, like
+    Array["float32", None, 768] for a batch of embeddings.
+    """
+
+    dtype: Optional[str] = None
+    shape: Optional[Tuple[Optional[int], ...]] = None
+
+    def __class_getitem__(cls, params: Any) -> Type["Array"]:
+        if not isinstance(params, tuple):
+            params = (params,)
+        dtype, *shape = params
+        return type(
+            "Array",
+            (cls,),
+            {"dtype": None if dtype is None else str(dtype), "shape": tuple(shape) or None},
+        )
+
+    @classmethod
+    def save(cls, data: Any) -> "Array":
+        """Saves data, a numpy array or anything numpy can make one from, to a .npy file."""
+        import numpy as np  # pylint: disable=import-outside-toplevel
+
+        with tempfile.NamedTemporaryFile(suffix=".npy", delete=False) as f:
+            np.save(f, np.asarray(data))
+        # Always an Array, not a subclass from Array[...], so it can be pickled
+        # to send it from the worker to the server
+        return Array(f.name)
+
+    @classmethod
+    def _schema(cls) -> Dict[str, Any]:
+        array: Dict[str, Any] = {}
+        if cls.dtype is not None:
+            array["dtype"] = cls.dtype
+        if cls.shape is not None:
+            array["shape"] = list(cls.shape)
+        return {"type": "string", "format": "uri", ARRAY_SCHEMA_KEY: array}
+
+    if PYDANTIC_V2:
+        from pydantic.json_schema import JsonSchemaValue
+        from pydantic_core import CoreSchema
+
+        @classmethod
+        def __get_pydantic_json_schema__(
+            cls, core_schema: "CoreSchema", handler: "pydantic.GetJsonSchemaHandler"
+        ) -> "JsonSchemaValue":
+            json_schema = handler(core_schema)
+            json_schema.update(cls._schema())
+            return json_schema
+
+    else:
+
+        @classmethod
+        def __modify_schema__(cls, field_schema: Dict[str, Any]) -> None:
+            field_schema.update(cls._schema())
+
+
+def negotiate_array_type(accept: Optional[str]) -> str:
+    """
+    Returns the MIME type to send arrays as, from a request's Accept header:
+    Arrow if the client prefers it and pyarrow is installed, otherwise npy.
+    """
+    if not accept:
+        return NPY_MIME_TYPE
+    preferences: Dict[str, float] = {}
+    for part in accept.split(","):
+        media_type, *params = [p.strip() for p in part.split(";")]
+        q = 1.0
+        for param in params:
+            key, _, value = param.partition("=")
+            if key.strip() == "q":
+                try:
+                    q = float(value)
+                except ValueError:
+                    q = 0.0
+        preferences[media_type.lower()] = q
+    if preferences.get(ARROW_MIME_TYPE, 0) > preferences.get(NPY_MIME_TYPE, 0):
+        try:
+            import pyarrow  # pylint: disable=import-outside-toplevel,unused-import # noqa: F401
+        except ImportError:
+            return NPY_MIME_TYPE
+        return ARROW_MIME_TYPE
+    return NPY_MIME_TYPE
+
+
+def _to_arrow(path: Path) -> Array:
+    # pylint: disable=import-outside-toplevel
+    import numpy as np
+    import pyarrow as pa  # type: ignore
+
+    array = np.load(path)
+    if array.ndim == 1:
+        column = pa.array(array)
+    elif array.ndim == 2:
+        # A row for each vector, like a batch of embeddings
+        column = pa.FixedSizeListArray.from_arrays(
+            pa.array(array.reshape(-1)), array.shape[1]
+        )
+    else:
+        # Arrow tables are one or two dimensional, so leave it as npy
+        return Array(path)
+    table = pa.table({"value": column})
+    with tempfile.NamedTemporaryFile(suffix=".arrow", delete=False) as f:
+        with pa.ipc.new_file(f, table.schema) as writer:
+            writer.write_table(table)
+    return Array(f.name)
+
+
+def convert_arrays(obj: Any, mime_type: str) -> Any:
+    """Converts the Arrays in an output to mime_type."""
+    if mime_type == NPY_MIME_TYPE:
+        return obj
+    if isinstance(obj, dict):
+        return {key: convert_arrays(value, mime_type) for key, value in obj.items()}
+    if isinstance(obj, list):
+        return [convert_arrays(value, mime_type) for value in obj]
+    if isinstance(obj, Array) and obj.suffix == ".npy":
+        return _to_arrow(obj)
+    return obj
@@ -480,6 +480,10 @@ For example:
     # attributes should be resolved to names, maybe blindly
     # subscript values are iterator or
     name = resolve_name(annotation)
+    if name == "Array":
+        return {}, {"title": "Output", **array_schema(annotation)}
+    if name == "Table":
+        return {}, {"title": "Output", "type": "string", "format": "uri", "x-cog-table": {}}
     if isinstance(annotation, ast.Subscript):
         # forget about other subscripts like Optional, and assume otherlib.File will still be an uri
         slice = resolve_name(annotation.slice)  # pylint: disable=redefined-builtin
@@ -510,6 +514,25 @@ For example:
     }
 
 
+def array_schema(annotation: ast.expr) -> "JSONDict":
+    """The schema of a cog.Array output, like Array["float32", None, 768]"""
+    array: JSONDict = {}
+    if isinstance(annotation, ast.Subscript):
+        node = annotation.slice
+        if isinstance(node, ast.Index):
+            # deprecated, but needed for py3.8
+            node = node.value  # type: ignore
+        params = ast.literal_eval(node)
+        if not isinstance(params, tuple):
+            params = (params,)
+        dtype, *shape = params
+        if dtype is not None:
+            array["dtype"] = str(dtype)
+        if shape:
+            array["shape"] = list(shape)
+    return {"type": "string", "format": "uri", "x-cog-array": array}
+
+
 KEPT_ATTRS = ("description", "default", "ge", "le", "max_length", "min_length", "regex")
 
 
@@ -543,6 +566,9 @@ def extract_info(code: str) -> "JSONDict":  # pylint: disable=too-many-branches,
         arg_type = OPENAPI_TYPES.get(annotation, "string")
         if annotation in ("Path", "File"):
             input["format"] = "uri"
+        elif annotation == "Table":
+            input["format"] = "uri"
+            input["x-cog-table"] = {}
         elif annotation.startswith("Literal["):
             input["enum"] = list(
                 ast.literal_eval(annotation[7:])  # Safely eval the literal values
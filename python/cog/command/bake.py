@@ -0,0 +1,41 @@
+"""
+python -m cog.command.bake
+
+This runs the model's setup so that anything it writes to disk, like compiled kernels, TensorRT engines or
+tokenizer caches, can be added to the image by `cog build`. See `build.bake` in cog.yaml.
+"""
+
+import asyncio
+import inspect
+import sys
+
+from ..config import Config
+from ..errors import ConfigDoesNotExist
+from ..mode import Mode
+from ..predictor import (
+    extract_setup_weights,
+    has_setup_weights,
+    load_predictor_from_ref,
+)
+
+
+def bake() -> None:
+    ref = Config().get_predictor_ref(Mode.PREDICT)
+    predictor = load_predictor_from_ref(ref)
+    if not hasattr(predictor, "setup"):
+        print("Predictor has no setup method, nothing to bake", file=sys.stderr)
+        return
+
+    if has_setup_weights(predictor):
+        result = predictor.setup(weights=extract_setup_weights(predictor))  # type: ignore
+    else:
+        result = predictor.setup()
+    if inspect.iscoroutine(result):
+        asyncio.run(result)
+
+
+if __name__ == "__main__":
+    try:
+        bake()
+    except ConfigDoesNotExist:
+        raise ConfigDoesNotExist("no cog.yaml found or present") from None
@@ -2,7 +2,7 @@ import inspect
 import os
 import sys
 import uuid
-from typing import Any, Callable, Optional, Tuple, Type
+from typing import Any, Callable, Dict, List, Optional, Tuple, Type
 
 import structlog
 import yaml
@@ -15,10 +15,13 @@ from .env_property import env_property
 from .errors import ConfigDoesNotExist
 from .mode import Mode
 from .predictor import (
+    STEP_METHOD_NAME,
+    SessionTypes,
     get_input_type,
     get_output_type,
     get_predict,
     get_predictor,
+    get_session_types,
     get_train,
     get_training_input_type,
     get_training_output_type,
@@ -34,12 +37,21 @@ COG_PREDICT_CODE_STRIP_ENV_VAR = "COG_PREDICT_CODE_STRIP"
 COG_TRAIN_CODE_STRIP_ENV_VAR = "COG_TRAIN_CODE_STRIP"
 COG_GPU_ENV_VAR = "COG_GPU"
 COG_MAX_CONCURRENCY_ENV_VAR = "COG_MAX_CONCURRENCY"
+COG_SETUP_THREADS_ENV_VAR = "COG_SETUP_THREADS"
+COG_SETUP_STRATEGY_ENV_VAR = "COG_SETUP_STRATEGY"
+COG_SETUP_DEVICE_MAP_ENV_VAR = "COG_SETUP_DEVICE_MAP"
+SETUP_STRATEGY_THREAD = "thread"
+SETUP_STRATEGY_PROCESS = "process"
 PREDICT_METHOD_NAME = "predict"
 TRAIN_METHOD_NAME = "train"
 
 log = structlog.get_logger("cog.config")
 
 
+def _is_async(fn: Callable[[Any], Any]) -> bool:
+    return inspect.iscoroutinefunction(fn) or inspect.isasyncgenfunction(fn)
+
+
 def _method_name_from_mode(mode: Mode) -> str:
     if mode == Mode.PREDICT:
         return PREDICT_METHOD_NAME
@@ -96,6 +108,43 @@ class Config:
         """Find the predictor ref for the train mode."""
         return self._cog_config.get(str(Mode.TRAIN))
 
+    @property
+    def pipeline(self) -> List[Dict[str, str]]:
+        """The steps of the model's pipeline, or an empty list if it doesn't have one."""
+        return self._cog_config.get("pipeline") or []
+
+    def _setup_config(self) -> Dict[str, Any]:
+        try:
+            return self._cog_config.get("setup") or {}
+        except ConfigDoesNotExist:
+            return {}
+
+    @property
+    @env_property(COG_SETUP_THREADS_ENV_VAR)
+    def setup_threads(self) -> Optional[int]:
+        """The number of workers setup() loads files in parallel with. Defaults to the number of CPUs."""
+        return self._setup_config().get("threads")
+
+    @property
+    @env_property(COG_SETUP_STRATEGY_ENV_VAR)
+    def setup_strategy(self) -> str:
+        """Whether setup() loads files in parallel with threads or processes."""
+        return str(self._setup_config().get("strategy", SETUP_STRATEGY_THREAD))
+
+    @property
+    @env_property(COG_SETUP_DEVICE_MAP_ENV_VAR)
+    def setup_device_map(self) -> Optional[str]:
+        """The device map setup() loads models with, like "auto"."""
+        return self._setup_config().get("device_map")
+
+    @property
+    def sidecars(self) -> List[Dict[str, Any]]:
+        """The processes to run alongside the predictor, or an empty list if there aren't any."""
+        try:
+            return self._cog_config.get("sidecars") or []
+        except ConfigDoesNotExist:
+            return []
+
     @property
     @env_property(COG_GPU_ENV_VAR)
     def requires_gpu(self) -> bool:
@@ -172,19 +221,36 @@ class Config:
             predictor_ref, _method_name_from_mode(mode=mode), mode
         )
 
-        def is_async(fn: Callable[[Any], Any]) -> bool:
-            return inspect.iscoroutinefunction(fn) or inspect.isasyncgenfunction(fn)
-
         if mode == Mode.PREDICT:
             return (
                 get_input_type(predictor),
                 get_output_type(predictor),
-                is_async(get_predict(predictor)),
+                _is_async(get_predict(predictor)),
             )
         elif mode == Mode.TRAIN:
             return (
                 get_training_input_type(predictor),
                 get_training_output_type(predictor),
-                is_async(get_train(predictor)),
+                _is_async(get_train(predictor)),
             )
         raise ValueError(f"Mode {mode} not found for generating input/output types.")
+
+    def get_session_types(self) -> Optional[SessionTypes]:
+        """
+        Find the input & output types of a stateful predictor's session
+        methods, or None if the predictor isn't stateful.
+        """
+        predictor = self._load_predictor_for_types(
+            self.get_predictor_ref(mode=Mode.PREDICT), STEP_METHOD_NAME, Mode.PREDICT
+        )
+        session_types = get_session_types(predictor)
+        if session_types is None:
+            return None
+        for name in session_types.methods:
+            if _is_async(getattr(predictor, name)) != _is_async(
+                get_predict(predictor)
+            ):
+                raise TypeError(
+                    f"{name}() must be async if predict() is, and not if it isn't"
+                )
+        return session_types
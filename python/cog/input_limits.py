@@ -0,0 +1,131 @@
+"""
+Checks file inputs against the limits set with Input(max_size=...,
+mime_types=...), before they're converted to files, so requests with files
+that are too big or of the wrong type get a 422 before predict() runs.
+
+Files sent as data URLs, like `cog predict` sends them, and uploads are
+checked for both. Uploads can't be started for files larger than the largest
+max_size.
+Files sent as HTTP URLs are checked for their type by their extension, because
+their size isn't known until they're downloaded.
+"""
+
+import base64
+import binascii
+import fnmatch
+import mimetypes
+import urllib.parse
+from typing import Any, Callable, Dict, List, Optional, Tuple, Type
+
+import pydantic
+from pydantic.fields import FieldInfo
+
+from .types import MAX_SIZE_SCHEMA_KEY, MIME_TYPES_SCHEMA_KEY, PYDANTIC_V2
+from .uploads import is_upload, resolve_upload
+
+
+def _parse_data_url(url: str) -> Tuple[str, int]:
+    """Returns the MIME type of a data URL, and the size of its data in bytes."""
+    header, sep, data = url[len("data:") :].partition(",")
+    if not sep:
+        raise ValueError("is not a valid data URL")
+    params = header.split(";")
+    mime_type = params[0] or "text/plain"
+    if "base64" in params[1:]:
+        try:
+            size = len(base64.b64decode(data, validate=False))
+        except (binascii.Error, ValueError) as e:
+            raise ValueError("is not a valid base64 data URL") from e
+    else:
+        size = len(urllib.parse.unquote_to_bytes(data))
+    return mime_type.lower(), size
+
+
+def _mime_type_allowed(mime_type: str, allowed: List[str]) -> bool:
+    return any(fnmatch.fnmatch(mime_type, pattern.lower()) for pattern in allowed)
+
+
+def check_file(value: Any, max_size: Optional[int], mime_types: Optional[List[str]]) -> None:
+    """Raises ValueError if value, a file input as it was sent, breaks the limits."""
+    if not isinstance(value, str):
+        return
+    parsed = urllib.parse.urlparse(value)
+    if parsed.scheme == "data":
+        mime_type, size = _parse_data_url(value)
+    elif parsed.scheme in ("http", "https"):
+        guessed, _ = mimetypes.guess_type(parsed.path)
+        if guessed is None:
+            return
+        mime_type, size = guessed.lower(), None
+    elif is_upload(value):
+        path, size = resolve_upload(value)
+        guessed, _ = mimetypes.guess_type(path.name)
+        mime_type = (guessed or "application/octet-stream").lower()
+    else:
+        return
+
+    if max_size is not None and size is not None and size > max_size:
+        raise ValueError(f"file is {size} bytes, larger than the maximum of {max_size} bytes")
+    if mime_types and not _mime_type_allowed(mime_type, mime_types):
+        raise ValueError(f"file type {mime_type} isn't allowed, it must be one of: {', '.join(mime_types)}")
+
+
+def _field_extra(field: FieldInfo) -> Dict[str, Any]:
+    if PYDANTIC_V2:
+        extra = field.json_schema_extra  # type: ignore
+        return extra if isinstance(extra, dict) else {}
+    return field.extra  # type: ignore
+
+
+def _make_validator(
+    max_size: Optional[int], mime_types: Optional[List[str]]
+) -> Callable[[Any, Any], Any]:
+    def validate(cls: Any, value: Any) -> Any:  # pylint: disable=unused-argument
+        for item in value if isinstance(value, list) else [value]:
+            check_file(item, max_size, mime_types)
+        return value
+
+    return validate
+
+
+def max_file_size(*input_types: Type[pydantic.BaseModel]) -> Optional[int]:
+    """
+    Returns the largest file that the file inputs of input_types accept, which
+    uploads are limited to, or None if one of them accepts files of any size.
+    If there aren't any file inputs, it's 0.
+    """
+    largest = 0
+    for input_type in input_types:
+        if PYDANTIC_V2:
+            properties = input_type.model_json_schema().get("properties", {})  # type: ignore
+        else:
+            properties = input_type.schema().get("properties", {})  # type: ignore
+        for prop in properties.values():
+            if prop.get("format") != "uri" and prop.get("items", {}).get("format") != "uri":
+                continue
+            max_size = prop.get(MAX_SIZE_SCHEMA_KEY)
+            if max_size is None:
+                return None
+            largest = max(largest, max_size)
+    return largest
+
+
+def get_input_limit_validators(
+    create_model_kwargs: Dict[str, Tuple[Any, FieldInfo]],
+) -> Dict[str, Callable[..., Any]]:
+    """Returns the validators, for pydantic.create_model, for the inputs that have limits."""
+    validators: Dict[str, Callable[..., Any]] = {}
+    for name, (_, field) in create_model_kwargs.items():
+        extra = _field_extra(field)
+        max_size = extra.get(MAX_SIZE_SCHEMA_KEY)
+        mime_types = extra.get(MIME_TYPES_SCHEMA_KEY)
+        if max_size is None and not mime_types:
+            continue
+
+        validate = _make_validator(max_size, mime_types)
+        if PYDANTIC_V2:
+            validator = pydantic.field_validator(name, mode="before")(validate)  # type: ignore
+        else:
+            validator = pydantic.validator(name, pre=True, allow_reuse=True)(validate)  # type: ignore
+        validators[f"check_{name}_limits"] = validator
+    return validators
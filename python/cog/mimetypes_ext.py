@@ -21,3 +21,11 @@ def install_mime_extensions(mimetypes: IMimeTypes) -> None:
     # mimetypes.read_mime_types().
     if sys.version_info < (3, 13):
         mimetypes.add_type("image/webp", ".webp")
+
+    # Array outputs, from cog.Array
+    mimetypes.add_type("application/x-npy", ".npy")
+    mimetypes.add_type("application/vnd.apache.arrow.file", ".arrow")
+
+    # Tables, from cog.Table
+    mimetypes.add_type("application/vnd.apache.arrow.stream", ".arrows")
+    mimetypes.add_type("application/vnd.apache.parquet", ".parquet")
@@ -0,0 +1,130 @@
+"""
+The predictor for models with a `pipeline` in cog.yaml. It runs the steps one
+after the other, passing the output of each to an input of the next.
+
+Its inputs are the first step's inputs, and the inputs of the other steps that
+aren't passed the previous step's output. Its output is the last step's output.
+"""
+
+import inspect
+from collections.abc import Iterator
+from typing import Any, Dict, List, Optional, get_origin
+
+from .config import Config
+from .predictor import (
+    extract_setup_weights,
+    get_predict,
+    has_setup_weights,
+    load_predictor_from_ref,
+)
+
+
+class PipelineStep:
+    def __init__(self, ref: str, input_name: Optional[str], first: bool) -> None:
+        self.ref = ref
+        self.predictor = load_predictor_from_ref(ref)
+        self.predict = get_predict(self.predictor)
+        if inspect.iscoroutinefunction(self.predict) or inspect.isasyncgenfunction(
+            self.predict
+        ):
+            raise TypeError(f"Pipeline step {ref} can't be async")
+
+        signature = inspect.signature(self.predict)
+        self.return_annotation = signature.return_annotation
+        parameters = list(signature.parameters.values())
+
+        # The input the previous step's output is passed to
+        self.input_name: Optional[str] = None
+        if not first:
+            if not parameters:
+                raise TypeError(
+                    f"Pipeline step {ref} must have an input for the previous step's output"
+                )
+            self.input_name = input_name or parameters[0].name
+            if self.input_name not in signature.parameters:
+                raise TypeError(
+                    f"Pipeline step {ref} doesn't have an input named {self.input_name}"
+                )
+        self.parameters = [p for p in parameters if p.name != self.input_name]
+
+    def setup(self) -> None:
+        # Could be a function or a class
+        if not hasattr(self.predictor, "setup"):
+            return
+        if has_setup_weights(self.predictor):
+            self.predictor.setup(weights=extract_setup_weights(self.predictor))  # type: ignore
+        else:
+            self.predictor.setup()
+
+    def run(self, previous: Any, inputs: Dict[str, Any]) -> Any:
+        kwargs = {p.name: inputs[p.name] for p in self.parameters}
+        if self.input_name is not None:
+            kwargs[self.input_name] = previous
+        return self.predict(**kwargs)
+
+
+def load_steps(pipeline: List[Dict[str, str]]) -> List[PipelineStep]:
+    steps = [
+        PipelineStep(step["predict"], step.get("input"), first=i == 0)
+        for i, step in enumerate(pipeline)
+    ]
+    for step in steps[:-1]:
+        if get_origin(step.return_annotation) is Iterator:
+            raise TypeError(
+                f"Pipeline step {step.ref} can't return an iterator, only the last step can"
+            )
+    return steps
+
+
+def signature_of(steps: List[PipelineStep]) -> inspect.Signature:
+    """
+    The signature of the pipeline's predict(), which the schema is generated
+    from. Inputs are keyword-only, so steps can have inputs without defaults
+    after inputs with them.
+    """
+    parameters: Dict[str, inspect.Parameter] = {}
+    for step in steps:
+        for parameter in step.parameters:
+            if parameter.name in parameters:
+                raise TypeError(
+                    f"Pipeline steps can't have inputs with the same name: {parameter.name} is an input of more than one step"
+                )
+            parameters[parameter.name] = parameter.replace(
+                kind=inspect.Parameter.KEYWORD_ONLY
+            )
+    return inspect.Signature(
+        list(parameters.values()), return_annotation=steps[-1].return_annotation
+    )
+
+
+class Pipeline:
+    def __init__(self, pipeline: Optional[List[Dict[str, str]]] = None) -> None:
+        if pipeline is None:
+            pipeline = Config().pipeline
+        if not pipeline:
+            raise ValueError("Can't run a pipeline: 'pipeline' not found in cog.yaml")
+        self.steps = load_steps(pipeline)
+
+        last = self.steps[-1]
+
+        def run(**inputs: Any) -> Any:
+            output = None
+            for step in self.steps[:-1]:
+                output = step.run(output, inputs)
+            return last.run(output, inputs)
+
+        def run_iterator(**inputs: Any) -> Any:
+            yield from run(**inputs)
+
+        predict = (
+            run_iterator
+            if get_origin(last.return_annotation) is Iterator
+            else run
+        )
+        # The schema is generated from the signature of predict()
+        predict.__signature__ = signature_of(self.steps)  # type: ignore
+        self.predict = predict
+
+    def setup(self) -> None:
+        for step in self.steps:
+            step.setup()
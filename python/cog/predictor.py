@@ -16,6 +16,7 @@ from typing import (
     Dict,
     List,
     Literal,
+    NamedTuple,
     Optional,
     Type,
     Union,
@@ -36,6 +37,8 @@ from typing_extensions import Annotated
 from .base_input import BaseInput
 from .base_predictor import BasePredictor
 from .code_xforms import load_module_from_string, strip_model_source_code
+from .input_limits import get_input_limit_validators
+from .tables import Table
 from .types import (
     PYDANTIC_V2,
     Input,
@@ -59,6 +62,7 @@ ALLOWED_INPUT_TYPES: List[Type[Any]] = [
     CogFile,
     CogPath,
     CogSecret,
+    Table,
 ]
 
 
@@ -304,14 +308,15 @@ def get_input_type(predictor: BasePredictor) -> Type[BaseInput]:
 
     predict = get_predict(predictor)
     signature = inspect.signature(predict)
+    create_model_kwargs = get_input_create_model_kwargs(signature)
 
     return create_model(
         "Input",
         __config__=None,
         __base__=BaseInput,
         __module__=__name__,
-        __validators__=None,
-        **get_input_create_model_kwargs(signature),
+        __validators__=get_input_limit_validators(create_model_kwargs),  # type: ignore
+        **create_model_kwargs,
     )  # type: ignore
 
 
@@ -410,14 +415,15 @@ def get_training_input_type(predictor: BasePredictor) -> Type[BaseInput]:
 
     train = get_train(predictor)
     signature = inspect.signature(train)
+    create_model_kwargs = get_input_create_model_kwargs(signature)
 
     return create_model(
         "TrainingInput",
         __config__=None,
         __base__=BaseInput,
         __module__=__name__,
-        __validators__=None,
-        **get_input_create_model_kwargs(signature),
+        __validators__=get_input_limit_validators(create_model_kwargs),  # type: ignore
+        **create_model_kwargs,
     )  # type: ignore
 
 
@@ -479,6 +485,123 @@ For example:
             return TrainingOutput
 
 
+SESSION_ID_PARAMETER = "session_id"
+CREATE_SESSION_METHOD_NAME = "create_session"
+STEP_METHOD_NAME = "step"
+CLOSE_SESSION_METHOD_NAME = "close_session"
+
+
+class SessionTypes(NamedTuple):
+    session_input: Type[BaseInput]
+    step_input: Type[BaseInput]
+    step_output: Type[BaseModel]
+    # The session methods the predictor defines, out of create_session(), step() and close_session()
+    methods: List[str]
+
+
+def is_stateful(predictor: Any) -> bool:
+    """
+    Whether a predictor keeps state between predictions in sessions, which it opts in to by defining step().
+    """
+    return callable(getattr(predictor, STEP_METHOD_NAME, None))
+
+
+def get_session_input_type(
+    predictor: Any, method_name: str, model_name: str
+) -> Type[BaseInput]:
+    """
+    Creates a Pydantic Input model from the arguments of a session method, like step(), apart from session_id.
+
+    class Predictor(BasePredictor):
+        def step(self, session_id: str, text: str):
+            ...
+
+    programmatically creates a model like this:
+
+    class StepInput(BaseModel):
+        text: str
+    """
+
+    parameters = []
+    method = getattr(predictor, method_name, None)
+    if method is not None:
+        signature = inspect.signature(method)
+        if SESSION_ID_PARAMETER not in signature.parameters:
+            raise TypeError(
+                f"{method_name}() must take a {SESSION_ID_PARAMETER} argument, which identifies the session it's for"
+            )
+        parameters = [
+            p for name, p in signature.parameters.items() if name != SESSION_ID_PARAMETER
+        ]
+
+    return create_model(
+        model_name,
+        __config__=None,
+        __base__=BaseInput,
+        __module__=__name__,
+        __validators__=None,
+        **get_input_create_model_kwargs(inspect.Signature(parameters)),
+    )  # type: ignore
+
+
+def get_step_output_type(predictor: Any) -> Type[BaseModel]:
+    """
+    Creates a Pydantic Output model from the return type annotation of a stateful predictor's step() method.
+    """
+
+    signature = inspect.signature(getattr(predictor, STEP_METHOD_NAME))
+    if signature.return_annotation is inspect.Signature.empty:
+        raise TypeError(
+            "You must set an output type for step(). If it can return multiple output types, you can explicitly set `Any` as the output type."
+        )
+    StepOutputType = signature.return_annotation
+
+    # Like predict(), a step that yields returns a list of the yielded type
+    if get_origin(StepOutputType) is Iterator:
+        if PYDANTIC_V2:
+            field = Field(**{"json_schema_extra": {"x-cog-array-type": "iterator"}})  # type: ignore
+        else:
+            field = Field(**{"x-cog-array-type": "iterator"})  # type: ignore
+        StepOutputType = Annotated[List[get_args(StepOutputType)[0]], field]  # type: ignore
+
+    if PYDANTIC_V2:
+
+        class StepOutput(pydantic.RootModel[StepOutputType]):  # type: ignore
+            pass
+
+    else:
+
+        class StepOutput(BaseModel):
+            __root__: StepOutputType  # type: ignore
+
+    return StepOutput
+
+
+def get_session_types(predictor: Any) -> Optional[SessionTypes]:
+    """
+    Creates the input and output models of a stateful predictor's session methods, or returns None if it isn't stateful.
+    """
+
+    if not is_stateful(predictor):
+        return None
+    return SessionTypes(
+        session_input=get_session_input_type(
+            predictor, CREATE_SESSION_METHOD_NAME, "SessionInput"
+        ),
+        step_input=get_session_input_type(predictor, STEP_METHOD_NAME, "StepInput"),
+        step_output=get_step_output_type(predictor),
+        methods=[
+            name
+            for name in [
+                CREATE_SESSION_METHOD_NAME,
+                STEP_METHOD_NAME,
+                CLOSE_SESSION_METHOD_NAME,
+            ]
+            if callable(getattr(predictor, name, None))
+        ],
+    )
+
+
 def human_readable_type_name(t: Type[Union[Any, None]]) -> str:
     """
     Generates a useful-for-humans label for a type. For builtin types, it's just the class name (eg "str" or "int"). For other types, it includes the module (eg "pathlib.Path" or "cog.File").
@@ -0,0 +1,94 @@
+import hashlib
+import json
+import os
+import tempfile
+import time
+from pathlib import Path
+from typing import Any, Dict, Optional, Union
+
+import structlog
+
+log = structlog.get_logger("cog.server.cache")
+
+# The default time a cached prediction is used for, in seconds
+DEFAULT_TTL = 24 * 60 * 60
+
+
+class PredictionCache:
+    """
+    A cache of successful prediction responses on disk, so predictions with
+    the same input aren't run again. It's only correct for models whose output
+    only depends on their input, so it's opt-in, for developing frontends
+    against a model with `cog serve --cache`.
+
+    Responses are keyed by the model's schema and the input, so they aren't
+    used after the model's inputs or outputs change.
+    """
+
+    def __init__(
+        self,
+        directory: Union[str, Path],
+        ttl: float = DEFAULT_TTL,
+        schema_version: str = "",
+    ) -> None:
+        self.directory = Path(directory)
+        self.ttl = ttl
+        self.schema_version = schema_version
+        self.directory.mkdir(parents=True, exist_ok=True)
+
+    def key(self, input: Dict[str, Any]) -> str:  # pylint: disable=redefined-builtin
+        canonical = json.dumps(
+            {"schema": self.schema_version, "input": input},
+            sort_keys=True,
+            separators=(",", ":"),
+            ensure_ascii=False,
+            default=str,
+        )
+        return hashlib.sha256(canonical.encode("utf-8")).hexdigest()
+
+    def _path(self, key: str) -> Path:
+        return self.directory / f"{key}.json"
+
+    def get(self, key: str) -> Optional[Dict[str, Any]]:
+        path = self._path(key)
+        try:
+            age = time.time() - path.stat().st_mtime
+            if self.ttl > 0 and age > self.ttl:
+                path.unlink(missing_ok=True)
+                return None
+            with path.open(encoding="utf-8") as f:
+                return json.load(f)
+        except FileNotFoundError:
+            return None
+        except (OSError, ValueError) as e:
+            log.warn("failed to read cached prediction", key=key, error=str(e))
+            return None
+
+    def put(self, key: str, response: Dict[str, Any]) -> None:
+        # Write to a temporary file and rename it, so a response is never read
+        # half-written
+        try:
+            fd, tmp = tempfile.mkstemp(dir=self.directory, suffix=".tmp")
+            with os.fdopen(fd, "w", encoding="utf-8") as f:
+                json.dump(response, f)
+            os.replace(tmp, self._path(key))
+        except OSError as e:
+            log.warn("failed to cache prediction", key=key, error=str(e))
+
+    def prune(self) -> None:
+        """Deletes the cached responses that have expired."""
+        if self.ttl <= 0:
+            return
+        now = time.time()
+        for path in self.directory.glob("*.json"):
+            try:
+                if now - path.stat().st_mtime > self.ttl:
+                    path.unlink()
+            except OSError:
+                pass
+
+
+def schema_version(openapi_schema: Dict[str, Any]) -> str:
+    """A hash of a model's OpenAPI schema, that changes when its inputs or outputs do."""
+    canonical = json.dumps(openapi_schema, sort_keys=True, separators=(",", ":"))
+    return hashlib.sha256(canonical.encode("utf-8")).hexdigest()[:16]
@@ -13,6 +13,9 @@ class Cancel:
 @define
 class PredictionInput:
     payload: Dict[str, Any]
+    # The predictor method to call, which is step() or another session method
+    # for stateful predictors
+    method: str = "predict"
 
 
 @define
@@ -1,6 +1,7 @@
 import argparse
 import asyncio
 import functools
+import json
 import logging
 import os
 import signal
@@ -8,14 +9,25 @@ import socket
 import sys
 import textwrap
 import threading
+import time
 import traceback
+import uuid
 from datetime import datetime, timezone
 from enum import Enum, auto, unique
-from typing import TYPE_CHECKING, Any, Awaitable, Callable, Dict, Optional, Type
+from typing import (
+    TYPE_CHECKING,
+    Any,
+    Awaitable,
+    Callable,
+    Dict,
+    Optional,
+    Set,
+    Type,
+)
 
 import structlog
 import uvicorn
-from fastapi import Body, FastAPI, Header, Path, Response
+from fastapi import Body, FastAPI, Header, Path, Query, Request, Response
 from fastapi.encoders import jsonable_encoder
 from fastapi.exceptions import HTTPException
 from fastapi.openapi.utils import get_openapi
@@ -23,13 +35,17 @@ from fastapi.responses import JSONResponse
 from pydantic import ValidationError
 
 from .. import schema
+from ..arrays import NPY_MIME_TYPE, convert_arrays, negotiate_array_type
 from ..config import Config
 from ..errors import PredictorNotSet
 from ..files import upload_file
+from ..input_limits import max_file_size
 from ..json import upload_files
 from ..logging import setup_logging
 from ..mode import Mode
+from ..tables import table_input
 from ..types import PYDANTIC_V2
+from ..uploads import OffsetMismatchError, Uploads, upload_dir
 
 try:
     from .._version import __version__
@@ -42,6 +58,7 @@ if PYDANTIC_V2:
         update_openapi_schema_for_pydantic_2,
     )
 
+from .cache import DEFAULT_TTL, PredictionCache, schema_version
 from .probes import ProbeHelper
 from .runner import (
     PredictionRunner,
@@ -49,6 +66,8 @@ from .runner import (
     SetupResult,
     UnknownPredictionError,
 )
+from .sidecars import Sidecars
+from .table_requests import TableRequestMiddleware, add_table_request_bodies
 from .telemetry import make_trace_context, trace_context
 from .worker import make_worker
 
@@ -69,11 +88,16 @@ class Health(Enum):
     BUSY = auto()
     SETUP_FAILED = auto()
     DEFUNCT = auto()
+    DRAINING = auto()
+    UNHEALTHY = auto()
 
 
 class MyState:
     health: Health
     setup_result: Optional[SetupResult]
+    draining: bool
+    in_flight: Callable[[], int]
+    drain: Callable[[float], None]
 
 
 class MyFastAPI(FastAPI):
@@ -117,6 +141,9 @@ def create_app(  # pylint: disable=too-many-arguments,too-many-locals,too-many-s
     mode: Mode = Mode.PREDICT,
     is_build: bool = False,
     await_explicit_shutdown: bool = False,  # pylint: disable=redefined-outer-name
+    drain_timeout: float = 0,
+    cache_dir: Optional[str] = None,
+    cache_ttl: float = DEFAULT_TTL,
 ) -> MyFastAPI:
     app = MyFastAPI(  # pylint: disable=redefined-outer-name
         title="Cog",  # TODO: mention model name?
@@ -137,20 +164,69 @@ def create_app(  # pylint: disable=too-many-arguments,too-many-locals,too-many-s
             if PYDANTIC_V2:
                 update_openapi_schema_for_pydantic_2(openapi_schema)
 
+            add_table_request_bodies(openapi_schema, tables)
+
             app.openapi_schema = openapi_schema
 
         return app.openapi_schema
 
     app.openapi = custom_openapi
 
+    prediction_cache = PredictionCache(cache_dir, ttl=cache_ttl) if cache_dir else None
+
+    # The endpoints that accept a table as the request body, and the name of
+    # the Table input it's sent to
+    tables: Dict[str, str] = {}
+
     app.state.health = Health.STARTING
     app.state.setup_result = None
     started_at = datetime.now(tz=timezone.utc)
 
+    default_drain_timeout = drain_timeout
+    app.state.draining = False
+    # replaced with the runner's count once it's created
+    app.state.in_flight = lambda: 0
+
+    def drain(timeout: float) -> None:
+        """
+        Stop accepting predictions, and wait up to timeout seconds for the running ones to finish.
+        """
+        app.state.draining = True
+        deadline = time.monotonic() + timeout
+        while app.state.in_flight() > 0:
+            if time.monotonic() >= deadline:
+                log.warn(
+                    "timed out draining, shutting down with predictions running",
+                    in_flight=app.state.in_flight(),
+                )
+                return
+            time.sleep(0.1)
+        log.info("drained")
+
+    app.state.drain = drain
+
     # shutdown is needed no matter what happens
     @app.post("/shutdown")
-    async def start_shutdown() -> Any:
+    async def start_shutdown(
+        drain_timeout: Optional[float] = Query(default=None),
+    ) -> Any:
         log.info("shutdown requested via http")
+        timeout = default_drain_timeout if drain_timeout is None else drain_timeout
+        in_flight = app.state.in_flight()
+        app.state.draining = True
+        if timeout > 0 and in_flight > 0:
+            log.info("draining before shutdown", in_flight=in_flight, timeout=timeout)
+
+            def _drain_and_shutdown() -> None:
+                drain(timeout)
+                if shutdown_event:
+                    shutdown_event.set()
+
+            threading.Thread(target=_drain_and_shutdown, daemon=True).start()
+            return JSONResponse(
+                {"status": Health.DRAINING.name, "predictions_in_flight": in_flight},
+                status_code=202,
+            )
         if shutdown_event:
             shutdown_event.set()
         return JSONResponse({}, status_code=200)
@@ -159,6 +235,7 @@ def create_app(  # pylint: disable=too-many-arguments,too-many-locals,too-many-s
         InputType, OutputType, is_async = cog_config.get_predictor_types(
             mode=Mode.PREDICT
         )
+        session_types = cog_config.get_session_types()
     except Exception:  # pylint: disable=broad-exception-caught
         msg = "Error while loading predictor:\n\n" + traceback.format_exc()
         add_setup_failed_routes(app, started_at, msg)
@@ -170,10 +247,17 @@ def create_app(  # pylint: disable=too-many-arguments,too-many-locals,too-many-s
         max_concurrency=cog_config.max_concurrency,
     )
     runner = PredictionRunner(worker=worker, max_concurrency=cog_config.max_concurrency)
+    app.state.in_flight = runner.in_flight
+    sidecars = Sidecars(cog_config.sidecars)
 
     class PredictionRequest(schema.PredictionRequest.with_types(input_type=InputType)):
         pass
 
+    table = table_input(InputType)
+    if table is not None:
+        tables["predictions"] = table
+    uploads = Uploads(upload_dir(), max_size=max_file_size(InputType))
+
     PredictionResponse = schema.PredictionResponse.with_types(  # pylint: disable=invalid-name
         input_type=InputType, output_type=OutputType
     )
@@ -199,6 +283,7 @@ def create_app(  # pylint: disable=too-many-arguments,too-many-locals,too-many-s
         "predictions_url": "/predictions",
         "predictions_idempotent_url": "/predictions/{prediction_id}",
         "predictions_cancel_url": "/predictions/{prediction_id}/cancel",
+        "uploads_url": "/uploads",
     }
 
     if cog_config.predictor_train_ref:
@@ -212,6 +297,11 @@ def create_app(  # pylint: disable=too-many-arguments,too-many-locals,too-many-s
             ):
                 pass
 
+            training_table = table_input(TrainingInputType)
+            if training_table is not None:
+                tables["trainings"] = training_table
+            uploads.max_size = max_file_size(InputType, TrainingInputType)
+
             TrainingResponse = schema.TrainingResponse.with_types(  # pylint: disable=invalid-name
                 input_type=TrainingInputType, output_type=TrainingOutputType
             )
@@ -311,8 +401,139 @@ def create_app(  # pylint: disable=too-many-arguments,too-many-locals,too-many-s
                 add_setup_failed_routes(app, started_at, msg)
                 return app
 
+    if session_types:
+        # The sessions a stateful predictor has open. Steps keep state in the
+        # model process, so a router has to send every step of a session to
+        # the instance that created it.
+        sessions: Set[str] = set()
+
+        class SessionRequest(
+            schema.PredictionRequest.with_types(input_type=session_types.session_input)
+        ):
+            pass
+
+        class StepRequest(
+            schema.PredictionRequest.with_types(input_type=session_types.step_input)
+        ):
+            pass
+
+        SessionResponse = schema.PredictionResponse.with_types(  # pylint: disable=invalid-name
+            input_type=session_types.session_input, output_type=Any
+        )
+        StepResponse = schema.PredictionResponse.with_types(  # pylint: disable=invalid-name
+            input_type=session_types.step_input,
+            output_type=session_types.step_output,
+        )
+
+        def _unknown_session(session_id: str) -> JSONResponse:
+            return JSONResponse(
+                {"detail": f"Unknown session {session_id}"}, status_code=404
+            )
+
+        @limited
+        @app.post(
+            "/sessions",
+            response_model=SessionResponse,
+            response_model_exclude_unset=True,
+        )
+        async def create_session(
+            request: SessionRequest = Body(default=None),
+        ) -> Any:
+            """
+            Open a session on a stateful model. The response's id is the session ID.
+            """
+            if request is None:
+                request = SessionRequest(input={})
+            session_id = request.id or uuid.uuid4().hex
+            if session_id in sessions:
+                return JSONResponse(
+                    {"detail": f"Session {session_id} already exists"},
+                    status_code=409,
+                )
+            request.id = session_id
+
+            if "create_session" not in session_types.methods:
+                sessions.add(session_id)
+                return JSONResponse(
+                    {"id": session_id, "status": schema.Status.SUCCEEDED}
+                )
+
+            sessions.add(session_id)
+            response = await _predict(
+                request=request,
+                response_type=SessionResponse,
+                method="create_session",
+                session_id=session_id,
+            )
+            if response.status_code != 200 or json.loads(response.body).get(
+                "status"
+            ) != schema.Status.SUCCEEDED:
+                sessions.discard(session_id)
+            return response
+
+        @limited
+        @app.post(
+            "/sessions/{session_id}/step",
+            response_model=StepResponse,
+            response_model_exclude_unset=True,
+        )
+        async def step(
+            session_id: str = Path(..., title="Session ID"),
+            request: StepRequest = Body(default=None),
+        ) -> Any:
+            """
+            Run a step of a session, which can use the state earlier steps left in the model
+            """
+            if session_id not in sessions:
+                return _unknown_session(session_id)
+            if request is None:
+                request = StepRequest(input={})
+            return await _predict(
+                request=request,
+                response_type=StepResponse,
+                method="step",
+                session_id=session_id,
+            )
+
+        @limited
+        @app.delete("/sessions/{session_id}")
+        async def close_session(
+            session_id: str = Path(..., title="Session ID"),
+        ) -> Any:
+            """
+            Close a session, so the model can free its state
+            """
+            if session_id not in sessions:
+                return _unknown_session(session_id)
+            sessions.discard(session_id)
+            if "close_session" not in session_types.methods:
+                return JSONResponse(
+                    {"id": session_id, "status": schema.Status.SUCCEEDED}
+                )
+            return await _predict(
+                request=schema.PredictionRequest(input={}),
+                response_type=schema.PredictionResponse,
+                method="close_session",
+                session_id=session_id,
+            )
+
+        index_document.update(
+            {
+                "sessions_url": "/sessions",
+                "sessions_step_url": "/sessions/{session_id}/step",
+            }
+        )
+
+    if tables:
+        app.add_middleware(TableRequestMiddleware, tables=tables)
+
     @app.on_event("startup")
     def startup() -> None:
+        sidecars.start()
+        if prediction_cache is not None:
+            # Cached predictions aren't used once the model's inputs or outputs change
+            prediction_cache.schema_version = schema_version(app.openapi())
+            prediction_cache.prune()
         # check for early setup failures
         if (
             app.state.setup_result
@@ -328,6 +549,7 @@ def create_app(  # pylint: disable=too-many-arguments,too-many-locals,too-many-s
     @app.on_event("shutdown")
     def shutdown() -> None:
         worker.terminate()
+        sidecars.stop()
 
     @app.get("/")
     async def root() -> Any:
@@ -336,20 +558,30 @@ def create_app(  # pylint: disable=too-many-arguments,too-many-locals,too-many-s
     @app.get("/health-check")
     async def healthcheck() -> Any:
         isReady = Health.READY
-        if app.state.health == isReady:
+        if app.state.draining:
+            health = Health.DRAINING
+        elif app.state.health == isReady:
             health = Health.BUSY if runner.is_busy() else Health.READY
         else:
             health = app.state.health
+        # The model isn't ready until its sidecars are
+        if health in (Health.READY, Health.BUSY) and not sidecars.healthy():
+            health = Health.UNHEALTHY if sidecars.ready() else Health.STARTING
 
         setup = app.state.setup_result.to_dict() if app.state.setup_result else {}
+        content = {"status": health.name, "setup": setup, "modified": True}
+        if sidecars.sidecars:
+            content["sidecars"] = sidecars.status()
+        if health == Health.DRAINING:
+            content["predictions_in_flight"] = runner.in_flight()
         if isReady:
             return JSONResponse(
-                jsonable_encoder({"status": health.name, "setup": setup, "modified": True}),
+                jsonable_encoder(content),
                 status_code=200,
             )
         else:
             return JSONResponse(
-                jsonable_encoder({"status": health.name, "setup": setup, "modified": True}),
+                jsonable_encoder(content),
                 status_code=503
             )
         
@@ -360,6 +592,7 @@ def create_app(  # pylint: disable=too-many-arguments,too-many-locals,too-many-s
         response_model_exclude_unset=True,
     )
     async def predict(
+        http_request: Request,
         request: PredictionRequest = Body(default=None),
         prefer: Optional[str] = Header(default=None),
         traceparent: Optional[str] = Header(default=None, include_in_schema=False),
@@ -376,6 +609,8 @@ def create_app(  # pylint: disable=too-many-arguments,too-many-locals,too-many-s
                 request=request,
                 response_type=PredictionResponse,
                 respond_async=respond_async,
+                cache_key=await _cache_key(http_request, respond_async),
+                array_type=negotiate_array_type(http_request.headers.get("accept")),
             )
 
     @limited
@@ -385,6 +620,7 @@ def create_app(  # pylint: disable=too-many-arguments,too-many-locals,too-many-s
         response_model_exclude_unset=True,
     )
     async def predict_idempotent(
+        http_request: Request,
         prediction_id: str = Path(..., title="Prediction ID"),
         request: PredictionRequest = Body(..., title="Prediction Request"),
         prefer: Optional[str] = Header(default=None),
@@ -424,13 +660,36 @@ def create_app(  # pylint: disable=too-many-arguments,too-many-locals,too-many-s
                 request=request,
                 response_type=PredictionResponse,
                 respond_async=respond_async,
+                cache_key=await _cache_key(http_request, respond_async),
+                array_type=negotiate_array_type(http_request.headers.get("accept")),
             )
 
-    async def _predict(
+    async def _cache_key(http_request: Request, respond_async: bool) -> Optional[str]:
+        # Only synchronous predictions are cached, because async ones send
+        # their results to webhooks
+        if prediction_cache is None or respond_async:
+            return None
+        # Cached outputs have arrays in the default format
+        if negotiate_array_type(http_request.headers.get("accept")) != NPY_MIME_TYPE:
+            return None
+        # The key is made from the input as it was sent, because files in the
+        # parsed input are downloaded to temporary paths
+        body = await http_request.body()
+        try:
+            raw_input = (json.loads(body) if body else {}).get("input") or {}
+        except (ValueError, AttributeError):
+            return None
+        return prediction_cache.key(raw_input)
+
+    async def _predict(  # pylint: disable=too-many-arguments
         *,
         request: Optional[PredictionRequest],
         response_type: Type[schema.PredictionResponse],
         respond_async: bool = False,
+        method: str = "predict",
+        session_id: Optional[str] = None,
+        cache_key: Optional[str] = None,
+        array_type: str = NPY_MIME_TYPE,
     ) -> Response:
         # [compat] If no body is supplied, assume that this model can be run
         # with empty input. This will throw a ValidationError if that's not
@@ -449,8 +708,23 @@ def create_app(  # pylint: disable=too-many-arguments,too-many-locals,too-many-s
             # backwards-compatible behaviour for synchronous predictions.
             task_kwargs["upload_url"] = upload_url
 
+        if app.state.draining:
+            return JSONResponse(
+                {"detail": "Shutting down, not accepting new predictions"},
+                status_code=503,
+            )
+
+        if prediction_cache is not None and cache_key is not None:
+            cached = prediction_cache.get(cache_key)
+            if cached is not None:
+                if request.id is not None:
+                    cached["id"] = request.id
+                return JSONResponse(content=cached, headers={"X-Cog-Cache": "hit"})
+
         try:
-            predict_task = runner.predict(request, task_kwargs=task_kwargs)
+            predict_task = runner.predict(
+                request, task_kwargs=task_kwargs, method=method, session_id=session_id
+            )
         except RunnerBusyError:
             return JSONResponse(
                 {"detail": "Already running a prediction"}, status_code=409
@@ -484,12 +758,18 @@ def create_app(  # pylint: disable=too-many-arguments,too-many-locals,too-many-s
             raise HTTPException(status_code=500, detail=str(e)) from e
 
         response_object["output"] = upload_files(
-            response_object["output"],
+            convert_arrays(response_object["output"], array_type),
             upload_file=lambda fh: upload_file(fh, request.output_file_prefix),  # type: ignore
         )
 
         # FIXME: clean up output files
         encoded_response = jsonable_encoder(response_object)
+        if prediction_cache is not None and cache_key is not None:
+            if encoded_response.get("status") == schema.Status.SUCCEEDED:
+                prediction_cache.put(cache_key, encoded_response)
+            return JSONResponse(
+                content=encoded_response, headers={"X-Cog-Cache": "miss"}
+            )
         return JSONResponse(content=encoded_response)
 
     @app.post("/predictions/{prediction_id}/cancel")
@@ -503,6 +783,70 @@ def create_app(  # pylint: disable=too-many-arguments,too-many-locals,too-many-s
             return JSONResponse({}, status_code=404)
         return JSONResponse({}, status_code=200)
 
+    @app.post("/uploads", status_code=201)
+    async def create_upload(
+        filename: str = Body(..., embed=True),
+        size: int = Body(..., ge=0, embed=True),
+    ) -> Any:
+        """
+        Start an upload of a large input file, which is sent in chunks
+        """
+        try:
+            status = uploads.create(filename, size)
+        except ValueError as e:
+            return JSONResponse({"detail": str(e)}, status_code=413)
+        return JSONResponse(
+            status,
+            status_code=201,
+            headers={"Location": f"/uploads/{status['id']}"},
+        )
+
+    @app.get("/uploads/{upload_id}")
+    async def get_upload(upload_id: str = Path(..., title="Upload ID")) -> Any:
+        """
+        Get how much of an upload has been received, to resume it
+        """
+        try:
+            return JSONResponse(uploads.status(upload_id))
+        except KeyError:
+            return JSONResponse({"detail": "Upload not found"}, status_code=404)
+
+    @app.patch("/uploads/{upload_id}")
+    async def append_upload(
+        http_request: Request,
+        upload_id: str = Path(..., title="Upload ID"),
+        upload_offset: int = Header(...),
+    ) -> Any:
+        """
+        Send the next chunk of an upload, starting at the Upload-Offset header
+        """
+        try:
+            status = await uploads.append(
+                upload_id, upload_offset, http_request.stream()
+            )
+        except KeyError:
+            return JSONResponse({"detail": "Upload not found"}, status_code=404)
+        except OffsetMismatchError as e:
+            return JSONResponse(
+                {"detail": str(e), "offset": e.offset}, status_code=409
+            )
+        except ValueError as e:
+            return JSONResponse({"detail": str(e)}, status_code=413)
+        if status["complete"]:
+            log.info("upload finished", upload_id=upload_id, size=status["size"])
+        return JSONResponse(status)
+
+    @app.delete("/uploads/{upload_id}")
+    async def delete_upload(upload_id: str = Path(..., title="Upload ID")) -> Any:
+        """
+        Remove an upload that won't be used
+        """
+        try:
+            uploads.delete(upload_id)
+        except KeyError:
+            return JSONResponse({"detail": "Upload not found"}, status_code=404)
+        return JSONResponse({}, status_code=200)
+
     def _handle_predict_done(response: schema.PredictionResponse) -> None:
         if response._fatal_exception:
             _maybe_shutdown(response._fatal_exception)
@@ -630,6 +974,13 @@ if __name__ == "__main__":
         default=False,
         help="Ignore SIGTERM and wait for a request to /shutdown (or a SIGINT) before exiting",
     )
+    parser.add_argument(
+        "--drain-timeout",
+        dest="drain_timeout",
+        type=float,
+        default=0,
+        help="On shutdown, stop accepting predictions and wait this many seconds for running ones to finish",
+    )
     parser.add_argument(
         "--x-mode",
         dest="mode",
@@ -638,6 +989,20 @@ if __name__ == "__main__":
         choices=list(Mode),
         help="Experimental: Run in 'predict' or 'train' mode",
     )
+    parser.add_argument(
+        "--cache-dir",
+        dest="cache_dir",
+        type=str,
+        default=os.environ.get("COG_PREDICTION_CACHE_DIR"),
+        help="Cache the responses of successful predictions in this directory, and return them for predictions with the same input",
+    )
+    parser.add_argument(
+        "--cache-ttl",
+        dest="cache_ttl",
+        type=float,
+        default=float(os.environ.get("COG_PREDICTION_CACHE_TTL", DEFAULT_TTL)),
+        help="How long cached predictions are used for, in seconds. 0 means forever.",
+    )
     args = parser.parse_args()
 
     if args.version:
@@ -666,6 +1031,9 @@ if __name__ == "__main__":
         upload_url=args.upload_url,
         mode=args.mode,
         await_explicit_shutdown=await_explicit_shutdown,
+        drain_timeout=args.drain_timeout,
+        cache_dir=args.cache_dir,
+        cache_ttl=args.cache_ttl,
     )
 
     host: str = args.host
@@ -690,7 +1058,12 @@ if __name__ == "__main__":
     try:
         shutdown_event.wait()
     except KeyboardInterrupt:
-        pass
+        if args.drain_timeout > 0:
+            log.info("draining before shutdown, interrupt again to stop now")
+            try:
+                app.state.drain(args.drain_timeout)
+            except KeyboardInterrupt:
+                pass
 
     s.stop()
 
@@ -18,6 +18,7 @@ from .. import schema
 from ..base_input import BaseInput
 from ..files import put_file_to_signed_endpoint
 from ..json import upload_files
+from ..startup import SETUP_PHASE_METRIC_PREFIX
 from ..types import PYDANTIC_V2
 from .errors import FileUploadError, RunnerBusyError, UnknownPredictionError
 from .eventtypes import (
@@ -44,6 +45,8 @@ class SetupResult:
     completed_at: Optional[datetime] = None
     logs: List[str] = field(factory=list)
     status: Optional[Literal[schema.Status.FAILED, schema.Status.SUCCEEDED]] = None
+    # How long each phase of setup took, in seconds, in the order they finished
+    phases: Dict[str, float] = field(factory=dict)
 
     def to_dict(self) -> Dict[str, Any]:
         return {
@@ -51,6 +54,7 @@ class SetupResult:
             "completed_at": self.completed_at,
             "logs": "".join(self.logs),
             "status": self.status,
+            "phases": self.phases,
         }
 
 
@@ -88,6 +92,8 @@ class PredictionRunner:
         self,
         prediction: schema.PredictionRequest,
         task_kwargs: Optional[Dict[str, Any]] = None,
+        method: str = "predict",
+        session_id: Optional[str] = None,
     ) -> "PredictTask":
         self._raise_if_busy()
 
@@ -111,9 +117,11 @@ class PredictionRunner:
                 payload = prediction.input.dict()
         else:
             payload = prediction.input.copy()
+        if session_id is not None:
+            payload["session_id"] = session_id
 
         sid = self._worker.subscribe(task.handle_event, tag=tag)
-        task.track(self._worker.predict(payload, tag=tag))
+        task.track(self._worker.predict(payload, tag=tag, method=method))
         task.add_done_callback(self._task_done_callback(tag, sid))
 
         return task
@@ -137,6 +145,10 @@ class PredictionRunner:
             return True
         return False
 
+    def in_flight(self) -> int:
+        with self._predict_tasks_lock:
+            return len([t for t in self._predict_tasks.values() if not t.done()])
+
     def cancel(self, prediction_id: str) -> None:
         if not prediction_id:
             raise ValueError("prediction_id is required")
@@ -229,7 +241,7 @@ class SetupTask(Task[SetupResult]):
         self._result.logs.append(message)
 
     def succeeded(self) -> None:
-        log.info("setup succeeded")
+        log.info("setup succeeded", phases=self._result.phases)
         assert self._clock
         self._result.completed_at = self._clock()
         self._result.status = schema.Status.SUCCEEDED
@@ -248,6 +260,11 @@ class SetupTask(Task[SetupResult]):
                 self.failed()
             else:
                 self.succeeded()
+        elif isinstance(event, PredictionMetric) and event.name.startswith(
+            SETUP_PHASE_METRIC_PREFIX
+        ):
+            name = event.name[len(SETUP_PHASE_METRIC_PREFIX) :]
+            self._result.phases[name] = float(event.value)
         else:
             log.warn("received unexpected event during setup", data=event)
 
@@ -0,0 +1,190 @@
+"""
+Supervises the sidecars in cog.yaml: long-running processes, like a tokenizer
+server, that run alongside the predictor. They're started when the server
+starts, restarted when they exit, and their output is written to the server's
+output with each line prefixed by the sidecar's name.
+"""
+
+import os
+import signal
+import subprocess
+import sys
+import threading
+import time
+import urllib.request
+from typing import Any, Dict, List, Optional
+
+import structlog
+
+log = structlog.get_logger("cog.server.sidecars")
+
+RESTART_ALWAYS = "always"
+RESTART_ON_FAILURE = "on-failure"
+RESTART_NEVER = "never"
+
+HEALTH_CHECK_INTERVAL = 5.0
+HEALTH_CHECK_TIMEOUT = 2.0
+MAX_RESTART_DELAY = 30.0
+STOP_TIMEOUT = 5.0
+
+_output_lock = threading.Lock()
+
+
+def _write_output(name: str, line: bytes) -> None:
+    with _output_lock:
+        sys.stdout.write(f"[{name}] {line.decode('utf-8', errors='replace')}")
+        if not line.endswith(b"\n"):
+            sys.stdout.write("\n")
+        sys.stdout.flush()
+
+
+class Sidecar:
+    def __init__(self, config: Dict[str, Any]) -> None:
+        self.name: str = config["name"]
+        self.command: str = config["command"]
+        self.health_check: Optional[str] = config.get("health_check")
+        self.restart: str = config.get("restart") or RESTART_ALWAYS
+
+        self.restarts = 0
+        self.exit_code: Optional[int] = None
+        # Whether the sidecar has passed its health check, or has started if it doesn't have one
+        self.healthy = False
+        # Whether the sidecar has been healthy since the server started
+        self.ready = False
+
+        self._process: Optional["subprocess.Popen[bytes]"] = None
+        self._stopping = threading.Event()
+        self._thread = threading.Thread(
+            target=self._supervise, name=f"sidecar-{self.name}", daemon=True
+        )
+
+    def start(self) -> None:
+        self._thread.start()
+
+    def stop(self) -> None:
+        self._stopping.set()
+        process = self._process
+        if process is None or process.poll() is not None:
+            return
+        try:
+            os.killpg(process.pid, signal.SIGTERM)
+            process.wait(timeout=STOP_TIMEOUT)
+        except subprocess.TimeoutExpired:
+            log.warn("sidecar didn't stop, killing it", sidecar=self.name)
+            os.killpg(process.pid, signal.SIGKILL)
+        except ProcessLookupError:
+            pass
+
+    def status(self) -> Dict[str, Any]:
+        process = self._process
+        if process is not None and process.poll() is None:
+            state = "running"
+        elif self._process is None:
+            state = "starting"
+        else:
+            state = "exited"
+        status: Dict[str, Any] = {
+            "status": state,
+            "healthy": self.healthy,
+            "restarts": self.restarts,
+        }
+        if state == "exited":
+            status["exit_code"] = self.exit_code
+        return status
+
+    def _supervise(self) -> None:
+        delay = 1.0
+        while not self._stopping.is_set():
+            started_at = time.monotonic()
+            self._run()
+            if self._stopping.is_set():
+                return
+            if self.restart == RESTART_NEVER or (
+                self.restart == RESTART_ON_FAILURE and self.exit_code == 0
+            ):
+                log.info("sidecar exited", sidecar=self.name, exit_code=self.exit_code)
+                return
+            # Back off when the sidecar keeps exiting straight away
+            if time.monotonic() - started_at > MAX_RESTART_DELAY:
+                delay = 1.0
+            log.warn(
+                "sidecar exited, restarting it",
+                sidecar=self.name,
+                exit_code=self.exit_code,
+                delay=delay,
+            )
+            if self._stopping.wait(delay):
+                return
+            delay = min(delay * 2, MAX_RESTART_DELAY)
+            self.restarts += 1
+
+    def _run(self) -> None:
+        log.info("starting sidecar", sidecar=self.name, command=self.command)
+        self.healthy = False
+        try:
+            self._process = subprocess.Popen(  # noqa: S602 # pylint: disable=consider-using-with
+                ["/bin/sh", "-c", self.command],
+                stdout=subprocess.PIPE,
+                stderr=subprocess.STDOUT,
+                start_new_session=True,
+            )
+        except OSError as e:
+            log.error("failed to start sidecar", sidecar=self.name, error=str(e))
+            self.exit_code = -1
+            return
+
+        process = self._process
+        output = threading.Thread(target=self._forward_output, args=(process,), daemon=True)
+        output.start()
+        if self.health_check is None:
+            self.healthy = self.ready = True
+            process.wait()
+        else:
+            while process.poll() is None:
+                self.healthy = self._check_health()
+                self.ready = self.ready or self.healthy
+                # Check often until it's healthy, so the model is ready as soon as it can be
+                try:
+                    process.wait(timeout=HEALTH_CHECK_INTERVAL if self.healthy else 1.0)
+                except subprocess.TimeoutExpired:
+                    pass
+        self.healthy = False
+        self.exit_code = process.returncode
+        output.join(timeout=1)
+
+    def _forward_output(self, process: "subprocess.Popen[bytes]") -> None:
+        assert process.stdout is not None
+        for line in iter(process.stdout.readline, b""):
+            _write_output(self.name, line)
+
+    def _check_health(self) -> bool:
+        assert self.health_check is not None
+        try:
+            with urllib.request.urlopen(  # noqa: S310
+                self.health_check, timeout=HEALTH_CHECK_TIMEOUT
+            ) as response:
+                return 200 <= response.status < 300
+        except Exception:  # pylint: disable=broad-exception-caught
+            return False
+
+
+class Sidecars:
+    def __init__(self, configs: List[Dict[str, Any]]) -> None:
+        self.sidecars = [Sidecar(c) for c in configs]
+
+    def start(self) -> None:
+        for sidecar in self.sidecars:
+            sidecar.start()
+
+    def stop(self) -> None:
+        for sidecar in self.sidecars:
+            sidecar.stop()
+
+    def healthy(self) -> bool:
+        return all(sidecar.healthy for sidecar in self.sidecars)
+
+    def ready(self) -> bool:
+        return all(sidecar.ready for sidecar in self.sidecars)
+
+    def status(self) -> Dict[str, Dict[str, Any]]:
+        return {sidecar.name: sidecar.status() for sidecar in self.sidecars}
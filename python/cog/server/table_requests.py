@@ -0,0 +1,115 @@
+import base64
+import json
+import urllib.parse
+from typing import Any, Awaitable, Callable, Dict, List, MutableMapping
+
+from ..tables import TABLE_MIME_TYPES
+
+Scope = MutableMapping[str, Any]
+Message = MutableMapping[str, Any]
+Receive = Callable[[], Awaitable[Message]]
+Send = Callable[[Message], Awaitable[None]]
+ASGIApp = Callable[[Scope, Receive, Send], Awaitable[None]]
+
+
+class TableRequestMiddleware:
+    """
+    Turns prediction requests whose body is an Arrow or Parquet table into
+    JSON requests, with the table as the model's Table input and the other
+    inputs from the query string, like:
+
+        POST /predictions?threshold=0.5
+        Content-Type: application/vnd.apache.parquet
+
+    tables maps an endpoint, like "predictions", to the name of its Table input.
+    """
+
+    def __init__(self, app: ASGIApp, tables: Dict[str, str]) -> None:
+        self.app = app
+        self.tables = tables
+
+    async def __call__(self, scope: Scope, receive: Receive, send: Send) -> None:
+        if scope["type"] != "http" or scope["method"] not in ("POST", "PUT"):
+            await self.app(scope, receive, send)
+            return
+        headers = dict(scope["headers"])
+        content_type = headers.get(b"content-type", b"").decode("latin-1")
+        content_type = content_type.split(";")[0].strip().lower()
+        parts = scope["path"].strip("/").split("/")
+        if (
+            content_type not in TABLE_MIME_TYPES
+            or parts[0] not in ("predictions", "trainings")
+            or len(parts) > 2
+        ):
+            await self.app(scope, receive, send)
+            return
+
+        field = self.tables.get(parts[0])
+        if field is None:
+            await _send_json(
+                send,
+                415,
+                {"detail": f"/{parts[0]} doesn't accept {content_type} request bodies"},
+            )
+            return
+
+        body = bytearray()
+        while True:
+            message = await receive()
+            if message["type"] == "http.disconnect":
+                return
+            body.extend(message.get("body", b""))
+            if not message.get("more_body", False):
+                break
+
+        query = urllib.parse.parse_qs(
+            scope["query_string"].decode("latin-1"), keep_blank_values=True
+        )
+        inputs: Dict[str, Any] = {
+            key: values[0] if len(values) == 1 else values
+            for key, values in query.items()
+        }
+        encoded = base64.b64encode(bytes(body)).decode("ascii")
+        inputs[field] = f"data:{content_type};base64,{encoded}"
+        new_body = json.dumps({"input": inputs}).encode("utf-8")
+
+        headers[b"content-type"] = b"application/json"
+        headers[b"content-length"] = str(len(new_body)).encode("latin-1")
+        scope = dict(scope)
+        scope["headers"] = list(headers.items())
+        scope["query_string"] = b""
+
+        sent = False
+
+        async def receive_json() -> Message:
+            nonlocal sent
+            if sent:
+                return await receive()
+            sent = True
+            return {"type": "http.request", "body": new_body, "more_body": False}
+
+        await self.app(scope, receive_json, send)
+
+
+async def _send_json(send: Send, status: int, content: Any) -> None:
+    body = json.dumps(content).encode("utf-8")
+    headers: List[Any] = [
+        (b"content-type", b"application/json"),
+        (b"content-length", str(len(body)).encode("latin-1")),
+    ]
+    await send({"type": "http.response.start", "status": status, "headers": headers})
+    await send({"type": "http.response.body", "body": body})
+
+
+def add_table_request_bodies(openapi_schema: Dict[str, Any], tables: Dict[str, str]) -> None:
+    """Adds the table MIME types to the request bodies of the endpoints in tables."""
+    for path, item in openapi_schema.get("paths", {}).items():
+        parts = path.strip("/").split("/")
+        if parts[0] not in tables or len(parts) > 2:
+            continue
+        for operation in item.values():
+            content = operation.get("requestBody", {}).get("content")
+            if content is None:
+                continue
+            for mime_type in TABLE_MIME_TYPES:
+                content[mime_type] = {"schema": {"type": "string", "format": "binary"}}
@@ -36,6 +36,7 @@ from ..predictor import (
     has_setup_weights,
     load_predictor_from_ref,
 )
+from ..startup import setup_phase
 from ..types import PYDANTIC_V2, URLPath
 from ..wait import wait_for_env
 from .connection import AsyncConnection, LockedConnection
@@ -134,7 +135,10 @@ class Worker:
         return self._setup_result
 
     def predict(
-        self, payload: Dict[str, Any], tag: Optional[str] = None
+        self,
+        payload: Dict[str, Any],
+        tag: Optional[str] = None,
+        method: str = "predict",
     ) -> "Future[Done]":
         # TODO: tag is Optional, but it's required when in concurrent mode and
         # basically unnecessary in sequential mode. Should we have a separate
@@ -157,11 +161,13 @@ class Worker:
             result = Future()
             self._predictions_in_flight[tag] = PredictionState(tag, payload, result)
 
-        self._prediction_start_pool.submit(self._start_prediction(tag, payload))
+        self._prediction_start_pool.submit(
+            self._start_prediction(tag, payload, method)
+        )
         return result
 
     def _start_prediction(
-        self, tag: Optional[str], payload: Dict[str, Any]
+        self, tag: Optional[str], payload: Dict[str, Any], method: str
     ) -> Callable[[], None]:
         def start_prediction() -> None:
             try:
@@ -212,7 +218,7 @@ class Worker:
                 # send the prediction to the child to start
                 self._events.send(
                     Envelope(
-                        event=PredictionInput(payload=payload),
+                        event=PredictionInput(payload=payload, method=method),
                         tag=tag,
                     )
                 )
@@ -431,7 +437,8 @@ class _ChildWorker(_spawn.Process):  # type: ignore
         with scope(Scope(record_metric=self.record_metric)), redirector:
             with self._handle_setup_error(redirector):
                 wait_for_env()
-                self._predictor = load_predictor_from_ref(self._predictor_ref)
+                with setup_phase("import"):
+                    self._predictor = load_predictor_from_ref(self._predictor_ref)
 
             # If load_predictor_from_ref hasn't returned a predictor instance then
             # it has sent a error Done event and we're done here.
@@ -525,12 +532,13 @@ class _ChildWorker(_spawn.Process):  # type: ignore
             if not hasattr(self._predictor, "setup"):
                 return
 
-            if not has_setup_weights(self._predictor):
-                self._predictor.setup()
-                return
+            with setup_phase("setup"):
+                if not has_setup_weights(self._predictor):
+                    self._predictor.setup()
+                    return
 
-            weights = extract_setup_weights(self._predictor)
-            self._predictor.setup(weights=weights)  # type: ignore
+                weights = extract_setup_weights(self._predictor)
+                self._predictor.setup(weights=weights)  # type: ignore
 
     async def _asetup(
         self, redirector: Union[StreamRedirector, SimpleStreamRedirector]
@@ -542,12 +550,13 @@ class _ChildWorker(_spawn.Process):  # type: ignore
             if not hasattr(self._predictor, "setup"):
                 return
 
-            if not has_setup_weights(self._predictor):
-                await self._predictor.setup()  # type: ignore
-                return
+            with setup_phase("setup"):
+                if not has_setup_weights(self._predictor):
+                    await self._predictor.setup()  # type: ignore
+                    return
 
-            weights = extract_setup_weights(self._predictor)
-            await self._predictor.setup(weights=weights)  # type: ignore
+                weights = extract_setup_weights(self._predictor)
+                await self._predictor.setup(weights=weights)  # type: ignore
 
     def _loop(
         self,
@@ -563,7 +572,12 @@ class _ChildWorker(_spawn.Process):  # type: ignore
             elif isinstance(e.event, Shutdown):
                 break
             elif isinstance(e.event, PredictionInput):
-                self._predict(e.tag, e.event.payload, predict, redirector)
+                self._predict(
+                    e.tag,
+                    e.event.payload,
+                    self._method(e.event.method, predict),
+                    redirector,
+                )
             else:
                 print(f"Got unexpected event: {e.event}", file=sys.stderr)
 
@@ -597,11 +611,22 @@ class _ChildWorker(_spawn.Process):  # type: ignore
                     break
                 elif isinstance(e.event, PredictionInput):
                     tasks[e.tag] = tg.create_task(
-                        self._apredict(e.tag, e.event.payload, predict, redirector)
+                        self._apredict(
+                            e.tag,
+                            e.event.payload,
+                            self._method(e.event.method, predict),
+                            redirector,
+                        )
                     )
                 else:
                     print(f"Got unexpected event: {e.event}", file=sys.stderr)
 
+    def _method(self, name: str, predict: Callable[..., Any]) -> Callable[..., Any]:
+        if name == "predict":
+            return predict
+        # Session methods of stateful predictors, like step()
+        return getattr(self._predictor, name)
+
     def _predict(
         self,
         tag: Optional[str],
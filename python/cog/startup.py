@@ -0,0 +1,75 @@
+"""
+Helpers for setup() in models that take a long time to start: timing the
+phases of setup, loading files in parallel, and the device map to load models
+with. They're configured by `setup` in cog.yaml.
+"""
+
+import time
+from concurrent.futures import Executor, ProcessPoolExecutor, ThreadPoolExecutor
+from contextlib import contextmanager
+from typing import Callable, Iterable, Iterator, List, Optional, TypeVar
+
+import structlog
+
+from .config import SETUP_STRATEGY_PROCESS, Config
+from .server.scope import _get_current_scope
+
+log = structlog.get_logger("cog.startup")
+
+# Setup phases are sent to Cog's server as metrics with this prefix
+SETUP_PHASE_METRIC_PREFIX = "setup_phase:"
+
+T = TypeVar("T")
+R = TypeVar("R")
+
+
+def record_setup_phase(name: str, seconds: float) -> None:
+    log.info("setup phase finished", phase=name, seconds=round(seconds, 3))
+    try:
+        scope = _get_current_scope()
+    except RuntimeError:
+        # Not running in Cog's server, like in a test
+        return
+    scope.record_metric(SETUP_PHASE_METRIC_PREFIX + name, seconds)
+
+
+@contextmanager
+def setup_phase(name: str) -> Iterator[None]:
+    """
+    Times a phase of setup(), like loading weights, so it's in the breakdown of
+    how long setup took that's reported when the model starts:
+
+        with setup_phase("load weights"):
+            self.model = load(...)
+    """
+    started = time.perf_counter()
+    try:
+        yield
+    finally:
+        record_setup_phase(name, time.perf_counter() - started)
+
+
+def _executor() -> Executor:
+    config = Config()
+    if config.setup_strategy == SETUP_STRATEGY_PROCESS:
+        return ProcessPoolExecutor(max_workers=config.setup_threads)
+    return ThreadPoolExecutor(max_workers=config.setup_threads)
+
+
+def parallel_load(fn: Callable[[T], R], items: Iterable[T]) -> List[R]:
+    """
+    Calls fn on each item in parallel, like loading the shards of a model's
+    weights, and returns the results in order. The number of workers, and
+    whether they're threads or processes, are `setup.threads` and
+    `setup.strategy` in cog.yaml.
+    """
+    with _executor() as executor:
+        return list(executor.map(fn, items))
+
+
+def device_map() -> Optional[str]:
+    """
+    The device map to load models with, like "auto", from `setup.device_map`
+    in cog.yaml, or None if it isn't set.
+    """
+    return Config().setup_device_map
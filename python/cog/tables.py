@@ -0,0 +1,144 @@
+"""
+Table is an input and output type for tabular data, like a batch of rows to
+run a model on, in an Arrow or Parquet file rather than a JSON list of
+objects, which is several times bigger and slow to encode and decode.
+
+Clients can send a model's Table input as the body of a prediction request,
+with a Content-Type of application/vnd.apache.arrow.file or
+application/vnd.apache.parquet, rather than in a JSON body.
+"""
+
+import pathlib
+import tempfile
+from typing import Any, Dict, Optional, Type
+
+import pydantic
+
+from .arrays import ARROW_MIME_TYPE
+from .types import PYDANTIC_V2, File, Path, URLPath, get_filename
+from .uploads import is_upload, resolve_upload
+
+PARQUET_MIME_TYPE = "application/vnd.apache.parquet"
+ARROW_STREAM_MIME_TYPE = "application/vnd.apache.arrow.stream"
+
+# The MIME types of the request bodies that are sent to a Table input
+TABLE_MIME_TYPES = (ARROW_MIME_TYPE, ARROW_STREAM_MIME_TYPE, PARQUET_MIME_TYPE)
+
+# The schema key that marks a Table input or output
+TABLE_SCHEMA_KEY = "x-cog-table"
+
+
+class Table(Path):  # pylint: disable=abstract-method
+    """
+    A table of rows, in an Arrow or Parquet file:
+
+        def predict(self, rows: Table) -> Table:
+            table = rows.read()
+            scores = self.model.score(table.to_pandas())
+            return Table.save({"score": scores})
+
+    Reading and saving tables needs pyarrow.
+    """
+
+    @classmethod
+    def validate(cls, value: Any) -> Any:
+        if isinstance(value, pathlib.Path):
+            return value
+        if is_upload(value):
+            path, _ = resolve_upload(value)
+            return Table(path)
+        return URLPath(
+            source=value,
+            filename=get_filename(value),
+            fileobj=File.validate(value),
+            path_type=Table,
+        )
+
+    def read(self) -> Any:
+        """Reads the table, from an Arrow file or stream or a Parquet file, to a pyarrow.Table."""
+        import pyarrow as pa  # type: ignore # pylint: disable=import-outside-toplevel
+
+        with open(self, "rb") as f:
+            magic = f.read(6)
+        if magic[:4] == b"PAR1":
+            import pyarrow.parquet as pq  # type: ignore # pylint: disable=import-outside-toplevel
+
+            return pq.read_table(str(self))
+        with pa.memory_map(str(self)) as source:
+            if magic == b"ARROW1":
+                return pa.ipc.open_file(source).read_all()
+            return pa.ipc.open_stream(source).read_all()
+
+    def rows(self) -> Any:
+        """Reads the table to a list of dicts, one for each row."""
+        return self.read().to_pylist()
+
+    @classmethod
+    def save(cls, data: Any, format: str = "arrow") -> "Table":  # pylint: disable=redefined-builtin
+        """
+        Saves data to an Arrow or Parquet file. data is a pyarrow.Table, a
+        pandas DataFrame, a dict of columns, or a list of dicts, one for each row.
+        """
+        # pylint: disable=import-outside-toplevel
+        import pyarrow as pa  # type: ignore
+
+        if format not in ("arrow", "parquet"):
+            raise ValueError(f"Unknown table format {format!r}, use 'arrow' or 'parquet'")
+
+        if isinstance(data, pa.Table):
+            table = data
+        elif isinstance(data, dict):
+            table = pa.table(data)
+        elif isinstance(data, list):
+            table = pa.Table.from_pylist(data)
+        else:
+            table = pa.Table.from_pandas(data, preserve_index=False)
+
+        with tempfile.NamedTemporaryFile(suffix=f".{format}", delete=False) as f:
+            if format == "parquet":
+                import pyarrow.parquet as pq  # type: ignore
+
+                pq.write_table(table, f)
+            else:
+                with pa.ipc.new_file(f, table.schema) as writer:
+                    writer.write_table(table)
+        return Table(f.name)
+
+    if PYDANTIC_V2:
+        from pydantic.json_schema import JsonSchemaValue
+        from pydantic_core import CoreSchema
+
+        @classmethod
+        def __get_pydantic_json_schema__(
+            cls, core_schema: "CoreSchema", handler: "pydantic.GetJsonSchemaHandler"
+        ) -> "JsonSchemaValue":
+            json_schema = handler(core_schema)
+            json_schema.update(type="string", format="uri", **{TABLE_SCHEMA_KEY: {}})
+            return json_schema
+
+    else:
+
+        @classmethod
+        def __modify_schema__(cls, field_schema: Dict[str, Any]) -> None:
+            field_schema.update(type="string", format="uri", **{TABLE_SCHEMA_KEY: {}})
+
+
+def table_input(input_type: Type[pydantic.BaseModel]) -> Optional[str]:
+    """
+    Returns the name of the Table input in input_type, which a table sent as
+    a request body is passed to, or None if it doesn't have exactly one.
+    """
+    if PYDANTIC_V2:
+        annotations = {
+            name: field.annotation for name, field in input_type.model_fields.items()
+        }
+    else:
+        annotations = {
+            name: field.outer_type_ for name, field in input_type.__fields__.items()
+        }
+    names = [
+        name
+        for name, annotation in annotations.items()
+        if isinstance(annotation, type) and issubclass(annotation, Table)
+    ]
+    return names[0] if len(names) == 1 else None
@@ -24,6 +24,8 @@ import pydantic
 import requests
 from typing_extensions import NotRequired  # added to typing in python 3.11
 
+from .uploads import UPLOAD_SCHEME, is_upload, resolve_upload
+
 if pydantic.__version__.startswith("1."):
     PYDANTIC_V2 = False
 else:
@@ -37,6 +39,12 @@ FILENAME_ILLEGAL_CHARS = set("\u0000/")
 # tempfile.NamedTemporaryFile, etc.
 FILENAME_MAX_LENGTH = 200
 
+# Schema keys for the limits on file inputs, set with Input(max_size=...,
+# mime_types=...). The server rejects files that break them before predict()
+# runs, and `cog predict` checks them before it sends the files.
+MAX_SIZE_SCHEMA_KEY = "x-cog-max-size"
+MIME_TYPES_SCHEMA_KEY = "x-cog-mime-types"
+
 
 class ExperimentalFeatureWarning(Warning):
     pass
@@ -73,8 +81,15 @@ def Input(  # pylint: disable=invalid-name, too-many-arguments
     max_length: Optional[int] = None,
     regex: Optional[str] = None,
     choices: Optional[List[Union[str, int]]] = None,
+    max_size: Optional[int] = None,
+    mime_types: Optional[List[str]] = None,
 ) -> Any:
-    """Input is similar to pydantic.Field, but doesn't require a default value to be the first argument."""
+    """
+    Input is similar to pydantic.Field, but doesn't require a default value to be the first argument.
+
+    max_size is the largest file a File or Path input accepts, in bytes, and
+    mime_types are the types of file it accepts, like "image/png" or "image/*".
+    """
     field_kwargs = {
         "default": default,
         "description": description,
@@ -83,6 +98,11 @@ def Input(  # pylint: disable=invalid-name, too-many-arguments
         "min_length": min_length,
         "max_length": max_length,
     }
+    extra: Dict[str, Any] = {}
+    if max_size is not None:
+        extra[MAX_SIZE_SCHEMA_KEY] = max_size
+    if mime_types:
+        extra[MIME_TYPES_SCHEMA_KEY] = list(mime_types)
 
     if PYDANTIC_V2:
         field_kwargs["pattern"] = regex
@@ -90,10 +110,13 @@ def Input(  # pylint: disable=invalid-name, too-many-arguments
             # The `choices` parameter is deprecated in Pydantic v2.
             # Instead, the user should use `Literal[...]`
             # to specify the allowed values.
-            field_kwargs["json_schema_extra"] = {"enum": choices}
+            extra["enum"] = choices
+        if extra:
+            field_kwargs["json_schema_extra"] = extra
     else:
         field_kwargs["regex"] = regex
         field_kwargs["enum"] = choices
+        field_kwargs.update(extra)
     return pydantic.Field(**field_kwargs)
 
 
@@ -146,8 +169,11 @@ class File(io.IOBase):
                 return io.BytesIO(res.read())
         if parsed_url.scheme in ("http", "https"):
             return URLFile(value)
+        if parsed_url.scheme == UPLOAD_SCHEME:
+            path, _ = resolve_upload(value)
+            return open(path, "rb")  # pylint: disable=consider-using-with
         raise ValueError(
-            f"'{parsed_url.scheme}' is not a valid URL scheme. 'data', 'http', or 'https' is supported."
+            f"'{parsed_url.scheme}' is not a valid URL scheme. 'data', 'http', 'https', or 'upload' is supported."
         )
 
     if PYDANTIC_V2:
@@ -199,6 +225,11 @@ class Path(pathlib.PosixPath):  # pylint: disable=abstract-method
     def validate(cls, value: Any) -> pathlib.Path:
         if isinstance(value, pathlib.Path):
             return value
+        # Uploads are already on disk, so they're used where they are rather
+        # than copied, and removed when the prediction finishes
+        if is_upload(value):
+            path, _ = resolve_upload(value)
+            return cls(path)
 
         return URLPath(
             source=value,
@@ -260,13 +291,22 @@ class URLPath(pathlib.PosixPath):  # pylint: disable=abstract-method
 
     _path: Optional[Path]
 
-    def __init__(self, *, source: str, filename: str, fileobj: io.IOBase) -> None:  # pylint: disable=super-init-not-called
+    def __init__(  # pylint: disable=super-init-not-called
+        self,
+        *,
+        source: str,
+        filename: str,
+        fileobj: io.IOBase,
+        path_type: Type["Path"] = Path,
+    ) -> None:
         if len(filename) > FILENAME_MAX_LENGTH:
             filename = _truncate_filename_bytes(filename, FILENAME_MAX_LENGTH)
 
         self.source = source
         self.filename = filename
         self.fileobj = fileobj
+        # The type of path it's converted to, like cog.Table
+        self.path_type = path_type
 
         self._path = None
 
@@ -274,7 +314,7 @@ class URLPath(pathlib.PosixPath):  # pylint: disable=abstract-method
         if self._path is None:
             dest = tempfile.NamedTemporaryFile(suffix=self.filename, delete=False)  # pylint: disable=consider-using-with
             shutil.copyfileobj(self.fileobj, dest)
-            self._path = Path(dest.name)
+            self._path = self.path_type(dest.name)
         return self._path
 
     def unlink(self, missing_ok: bool = False) -> None:
@@ -0,0 +1,188 @@
+"""
+Uploads are files streamed to the model's server in chunks before a
+prediction is created, rather than sent in the prediction's request, so large
+inputs like videos aren't held in memory, and an upload that's interrupted can
+be resumed from where it got to.
+
+A prediction's input refers to a finished upload with its URL, like
+upload:0123456789abcdef0123456789abcdef.
+"""
+
+import asyncio
+import json
+import os
+import re
+import shutil
+import tempfile
+import time
+import uuid
+from pathlib import Path
+from typing import Any, AsyncIterator, Dict, Optional, Tuple
+
+UPLOAD_SCHEME = "upload"
+
+# Uploads that haven't been used are removed after a day
+DEFAULT_TTL = 86400
+
+_ID_PATTERN = re.compile(r"^[0-9a-f]{32}$")
+_METADATA = ".upload.json"
+
+
+def upload_dir() -> str:
+    """The directory uploads are kept in, set with COG_UPLOAD_DIR."""
+    return os.environ.get("COG_UPLOAD_DIR") or os.path.join(
+        tempfile.gettempdir(), "cog-uploads"
+    )
+
+
+def is_upload(value: Any) -> bool:
+    return isinstance(value, str) and value.startswith(UPLOAD_SCHEME + ":")
+
+
+class OffsetMismatchError(Exception):
+    """A chunk was sent for a different offset than the upload has got to."""
+
+    def __init__(self, offset: int) -> None:
+        super().__init__(f"upload is at offset {offset}")
+        self.offset = offset
+
+
+class Uploads:
+    def __init__(
+        self, directory: str, ttl: float = DEFAULT_TTL, max_size: Optional[int] = None
+    ) -> None:
+        self.directory = Path(directory)
+        self.ttl = ttl
+        # The largest file the model's inputs accept, or None if they accept
+        # files of any size
+        self.max_size = max_size
+        # Chunks for an upload are written one request at a time, so two
+        # requests for the same offset can't both be appended
+        self._locks: Dict[str, asyncio.Lock] = {}
+
+    def create(self, filename: str, size: int) -> Dict[str, Any]:
+        """
+        Starts an upload of a file of size bytes, and returns its status.
+        Raises ValueError if it's larger than the model's inputs accept, or
+        than there's space for.
+        """
+        if size < 0:
+            raise ValueError("size must be at least 0")
+        if self.max_size is not None and size > self.max_size:
+            raise ValueError(
+                f"file is {size} bytes, larger than the maximum of {self.max_size} bytes"
+            )
+        self.prune()
+        self.directory.mkdir(parents=True, exist_ok=True)
+        free = shutil.disk_usage(self.directory).free
+        if size > free:
+            raise ValueError(
+                f"file is {size} bytes, larger than the {free} bytes of free space for uploads"
+            )
+        upload_id = uuid.uuid4().hex
+        path = self.directory / upload_id
+        path.mkdir(parents=True)
+        metadata = {"filename": _safe_filename(filename), "size": size}
+        (path / _METADATA).write_text(json.dumps(metadata))
+        (path / metadata["filename"]).touch()
+        return self.status(upload_id)
+
+    def status(self, upload_id: str) -> Dict[str, Any]:
+        """Returns how much of the upload has been received. Raises KeyError if there isn't one with upload_id."""
+        metadata, path = self._load(upload_id)
+        try:
+            offset = path.stat().st_size
+        except FileNotFoundError as e:
+            # It was removed since its metadata was read
+            raise KeyError(upload_id) from e
+        return {
+            "id": upload_id,
+            "url": f"{UPLOAD_SCHEME}:{upload_id}",
+            "filename": metadata["filename"],
+            "offset": offset,
+            "size": metadata["size"],
+            "complete": offset == metadata["size"],
+        }
+
+    async def append(
+        self, upload_id: str, offset: int, chunks: AsyncIterator[bytes]
+    ) -> Dict[str, Any]:
+        """
+        Writes chunks to the upload at offset, and returns its status. Raises
+        OffsetMismatchError if offset isn't how much has been received, and
+        ValueError if the chunks go past the upload's size.
+        """
+        lock = self._locks.setdefault(upload_id, asyncio.Lock())
+        async with lock:
+            metadata, path = self._load(upload_id)
+            current = self.status(upload_id)["offset"]
+            if offset != current:
+                raise OffsetMismatchError(current)
+            try:
+                f = path.open("ab")
+            except FileNotFoundError as e:
+                raise KeyError(upload_id) from e
+            with f:
+                async for chunk in chunks:
+                    if f.tell() + len(chunk) > metadata["size"]:
+                        # Keep what fits, so the client can resume from there
+                        f.write(chunk[: metadata["size"] - f.tell()])
+                        raise ValueError(
+                            f"upload is larger than its size of {metadata['size']} bytes"
+                        )
+                    f.write(chunk)
+            return self.status(upload_id)
+
+    def delete(self, upload_id: str) -> None:
+        self._load(upload_id)
+        shutil.rmtree(self.directory / upload_id, ignore_errors=True)
+        self._locks.pop(upload_id, None)
+
+    def prune(self) -> None:
+        """Removes uploads that haven't been written to for longer than the TTL."""
+        if not self.directory.is_dir():
+            return
+        cutoff = time.time() - self.ttl
+        for path in self.directory.iterdir():
+            try:
+                if path.stat().st_mtime < cutoff and all(
+                    f.stat().st_mtime < cutoff for f in path.iterdir()
+                ):
+                    shutil.rmtree(path, ignore_errors=True)
+                    self._locks.pop(path.name, None)
+            except OSError:
+                continue
+
+    def _load(self, upload_id: str) -> Tuple[Dict[str, Any], Path]:
+        if not _ID_PATTERN.match(upload_id):
+            raise KeyError(upload_id)
+        try:
+            metadata = json.loads((self.directory / upload_id / _METADATA).read_text())
+        except (OSError, ValueError) as e:
+            raise KeyError(upload_id) from e
+        return metadata, self.directory / upload_id / metadata["filename"]
+
+
+def resolve_upload(url: str) -> Tuple[Path, int]:
+    """
+    Returns the path and size of the finished upload at url. Raises ValueError
+    if there isn't one, or it hasn't been finished.
+    """
+    upload_id = url[len(UPLOAD_SCHEME) + 1 :]
+    uploads = Uploads(upload_dir())
+    try:
+        status = uploads.status(upload_id)
+    except KeyError:
+        raise ValueError(f"there's no upload at {url}") from None
+    if not status["complete"]:
+        raise ValueError(
+            f"the upload at {url} hasn't finished: {status['offset']} of {status['size']} bytes have been sent"
+        )
+    return uploads.directory / upload_id / status["filename"], status["size"]
+
+
+def _safe_filename(filename: Optional[str]) -> str:
+    name = os.path.basename(filename or "").replace("\0", "_")
+    if not name or name.startswith("."):
+        name = "file" + name
+    return name[-200:]
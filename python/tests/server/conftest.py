@@ -121,6 +121,7 @@ def make_client(
     fixture_name: str,
     upload_url: Optional[str] = None,
     additional_config: Optional[dict] = None,
+    cache_dir: Optional[str] = None,
 ):
     """
     Creates a fastapi test client for an app that uses the requested Predictor.
@@ -134,6 +135,7 @@ def make_client(
         cog_config=Config(config=config),
         shutdown_event=threading.Event(),
         upload_url=upload_url,
+        cache_dir=cache_dir,
     )
     return TestClient(app)
 
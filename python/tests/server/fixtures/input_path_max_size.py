@@ -0,0 +1,7 @@
+from cog import BasePredictor, Input, Path
+
+
+class Predictor(BasePredictor):
+    def predict(self, path: Path = Input(max_size=8)) -> str:
+        with open(path) as fh:
+            return fh.read()
@@ -0,0 +1,6 @@
+from cog import BasePredictor, Table
+
+
+class Predictor(BasePredictor):
+    def predict(self, rows: Table, scale: float = 1.0) -> str:
+        return f"{type(rows).__name__} {rows.suffix} {rows.read_bytes().decode()} {scale}"
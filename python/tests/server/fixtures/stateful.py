@@ -0,0 +1,19 @@
+from cog import BasePredictor
+
+
+class Predictor(BasePredictor):
+    def setup(self):
+        self.totals = {}
+
+    def predict(self, n: int) -> int:
+        return n
+
+    def create_session(self, session_id: str, start: int = 0) -> None:
+        self.totals[session_id] = start
+
+    def step(self, session_id: str, n: int) -> int:
+        self.totals[session_id] += n
+        return self.totals[session_id]
+
+    def close_session(self, session_id: str) -> None:
+        del self.totals[session_id]
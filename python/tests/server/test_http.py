@@ -15,6 +15,7 @@ from .conftest import (
     uses_predictor,
     uses_predictor_with_client_options,
     uses_trainer,
+    wait_for_setup,
 )
 
 
@@ -528,6 +529,43 @@ def test_prediction_idempotent_endpoint_conflict(client, match):
     assert resp2.status_code == 409
 
 
+@uses_predictor("sleep")
+def test_shutdown_drains_running_predictions(client):
+    resp = client.post(
+        "/predictions",
+        json={"input": {"sleep": 1}},
+        headers={"Prefer": "respond-async"},
+    )
+    assert resp.status_code == 202
+
+    resp = client.post("/shutdown", params={"drain_timeout": 10})
+    assert resp.status_code == 202
+    assert resp.json() == {"status": "DRAINING", "predictions_in_flight": 1}
+
+    resp = client.get("/health-check")
+    assert resp.json()["status"] == "DRAINING"
+    assert resp.json()["predictions_in_flight"] == 1
+
+    resp = client.post("/predictions", json={"input": {"sleep": 1}})
+    assert resp.status_code == 503
+
+    for _ in range(100):
+        if client.get("/health-check").json()["predictions_in_flight"] == 0:
+            break
+        time.sleep(0.1)
+    else:
+        pytest.fail("prediction didn't finish while draining")
+
+
+@uses_predictor("sleep")
+def test_shutdown_without_drain_timeout(client):
+    resp = client.post("/shutdown")
+    assert resp.status_code == 200
+
+    resp = client.post("/predictions", json={"input": {"sleep": 1}})
+    assert resp.status_code == 503
+
+
 # a basic end-to-end test for async predictions. if you're adding more
 # exhaustive tests of webhooks, consider adding them to test_runner.py
 @responses.activate
@@ -675,3 +713,68 @@ def test_weights_are_read_from_environment_variables(client, match):
     resp = client.post("/predictions")
     assert resp.status_code == 200
     assert resp.json() == match({"status": "succeeded", "output": "hello"})
+
+
+@uses_predictor("stateful")
+def test_sessions_keep_state_between_steps(client, match):
+    resp = client.get("/")
+    assert resp.json()["sessions_url"] == "/sessions"
+
+    resp = client.post("/sessions", json={"id": "abc", "input": {"start": 10}})
+    assert resp.status_code == 200
+    assert resp.json() == match({"id": "abc", "status": "succeeded"})
+
+    resp = client.post("/sessions/abc/step", json={"input": {"n": 1}})
+    assert resp.json() == match({"status": "succeeded", "output": 11})
+    resp = client.post("/sessions/abc/step", json={"input": {"n": 2}})
+    assert resp.json() == match({"status": "succeeded", "output": 13})
+
+    resp = client.delete("/sessions/abc")
+    assert resp.status_code == 200
+    resp = client.post("/sessions/abc/step", json={"input": {"n": 1}})
+    assert resp.status_code == 404
+
+
+@uses_predictor("stateful")
+def test_sessions_get_an_id_if_none_is_given(client):
+    resp = client.post("/sessions")
+    assert resp.status_code == 200
+    session_id = resp.json()["id"]
+    assert session_id
+
+    resp = client.post(f"/sessions/{session_id}/step", json={"input": {"n": 5}})
+    assert resp.json()["output"] == 5
+
+
+@uses_predictor("stateful")
+def test_openapi_specification_with_sessions(client):
+    schema = client.get("/openapi.json").json()
+    assert "/sessions" in schema["paths"]
+    assert "/sessions/{session_id}/step" in schema["paths"]
+    assert schema["components"]["schemas"]["StepInput"]["required"] == ["n"]
+    assert "session_id" not in schema["components"]["schemas"]["StepInput"]["properties"]
+
+
+@uses_predictor("hello_world")
+def test_sessions_are_only_served_by_stateful_predictors(client):
+    assert "sessions_url" not in client.get("/").json()
+    assert client.post("/sessions").status_code == 404
+
+
+def test_predictions_are_cached(tmp_path, match):
+    with make_client("input_string", cache_dir=str(tmp_path)) as client:
+        wait_for_setup(client)
+
+        resp = client.post("/predictions", json={"input": {"text": "baz"}})
+        assert resp.status_code == 200
+        assert resp.headers["X-Cog-Cache"] == "miss"
+        assert resp.json() == match({"output": "baz", "status": "succeeded"})
+        assert len(list(tmp_path.glob("*.json"))) == 1
+
+        resp = client.post("/predictions", json={"input": {"text": "baz"}})
+        assert resp.headers["X-Cog-Cache"] == "hit"
+        assert resp.json() == match({"output": "baz", "status": "succeeded"})
+
+        resp = client.post("/predictions", json={"input": {"text": "qux"}})
+        assert resp.headers["X-Cog-Cache"] == "miss"
+        assert len(list(tmp_path.glob("*.json"))) == 2
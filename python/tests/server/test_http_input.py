@@ -12,6 +12,7 @@ from cog import schema
 from cog.config import Config
 from cog.server.http import Health, create_app
 from cog.types import PYDANTIC_V2
+from cog.uploads import upload_dir
 
 from .conftest import _fixture_path, uses_predictor
 
@@ -180,6 +181,105 @@ def test_path_input_with_http_url(client, match):
     assert resp.json() == match({"output": "txt hello", "status": "succeeded"})
 
 
+@uses_predictor("input_path")
+def test_path_input_upload(client, match):
+    resp = client.post("/uploads", json={"filename": "notes.txt", "size": 6})
+    assert resp.status_code == 201
+    upload = resp.json()
+    assert upload["offset"] == 0
+
+    resp = client.patch(
+        f"/uploads/{upload['id']}", content=b"foo", headers={"Upload-Offset": "0"}
+    )
+    assert resp.json()["offset"] == 3
+
+    # Unfinished uploads can't be used
+    resp = client.post("/predictions", json={"input": {"path": upload["url"]}})
+    assert resp.status_code == 422
+
+    # A chunk for the wrong offset is rejected with where to resume from
+    resp = client.patch(
+        f"/uploads/{upload['id']}", content=b"bar", headers={"Upload-Offset": "0"}
+    )
+    assert resp.status_code == 409
+    assert resp.json()["offset"] == 3
+    assert client.get(f"/uploads/{upload['id']}").json()["offset"] == 3
+
+    resp = client.patch(
+        f"/uploads/{upload['id']}", content=b"bar", headers={"Upload-Offset": "3"}
+    )
+    assert resp.json()["complete"]
+
+    resp = client.post("/predictions", json={"input": {"path": upload["url"]}})
+    assert resp.json() == match({"output": "txt foobar", "status": "succeeded"})
+
+
+@uses_predictor("input_path_max_size")
+def test_path_input_upload_max_size(client):
+    resp = client.post("/uploads", json={"filename": "notes.txt", "size": 9})
+    assert resp.status_code == 413
+    assert "larger than the maximum of 8 bytes" in resp.json()["detail"]
+
+    resp = client.post("/uploads", json={"filename": "notes.txt", "size": 8})
+    assert resp.status_code == 201
+
+
+@uses_predictor("input_path")
+def test_path_input_upload_removed(client):
+    upload = client.post("/uploads", json={"filename": "notes.txt", "size": 6}).json()
+    upload_path = os.path.join(upload_dir(), upload["id"], "notes.txt")
+    os.remove(upload_path)
+
+    assert client.get(f"/uploads/{upload['id']}").status_code == 404
+    resp = client.patch(
+        f"/uploads/{upload['id']}", content=b"foo", headers={"Upload-Offset": "0"}
+    )
+    assert resp.status_code == 404
+
+
+@uses_predictor("input_table")
+def test_table_input_request_body(client, match):
+    resp = client.post(
+        "/predictions?scale=2.5",
+        content=b"PAR1",
+        headers={"Content-Type": "application/vnd.apache.parquet"},
+    )
+    assert resp.status_code == 200
+    assert resp.json() == match(
+        {"output": "Table .parquet PAR1 2.5", "status": "succeeded"}
+    )
+
+    resp = client.post(
+        "/predictions",
+        json={
+            "input": {
+                "rows": "data:application/vnd.apache.arrow.file;base64,"
+                + base64.b64encode(b"ARROW1").decode("utf-8")
+            }
+        },
+    )
+    assert resp.json() == match(
+        {"output": "Table .arrow ARROW1 1.0", "status": "succeeded"}
+    )
+
+    schema = client.get("/openapi.json").json()
+    content = schema["paths"]["/predictions"]["post"]["requestBody"]["content"]
+    assert "application/vnd.apache.parquet" in content
+    assert schema["components"]["schemas"]["Input"]["properties"]["rows"][
+        "x-cog-table"
+    ] == {}
+
+
+@uses_predictor("input_path")
+def test_table_request_body_without_table_input(client):
+    resp = client.post(
+        "/predictions",
+        content=b"PAR1",
+        headers={"Content-Type": "application/vnd.apache.parquet"},
+    )
+    assert resp.status_code == 422
+
+
 @uses_predictor("input_file")
 def test_file_bad_input(client):
     resp = client.post(
@@ -7,7 +7,7 @@ from unittest import mock
 import pytest
 
 from cog.schema import PredictionRequest, Status, WebhookEvent
-from cog.server.eventtypes import Done, Log
+from cog.server.eventtypes import Done, Log, PredictionMetric
 from cog.server.runner import (
     PredictionRunner,
     PredictTask,
@@ -71,7 +71,7 @@ class FakeWorker:
             if isinstance(event, Done):
                 self._setup_future.set_result(event)
 
-    def predict(self, payload, tag=None):
+    def predict(self, payload, tag=None, method="predict"):
         assert tag not in self._predict_futures or self._predict_futures[tag].done()
         self.last_prediction_payload = payload
         self._predict_futures[tag] = Future()
@@ -412,6 +412,20 @@ def test_prediction_runner_predict_e2e():
                 status=Status.SUCCEEDED,
             ),
         ),
+        (
+            [
+                tick,
+                PredictionMetric("setup_phase:import", 0.5),
+                PredictionMetric("setup_phase:setup", 1.5),
+                Done(),
+            ],
+            SetupResult(
+                started_at=1,
+                completed_at=2,
+                status=Status.SUCCEEDED,
+                phases={"import": 0.5, "setup": 1.5},
+            ),
+        ),
         (
             [
                 tick,
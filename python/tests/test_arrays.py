@@ -0,0 +1,54 @@
+import pickle
+
+import numpy as np
+import pydantic
+
+from cog import Array
+from cog.arrays import (
+    ARROW_MIME_TYPE,
+    NPY_MIME_TYPE,
+    convert_arrays,
+    negotiate_array_type,
+)
+from cog.files import upload_file
+from cog.types import PYDANTIC_V2
+
+
+def _output_schema(output_type):
+    model = pydantic.create_model("Output", output=(output_type, ...))
+    if PYDANTIC_V2:
+        return model.model_json_schema()["properties"]["output"]
+    return model.schema()["properties"]["output"]
+
+
+def test_array_schema_has_dtype_and_shape():
+    schema = _output_schema(Array["float32", None, 768])
+    assert schema["type"] == "string"
+    assert schema["format"] == "uri"
+    assert schema["x-cog-array"] == {"dtype": "float32", "shape": [None, 768]}
+
+    assert _output_schema(Array)["x-cog-array"] == {}
+
+
+def test_array_save_writes_npy():
+    array = Array["float32", 3].save(np.array([1, 2, 3], dtype=np.float32))
+    assert type(array) is Array
+    assert array.suffix == ".npy"
+    np.testing.assert_array_equal(np.load(array), [1, 2, 3])
+
+    # Arrays are sent from the worker to the server with pickle
+    assert type(pickle.loads(pickle.dumps(array))) is Array
+
+    with array.open("rb") as f:
+        assert upload_file(f).startswith(f"data:{NPY_MIME_TYPE};base64,")
+
+
+def test_negotiate_array_type():
+    assert negotiate_array_type(None) == NPY_MIME_TYPE
+    assert negotiate_array_type("application/json") == NPY_MIME_TYPE
+    assert negotiate_array_type(f"{NPY_MIME_TYPE}, {ARROW_MIME_TYPE};q=0.5") == NPY_MIME_TYPE
+
+
+def test_convert_arrays_leaves_npy():
+    output = {"embedding": Array.save([1.0, 2.0]), "text": "hello"}
+    assert convert_arrays(output, NPY_MIME_TYPE) is output
@@ -1,10 +1,11 @@
 import os
 import sys
-from typing import Optional
+from typing import Iterator, Optional
 from unittest.mock import patch
 
 from cog import File, Path
 from cog.predictor import (
+    get_session_types,
     get_weights_type,
     load_predictor_from_ref,
 )
@@ -32,6 +33,28 @@ def test_get_weights_type() -> None:
     assert get_weights_type(f) == File
 
 
+def test_get_session_types() -> None:
+    class Stateless:
+        def predict(self, n: int) -> int:
+            return n
+
+    assert get_session_types(Stateless()) is None
+
+    class Stateful:
+        def predict(self, n: int) -> int:
+            return n
+
+        def step(self, session_id: str, text: str, n: int = 1) -> Iterator[str]:
+            yield text * n
+
+    session_types = get_session_types(Stateful())
+    assert session_types is not None
+    assert session_types.methods == ["step"]
+    assert list(session_types.session_input.__fields__) == []
+    assert list(session_types.step_input.__fields__) == ["text", "n"]
+    assert session_types.step_input(text="a").n == 1
+
+
 def test_load_predictor_from_ref_overrides_argv():
     with patch("sys.argv", ["foo.py", "exec", "--giraffes=2", "--eat-cookies"]):
         predictor = load_predictor_from_ref(_fixture_path("argv_override"))
@@ -0,0 +1,37 @@
+import pickle
+
+import pytest
+
+from cog import Table
+
+pa = pytest.importorskip("pyarrow")
+
+
+def test_table_save_and_read():
+    rows = [{"text": "a", "score": 0.5}, {"text": "b", "score": 1.5}]
+    table = Table.save(rows)
+    assert type(table) is Table
+    assert table.suffix == ".arrow"
+    assert table.rows() == rows
+
+    # Tables are sent from the worker to the server with pickle
+    assert type(pickle.loads(pickle.dumps(table))) is Table
+
+
+def test_table_read_parquet_and_stream(tmp_path):
+    pytest.importorskip("pyarrow.parquet")
+    table = Table.save({"x": [1, 2, 3]}, format="parquet")
+    assert table.suffix == ".parquet"
+    assert table.read().column("x").to_pylist() == [1, 2, 3]
+
+    path = tmp_path / "rows.arrows"
+    data = pa.table({"x": [4, 5]})
+    with pa.OSFile(str(path), "wb") as sink:
+        with pa.ipc.new_stream(sink, data.schema) as writer:
+            writer.write_table(data)
+    assert Table(path).rows() == [{"x": 4}, {"x": 5}]
+
+
+def test_table_save_rejects_unknown_format():
+    with pytest.raises(ValueError):
+        Table.save({"x": [1]}, format="csv")
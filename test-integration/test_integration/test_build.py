@@ -279,7 +279,7 @@ def test_torch_1_13_0_base_image_fail(docker_image):
         cwd=project_dir,
         capture_output=True,
     )
-    assert build_process.returncode == 1
+    assert build_process.returncode == 3
 
 
 def test_torch_1_13_0_base_image_fail_explicit(docker_image):
@@ -463,7 +463,7 @@ def test_bad_dockerignore(docker_image):
         capture_output=True,
     )
 
-    assert build_process.returncode == 1
+    assert build_process.returncode == 3
     assert (
         "The .cog tmp path cannot be ignored by docker in .dockerignore"
         in build_process.stderr.decode()
@@ -498,3 +498,44 @@ def test_fast_build_with_local_image(docker_image):
     os.remove(weights_file)
 
     assert build_process.returncode == 0
+
+
+def test_build_cleanup_makes_image_smaller(tmpdir, docker_image):
+    with open(tmpdir / "predict.py", "w", encoding="utf-8") as f:
+        f.write(
+            """from cog import BasePredictor
+
+class Predictor(BasePredictor):
+    def predict(self) -> str:
+        return "hello"
+"""
+        )
+
+    def build(image, cleanup):
+        with open(tmpdir / "cog.yaml", "w", encoding="utf-8") as f:
+            f.write(
+                f"""build:
+  python_version: "3.12"
+  python_packages:
+    - numpy==1.26.4
+  cleanup: {cleanup}
+predict: predict.py:Predictor
+"""
+            )
+        subprocess.run(["cog", "build", "-t", image], cwd=tmpdir, check=True)
+        return int(
+            subprocess.run(
+                ["docker", "image", "inspect", "--format", "{{.Size}}", image],
+                capture_output=True,
+                check=True,
+            ).stdout
+        )
+
+    cleaned_image = docker_image + "-cleaned"
+    try:
+        size = build(docker_image, "[]")
+        cleaned_size = build(cleaned_image, "[pycache, tests]")
+    finally:
+        subprocess.run(["docker", "rmi", "-f", cleaned_image], check=False)
+    # numpy's tests and bytecode are deleted in the layer that installs them, so they aren't in the image
+    assert cleaned_size < size